@@ -136,6 +136,26 @@ func OpenDB(dbpath string) *vbolt.DB {
 		})
 	})
 
+	// Migration: one MilestonePerson row per existing milestone, mirroring
+	// Milestone.PersonId. GetPersonMilestonesTx reads the join rows, so a
+	// milestone without one would vanish from its person's page.
+	vbolt.ApplyDBProcess(dbConnection, "2026-1016-backfill-milestone-people", func() {
+		vbolt.WithWriteTx(dbConnection, func(tx *vbolt.Tx) {
+			backend.BackfillMilestonePeople(tx)
+			vbolt.TxCommit(tx)
+		})
+	})
+
+	// Migration: milestone categories were free text; this gives every
+	// family its category records and folds the stored values into them, so
+	// "Firsts" and "first" end up as one category.
+	vbolt.ApplyDBProcess(dbConnection, "2026-1016-milestone-categories", func() {
+		vbolt.WithWriteTx(dbConnection, func(tx *vbolt.Tx) {
+			backend.BackfillMilestoneCategories(tx)
+			vbolt.TxCommit(tx)
+		})
+	})
+
 	return dbConnection
 }
 
@@ -221,12 +241,25 @@ func MakeApplication() *vbeam.Application {
 	backend.RegisterPasswordResetMethods(app)
 	backend.RegisterFamilyLinkMethods(app)
 	backend.RegisterPersonMethods(app)
+	backend.RegisterFamilyTreeMethods(app)
+	backend.RegisterPersonMergeMethods(app)
+	backend.RegisterHealthMethods(app)
+	backend.RegisterDentalMethods(app)
+	backend.RegisterSchoolMethods(app)
 	backend.RegisterGrowthMethods(app)
+	backend.RegisterPrenatalMethods(app)
+	backend.RegisterChecklistMethods(app)
+	backend.RegisterMemoriesMethods(app)
+	backend.RegisterCommentMethods(app)
 	backend.RegisterMilestoneMethods(app)
+	backend.RegisterMilestoneCategoryMethods(app)
 	backend.RegisterActivityMethods(app)
 	backend.RegisterActivityResultMethods(app)
 	backend.RegisterActivityViewMethods(app)
 	backend.RegisterActivityPhotoMethods(app)
+	backend.RegisterAlbumMethods(app)
+	backend.RegisterDuplicateMethods(app)
+	backend.RegisterPhotoLocationMethods(app)
 	backend.RegisterTagMethods(app)
 	backend.RegisterChatMethods(app)
 	backend.RegisterPhotoMethods(app)
@@ -239,6 +272,7 @@ func MakeApplication() *vbeam.Application {
 	backend.RegisterUniversalLinkHandlers(app)
 	backend.RegisterPushNotificationMethods(app)
 	backend.RegisterNotificationPreferenceMethods(app)
+	backend.RegisterUserPreferenceMethods(app)
 	backend.RegisterMobileVersionMethods(app)
 
 	app.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -160,7 +160,8 @@ func CanAccessPerson(tx *vbolt.Tx, user User, person Person, scope LinkScope, ne
 }
 
 // CanAccessRecordOfPerson is the same question for a record hanging off a
-// person — a milestone, a measurement — which the person's family owns.
+// person — a measurement, an activity entry — which the person's family owns.
+// Milestones can be about several people and use CanAccessMilestone.
 func CanAccessRecordOfPerson(tx *vbolt.Tx, user User, recordFamilyId int, personId int, scope LinkScope, need AccessLevel) bool {
 	if CanAccessFamily(tx, user, recordFamilyId, need) {
 		return true
@@ -171,8 +172,9 @@ func CanAccessRecordOfPerson(tx *vbolt.Tx, user User, recordFamilyId int, person
 // CanAccessPhoto reports whether the user may see this photo. A photo is owned
 // by a family rather than by a person, so the link path asks whether anyone
 // tagged in it is a person shared into one of the user's families by a link
-// carrying photos. A photo of nobody in particular is therefore never reachable
-// through a link — only its own family sees it.
+// carrying photos. A photo of nobody in particular is reachable through a link
+// only by way of an album its family has shared (see album.go); otherwise only
+// its own family sees it.
 func CanAccessPhoto(tx *vbolt.Tx, user User, image Image, need AccessLevel) bool {
 	if image.Id == 0 {
 		return false
@@ -185,6 +187,25 @@ func CanAccessPhoto(tx *vbolt.Tx, user User, image Image, need AccessLevel) bool
 			return true
 		}
 	}
+	return photoSharedThroughAlbum(tx, user, image.Id, need)
+}
+
+// CanAccessMilestone is CanAccessPhoto for a milestone: its own family, or a
+// link carrying milestones for any one of the people it is about. A reader who
+// gets in through one child does not thereby see the others — that is
+// withMilestonePersonIds' job — but does see the milestone they share.
+func CanAccessMilestone(tx *vbolt.Tx, user User, milestone Milestone, need AccessLevel) bool {
+	if milestone.Id == 0 {
+		return false
+	}
+	if CanAccessFamily(tx, user, milestone.FamilyId, need) {
+		return true
+	}
+	for _, personId := range GetMilestonePersonIds(tx, milestone.Id) {
+		if canAccessPersonViaLink(tx, user, GetPersonById(tx, personId), ScopeMilestones, need) {
+			return true
+		}
+	}
 	return false
 }
 
@@ -3,7 +3,10 @@ package backend
 import (
 	"encoding/json"
 	"errors"
+	"family/cfg"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -116,6 +119,15 @@ func deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 	}
+	// A destroyed family's school documents all live in its own directory.
+	for _, familyId := range destroyedFamilies {
+		if err := os.RemoveAll(filepath.Join(cfg.StaticDir, schoolFamilyDir(familyId))); err != nil {
+			LogErrorSimple(LogCategoryAPI, "Failed to delete school documents during account deletion", map[string]interface{}{
+				"familyId": familyId,
+				"error":    err.Error(),
+			})
+		}
+	}
 
 	clearAuthCookies(w)
 
@@ -172,8 +184,11 @@ func deleteAccountTx(tx *vbolt.Tx, user User) (orphanedPhotos []Image, destroyed
 	}
 
 	deleteUserChatMessagesTx(tx, user.Id)
+	deleteUserCommentsTx(tx, user.Id)
 	deleteUserPushDeviceTokensTx(tx, user.Id)
 	deleteNotificationPreferencesTx(tx, user.Id)
+	deleteMemoriesDigestLogTx(tx, user.Id)
+	deleteUserPreferencesTx(tx, user.Id)
 	DeleteUserRefreshTokens(tx, user.Id)
 	deleteUserPasswordResetTokensTx(tx, user.Id)
 
@@ -242,6 +257,10 @@ func deleteFamilyContentTx(tx *vbolt.Tx, familyId int) (photos []Image) {
 		_ = DeleteGrowthDataTx(tx, growth.Id, familyId)
 	}
 
+	for _, measurement := range getFamilyPrenatalMeasurements(tx, familyId) {
+		deletePrenatalMeasurementTx(tx, measurement)
+	}
+
 	for _, message := range GetFamilyChatMessages(tx, familyId, 0, 0) {
 		_ = DeleteChatMessageTx(tx, message.Id, familyId)
 	}
@@ -251,6 +270,14 @@ func deleteFamilyContentTx(tx *vbolt.Tx, familyId int) (photos []Image) {
 	}
 
 	deleteFamilyActivitiesTx(tx, familyId)
+	deleteFamilyChecklistTx(tx, familyId)
+	deleteFamilyCommentsTx(tx, familyId)
+	deleteFamilyMilestoneCategoriesTx(tx, familyId)
+	deleteFamilyPersonMergesTx(tx, familyId)
+	deleteFamilyHealthTx(tx, familyId)
+	deleteFamilyDentalTx(tx, familyId)
+	deleteFamilySchoolTx(tx, familyId)
+	deleteFamilyAlbumsTx(tx, familyId)
 
 	// The family's own people, and with them the face descriptors derived from
 	// their photos.
@@ -278,11 +305,12 @@ func deleteFamilyContentTx(tx *vbolt.Tx, familyId int) (photos []Image) {
 }
 
 // deletePersonRecordTx removes a person, their roster rows, any photo tag still
-// pointing at them, and their place on any activity roster. Those joins are
-// normally gone already — the family's photos and activities were deleted first
-// — but a person can be tagged in a photo, or rostered in a routine, owned by a
-// family that is not being deleted, and those rows must not outlive the person
-// they name.
+// pointing at them, their family-tree edges, and their place on any activity
+// roster. Those joins are normally gone already — the family's photos and
+// activities were deleted first — but a person can be tagged in a photo, or
+// rostered in a routine, owned by a family that is not being deleted, or be
+// the parent of someone in another household, and those rows must not outlive
+// the person they name.
 func deletePersonRecordTx(tx *vbolt.Tx, person Person) {
 	for _, photoPerson := range GetPhotoPersonsByPerson(tx, person.Id) {
 		vbolt.Delete(tx, PhotoPersonBkt, photoPerson.Id)
@@ -292,6 +320,7 @@ func deletePersonRecordTx(tx *vbolt.Tx, person Person) {
 	}
 
 	removePersonFromActivitiesTx(tx, person.Id)
+	deletePersonRelationsTx(tx, person.Id)
 	deletePersonRostersTx(tx, person.Id)
 	vbolt.Delete(tx, PeopleBkt, person.Id)
 	vbolt.SetTargetSingleTerm(tx, PersonIndex, person.Id, -1)
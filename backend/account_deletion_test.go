@@ -109,8 +109,11 @@ func setupDeletionFixture(t *testing.T) deletionFixture {
 		vbolt.Write(tx, ImagesBkt, fx.photo.Id, &fx.photo)
 		vbolt.SetTargetSingleTerm(tx, ImageByFamilyIndex, fx.photo.Id, fx.familyId)
 		tagPersonInPhoto(tx, fx.photo.Id, fx.person.Id, fx.familyId)
+		addGrowthPhotoTx(tx, fx.growth, fx.photo.Id)
 
 		seedFamilyActivities(tx, fx.familyId, fx.person.Id, fx.photo.Id)
+		seedFamilyChecklist(t, tx, fx.familyId, fx.person.Id)
+		ensureMilestoneCategoriesTx(tx, fx.familyId)
 
 		fx.message, err = AddChatMessageTx(tx, SendMessageRequest{
 			Content: "hello", ClientMessageId: "m-1",
@@ -119,6 +122,42 @@ func setupDeletionFixture(t *testing.T) deletionFixture {
 			t.Fatalf("AddChatMessageTx() error = %v", err)
 		}
 
+		AddCommentTx(tx, CommentTarget{Kind: CommentTargetMilestone, Id: fx.milestone.Id}, fx.familyId, fx.owner, "So proud")
+		AddCommentTx(tx, CommentTarget{Kind: CommentTargetPhoto, Id: fx.photo.Id}, fx.familyId, fx.owner, "Lovely")
+
+		// A family-tree edge reaching into a household that stays behind.
+		if _, err := AddPersonRelationTx(tx, RelationPartner, fx.person.Id, fx.outsiderPerson.Id, fx.familyId); err != nil {
+			t.Fatalf("AddPersonRelationTx() error = %v", err)
+		}
+		ToggleReactionTx(tx, CommentTarget{Kind: CommentTargetPhoto, Id: fx.photo.Id}, fx.familyId, fx.owner.Id, "❤️")
+
+		// A merge journal keeps a copy of the person it deleted.
+		takeMergeSnapshot(tx, fx.person, fx.person.Id).recordTx(tx, fx.familyId, fx.owner.Id)
+
+		vaccination := Vaccination{PersonId: fx.person.Id, FamilyId: fx.familyId, Vaccine: "mmr", Dose: 1, Date: time.Now()}
+		writeVaccinationTx(tx, &vaccination)
+		record := HealthRecord{PersonId: fx.person.Id, FamilyId: fx.familyId, Kind: HealthAllergy, Title: "Peanuts"}
+		writeHealthRecordTx(tx, &record)
+		tooth := ToothRecord{PersonId: fx.person.Id, FamilyId: fx.familyId, Tooth: "E", EruptedDate: time.Now()}
+		writeToothRecordTx(tx, &tooth)
+
+		schoolYear := SchoolYear{Id: vbolt.NextIntId(tx, SchoolYearBkt), PersonId: fx.person.Id, FamilyId: fx.familyId, Label: "2025-26"}
+		writeSchoolYearTx(tx, &schoolYear)
+		if _, err := setSchoolGradesTx(tx, schoolYear, []SchoolGradeInput{{Subject: "Reading", Mark: "A"}}); err != nil {
+			t.Fatalf("setSchoolGradesTx() error = %v", err)
+		}
+		if err := setSchoolYearPhotosTx(tx, schoolYear, []int{fx.photo.Id}); err != nil {
+			t.Fatalf("setSchoolYearPhotosTx() error = %v", err)
+		}
+		reportCard := SchoolDocument{Id: vbolt.NextIntId(tx, SchoolDocumentBkt), SchoolYearId: schoolYear.Id, FamilyId: fx.familyId, Title: "Report card"}
+		writeSchoolDocumentTx(tx, &reportCard)
+
+		album := Album{Id: vbolt.NextIntId(tx, AlbumBkt), FamilyId: fx.familyId, PersonId: fx.person.Id, Title: "First year", CoverPhotoId: fx.photo.Id}
+		writeAlbumTx(tx, &album)
+		if _, err := addAlbumPhotosTx(tx, album, []int{fx.photo.Id}); err != nil {
+			t.Fatalf("addAlbumPhotosTx() error = %v", err)
+		}
+
 		fx.device = strings.Repeat("c", apnsDeviceTokenHexLength)
 		if _, err := upsertPushDeviceToken(tx, fx.owner.Id, RegisterPushDeviceRequest{
 			Token: fx.device, Platform: "ios", Environment: "sandbox", BundleId: "com.example.family",
@@ -130,6 +169,10 @@ func setupDeletionFixture(t *testing.T) deletionFixture {
 			UserId: fx.owner.Id, ChatEnabled: true, ShowMessageText: true, UpdatedAt: time.Now(),
 		}
 		vbolt.Write(tx, NotificationPreferencesBkt, ownerPrefs.UserId, &ownerPrefs)
+		ownerDigest := MemoriesDigestLog{UserId: fx.owner.Id, Day: time.Now().Format("2006-01-02"), SentAt: time.Now()}
+		vbolt.Write(tx, MemoriesDigestLogBkt, ownerDigest.UserId, &ownerDigest)
+		ownerDisplay := UserPreferences{UserId: fx.owner.Id, UnitSystem: UnitSystemMetric, DateFormat: DateFormatISO}
+		vbolt.Write(tx, UserPreferencesBkt, ownerDisplay.UserId, &ownerDisplay)
 
 		if _, err := createPasswordResetTokenTx(tx, fx.owner.Id, time.Now()); err != nil {
 			t.Fatalf("createPasswordResetTokenTx() error = %v", err)
@@ -319,13 +362,29 @@ func TestDeleteAccountClearsEveryStore(t *testing.T) {
 		"images":                   countRows(t, fx.db, ImagesBkt),
 		"photo_person":             countRows(t, fx.db, PhotoPersonBkt),
 		"growth":                   countRows(t, fx.db, GrowthDataBkt),
+		"growth photos":            countRows(t, fx.db, GrowthPhotoBkt),
+		"checklist items":          countRows(t, fx.db, FamilyChecklistItemBkt),
+		"checklist hidden items":   countRows(t, fx.db, ChecklistHiddenItemBkt),
+		"checklist achievements":   countRows(t, fx.db, ChecklistAchievementBkt),
 		"milestones":               countRows(t, fx.db, MilestoneBkt),
+		"milestone people":         countRows(t, fx.db, MilestonePersonBkt),
 		"tags":                     countRows(t, fx.db, TagBkt),
+		"milestone categories":     countRows(t, fx.db, MilestoneCategoryBkt),
+		"person relations":         countRows(t, fx.db, PersonRelationBkt),
+		"person merges":            countRows(t, fx.db, PersonMergeBkt),
+		"person merge items":       countRows(t, fx.db, PersonMergeItemBkt),
+		"vaccinations":             countRows(t, fx.db, VaccinationBkt),
+		"health records":           countRows(t, fx.db, HealthRecordBkt),
+		"tooth records":            countRows(t, fx.db, ToothRecordBkt),
 		"chat":                     countRows(t, fx.db, ChatMessagesBkt),
+		"comments":                 countRows(t, fx.db, CommentBkt),
+		"reactions":                countRows(t, fx.db, ReactionBkt),
 		"refresh tokens":           countRows(t, fx.db, RefreshTokenBkt),
 		"reset tokens":             countRows(t, fx.db, PasswordResetBkt),
 		"device tokens":            countRows(t, fx.db, PushDeviceTokenBkt),
 		"notification preferences": countRows(t, fx.db, NotificationPreferencesBkt),
+		"memories digest log":      countRows(t, fx.db, MemoriesDigestLogBkt),
+		"user preferences":         countRows(t, fx.db, UserPreferencesBkt),
 
 		"activities":        countRows(t, fx.db, ActivityBkt),
 		"seasons":           countRows(t, fx.db, SeasonBkt),
@@ -336,6 +395,14 @@ func TestDeleteAccountClearsEveryStore(t *testing.T) {
 		"activity results":  countRows(t, fx.db, ResultBkt),
 		"appearance photos": countRows(t, fx.db, AppearancePhotoBkt),
 		"event photos":      countRows(t, fx.db, EventPhotoBkt),
+
+		"school years":       countRows(t, fx.db, SchoolYearBkt),
+		"school grades":      countRows(t, fx.db, SchoolGradeBkt),
+		"school year photos": countRows(t, fx.db, SchoolYearPhotoBkt),
+		"school documents":   countRows(t, fx.db, SchoolDocumentBkt),
+
+		"albums":       countRows(t, fx.db, AlbumBkt),
+		"album photos": countRows(t, fx.db, AlbumPhotoBkt),
 	} {
 		if got != 0 {
 			t.Errorf("%s remaining = %d, want 0", name, got)
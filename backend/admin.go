@@ -283,17 +283,28 @@ func isPhotoProcessed(photo Image) bool {
 
 // Helper function to reprocess a single photo
 func reprocessSinglePhoto(photo Image) error {
-	// Read the original file
-	originalPath := getOriginalPhotoPath(photo)
-	originalData, err := os.ReadFile(originalPath)
-	if err != nil {
-		return fmt.Errorf("failed to read original file: %w", err)
-	}
+	var processedImages map[string][]byte
+	if photo.MediaKind == MediaKindVideo {
+		// A clip's original is not an image; its variants come from its
+		// poster frame, which may legitimately not exist.
+		processedImages, _, _ = processVideoPoster(PhotoProcessingJob{
+			ImageId:  photo.Id,
+			FilePath: photo.FilePath,
+			Duration: time.Duration(photo.DurationMs) * time.Millisecond,
+		})
+	} else {
+		// Read the original file
+		originalPath := getOriginalPhotoPath(photo)
+		originalData, err := os.ReadFile(originalPath)
+		if err != nil {
+			return fmt.Errorf("failed to read original file: %w", err)
+		}
 
-	// Reprocess with modern formats and sizes
-	processedImages, _, _, err := ProcessAndSaveMultipleSizes(originalData, photo.MimeType)
-	if err != nil {
-		return fmt.Errorf("failed to process image: %w", err)
+		// Reprocess with modern formats and sizes
+		processedImages, _, _, err = ProcessAndSaveMultipleSizes(originalData, photo.MimeType)
+		if err != nil {
+			return fmt.Errorf("failed to process image: %w", err)
+		}
 	}
 
 	// Save all variants to disk
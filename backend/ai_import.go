@@ -33,6 +33,9 @@ type AIImportDataStructure struct {
 	TotalHeights    int               `json:"total_heights"`
 	TotalWeights    int               `json:"total_weights"`
 	TotalMilestones int               `json:"total_milestones"`
+
+	HeadCircumferences      []ImportHeadCircumference `json:"head_circumferences,omitempty"`
+	TotalHeadCircumferences int                       `json:"total_head_circumferences,omitempty"`
 }
 
 type ProcessAIImportResponse struct {
@@ -102,7 +105,7 @@ func ProcessAIImport(ctx *vbeam.Context, req ProcessAIImportRequest) (resp Proce
 	}
 
 	// Format person context for the AI
-	personContext := formatPersonContext(person)
+	personContext := formatPersonContext(person, loadUserPreferences(ctx.Tx, user.Id))
 
 	// Get default prompt with person context and current date
 	currentDate := time.Now().Format("2006-01-02")
@@ -182,7 +185,8 @@ func ProcessAIImport(ctx *vbeam.Context, req ProcessAIImportRequest) (resp Proce
 	}
 
 	// Basic data validation
-	if len(aiImportData.Heights) == 0 && len(aiImportData.Weights) == 0 && len(aiImportData.Milestones) == 0 {
+	if len(aiImportData.Heights) == 0 && len(aiImportData.Weights) == 0 &&
+		len(aiImportData.HeadCircumferences) == 0 && len(aiImportData.Milestones) == 0 {
 		resp.ValidationWarnings = append(resp.ValidationWarnings, "No data extracted from text")
 	}
 
@@ -235,6 +239,7 @@ func ProcessAIImport(ctx *vbeam.Context, req ProcessAIImportRequest) (resp Proce
 		"tokensUsed":      resp.TokensUsed,
 		"heightsCount":    len(aiImportData.Heights),
 		"weightsCount":    len(aiImportData.Weights),
+		"headCircCount":   len(aiImportData.HeadCircumferences),
 		"milestonesCount": len(aiImportData.Milestones),
 		"fileSaved":       req.GenerateFile,
 	})
@@ -281,9 +286,11 @@ func ReadTextFile(reader io.Reader, maxSize int64) (string, error) {
 	return string(content), nil
 }
 
-// formatPersonContext formats a single person's information into a context string for the AI
-func formatPersonContext(person Person) string {
-	return fmt.Sprintf(`PERSON CONTEXT:
+// formatPersonContext formats a single person's information into a context string for the AI.
+// The user's preferences go along with it: pasted notes rarely say which units
+// a bare "42" is in, and 03/04/2024 is a different day depending on who wrote it.
+func formatPersonContext(person Person, prefs UserPreferences) string {
+	context := fmt.Sprintf(`PERSON CONTEXT:
 - Person ID: %d
 - Name: "%s"
 - Birthday: %s
@@ -301,6 +308,19 @@ func formatPersonContext(person Person) string {
 		person.Gender,
 		person.FamilyId,
 	)
+
+	switch prefs.UnitSystem {
+	case UnitSystemMetric:
+		context += "\n- The user measures in metric: a length or weight with no unit is most likely in centimeters or kilograms"
+	case UnitSystemImperial:
+		context += "\n- The user measures in imperial: a length or weight with no unit is most likely in inches or pounds"
+	}
+	switch prefs.DateFormat {
+	case DateFormatUS, DateFormatEU:
+		context += fmt.Sprintf("\n- The user writes dates as %s: read numeric dates such as %s that way",
+			dateFormatPatterns[prefs.DateFormat], prefs.formatDate(time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)))
+	}
+	return context
 }
 
 // formatPersonType returns human-readable person type
@@ -339,15 +359,17 @@ func convertToImportDataStructure(person Person, aiData AIImportDataStructure) I
 
 	// Build full import structure
 	return ImportDataStructure{
-		People:          []ImportPerson{importPerson},
-		Heights:         aiData.Heights,
-		Weights:         aiData.Weights,
-		Milestones:      aiData.Milestones,
-		ExportDate:      time.Now(),
-		TotalHeights:    aiData.TotalHeights,
-		TotalWeights:    aiData.TotalWeights,
-		TotalPeople:     1,
-		TotalMilestones: aiData.TotalMilestones,
+		People:                  []ImportPerson{importPerson},
+		Heights:                 aiData.Heights,
+		Weights:                 aiData.Weights,
+		HeadCircumferences:      aiData.HeadCircumferences,
+		Milestones:              aiData.Milestones,
+		ExportDate:              time.Now(),
+		TotalHeights:            aiData.TotalHeights,
+		TotalWeights:            aiData.TotalWeights,
+		TotalHeadCircumferences: aiData.TotalHeadCircumferences,
+		TotalPeople:             1,
+		TotalMilestones:         aiData.TotalMilestones,
 	}
 }
 
@@ -0,0 +1,363 @@
+// Albums: named, ordered selections from a family's photo library.
+//
+// The library stays the one place a photo lives. An album is a title, a
+// description and a list of joins pointing into it, so putting a photo in
+// three albums stores it once, and deleting an album never deletes a photo.
+//
+// Order is a Position on each join rather than the join ids ascending, which
+// is how the activity and school photo lists keep theirs. Those lists are
+// short and replaced whole; an album runs to hundreds of photos and is added
+// to a few at a time, so appending one photo should write one row rather than
+// rewrite the album. Positions only need to be increasing — removing a photo
+// leaves a gap and nothing renumbers it.
+//
+// An album may be about one person (PersonId) or about nobody in particular.
+// That person is what a link reaches it through: a linked family sees an
+// album only once the owning family has marked it Shared, and only if the
+// person is shared with them under ScopePhotos. A family album has no person,
+// so it can never be shared — the same rule CanAccessPhoto applies to a photo
+// of nobody in particular.
+package backend
+
+import (
+	"errors"
+	"family/cfg"
+	"sort"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+type Album struct {
+	Id           int       `json:"id"`
+	FamilyId     int       `json:"familyId"`
+	PersonId     int       `json:"personId"` // 0 for a family album
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	CoverPhotoId int       `json:"coverPhotoId"` // 0 means the first photo
+	Shared       bool      `json:"shared"`
+	CreatedBy    int       `json:"createdBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// AlbumPhoto places one library photo in one album.
+type AlbumPhoto struct {
+	Id       int       `json:"id"`
+	AlbumId  int       `json:"albumId"`
+	PhotoId  int       `json:"photoId"`
+	FamilyId int       `json:"familyId"`
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+var (
+	ErrAlbumNotFound      = errors.New("Album not found")
+	ErrAlbumTitleRequired = errors.New("An album needs a title")
+	ErrAlbumFull          = errors.New("That is more photos than one album can hold")
+	ErrAlbumNeedsPerson   = errors.New("Only an album about one person can be shared. Choose whose album this is first.")
+	ErrAlbumOrderStale    = errors.New("The album has changed since it was loaded. Reload it and try again.")
+	ErrCoverNotInAlbum    = errors.New("The cover has to be one of the album's photos")
+)
+
+// maxAlbumPhotos bounds one album. It is well past a year of one child's
+// photos; a family wanting more is better served by a second album than by a
+// reorder request carrying every id at once.
+const maxAlbumPhotos = 2000
+
+// ── packing ───────────────────────────────────────────────────────────────────
+
+func PackAlbum(self *Album, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.String(&self.Title, buf)
+	vpack.String(&self.Description, buf)
+	vpack.Int(&self.CoverPhotoId, buf)
+	vpack.Bool(&self.Shared, buf)
+	vpack.Int(&self.CreatedBy, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+func PackAlbumPhoto(self *AlbumPhoto, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.AlbumId, buf)
+	vpack.Int(&self.PhotoId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Int(&self.Position, buf)
+	vpack.Time(&self.AddedAt, buf)
+}
+
+// ── buckets and indexes ───────────────────────────────────────────────────────
+
+var AlbumBkt = vbolt.Bucket(&cfg.Info, "albums", vpack.FInt, PackAlbum)
+var AlbumPhotoBkt = vbolt.Bucket(&cfg.Info, "album_photos", vpack.FInt, PackAlbumPhoto)
+
+// AlbumByFamilyIndex: term = family_id, target = album_id
+var AlbumByFamilyIndex = vbolt.Index(&cfg.Info, "album_by_family", vpack.FInt, vpack.FInt)
+
+// AlbumByPersonIndex: term = person_id, target = album_id. Family albums have
+// no entry.
+var AlbumByPersonIndex = vbolt.Index(&cfg.Info, "album_by_person", vpack.FInt, vpack.FInt)
+
+// AlbumPhotoBy*: the by-photo index is what lets deleting a photo clear its
+// joins, and what CanAccessPhoto reads to find the albums a photo is in.
+var AlbumPhotoByAlbumIndex = vbolt.Index(&cfg.Info, "album_photo_by_album", vpack.FInt, vpack.FInt)
+var AlbumPhotoByPhotoIndex = vbolt.Index(&cfg.Info, "album_photo_by_photo", vpack.FInt, vpack.FInt)
+var AlbumPhotoByFamilyIndex = vbolt.Index(&cfg.Info, "album_photo_by_family", vpack.FInt, vpack.FInt)
+
+// ── reads ─────────────────────────────────────────────────────────────────────
+
+func GetAlbumById(tx *vbolt.Tx, id int) (album Album) {
+	vbolt.Read(tx, AlbumBkt, id, &album)
+	return
+}
+
+func GetFamilyAlbums(tx *vbolt.Tx, familyId int) []Album {
+	return readByTerm(tx, AlbumByFamilyIndex, AlbumBkt, familyId)
+}
+
+func GetPersonAlbums(tx *vbolt.Tx, personId int) []Album {
+	return readByTerm(tx, AlbumByPersonIndex, AlbumBkt, personId)
+}
+
+// GetAlbumPhotoJoins returns the album's joins in album order.
+func GetAlbumPhotoJoins(tx *vbolt.Tx, albumId int) []AlbumPhoto {
+	joins := readByTerm(tx, AlbumPhotoByAlbumIndex, AlbumPhotoBkt, albumId)
+	sort.SliceStable(joins, func(i, j int) bool {
+		if joins[i].Position != joins[j].Position {
+			return joins[i].Position < joins[j].Position
+		}
+		return joins[i].Id < joins[j].Id
+	})
+	return joins
+}
+
+func GetPhotoAlbumJoins(tx *vbolt.Tx, photoId int) []AlbumPhoto {
+	return readByTerm(tx, AlbumPhotoByPhotoIndex, AlbumPhotoBkt, photoId)
+}
+
+func GetFamilyAlbumPhotos(tx *vbolt.Tx, familyId int) []AlbumPhoto {
+	return readByTerm(tx, AlbumPhotoByFamilyIndex, AlbumPhotoBkt, familyId)
+}
+
+// GetAlbumPhotoIds returns the album's photo ids in album order.
+func GetAlbumPhotoIds(tx *vbolt.Tx, albumId int) []int {
+	joins := GetAlbumPhotoJoins(tx, albumId)
+	photoIds := make([]int, 0, len(joins))
+	for _, join := range joins {
+		photoIds = append(photoIds, join.PhotoId)
+	}
+	return photoIds
+}
+
+// ── writes ────────────────────────────────────────────────────────────────────
+
+func writeAlbumTx(tx *vbolt.Tx, album *Album) {
+	vbolt.Write(tx, AlbumBkt, album.Id, album)
+	vbolt.SetTargetSingleTerm(tx, AlbumByFamilyIndex, album.Id, album.FamilyId)
+	personTerm := album.PersonId
+	if personTerm == 0 {
+		personTerm = -1
+	}
+	vbolt.SetTargetSingleTerm(tx, AlbumByPersonIndex, album.Id, personTerm)
+}
+
+func writeAlbumPhotoTx(tx *vbolt.Tx, join *AlbumPhoto) {
+	vbolt.Write(tx, AlbumPhotoBkt, join.Id, join)
+	vbolt.SetTargetSingleTerm(tx, AlbumPhotoByAlbumIndex, join.Id, join.AlbumId)
+	vbolt.SetTargetSingleTerm(tx, AlbumPhotoByPhotoIndex, join.Id, join.PhotoId)
+	vbolt.SetTargetSingleTerm(tx, AlbumPhotoByFamilyIndex, join.Id, join.FamilyId)
+}
+
+func deleteAlbumRowTx(tx *vbolt.Tx, id int) {
+	vbolt.Delete(tx, AlbumBkt, id)
+	vbolt.SetTargetSingleTerm(tx, AlbumByFamilyIndex, id, -1)
+	vbolt.SetTargetSingleTerm(tx, AlbumByPersonIndex, id, -1)
+}
+
+func deleteAlbumPhotoRowTx(tx *vbolt.Tx, id int) {
+	vbolt.Delete(tx, AlbumPhotoBkt, id)
+	vbolt.SetTargetSingleTerm(tx, AlbumPhotoByAlbumIndex, id, -1)
+	vbolt.SetTargetSingleTerm(tx, AlbumPhotoByPhotoIndex, id, -1)
+	vbolt.SetTargetSingleTerm(tx, AlbumPhotoByFamilyIndex, id, -1)
+}
+
+// addAlbumPhotosTx appends photos to the end of the album in the order given,
+// returning how many were new. A photo already in the album keeps its place,
+// so adding a batch twice is harmless. Every id is checked against the album's
+// family before anything is written, the same bar activity photos set.
+func addAlbumPhotosTx(tx *vbolt.Tx, album Album, photoIds []int) (added int, err error) {
+	ordered, err := resolveActivityPhotoIds(tx, photoIds, album.FamilyId)
+	if err != nil {
+		return 0, err
+	}
+	joins := GetAlbumPhotoJoins(tx, album.Id)
+	present := make(map[int]bool, len(joins))
+	next := 0
+	for _, join := range joins {
+		present[join.PhotoId] = true
+		next = max(next, join.Position+1)
+	}
+	var fresh []int
+	for _, photoId := range ordered {
+		if !present[photoId] {
+			fresh = append(fresh, photoId)
+		}
+	}
+	if len(joins)+len(fresh) > maxAlbumPhotos {
+		return 0, ErrAlbumFull
+	}
+
+	now := time.Now()
+	for i, photoId := range fresh {
+		join := AlbumPhoto{
+			Id:       vbolt.NextIntId(tx, AlbumPhotoBkt),
+			AlbumId:  album.Id,
+			PhotoId:  photoId,
+			FamilyId: album.FamilyId,
+			Position: next + i,
+			AddedAt:  now,
+		}
+		writeAlbumPhotoTx(tx, &join)
+	}
+	return len(fresh), nil
+}
+
+// removeAlbumPhotosTx takes photos out of the album, and off its cover if one
+// of them was it. The photos themselves stay in the library.
+func removeAlbumPhotosTx(tx *vbolt.Tx, album *Album, photoIds []int) (removed int) {
+	remove := make(map[int]bool, len(photoIds))
+	for _, photoId := range photoIds {
+		remove[photoId] = true
+	}
+	for _, join := range GetAlbumPhotoJoins(tx, album.Id) {
+		if remove[join.PhotoId] {
+			deleteAlbumPhotoRowTx(tx, join.Id)
+			removed++
+		}
+	}
+	if remove[album.CoverPhotoId] {
+		album.CoverPhotoId = 0
+		writeAlbumTx(tx, album)
+	}
+	return
+}
+
+// reorderAlbumPhotosTx puts the album's photos in the order given. The list
+// must name exactly the photos the album holds: a reorder computed from a
+// stale copy would otherwise quietly drop whatever someone else added in the
+// meantime, or put back what they removed.
+func reorderAlbumPhotosTx(tx *vbolt.Tx, album Album, photoIds []int) error {
+	joins := GetAlbumPhotoJoins(tx, album.Id)
+	if len(photoIds) != len(joins) {
+		return ErrAlbumOrderStale
+	}
+	byPhoto := make(map[int]AlbumPhoto, len(joins))
+	for _, join := range joins {
+		byPhoto[join.PhotoId] = join
+	}
+	reordered := make([]AlbumPhoto, 0, len(photoIds))
+	for _, photoId := range photoIds {
+		join, found := byPhoto[photoId]
+		if !found {
+			return ErrAlbumOrderStale
+		}
+		delete(byPhoto, photoId) // a repeated id is caught on its second use
+		reordered = append(reordered, join)
+	}
+	for position, join := range reordered {
+		if join.Position != position {
+			join.Position = position
+			writeAlbumPhotoTx(tx, &join)
+		}
+	}
+	return nil
+}
+
+// ── cascades ──────────────────────────────────────────────────────────────────
+
+// deleteAlbumTx deletes an album and its joins. Its photos stay in the library.
+func deleteAlbumTx(tx *vbolt.Tx, albumId int) {
+	for _, join := range GetAlbumPhotoJoins(tx, albumId) {
+		deleteAlbumPhotoRowTx(tx, join.Id)
+	}
+	deleteAlbumRowTx(tx, albumId)
+}
+
+// removePhotoFromAlbums clears a deleted photo's joins, and takes it off the
+// cover of any album it was the cover of. The albums themselves stay.
+func removePhotoFromAlbums(tx *vbolt.Tx, photoId int) {
+	for _, join := range GetPhotoAlbumJoins(tx, photoId) {
+		deleteAlbumPhotoRowTx(tx, join.Id)
+		album := GetAlbumById(tx, join.AlbumId)
+		if album.Id != 0 && album.CoverPhotoId == photoId {
+			album.CoverPhotoId = 0
+			writeAlbumTx(tx, &album)
+		}
+	}
+}
+
+// deleteFamilyAlbumsTx empties both buckets for one family, sweeping the
+// by-family indexes for the same reason deleteFamilySchoolTx does.
+func deleteFamilyAlbumsTx(tx *vbolt.Tx, familyId int) {
+	for _, join := range GetFamilyAlbumPhotos(tx, familyId) {
+		deleteAlbumPhotoRowTx(tx, join.Id)
+	}
+	for _, album := range GetFamilyAlbums(tx, familyId) {
+		deleteAlbumRowTx(tx, album.Id)
+	}
+}
+
+// moveAlbumsTx hands the source's albums to the target of a merge, returning
+// how many moved. The photos in them are the family's either way and do not
+// move.
+func moveAlbumsTx(tx *vbolt.Tx, sourceId int, targetId int) (moved int) {
+	for _, album := range GetPersonAlbums(tx, sourceId) {
+		album.PersonId = targetId
+		writeAlbumTx(tx, &album)
+		moved++
+	}
+	return
+}
+
+// ── access ────────────────────────────────────────────────────────────────────
+
+// canAccessAlbum allows the owning family, or — for an album its family has
+// shared — a user the album's person is shared with under ScopePhotos. Links
+// are read-only, so every write still comes down to membership.
+func canAccessAlbum(tx *vbolt.Tx, user User, album Album, need AccessLevel) bool {
+	if album.Id == 0 || album.FamilyId == 0 {
+		return false
+	}
+	if CanAccessFamily(tx, user, album.FamilyId, need) {
+		return true
+	}
+	return canAccessAlbumViaLink(tx, user, album, need)
+}
+
+func canAccessAlbumViaLink(tx *vbolt.Tx, user User, album Album, need AccessLevel) bool {
+	if !album.Shared || album.PersonId == 0 {
+		return false
+	}
+	person := GetPersonById(tx, album.PersonId)
+	if person.FamilyId != album.FamilyId {
+		return false
+	}
+	return canAccessPersonViaLink(tx, user, person, ScopePhotos, need)
+}
+
+// photoSharedThroughAlbum is the album half of CanAccessPhoto: a photo nobody
+// shared is tagged in is still visible to a linked family once it sits in an
+// album that has been shared with them. Sharing the album is the owning
+// family choosing to show those photos, so it needs no tag to back it.
+func photoSharedThroughAlbum(tx *vbolt.Tx, user User, photoId int, need AccessLevel) bool {
+	for _, join := range GetPhotoAlbumJoins(tx, photoId) {
+		if canAccessAlbumViaLink(tx, user, GetAlbumById(tx, join.AlbumId), need) {
+			return true
+		}
+	}
+	return false
+}
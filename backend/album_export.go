@@ -0,0 +1,125 @@
+// Exporting and importing a family's albums.
+//
+// An album travels as its fields and its photo ids in album order; the photos
+// themselves are already in the bundle, so importing one is a matter of
+// remapping ids. The JSON-only import path has no photos to remap against and
+// so does not offer albums at all — an album with none of its photos is only
+// a title.
+package backend
+
+import (
+	"slices"
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+// ExportAlbum carries PersonName for the same reason ExportMilestone does.
+// Shared is recorded so the file is a faithful copy, but an import never
+// restores it: the links it was shared over belong to the exporting family.
+type ExportAlbum struct {
+	Id           int       `json:"id"`
+	PersonId     int       `json:"personId,omitempty"`
+	PersonName   string    `json:"personName,omitempty"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description,omitempty"`
+	CoverPhotoId int       `json:"coverPhotoId,omitempty"`
+	Shared       bool      `json:"shared,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	PhotoIds     []int     `json:"photoIds"`
+}
+
+// AlbumImportCounts says what an import did.
+type AlbumImportCounts struct {
+	Albums int `json:"albums"`
+	Photos int `json:"photos"`
+	// Reused counts albums the family already had under the same title, which
+	// are left as they are rather than filled in twice.
+	Reused  int `json:"reused"`
+	Skipped int `json:"skipped"`
+}
+
+func buildAlbumExport(tx *vbolt.Tx, familyId int, personNames map[int]string) []ExportAlbum {
+	albums := GetFamilyAlbums(tx, familyId)
+	sortAlbumsNewestFirst(albums)
+	exported := make([]ExportAlbum, 0, len(albums))
+	for _, album := range albums {
+		exported = append(exported, ExportAlbum{
+			Id:           album.Id,
+			PersonId:     album.PersonId,
+			PersonName:   personNames[album.PersonId],
+			Title:        album.Title,
+			Description:  album.Description,
+			CoverPhotoId: album.CoverPhotoId,
+			Shared:       album.Shared,
+			CreatedAt:    album.CreatedAt,
+			PhotoIds:     GetAlbumPhotoIds(tx, album.Id),
+		})
+	}
+	return exported
+}
+
+// findAlbumByTitle returns the family's album with this title, if it has one.
+func findAlbumByTitle(tx *vbolt.Tx, familyId int, title string) (Album, bool) {
+	for _, album := range GetFamilyAlbums(tx, familyId) {
+		if album.Title == title {
+			return album, true
+		}
+	}
+	return Album{}, false
+}
+
+// importAlbums writes the bundle's albums into the family, with whichever of
+// their photos were imported, in their original order. An album about a
+// person who was not imported comes back as a family album rather than not at
+// all; its photos are still the family's.
+func importAlbums(
+	tx *vbolt.Tx,
+	albums []ExportAlbum,
+	familyId int,
+	ownerUserId int,
+	personIdMapping map[int]int,
+	photoIdMapping map[int]int,
+) (counts AlbumImportCounts, warnings []string) {
+	for _, source := range albums {
+		album := Album{FamilyId: familyId, CreatedBy: ownerUserId}
+		fields := AlbumFields{Title: source.Title, Description: source.Description, PersonId: personIdMapping[source.PersonId]}
+		if err := applyAlbumFields(tx, &album, fields); err != nil {
+			counts.Skipped++
+			warnings = append(warnings, "Skipped album "+source.Title+": "+err.Error())
+			continue
+		}
+		if _, found := findAlbumByTitle(tx, familyId, album.Title); found {
+			counts.Reused++
+			continue
+		}
+
+		album.Id = vbolt.NextIntId(tx, AlbumBkt)
+		album.CreatedAt = source.CreatedAt
+		if album.CreatedAt.IsZero() {
+			album.CreatedAt = time.Now()
+		}
+		writeAlbumTx(tx, &album)
+		counts.Albums++
+
+		var photoIds []int
+		attachPhotos(photoIdMapping, source.PhotoIds, func(photoId int) {
+			photoIds = append(photoIds, photoId)
+		})
+		// addAlbumPhotosTx takes a request-sized batch at a time.
+		for start := 0; start < len(photoIds); start += maxPhotosPerSubject {
+			batch := photoIds[start:min(start+maxPhotosPerSubject, len(photoIds))]
+			added, err := addAlbumPhotosTx(tx, album, batch)
+			if err != nil {
+				warnings = append(warnings, "Skipped some photos in album "+album.Title+": "+err.Error())
+				break
+			}
+			counts.Photos += added
+		}
+		if coverId, ok := photoIdMapping[source.CoverPhotoId]; ok && slices.Contains(GetAlbumPhotoIds(tx, album.Id), coverId) {
+			album.CoverPhotoId = coverId
+			writeAlbumTx(tx, &album)
+		}
+	}
+	return
+}
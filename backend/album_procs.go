@@ -0,0 +1,369 @@
+// Procs for albums: listing, one album with its photos, and the edits — the
+// album's own fields, adding, removing and reordering photos, and sharing.
+//
+// Reads go through canAccessAlbum. Every write asks for AccessContribute in
+// the album's family, which no link can grant, so a grandparent who can see a
+// shared album cannot rearrange it.
+package backend
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func RegisterAlbumMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, ListAlbums)
+	vbeam.RegisterProc(app, GetAlbum)
+	vbeam.RegisterProc(app, CreateAlbum)
+	vbeam.RegisterProc(app, UpdateAlbum)
+	vbeam.RegisterProc(app, AddAlbumPhotos)
+	vbeam.RegisterProc(app, RemoveAlbumPhotos)
+	vbeam.RegisterProc(app, ReorderAlbumPhotos)
+	vbeam.RegisterProc(app, ShareAlbum)
+	vbeam.RegisterProc(app, DeleteAlbum)
+}
+
+// ── request/response types ────────────────────────────────────────────────────
+
+// ListAlbumsRequest names a person to list the albums about them — which is
+// how a linked family finds the albums shared with it — or, with no person,
+// lists every album in the family (the primary one when FamilyId is 0).
+type ListAlbumsRequest struct {
+	FamilyId int `json:"familyId,omitempty"`
+	PersonId int `json:"personId,omitempty"`
+}
+
+// AlbumView is an album as a list shows it. CoverId is the photo to show on
+// it: the chosen cover, or the first photo when none was chosen.
+type AlbumView struct {
+	Album
+	CoverId    int `json:"coverId"`
+	PhotoCount int `json:"photoCount"`
+}
+
+type ListAlbumsResponse struct {
+	Albums []AlbumView `json:"albums"` // newest first
+}
+
+type AlbumIdRequest struct {
+	Id int `json:"id"`
+}
+
+// AlbumResponse is one album with its photo ids in album order, limited to
+// the ones the caller can fetch.
+type AlbumResponse struct {
+	Album    AlbumView `json:"album"`
+	PhotoIds []int     `json:"photoIds"`
+}
+
+// AlbumFields is the shared half of create and update.
+type AlbumFields struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	PersonId    int    `json:"personId"` // 0 for a family album
+}
+
+type CreateAlbumRequest struct {
+	FamilyId int `json:"familyId,omitempty"`
+	AlbumFields
+	PhotoIds []int `json:"photoIds,omitempty"`
+}
+
+type UpdateAlbumRequest struct {
+	Id int `json:"id"`
+	AlbumFields
+	CoverPhotoId int `json:"coverPhotoId"` // 0 to go back to the first photo
+}
+
+// AlbumPhotosRequest serves add, remove and reorder. For a reorder PhotoIds
+// is every photo in the album, in the new order.
+type AlbumPhotosRequest struct {
+	AlbumId  int   `json:"albumId"`
+	PhotoIds []int `json:"photoIds"`
+}
+
+type ShareAlbumRequest struct {
+	Id     int  `json:"id"`
+	Shared bool `json:"shared"`
+}
+
+// ── helpers ───────────────────────────────────────────────────────────────────
+
+func getAlbumForUser(tx *vbolt.Tx, id int, user User, need AccessLevel) (Album, error) {
+	album := GetAlbumById(tx, id)
+	if !canAccessAlbum(tx, user, album, need) {
+		return Album{}, ErrAlbumNotFound
+	}
+	return album, nil
+}
+
+func albumView(album Album, photoIds []int) AlbumView {
+	view := AlbumView{Album: album, CoverId: album.CoverPhotoId, PhotoCount: len(photoIds)}
+	if view.CoverId == 0 && len(photoIds) > 0 {
+		view.CoverId = photoIds[0]
+	}
+	return view
+}
+
+func albumResponse(tx *vbolt.Tx, user User, album Album) AlbumResponse {
+	photoIds := visiblePhotoIds(tx, user, GetAlbumPhotoIds(tx, album.Id))
+	return AlbumResponse{Album: albumView(album, photoIds), PhotoIds: photoIds}
+}
+
+// sortAlbumsNewestFirst puts the album made last at the top, which is the one
+// a family is most likely still adding to.
+func sortAlbumsNewestFirst(albums []Album) {
+	sort.SliceStable(albums, func(i, j int) bool {
+		if !albums[i].CreatedAt.Equal(albums[j].CreatedAt) {
+			return albums[i].CreatedAt.After(albums[j].CreatedAt)
+		}
+		return albums[i].Id > albums[j].Id
+	})
+}
+
+// applyAlbumFields validates an album's fields and copies them onto it. The
+// person, when there is one, must belong to the album's own family: that is
+// the family whose link shares them, and the one canAccessAlbumViaLink checks.
+func applyAlbumFields(tx *vbolt.Tx, album *Album, fields AlbumFields) error {
+	title := trimField(fields.Title, maxLabelLength)
+	if title == "" {
+		return ErrAlbumTitleRequired
+	}
+	if fields.PersonId != 0 {
+		person := GetPersonById(tx, fields.PersonId)
+		if person.Id == 0 || person.FamilyId != album.FamilyId {
+			return errors.New("Person not found or not in your family")
+		}
+	} else if album.Shared {
+		return ErrAlbumNeedsPerson
+	}
+
+	album.Title = title
+	album.Description = trimField(fields.Description, maxNotesLength)
+	album.PersonId = fields.PersonId
+	return nil
+}
+
+// ── procs ─────────────────────────────────────────────────────────────────────
+
+func ListAlbums(ctx *vbeam.Context, req ListAlbumsRequest) (resp ListAlbumsResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	var albums []Album
+	if req.PersonId != 0 {
+		person := GetPersonById(ctx.Tx, req.PersonId)
+		if !CanAccessPerson(ctx.Tx, user, person, ScopePhotos, AccessView) {
+			err = errors.New("Person not found or not in your family")
+			return
+		}
+		albums = GetPersonAlbums(ctx.Tx, person.Id)
+	} else {
+		familyId, resolveErr := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessView)
+		if resolveErr != nil {
+			err = resolveErr
+			return
+		}
+		albums = GetFamilyAlbums(ctx.Tx, familyId)
+	}
+
+	sortAlbumsNewestFirst(albums)
+	resp.Albums = make([]AlbumView, 0, len(albums))
+	for _, album := range albums {
+		if canAccessAlbum(ctx.Tx, user, album, AccessView) {
+			resp.Albums = append(resp.Albums, albumView(album, GetAlbumPhotoIds(ctx.Tx, album.Id)))
+		}
+	}
+	return
+}
+
+func GetAlbum(ctx *vbeam.Context, req AlbumIdRequest) (resp AlbumResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	album, err := getAlbumForUser(ctx.Tx, req.Id, user, AccessView)
+	if err != nil {
+		return
+	}
+	resp = albumResponse(ctx.Tx, user, album)
+	return
+}
+
+// CreateAlbum makes an album, optionally with its first photos. A new album
+// is never shared; that is a separate decision, made with ShareAlbum.
+func CreateAlbum(ctx *vbeam.Context, req CreateAlbumRequest) (resp AlbumResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessContribute)
+	if err != nil {
+		return
+	}
+	album := Album{FamilyId: familyId}
+	if err = applyAlbumFields(ctx.Tx, &album, req.AlbumFields); err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	album.Id = vbolt.NextIntId(ctx.Tx, AlbumBkt)
+	album.CreatedBy = user.Id
+	album.CreatedAt = time.Now()
+	writeAlbumTx(ctx.Tx, &album)
+	if _, err = addAlbumPhotosTx(ctx.Tx, album, req.PhotoIds); err != nil {
+		return
+	}
+	resp = albumResponse(ctx.Tx, user, album)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+func UpdateAlbum(ctx *vbeam.Context, req UpdateAlbumRequest) (resp AlbumResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	album, err := getAlbumForUser(ctx.Tx, req.Id, user, AccessContribute)
+	if err != nil {
+		return
+	}
+	if err = applyAlbumFields(ctx.Tx, &album, req.AlbumFields); err != nil {
+		return
+	}
+	if req.CoverPhotoId != 0 && !slices.Contains(GetAlbumPhotoIds(ctx.Tx, album.Id), req.CoverPhotoId) {
+		err = ErrCoverNotInAlbum
+		return
+	}
+	album.CoverPhotoId = req.CoverPhotoId
+
+	vbeam.UseWriteTx(ctx)
+	writeAlbumTx(ctx.Tx, &album)
+	resp = albumResponse(ctx.Tx, user, album)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+func AddAlbumPhotos(ctx *vbeam.Context, req AlbumPhotosRequest) (resp AlbumResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	album, err := getAlbumForUser(ctx.Tx, req.AlbumId, user, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	if _, err = addAlbumPhotosTx(ctx.Tx, album, req.PhotoIds); err != nil {
+		return
+	}
+	resp = albumResponse(ctx.Tx, user, album)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+func RemoveAlbumPhotos(ctx *vbeam.Context, req AlbumPhotosRequest) (resp AlbumResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	album, err := getAlbumForUser(ctx.Tx, req.AlbumId, user, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	removeAlbumPhotosTx(ctx.Tx, &album, req.PhotoIds)
+	resp = albumResponse(ctx.Tx, user, album)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+func ReorderAlbumPhotos(ctx *vbeam.Context, req AlbumPhotosRequest) (resp AlbumResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	album, err := getAlbumForUser(ctx.Tx, req.AlbumId, user, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	if err = reorderAlbumPhotosTx(ctx.Tx, album, req.PhotoIds); err != nil {
+		return
+	}
+	resp = albumResponse(ctx.Tx, user, album)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+// ShareAlbum opens an album about one person to the families that person is
+// shared with, or closes it again. What a linked family then sees is still
+// bounded by its link: without ScopePhotos for the person, sharing the album
+// shows them nothing.
+func ShareAlbum(ctx *vbeam.Context, req ShareAlbumRequest) (resp AlbumResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	album, err := getAlbumForUser(ctx.Tx, req.Id, user, AccessContribute)
+	if err != nil {
+		return
+	}
+	if req.Shared && album.PersonId == 0 {
+		err = ErrAlbumNeedsPerson
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	album.Shared = req.Shared
+	writeAlbumTx(ctx.Tx, &album)
+	resp = albumResponse(ctx.Tx, user, album)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+// DeleteAlbum deletes the album and its joins. Every photo in it stays in the
+// library.
+func DeleteAlbum(ctx *vbeam.Context, req AlbumIdRequest) (resp DeleteResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	album, err := getAlbumForUser(ctx.Tx, req.Id, user, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	deleteAlbumTx(ctx.Tx, album.Id)
+	vbolt.TxCommit(ctx.Tx)
+	resp.Success = true
+	return
+}
@@ -0,0 +1,286 @@
+package backend
+
+import (
+	"slices"
+	"testing"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func TestAlbumOrderingAndCover(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("album-test-secret-key-at-least-32-bytes")
+
+	var beach Image
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		beach = writeTestImage(tx, fx.famA, fx.userA.Id, "beach.jpg")
+		vbolt.TxCommit(tx)
+	})
+
+	var album AlbumView
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := CreateAlbum(ctx, CreateAlbumRequest{
+			AlbumFields: AlbumFields{Title: "  Summer  ", PersonId: fx.alice.Id},
+			PhotoIds:    []int{fx.alicePhoto.Id, fx.untaggedPhoto.Id, fx.alicePhoto.Id},
+		})
+		if err != nil {
+			t.Fatalf("CreateAlbum() error = %v", err)
+		}
+		album = resp.Album
+		if album.Title != "Summer" || album.FamilyId != fx.famA || album.Shared {
+			t.Errorf("created album = %+v", album)
+		}
+		if want := []int{fx.alicePhoto.Id, fx.untaggedPhoto.Id}; !slices.Equal(resp.PhotoIds, want) || album.CoverId != fx.alicePhoto.Id {
+			t.Errorf("photos = %v cover %d, want %v cover %d", resp.PhotoIds, album.CoverId, want, fx.alicePhoto.Id)
+		}
+	})
+
+	// Adding appends, and a photo already there keeps its place.
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := AddAlbumPhotos(ctx, AlbumPhotosRequest{AlbumId: album.Id, PhotoIds: []int{beach.Id, fx.alicePhoto.Id}})
+		if err != nil {
+			t.Fatalf("AddAlbumPhotos() error = %v", err)
+		}
+		if want := []int{fx.alicePhoto.Id, fx.untaggedPhoto.Id, beach.Id}; !slices.Equal(resp.PhotoIds, want) {
+			t.Errorf("after adding = %v, want %v", resp.PhotoIds, want)
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		order := []int{beach.Id, fx.alicePhoto.Id, fx.untaggedPhoto.Id}
+		resp, err := ReorderAlbumPhotos(ctx, AlbumPhotosRequest{AlbumId: album.Id, PhotoIds: order})
+		if err != nil || !slices.Equal(resp.PhotoIds, order) {
+			t.Fatalf("ReorderAlbumPhotos() = %v, %v", resp.PhotoIds, err)
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		for _, stale := range [][]int{
+			{beach.Id, fx.alicePhoto.Id},
+			{beach.Id, fx.alicePhoto.Id, fx.alicePhoto.Id},
+			{beach.Id, fx.alicePhoto.Id, 999999},
+		} {
+			if _, err := ReorderAlbumPhotos(ctx, AlbumPhotosRequest{AlbumId: album.Id, PhotoIds: stale}); err != ErrAlbumOrderStale {
+				t.Errorf("reorder to %v: error = %v, want ErrAlbumOrderStale", stale, err)
+			}
+		}
+
+		if _, err := UpdateAlbum(ctx, UpdateAlbumRequest{Id: album.Id, AlbumFields: AlbumFields{Title: "Summer"}, CoverPhotoId: 999999}); err != ErrCoverNotInAlbum {
+			t.Errorf("cover outside the album: error = %v", err)
+		}
+		resp, err := UpdateAlbum(ctx, UpdateAlbumRequest{Id: album.Id, AlbumFields: AlbumFields{Title: "Summer 2024", PersonId: fx.alice.Id}, CoverPhotoId: fx.untaggedPhoto.Id})
+		if err != nil || resp.Album.CoverId != fx.untaggedPhoto.Id {
+			t.Fatalf("UpdateAlbum() = %+v, %v", resp.Album, err)
+		}
+	})
+
+	// Deleting the cover photo clears its join and the cover, and leaves the
+	// rest of the album in order.
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		deletePhotoRecordTx(tx, fx.untaggedPhoto)
+		vbolt.TxCommit(tx)
+	})
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		stored := GetAlbumById(tx, album.Id)
+		if stored.CoverPhotoId != 0 {
+			t.Errorf("CoverPhotoId = %d after its photo was deleted", stored.CoverPhotoId)
+		}
+		if got, want := GetAlbumPhotoIds(tx, album.Id), []int{beach.Id, fx.alicePhoto.Id}; !slices.Equal(got, want) {
+			t.Errorf("album after the delete = %v, want %v", got, want)
+		}
+		if joins := GetPhotoAlbumJoins(tx, fx.untaggedPhoto.Id); len(joins) != 0 {
+			t.Errorf("the deleted photo kept %d album joins", len(joins))
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := DeleteAlbum(ctx, AlbumIdRequest{Id: album.Id}); err != nil {
+			t.Fatalf("DeleteAlbum() error = %v", err)
+		}
+	})
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if GetImageById(tx, beach.Id).Id == 0 {
+			t.Error("deleting the album deleted a photo in it")
+		}
+		if joins := GetFamilyAlbumPhotos(tx, fx.famA); len(joins) != 0 {
+			t.Errorf("%d joins outlived the album", len(joins))
+		}
+	})
+}
+
+// Grandparents see alice's album once it is shared, and with it a photo of
+// nobody in particular they could not otherwise reach. Family C, which holds
+// a link from B but not from A, sees nothing.
+func TestSharedAlbumThroughLink(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("album-test-secret-key-at-least-32-bytes")
+
+	var aliceAlbum, familyAlbum Album
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := CreateAlbum(ctx, CreateAlbumRequest{
+			AlbumFields: AlbumFields{Title: "Alice at the lake", PersonId: fx.alice.Id},
+			PhotoIds:    []int{fx.untaggedPhoto.Id, fx.alicePhoto.Id},
+		})
+		if err != nil {
+			t.Fatalf("CreateAlbum() error = %v", err)
+		}
+		aliceAlbum = resp.Album.Album
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := CreateAlbum(ctx, CreateAlbumRequest{AlbumFields: AlbumFields{Title: "House move"}, PhotoIds: []int{fx.untaggedPhoto.Id}})
+		if err != nil {
+			t.Fatalf("CreateAlbum() error = %v", err)
+		}
+		familyAlbum = resp.Album.Album
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := ShareAlbum(ctx, ShareAlbumRequest{Id: familyAlbum.Id, Shared: true}); err != ErrAlbumNeedsPerson {
+			t.Errorf("sharing a family album: error = %v", err)
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := GetAlbum(ctx, AlbumIdRequest{Id: aliceAlbum.Id}); err != ErrAlbumNotFound {
+			t.Errorf("an unshared album: error = %v", err)
+		}
+		if CanAccessPhoto(ctx.Tx, fx.userB, fx.untaggedPhoto, AccessView) {
+			t.Error("the untagged photo was visible before the album was shared")
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := ShareAlbum(ctx, ShareAlbumRequest{Id: aliceAlbum.Id, Shared: true}); err != nil {
+			t.Fatalf("ShareAlbum() error = %v", err)
+		}
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := UpdateAlbum(ctx, UpdateAlbumRequest{Id: aliceAlbum.Id, AlbumFields: AlbumFields{Title: "Alice at the lake"}}); err != ErrAlbumNeedsPerson {
+			t.Errorf("taking the person off a shared album: error = %v", err)
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		resp, err := GetAlbum(ctx, AlbumIdRequest{Id: aliceAlbum.Id})
+		if err != nil {
+			t.Fatalf("GetAlbum() error = %v", err)
+		}
+		if want := []int{fx.untaggedPhoto.Id, fx.alicePhoto.Id}; !slices.Equal(resp.PhotoIds, want) {
+			t.Errorf("shared album photos = %v, want %v", resp.PhotoIds, want)
+		}
+		if !CanAccessPhoto(ctx.Tx, fx.userB, fx.untaggedPhoto, AccessView) {
+			t.Error("a photo in a shared album is not visible through it")
+		}
+		if CanAccessPhoto(ctx.Tx, fx.userB, fx.untaggedPhoto, AccessContribute) {
+			t.Error("a shared album made a photo writable")
+		}
+
+		list, err := ListAlbums(ctx, ListAlbumsRequest{PersonId: fx.alice.Id})
+		if err != nil || len(list.Albums) != 1 || list.Albums[0].Id != aliceAlbum.Id {
+			t.Errorf("ListAlbums(alice) = %+v, %v", list.Albums, err)
+		}
+		if _, err := GetAlbum(ctx, AlbumIdRequest{Id: familyAlbum.Id}); err != ErrAlbumNotFound {
+			t.Errorf("the family album: error = %v", err)
+		}
+		if _, err := ReorderAlbumPhotos(ctx, AlbumPhotosRequest{AlbumId: aliceAlbum.Id, PhotoIds: []int{fx.alicePhoto.Id, fx.untaggedPhoto.Id}}); err != ErrAlbumNotFound {
+			t.Errorf("a linked family reordered the album: error = %v", err)
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userC, func(ctx *vbeam.Context) {
+		if _, err := GetAlbum(ctx, AlbumIdRequest{Id: aliceAlbum.Id}); err != ErrAlbumNotFound {
+			t.Errorf("a second-hop family: error = %v", err)
+		}
+		if CanAccessPhoto(ctx.Tx, fx.userC, fx.untaggedPhoto, AccessView) {
+			t.Error("a second-hop family reached a photo through the album")
+		}
+	})
+}
+
+func TestAlbumExportRoundTrip(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		album := Album{Id: vbolt.NextIntId(tx, AlbumBkt), FamilyId: fx.famA, PersonId: fx.alice.Id, Title: "Firsts", Shared: true}
+		writeAlbumTx(tx, &album)
+		if _, err := addAlbumPhotosTx(tx, album, []int{fx.untaggedPhoto.Id, fx.alicePhoto.Id}); err != nil {
+			t.Fatalf("addAlbumPhotosTx() error = %v", err)
+		}
+		album.CoverPhotoId = fx.alicePhoto.Id
+		writeAlbumTx(tx, &album)
+
+		exportData, err := buildExportData(tx, fx.famA)
+		if err != nil {
+			t.Fatalf("buildExportData() error = %v", err)
+		}
+		if exportData.TotalAlbums != 1 {
+			t.Fatalf("exported %d albums, want 1", exportData.TotalAlbums)
+		}
+		exported := exportData.Albums[0]
+		if exported.PersonName != "Alice" || !slices.Equal(exported.PhotoIds, []int{fx.untaggedPhoto.Id, fx.alicePhoto.Id}) {
+			t.Fatalf("exported album %+v", exported)
+		}
+
+		copyOfAlice, err := AddPersonTx(tx, AddPersonRequest{Name: "Alice", PersonType: int(Child), Gender: 1, Birthdate: "2018-04-01"}, fx.famB)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		landscape := writeTestImage(tx, fx.famB, fx.userB.Id, "landscape.jpg")
+		portrait := writeTestImage(tx, fx.famB, fx.userB.Id, "alice.jpg")
+		people := map[int]int{fx.alice.Id: copyOfAlice.Id}
+		photos := map[int]int{fx.untaggedPhoto.Id: landscape.Id, fx.alicePhoto.Id: portrait.Id}
+
+		counts, warnings := importAlbums(tx, exportData.Albums, fx.famB, fx.userB.Id, people, photos)
+		if counts.Albums != 1 || counts.Photos != 2 || len(warnings) != 0 {
+			t.Fatalf("first import = %+v, warnings %v", counts, warnings)
+		}
+		albums := GetPersonAlbums(tx, copyOfAlice.Id)
+		if len(albums) != 1 || albums[0].FamilyId != fx.famB || albums[0].CoverPhotoId != portrait.Id || albums[0].Shared {
+			t.Fatalf("imported albums %+v", albums)
+		}
+		if got := GetAlbumPhotoIds(tx, albums[0].Id); !slices.Equal(got, []int{landscape.Id, portrait.Id}) {
+			t.Errorf("imported order = %v", got)
+		}
+
+		if counts, _ := importAlbums(tx, exportData.Albums, fx.famB, fx.userB.Id, people, photos); counts.Albums != 0 || counts.Reused != 1 {
+			t.Errorf("second import = %+v", counts)
+		}
+	})
+}
+
+func TestMergeAndUnmergeAlbums(t *testing.T) {
+	fx := setupMergeFixture(t)
+	var album Album
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		album = Album{Id: vbolt.NextIntId(tx, AlbumBkt), FamilyId: fx.parent.FamilyId, PersonId: fx.duplicate.Id, Title: "Baby"}
+		writeAlbumTx(tx, &album)
+		vbolt.TxCommit(tx)
+	})
+
+	merged := fx.merge(t)
+	if merged.MergedAlbums != 1 {
+		t.Errorf("MergedAlbums = %d, want 1", merged.MergedAlbums)
+	}
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if albums := GetPersonAlbums(tx, fx.child.Id); len(albums) != 1 || albums[0].Id != album.Id {
+			t.Errorf("the target's albums after the merge: %+v", albums)
+		}
+	})
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		if _, err := UnmergePeople(ctx, UnmergePeopleRequest{MergeId: merged.MergeId}); err != nil {
+			t.Fatalf("UnmergePeople() error = %v", err)
+		}
+	})
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if albums := GetPersonAlbums(tx, fx.duplicate.Id); len(albums) != 1 || albums[0].Id != album.Id {
+			t.Errorf("the album did not come back: %+v", albums)
+		}
+		if albums := GetPersonAlbums(tx, fx.child.Id); len(albums) != 0 {
+			t.Errorf("the target kept %+v", albums)
+		}
+	})
+}
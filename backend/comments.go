@@ -0,0 +1,583 @@
+package backend
+
+import (
+	"errors"
+	"family/cfg"
+	"sort"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// Comments and reactions hang off a milestone or a photo. They are how the
+// grandparents say something about the first day of school without starting a
+// thread in the family chat, so they follow the record rather than the room:
+// anyone who can see the record can read its thread, and anyone who can see it
+// can add to it.
+//
+// That last part is deliberate. A family link is capped at AccessView and every
+// write path still requires membership, but a comment is not an edit to the
+// record — it is the reader's own words beside it. So commenting and reacting
+// ask for AccessView on the record, through CanAccessPhoto and
+// GetMilestoneForUser, and a linked family whose link carries photos (or
+// milestones) can join in on exactly the records it already sees. Changing
+// somebody else's comment is still reserved: its author may edit or delete it,
+// and a contributor in the record's own family may delete it, because the
+// record is theirs to keep tidy.
+//
+// Comment and Reaction rows carry the family that owns the record, not the
+// commenter's family, so the family index sweeps up everything on that
+// family's records when the family goes.
+
+func RegisterCommentMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, GetComments)
+	vbeam.RegisterProc(app, AddComment)
+	vbeam.RegisterProc(app, UpdateComment)
+	vbeam.RegisterProc(app, DeleteComment)
+	vbeam.RegisterProc(app, ToggleReaction)
+}
+
+// Comment targets.
+const (
+	CommentTargetMilestone = "milestone"
+	CommentTargetPhoto     = "photo"
+)
+
+// maxCommentLength matches the chat's limit: a comment is a remark, not a
+// journal entry.
+const maxCommentLength = 1000
+
+// commentReactions is the fixed set of reactions on offer. A short fixed set
+// keeps the counts meaningful and the row small; free-form emoji would need
+// validating against a Unicode table the server has no other use for.
+var commentReactions = []string{"❤️", "👍", "😂", "😮", "😢", "🎉"}
+
+// CommentTarget names the record a comment or reaction is on.
+type CommentTarget struct {
+	Kind string `json:"kind"`
+	Id   int    `json:"id"`
+}
+
+type Comment struct {
+	Id         int       `json:"id"`
+	TargetKind string    `json:"targetKind"`
+	TargetId   int       `json:"targetId"`
+	FamilyId   int       `json:"familyId"` // the family that owns the record
+	UserId     int       `json:"userId"`
+	UserName   string    `json:"userName"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"createdAt"`
+	EditedAt   time.Time `json:"editedAt"` // zero until the author edits it
+}
+
+type Reaction struct {
+	Id         int       `json:"id"`
+	TargetKind string    `json:"targetKind"`
+	TargetId   int       `json:"targetId"`
+	FamilyId   int       `json:"familyId"`
+	UserId     int       `json:"userId"`
+	Emoji      string    `json:"emoji"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func PackComment(self *Comment, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.String(&self.TargetKind, buf)
+	vpack.Int(&self.TargetId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Int(&self.UserId, buf)
+	vpack.String(&self.UserName, buf)
+	vpack.String(&self.Content, buf)
+	vpack.Time(&self.CreatedAt, buf)
+	vpack.Time(&self.EditedAt, buf)
+}
+
+func PackReaction(self *Reaction, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.String(&self.TargetKind, buf)
+	vpack.Int(&self.TargetId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Int(&self.UserId, buf)
+	vpack.String(&self.Emoji, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var CommentBkt = vbolt.Bucket(&cfg.Info, "comment", vpack.FInt, PackComment)
+
+// CommentByMilestoneIndex: term = milestone_id, target = comment_id
+var CommentByMilestoneIndex = vbolt.Index(&cfg.Info, "comment_by_milestone", vpack.FInt, vpack.FInt)
+
+// CommentByPhotoIndex: term = photo_id, target = comment_id
+var CommentByPhotoIndex = vbolt.Index(&cfg.Info, "comment_by_photo", vpack.FInt, vpack.FInt)
+
+// CommentByFamilyIndex: term = owning family_id, target = comment_id
+var CommentByFamilyIndex = vbolt.Index(&cfg.Info, "comment_by_family", vpack.FInt, vpack.FInt)
+
+// CommentByUserIndex: term = author user_id, target = comment_id
+var CommentByUserIndex = vbolt.Index(&cfg.Info, "comment_by_user", vpack.FInt, vpack.FInt)
+
+var ReactionBkt = vbolt.Bucket(&cfg.Info, "reaction", vpack.FInt, PackReaction)
+
+// ReactionByMilestoneIndex: term = milestone_id, target = reaction_id
+var ReactionByMilestoneIndex = vbolt.Index(&cfg.Info, "reaction_by_milestone", vpack.FInt, vpack.FInt)
+
+// ReactionByPhotoIndex: term = photo_id, target = reaction_id
+var ReactionByPhotoIndex = vbolt.Index(&cfg.Info, "reaction_by_photo", vpack.FInt, vpack.FInt)
+
+// ReactionByFamilyIndex: term = owning family_id, target = reaction_id
+var ReactionByFamilyIndex = vbolt.Index(&cfg.Info, "reaction_by_family", vpack.FInt, vpack.FInt)
+
+// ReactionByUserIndex: term = user_id, target = reaction_id
+var ReactionByUserIndex = vbolt.Index(&cfg.Info, "reaction_by_user", vpack.FInt, vpack.FInt)
+
+// commentTargetIndexes returns the by-target indexes for a kind of record.
+func commentTargetIndexes(kind string) (comments, reactions *vbolt.IndexInfo[int, int, uint16]) {
+	if kind == CommentTargetMilestone {
+		return CommentByMilestoneIndex, ReactionByMilestoneIndex
+	}
+	return CommentByPhotoIndex, ReactionByPhotoIndex
+}
+
+func GetCommentById(tx *vbolt.Tx, commentId int) (comment Comment) {
+	vbolt.Read(tx, CommentBkt, commentId, &comment)
+	return
+}
+
+// GetTargetComments returns a record's comments, oldest first.
+func GetTargetComments(tx *vbolt.Tx, target CommentTarget) []Comment {
+	index, _ := commentTargetIndexes(target.Kind)
+	comments := readByTerm(tx, index, CommentBkt, target.Id)
+	sort.SliceStable(comments, func(i, j int) bool { return comments[i].Id < comments[j].Id })
+	return comments
+}
+
+func GetTargetReactions(tx *vbolt.Tx, target CommentTarget) []Reaction {
+	_, index := commentTargetIndexes(target.Kind)
+	return readByTerm(tx, index, ReactionBkt, target.Id)
+}
+
+// resolveCommentTarget checks that the user may see the record and returns the
+// family that owns it. The errors are the record's own "not found or access
+// denied" wording, so a thread does not reveal whether a record exists.
+func resolveCommentTarget(tx *vbolt.Tx, user User, target CommentTarget) (familyId int, err error) {
+	switch target.Kind {
+	case CommentTargetMilestone:
+		milestone, accessErr := GetMilestoneForUser(tx, target.Id, user, AccessView)
+		if accessErr != nil {
+			return 0, accessErr
+		}
+		return milestone.FamilyId, nil
+	case CommentTargetPhoto:
+		photo := GetImageById(tx, target.Id)
+		if !CanAccessPhoto(tx, user, photo, AccessView) {
+			return 0, errors.New("Photo not found or access denied")
+		}
+		return photo.FamilyId, nil
+	}
+	return 0, errors.New("Comments can only be added to a milestone or a photo")
+}
+
+// canSeeCommentTarget is resolveCommentTarget as a yes/no, for working out who
+// should hear about a change.
+func canSeeCommentTarget(tx *vbolt.Tx, user User, target CommentTarget) bool {
+	switch target.Kind {
+	case CommentTargetMilestone:
+		return CanAccessMilestone(tx, user, GetMilestoneById(tx, target.Id), AccessView)
+	case CommentTargetPhoto:
+		return CanAccessPhoto(tx, user, GetImageById(tx, target.Id), AccessView)
+	}
+	return false
+}
+
+// commentAudienceTx lists the users who can see a record, and so should see its
+// thread change live.
+//
+// ChatHub files each connection under the user's primary family, which is the
+// wrong grouping here: a photo shared by link is seen by members of another
+// household, and not by all of them if they reach it through a family other
+// than their primary one. So the audience is worked out per user — the owning
+// family's members and the members of every family it links to, each asked the
+// same access question the procs ask — and the hub delivers to those users
+// wherever they are connected.
+func commentAudienceTx(tx *vbolt.Tx, familyId int, target CommentTarget) []int {
+	candidateFamilies := []int{familyId}
+	for _, link := range GetLinksFromFamily(tx, familyId) {
+		if link.Status == LinkAccepted {
+			candidateFamilies = append(candidateFamilies, link.ToFamilyId)
+		}
+	}
+
+	seen := make(map[int]bool)
+	var userIds []int
+	for _, candidateFamily := range candidateFamilies {
+		for _, userId := range GetFamilyUserIds(tx, candidateFamily) {
+			if seen[userId] {
+				continue
+			}
+			seen[userId] = true
+			if canSeeCommentTarget(tx, GetUser(tx, userId), target) {
+				userIds = append(userIds, userId)
+			}
+		}
+	}
+	sort.Ints(userIds)
+	return userIds
+}
+
+// ReactionSummary is one emoji's count on a record. UserIds lets a client mark
+// its own reaction without a second request.
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIds []int  `json:"userIds"`
+}
+
+// summarizeReactions groups a record's reactions in the order commentReactions
+// offers them.
+func summarizeReactions(reactions []Reaction) []ReactionSummary {
+	byEmoji := make(map[string]*ReactionSummary)
+	for _, reaction := range reactions {
+		summary := byEmoji[reaction.Emoji]
+		if summary == nil {
+			summary = &ReactionSummary{Emoji: reaction.Emoji}
+			byEmoji[reaction.Emoji] = summary
+		}
+		summary.Count++
+		summary.UserIds = append(summary.UserIds, reaction.UserId)
+	}
+
+	summaries := []ReactionSummary{}
+	for _, emoji := range commentReactions {
+		if summary := byEmoji[emoji]; summary != nil {
+			sort.Ints(summary.UserIds)
+			summaries = append(summaries, *summary)
+		}
+	}
+	return summaries
+}
+
+func validateCommentContent(content string) error {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return errors.New("Comment content is required")
+	}
+	if len(content) > maxCommentLength {
+		return errors.New("Comment content cannot exceed 1000 characters")
+	}
+	return nil
+}
+
+func isCommentReaction(emoji string) bool {
+	for _, offered := range commentReactions {
+		if emoji == offered {
+			return true
+		}
+	}
+	return false
+}
+
+func AddCommentTx(tx *vbolt.Tx, target CommentTarget, familyId int, user User, content string) Comment {
+	comment := Comment{
+		Id:         vbolt.NextIntId(tx, CommentBkt),
+		TargetKind: target.Kind,
+		TargetId:   target.Id,
+		FamilyId:   familyId,
+		UserId:     user.Id,
+		UserName:   user.Name,
+		Content:    strings.TrimSpace(content),
+		CreatedAt:  time.Now(),
+	}
+	vbolt.Write(tx, CommentBkt, comment.Id, &comment)
+
+	index, _ := commentTargetIndexes(target.Kind)
+	vbolt.SetTargetSingleTerm(tx, index, comment.Id, target.Id)
+	vbolt.SetTargetSingleTerm(tx, CommentByFamilyIndex, comment.Id, familyId)
+	vbolt.SetTargetSingleTerm(tx, CommentByUserIndex, comment.Id, user.Id)
+	return comment
+}
+
+func deleteCommentTx(tx *vbolt.Tx, comment Comment) {
+	index, _ := commentTargetIndexes(comment.TargetKind)
+	vbolt.SetTargetSingleTerm(tx, index, comment.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, CommentByFamilyIndex, comment.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, CommentByUserIndex, comment.Id, -1)
+	vbolt.Delete(tx, CommentBkt, comment.Id)
+}
+
+func deleteReactionTx(tx *vbolt.Tx, reaction Reaction) {
+	_, index := commentTargetIndexes(reaction.TargetKind)
+	vbolt.SetTargetSingleTerm(tx, index, reaction.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, ReactionByFamilyIndex, reaction.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, ReactionByUserIndex, reaction.Id, -1)
+	vbolt.Delete(tx, ReactionBkt, reaction.Id)
+}
+
+// ToggleReactionTx adds the user's reaction to a record, or takes it away if
+// they had already left it. Reports whether the reaction is now there.
+func ToggleReactionTx(tx *vbolt.Tx, target CommentTarget, familyId int, userId int, emoji string) bool {
+	for _, reaction := range GetTargetReactions(tx, target) {
+		if reaction.UserId == userId && reaction.Emoji == emoji {
+			deleteReactionTx(tx, reaction)
+			return false
+		}
+	}
+
+	reaction := Reaction{
+		Id:         vbolt.NextIntId(tx, ReactionBkt),
+		TargetKind: target.Kind,
+		TargetId:   target.Id,
+		FamilyId:   familyId,
+		UserId:     userId,
+		Emoji:      emoji,
+		CreatedAt:  time.Now(),
+	}
+	vbolt.Write(tx, ReactionBkt, reaction.Id, &reaction)
+
+	_, index := commentTargetIndexes(target.Kind)
+	vbolt.SetTargetSingleTerm(tx, index, reaction.Id, target.Id)
+	vbolt.SetTargetSingleTerm(tx, ReactionByFamilyIndex, reaction.Id, familyId)
+	vbolt.SetTargetSingleTerm(tx, ReactionByUserIndex, reaction.Id, userId)
+	return true
+}
+
+// deleteTargetCommentsTx removes a record's whole thread, for when the record
+// itself is deleted.
+func deleteTargetCommentsTx(tx *vbolt.Tx, target CommentTarget) {
+	for _, comment := range GetTargetComments(tx, target) {
+		deleteCommentTx(tx, comment)
+	}
+	for _, reaction := range GetTargetReactions(tx, target) {
+		deleteReactionTx(tx, reaction)
+	}
+}
+
+// deleteFamilyCommentsTx removes whatever is still filed under a family being
+// destroyed. Deleting its photos and milestones has normally taken every thread
+// with it already; this catches a row whose record went missing some other way.
+func deleteFamilyCommentsTx(tx *vbolt.Tx, familyId int) {
+	for _, comment := range readByTerm(tx, CommentByFamilyIndex, CommentBkt, familyId) {
+		deleteCommentTx(tx, comment)
+	}
+	for _, reaction := range readByTerm(tx, ReactionByFamilyIndex, ReactionBkt, familyId) {
+		deleteReactionTx(tx, reaction)
+	}
+}
+
+// deleteUserCommentsTx removes everything the user wrote or reacted with, on
+// every family's records, including families that survive the deletion.
+func deleteUserCommentsTx(tx *vbolt.Tx, userId int) {
+	for _, comment := range readByTerm(tx, CommentByUserIndex, CommentBkt, userId) {
+		deleteCommentTx(tx, comment)
+	}
+	for _, reaction := range readByTerm(tx, ReactionByUserIndex, ReactionBkt, userId) {
+		deleteReactionTx(tx, reaction)
+	}
+}
+
+// Request/Response types
+
+type GetCommentsRequest struct {
+	Target CommentTarget `json:"target"`
+}
+
+type GetCommentsResponse struct {
+	Comments  []Comment         `json:"comments"`
+	Reactions []ReactionSummary `json:"reactions"`
+}
+
+type AddCommentRequest struct {
+	Target  CommentTarget `json:"target"`
+	Content string        `json:"content"`
+}
+
+type UpdateCommentRequest struct {
+	Id      int    `json:"id"`
+	Content string `json:"content"`
+}
+
+type CommentResponse struct {
+	Comment Comment `json:"comment"`
+}
+
+type DeleteCommentRequest struct {
+	Id int `json:"id"`
+}
+
+type DeleteCommentResponse struct {
+	Success bool `json:"success"`
+}
+
+type ToggleReactionRequest struct {
+	Target CommentTarget `json:"target"`
+	Emoji  string        `json:"emoji"`
+}
+
+type ToggleReactionResponse struct {
+	Added     bool              `json:"added"`
+	Reactions []ReactionSummary `json:"reactions"`
+}
+
+// vbeam procedures
+
+func GetComments(ctx *vbeam.Context, req GetCommentsRequest) (resp GetCommentsResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if _, err = resolveCommentTarget(ctx.Tx, user, req.Target); err != nil {
+		return
+	}
+
+	resp.Comments = GetTargetComments(ctx.Tx, req.Target)
+	resp.Reactions = summarizeReactions(GetTargetReactions(ctx.Tx, req.Target))
+	return
+}
+
+func AddComment(ctx *vbeam.Context, req AddCommentRequest) (resp CommentResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateCommentContent(req.Content); err != nil {
+		return
+	}
+
+	familyId, err := resolveCommentTarget(ctx.Tx, user, req.Target)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	resp.Comment = AddCommentTx(ctx.Tx, req.Target, familyId, user, req.Content)
+	audience := commentAudienceTx(ctx.Tx, familyId, req.Target)
+	vbolt.TxCommit(ctx.Tx)
+
+	if hub := GetChatHub(); hub != nil {
+		hub.BroadcastComment(audience, resp.Comment)
+	}
+
+	LogInfo(LogCategoryAPI, "Comment added", map[string]interface{}{
+		"commentId":  resp.Comment.Id,
+		"targetKind": req.Target.Kind,
+		"targetId":   req.Target.Id,
+		"userId":     user.Id,
+	})
+	return
+}
+
+// UpdateComment lets the author reword their own comment. Nobody else may,
+// not even the record's family: deleting a comment is moderation, rewriting one
+// would be putting words in someone's mouth.
+func UpdateComment(ctx *vbeam.Context, req UpdateCommentRequest) (resp CommentResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateCommentContent(req.Content); err != nil {
+		return
+	}
+
+	comment := GetCommentById(ctx.Tx, req.Id)
+	if comment.Id == 0 || comment.UserId != user.Id {
+		err = errors.New("Comment not found or access denied")
+		return
+	}
+	target := CommentTarget{Kind: comment.TargetKind, Id: comment.TargetId}
+	// An author who has since lost sight of the record cannot keep editing a
+	// thread they can no longer read.
+	if _, err = resolveCommentTarget(ctx.Tx, user, target); err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	comment.Content = strings.TrimSpace(req.Content)
+	comment.EditedAt = time.Now()
+	vbolt.Write(ctx.Tx, CommentBkt, comment.Id, &comment)
+	audience := commentAudienceTx(ctx.Tx, comment.FamilyId, target)
+	vbolt.TxCommit(ctx.Tx)
+
+	if hub := GetChatHub(); hub != nil {
+		hub.BroadcastComment(audience, comment)
+	}
+
+	resp.Comment = comment
+	return
+}
+
+// DeleteComment removes a comment. Its author may always delete it; so may a
+// contributor in the family that owns the record.
+func DeleteComment(ctx *vbeam.Context, req DeleteCommentRequest) (resp DeleteCommentResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	comment := GetCommentById(ctx.Tx, req.Id)
+	if comment.Id == 0 {
+		err = errors.New("Comment not found or access denied")
+		return
+	}
+	if comment.UserId != user.Id && !CanAccessFamily(ctx.Tx, user, comment.FamilyId, AccessContribute) {
+		err = errors.New("Comment not found or access denied")
+		return
+	}
+
+	target := CommentTarget{Kind: comment.TargetKind, Id: comment.TargetId}
+	vbeam.UseWriteTx(ctx)
+	audience := commentAudienceTx(ctx.Tx, comment.FamilyId, target)
+	deleteCommentTx(ctx.Tx, comment)
+	vbolt.TxCommit(ctx.Tx)
+
+	if hub := GetChatHub(); hub != nil {
+		hub.BroadcastDeleteComment(audience, target, comment.Id)
+	}
+
+	LogInfo(LogCategoryAPI, "Comment deleted", map[string]interface{}{
+		"commentId": comment.Id,
+		"userId":    user.Id,
+	})
+
+	resp.Success = true
+	return
+}
+
+func ToggleReaction(ctx *vbeam.Context, req ToggleReactionRequest) (resp ToggleReactionResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if !isCommentReaction(req.Emoji) {
+		err = errors.New("That reaction is not available")
+		return
+	}
+
+	familyId, err := resolveCommentTarget(ctx.Tx, user, req.Target)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	resp.Added = ToggleReactionTx(ctx.Tx, req.Target, familyId, user.Id, req.Emoji)
+	resp.Reactions = summarizeReactions(GetTargetReactions(ctx.Tx, req.Target))
+	audience := commentAudienceTx(ctx.Tx, familyId, req.Target)
+	vbolt.TxCommit(ctx.Tx)
+
+	if hub := GetChatHub(); hub != nil {
+		hub.BroadcastReactions(audience, req.Target, resp.Reactions)
+	}
+	return
+}
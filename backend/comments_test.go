@@ -0,0 +1,267 @@
+package backend
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func callAsUser(t *testing.T, db *vbolt.DB, user User, fn func(ctx *vbeam.Context)) {
+	t.Helper()
+	token, err := generateJwtTokenString(user)
+	if err != nil {
+		t.Fatalf("generateJwtTokenString() error = %v", err)
+	}
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		fn(&vbeam.Context{Tx: tx, Token: token})
+	})
+}
+
+// Grandparents reach alice's photo and milestone through the A→B link, and
+// can comment on both — the link being read-only does not stop them talking
+// about what they see.
+func TestLinkedFamilyCanCommentOnWhatItSees(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("comments-test-secret-key-at-least-32")
+
+	photo := CommentTarget{Kind: CommentTargetPhoto, Id: fx.alicePhoto.Id}
+	milestone := CommentTarget{Kind: CommentTargetMilestone, Id: fx.aliceMilestone.Id}
+	untagged := CommentTarget{Kind: CommentTargetPhoto, Id: fx.untaggedPhoto.Id}
+
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		resp, err := AddComment(ctx, AddCommentRequest{Target: photo, Content: "  What a smile  "})
+		if err != nil {
+			t.Fatalf("AddComment(photo) error = %v", err)
+		}
+		if resp.Comment.FamilyId != fx.famA || resp.Comment.Content != "What a smile" || resp.Comment.UserName != fx.userB.Name {
+			t.Errorf("comment = %+v, want it filed under A, trimmed", resp.Comment)
+		}
+	})
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := AddComment(ctx, AddCommentRequest{Target: milestone, Content: "Well done!"}); err != nil {
+			t.Fatalf("AddComment(milestone) error = %v", err)
+		}
+	})
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := AddComment(ctx, AddCommentRequest{Target: untagged, Content: "Who is this?"}); err == nil {
+			t.Error("B commented on a photo of nobody it was shown")
+		}
+	})
+	callAsUser(t, fx.db, fx.userC, func(ctx *vbeam.Context) {
+		if _, err := GetComments(ctx, GetCommentsRequest{Target: photo}); err == nil {
+			t.Error("C read a thread two hops away")
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := GetComments(ctx, GetCommentsRequest{Target: photo})
+		if err != nil {
+			t.Fatalf("GetComments() error = %v", err)
+		}
+		if len(resp.Comments) != 1 || resp.Comments[0].UserId != fx.userB.Id {
+			t.Errorf("A sees %+v, want B's comment", resp.Comments)
+		}
+	})
+
+	// Taking milestones out of the link takes the milestone's thread with it.
+	setLinkScopes(t, fx, fx.linkAB, LinkScopes{People: true, Photos: true})
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := GetComments(ctx, GetCommentsRequest{Target: milestone}); err == nil {
+			t.Error("B still reads the milestone thread without the milestones scope")
+		}
+	})
+}
+
+func TestCommentEditAndDeleteRights(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("comments-test-secret-key-at-least-32")
+
+	target := CommentTarget{Kind: CommentTargetPhoto, Id: fx.alicePhoto.Id}
+	var fromB, fromA Comment
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		fromB = AddCommentTx(tx, target, fx.famA, fx.userB, "Adorable")
+		fromA = AddCommentTx(tx, target, fx.famA, fx.userA, "Thanks!")
+		vbolt.TxCommit(tx)
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := UpdateComment(ctx, UpdateCommentRequest{Id: fromB.Id, Content: "Ugly"}); err == nil {
+			t.Error("the record's family rewrote somebody else's comment")
+		}
+	})
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		resp, err := UpdateComment(ctx, UpdateCommentRequest{Id: fromB.Id, Content: "So adorable"})
+		if err != nil {
+			t.Fatalf("UpdateComment() error = %v", err)
+		}
+		if resp.Comment.Content != "So adorable" || resp.Comment.EditedAt.IsZero() {
+			t.Errorf("edited = %+v", resp.Comment)
+		}
+	})
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := DeleteComment(ctx, DeleteCommentRequest{Id: fromA.Id}); err == nil {
+			t.Error("a linked reader deleted the family's own comment")
+		}
+	})
+	// The record's family may tidy its own thread.
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := DeleteComment(ctx, DeleteCommentRequest{Id: fromB.Id}); err != nil {
+			t.Fatalf("DeleteComment() error = %v", err)
+		}
+	})
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if comments := GetTargetComments(tx, target); len(comments) != 1 || comments[0].Id != fromA.Id {
+			t.Errorf("thread = %+v, want only A's comment", comments)
+		}
+	})
+}
+
+func TestToggleReaction(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("comments-test-secret-key-at-least-32")
+
+	target := CommentTarget{Kind: CommentTargetMilestone, Id: fx.aliceMilestone.Id}
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := ToggleReaction(ctx, ToggleReactionRequest{Target: target, Emoji: "🦄"}); err == nil {
+			t.Error("a reaction off the list was accepted")
+		}
+	})
+	for _, user := range []User{fx.userA, fx.userB} {
+		callAsUser(t, fx.db, user, func(ctx *vbeam.Context) {
+			if resp, err := ToggleReaction(ctx, ToggleReactionRequest{Target: target, Emoji: "🎉"}); err != nil || !resp.Added {
+				t.Fatalf("ToggleReaction() = %+v, %v", resp, err)
+			}
+		})
+	}
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := ToggleReaction(ctx, ToggleReactionRequest{Target: target, Emoji: "❤️"}); err != nil {
+			t.Fatalf("ToggleReaction() error = %v", err)
+		}
+	})
+
+	// A second tap takes B's reaction back.
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		resp, err := ToggleReaction(ctx, ToggleReactionRequest{Target: target, Emoji: "🎉"})
+		if err != nil || resp.Added {
+			t.Fatalf("second toggle = %+v, %v", resp, err)
+		}
+		want := []ReactionSummary{{Emoji: "❤️", Count: 1}, {Emoji: "🎉", Count: 1}}
+		if len(resp.Reactions) != len(want) {
+			t.Fatalf("reactions = %+v, want %+v", resp.Reactions, want)
+		}
+		for i, summary := range resp.Reactions {
+			if summary.Emoji != want[i].Emoji || summary.Count != want[i].Count || summary.UserIds[0] != fx.userA.Id {
+				t.Errorf("reactions[%d] = %+v, want %s from A", i, summary, want[i].Emoji)
+			}
+		}
+	})
+}
+
+func TestDeletingARecordDeletesItsThread(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+
+	photo := CommentTarget{Kind: CommentTargetPhoto, Id: fx.alicePhoto.Id}
+	milestone := CommentTarget{Kind: CommentTargetMilestone, Id: fx.aliceMilestone.Id}
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		AddCommentTx(tx, photo, fx.famA, fx.userB, "Cute")
+		ToggleReactionTx(tx, photo, fx.famA, fx.userB.Id, "👍")
+		AddCommentTx(tx, milestone, fx.famA, fx.userA, "Finally")
+		ToggleReactionTx(tx, milestone, fx.famA, fx.userB.Id, "🎉")
+
+		deletePhotoRecordTx(tx, fx.alicePhoto)
+		if err := DeleteMilestoneTx(tx, fx.aliceMilestone.Id, fx.famA); err != nil {
+			t.Fatalf("DeleteMilestoneTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	if got := countRows(t, fx.db, CommentBkt); got != 0 {
+		t.Errorf("comments remaining = %d, want 0", got)
+	}
+	if got := countRows(t, fx.db, ReactionBkt); got != 0 {
+		t.Errorf("reactions remaining = %d, want 0", got)
+	}
+}
+
+// An account that commented on another household's photo takes its comments
+// with it; the household keeps its photo and everyone else's comments.
+func TestDeletedAccountsCommentsGoEverywhere(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+
+	target := CommentTarget{Kind: CommentTargetPhoto, Id: fx.alicePhoto.Id}
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		AddCommentTx(tx, target, fx.famA, fx.userB, "Gorgeous")
+		ToggleReactionTx(tx, target, fx.famA, fx.userB.Id, "❤️")
+		AddCommentTx(tx, target, fx.famA, fx.userA, "Thank you")
+
+		deleteUserCommentsTx(tx, fx.userB.Id)
+
+		comments := GetTargetComments(tx, target)
+		if len(comments) != 1 || comments[0].UserId != fx.userA.Id {
+			t.Errorf("thread = %+v, want only A's comment", comments)
+		}
+		if reactions := GetTargetReactions(tx, target); len(reactions) != 0 {
+			t.Errorf("reactions = %+v, want none", reactions)
+		}
+	})
+}
+
+func TestCommentAudienceIsWhoeverCanSeeTheRecord(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		shared := commentAudienceTx(tx, fx.famA, CommentTarget{Kind: CommentTargetPhoto, Id: fx.alicePhoto.Id})
+		if len(shared) != 2 || shared[0] != fx.userA.Id || shared[1] != fx.userB.Id {
+			t.Errorf("alice's photo goes to %v, want A and B", shared)
+		}
+		private := commentAudienceTx(tx, fx.famA, CommentTarget{Kind: CommentTargetPhoto, Id: fx.untaggedPhoto.Id})
+		if len(private) != 1 || private[0] != fx.userA.Id {
+			t.Errorf("the untagged photo goes to %v, want A alone", private)
+		}
+	})
+}
+
+// Comment updates are addressed to users, so they reach a user whatever family
+// their connection is filed under, and nobody else.
+func TestBroadcastToUsersReachesOnlyTheAudience(t *testing.T) {
+	hub := &ChatHub{families: make(map[int]map[*Client]bool)}
+	audience := &Client{userId: 7, familyId: 1, send: make(chan []byte, 1)}
+	elsewhere := &Client{userId: 7, familyId: 2, send: make(chan []byte, 1)}
+	outsider := &Client{userId: 8, familyId: 1, send: make(chan []byte, 1)}
+	hub.families[1] = map[*Client]bool{audience: true, outsider: true}
+	hub.families[2] = map[*Client]bool{elsewhere: true}
+
+	hub.broadcastToUsers([]int{7}, WSMessage{
+		Type:    WSMsgTypeComment,
+		Payload: WSCommentPayload{Comment: Comment{Id: 3, Content: "Hi"}},
+	})
+
+	for _, client := range []*Client{audience, elsewhere} {
+		select {
+		case raw := <-client.send:
+			var got struct {
+				Type    string           `json:"type"`
+				Payload WSCommentPayload `json:"payload"`
+			}
+			if err := json.Unmarshal(raw, &got); err != nil || got.Type != WSMsgTypeComment || got.Payload.Comment.Id != 3 {
+				t.Errorf("family %d client got %s", client.familyId, raw)
+			}
+		default:
+			t.Errorf("family %d client got nothing", client.familyId)
+		}
+	}
+	select {
+	case raw := <-outsider.send:
+		t.Errorf("a user outside the audience got %s", raw)
+	default:
+	}
+}
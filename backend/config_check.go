@@ -63,6 +63,7 @@ func CheckProductionConfig(dbPath, staticDir string) []ConfigIssue {
 	issues = append(issues, checkBackupToken()...)
 	issues = append(issues, checkAPNs()...)
 	issues = append(issues, checkIOSAppID()...)
+	issues = append(issues, checkVaccineSchedule()...)
 	issues = append(issues, checkStoragePaths(dbPath, staticDir)...)
 	return issues
 }
@@ -130,6 +131,19 @@ func checkMail() []ConfigIssue {
 	return nil
 }
 
+// checkVaccineSchedule reads the schedule VACCINE_SCHEDULE_PATH names, if it
+// names one. An unusable file falls back to the built-in schedule at runtime,
+// which is the wrong country's schedule shown without complaint.
+func checkVaccineSchedule() []ConfigIssue {
+	if os.Getenv("VACCINE_SCHEDULE_PATH") == "" {
+		return nil
+	}
+	if _, err := loadVaccineSchedule(); err != nil {
+		return []ConfigIssue{{Setting: "VACCINE_SCHEDULE_PATH", Detail: "schedule is unusable: " + err.Error()}}
+	}
+	return nil
+}
+
 // checkAIProvider requires the Gemini key. The import page exposes AI-assisted
 // import to every user in 1.0, so an unset key is a visible feature that errors
 // on use. AI_MODEL is optional and falls back to GetDefaultAIModel.
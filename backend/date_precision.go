@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A milestone or photo date used to be a day, always. "Sometime in summer
+// 2019" or "around age 2" had to be typed in as some particular day, and a
+// year later nobody could tell the made-up 1 July from a date somebody
+// actually wrote down. A date now carries its precision alongside it.
+//
+// The stored time is the start of the period it names — the first of the
+// month, the first day of the season, 1 January — so two entries for "March
+// 2020" are the same value and a period reads back unambiguously. Sorting uses
+// the middle of the period instead, so "2019" lands among 2019's dated entries
+// rather than ahead of all of them. Day and approximate dates are a single day
+// and store it as given; approximate only says not to take the day literally.
+//
+// Seasons are meteorological and northern: spring is March to May, summer June
+// to August, autumn September to November, and winter December to February,
+// belonging to the year it starts in.
+
+// Date precisions. The empty string is every row written before precision
+// existed, and reads as a day.
+const (
+	DatePrecisionDay         = "day"
+	DatePrecisionMonth       = "month"
+	DatePrecisionSeason      = "season"
+	DatePrecisionYear        = "year"
+	DatePrecisionApproximate = "approximate"
+)
+
+var datePrecisions = map[string]bool{
+	"":                       true,
+	DatePrecisionDay:         true,
+	DatePrecisionMonth:       true,
+	DatePrecisionSeason:      true,
+	DatePrecisionYear:        true,
+	DatePrecisionApproximate: true,
+}
+
+func validateDatePrecision(precision string) error {
+	if !datePrecisions[precision] {
+		return errors.New("Date precision must be 'day', 'month', 'season', 'year' or 'approximate'")
+	}
+	return nil
+}
+
+// normalizeDatePrecision maps the unset value to the day it has always meant.
+func normalizeDatePrecision(precision string) string {
+	if precision == "" {
+		return DatePrecisionDay
+	}
+	return precision
+}
+
+// isExactDate reports whether a date names one particular day that can be
+// taken at its word: the day anniversaries and "on this day" rely on.
+func isExactDate(precision string) bool {
+	return normalizeDatePrecision(precision) == DatePrecisionDay
+}
+
+// parseDateValue reads a date entered at the given precision. A whole date is
+// always accepted; a coarser precision also accepts the shorter form a person
+// would naturally type for it, "2019-07" for a month and "2019" for a year.
+func parseDateValue(value string, precision string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	switch normalizeDatePrecision(precision) {
+	case DatePrecisionMonth, DatePrecisionSeason:
+		if t, err := time.Parse("2006-01", value); err == nil {
+			return t, nil
+		}
+	case DatePrecisionYear:
+		if t, err := time.Parse("2006-01", value); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse("2006", value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid date format. Use YYYY-MM-DD")
+}
+
+// seasonStart returns the first day of the season t falls in.
+func seasonStart(t time.Time) time.Time {
+	year, month := t.Year(), t.Month()
+	var start time.Month
+	switch {
+	case month == time.December:
+		start = time.December
+	case month <= time.February:
+		start = time.December
+		year--
+	case month <= time.May:
+		start = time.March
+	case month <= time.August:
+		start = time.June
+	default:
+		start = time.September
+	}
+	return time.Date(year, start, 1, 0, 0, 0, 0, t.Location())
+}
+
+// anchorDate snaps t to the start of the period it names at this precision.
+// Day and approximate dates are left exactly as given.
+func anchorDate(t time.Time, precision string) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	switch normalizeDatePrecision(precision) {
+	case DatePrecisionMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case DatePrecisionSeason:
+		return seasonStart(t)
+	case DatePrecisionYear:
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
+
+// datePeriod returns the half-open span [start, end) a date covers at its
+// precision.
+func datePeriod(t time.Time, precision string) (start, end time.Time) {
+	start = anchorDate(t, precision)
+	switch normalizeDatePrecision(precision) {
+	case DatePrecisionMonth:
+		end = start.AddDate(0, 1, 0)
+	case DatePrecisionSeason:
+		end = start.AddDate(0, 3, 0)
+	case DatePrecisionYear:
+		end = start.AddDate(1, 0, 0)
+	default:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		end = start.AddDate(0, 0, 1)
+	}
+	return
+}
+
+// dateSortKey is where a date sorts: its own time for a day, the middle of the
+// period for anything coarser.
+func dateSortKey(t time.Time, precision string) time.Time {
+	switch normalizeDatePrecision(precision) {
+	case DatePrecisionDay, DatePrecisionApproximate:
+		return t
+	}
+	start, end := datePeriod(t, precision)
+	return start.Add(end.Sub(start) / 2)
+}
+
+// datePeriodMonths lists the months a date covers, for the year and month
+// search terms: a season crosses three months and, in winter, two years. A
+// year-precision date covers no particular month and returns none.
+func datePeriodMonths(t time.Time, precision string) []time.Time {
+	switch normalizeDatePrecision(precision) {
+	case DatePrecisionYear:
+		return nil
+	case DatePrecisionSeason:
+		start := seasonStart(t)
+		return []time.Time{start, start.AddDate(0, 1, 0), start.AddDate(0, 2, 0)}
+	}
+	return []time.Time{t}
+}
+
+var seasonNames = map[time.Month]string{
+	time.March:     "Spring",
+	time.June:      "Summer",
+	time.September: "Autumn",
+	time.December:  "Winter",
+}
+
+// formatDatePrecision renders a date at the precision it was entered with, in
+// this reader's format where the date is a whole day.
+func (prefs UserPreferences) formatDatePrecision(t time.Time, precision string) string {
+	switch normalizeDatePrecision(precision) {
+	case DatePrecisionMonth:
+		return t.Format("January 2006")
+	case DatePrecisionSeason:
+		start := seasonStart(t)
+		if start.Month() == time.December {
+			return fmt.Sprintf("Winter %d–%02d", start.Year(), (start.Year()+1)%100)
+		}
+		return fmt.Sprintf("%s %d", seasonNames[start.Month()], start.Year())
+	case DatePrecisionYear:
+		return t.Format("2006")
+	case DatePrecisionApproximate:
+		return "around " + prefs.formatDate(t)
+	}
+	return prefs.formatDate(t)
+}
+
+// sortMilestonesByDate orders milestones by when they happened, as their
+// precision places them, oldest first.
+func sortMilestonesByDate(milestones []Milestone) {
+	sort.SliceStable(milestones, func(i, j int) bool {
+		a := dateSortKey(milestones[i].MilestoneDate, milestones[i].DatePrecision)
+		b := dateSortKey(milestones[j].MilestoneDate, milestones[j].DatePrecision)
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return milestones[i].Id < milestones[j].Id
+	})
+}
+
+// sortImagesByDate is sortMilestonesByDate for photos.
+func sortImagesByDate(images []Image) {
+	sort.SliceStable(images, func(i, j int) bool {
+		a := dateSortKey(images[i].PhotoDate, images[i].PhotoDatePrecision)
+		b := dateSortKey(images[j].PhotoDate, images[j].PhotoDatePrecision)
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return images[i].Id < images[j].Id
+	})
+}
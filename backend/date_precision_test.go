@@ -0,0 +1,213 @@
+package backend
+
+import (
+	"family/cfg"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func calendarDay(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestAnchorDate(t *testing.T) {
+	cases := []struct {
+		date      time.Time
+		precision string
+		want      time.Time
+	}{
+		{calendarDay(2019, time.July, 19), DatePrecisionDay, calendarDay(2019, time.July, 19)},
+		{calendarDay(2019, time.July, 19), "", calendarDay(2019, time.July, 19)},
+		{calendarDay(2019, time.July, 19), DatePrecisionApproximate, calendarDay(2019, time.July, 19)},
+		{calendarDay(2019, time.July, 19), DatePrecisionMonth, calendarDay(2019, time.July, 1)},
+		{calendarDay(2019, time.July, 19), DatePrecisionSeason, calendarDay(2019, time.June, 1)},
+		{calendarDay(2019, time.July, 19), DatePrecisionYear, calendarDay(2019, time.January, 1)},
+		// January belongs to the winter that started the December before.
+		{calendarDay(2020, time.January, 10), DatePrecisionSeason, calendarDay(2019, time.December, 1)},
+		{calendarDay(2019, time.December, 24), DatePrecisionSeason, calendarDay(2019, time.December, 1)},
+		{calendarDay(2019, time.November, 30), DatePrecisionSeason, calendarDay(2019, time.September, 1)},
+	}
+	for _, tc := range cases {
+		if got := anchorDate(tc.date, tc.precision); !got.Equal(tc.want) {
+			t.Errorf("anchorDate(%s, %q) = %s, want %s", tc.date.Format("2006-01-02"), tc.precision, got.Format("2006-01-02"), tc.want.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestParseDateValueAcceptsShortFormsOnlyWhereTheyMeanSomething(t *testing.T) {
+	if got, err := parseDateValue("2019-07", DatePrecisionMonth); err != nil || !got.Equal(calendarDay(2019, time.July, 1)) {
+		t.Errorf("month = %v, %v", got, err)
+	}
+	if got, err := parseDateValue("2019", DatePrecisionYear); err != nil || !got.Equal(calendarDay(2019, time.January, 1)) {
+		t.Errorf("year = %v, %v", got, err)
+	}
+	if _, err := parseDateValue("2019-07", DatePrecisionDay); err == nil {
+		t.Error("a month was accepted as a day")
+	}
+	if _, err := parseDateValue("2019", DatePrecisionMonth); err == nil {
+		t.Error("a bare year was accepted as a month")
+	}
+}
+
+func TestCoarseDatesSortAmongTheirPeriod(t *testing.T) {
+	milestones := []Milestone{
+		{Id: 1, MilestoneDate: calendarDay(2019, time.January, 1), DatePrecision: DatePrecisionYear},
+		{Id: 2, MilestoneDate: calendarDay(2019, time.February, 10), DatePrecision: DatePrecisionDay},
+		{Id: 3, MilestoneDate: calendarDay(2019, time.November, 2), DatePrecision: DatePrecisionDay},
+		{Id: 4, MilestoneDate: calendarDay(2019, time.June, 1), DatePrecision: DatePrecisionSeason},
+	}
+	sortMilestonesByDate(milestones)
+
+	want := []int{2, 1, 4, 3}
+	for i, id := range want {
+		if milestones[i].Id != id {
+			t.Fatalf("order = %v, want %v", []int{milestones[0].Id, milestones[1].Id, milestones[2].Id, milestones[3].Id}, want)
+		}
+	}
+}
+
+func TestFormatDatePrecision(t *testing.T) {
+	prefs := UserPreferences{DateFormat: DateFormatISO}
+	cases := map[string]string{
+		DatePrecisionDay:         "2019-07-19",
+		DatePrecisionApproximate: "around 2019-07-19",
+		DatePrecisionMonth:       "July 2019",
+		DatePrecisionSeason:      "Summer 2019",
+		DatePrecisionYear:        "2019",
+	}
+	for precision, want := range cases {
+		if got := prefs.formatDatePrecision(anchorDate(calendarDay(2019, time.July, 19), precision), precision); got != want {
+			t.Errorf("formatDatePrecision(%q) = %q, want %q", precision, got, want)
+		}
+	}
+	if got := prefs.formatDatePrecision(calendarDay(2019, time.December, 1), DatePrecisionSeason); got != "Winter 2019–20" {
+		t.Errorf("winter = %q", got)
+	}
+}
+
+func TestMilestoneDatePrecisionIsStoredAndSearched(t *testing.T) {
+	db := vbolt.Open(t.TempDir() + "/precision.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	t.Cleanup(func() { _ = db.Close() })
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		parent := AddUserTx(tx, CreateAccountRequest{Name: "Parent", Email: "parent@example.com"}, hash)
+		child, err := AddPersonTx(tx, AddPersonRequest{Name: "Kid", PersonType: 1, Gender: 0, Birthdate: "2017-03-01"}, parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+
+		summer, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: child.Id, Description: "Learned to swim", Category: "achievement",
+			InputType: "date", MilestoneDate: stringPtr("2019-07-19"), DatePrecision: DatePrecisionSeason,
+		}, parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		if summer.DatePrecision != DatePrecisionSeason || !summer.MilestoneDate.Equal(calendarDay(2019, time.June, 1)) {
+			t.Errorf("stored %s at %q, want the start of summer", summer.MilestoneDate, summer.DatePrecision)
+		}
+
+		for term, want := range map[string]bool{"m:2019.08": true, "m:2019.09": false, "y:2019": true} {
+			var ids []int
+			vbolt.ReadTermTargets(tx, MilestoneSearchIndex, term, &ids, vbolt.Window{})
+			if found := len(ids) == 1 && ids[0] == summer.Id; found != want {
+				t.Errorf("search term %s found the milestone = %v, want %v", term, found, want)
+			}
+		}
+
+		// An edit that does not mention precision keeps it.
+		updated, err := UpdateMilestoneTx(tx, UpdateMilestoneRequest{
+			Id: summer.Id, Description: "Learned to swim", Category: "achievement",
+			InputType: "date", MilestoneDate: stringPtr("2019-08-02"),
+		}, parent.FamilyId)
+		if err != nil {
+			t.Fatalf("UpdateMilestoneTx() error = %v", err)
+		}
+		if updated.DatePrecision != DatePrecisionSeason || !updated.MilestoneDate.Equal(calendarDay(2019, time.June, 1)) {
+			t.Errorf("after the edit: %s at %q", updated.MilestoneDate, updated.DatePrecision)
+		}
+
+		if _, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: child.Id, Description: "Something", Category: "other",
+			InputType: "today", DatePrecision: "decade",
+		}, parent.FamilyId); err == nil {
+			t.Error("an unknown precision was accepted")
+		}
+
+		// "Around age 2" is the birthday plus two years, not to be taken
+		// literally.
+		around, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: child.Id, Description: "Stopped napping", Category: "development",
+			InputType: "age", AgeYears: intPtr(2), DatePrecision: DatePrecisionApproximate,
+		}, parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		if !around.MilestoneDate.Equal(calendarDay(2019, time.March, 1)) || around.DatePrecision != DatePrecisionApproximate {
+			t.Errorf("around age 2 = %s at %q", around.MilestoneDate, around.DatePrecision)
+		}
+	})
+}
+
+func TestDatePrecisionRoundTripsThroughExport(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if _, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.child.Id, Description: "Moved house", Category: "other",
+			InputType: "date", MilestoneDate: stringPtr("2021-05"), DatePrecision: DatePrecisionMonth,
+		}, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	var exported ExportDataStructure
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		if exported, err = buildExportData(tx, fx.parent.FamilyId); err != nil {
+			t.Fatalf("buildExportData() error = %v", err)
+		}
+	})
+	localizeExportData(UserPreferences{DateFormat: DateFormatISO}, &exported)
+	milestone := exported.Milestones[0]
+	if milestone.DatePrecision != DatePrecisionMonth || milestone.DisplayDate != "May 2021" {
+		t.Fatalf("exported %q shown as %q", milestone.DatePrecision, milestone.DisplayDate)
+	}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		person, err := AddPersonTx(tx, AddPersonRequest{Name: "Kid", PersonType: 1, Gender: 0, Birthdate: "2020-01-01"}, fx.outsider.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		// A file may give any day in the month; it comes in as the month.
+		milestone.MilestoneDate = calendarDay(2021, time.May, 17)
+		imported, _, errs := importMilestones(tx, []ExportMilestone{milestone}, map[int]int{fx.child.Id: person.Id}, fx.outsider.FamilyId, nil)
+		if imported != 1 || len(errs) != 0 {
+			t.Fatalf("imported %d, errors %v", imported, errs)
+		}
+		got := GetPersonMilestonesTx(tx, person.Id)[0]
+		if got.DatePrecision != DatePrecisionMonth || !got.MilestoneDate.Equal(calendarDay(2021, time.May, 1)) {
+			t.Errorf("imported %s at %q", got.MilestoneDate, got.DatePrecision)
+		}
+	})
+}
+
+func TestPhotoDatePrecision(t *testing.T) {
+	date, precision, err := calculatePhotoDateAt("date", "2018", DatePrecisionYear, nil, nil, Person{}, nil)
+	if err != nil || precision != DatePrecisionYear || !date.Equal(calendarDay(2018, time.January, 1)) {
+		t.Errorf("year photo = %s at %q, %v", date, precision, err)
+	}
+	// A camera date is a day, whatever the form said.
+	if _, precision, _ := calculatePhotoDateAt("auto", "", DatePrecisionYear, nil, nil, Person{}, nil); precision != DatePrecisionDay {
+		t.Errorf("auto photo precision = %q", precision)
+	}
+	if _, _, err := calculatePhotoDateAt("date", "2018-01-01", "fortnight", nil, nil, Person{}, nil); err == nil {
+		t.Error("an unknown precision was accepted")
+	}
+}
@@ -0,0 +1,529 @@
+package backend
+
+// Dental chart: when each tooth came in and when it came out.
+//
+// Teeth are named by the Universal Numbering System dentists here use: the
+// twenty primary teeth are the letters A to T and the thirty-two permanent
+// teeth the numbers 1 to 32, both counted from the upper right, across the
+// upper jaw, and back along the lower. A person has at most one record per
+// tooth, and the chart is the full set of teeth with whatever has been
+// recorded against each, so an empty chart still shows every tooth to fill in.
+//
+// The first primary tooth to come in, and the first to fall out, are the
+// milestones every family records; saving the tooth that is the first of
+// either files the milestone too, dated the same day. It is filed once: a
+// milestone deleted afterwards is not brought back by the next tooth, since
+// that tooth is no longer the first.
+//
+// Teeth coming in are development, and are read through ScopeGrowth along
+// with heights and weights rather than as a medical record.
+
+import (
+	"errors"
+	"family/cfg"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+func RegisterDentalMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, GetDentalChart)
+	vbeam.RegisterProc(app, SetTooth)
+	vbeam.RegisterProc(app, ClearTooth)
+}
+
+// Tooth sets
+const (
+	ToothPrimary   = "primary"
+	ToothPermanent = "permanent"
+)
+
+const maxToothNotesLength = 500
+
+// dentalMilestoneCategory files the first-tooth milestones with the other
+// firsts.
+const dentalMilestoneCategory = "first"
+
+// Tooth is one tooth of the numbering.
+type Tooth struct {
+	Code string `json:"code"` // "A".."T" for primary teeth, "1".."32" for permanent
+	Set  string `json:"set"`
+	Name string `json:"name"` // e.g. "Upper right first molar"
+}
+
+// dentalTeeth is every tooth in chart order: the primary set, then the
+// permanent, each from the upper right around to the lower right.
+var dentalTeeth = buildDentalTeeth()
+
+func buildDentalTeeth() []Tooth {
+	// One quadrant from the back of the mouth to the front. The upper right
+	// quadrant is read as listed, the upper left mirrored; the lower jaw is
+	// numbered left to right, so its left quadrant is as listed and its right
+	// mirrored.
+	primary := []string{"second molar", "first molar", "canine", "lateral incisor", "central incisor"}
+	permanent := []string{"third molar", "second molar", "first molar", "second premolar", "first premolar", "canine", "lateral incisor", "central incisor"}
+
+	var teeth []Tooth
+	add := func(set string, quadrant []string, code func(int) string) {
+		sides := []struct {
+			jaw, side string
+			reverse   bool
+		}{
+			{"Upper", "right", false},
+			{"Upper", "left", true},
+			{"Lower", "left", false},
+			{"Lower", "right", true},
+		}
+		n := 0
+		for _, s := range sides {
+			for i := range quadrant {
+				kind := quadrant[i]
+				if s.reverse {
+					kind = quadrant[len(quadrant)-1-i]
+				}
+				teeth = append(teeth, Tooth{Code: code(n), Set: set, Name: s.jaw + " " + s.side + " " + kind})
+				n++
+			}
+		}
+	}
+	add(ToothPrimary, primary, func(n int) string { return string(rune('A' + n)) })
+	add(ToothPermanent, permanent, func(n int) string { return strconv.Itoa(n + 1) })
+	return teeth
+}
+
+// findTooth looks a tooth up by its code. Letters are taken in either case
+// and numbers with a leading zero, as charts print them both ways.
+func findTooth(code string) (Tooth, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if n, err := strconv.Atoi(code); err == nil {
+		code = strconv.Itoa(n)
+	}
+	for _, tooth := range dentalTeeth {
+		if tooth.Code == code {
+			return tooth, true
+		}
+	}
+	return Tooth{}, false
+}
+
+// ToothRecord is what is known about one of a person's teeth.
+type ToothRecord struct {
+	Id          int       `json:"id"`
+	PersonId    int       `json:"personId"`
+	FamilyId    int       `json:"familyId"`
+	Tooth       string    `json:"tooth"`       // the tooth's code
+	EruptedDate time.Time `json:"eruptedDate"` // zero when not recorded
+	LostDate    time.Time `json:"lostDate"`    // zero while the tooth is in
+	Notes       string    `json:"notes"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func PackToothRecord(self *ToothRecord, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.String(&self.Tooth, buf)
+	vpack.Time(&self.EruptedDate, buf)
+	vpack.Time(&self.LostDate, buf)
+	vpack.String(&self.Notes, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var ToothRecordBkt = vbolt.Bucket(&cfg.Info, "tooth_record", vpack.FInt, PackToothRecord)
+
+// ToothRecordByPersonIndex: term = person_id, target = tooth_record_id
+var ToothRecordByPersonIndex = vbolt.Index(&cfg.Info, "tooth_record_by_person", vpack.FInt, vpack.FInt)
+
+// ToothRecordByFamilyIndex: term = family_id, target = tooth_record_id
+var ToothRecordByFamilyIndex = vbolt.Index(&cfg.Info, "tooth_record_by_family", vpack.FInt, vpack.FInt)
+
+// Request/Response types
+
+type GetDentalChartRequest struct {
+	PersonId int `json:"personId"`
+}
+
+// ChartTooth is a tooth on the chart, with its record when there is one.
+type ChartTooth struct {
+	Tooth
+	Record *ToothRecord `json:"record,omitempty"`
+}
+
+type GetDentalChartResponse struct {
+	Primary   []ChartTooth `json:"primary"`   // A to T
+	Permanent []ChartTooth `json:"permanent"` // 1 to 32
+	InMouth   int          `json:"inMouth"`   // erupted and not lost
+	Lost      int          `json:"lost"`
+}
+
+type SetToothRequest struct {
+	PersonId    int    `json:"personId"`
+	Tooth       string `json:"tooth"`
+	EruptedDate string `json:"eruptedDate,omitempty"` // YYYY-MM-DD
+	LostDate    string `json:"lostDate,omitempty"`    // YYYY-MM-DD
+	Notes       string `json:"notes,omitempty"`
+}
+
+type SetToothResponse struct {
+	Record ToothRecord `json:"record"`
+	// Milestones are the first-tooth milestones this save filed, if any.
+	Milestones []Milestone `json:"milestones,omitempty"`
+}
+
+type ClearToothRequest struct {
+	PersonId int    `json:"personId"`
+	Tooth    string `json:"tooth"`
+}
+
+type ClearToothResponse struct {
+	Success bool `json:"success"`
+}
+
+// Database helper functions
+
+// GetPersonToothRecords returns the person's tooth records in no particular
+// order.
+func GetPersonToothRecords(tx *vbolt.Tx, personId int) []ToothRecord {
+	return readByTerm(tx, ToothRecordByPersonIndex, ToothRecordBkt, personId)
+}
+
+func getFamilyToothRecords(tx *vbolt.Tx, familyId int) []ToothRecord {
+	return readByTerm(tx, ToothRecordByFamilyIndex, ToothRecordBkt, familyId)
+}
+
+func findPersonToothRecord(tx *vbolt.Tx, personId int, code string) (ToothRecord, bool) {
+	for _, record := range GetPersonToothRecords(tx, personId) {
+		if record.Tooth == code {
+			return record, true
+		}
+	}
+	return ToothRecord{}, false
+}
+
+func writeToothRecordTx(tx *vbolt.Tx, record *ToothRecord) {
+	if record.Id == 0 {
+		record.Id = vbolt.NextIntId(tx, ToothRecordBkt)
+		record.CreatedAt = time.Now()
+	}
+	vbolt.Write(tx, ToothRecordBkt, record.Id, record)
+	vbolt.SetTargetSingleTerm(tx, ToothRecordByPersonIndex, record.Id, record.PersonId)
+	vbolt.SetTargetSingleTerm(tx, ToothRecordByFamilyIndex, record.Id, record.FamilyId)
+}
+
+func deleteToothRecordTx(tx *vbolt.Tx, record ToothRecord) {
+	vbolt.SetTargetSingleTerm(tx, ToothRecordByPersonIndex, record.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, ToothRecordByFamilyIndex, record.Id, -1)
+	vbolt.Delete(tx, ToothRecordBkt, record.Id)
+}
+
+// deleteFamilyDentalTx removes every tooth record the family owns.
+func deleteFamilyDentalTx(tx *vbolt.Tx, familyId int) {
+	for _, record := range getFamilyToothRecords(tx, familyId) {
+		deleteToothRecordTx(tx, record)
+	}
+}
+
+// moveToothRecordsTx hands the source's teeth to the target of a merge,
+// returning how many records moved. A tooth both have keeps the target's
+// record, with any date only the source knew filled in, and the source's is
+// dropped: a person has one record per tooth.
+func moveToothRecordsTx(tx *vbolt.Tx, sourceId int, targetId int) (moved int) {
+	for _, record := range GetPersonToothRecords(tx, sourceId) {
+		existing, found := findPersonToothRecord(tx, targetId, record.Tooth)
+		if !found {
+			record.PersonId = targetId
+			writeToothRecordTx(tx, &record)
+			moved++
+			continue
+		}
+		if existing.EruptedDate.IsZero() && !record.EruptedDate.IsZero() && (existing.LostDate.IsZero() || !record.EruptedDate.After(existing.LostDate)) {
+			existing.EruptedDate = record.EruptedDate
+		}
+		if existing.LostDate.IsZero() && !record.LostDate.IsZero() && !record.LostDate.Before(existing.EruptedDate) {
+			existing.LostDate = record.LostDate
+		}
+		if existing.Notes == "" {
+			existing.Notes = record.Notes
+		}
+		writeToothRecordTx(tx, &existing)
+		deleteToothRecordTx(tx, record)
+	}
+	return
+}
+
+// buildDentalChart lays the person's records over the full set of teeth.
+func buildDentalChart(records []ToothRecord) (resp GetDentalChartResponse) {
+	byTooth := make(map[string]ToothRecord, len(records))
+	for _, record := range records {
+		byTooth[record.Tooth] = record
+	}
+	resp.Primary = []ChartTooth{}
+	resp.Permanent = []ChartTooth{}
+	for _, tooth := range dentalTeeth {
+		entry := ChartTooth{Tooth: tooth}
+		if record, ok := byTooth[tooth.Code]; ok {
+			entry.Record = &record
+			if !record.LostDate.IsZero() {
+				resp.Lost++
+			} else if !record.EruptedDate.IsZero() {
+				resp.InMouth++
+			}
+		}
+		if tooth.Set == ToothPrimary {
+			resp.Primary = append(resp.Primary, entry)
+		} else {
+			resp.Permanent = append(resp.Permanent, entry)
+		}
+	}
+	return
+}
+
+// applyToothFields validates a tooth's dates against the person and copies
+// them onto the record. A date is as much a fact about the past as a health
+// record's, so the same parsing applies. Notes left empty keep the ones
+// already on the record: changing a date should not wipe them.
+func applyToothFields(record *ToothRecord, req SetToothRequest, person Person) error {
+	tooth, ok := findTooth(req.Tooth)
+	if !ok {
+		return errors.New("Unknown tooth. Use A to T for primary teeth and 1 to 32 for permanent teeth")
+	}
+	if person.IsPregnancy {
+		return errors.New("Teeth are recorded once the baby is born")
+	}
+	erupted, err := parseHealthDate(req.EruptedDate, "eruption date")
+	if err != nil {
+		return err
+	}
+	lost, err := parseHealthDate(req.LostDate, "loss date")
+	if err != nil {
+		return err
+	}
+	if erupted.IsZero() && lost.IsZero() {
+		return errors.New("An eruption or loss date is required")
+	}
+	if (!erupted.IsZero() && erupted.Before(person.Birthday)) || (!lost.IsZero() && lost.Before(person.Birthday)) {
+		return errors.New("Tooth dates cannot be before the birthdate")
+	}
+	if !erupted.IsZero() && !lost.IsZero() && lost.Before(erupted) {
+		return errors.New("A tooth cannot be lost before it came in")
+	}
+	notes := strings.TrimSpace(req.Notes)
+	if len(notes) > maxToothNotesLength {
+		return errors.New("Notes must be at most 500 characters")
+	}
+
+	record.Tooth = tooth.Code
+	record.EruptedDate = erupted
+	record.LostDate = lost
+	if notes != "" {
+		record.Notes = notes
+	}
+	return nil
+}
+
+// firstPrimaryTeeth reports whether any of the records, other than the one
+// with skipId, has a primary tooth in, or one out.
+func firstPrimaryTeeth(records []ToothRecord, skipId int) (anyErupted bool, anyLost bool) {
+	for _, record := range records {
+		if record.Id == skipId {
+			continue
+		}
+		if tooth, _ := findTooth(record.Tooth); tooth.Set != ToothPrimary {
+			continue
+		}
+		anyErupted = anyErupted || !record.EruptedDate.IsZero()
+		anyLost = anyLost || !record.LostDate.IsZero()
+	}
+	return
+}
+
+// setToothTx writes the tooth and files the first-tooth milestones it earns.
+func setToothTx(tx *vbolt.Tx, req SetToothRequest, person Person, familyId int) (resp SetToothResponse, err error) {
+	record, found := ToothRecord{}, false
+	if tooth, ok := findTooth(req.Tooth); ok {
+		record, found = findPersonToothRecord(tx, person.Id, tooth.Code)
+	}
+	hadErupted, hadLost := !record.EruptedDate.IsZero(), !record.LostDate.IsZero()
+	if !found {
+		record = ToothRecord{PersonId: person.Id, FamilyId: familyId}
+	}
+	if err = applyToothFields(&record, req, person); err != nil {
+		return
+	}
+
+	othersErupted, othersLost := firstPrimaryTeeth(GetPersonToothRecords(tx, person.Id), record.Id)
+	writeToothRecordTx(tx, &record)
+	resp.Record = record
+
+	tooth, _ := findTooth(record.Tooth)
+	if tooth.Set != ToothPrimary {
+		return
+	}
+	firsts := []struct {
+		earned      bool
+		date        time.Time
+		description string
+	}{
+		{!hadErupted && !othersErupted && !record.EruptedDate.IsZero(), record.EruptedDate, "First tooth: " + strings.ToLower(tooth.Name)},
+		{!hadLost && !othersLost && !record.LostDate.IsZero(), record.LostDate, "Lost first tooth: " + strings.ToLower(tooth.Name)},
+	}
+	for _, first := range firsts {
+		if !first.earned {
+			continue
+		}
+		date := first.date.Format("2006-01-02")
+		var milestone Milestone
+		milestone, err = AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId:      person.Id,
+			Description:   first.description,
+			Category:      dentalMilestoneCategory,
+			InputType:     "date",
+			MilestoneDate: &date,
+		}, person.FamilyId)
+		if err != nil {
+			return
+		}
+		resp.Milestones = append(resp.Milestones, milestone)
+	}
+	return
+}
+
+// ExportToothRecord carries PersonName for the same reason ExportMilestone
+// does.
+type ExportToothRecord struct {
+	Id          int       `json:"id"`
+	PersonId    int       `json:"personId"`
+	PersonName  string    `json:"personName"`
+	Tooth       string    `json:"tooth"`
+	EruptedDate time.Time `json:"eruptedDate"`
+	LostDate    time.Time `json:"lostDate"`
+	Notes       string    `json:"notes,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func buildDentalExport(tx *vbolt.Tx, familyId int, personNames map[int]string) []ExportToothRecord {
+	teeth := []ExportToothRecord{}
+	for _, record := range getFamilyToothRecords(tx, familyId) {
+		teeth = append(teeth, ExportToothRecord{
+			Id:          record.Id,
+			PersonId:    record.PersonId,
+			PersonName:  personNames[record.PersonId],
+			Tooth:       record.Tooth,
+			EruptedDate: record.EruptedDate,
+			LostDate:    record.LostDate,
+			Notes:       record.Notes,
+			CreatedAt:   record.CreatedAt,
+		})
+	}
+	return teeth
+}
+
+// importDental writes the file's tooth records for the people that were
+// imported, returning how many were written and how many skipped. A tooth
+// the person already has a record for is skipped rather than overwritten, and
+// no milestones are filed: the file's milestones come across on their own.
+func importDental(tx *vbolt.Tx, teeth []ExportToothRecord, familyId int, personIdMapping map[int]int) (imported int, skipped int, warnings []string) {
+	for _, source := range teeth {
+		personId, ok := personIdMapping[source.PersonId]
+		if !ok {
+			continue
+		}
+		req := SetToothRequest{PersonId: personId, Tooth: source.Tooth, Notes: source.Notes}
+		if !source.EruptedDate.IsZero() {
+			req.EruptedDate = source.EruptedDate.Format("2006-01-02")
+		}
+		if !source.LostDate.IsZero() {
+			req.LostDate = source.LostDate.Format("2006-01-02")
+		}
+		record := ToothRecord{PersonId: personId, FamilyId: familyId}
+		if err := applyToothFields(&record, req, GetPersonById(tx, personId)); err != nil {
+			skipped++
+			warnings = append(warnings, "Skipped tooth "+source.Tooth+": "+err.Error())
+			continue
+		}
+		if _, found := findPersonToothRecord(tx, personId, record.Tooth); found {
+			skipped++
+			continue
+		}
+		writeToothRecordTx(tx, &record)
+		imported++
+	}
+	return
+}
+
+// vbeam procedures
+
+func GetDentalChart(ctx *vbeam.Context, req GetDentalChartRequest) (resp GetDentalChartResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	person := GetPersonById(ctx.Tx, req.PersonId)
+	if !CanAccessPerson(ctx.Tx, user, person, ScopeGrowth, AccessView) {
+		err = errors.New("Person not found or not in your family")
+		return
+	}
+
+	resp = buildDentalChart(GetPersonToothRecords(ctx.Tx, person.Id))
+	return
+}
+
+// SetTooth records a tooth's dates, replacing what was recorded for it.
+func SetTooth(ctx *vbeam.Context, req SetToothRequest) (resp SetToothResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ActingFamilyForPerson(ctx.Tx, user, req.PersonId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	resp, err = setToothTx(ctx.Tx, req, GetPersonById(ctx.Tx, req.PersonId), familyId)
+	if err != nil {
+		return
+	}
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+// ClearTooth removes what was recorded for a tooth. A milestone it filed
+// stays; it is the family's to delete.
+func ClearTooth(ctx *vbeam.Context, req ClearToothRequest) (resp ClearToothResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if _, err = ActingFamilyForPerson(ctx.Tx, user, req.PersonId, AccessContribute); err != nil {
+		return
+	}
+	tooth, ok := findTooth(req.Tooth)
+	if !ok {
+		err = errors.New("Unknown tooth. Use A to T for primary teeth and 1 to 32 for permanent teeth")
+		return
+	}
+	record, found := findPersonToothRecord(ctx.Tx, req.PersonId, tooth.Code)
+	if !found {
+		err = errors.New("Nothing is recorded for this tooth")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	deleteToothRecordTx(ctx.Tx, record)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Success = true
+	return
+}
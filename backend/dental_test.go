@@ -0,0 +1,243 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func TestDentalTeethNumbering(t *testing.T) {
+	if len(dentalTeeth) != 52 {
+		t.Fatalf("%d teeth, want 20 primary and 32 permanent", len(dentalTeeth))
+	}
+	want := map[string]string{
+		"A":  "Upper right second molar",
+		"E":  "Upper right central incisor",
+		"F":  "Upper left central incisor",
+		"J":  "Upper left second molar",
+		"K":  "Lower left second molar",
+		"T":  "Lower right second molar",
+		"1":  "Upper right third molar",
+		"8":  "Upper right central incisor",
+		"16": "Upper left third molar",
+		"17": "Lower left third molar",
+		"30": "Lower right first molar",
+		"32": "Lower right third molar",
+	}
+	for code, name := range want {
+		if tooth, ok := findTooth(code); !ok || tooth.Name != name {
+			t.Errorf("tooth %s = %+v, want %q", code, tooth, name)
+		}
+	}
+	if tooth, ok := findTooth(" o "); !ok || tooth.Code != "O" || tooth.Set != ToothPrimary {
+		t.Errorf("lowercase letter found %+v", tooth)
+	}
+	if tooth, ok := findTooth("08"); !ok || tooth.Code != "8" {
+		t.Errorf("zero-padded number found %+v", tooth)
+	}
+	for _, code := range []string{"U", "0", "33", ""} {
+		if _, ok := findTooth(code); ok {
+			t.Errorf("found a tooth %q", code)
+		}
+	}
+}
+
+func TestApplyToothFields(t *testing.T) {
+	person := Person{Birthday: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cases := []struct {
+		name    string
+		req     SetToothRequest
+		wantErr bool
+	}{
+		{"erupted", SetToothRequest{Tooth: "O", EruptedDate: "2020-08-01"}, false},
+		{"lost without an eruption date", SetToothRequest{Tooth: "O", LostDate: "2026-03-01"}, false},
+		{"unknown tooth", SetToothRequest{Tooth: "Z", EruptedDate: "2020-08-01"}, true},
+		{"no dates", SetToothRequest{Tooth: "O", Notes: "wobbly"}, true},
+		{"before birth", SetToothRequest{Tooth: "O", EruptedDate: "2019-12-01"}, true},
+		{"lost before it came in", SetToothRequest{Tooth: "O", EruptedDate: "2020-08-01", LostDate: "2020-07-01"}, true},
+		{"in the future", SetToothRequest{Tooth: "O", EruptedDate: "2999-01-01"}, true},
+	}
+	for _, tc := range cases {
+		var record ToothRecord
+		if err := applyToothFields(&record, tc.req, person); (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+
+	person.IsPregnancy = true
+	var record ToothRecord
+	if err := applyToothFields(&record, SetToothRequest{Tooth: "O", EruptedDate: "2020-08-01"}, person); err == nil {
+		t.Error("recorded a tooth for a pregnancy")
+	}
+}
+
+func TestSetToothFilesTheFirstsOnce(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("dental-test-secret-key-at-least-32-bytes")
+
+	setTooth := func(req SetToothRequest) SetToothResponse {
+		t.Helper()
+		var resp SetToothResponse
+		callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+			var err error
+			req.PersonId = fx.alice.Id
+			if resp, err = SetTooth(ctx, req); err != nil {
+				t.Fatalf("SetTooth(%+v) error = %v", req, err)
+			}
+		})
+		return resp
+	}
+
+	// A six-year molar is not a first tooth.
+	if resp := setTooth(SetToothRequest{Tooth: "19", EruptedDate: "2024-05-01"}); len(resp.Milestones) != 0 {
+		t.Errorf("a permanent tooth filed %+v", resp.Milestones)
+	}
+
+	first := setTooth(SetToothRequest{Tooth: "O", EruptedDate: "2018-11-02"})
+	if len(first.Milestones) != 1 || first.Milestones[0].Description != "First tooth: lower left central incisor" ||
+		first.Milestones[0].Category != dentalMilestoneCategory || first.Milestones[0].MilestoneDate.Format("2006-01-02") != "2018-11-02" {
+		t.Fatalf("first tooth filed %+v", first.Milestones)
+	}
+	if resp := setTooth(SetToothRequest{Tooth: "P", EruptedDate: "2018-11-20"}); len(resp.Milestones) != 0 {
+		t.Errorf("the second tooth filed %+v", resp.Milestones)
+	}
+	// Editing the first tooth's notes files nothing new either.
+	if resp := setTooth(SetToothRequest{Tooth: "o", EruptedDate: "2018-11-02", Notes: "at grandma's"}); len(resp.Milestones) != 0 {
+		t.Errorf("editing the first tooth filed %+v", resp.Milestones)
+	}
+
+	lost := setTooth(SetToothRequest{Tooth: "P", EruptedDate: "2018-11-20", LostDate: "2024-09-01"})
+	if len(lost.Milestones) != 1 || lost.Milestones[0].Description != "Lost first tooth: lower right central incisor" {
+		t.Fatalf("first lost tooth filed %+v", lost.Milestones)
+	}
+	if resp := setTooth(SetToothRequest{Tooth: "O", EruptedDate: "2018-11-02", LostDate: "2024-10-01"}); len(resp.Milestones) != 0 {
+		t.Errorf("the second lost tooth filed %+v", resp.Milestones)
+	}
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		chart, err := GetDentalChart(ctx, GetDentalChartRequest{PersonId: fx.alice.Id})
+		if err != nil {
+			t.Fatalf("GetDentalChart() error = %v", err)
+		}
+		if len(chart.Primary) != 20 || len(chart.Permanent) != 32 {
+			t.Fatalf("chart has %d primary and %d permanent teeth", len(chart.Primary), len(chart.Permanent))
+		}
+		if chart.InMouth != 1 || chart.Lost != 2 {
+			t.Errorf("in mouth %d, lost %d; want 1 and 2", chart.InMouth, chart.Lost)
+		}
+		if record := chart.Primary[14].Record; chart.Primary[14].Code != "O" || record == nil || record.Notes != "at grandma's" {
+			t.Errorf("tooth O = %+v", chart.Primary[14])
+		}
+
+		if _, err := ClearTooth(ctx, ClearToothRequest{PersonId: fx.alice.Id, Tooth: "19"}); err != nil {
+			t.Fatalf("ClearTooth() error = %v", err)
+		}
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := ClearTooth(ctx, ClearToothRequest{PersonId: fx.alice.Id, Tooth: "19"}); err == nil {
+			t.Error("cleared a tooth with nothing recorded")
+		}
+	})
+
+	// The chart goes with growth: B's default link does not carry it, and a
+	// link that does is still read-only.
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := GetDentalChart(ctx, GetDentalChartRequest{PersonId: fx.alice.Id}); err == nil {
+			t.Error("a link without growth read alice's teeth")
+		}
+	})
+	setLinkScopes(t, fx, fx.linkAB, LinkScopes{Growth: true})
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := GetDentalChart(ctx, GetDentalChartRequest{PersonId: fx.alice.Id}); err != nil {
+			t.Errorf("GetDentalChart() through a growth link error = %v", err)
+		}
+		if _, err := SetTooth(ctx, SetToothRequest{PersonId: fx.alice.Id, Tooth: "E", EruptedDate: "2019-01-01"}); err == nil {
+			t.Error("a linked family recorded a tooth")
+		}
+	})
+}
+
+func TestMergeAndUnmergeTeeth(t *testing.T) {
+	fx := setupMergeFixture(t)
+	var shared, own ToothRecord
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		kept := ToothRecord{PersonId: fx.child.Id, FamilyId: fx.parent.FamilyId, Tooth: "O", LostDate: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)}
+		writeToothRecordTx(tx, &kept)
+		shared = ToothRecord{PersonId: fx.duplicate.Id, FamilyId: fx.parent.FamilyId, Tooth: "O", EruptedDate: time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC), Notes: "first!"}
+		writeToothRecordTx(tx, &shared)
+		own = ToothRecord{PersonId: fx.duplicate.Id, FamilyId: fx.parent.FamilyId, Tooth: "P", EruptedDate: time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)}
+		writeToothRecordTx(tx, &own)
+		vbolt.TxCommit(tx)
+	})
+
+	merged := fx.merge(t)
+	if merged.MergedTeeth != 1 {
+		t.Errorf("MergedTeeth = %d, want 1", merged.MergedTeeth)
+	}
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		records := GetPersonToothRecords(tx, fx.child.Id)
+		if len(records) != 2 {
+			t.Fatalf("the target has %d tooth records, want 2", len(records))
+		}
+		o, _ := findPersonToothRecord(tx, fx.child.Id, "O")
+		if o.EruptedDate.IsZero() || o.LostDate.IsZero() || o.Notes != "first!" {
+			t.Errorf("the kept record was not filled in: %+v", o)
+		}
+	})
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		if _, err := UnmergePeople(ctx, UnmergePeopleRequest{MergeId: merged.MergeId}); err != nil {
+			t.Fatalf("UnmergePeople() error = %v", err)
+		}
+	})
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		p, _ := findPersonToothRecord(tx, fx.duplicate.Id, "P")
+		if p.Id != own.Id {
+			t.Errorf("the moved tooth did not come back: %+v", p)
+		}
+		o, found := findPersonToothRecord(tx, fx.duplicate.Id, "O")
+		if !found || !o.EruptedDate.Equal(shared.EruptedDate) || !o.LostDate.IsZero() || o.Notes != "first!" {
+			t.Errorf("the dropped tooth was not made again: %+v", o)
+		}
+		if len(GetPersonToothRecords(tx, fx.child.Id)) != 1 {
+			t.Error("the target kept the tooth that went back")
+		}
+	})
+}
+
+func TestDentalExportRoundTrip(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if _, err := setToothTx(tx, SetToothRequest{PersonId: fx.alice.Id, Tooth: "E", EruptedDate: "2018-12-01"}, fx.alice, fx.famA); err != nil {
+			t.Fatalf("setToothTx() error = %v", err)
+		}
+		exportData, err := buildExportData(tx, fx.famA)
+		if err != nil {
+			t.Fatalf("buildExportData() error = %v", err)
+		}
+		if exportData.TotalTeeth != 1 || exportData.Teeth[0].Tooth != "E" || exportData.Teeth[0].PersonName != "Alice" {
+			t.Fatalf("exported teeth %+v", exportData.Teeth)
+		}
+
+		copyOfAlice, err := AddPersonTx(tx, AddPersonRequest{Name: "Alice", PersonType: int(Child), Gender: 1, Birthdate: "2018-04-01"}, fx.famB)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		mapping := map[int]int{fx.alice.Id: copyOfAlice.Id}
+		if imported, skipped, warnings := importDental(tx, exportData.Teeth, fx.famB, mapping); imported != 1 || skipped != 0 || len(warnings) != 0 {
+			t.Errorf("first import = %d imported, %d skipped, warnings %v", imported, skipped, warnings)
+		}
+		if imported, skipped, _ := importDental(tx, exportData.Teeth, fx.famB, mapping); imported != 0 || skipped != 1 {
+			t.Errorf("second import = %d imported, %d skipped", imported, skipped)
+		}
+		// The file's milestones come across on their own; the import files none.
+		if milestones := GetPersonMilestonesTx(tx, copyOfAlice.Id); len(milestones) != 0 {
+			t.Errorf("importing teeth filed %+v", milestones)
+		}
+	})
+}
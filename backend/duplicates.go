@@ -0,0 +1,683 @@
+// Finding and merging duplicate photos.
+//
+// Two hashes, because there are two ways a photo arrives twice. Uploading the
+// same camera roll again produces byte-identical files, which a SHA-256 of the
+// original catches exactly and cheaply — cheaply enough that the upload handler
+// computes it itself and can answer "this looks like photo #123" before the
+// worker has touched the file. A copy that went through a messaging app or an
+// editor is resized and re-encoded, and no two of its bytes need agree with the
+// original; for those the worker computes a difference hash of the thumbnail,
+// 64 bits that say which way the brightness runs across a 9x8 grid, and two
+// photos a few bits apart are the same picture.
+//
+// Only the content hash is indexed. Its term is the family id and the hash
+// encoded together, by hand, because vbolt terms are single values and a hash
+// is only a duplicate within one family. A perceptual match is a distance, not
+// an equality, so no term could find one; the cluster listing compares the
+// family's photos pairwise instead, which is fine at the size of one family's
+// library.
+//
+// Merging keeps one photo and folds the others into it: the people tagged, the
+// tags, and every milestone, measurement, activity, school year and album that
+// pointed at a duplicate now point at the one kept, along with profile photos
+// and comment threads. Then the duplicates are deleted like any other photo.
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"family/cfg"
+	"fmt"
+	"io"
+	"log"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+func RegisterDuplicateMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, ListDuplicatePhotos)
+	vbeam.RegisterProc(app, MergeDuplicatePhotos)
+	vbeam.RegisterProc(app, BackfillPhotoHashes)
+}
+
+var ErrPhotoNotFound = errors.New("Photo not found or access denied")
+var ErrNothingToMerge = errors.New("Choose at least one duplicate to merge")
+var ErrMergePhotoIntoItself = errors.New("A photo cannot be merged into itself")
+var ErrMergeMediaKinds = errors.New("A video and a photo cannot be merged")
+
+// nearDuplicateDistance is how many of the 64 perceptual bits two photos may
+// differ in and still be called the same picture. Re-encoding and resizing
+// typically move two or three; a different shot from the same burst moves
+// well over ten.
+const nearDuplicateDistance = 6
+
+const (
+	DuplicateKindExact   = "exact"
+	DuplicateKindSimilar = "similar"
+)
+
+// ImageByContentHashIndex: term = "family_id:sha256", target = image_id
+var ImageByContentHashIndex = vbolt.Index(&cfg.Info, "image_by_content_hash", vpack.StringZ, vpack.FInt)
+
+// ── hashing ───────────────────────────────────────────────────────────────────
+
+// photoHashes is what the worker learned about a photo, to be stored when it
+// marks the photo complete. An empty field is one it could not compute.
+type photoHashes struct {
+	Content    string
+	Perceptual string
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func contentHashOfReader(reader io.Reader) (string, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func contentHashOfFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return contentHashOfReader(file)
+}
+
+// perceptualHash is a difference hash: the image shrunk to 9x8 in grey, and
+// one bit per pair of horizontal neighbours saying whether the left is the
+// brighter. It survives resizing, recompression and small colour shifts, and
+// it is sixteen hex digits so it travels in JSON without losing precision.
+func perceptualHash(data []byte) (string, error) {
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return "", err
+	}
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.Pix[small.PixOffset(x, y)] > small.Pix[small.PixOffset(x+1, y)] {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+// parsePerceptualHash reports false for a photo that has no usable hash.
+func parsePerceptualHash(hash string) (uint64, bool) {
+	if hash == "" {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(hash, 16, 64)
+	return value, err == nil
+}
+
+// hashProcessedPhoto hashes a job once its variants exist. The content hash is
+// of the original — from memory for a photo, from disk for a clip, whose job
+// carries no bytes — and the perceptual hash is of the JPEG thumbnail, which
+// is already decoded, upright and small, and exists for HEIC photos and video
+// posters alike.
+func hashProcessedPhoto(job PhotoProcessingJob, processedImages map[string][]byte) (hashes photoHashes) {
+	if len(job.FileData) > 0 {
+		hashes.Content = contentHash(job.FileData)
+	} else if hash, err := contentHashOfFile(filepath.Join(cfg.StaticDir, originalMediaPath(job.FilePath))); err == nil {
+		hashes.Content = hash
+	} else {
+		log.Printf("[PHOTO_PROCESSING] Could not hash the original of photo %d: %v", job.ImageId, err)
+	}
+	if thumb, ok := processedImages["thumb_jpeg"]; ok {
+		if hash, err := perceptualHash(thumb); err == nil {
+			hashes.Perceptual = hash
+		}
+	}
+	return
+}
+
+// applyPhotoHashes fills in whatever was computed, leaving the rest as it was.
+func applyPhotoHashes(image *Image, hashes photoHashes) {
+	if hashes.Content != "" {
+		image.ContentHash = hashes.Content
+	}
+	if hashes.Perceptual != "" {
+		image.PerceptualHash = hashes.Perceptual
+	}
+}
+
+// ── index ─────────────────────────────────────────────────────────────────────
+
+func contentHashTerm(familyId int, hash string) string {
+	return strconv.Itoa(familyId) + ":" + hash
+}
+
+// setImageContentHashTx indexes the image under its content hash. Call it
+// after every write that may have set or changed the hash.
+func setImageContentHashTx(tx *vbolt.Tx, image Image) {
+	if image.ContentHash == "" {
+		clearImageContentHashTx(tx, image.Id)
+		return
+	}
+	vbolt.SetTargetSingleTerm(tx, ImageByContentHashIndex, image.Id, contentHashTerm(image.FamilyId, image.ContentHash))
+}
+
+func clearImageContentHashTx(tx *vbolt.Tx, imageId int) {
+	vbolt.SetTargetTermsUniform(tx, ImageByContentHashIndex, imageId, []string{}, 0)
+}
+
+// findPhotoByContentHash returns the family's earliest photo with these exact
+// bytes, or 0. Rows are read back rather than trusted from the index, so a
+// photo deleted in this same transaction is not reported.
+func findPhotoByContentHash(tx *vbolt.Tx, familyId int, hash string) int {
+	if hash == "" {
+		return 0
+	}
+	var imageIds []int
+	vbolt.ReadTermTargets(tx, ImageByContentHashIndex, contentHashTerm(familyId, hash), &imageIds, vbolt.Window{})
+	sort.Ints(imageIds)
+	for _, imageId := range imageIds {
+		if image := GetImageById(tx, imageId); image.Id != 0 && image.ContentHash == hash {
+			return image.Id
+		}
+	}
+	return 0
+}
+
+// ── clusters ──────────────────────────────────────────────────────────────────
+
+// DuplicateCluster is a set of photos that look like one picture. KeepId is a
+// suggestion — the largest, then the oldest — and the photos come keeper
+// first, then by id.
+type DuplicateCluster struct {
+	Kind   string  `json:"kind"` // "exact" when every photo has the same bytes, else "similar"
+	KeepId int     `json:"keepId"`
+	Photos []Image `json:"photos"`
+}
+
+// findDuplicateClusters groups the photos that share a content hash or sit
+// within nearDuplicateDistance of each other. Similarity is taken as
+// transitive, so a chain of close copies is one cluster. A photo that failed
+// processing is left out, and a clip is only ever compared with other clips.
+func findDuplicateClusters(images []Image) []DuplicateCluster {
+	var candidates []Image
+	for _, image := range images {
+		if image.Status != 2 {
+			candidates = append(candidates, image)
+		}
+	}
+
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(a, b int) {
+		parent[find(a)] = find(b)
+	}
+
+	byContent := make(map[string]int)
+	hashes := make([]uint64, len(candidates))
+	hashed := make([]bool, len(candidates))
+	for i, image := range candidates {
+		if image.ContentHash != "" {
+			if first, seen := byContent[image.ContentHash]; seen {
+				union(i, first)
+			} else {
+				byContent[image.ContentHash] = i
+			}
+		}
+		hashes[i], hashed[i] = parsePerceptualHash(image.PerceptualHash)
+	}
+	for i := range candidates {
+		if !hashed[i] {
+			continue
+		}
+		for j := i + 1; j < len(candidates); j++ {
+			if hashed[j] && candidates[i].MediaKind == candidates[j].MediaKind &&
+				bits.OnesCount64(hashes[i]^hashes[j]) <= nearDuplicateDistance {
+				union(i, j)
+			}
+		}
+	}
+
+	groups := make(map[int][]Image)
+	for i, image := range candidates {
+		root := find(i)
+		groups[root] = append(groups[root], image)
+	}
+
+	clusters := []DuplicateCluster{}
+	for _, photos := range groups {
+		if len(photos) < 2 {
+			continue
+		}
+		keep := suggestedKeeper(photos)
+		sort.Slice(photos, func(i, j int) bool {
+			if (photos[i].Id == keep) != (photos[j].Id == keep) {
+				return photos[i].Id == keep
+			}
+			return photos[i].Id < photos[j].Id
+		})
+		kind := DuplicateKindExact
+		for _, photo := range photos {
+			if photo.ContentHash == "" || photo.ContentHash != photos[0].ContentHash {
+				kind = DuplicateKindSimilar
+				break
+			}
+		}
+		clusters = append(clusters, DuplicateCluster{Kind: kind, KeepId: keep, Photos: photos})
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].KeepId < clusters[j].KeepId })
+	return clusters
+}
+
+// suggestedKeeper prefers the copy with the most pixels, since a re-encoded
+// copy is usually the smaller one, and among equals the one uploaded first.
+func suggestedKeeper(photos []Image) int {
+	best := photos[0]
+	for _, photo := range photos[1:] {
+		pixels, bestPixels := photo.Width*photo.Height, best.Width*best.Height
+		if pixels > bestPixels || (pixels == bestPixels && photo.Id < best.Id) {
+			best = photo
+		}
+	}
+	return best.Id
+}
+
+// ── merging ───────────────────────────────────────────────────────────────────
+
+// mergePhotoIntoTx moves everything that points at duplicate onto keep, then
+// deletes duplicate's record. A join keep already has — the same person, the
+// same milestone — is left on the duplicate and goes with it, so nothing ends
+// up linked twice. Rows are repointed rather than recreated, which keeps an
+// album position, a tag's auto-tagged flag and a comment's date as they were.
+// The files are the caller's to delete once the transaction has committed.
+func mergePhotoIntoTx(tx *vbolt.Tx, keep *Image, duplicate Image) {
+	people := make(map[int]bool)
+	for _, photoPerson := range GetPhotoPersonsByPhoto(tx, keep.Id) {
+		people[photoPerson.PersonId] = true
+	}
+	for _, photoPerson := range GetPhotoPersonsByPhoto(tx, duplicate.Id) {
+		if people[photoPerson.PersonId] {
+			continue
+		}
+		people[photoPerson.PersonId] = true
+		photoPerson.PhotoId = keep.Id
+		vbolt.Write(tx, PhotoPersonBkt, photoPerson.Id, &photoPerson)
+		vbolt.SetTargetSingleTerm(tx, PhotoPersonByPhotoIndex, photoPerson.Id, keep.Id)
+	}
+
+	tags := make(map[int]bool)
+	for _, tagId := range GetPhotoTagIds(tx, keep.Id) {
+		tags[tagId] = true
+	}
+	for _, photoTag := range readByTerm(tx, PhotoTagByPhotoIndex, PhotoTagBkt, duplicate.Id) {
+		if tags[photoTag.TagId] {
+			continue
+		}
+		tags[photoTag.TagId] = true
+		photoTag.PhotoId = keep.Id
+		vbolt.Write(tx, PhotoTagBkt, photoTag.Id, &photoTag)
+		vbolt.SetTargetSingleTerm(tx, PhotoTagByPhotoIndex, photoTag.Id, keep.Id)
+	}
+
+	milestones := make(map[int]bool)
+	for _, join := range readByTerm(tx, MilestonePhotoByPhotoIndex, MilestonePhotoBkt, keep.Id) {
+		milestones[join.MilestoneId] = true
+	}
+	for _, join := range readByTerm(tx, MilestonePhotoByPhotoIndex, MilestonePhotoBkt, duplicate.Id) {
+		if milestones[join.MilestoneId] {
+			continue
+		}
+		milestones[join.MilestoneId] = true
+		join.PhotoId = keep.Id
+		vbolt.Write(tx, MilestonePhotoBkt, join.Id, &join)
+		vbolt.SetTargetSingleTerm(tx, MilestonePhotoByPhotoIndex, join.Id, keep.Id)
+	}
+
+	measurements := make(map[int]bool)
+	for _, join := range readByTerm(tx, GrowthPhotoByPhotoIndex, GrowthPhotoBkt, keep.Id) {
+		measurements[join.GrowthDataId] = true
+	}
+	for _, join := range readByTerm(tx, GrowthPhotoByPhotoIndex, GrowthPhotoBkt, duplicate.Id) {
+		if measurements[join.GrowthDataId] {
+			continue
+		}
+		measurements[join.GrowthDataId] = true
+		join.PhotoId = keep.Id
+		vbolt.Write(tx, GrowthPhotoBkt, join.Id, &join)
+		vbolt.SetTargetSingleTerm(tx, GrowthPhotoByPhotoIndex, join.Id, keep.Id)
+	}
+
+	appearances := make(map[int]bool)
+	for _, join := range readByTerm(tx, AppearancePhotoByPhotoIndex, AppearancePhotoBkt, keep.Id) {
+		appearances[join.AppearanceId] = true
+	}
+	for _, join := range readByTerm(tx, AppearancePhotoByPhotoIndex, AppearancePhotoBkt, duplicate.Id) {
+		if appearances[join.AppearanceId] {
+			continue
+		}
+		appearances[join.AppearanceId] = true
+		join.PhotoId = keep.Id
+		writeAppearancePhotoTx(tx, &join)
+	}
+
+	events := make(map[int]bool)
+	for _, join := range readByTerm(tx, EventPhotoByPhotoIndex, EventPhotoBkt, keep.Id) {
+		events[join.EventId] = true
+	}
+	for _, join := range readByTerm(tx, EventPhotoByPhotoIndex, EventPhotoBkt, duplicate.Id) {
+		if events[join.EventId] {
+			continue
+		}
+		events[join.EventId] = true
+		join.PhotoId = keep.Id
+		writeEventPhotoTx(tx, &join)
+	}
+
+	schoolYears := make(map[int]bool)
+	for _, join := range readByTerm(tx, SchoolYearPhotoByPhotoIndex, SchoolYearPhotoBkt, keep.Id) {
+		schoolYears[join.SchoolYearId] = true
+	}
+	for _, join := range readByTerm(tx, SchoolYearPhotoByPhotoIndex, SchoolYearPhotoBkt, duplicate.Id) {
+		if schoolYears[join.SchoolYearId] {
+			continue
+		}
+		schoolYears[join.SchoolYearId] = true
+		join.PhotoId = keep.Id
+		writeSchoolYearPhotoTx(tx, &join)
+	}
+
+	albums := make(map[int]bool)
+	for _, join := range GetPhotoAlbumJoins(tx, keep.Id) {
+		albums[join.AlbumId] = true
+	}
+	for _, join := range GetPhotoAlbumJoins(tx, duplicate.Id) {
+		album := GetAlbumById(tx, join.AlbumId)
+		if album.Id != 0 && album.CoverPhotoId == duplicate.Id {
+			album.CoverPhotoId = keep.Id
+			writeAlbumTx(tx, &album)
+		}
+		if albums[join.AlbumId] {
+			continue
+		}
+		albums[join.AlbumId] = true
+		join.PhotoId = keep.Id
+		writeAlbumPhotoTx(tx, &join)
+	}
+
+	for _, person := range GetFamilyOwnPeople(tx, duplicate.FamilyId) {
+		if person.ProfilePhotoId == duplicate.Id {
+			person.ProfilePhotoId = keep.Id
+			vbolt.Write(tx, PeopleBkt, person.Id, &person)
+		}
+	}
+
+	mergeCommentThreadTx(tx, keep.Id, duplicate.Id)
+
+	if keep.Description == "" {
+		keep.Description = duplicate.Description
+	}
+	// Whoever hid one copy's location meant the picture's, so a hidden
+	// location only moves across to a photo that can keep it hidden.
+	if duplicate.LocationHidden && canStripLocation(*keep) {
+		keep.LocationHidden = true
+	}
+	if !keep.HasLocation && duplicate.HasLocation && (!duplicate.LocationHidden || keep.LocationHidden) {
+		setPhotoLocation(keep, duplicate.Latitude, duplicate.Longitude)
+	}
+	if keep.CameraMake == "" && keep.CameraModel == "" {
+		keep.CameraMake = duplicate.CameraMake
+		keep.CameraModel = duplicate.CameraModel
+		keep.LensModel = duplicate.LensModel
+	}
+	deletePhotoRecordTx(tx, duplicate)
+}
+
+// mergeCommentThreadTx moves the duplicate's comments onto the kept photo,
+// where they interleave by id with its own. A reaction the same person already
+// left on the kept photo stays behind and is deleted with the duplicate.
+func mergeCommentThreadTx(tx *vbolt.Tx, keepId int, duplicateId int) {
+	keepTarget := CommentTarget{Kind: CommentTargetPhoto, Id: keepId}
+	duplicateTarget := CommentTarget{Kind: CommentTargetPhoto, Id: duplicateId}
+
+	for _, comment := range GetTargetComments(tx, duplicateTarget) {
+		comment.TargetId = keepId
+		vbolt.Write(tx, CommentBkt, comment.Id, &comment)
+		vbolt.SetTargetSingleTerm(tx, CommentByPhotoIndex, comment.Id, keepId)
+	}
+
+	reacted := make(map[string]bool)
+	for _, reaction := range GetTargetReactions(tx, keepTarget) {
+		reacted[strconv.Itoa(reaction.UserId)+reaction.Emoji] = true
+	}
+	for _, reaction := range GetTargetReactions(tx, duplicateTarget) {
+		key := strconv.Itoa(reaction.UserId) + reaction.Emoji
+		if reacted[key] {
+			continue
+		}
+		reacted[key] = true
+		reaction.TargetId = keepId
+		vbolt.Write(tx, ReactionBkt, reaction.Id, &reaction)
+		vbolt.SetTargetSingleTerm(tx, ReactionByPhotoIndex, reaction.Id, keepId)
+	}
+}
+
+// ── procs ─────────────────────────────────────────────────────────────────────
+
+type ListDuplicatePhotosRequest struct {
+	FamilyId int `json:"familyId,omitempty"` // 0 = the user's primary family
+}
+
+type ListDuplicatePhotosResponse struct {
+	Clusters []DuplicateCluster `json:"clusters"`
+	// Unhashed counts photos with no perceptual hash yet — uploaded before
+	// hashing existed, or imported from a bundle — which can only be matched
+	// exactly, if at all, until BackfillPhotoHashes has run.
+	Unhashed int `json:"unhashed"`
+}
+
+func ListDuplicatePhotos(ctx *vbeam.Context, req ListDuplicatePhotosRequest) (resp ListDuplicatePhotosResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, resolveErr := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessView)
+	if resolveErr != nil {
+		err = resolveErr
+		return
+	}
+
+	images := GetFamilyImages(ctx.Tx, familyId)
+	resp.Clusters = findDuplicateClusters(images)
+	for _, image := range images {
+		if image.Status != 2 && image.PerceptualHash == "" {
+			resp.Unhashed++
+		}
+	}
+	return
+}
+
+type MergeDuplicatePhotosRequest struct {
+	KeepId       int   `json:"keepId"`
+	DuplicateIds []int `json:"duplicateIds"`
+}
+
+type MergeDuplicatePhotosResponse struct {
+	Photo  Image `json:"photo"`
+	Merged int   `json:"merged"`
+}
+
+// MergeDuplicatePhotos folds the duplicates into the photo kept and deletes
+// them. It asks for the same access deleting a photo does. Whether the photos
+// really are alike is the user's call: the cluster listing only suggests.
+func MergeDuplicatePhotos(ctx *vbeam.Context, req MergeDuplicatePhotosRequest) (resp MergeDuplicatePhotosResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	duplicateIds := normalizePhotoIds(req.DuplicateIds)
+	if len(duplicateIds) == 0 {
+		err = ErrNothingToMerge
+		return
+	}
+	if len(duplicateIds) > maxPhotosPerSubject {
+		err = ErrTooManyPhotos
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+
+	keep := GetImageById(ctx.Tx, req.KeepId)
+	if keep.Id == 0 || !CanAccessFamily(ctx.Tx, user, keep.FamilyId, AccessAdmin) {
+		err = ErrPhotoNotFound
+		return
+	}
+	// Every duplicate is checked before anything moves.
+	duplicates := make([]Image, 0, len(duplicateIds))
+	for _, duplicateId := range duplicateIds {
+		if duplicateId == keep.Id {
+			err = ErrMergePhotoIntoItself
+			return
+		}
+		duplicate := GetImageById(ctx.Tx, duplicateId)
+		if duplicate.Id == 0 || duplicate.FamilyId != keep.FamilyId {
+			err = ErrPhotoNotFound
+			return
+		}
+		if duplicate.MediaKind != keep.MediaKind {
+			err = ErrMergeMediaKinds
+			return
+		}
+		duplicates = append(duplicates, duplicate)
+	}
+
+	for _, duplicate := range duplicates {
+		mergePhotoIntoTx(ctx.Tx, &keep, duplicate)
+	}
+	vbolt.Write(ctx.Tx, ImagesBkt, keep.Id, &keep)
+	keep.TagIds = GetPhotoTagIds(ctx.Tx, keep.Id)
+
+	vbolt.TxCommit(ctx.Tx)
+
+	for _, duplicate := range duplicates {
+		if fileErr := deletePhotoFiles(duplicate); fileErr != nil {
+			log.Printf("Warning: Failed to delete files for merged photo %d: %v", duplicate.Id, fileErr)
+		}
+	}
+
+	resp.Photo = keep
+	resp.Merged = len(duplicates)
+	return
+}
+
+type BackfillPhotoHashesResponse struct {
+	Hashed    int      `json:"hashed"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+	TotalTime string   `json:"totalTime"`
+}
+
+// BackfillPhotoHashes hashes every photo that is missing a hash: those
+// uploaded before hashing existed and those restored from a bundle, which
+// never pass through the worker. It reads the files the worker left, the
+// original for the content hash and the thumbnail for the perceptual one,
+// falling back to the original for a photo that has no thumbnail.
+func BackfillPhotoHashes(ctx *vbeam.Context, req Empty) (resp BackfillPhotoHashesResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	// Check if user is admin (ID == 1)
+	if user.Id != 1 {
+		err = errors.New("Unauthorized: Admin access required")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	startTime := time.Now()
+
+	var photosToHash []Image
+	vbolt.IterateAll(ctx.Tx, ImagesBkt, func(key int, image Image) bool {
+		// A photo still processing will be hashed by the worker.
+		if image.Status != 1 && (image.ContentHash == "" || image.PerceptualHash == "") {
+			photosToHash = append(photosToHash, image)
+		}
+		return true
+	})
+
+	for _, photo := range photosToHash {
+		hashes, hashErr := hashStoredPhoto(photo)
+		if hashErr != nil {
+			resp.Failed++
+			resp.Errors = append(resp.Errors, fmt.Sprintf("Photo %d: %v", photo.Id, hashErr))
+		}
+		if hashes == (photoHashes{}) {
+			continue
+		}
+		applyPhotoHashes(&photo, hashes)
+		vbolt.Write(ctx.Tx, ImagesBkt, photo.Id, &photo)
+		setImageContentHashTx(ctx.Tx, photo)
+		resp.Hashed++
+	}
+
+	vbolt.TxCommit(ctx.Tx)
+	resp.TotalTime = time.Since(startTime).String()
+	return
+}
+
+// hashStoredPhoto computes whichever hashes photo is missing from its files on
+// disk. What it could compute is returned even alongside an error.
+func hashStoredPhoto(photo Image) (hashes photoHashes, err error) {
+	originalPath := getOriginalPhotoPath(photo)
+	if photo.ContentHash == "" {
+		if hashes.Content, err = contentHashOfFile(originalPath); err != nil {
+			return
+		}
+	}
+	if photo.PerceptualHash == "" {
+		basePath := filepath.Join(cfg.StaticDir, photo.FilePath)
+		thumbPath := strings.TrimSuffix(basePath, filepath.Ext(basePath)) + "_thumb.jpg"
+		data, readErr := os.ReadFile(thumbPath)
+		if readErr != nil && photo.MediaKind != MediaKindVideo {
+			data, readErr = os.ReadFile(originalPath)
+		}
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		hashes.Perceptual, err = perceptualHash(data)
+	}
+	return
+}
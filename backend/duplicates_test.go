@@ -0,0 +1,284 @@
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"family/cfg"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+// createPatternImage draws blocks of uneven brightness, which unlike a smooth
+// gradient gives a difference hash something to say.
+func createPatternImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := uint8((x*8/width*53 + y*8/height*97) % 256)
+			img.Set(x, y, color.RGBA{value, value / 2, 255 - value, 255})
+		}
+	}
+	return img
+}
+
+func perceptualDistance(t *testing.T, a, b string) int {
+	t.Helper()
+	x, okA := parsePerceptualHash(a)
+	y, okB := parsePerceptualHash(b)
+	if !okA || !okB {
+		t.Fatalf("unparseable hashes %q, %q", a, b)
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+func TestPerceptualHashMatchesResizedCopies(t *testing.T) {
+	original := createPatternImage(800, 600)
+	var pngBytes, jpegBytes bytes.Buffer
+	png.Encode(&pngBytes, original)
+	jpeg.Encode(&jpegBytes, imaging.Resize(original, 320, 0, imaging.Lanczos), &jpeg.Options{Quality: 60})
+
+	originalHash, err := perceptualHash(pngBytes.Bytes())
+	if err != nil {
+		t.Fatalf("perceptualHash() error = %v", err)
+	}
+	copyHash, err := perceptualHash(jpegBytes.Bytes())
+	if err != nil {
+		t.Fatalf("perceptualHash() error = %v", err)
+	}
+	if distance := perceptualDistance(t, originalHash, copyHash); distance > nearDuplicateDistance {
+		t.Errorf("resized copy is %d bits away (%s vs %s)", distance, originalHash, copyHash)
+	}
+
+	var mirrored bytes.Buffer
+	png.Encode(&mirrored, imaging.FlipH(original))
+	mirroredHash, _ := perceptualHash(mirrored.Bytes())
+	if distance := perceptualDistance(t, originalHash, mirroredHash); distance <= nearDuplicateDistance {
+		t.Errorf("a different picture is only %d bits away", distance)
+	}
+
+	if _, err := perceptualHash([]byte("not an image")); err == nil {
+		t.Error("hashed bytes that are not an image")
+	}
+	if contentHash(pngBytes.Bytes()) == contentHash(jpegBytes.Bytes()) || len(contentHash(nil)) != 64 {
+		t.Error("content hashes do not tell the copies apart")
+	}
+}
+
+func TestFindDuplicateClusters(t *testing.T) {
+	images := []Image{
+		{Id: 1, Width: 800, Height: 600, ContentHash: "aaa", PerceptualHash: "00000000000000ff", MediaKind: MediaKindPhoto},
+		{Id: 2, Width: 800, Height: 600, ContentHash: "aaa", PerceptualHash: "00000000000000ff", MediaKind: MediaKindPhoto},
+		// A smaller re-encode of 1, and a bigger one that is only similar.
+		{Id: 3, Width: 400, Height: 300, ContentHash: "bbb", PerceptualHash: "00000000000000fc", MediaKind: MediaKindPhoto},
+		{Id: 4, Width: 1600, Height: 1200, ContentHash: "ccc", PerceptualHash: "00000000000001ff", MediaKind: MediaKindPhoto},
+		// Exact copies whose perceptual hash is not in yet.
+		{Id: 5, ContentHash: "ddd", MediaKind: MediaKindPhoto},
+		{Id: 6, ContentHash: "ddd", MediaKind: MediaKindPhoto},
+		// Unrelated, failed, and a clip whose poster looks like photo 1.
+		{Id: 7, ContentHash: "eee", PerceptualHash: "ffffffffffffff00", MediaKind: MediaKindPhoto},
+		{Id: 8, ContentHash: "aaa", PerceptualHash: "00000000000000ff", MediaKind: MediaKindPhoto, Status: 2},
+		{Id: 9, ContentHash: "fff", PerceptualHash: "00000000000000ff", MediaKind: MediaKindVideo},
+	}
+
+	clusters := findDuplicateClusters(images)
+	if len(clusters) != 2 {
+		t.Fatalf("clusters = %+v, want 2", clusters)
+	}
+
+	similar := clusters[0]
+	ids := make([]int, 0, len(similar.Photos))
+	for _, photo := range similar.Photos {
+		ids = append(ids, photo.Id)
+	}
+	if similar.Kind != DuplicateKindSimilar || similar.KeepId != 4 || !slices.Equal(ids, []int{4, 1, 2, 3}) {
+		t.Errorf("similar cluster = %s keep %d %v", similar.Kind, similar.KeepId, ids)
+	}
+
+	exact := clusters[1]
+	if exact.Kind != DuplicateKindExact || exact.KeepId != 5 || len(exact.Photos) != 2 || exact.Photos[1].Id != 6 {
+		t.Errorf("exact cluster = %+v", exact)
+	}
+
+	if got := findDuplicateClusters(images[6:]); len(got) != 0 {
+		t.Errorf("found clusters among unrelated photos: %+v", got)
+	}
+}
+
+// Merging moves everything that pointed at the duplicate onto the photo kept,
+// without linking anything twice, and takes the duplicate out of the hash
+// index along with its record.
+func TestMergeDuplicatePhotos(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("duplicates-test-secret-key-at-least-32-bytes")
+
+	var keep, duplicate Image
+	var album Album
+	var comment Comment
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		keep = writeTestImage(tx, fx.famA, fx.userA.Id, "beach.jpg")
+		duplicate = writeTestImage(tx, fx.famA, fx.userA.Id, "beach (1).jpg")
+		duplicate.ContentHash = "duplicate-bytes"
+		duplicate.Description = "Low tide"
+		vbolt.Write(tx, ImagesBkt, duplicate.Id, &duplicate)
+		setImageContentHashTx(tx, duplicate)
+
+		tagPersonInPhoto(tx, keep.Id, fx.bob.Id, fx.famA)
+		tagPersonInPhoto(tx, duplicate.Id, fx.bob.Id, fx.famA)
+		tagPersonInPhoto(tx, duplicate.Id, fx.alice.Id, fx.famA)
+		addTagToPhoto(tx, duplicate.Id, fx.tagA.Id, fx.famA)
+		if err := addPhotoToMilestone(tx, fx.aliceMilestone.Id, duplicate.Id, fx.famA); err != nil {
+			t.Fatalf("addPhotoToMilestone() error = %v", err)
+		}
+
+		album = Album{Id: vbolt.NextIntId(tx, AlbumBkt), FamilyId: fx.famA, Title: "Beach", CreatedBy: fx.userA.Id, CreatedAt: time.Now()}
+		writeAlbumTx(tx, &album)
+		if _, err := addAlbumPhotosTx(tx, album, []int{fx.alicePhoto.Id, duplicate.Id}); err != nil {
+			t.Fatalf("addAlbumPhotosTx() error = %v", err)
+		}
+		album.CoverPhotoId = duplicate.Id
+		writeAlbumTx(tx, &album)
+
+		comment = AddCommentTx(tx, CommentTarget{Kind: CommentTargetPhoto, Id: duplicate.Id}, fx.famA, fx.userA, "Look at the waves")
+		ToggleReactionTx(tx, CommentTarget{Kind: CommentTargetPhoto, Id: keep.Id}, fx.famA, fx.userA.Id, "❤️")
+		ToggleReactionTx(tx, CommentTarget{Kind: CommentTargetPhoto, Id: duplicate.Id}, fx.famA, fx.userA.Id, "❤️")
+
+		alice := GetPersonById(tx, fx.alice.Id)
+		alice.ProfilePhotoId = duplicate.Id
+		vbolt.Write(tx, PeopleBkt, alice.Id, &alice)
+		vbolt.TxCommit(tx)
+	})
+
+	// Grandparents see alice's photos through the link but cannot delete any.
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := MergeDuplicatePhotos(ctx, MergeDuplicatePhotosRequest{KeepId: keep.Id, DuplicateIds: []int{duplicate.Id}}); err != ErrPhotoNotFound {
+			t.Errorf("linked family merging: error = %v, want ErrPhotoNotFound", err)
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := MergeDuplicatePhotos(ctx, MergeDuplicatePhotosRequest{KeepId: keep.Id, DuplicateIds: []int{keep.Id}}); err != ErrMergePhotoIntoItself {
+			t.Errorf("merging into itself: error = %v", err)
+		}
+		resp, err := MergeDuplicatePhotos(ctx, MergeDuplicatePhotosRequest{KeepId: keep.Id, DuplicateIds: []int{duplicate.Id, duplicate.Id}})
+		if err != nil {
+			t.Fatalf("MergeDuplicatePhotos() error = %v", err)
+		}
+		if resp.Merged != 1 || resp.Photo.Description != "Low tide" || !slices.Equal(resp.Photo.TagIds, []int{fx.tagA.Id}) {
+			t.Errorf("merged photo = %+v, merged %d", resp.Photo, resp.Merged)
+		}
+	})
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if GetImageById(tx, duplicate.Id).Id != 0 {
+			t.Error("the duplicate survived the merge")
+		}
+		if found := findPhotoByContentHash(tx, fx.famA, "duplicate-bytes"); found != 0 {
+			t.Errorf("the deleted duplicate is still indexed as %d", found)
+		}
+
+		var people []int
+		for _, photoPerson := range GetPhotoPersonsByPhoto(tx, keep.Id) {
+			people = append(people, photoPerson.PersonId)
+		}
+		slices.Sort(people)
+		if want := []int{fx.alice.Id, fx.bob.Id}; !slices.Equal(people, want) {
+			t.Errorf("people on the kept photo = %v, want %v", people, want)
+		}
+		if ids := GetMilestonePhotoIds(tx, fx.aliceMilestone.Id); !slices.Equal(ids, []int{keep.Id}) {
+			t.Errorf("milestone photos = %v", ids)
+		}
+		if ids := GetAlbumPhotoIds(tx, album.Id); !slices.Equal(ids, []int{fx.alicePhoto.Id, keep.Id}) {
+			t.Errorf("album photos = %v", ids)
+		}
+		if cover := GetAlbumById(tx, album.Id).CoverPhotoId; cover != keep.Id {
+			t.Errorf("album cover = %d", cover)
+		}
+		if profile := GetPersonById(tx, fx.alice.Id).ProfilePhotoId; profile != keep.Id {
+			t.Errorf("alice's profile photo = %d", profile)
+		}
+		keepTarget := CommentTarget{Kind: CommentTargetPhoto, Id: keep.Id}
+		if comments := GetTargetComments(tx, keepTarget); len(comments) != 1 || comments[0].Id != comment.Id {
+			t.Errorf("comments on the kept photo = %+v", comments)
+		}
+		if reactions := GetTargetReactions(tx, keepTarget); len(reactions) != 1 {
+			t.Errorf("the same reaction twice: %+v", reactions)
+		}
+	})
+}
+
+func TestImportBundleSkipsDuplicates(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+
+	var beach, forest bytes.Buffer
+	png.Encode(&beach, createPatternImage(64, 48))
+	png.Encode(&forest, imaging.FlipV(createPatternImage(64, 48)))
+
+	prefix := "photos/dup-import-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	bundle := []ExportPhoto{
+		{Id: 101, ZipPath: prefix + "-beach_original.png", PersonIds: []int{fx.alice.Id}},
+		{Id: 102, ZipPath: prefix + "-forest_original.png"},
+		// The same beach photo twice in one bundle.
+		{Id: 103, ZipPath: prefix + "-beach-again_original.png"},
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, data := range [][]byte{beach.Bytes(), forest.Bytes(), beach.Bytes()} {
+		entry, _ := zw.Create(bundle[i].ZipPath)
+		entry.Write(data)
+	}
+	zw.Close()
+	zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	t.Cleanup(func() {
+		for _, photo := range bundle {
+			os.Remove(filepath.Join(cfg.StaticDir, photo.ZipPath))
+		}
+	})
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		existing := writeTestImage(tx, fx.famA, fx.userA.Id, "beach.png")
+		existing.ContentHash = contentHash(beach.Bytes())
+		vbolt.Write(tx, ImagesBkt, existing.Id, &existing)
+		setImageContentHashTx(tx, existing)
+		people := map[int]int{fx.alice.Id: fx.alice.Id}
+
+		imported, skipped, duplicates, mapping := importPhotos(tx, fx.famA, fx.userA.Id, bundle, people, nil, zipReader, true)
+		if imported != 1 || skipped != 0 || duplicates != 2 {
+			t.Fatalf("import = %d imported, %d skipped, %d duplicates", imported, skipped, duplicates)
+		}
+		if mapping[101] != existing.Id || mapping[103] != existing.Id || mapping[102] == existing.Id {
+			t.Errorf("mapping = %v, existing %d", mapping, existing.Id)
+		}
+		if people := GetPhotoPersonsByPhoto(tx, existing.Id); len(people) != 1 || people[0].PersonId != fx.alice.Id {
+			t.Errorf("the skipped copy's people were not carried over: %+v", people)
+		}
+		if forestPhoto := GetImageById(tx, mapping[102]); forestPhoto.ContentHash != contentHash(forest.Bytes()) {
+			t.Errorf("imported photo hash = %q", forestPhoto.ContentHash)
+		}
+		if found := findPhotoByContentHash(tx, fx.famB, contentHash(beach.Bytes())); found != 0 {
+			t.Errorf("another family's photo matched: %d", found)
+		}
+
+		// Without the option every photo comes in again.
+		if imported, _, duplicates, _ := importPhotos(tx, fx.famA, fx.userA.Id, bundle, people, nil, zipReader, false); imported != 3 || duplicates != 0 {
+			t.Errorf("import without skipping = %d imported, %d duplicates", imported, duplicates)
+		}
+	})
+}
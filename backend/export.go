@@ -64,6 +64,7 @@ func exportBundleHandler(w http.ResponseWriter, r *http.Request) {
 		if buildErr != nil {
 			return
 		}
+		localizeExportData(loadUserPreferences(tx, user.Id), &exportData)
 		if mode == "with_photos" {
 			photos := buildPhotoExportMetadata(tx, familyId)
 			exportData.Photos = photos
@@ -127,19 +128,58 @@ func exportBundleHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("[EXPORT] Skipping photo %d (%s): %v", ep.Id, diskPath, err)
 			continue
 		}
-		entry, err := zw.Create(ep.ZipPath)
+		var source io.Reader = f
+		if ep.LocationHidden {
+			// A photo that cannot be stripped is left out rather than
+			// exported with the location its owner hid.
+			stripped, err := readStrippedOriginal(diskPath)
+			if err != nil {
+				log.Printf("[EXPORT] Skipping photo %d with hidden location: %v", ep.Id, err)
+				f.Close()
+				continue
+			}
+			source = bytes.NewReader(stripped)
+		}
+		// A clip is already compressed; deflating it again costs a lot of CPU
+		// and saves nothing.
+		method := zip.Deflate
+		if ep.MediaKind == MediaKindVideo {
+			method = zip.Store
+		}
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: ep.ZipPath, Method: method})
 		if err != nil {
 			log.Printf("[EXPORT] Failed to create ZIP entry for photo %d: %v", ep.Id, err)
 			f.Close()
 			continue
 		}
-		if _, err := io.Copy(entry, f); err != nil {
+		if _, err := io.Copy(entry, source); err != nil {
 			log.Printf("[EXPORT] Failed to write photo %d to ZIP: %v", ep.Id, err)
 			f.Close()
 			return // ZIP stream is broken; abort
 		}
 		f.Close()
 	}
+
+	for _, zipPath := range exportedSchoolDocumentPaths(exportData.SchoolYears) {
+		diskPath := filepath.Join(cfg.StaticDir, zipPath)
+		f, err := os.Open(diskPath)
+		if err != nil {
+			log.Printf("[EXPORT] Skipping school document %s: %v", diskPath, err)
+			continue
+		}
+		entry, err := zw.Create(zipPath)
+		if err != nil {
+			log.Printf("[EXPORT] Failed to create ZIP entry for school document %s: %v", zipPath, err)
+			f.Close()
+			continue
+		}
+		if _, err := io.Copy(entry, f); err != nil {
+			log.Printf("[EXPORT] Failed to write school document %s to ZIP: %v", zipPath, err)
+			f.Close()
+			return // ZIP stream is broken; abort
+		}
+		f.Close()
+	}
 }
 
 func buildPhotoExportMetadata(tx *vbolt.Tx, familyId int) []ExportPhoto {
@@ -159,15 +199,29 @@ func buildPhotoExportMetadata(tx *vbolt.Tx, familyId int) []ExportPhoto {
 			personIds = append(personIds, pp.PersonId)
 		}
 
-		result = append(result, ExportPhoto{
-			Id:          img.Id,
-			Title:       img.Title,
-			Description: img.Description,
-			PhotoDate:   img.PhotoDate,
-			ZipPath:     zipPath,
-			PersonIds:   personIds,
-			TagIds:      GetPhotoTagIds(tx, img.Id),
-		})
+		exported := ExportPhoto{
+			Id:                 img.Id,
+			Title:              img.Title,
+			Description:        img.Description,
+			PhotoDate:          img.PhotoDate,
+			ZipPath:            zipPath,
+			PersonIds:          personIds,
+			TagIds:             GetPhotoTagIds(tx, img.Id),
+			PhotoDatePrecision: normalizeDatePrecision(img.PhotoDatePrecision),
+		}
+		if img.MediaKind == MediaKindVideo {
+			exported.MediaKind = MediaKindVideo
+			exported.DurationMs = img.DurationMs
+		}
+		exported.CameraMake = img.CameraMake
+		exported.CameraModel = img.CameraModel
+		exported.LensModel = img.LensModel
+		exported.LocationHidden = img.LocationHidden
+		if img.HasLocation && !img.LocationHidden {
+			exported.Latitude = img.Latitude
+			exported.Longitude = img.Longitude
+		}
+		result = append(result, exported)
 	}
 	return result
 }
@@ -179,6 +233,17 @@ type ExportTag struct {
 	Color string `json:"color"`
 }
 
+// Export milestone category structure. A milestone's category field holds the
+// Key; the aliases carry what earlier merges folded into the category.
+type ExportMilestoneCategory struct {
+	Key       string   `json:"key"`
+	Name      string   `json:"name"`
+	Color     string   `json:"color,omitempty"`
+	Icon      string   `json:"icon,omitempty"`
+	SortOrder int      `json:"sortOrder"`
+	Aliases   []string `json:"aliases,omitempty"`
+}
+
 // Export photo structure
 type ExportPhoto struct {
 	Id          int       `json:"id"`
@@ -188,6 +253,23 @@ type ExportPhoto struct {
 	ZipPath     string    `json:"zip_path"`
 	PersonIds   []int     `json:"person_ids"`
 	TagIds      []int     `json:"tag_ids"`
+	// PhotoDatePrecision is absent from files written before it existed,
+	// which means a day.
+	PhotoDatePrecision string `json:"photo_date_precision,omitempty"`
+	// MediaKind is "video" for a clip and absent for a photo, which is also
+	// how every file written before videos existed reads.
+	MediaKind  string `json:"media_kind,omitempty"`
+	DurationMs int    `json:"duration_ms,omitempty"`
+	// Where and with what the photo was taken. A location is absent when
+	// the photo had none or it is hidden, and a hidden one stays out of the
+	// original in the archive too; LocationHidden carries the switch across
+	// so the photo stays private after an import.
+	Latitude       float64 `json:"latitude,omitempty"`
+	Longitude      float64 `json:"longitude,omitempty"`
+	CameraMake     string  `json:"camera_make,omitempty"`
+	CameraModel    string  `json:"camera_model,omitempty"`
+	LensModel      string  `json:"lens_model,omitempty"`
+	LocationHidden bool    `json:"location_hidden,omitempty"`
 }
 
 // Export data types matching the import structure
@@ -207,6 +289,13 @@ type ExportDataStructure struct {
 	TotalTags       int               `json:"total_tags"`
 	TotalPhotos     int               `json:"total_photos,omitempty"`
 
+	// HeadCircumferences are stored measurements and import back in. BMI is
+	// derived from the heights and weights above and is written out for
+	// whoever reads the file; import ignores it and derives its own.
+	HeadCircumferences      []ImportHeadCircumference `json:"head_circumferences,omitempty"`
+	BMI                     []BMIPoint                `json:"bmi,omitempty"`
+	TotalHeadCircumferences int                       `json:"total_head_circumferences,omitempty"`
+
 	// The activities tree nests, so one total per level: "3 activities" says
 	// nothing about whether a season's results came through.
 	TotalActivities  int `json:"total_activities,omitempty"`
@@ -215,6 +304,35 @@ type ExportDataStructure struct {
 	TotalEntries     int `json:"total_entries,omitempty"`
 	TotalAppearances int `json:"total_appearances,omitempty"`
 	TotalResults     int `json:"total_results,omitempty"`
+
+	// UnitSystem and DateFormat record the preferences the Display fields
+	// were rendered with, so a file shared onward says what it is showing.
+	UnitSystem string `json:"unit_system,omitempty"`
+	DateFormat string `json:"date_format,omitempty"`
+
+	// MilestoneCategories are absent from files written while categories were
+	// free text; those files' milestones name their category directly.
+	MilestoneCategories []ExportMilestoneCategory `json:"milestone_categories,omitempty"`
+
+	// Vaccinations and HealthRecords go to whoever holds the file, like every
+	// other record here; export is for the family's own members.
+	Vaccinations       []ExportVaccination  `json:"vaccinations,omitempty"`
+	HealthRecords      []ExportHealthRecord `json:"health_records,omitempty"`
+	TotalVaccinations  int                  `json:"total_vaccinations,omitempty"`
+	TotalHealthRecords int                  `json:"total_health_records,omitempty"`
+
+	Teeth      []ExportToothRecord `json:"teeth,omitempty"`
+	TotalTeeth int                 `json:"total_teeth,omitempty"`
+
+	// SchoolYears nest their grades, photo ids and documents; a bundle with
+	// photos carries each document's PDF at its ZipPath.
+	SchoolYears      []ExportSchoolYear `json:"school_years,omitempty"`
+	TotalSchoolYears int                `json:"total_school_years,omitempty"`
+
+	// Albums list their photo ids in album order. Those ids resolve against
+	// Photos, so only a bundle with photos can bring an album back whole.
+	Albums      []ExportAlbum `json:"albums,omitempty"`
+	TotalAlbums int           `json:"total_albums,omitempty"`
 }
 
 // Export milestone structure
@@ -228,6 +346,15 @@ type ExportMilestone struct {
 	CreatedAt     time.Time `json:"createdAt"`
 	PersonName    string    `json:"personName"`
 	TagNames      []string  `json:"tagNames,omitempty"`
+	DisplayDate   string    `json:"displayDate,omitempty"`
+	// PersonIds and PersonNames list everyone the milestone is about, PersonId
+	// first. A file written before milestones were shared has neither, and
+	// means PersonId alone.
+	PersonIds   []int    `json:"personIds,omitempty"`
+	PersonNames []string `json:"personNames,omitempty"`
+	// DatePrecision is absent from files written before it existed, which
+	// means a day. DisplayDate is rendered at this precision.
+	DatePrecision string `json:"datePrecision,omitempty"`
 }
 
 // Request/Response types
@@ -261,6 +388,7 @@ func ExportData(ctx *vbeam.Context, req ExportDataRequest) (resp ExportDataRespo
 	if err != nil {
 		return
 	}
+	localizeExportData(loadUserPreferences(ctx.Tx, user.Id), &exportData)
 
 	// Marshal to JSON
 	jsonBytes, err := json.MarshalIndent(exportData, "", "  ")
@@ -302,6 +430,9 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 			Birthday: person.Birthday,
 			Age:      person.Age,
 			ImageId:  person.ProfilePhotoId,
+
+			DateOfDeath: person.DateOfDeath,
+			InMemoriam:  person.InMemoriam,
 		}
 	}
 
@@ -314,9 +445,10 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 		vbolt.ReadSlice(tx, GrowthDataBkt, growthDataIds, &growthData)
 	}
 
-	// Separate heights and weights, convert to export format
+	// Separate heights, weights and head circumferences, convert to export format
 	var heights []ImportHeight
 	var weights []ImportWeight
+	var headCircumferences []ImportHeadCircumference
 
 	for _, gd := range growthData {
 		// Get person name for the measurement
@@ -329,17 +461,23 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 		}
 
 		// Calculate age at measurement date
-		var personBirthday time.Time
+		var measuredPerson Person
 		for _, person := range people {
 			if person.Id == gd.PersonId {
-				personBirthday = person.Birthday
+				measuredPerson = person
 				break
 			}
 		}
 
-		age := calculateAgeAtDate(personBirthday, gd.MeasurementDate)
+		age := calculateAgeAtDate(measuredPerson.Birthday, gd.MeasurementDate)
 		dateString := gd.MeasurementDate.Format("2006-01-02")
 
+		// The percentile goes out with the value so a spreadsheet opened from
+		// the export agrees with the app. Import ignores it; it is recomputed
+		// from the birthday on the way back in.
+		gd = annotateGrowthPercentiles(measuredPerson, []GrowthData{gd})[0]
+		photoIds := GetGrowthPhotoIds(tx, gd.Id)
+
 		if gd.MeasurementType == Height {
 			// Convert to inches if needed
 			inches := gd.Value
@@ -355,6 +493,11 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 				DateString: dateString,
 				Age:        age,
 				PersonName: personName,
+				Percentile: gd.Percentile,
+				ZScore:     gd.ZScore,
+				Source:     gd.Source,
+				Notes:      gd.Notes,
+				PhotoIds:   photoIds,
 			})
 		} else if gd.MeasurementType == Weight {
 			// Convert to pounds if needed
@@ -371,6 +514,24 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 				DateString: dateString,
 				Age:        age,
 				PersonName: personName,
+				Percentile: gd.Percentile,
+				ZScore:     gd.ZScore,
+				Source:     gd.Source,
+				Notes:      gd.Notes,
+				PhotoIds:   photoIds,
+			})
+		} else if gd.MeasurementType == HeadCircumference {
+			headCircumferences = append(headCircumferences, ImportHeadCircumference{
+				Id:          gd.Id,
+				PersonId:    gd.PersonId,
+				Centimeters: canonicalGrowthValue(HeadCircumference, gd.Value, gd.Unit),
+				Date:        gd.MeasurementDate,
+				DateString:  dateString,
+				Age:         age,
+				PersonName:  personName,
+				Source:      gd.Source,
+				Notes:       gd.Notes,
+				PhotoIds:    photoIds,
 			})
 		}
 	}
@@ -387,7 +548,7 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 	// Convert milestones to export format
 	exportMilestones := make([]ExportMilestone, len(milestones))
 	for i, milestone := range milestones {
-		// Get person name for the milestone
+		// Get person names for the milestone
 		var personName string
 		for _, person := range people {
 			if person.Id == milestone.PersonId {
@@ -395,6 +556,16 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 				break
 			}
 		}
+		personIds := normalizeMilestonePersonIds(milestone.PersonId, GetMilestonePersonIds(tx, milestone.Id))
+		personNames := make([]string, 0, len(personIds))
+		for _, personId := range personIds {
+			for _, person := range people {
+				if person.Id == personId {
+					personNames = append(personNames, person.Name)
+					break
+				}
+			}
+		}
 
 		tagIds := GetMilestoneTagIds(tx, milestone.Id)
 		var tagNames []string
@@ -414,6 +585,9 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 			CreatedAt:     milestone.CreatedAt,
 			PersonName:    personName,
 			TagNames:      tagNames,
+			PersonIds:     personIds,
+			PersonNames:   personNames,
+			DatePrecision: normalizeDatePrecision(milestone.DatePrecision),
 		}
 	}
 
@@ -428,14 +602,23 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 	seasonCount, eventCount, entryCount, appearanceCount, resultCount :=
 		countExportedActivities(exportData.Activities)
 
+	exportData.Vaccinations, exportData.HealthRecords = buildHealthExport(tx, familyId, personNames)
+	exportData.Teeth = buildDentalExport(tx, familyId, personNames)
+	exportData.SchoolYears = buildSchoolExport(tx, familyId, personNames)
+	exportData.Albums = buildAlbumExport(tx, familyId, personNames)
+
 	// Set export data
 	exportData.Heights = heights
 	exportData.Weights = weights
 	exportData.Milestones = exportMilestones
 	exportData.Tags = exportTags
+	exportData.MilestoneCategories = exportMilestoneCategories(tx, familyId)
 	exportData.ExportDate = time.Now()
 	exportData.TotalHeights = len(heights)
 	exportData.TotalWeights = len(weights)
+	exportData.HeadCircumferences = headCircumferences
+	exportData.TotalHeadCircumferences = len(headCircumferences)
+	exportData.BMI = deriveBMISeries(growthData)
 	exportData.TotalPeople = len(people)
 	exportData.TotalMilestones = len(milestones)
 	exportData.TotalTags = len(tags)
@@ -445,10 +628,43 @@ func buildExportData(tx *vbolt.Tx, familyId int) (ExportDataStructure, error) {
 	exportData.TotalEntries = entryCount
 	exportData.TotalAppearances = appearanceCount
 	exportData.TotalResults = resultCount
+	exportData.TotalVaccinations = len(exportData.Vaccinations)
+	exportData.TotalHealthRecords = len(exportData.HealthRecords)
+	exportData.TotalTeeth = len(exportData.Teeth)
+	exportData.TotalSchoolYears = len(exportData.SchoolYears)
+	exportData.TotalAlbums = len(exportData.Albums)
 
 	return exportData, nil
 }
 
+// localizeExportData adds the exporting user's rendering of each value and
+// date next to the fields an import reads. The import fields themselves keep
+// their fixed units, so a file exported by a metric reader imports the same as
+// one exported by an imperial one.
+func localizeExportData(prefs UserPreferences, exportData *ExportDataStructure) {
+	exportData.UnitSystem = prefs.UnitSystem
+	exportData.DateFormat = prefs.DateFormat
+	for i := range exportData.Heights {
+		h := &exportData.Heights[i]
+		h.Display = prefs.formatGrowthValue(Height, h.Inches, "in")
+		h.DisplayDate = prefs.formatDate(h.Date)
+	}
+	for i := range exportData.Weights {
+		w := &exportData.Weights[i]
+		w.Display = prefs.formatGrowthValue(Weight, w.Pounds, "lbs")
+		w.DisplayDate = prefs.formatDate(w.Date)
+	}
+	for i := range exportData.HeadCircumferences {
+		hc := &exportData.HeadCircumferences[i]
+		hc.Display = prefs.formatGrowthValue(HeadCircumference, hc.Centimeters, "cm")
+		hc.DisplayDate = prefs.formatDate(hc.Date)
+	}
+	for i := range exportData.Milestones {
+		m := &exportData.Milestones[i]
+		m.DisplayDate = prefs.formatDatePrecision(m.MilestoneDate, m.DatePrecision)
+	}
+}
+
 // Helper function to calculate age at a specific date
 func calculateAgeAtDate(birthday, targetDate time.Time) float64 {
 	years := targetDate.Year() - birthday.Year()
@@ -465,3 +681,20 @@ func calculateAgeAtDate(birthday, targetDate time.Time) float64 {
 
 	return float64(years) + float64(months)/12.0
 }
+
+// exportMilestoneCategories lists the family's categories in its order.
+func exportMilestoneCategories(tx *vbolt.Tx, familyId int) []ExportMilestoneCategory {
+	categories := getFamilyMilestoneCategories(tx, familyId)
+	exported := make([]ExportMilestoneCategory, len(categories))
+	for i, category := range categories {
+		exported[i] = ExportMilestoneCategory{
+			Key:       category.Key,
+			Name:      category.Name,
+			Color:     category.Color,
+			Icon:      category.Icon,
+			SortOrder: category.SortOrder,
+			Aliases:   category.Aliases,
+		}
+	}
+	return exported
+}
@@ -48,6 +48,12 @@ const (
 	// every existing link reads back as Activities: false — no migration, and no
 	// link silently widened to cover a feature it predates.
 	ScopeActivities
+	// ScopeHealth follows for the same reason, and is never implied by any
+	// other scope: vaccinations and diagnoses go to a linked family only when
+	// the granting family ticks this box for that link.
+	ScopeHealth
+	// ScopeSchool covers school years, report cards and grades.
+	ScopeSchool
 )
 
 // bit is this scope's position in a FamilyLink.Scopes mask.
@@ -236,14 +242,16 @@ type LinkScopes struct {
 	Photos     bool `json:"photos"`
 	Growth     bool `json:"growth"`
 	Activities bool `json:"activities"`
+	Health     bool `json:"health"`
+	School     bool `json:"school"`
 }
 
 // DefaultLinkScopes is what a new link shares unless the granting family says
 // otherwise: the people put on the other family's roster, and their milestones
-// and photos. Growth measurements are medical data and stay off until they are
-// deliberately turned on; activities stay off because every link created before
-// the feature existed would otherwise start sharing something its granter never
-// agreed to.
+// and photos. Growth measurements and health records are medical data and stay
+// off until they are deliberately turned on; activities and school stay off
+// because every link created before the feature existed would otherwise start
+// sharing something its granter never agreed to.
 func DefaultLinkScopes() LinkScopes {
 	return LinkScopes{People: true, Milestones: true, Photos: true}
 }
@@ -265,6 +273,12 @@ func (scopes LinkScopes) ToMask() int {
 	if scopes.Activities {
 		mask |= ScopeActivities.bit()
 	}
+	if scopes.Health {
+		mask |= ScopeHealth.bit()
+	}
+	if scopes.School {
+		mask |= ScopeSchool.bit()
+	}
 	return mask
 }
 
@@ -275,6 +289,8 @@ func linkScopesFromMask(mask int) LinkScopes {
 		Photos:     mask&ScopePhotos.bit() != 0,
 		Growth:     mask&ScopeGrowth.bit() != 0,
 		Activities: mask&ScopeActivities.bit() != 0,
+		Health:     mask&ScopeHealth.bit() != 0,
+		School:     mask&ScopeSchool.bit() != 0,
 	}
 }
 
@@ -283,7 +299,7 @@ func linkScopesFromMask(mask int) LinkScopes {
 // every one of those reads resolves through a person — so People is implied by
 // any of them. An empty mask shares nothing and is rejected by the callers.
 func normalizeLinkScopes(scopes LinkScopes) LinkScopes {
-	if scopes.Milestones || scopes.Photos || scopes.Growth || scopes.Activities {
+	if scopes.Milestones || scopes.Photos || scopes.Growth || scopes.Activities || scopes.Health || scopes.School {
 		scopes.People = true
 	}
 	return scopes
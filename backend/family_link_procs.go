@@ -384,11 +384,16 @@ func personSharing(tx *vbolt.Tx, user User, person Person) GetPersonSharingRespo
 		if row.FamilyId == person.FamilyId {
 			continue // the home roster is not a share
 		}
+		// A typed label wins; without one, the family tree may know.
+		relationship := row.Relationship
+		if relationship == "" {
+			relationship = deriveRosterRelationship(tx, person, row.FamilyId)
+		}
 		resp.SharedWith = append(resp.SharedWith, SharedRosterRef{
 			FamilyId:     row.FamilyId,
 			FamilyName:   GetFamily(tx, row.FamilyId).Name,
 			Role:         row.Role,
-			Relationship: row.Relationship,
+			Relationship: relationship,
 		})
 	}
 
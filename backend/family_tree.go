@@ -0,0 +1,413 @@
+package backend
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func RegisterFamilyTreeMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, GetFamilyTree)
+	vbeam.RegisterProc(app, AddPersonRelation)
+	vbeam.RegisterProc(app, DeletePersonRelation)
+}
+
+// maxFamilyTreePeople bounds a walk. Trees are small, but edges cross
+// households and one walk could otherwise follow them through every family
+// that ever linked to another.
+const maxFamilyTreePeople = 500
+
+// relationGraph is the part of the tree a walk reached: the people and the
+// edges between them, with each person's parents, children and partners
+// indexed for the label lookups.
+type relationGraph struct {
+	people    map[int]Person
+	relations []PersonRelation
+	parents   map[int][]int
+	children  map[int][]int
+	partners  map[int][]int
+}
+
+// loadRelationGraph walks parent and partner edges outward from the seeds.
+// include decides whether a person reached is part of the graph; a person left
+// out is not walked through either, so an edge is only followed from someone
+// the caller may see. A nil include takes everyone.
+func loadRelationGraph(tx *vbolt.Tx, seeds []Person, include func(Person) bool) relationGraph {
+	graph := relationGraph{
+		people:   map[int]Person{},
+		parents:  map[int][]int{},
+		children: map[int][]int{},
+		partners: map[int][]int{},
+	}
+	rejected := map[int]bool{}
+	admit := func(person Person) bool {
+		if _, ok := graph.people[person.Id]; ok {
+			return true
+		}
+		if person.Id == 0 || rejected[person.Id] || len(graph.people) >= maxFamilyTreePeople {
+			return false
+		}
+		if include != nil && !include(person) {
+			rejected[person.Id] = true
+			return false
+		}
+		graph.people[person.Id] = person
+		return true
+	}
+
+	var queue []int
+	for _, seed := range seeds {
+		if _, seen := graph.people[seed.Id]; !seen && admit(seed) {
+			queue = append(queue, seed.Id)
+		}
+	}
+	seenEdge := map[int]bool{}
+	for len(queue) > 0 {
+		personId := queue[0]
+		queue = queue[1:]
+		for _, relation := range GetPersonRelations(tx, personId) {
+			if seenEdge[relation.Id] {
+				continue
+			}
+			otherId := relation.FromPersonId
+			if otherId == personId {
+				otherId = relation.ToPersonId
+			}
+			_, known := graph.people[otherId]
+			if !known {
+				if !admit(GetPersonById(tx, otherId)) {
+					continue
+				}
+				queue = append(queue, otherId)
+			}
+			seenEdge[relation.Id] = true
+			graph.addRelation(relation)
+		}
+	}
+	sort.Slice(graph.relations, func(i, j int) bool { return graph.relations[i].Id < graph.relations[j].Id })
+	return graph
+}
+
+func (graph *relationGraph) addRelation(relation PersonRelation) {
+	graph.relations = append(graph.relations, relation)
+	switch relation.Type {
+	case RelationParent:
+		graph.parents[relation.ToPersonId] = append(graph.parents[relation.ToPersonId], relation.FromPersonId)
+		graph.children[relation.FromPersonId] = append(graph.children[relation.FromPersonId], relation.ToPersonId)
+	case RelationPartner:
+		graph.partners[relation.FromPersonId] = append(graph.partners[relation.FromPersonId], relation.ToPersonId)
+		graph.partners[relation.ToPersonId] = append(graph.partners[relation.ToPersonId], relation.FromPersonId)
+	}
+}
+
+// siblings are the people who share at least one parent with the person.
+func (graph relationGraph) siblings(personId int) []int {
+	seen := map[int]bool{personId: true}
+	var ids []int
+	for _, parentId := range graph.parents[personId] {
+		for _, childId := range graph.children[parentId] {
+			if !seen[childId] {
+				seen[childId] = true
+				ids = append(ids, childId)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// ancestorDepths maps each ancestor of the person to how many generations up
+// they are, the nearer path winning where there are two.
+func (graph relationGraph) ancestorDepths(personId int) map[int]int {
+	depths := map[int]int{personId: 0}
+	frontier := []int{personId}
+	for depth := 1; len(frontier) > 0; depth++ {
+		var next []int
+		for _, id := range frontier {
+			for _, parentId := range graph.parents[id] {
+				if _, seen := depths[parentId]; !seen {
+					depths[parentId] = depth
+					next = append(next, parentId)
+				}
+			}
+		}
+		frontier = next
+	}
+	return depths
+}
+
+// kinWord picks the gendered word where the person's gender is known.
+func kinWord(gender GenderType, neutral string, male string, female string) string {
+	switch gender {
+	case Male:
+		return male
+	case Female:
+		return female
+	}
+	return neutral
+}
+
+func greats(n int) string {
+	return strings.Repeat("great-", max(n, 0))
+}
+
+// describeRelation says what `to` is to `from`: "grandmother", "cousin",
+// "nephew". Only blood relations through parent edges and a person's own
+// partner are named; anything further is left blank rather than guessed.
+func (graph relationGraph) describeRelation(fromId int, toId int) string {
+	if fromId == toId {
+		return ""
+	}
+	to := graph.people[toId]
+	for _, partnerId := range graph.partners[fromId] {
+		if partnerId == toId {
+			return "partner"
+		}
+	}
+
+	fromDepths := graph.ancestorDepths(fromId)
+	toDepths := graph.ancestorDepths(toId)
+	if up, ok := fromDepths[toId]; ok {
+		switch up {
+		case 1:
+			return kinWord(to.Gender, "parent", "father", "mother")
+		default:
+			return greats(up-2) + kinWord(to.Gender, "grandparent", "grandfather", "grandmother")
+		}
+	}
+	if down, ok := toDepths[fromId]; ok {
+		switch down {
+		case 1:
+			return kinWord(to.Gender, "child", "son", "daughter")
+		default:
+			return greats(down-2) + kinWord(to.Gender, "grandchild", "grandson", "granddaughter")
+		}
+	}
+
+	// The nearest common ancestor, counted from each side.
+	best, bestFrom, bestTo := -1, 0, 0
+	for ancestorId, fromUp := range fromDepths {
+		toUp, shared := toDepths[ancestorId]
+		if !shared || ancestorId == fromId || ancestorId == toId {
+			continue
+		}
+		if best == -1 || fromUp+toUp < best {
+			best, bestFrom, bestTo = fromUp+toUp, fromUp, toUp
+		}
+	}
+	switch {
+	case best == -1:
+		return ""
+	case bestFrom == 1 && bestTo == 1:
+		return kinWord(to.Gender, "sibling", "brother", "sister")
+	case bestTo == 1:
+		return greats(bestFrom-2) + kinWord(to.Gender, "aunt or uncle", "uncle", "aunt")
+	case bestFrom == 1:
+		return greats(bestTo-2) + kinWord(to.Gender, "niece or nephew", "nephew", "niece")
+	default:
+		return "cousin"
+	}
+}
+
+// deriveRosterRelationship is the label a roster row would have been typed
+// with: what the person is to the adults of that family, read off the tree.
+// Empty when the tree does not connect them.
+func deriveRosterRelationship(tx *vbolt.Tx, person Person, familyId int) string {
+	var adults []Person
+	for _, p := range GetFamilyOwnPeople(tx, familyId) {
+		if p.Type == Parent && p.Id != person.Id {
+			adults = append(adults, p)
+		}
+	}
+	if len(adults) == 0 {
+		return ""
+	}
+	// The walk is not filtered by visibility: it yields one word about a
+	// person the caller can already see, never the people it passed through.
+	graph := loadRelationGraph(tx, append([]Person{person}, adults...), nil)
+	for _, adult := range adults {
+		if label := graph.describeRelation(adult.Id, person.Id); label != "" {
+			return label
+		}
+	}
+	return ""
+}
+
+// Request/Response types
+
+type GetFamilyTreeRequest struct {
+	FamilyId int `json:"familyId,omitempty"` // defaults to the user's own family
+	// RootPersonId, when set, is who the Label on every node is relative to.
+	RootPersonId int `json:"rootPersonId,omitempty"`
+}
+
+type FamilyTreeNode struct {
+	Person     Person `json:"person"`
+	ParentIds  []int  `json:"parentIds"`
+	ChildIds   []int  `json:"childIds"`
+	PartnerIds []int  `json:"partnerIds"`
+	SiblingIds []int  `json:"siblingIds"` // derived: people sharing a parent
+	Label      string `json:"label,omitempty"`
+}
+
+type GetFamilyTreeResponse struct {
+	FamilyId     int              `json:"familyId"`
+	RootPersonId int              `json:"rootPersonId,omitempty"`
+	People       []FamilyTreeNode `json:"people"`
+	Relations    []PersonRelation `json:"relations"`
+}
+
+type AddPersonRelationRequest struct {
+	Type         RelationType `json:"type"`
+	FromPersonId int          `json:"fromPersonId"` // the parent, for a parent edge
+	ToPersonId   int          `json:"toPersonId"`   // the child, for a parent edge
+}
+
+type AddPersonRelationResponse struct {
+	Relation PersonRelation `json:"relation"`
+}
+
+type DeletePersonRelationRequest struct {
+	Id int `json:"id"`
+}
+
+type DeletePersonRelationResponse struct {
+	Success bool `json:"success"`
+}
+
+// vbeam procedures
+
+// GetFamilyTree starts from a family's roster and follows parent and partner
+// edges outward. Every person reached is checked the way a person page is —
+// their own family, or a link carrying people onto one of the user's rosters —
+// and the walk goes no further through someone who fails it.
+func GetFamilyTree(ctx *vbeam.Context, req GetFamilyTreeRequest) (resp GetFamilyTreeResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessView)
+	if err != nil {
+		return
+	}
+
+	canSee := func(person Person) bool {
+		return CanAccessPerson(ctx.Tx, user, person, ScopePeople, AccessView)
+	}
+	graph := loadRelationGraph(ctx.Tx, GetFamilyPeople(ctx.Tx, familyId), canSee)
+
+	resp.FamilyId = familyId
+	if _, ok := graph.people[req.RootPersonId]; ok {
+		resp.RootPersonId = req.RootPersonId
+	}
+	resp.People = make([]FamilyTreeNode, 0, len(graph.people))
+	for id, person := range graph.people {
+		person.Age = personAge(person)
+		node := FamilyTreeNode{
+			Person:     person,
+			ParentIds:  sortedIds(graph.parents[id]),
+			ChildIds:   sortedIds(graph.children[id]),
+			PartnerIds: sortedIds(graph.partners[id]),
+			SiblingIds: graph.siblings(id),
+		}
+		if resp.RootPersonId != 0 {
+			node.Label = graph.describeRelation(resp.RootPersonId, id)
+		}
+		resp.People = append(resp.People, node)
+	}
+	// Oldest first, which puts each generation above the next.
+	sort.Slice(resp.People, func(i, j int) bool {
+		a, b := resp.People[i].Person, resp.People[j].Person
+		if !a.Birthday.Equal(b.Birthday) {
+			return a.Birthday.Before(b.Birthday)
+		}
+		return a.Id < b.Id
+	})
+	resp.Relations = graph.relations
+	if resp.Relations == nil {
+		resp.Relations = []PersonRelation{}
+	}
+	return
+}
+
+func sortedIds(ids []int) []int {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+	return sorted
+}
+
+// AddPersonRelation records an edge. The user must be able to see both people
+// and contribute to the home family of at least one of them; that family
+// records it, the child's in preference for a parent edge, since a family's
+// tree is mostly the people above and beside its own.
+func AddPersonRelation(ctx *vbeam.Context, req AddPersonRelationRequest) (resp AddPersonRelationResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	from := GetPersonById(ctx.Tx, req.FromPersonId)
+	to := GetPersonById(ctx.Tx, req.ToPersonId)
+	if !CanAccessPerson(ctx.Tx, user, from, ScopePeople, AccessView) || !CanAccessPerson(ctx.Tx, user, to, ScopePeople, AccessView) {
+		err = errors.New("Person not found or not in your family")
+		return
+	}
+
+	var familyId int
+	switch {
+	case CanAccessFamily(ctx.Tx, user, to.FamilyId, AccessContribute):
+		familyId = to.FamilyId
+	case CanAccessFamily(ctx.Tx, user, from.FamilyId, AccessContribute):
+		familyId = from.FamilyId
+	default:
+		err = errors.New("Access denied")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	resp.Relation, err = AddPersonRelationTx(ctx.Tx, req.Type, from.Id, to.Id, familyId)
+	if err != nil {
+		return
+	}
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+// DeletePersonRelation removes an edge. Either end's household may take it
+// back out, as may the family that recorded it.
+func DeletePersonRelation(ctx *vbeam.Context, req DeletePersonRelationRequest) (resp DeletePersonRelationResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	relation := getPersonRelationById(ctx.Tx, req.Id)
+	if relation.Id == 0 {
+		err = errors.New("Relationship not found")
+		return
+	}
+	allowed := CanAccessFamily(ctx.Tx, user, relation.FamilyId, AccessContribute)
+	for _, personId := range []int{relation.FromPersonId, relation.ToPersonId} {
+		if !allowed {
+			allowed = CanAccessFamily(ctx.Tx, user, GetPersonById(ctx.Tx, personId).FamilyId, AccessContribute)
+		}
+	}
+	if !allowed {
+		err = errors.New("Relationship not found")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	deletePersonRelationTx(ctx.Tx, relation)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Success = true
+	return
+}
@@ -0,0 +1,250 @@
+package backend
+
+import (
+	"testing"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+// familyTreeFixture adds the generations above the link fixture's children:
+// alice and bob's parents in A, and their father's mother, who lives in B and
+// is also robin's mother — so robin is the children's uncle.
+type familyTreeFixture struct {
+	familyLinkFixture
+	dad     Person
+	mom     Person
+	grandma Person
+}
+
+func setupFamilyTreeFixture(t *testing.T) (familyTreeFixture, func()) {
+	t.Helper()
+	link, cleanup := setupFamilyLinkFixture(t)
+	jwtKey = []byte("family-tree-test-secret-key-at-least-32")
+	fx := familyTreeFixture{familyLinkFixture: link}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		add := func(name string, gender int, birthdate string, familyId int) Person {
+			person, err := AddPersonTx(tx, AddPersonRequest{Name: name, PersonType: int(Parent), Gender: gender, Birthdate: birthdate}, familyId)
+			if err != nil {
+				t.Fatalf("AddPersonTx(%s) error = %v", name, err)
+			}
+			return person
+		}
+		fx.dad = add("Dad", 0, "1985-03-01", fx.famA)
+		fx.mom = add("Mom", 1, "1986-07-01", fx.famA)
+		fx.grandma = add("Grandma", 1, "1958-11-01", fx.famB)
+
+		for _, edge := range []struct {
+			relationType RelationType
+			from, to     Person
+			familyId     int
+		}{
+			{RelationParent, fx.dad, fx.alice, fx.famA},
+			{RelationParent, fx.mom, fx.alice, fx.famA},
+			{RelationParent, fx.dad, fx.bob, fx.famA},
+			{RelationParent, fx.mom, fx.bob, fx.famA},
+			{RelationPartner, fx.mom, fx.dad, fx.famA},
+			{RelationParent, fx.grandma, fx.dad, fx.famA},
+			{RelationParent, fx.grandma, fx.robin, fx.famB},
+		} {
+			if _, err := AddPersonRelationTx(tx, edge.relationType, edge.from.Id, edge.to.Id, edge.familyId); err != nil {
+				t.Fatalf("AddPersonRelationTx(%s → %s) error = %v", edge.from.Name, edge.to.Name, err)
+			}
+		}
+		vbolt.TxCommit(tx)
+	})
+	return fx, cleanup
+}
+
+func TestPersonRelationRejectsCyclesAndDuplicates(t *testing.T) {
+	fx, cleanup := setupFamilyTreeFixture(t)
+	defer cleanup()
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		cases := []struct {
+			name         string
+			relationType RelationType
+			from, to     Person
+			want         error
+		}{
+			{"child as parent of their parent", RelationParent, fx.alice, fx.dad, ErrRelationCycle},
+			{"three generations round", RelationParent, fx.alice, fx.grandma, ErrRelationCycle},
+			{"same edge twice", RelationParent, fx.dad, fx.alice, ErrRelationExists},
+			{"partners the other way round", RelationPartner, fx.dad, fx.mom, ErrRelationExists},
+		}
+		for _, tc := range cases {
+			if _, err := AddPersonRelationTx(tx, tc.relationType, tc.from.Id, tc.to.Id, fx.famA); err != tc.want {
+				t.Errorf("%s: error = %v, want %v", tc.name, err, tc.want)
+			}
+		}
+		if _, err := AddPersonRelationTx(tx, RelationPartner, fx.dad.Id, fx.dad.Id, fx.famA); err == nil {
+			t.Error("a person was made their own partner")
+		}
+	})
+}
+
+func TestDescribeRelation(t *testing.T) {
+	fx, cleanup := setupFamilyTreeFixture(t)
+	defer cleanup()
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		graph := loadRelationGraph(tx, []Person{fx.alice}, nil)
+		cases := []struct {
+			from, to Person
+			want     string
+		}{
+			{fx.alice, fx.dad, "father"},
+			{fx.alice, fx.mom, "mother"},
+			{fx.alice, fx.bob, "brother"},
+			{fx.bob, fx.alice, "sister"},
+			{fx.alice, fx.grandma, "grandmother"},
+			{fx.grandma, fx.alice, "granddaughter"},
+			{fx.alice, fx.robin, "uncle"},
+			{fx.robin, fx.bob, "nephew"},
+			{fx.dad, fx.mom, "partner"},
+			// Mom is related to robin only by marriage, which is not named.
+			{fx.mom, fx.robin, ""},
+		}
+		for _, tc := range cases {
+			if got := graph.describeRelation(tc.from.Id, tc.to.Id); got != tc.want {
+				t.Errorf("%s is %s's %q, want %q", tc.to.Name, tc.from.Name, got, tc.want)
+			}
+		}
+		if siblings := graph.siblings(fx.alice.Id); len(siblings) != 1 || siblings[0] != fx.bob.Id {
+			t.Errorf("alice's siblings = %v", siblings)
+		}
+	})
+}
+
+func treePeople(t *testing.T, fx familyTreeFixture, user User, req GetFamilyTreeRequest) map[int]FamilyTreeNode {
+	t.Helper()
+	nodes := map[int]FamilyTreeNode{}
+	callAsUser(t, fx.db, user, func(ctx *vbeam.Context) {
+		resp, err := GetFamilyTree(ctx, req)
+		if err != nil {
+			t.Fatalf("GetFamilyTree() error = %v", err)
+		}
+		for _, node := range resp.People {
+			nodes[node.Person.Id] = node
+		}
+	})
+	return nodes
+}
+
+// The walk crosses households only where a link carrying people has put
+// someone on the viewer's roster, and goes no further through anyone else.
+func TestGetFamilyTreeFollowsLinks(t *testing.T) {
+	fx, cleanup := setupFamilyTreeFixture(t)
+	defer cleanup()
+
+	a := treePeople(t, fx, fx.userA, GetFamilyTreeRequest{RootPersonId: fx.alice.Id})
+	for _, person := range []Person{fx.alice, fx.bob, fx.dad, fx.mom} {
+		if _, ok := a[person.Id]; !ok {
+			t.Errorf("A's tree is missing %s", person.Name)
+		}
+	}
+	if _, ok := a[fx.grandma.Id]; ok {
+		t.Error("A's tree reached grandma, whose family shares nothing with A")
+	}
+	if a[fx.bob.Id].Label != "brother" || len(a[fx.bob.Id].SiblingIds) != 1 || len(a[fx.alice.Id].ParentIds) != 2 {
+		t.Errorf("bob = %+v, alice = %+v", a[fx.bob.Id], a[fx.alice.Id])
+	}
+
+	// B sees alice because A shared her, and its own people, but not alice's
+	// parents: they were never shared.
+	b := treePeople(t, fx, fx.userB, GetFamilyTreeRequest{})
+	for _, person := range []Person{fx.alice, fx.grandma, fx.robin} {
+		if _, ok := b[person.Id]; !ok {
+			t.Errorf("B's tree is missing %s", person.Name)
+		}
+	}
+	for _, person := range []Person{fx.dad, fx.mom, fx.bob} {
+		if _, ok := b[person.Id]; ok {
+			t.Errorf("B's tree reached %s", person.Name)
+		}
+	}
+
+	// C has robin through B, and nothing of B's beyond him.
+	c := treePeople(t, fx, fx.userC, GetFamilyTreeRequest{})
+	if _, ok := c[fx.robin.Id]; !ok || len(c) != 1 {
+		t.Errorf("C's tree = %v", c)
+	}
+}
+
+func TestAddPersonRelationNeedsToContributeToOneSide(t *testing.T) {
+	fx, cleanup := setupFamilyTreeFixture(t)
+	defer cleanup()
+
+	// B may relate its own robin to alice, whom it can see.
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		resp, err := AddPersonRelation(ctx, AddPersonRelationRequest{Type: RelationPartner, FromPersonId: fx.robin.Id, ToPersonId: fx.alice.Id})
+		if err != nil {
+			t.Fatalf("AddPersonRelation() error = %v", err)
+		}
+		if resp.Relation.FamilyId != fx.famB {
+			t.Errorf("recorded by family %d, want B", resp.Relation.FamilyId)
+		}
+	})
+
+	// C can see robin through B, but not alice, and contributes to neither
+	// household.
+	callAsUser(t, fx.db, fx.userC, func(ctx *vbeam.Context) {
+		if _, err := AddPersonRelation(ctx, AddPersonRelationRequest{Type: RelationParent, FromPersonId: fx.robin.Id, ToPersonId: fx.alice.Id}); err == nil {
+			t.Error("a family with only a link recorded a relationship")
+		}
+	})
+}
+
+func TestSharedRosterRelationshipIsDerived(t *testing.T) {
+	fx, cleanup := setupFamilyTreeFixture(t)
+	defer cleanup()
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		sharing := personSharing(tx, fx.userA, fx.alice)
+		if len(sharing.SharedWith) != 1 || sharing.SharedWith[0].Relationship != "granddaughter" {
+			t.Errorf("shared with = %+v", sharing.SharedWith)
+		}
+	})
+}
+
+func TestMergeMovesRelations(t *testing.T) {
+	fx, cleanup := setupFamilyTreeFixture(t)
+	defer cleanup()
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		// The same father entered twice, with both children recorded again
+		// against the second record.
+		duplicate, err := AddPersonTx(tx, AddPersonRequest{Name: "Dad again", PersonType: int(Parent), Gender: 0, Birthdate: "1985-03-01"}, fx.famA)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		for _, childId := range []int{fx.alice.Id, fx.bob.Id} {
+			if _, err := AddPersonRelationTx(tx, RelationParent, duplicate.Id, childId, fx.famA); err != nil {
+				t.Fatalf("AddPersonRelationTx() error = %v", err)
+			}
+		}
+		// An edge between the two records is a loop once they are one person.
+		if _, err := AddPersonRelationTx(tx, RelationParent, fx.dad.Id, duplicate.Id, fx.famA); err != nil {
+			t.Fatalf("AddPersonRelationTx() error = %v", err)
+		}
+
+		movePersonRelationsTx(tx, duplicate.Id, fx.dad.Id)
+
+		if left := GetPersonRelations(tx, duplicate.Id); len(left) != 0 {
+			t.Errorf("%d relations still point at the duplicate", len(left))
+		}
+		children := 0
+		for _, relation := range GetPersonRelations(tx, fx.dad.Id) {
+			if relation.FromPersonId == relation.ToPersonId {
+				t.Error("the merge left dad as his own parent")
+			}
+			if relation.Type == RelationParent && relation.FromPersonId == fx.dad.Id {
+				children++
+			}
+		}
+		if children != 2 {
+			t.Errorf("dad has %d children after the merge, want 2", children)
+		}
+	})
+}
@@ -12,9 +12,11 @@ import (
 
 func RegisterGrowthMethods(app *vbeam.Application) {
 	vbeam.RegisterProc(app, AddGrowthData)
+	vbeam.RegisterProc(app, AddCheckup)
 	vbeam.RegisterProc(app, GetGrowthData)
 	vbeam.RegisterProc(app, UpdateGrowthData)
 	vbeam.RegisterProc(app, DeleteGrowthData)
+	vbeam.RegisterProc(app, GetGrowthProjection)
 }
 
 type MeasurementType int
@@ -22,18 +24,77 @@ type MeasurementType int
 const (
 	Height MeasurementType = iota
 	Weight
+	// HeadCircumference is the measurement taken around the head at every
+	// infant checkup. Appended after Weight so stored rows keep their values.
+	HeadCircumference
 )
 
+// measurementTypeNames maps the names requests and imports use onto the
+// stored enum.
+var measurementTypeNames = map[string]MeasurementType{
+	"height":             Height,
+	"weight":             Weight,
+	"head_circumference": HeadCircumference,
+}
+
+// measurementUnits lists the units each measurement may be recorded in. The
+// value is stored in whichever unit it was entered in; conversion happens on
+// the way out.
+var measurementUnits = map[MeasurementType][]string{
+	Height:            {"cm", "in"},
+	Weight:            {"kg", "lbs"},
+	HeadCircumference: {"cm", "in"},
+}
+
+func parseMeasurementType(name string) (MeasurementType, error) {
+	measurementType, ok := measurementTypeNames[name]
+	if !ok {
+		return 0, errors.New("Invalid measurement type")
+	}
+	return measurementType, nil
+}
+
+// validateMeasurement checks a type name, value and unit together, which is
+// everything the add and update requests have in common.
+func validateMeasurement(typeName string, value float64, unit string) error {
+	measurementType, ok := measurementTypeNames[typeName]
+	if !ok {
+		return errors.New("Measurement type must be 'height', 'weight' or 'head_circumference'")
+	}
+	if value <= 0 {
+		return errors.New("Measurement value must be positive")
+	}
+	if unit == "" {
+		return errors.New("Unit is required")
+	}
+	for _, allowed := range measurementUnits[measurementType] {
+		if unit == allowed {
+			return nil
+		}
+	}
+	switch measurementType {
+	case Height:
+		return errors.New("Height unit must be 'cm' or 'in'")
+	case Weight:
+		return errors.New("Weight unit must be 'kg' or 'lbs'")
+	default:
+		return errors.New("Head circumference unit must be 'cm' or 'in'")
+	}
+}
+
 // Request/Response types
 type AddGrowthDataRequest struct {
 	PersonId        int     `json:"personId"`
-	MeasurementType string  `json:"measurementType"` // "height" or "weight"
+	MeasurementType string  `json:"measurementType"` // "height", "weight" or "head_circumference"
 	Value           float64 `json:"value"`
 	Unit            string  `json:"unit"`                      // cm, in, kg, lbs
 	InputType       string  `json:"inputType"`                 // "date" or "age"
 	MeasurementDate *string `json:"measurementDate,omitempty"` // YYYY-MM-DD format (if inputType is "date")
 	AgeYears        *int    `json:"ageYears,omitempty"`        // Age in years (if inputType is "age")
 	AgeMonths       *int    `json:"ageMonths,omitempty"`       // Additional months (if inputType is "age")
+	Source          string  `json:"source,omitempty"`          // "clinic", "home", "school", "imported" or empty
+	Notes           string  `json:"notes,omitempty"`
+	PhotoIds        []int   `json:"photoIds,omitempty"` // Photos documenting the measurement
 }
 
 type AddGrowthDataResponse struct {
@@ -42,13 +103,18 @@ type AddGrowthDataResponse struct {
 
 type UpdateGrowthDataRequest struct {
 	Id              int     `json:"id"`
-	MeasurementType string  `json:"measurementType"` // "height" or "weight"
+	MeasurementType string  `json:"measurementType"` // "height", "weight" or "head_circumference"
 	Value           float64 `json:"value"`
 	Unit            string  `json:"unit"`                      // cm, in, kg, lbs
 	InputType       string  `json:"inputType"`                 // "today", "date" or "age"
 	MeasurementDate *string `json:"measurementDate,omitempty"` // YYYY-MM-DD format (if inputType is "date")
 	AgeYears        *int    `json:"ageYears,omitempty"`        // Age in years (if inputType is "age")
 	AgeMonths       *int    `json:"ageMonths,omitempty"`       // Additional months (if inputType is "age")
+	// Source, Notes and PhotoIds arrived after the edit form did; nil leaves
+	// them as they are, so an older client saving a value does not wipe them.
+	Source   *string `json:"source,omitempty"`
+	Notes    *string `json:"notes,omitempty"`
+	PhotoIds []int   `json:"photoIds,omitempty"`
 }
 
 type UpdateGrowthDataResponse struct {
@@ -81,11 +147,34 @@ type GrowthData struct {
 	Unit            string          `json:"unit"`
 	MeasurementDate time.Time       `json:"measurementDate"`
 	CreatedAt       time.Time       `json:"createdAt"`
+	// Flags are what growth analysis found about this measurement against the
+	// rest of the person's series. Kept current by refreshGrowthFlagsTx.
+	Flags []GrowthFlag `json:"flags"`
+	// Source and Notes say where the measurement came from; see
+	// growth_provenance.go. PhotoIds is read from GrowthPhotoBkt and never
+	// packed.
+	Source   string `json:"source"`
+	Notes    string `json:"notes"`
+	PhotoIds []int  `json:"photoIds,omitempty"`
+
+	// Percentile, ZScore and Reference are computed on read by
+	// annotateGrowthPercentiles and never packed. They are nil for anyone the
+	// reference charts do not cover.
+	Percentile *float64 `json:"percentile,omitempty"`
+	ZScore     *float64 `json:"zScore,omitempty"`
+	Reference  string   `json:"reference,omitempty"`
+
+	// DisplayValue, DisplayUnit and DisplayDate are the measurement in the
+	// reader's preferred units and date format, filled in on read by
+	// localizeGrowthData and never packed. Value and Unit remain the record.
+	DisplayValue float64 `json:"displayValue,omitempty"`
+	DisplayUnit  string  `json:"displayUnit,omitempty"`
+	DisplayDate  string  `json:"displayDate,omitempty"`
 }
 
 // Packing function for vbolt serialization
 func PackGrowthData(self *GrowthData, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
+	version := vpack.Version(3, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.Int(&self.PersonId, buf)
 	vpack.Int(&self.FamilyId, buf)
@@ -94,6 +183,13 @@ func PackGrowthData(self *GrowthData, buf *vpack.Buffer) {
 	vpack.String(&self.Unit, buf)
 	vpack.Time(&self.MeasurementDate, buf)
 	vpack.Time(&self.CreatedAt, buf)
+	if version >= 2 {
+		packGrowthFlags(&self.Flags, buf)
+	}
+	if version >= 3 {
+		vpack.String(&self.Source, buf)
+		vpack.String(&self.Notes, buf)
+	}
 }
 
 // Buckets for vbolt database storage
@@ -176,24 +272,42 @@ func UpdateGrowthDataTx(tx *vbolt.Tx, req UpdateGrowthDataRequest, familyId int)
 	}
 
 	// Convert string measurement type to enum
-	var measurementType MeasurementType
-	if req.MeasurementType == "height" {
-		measurementType = Height
-	} else if req.MeasurementType == "weight" {
-		measurementType = Weight
-	} else {
-		return growthData, errors.New("Invalid measurement type")
+	measurementType, err := parseMeasurementType(req.MeasurementType)
+	if err != nil {
+		return growthData, err
 	}
 
 	// Update the growth data fields
+	previousType := growthData.MeasurementType
 	growthData.MeasurementType = measurementType
 	growthData.Value = req.Value
 	growthData.Unit = req.Unit
+	if req.Source != nil {
+		growthData.Source = *req.Source
+	}
+	if req.Notes != nil {
+		growthData.Notes = trimField(*req.Notes, maxNotesLength)
+	}
+	if req.PhotoIds != nil {
+		if err = validateGrowthPhotos(tx, req.PhotoIds, growthData.FamilyId); err != nil {
+			return growthData, err
+		}
+	}
 
 	// Save updated record
 	vbolt.Write(tx, GrowthDataBkt, growthData.Id, &growthData)
+	if req.PhotoIds != nil {
+		setGrowthPhotosTx(tx, growthData, req.PhotoIds)
+	}
 
-	return growthData, nil
+	// A change of type moves the row from one series to another, and both
+	// need their flags recomputed.
+	refreshGrowthFlagsTx(tx, growthData.PersonId, measurementType)
+	if previousType != measurementType {
+		refreshGrowthFlagsTx(tx, growthData.PersonId, previousType)
+	}
+
+	return GetGrowthDataById(tx, growthData.Id), nil
 }
 
 // getFamilyGrowthData returns every measurement the family owns.
@@ -217,9 +331,14 @@ func DeleteGrowthDataTx(tx *vbolt.Tx, growthDataId int, familyId int) error {
 	vbolt.SetTargetSingleTerm(tx, GrowthDataByPersonIndex, growthData.Id, -1)
 	vbolt.SetTargetSingleTerm(tx, GrowthDataByFamilyIndex, growthData.Id, -1)
 
+	removeAllGrowthPhotos(tx, growthData.Id)
+
 	// Delete the record
 	vbolt.Delete(tx, GrowthDataBkt, growthData.Id)
 
+	// The measurement after this one was being compared against it.
+	refreshGrowthFlagsTx(tx, growthData.PersonId, growthData.MeasurementType)
+
 	return nil
 }
 
@@ -240,13 +359,13 @@ func AddGrowthDataTx(tx *vbolt.Tx, req AddGrowthDataRequest, familyId int) (Grow
 	}
 
 	// Convert string measurement type to enum
-	var measurementType MeasurementType
-	if req.MeasurementType == "height" {
-		measurementType = Height
-	} else if req.MeasurementType == "weight" {
-		measurementType = Weight
-	} else {
-		return growthData, errors.New("Invalid measurement type")
+	measurementType, err := parseMeasurementType(req.MeasurementType)
+	if err != nil {
+		return growthData, err
+	}
+
+	if err = validateGrowthPhotos(tx, req.PhotoIds, familyId); err != nil {
+		return growthData, err
 	}
 
 	// Create growth data record
@@ -256,13 +375,18 @@ func AddGrowthDataTx(tx *vbolt.Tx, req AddGrowthDataRequest, familyId int) (Grow
 	growthData.MeasurementType = measurementType
 	growthData.Value = req.Value
 	growthData.Unit = req.Unit
+	growthData.Source = req.Source
+	growthData.Notes = trimField(req.Notes, maxNotesLength)
 	growthData.CreatedAt = time.Now()
 
 	vbolt.Write(tx, GrowthDataBkt, growthData.Id, &growthData)
 
 	updateGrowthDataIndices(tx, growthData)
+	setGrowthPhotosTx(tx, growthData, req.PhotoIds)
 
-	return growthData, nil
+	refreshGrowthFlagsTx(tx, growthData.PersonId, growthData.MeasurementType)
+
+	return GetGrowthDataById(tx, growthData.Id), nil
 }
 
 func updateGrowthDataIndices(tx *vbolt.Tx, growthData GrowthData) {
@@ -326,9 +450,14 @@ func AddGrowthData(ctx *vbeam.Context, req AddGrowthDataRequest) (resp AddGrowth
 		return
 	}
 
-	vbolt.TxCommit(ctx.Tx)
+	queueGrowthAlerts(ctx.Tx, user, GetPersonById(ctx.Tx, growthData.PersonId), growthData, growthData.Flags)
 
-	resp.GrowthData = growthData
+	// The response is read from the transaction, so it is built before the
+	// commit closes it.
+	resp.GrowthData = localizeGrowthValue(loadUserPreferences(ctx.Tx, user.Id), withGrowthPercentile(ctx.Tx, growthData))
+	resp.GrowthData.PhotoIds = visiblePhotoIds(ctx.Tx, user, GetGrowthPhotoIds(ctx.Tx, growthData.Id))
+
+	vbolt.TxCommit(ctx.Tx)
 	return
 }
 
@@ -352,7 +481,8 @@ func GetGrowthData(ctx *vbeam.Context, req GetGrowthDataRequest) (resp GetGrowth
 		return
 	}
 
-	resp.GrowthData = growthData
+	resp.GrowthData = localizeGrowthValue(loadUserPreferences(ctx.Tx, user.Id), withGrowthPercentile(ctx.Tx, growthData))
+	resp.GrowthData.PhotoIds = visiblePhotoIds(ctx.Tx, user, GetGrowthPhotoIds(ctx.Tx, growthData.Id))
 	return
 }
 
@@ -382,9 +512,16 @@ func UpdateGrowthData(ctx *vbeam.Context, req UpdateGrowthDataRequest) (resp Upd
 		return
 	}
 
-	vbolt.TxCommit(ctx.Tx)
+	// Only what the edit newly raised is worth telling anyone about.
+	fresh := newGrowthFlags(existing.Flags, growthData.Flags)
+	queueGrowthAlerts(ctx.Tx, user, GetPersonById(ctx.Tx, growthData.PersonId), growthData, fresh)
+
+	// The response is read from the transaction, so it is built before the
+	// commit closes it.
+	resp.GrowthData = localizeGrowthValue(loadUserPreferences(ctx.Tx, user.Id), withGrowthPercentile(ctx.Tx, growthData))
+	resp.GrowthData.PhotoIds = visiblePhotoIds(ctx.Tx, user, GetGrowthPhotoIds(ctx.Tx, growthData.Id))
 
-	resp.GrowthData = growthData
+	vbolt.TxCommit(ctx.Tx)
 	return
 }
 
@@ -424,27 +561,15 @@ func validateUpdateGrowthDataRequest(req UpdateGrowthDataRequest) error {
 	if req.Id <= 0 {
 		return errors.New("Growth data ID is required")
 	}
-	if req.MeasurementType != "height" && req.MeasurementType != "weight" {
-		return errors.New("Measurement type must be 'height' or 'weight'")
-	}
-	if req.Value <= 0 {
-		return errors.New("Measurement value must be positive")
-	}
-	if req.Unit == "" {
-		return errors.New("Unit is required")
+	if err := validateMeasurement(req.MeasurementType, req.Value, req.Unit); err != nil {
+		return err
 	}
 	if req.InputType != "today" && req.InputType != "date" && req.InputType != "age" {
 		return errors.New("Input type must be 'today', 'date' or 'age'")
 	}
-
-	// Validate units based on measurement type
-	if req.MeasurementType == "height" {
-		if req.Unit != "cm" && req.Unit != "in" {
-			return errors.New("Height unit must be 'cm' or 'in'")
-		}
-	} else if req.MeasurementType == "weight" {
-		if req.Unit != "kg" && req.Unit != "lbs" {
-			return errors.New("Weight unit must be 'kg' or 'lbs'")
+	if req.Source != nil {
+		if err := validateGrowthSource(*req.Source); err != nil {
+			return err
 		}
 	}
 
@@ -455,28 +580,14 @@ func validateAddGrowthDataRequest(req AddGrowthDataRequest) error {
 	if req.PersonId <= 0 {
 		return errors.New("Person ID is required")
 	}
-	if req.MeasurementType != "height" && req.MeasurementType != "weight" {
-		return errors.New("Measurement type must be 'height' or 'weight'")
-	}
-	if req.Value <= 0 {
-		return errors.New("Measurement value must be positive")
-	}
-	if req.Unit == "" {
-		return errors.New("Unit is required")
+	if err := validateMeasurement(req.MeasurementType, req.Value, req.Unit); err != nil {
+		return err
 	}
 	if req.InputType != "today" && req.InputType != "date" && req.InputType != "age" {
 		return errors.New("Input type must be 'today', 'date' or 'age'")
 	}
-
-	// Validate units based on measurement type
-	if req.MeasurementType == "height" {
-		if req.Unit != "cm" && req.Unit != "in" {
-			return errors.New("Height unit must be 'cm' or 'in'")
-		}
-	} else if req.MeasurementType == "weight" {
-		if req.Unit != "kg" && req.Unit != "lbs" {
-			return errors.New("Weight unit must be 'kg' or 'lbs'")
-		}
+	if err := validateGrowthSource(req.Source); err != nil {
+		return err
 	}
 
 	return nil
@@ -0,0 +1,429 @@
+package backend
+
+// Growth analysis.
+//
+// A weight that slides two percentile lines between checkups is the kind of
+// thing a pediatrician wants to hear about and a busy parent does not notice in
+// a chart. So is a height typed into the weight box. This file looks at each
+// measurement against the ones around it and records what it finds on the
+// measurement itself, where every client that shows the value can show the note
+// beside it.
+//
+// Flags are recomputed for a person's whole series of one measurement type
+// whenever any row in it changes. A measurement's flags depend on its
+// neighbours — the drop is between this visit and the last one — so editing or
+// deleting one row can clear or raise a flag on the next. The series for one
+// child is a few dozen rows at most, which makes recomputing all of it cheaper
+// than working out which neighbours an edit touched.
+//
+// Alerts are opt-in and go only to accounts that can edit the child's records
+// in the child's own family: the people who would act on a note from the
+// doctor, not every grandparent with a view-only link.
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// Growth flag kinds.
+const (
+	// GrowthFlagImplausible marks a value too far from the reference curve to
+	// be a real measurement of a child that age.
+	GrowthFlagImplausible = "implausible"
+	// GrowthFlagUnitMixup is an implausible value that becomes ordinary when
+	// read in the other unit — pounds typed as kilograms, inches as centimetres.
+	GrowthFlagUnitMixup = "unit_mixup"
+	// GrowthFlagDecrease marks a length that went down since the previous
+	// measurement. Children do not shrink, so it is a typo or a different
+	// measuring method.
+	GrowthFlagDecrease = "decrease"
+	// GrowthFlagPercentileCrossing marks a move across two or more of the major
+	// percentile lines since the previous measurement.
+	GrowthFlagPercentileCrossing = "percentile_crossing"
+)
+
+// implausibleZScore is the WHO's cut-off for a biologically implausible value.
+// Anything further out is almost always a data-entry mistake.
+const implausibleZScore = 5.0
+
+// plausibleAlternateZScore is how close to the curve a value has to land in the
+// other unit before the mismatch is reported as a unit mix-up rather than just
+// an implausible number.
+const plausibleAlternateZScore = 3.0
+
+// percentileLineThreshold is how many major lines a measurement has to cross
+// before it is flagged. One line is ordinary noise between visits; two is the
+// conventional point at which a pediatrician takes a second look.
+const percentileLineThreshold = 2
+
+// majorPercentileLines are the lines drawn on the CDC charts.
+var majorPercentileLines = []float64{5, 10, 25, 50, 75, 90, 95}
+
+// lengthDecreaseTolerance is how much a length may fall between measurements
+// before it is flagged, in centimetres. Standing versus lying down, shoes, and
+// the person holding the tape all move the number by about this much.
+var lengthDecreaseTolerance = map[MeasurementType]float64{
+	Height:            1.5,
+	HeadCircumference: 1.0,
+}
+
+// absoluteGrowthBounds are the sanity limits for people no reference curve
+// covers — adults and anyone with no recorded sex — in centimetres and
+// kilograms.
+var absoluteGrowthBounds = map[MeasurementType][2]float64{
+	Height:            {20, 250},
+	Weight:            {0.3, 350},
+	HeadCircumference: {20, 70},
+}
+
+// GrowthFlag is one finding about a measurement.
+type GrowthFlag struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+func PackGrowthFlag(self *GrowthFlag, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.String(&self.Kind, buf)
+	vpack.String(&self.Message, buf)
+}
+
+// packGrowthFlags stores a flag list as a count followed by the flags.
+func packGrowthFlags(flags *[]GrowthFlag, buf *vpack.Buffer) {
+	count := len(*flags)
+	vpack.Int(&count, buf)
+	if !buf.Writing {
+		*flags = nil
+		if count > 0 {
+			*flags = make([]GrowthFlag, count)
+		}
+	}
+	for i := 0; i < count; i++ {
+		PackGrowthFlag(&(*flags)[i], buf)
+	}
+}
+
+// measurementLabel is how a measurement type reads in a sentence.
+func measurementLabel(measurementType MeasurementType) string {
+	switch measurementType {
+	case Height:
+		return "height"
+	case Weight:
+		return "weight"
+	case HeadCircumference:
+		return "head circumference"
+	}
+	return "measurement"
+}
+
+// alternateUnit is the unit a value was most likely meant to be in when it was
+// entered in this one.
+func alternateUnit(unit string) string {
+	switch unit {
+	case "cm":
+		return "in"
+	case "in":
+		return "cm"
+	case "kg":
+		return "lbs"
+	case "lbs":
+		return "kg"
+	}
+	return ""
+}
+
+// valueFlags checks one measurement on its own: is it a number a child of this
+// age could plausibly have measured?
+func valueFlags(person Person, gd GrowthData) []GrowthFlag {
+	label := measurementLabel(gd.MeasurementType)
+
+	if p, ok := computeGrowthPercentile(person, gd); ok {
+		if math.Abs(p.ZScore) <= implausibleZScore {
+			return nil
+		}
+		if alt := alternateUnit(gd.Unit); alt != "" {
+			swapped := gd
+			swapped.Unit = alt
+			if q, ok := computeGrowthPercentile(person, swapped); ok && math.Abs(q.ZScore) <= plausibleAlternateZScore {
+				return []GrowthFlag{{
+					Kind:    GrowthFlagUnitMixup,
+					Message: fmt.Sprintf("This %s looks like it was meant to be in %s, not %s", label, alt, gd.Unit),
+				}}
+			}
+		}
+		return []GrowthFlag{{
+			Kind:    GrowthFlagImplausible,
+			Message: fmt.Sprintf("This %s is far outside the expected range for this age", label),
+		}}
+	}
+
+	bounds, ok := absoluteGrowthBounds[gd.MeasurementType]
+	if !ok {
+		return nil
+	}
+	value := canonicalGrowthValue(gd.MeasurementType, gd.Value, gd.Unit)
+	if value < bounds[0] || value > bounds[1] {
+		return []GrowthFlag{{
+			Kind:    GrowthFlagImplausible,
+			Message: fmt.Sprintf("This %s is outside any plausible range", label),
+		}}
+	}
+	return nil
+}
+
+// percentileLinesCrossed counts the major lines strictly between two
+// percentiles.
+func percentileLinesCrossed(from, to float64) int {
+	crossed := 0
+	for _, line := range majorPercentileLines {
+		if (from < line) != (to < line) {
+			crossed++
+		}
+	}
+	return crossed
+}
+
+// ordinal renders a percentile the way a chart labels it.
+func ordinal(n int) string {
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// analyzeGrowthSeries computes flags for every measurement in one person's
+// series of a single type. The series must be in date order. The result is
+// indexed like the input.
+func analyzeGrowthSeries(person Person, series []GrowthData) [][]GrowthFlag {
+	result := make([][]GrowthFlag, len(series))
+	annotated := annotateGrowthPercentiles(person, append([]GrowthData(nil), series...))
+
+	// previous is the most recent earlier measurement that was itself
+	// believable. Comparing against a typo would flag the correct value after
+	// it as well.
+	previous := -1
+	for i, gd := range annotated {
+		flags := valueFlags(person, gd)
+		plausible := len(flags) == 0
+
+		if plausible && previous >= 0 {
+			prev := annotated[previous]
+			label := measurementLabel(gd.MeasurementType)
+
+			if tolerance, ok := lengthDecreaseTolerance[gd.MeasurementType]; ok {
+				before := canonicalGrowthValue(prev.MeasurementType, prev.Value, prev.Unit)
+				now := canonicalGrowthValue(gd.MeasurementType, gd.Value, gd.Unit)
+				if before-now > tolerance {
+					flags = append(flags, GrowthFlag{
+						Kind:    GrowthFlagDecrease,
+						Message: fmt.Sprintf("This %s is %.1f cm less than on %s", label, before-now, prev.MeasurementDate.Format("Jan 2, 2006")),
+					})
+				}
+			}
+
+			// Head circumference has no reference curve here, so this only
+			// ever fires for height and weight.
+			if gd.Percentile != nil && prev.Percentile != nil {
+				if percentileLinesCrossed(*prev.Percentile, *gd.Percentile) >= percentileLineThreshold {
+					direction := "dropped"
+					if *gd.Percentile > *prev.Percentile {
+						direction = "rose"
+					}
+					flags = append(flags, GrowthFlag{
+						Kind: GrowthFlagPercentileCrossing,
+						Message: fmt.Sprintf("The %s percentile %s from the %s to the %s since %s",
+							label, direction,
+							ordinal(int(math.Round(*prev.Percentile))), ordinal(int(math.Round(*gd.Percentile))),
+							prev.MeasurementDate.Format("Jan 2, 2006")),
+					})
+				}
+			}
+		}
+
+		if plausible {
+			previous = i
+		}
+		result[i] = flags
+	}
+	return result
+}
+
+// sameGrowthFlags reports whether two flag lists say the same thing.
+func sameGrowthFlags(a, b []GrowthFlag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newGrowthFlags returns the flags in after whose kind was not already in
+// before. An edit that leaves a known problem in place should not alert again.
+func newGrowthFlags(before, after []GrowthFlag) []GrowthFlag {
+	seen := make(map[string]bool, len(before))
+	for _, flag := range before {
+		seen[flag.Kind] = true
+	}
+	var fresh []GrowthFlag
+	for _, flag := range after {
+		if !seen[flag.Kind] {
+			fresh = append(fresh, flag)
+		}
+	}
+	return fresh
+}
+
+// refreshGrowthFlagsTx recomputes the flags on one person's series of one
+// measurement type and writes back the rows whose flags changed.
+func refreshGrowthFlagsTx(tx *vbolt.Tx, personId int, measurementType MeasurementType) {
+	person := GetPersonById(tx, personId)
+	if person.Id == 0 {
+		return
+	}
+
+	var series []GrowthData
+	for _, gd := range GetPersonGrowthDataTx(tx, personId) {
+		if gd.MeasurementType == measurementType {
+			series = append(series, gd)
+		}
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		if !series[i].MeasurementDate.Equal(series[j].MeasurementDate) {
+			return series[i].MeasurementDate.Before(series[j].MeasurementDate)
+		}
+		return series[i].Id < series[j].Id
+	})
+
+	flags := analyzeGrowthSeries(person, series)
+	for i := range series {
+		if sameGrowthFlags(series[i].Flags, flags[i]) {
+			continue
+		}
+		series[i].Flags = flags[i]
+		vbolt.Write(tx, GrowthDataBkt, series[i].Id, &series[i])
+	}
+}
+
+// refreshAllGrowthFlagsTx recomputes every series a person has. It is for
+// changes that move the whole record at once — a merge, a corrected birthday.
+func refreshAllGrowthFlagsTx(tx *vbolt.Tx, personId int) {
+	for _, measurementType := range []MeasurementType{Height, Weight, HeadCircumference} {
+		refreshGrowthFlagsTx(tx, personId, measurementType)
+	}
+}
+
+// growthAlertRecipients returns the accounts that can edit records in the
+// person's own family, less the one that entered the measurement: they saw the
+// flag in the response already.
+func growthAlertRecipients(tx *vbolt.Tx, person Person, actorId int) []User {
+	var recipients []User
+	for _, userId := range GetFamilyUserIds(tx, person.FamilyId) {
+		if userId == actorId {
+			continue
+		}
+		user := GetUser(tx, userId)
+		if user.Id == 0 || !CanAccessFamily(tx, user, person.FamilyId, AccessContribute) {
+			continue
+		}
+		recipients = append(recipients, user)
+	}
+	return recipients
+}
+
+// queueGrowthAlerts tells the family's parents about new flags on a
+// measurement, by push and by email, each only to accounts that opted in. A
+// failure to queue is logged and otherwise ignored: the flag is stored on the
+// measurement either way, and that is the record that matters. Nobody is
+// alerted about someone who has died; their records are being filled in, not
+// watched.
+func queueGrowthAlerts(tx *vbolt.Tx, actor User, person Person, gd GrowthData, flags []GrowthFlag) {
+	if len(flags) == 0 || person.InMemoriam {
+		return
+	}
+
+	messages := make([]string, len(flags))
+	for i, flag := range flags {
+		messages[i] = flag.Message
+	}
+	summary := fmt.Sprintf("%s: %s", person.Name, strings.Join(messages, "; "))
+
+	var pushIds []int
+	for _, recipient := range growthAlertRecipients(tx, person, actor.Id) {
+		prefs := loadNotificationPreferences(tx, recipient.Id)
+		if prefs.GrowthAlertsEnabled {
+			pushIds = append(pushIds, recipient.Id)
+		}
+		if prefs.GrowthAlertEmails && recipient.Email != "" {
+			err := QueueMail(MailJob{
+				To:      recipient.Email,
+				Subject: fmt.Sprintf("A growth measurement for %s needs a look", person.Name),
+				Body:    growthAlertEmailBody(recipient, loadUserPreferences(tx, recipient.Id), actor, person, gd, messages),
+				Kind:    "growth_alert",
+			})
+			if err != nil {
+				LogWarn(LogCategoryAPI, "Failed to queue growth alert email", map[string]interface{}{
+					"growthDataId": gd.Id,
+					"error":        err.Error(),
+				})
+			}
+		}
+	}
+
+	if len(pushIds) == 0 || !IsPushWorkerEnabled() {
+		return
+	}
+	err := QueuePushNotification(PushNotificationJob{
+		Event:            PushEventGrowthAlert,
+		RecordId:         gd.Id,
+		FamilyId:         person.FamilyId,
+		SenderId:         actor.Id,
+		SenderName:       actor.Name,
+		Content:          summary,
+		RecipientUserIds: pushIds,
+	})
+	if err != nil {
+		LogWarn(LogCategoryAPI, "Failed to queue growth alert push", map[string]interface{}{
+			"growthDataId": gd.Id,
+			"error":        err.Error(),
+		})
+	}
+}
+
+// growthAlertEmailBody writes the measurement in the recipient's own units and
+// date format, whatever the person who entered it was using.
+func growthAlertEmailBody(recipient User, display UserPreferences, actor User, person Person, gd GrowthData, messages []string) string {
+	greeting := "Hello,"
+	if recipient.Name != "" {
+		greeting = "Hello " + recipient.Name + ","
+	}
+	var notes strings.Builder
+	for _, message := range messages {
+		fmt.Fprintf(&notes, "  - %s\n", message)
+	}
+	return fmt.Sprintf(`%s
+
+%s recorded a %s for %s on %s: %s. Family Record noticed:
+
+%s
+If the number was mistyped, editing it clears the note. You can turn these
+emails off in Settings.
+`, greeting, actor.Name, measurementLabel(gd.MeasurementType), person.Name,
+		display.formatDate(gd.MeasurementDate), display.formatGrowthValue(gd.MeasurementType, gd.Value, gd.Unit), notes.String())
+}
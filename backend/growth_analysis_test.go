@@ -0,0 +1,294 @@
+// Tests for growth analysis: the plausibility checks, the comparison against
+// the previous measurement, flags persisting with the row and following edits
+// and deletes, and alerts reaching only the parents who asked for them.
+package backend
+
+import (
+	"family/cfg"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func flagKinds(flags []GrowthFlag) []string {
+	kinds := make([]string, len(flags))
+	for i, flag := range flags {
+		kinds[i] = flag.Kind
+	}
+	return kinds
+}
+
+func hasFlag(flags []GrowthFlag, kind string) bool {
+	for _, flag := range flags {
+		if flag.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAnalyzeGrowthSeries(t *testing.T) {
+	birthday := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	boy := Person{Id: 1, Gender: Male, Birthday: birthday}
+
+	t.Run("pounds typed as kilograms", func(t *testing.T) {
+		series := []GrowthData{{Id: 1, MeasurementType: Weight, Value: 8, Unit: "kg", MeasurementDate: birthday}}
+		flags := analyzeGrowthSeries(boy, series)
+		if !hasFlag(flags[0], GrowthFlagUnitMixup) {
+			t.Errorf("Expected an 8kg newborn to be flagged as a unit mix-up, got %v", flagKinds(flags[0]))
+		}
+	})
+
+	t.Run("implausible in either unit", func(t *testing.T) {
+		series := []GrowthData{{Id: 1, MeasurementType: Weight, Value: 30, Unit: "kg", MeasurementDate: birthday}}
+		flags := analyzeGrowthSeries(boy, series)
+		if !hasFlag(flags[0], GrowthFlagImplausible) {
+			t.Errorf("Expected a 30kg newborn to be implausible, got %v", flagKinds(flags[0]))
+		}
+	})
+
+	t.Run("ordinary values carry no flags", func(t *testing.T) {
+		series := []GrowthData{
+			{Id: 1, MeasurementType: Height, Value: 75.7, Unit: "cm", MeasurementDate: birthday.AddDate(1, 0, 0)},
+			{Id: 2, MeasurementType: Height, Value: 82.3, Unit: "cm", MeasurementDate: birthday.AddDate(1, 6, 0)},
+		}
+		for i, flags := range analyzeGrowthSeries(boy, series) {
+			if len(flags) != 0 {
+				t.Errorf("Expected no flags on row %d, got %v", i, flagKinds(flags))
+			}
+		}
+	})
+
+	t.Run("height went down", func(t *testing.T) {
+		series := []GrowthData{
+			{Id: 1, MeasurementType: Height, Value: 80, Unit: "cm", MeasurementDate: birthday.AddDate(1, 0, 0)},
+			{Id: 2, MeasurementType: Height, Value: 77, Unit: "cm", MeasurementDate: birthday.AddDate(1, 2, 0)},
+		}
+		flags := analyzeGrowthSeries(boy, series)
+		if !hasFlag(flags[1], GrowthFlagDecrease) {
+			t.Errorf("Expected a 3cm drop to be flagged, got %v", flagKinds(flags[1]))
+		}
+		if len(flags[0]) != 0 {
+			t.Errorf("The earlier measurement should not be flagged, got %v", flagKinds(flags[0]))
+		}
+	})
+
+	t.Run("small height wobble is tolerated", func(t *testing.T) {
+		series := []GrowthData{
+			{Id: 1, MeasurementType: Height, Value: 80, Unit: "cm", MeasurementDate: birthday.AddDate(1, 0, 0)},
+			{Id: 2, MeasurementType: Height, Value: 79.5, Unit: "cm", MeasurementDate: birthday.AddDate(1, 1, 0)},
+		}
+		if flags := analyzeGrowthSeries(boy, series); hasFlag(flags[1], GrowthFlagDecrease) {
+			t.Error("Expected half a centimetre to be within tolerance")
+		}
+	})
+
+	t.Run("weight crosses percentile lines", func(t *testing.T) {
+		at12 := birthday.AddDate(1, 0, 0)
+		at18 := birthday.AddDate(1, 6, 0)
+		point, _ := lmsAt(growthReferenceTables[lmsKey{GrowthReferenceWHO, Weight, Male}], ageInMonths(birthday, at18))
+		series := []GrowthData{
+			{Id: 1, MeasurementType: Weight, Value: 9.65, Unit: "kg", MeasurementDate: at12},
+			{Id: 2, MeasurementType: Weight, Value: lmsValue(-1.6, point), Unit: "kg", MeasurementDate: at18},
+		}
+		flags := analyzeGrowthSeries(boy, series)
+		if !hasFlag(flags[1], GrowthFlagPercentileCrossing) {
+			t.Fatalf("Expected a drop from the 50th to about the 5th to be flagged, got %v", flagKinds(flags[1]))
+		}
+		for _, flag := range flags[1] {
+			if flag.Kind == GrowthFlagPercentileCrossing && !strings.Contains(flag.Message, "dropped") {
+				t.Errorf("Expected the message to say which way it moved, got %q", flag.Message)
+			}
+		}
+	})
+
+	t.Run("a typo is not the baseline for the next value", func(t *testing.T) {
+		series := []GrowthData{
+			{Id: 1, MeasurementType: Height, Value: 76, Unit: "cm", MeasurementDate: birthday.AddDate(1, 0, 0)},
+			{Id: 2, MeasurementType: Height, Value: 760, Unit: "cm", MeasurementDate: birthday.AddDate(1, 1, 0)},
+			{Id: 3, MeasurementType: Height, Value: 78.5, Unit: "cm", MeasurementDate: birthday.AddDate(1, 2, 0)},
+		}
+		flags := analyzeGrowthSeries(boy, series)
+		if len(flags[2]) != 0 {
+			t.Errorf("Expected the value after a typo to compare against the last good one, got %v", flagKinds(flags[2]))
+		}
+	})
+
+	t.Run("adults are checked against fixed bounds", func(t *testing.T) {
+		adult := Person{Id: 2, Gender: Female, Birthday: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)}
+		series := []GrowthData{{Id: 1, MeasurementType: Height, Value: 1650, Unit: "cm", MeasurementDate: time.Now()}}
+		if flags := analyzeGrowthSeries(adult, series); !hasFlag(flags[0], GrowthFlagImplausible) {
+			t.Errorf("Expected 16.5 metres to be implausible, got %v", flagKinds(flags[0]))
+		}
+	})
+}
+
+func TestPercentileLinesCrossed(t *testing.T) {
+	tests := []struct {
+		from, to float64
+		want     int
+	}{
+		{50, 50, 0},
+		{52, 48, 1},
+		{60, 20, 2},
+		{60, 4, 4},
+		{4, 96, 7},
+	}
+	for _, tc := range tests {
+		if got := percentileLinesCrossed(tc.from, tc.to); got != tc.want {
+			t.Errorf("percentileLinesCrossed(%v, %v) = %d, want %d", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestNewGrowthFlags(t *testing.T) {
+	before := []GrowthFlag{{Kind: GrowthFlagDecrease, Message: "old"}}
+	after := []GrowthFlag{{Kind: GrowthFlagDecrease, Message: "new wording"}, {Kind: GrowthFlagPercentileCrossing}}
+	fresh := newGrowthFlags(before, after)
+	if len(fresh) != 1 || fresh[0].Kind != GrowthFlagPercentileCrossing {
+		t.Errorf("Expected only the crossing to be new, got %v", flagKinds(fresh))
+	}
+}
+
+func TestGrowthFlagsStoredWithMeasurement(t *testing.T) {
+	testDBPath := "test_growth_analysis.db"
+	db := vbolt.Open(testDBPath)
+	vbolt.InitBuckets(db, &cfg.Info)
+	defer os.Remove(testDBPath)
+	defer db.Close()
+
+	var parent, otherParent, viewer User
+	var child Person
+	var first, second GrowthData
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		parent = AddUserTx(tx, CreateAccountRequest{Name: "Parent", Email: "parent@example.com", Password: "password123", ConfirmPassword: "password123"}, hash)
+		otherParent = AddUserTx(tx, CreateAccountRequest{Name: "Other Parent", Email: "other@example.com", Password: "password123", ConfirmPassword: "password123"}, hash)
+		viewer = AddUserTx(tx, CreateAccountRequest{Name: "Grandma", Email: "grandma@example.com", Password: "password123", ConfirmPassword: "password123"}, hash)
+		EnsureMembershipTx(tx, otherParent.Id, parent.FamilyId, AccessContribute)
+		EnsureMembershipTx(tx, viewer.Id, parent.FamilyId, AccessView)
+
+		var err error
+		child, err = AddPersonTx(tx, AddPersonRequest{Name: "Kid", PersonType: 1, Gender: 0, Birthdate: "2022-01-01"}, parent.FamilyId)
+		if err != nil {
+			t.Fatalf("Failed to create person: %v", err)
+		}
+
+		first, err = AddGrowthDataTx(tx, AddGrowthDataRequest{
+			PersonId: child.Id, MeasurementType: "height", Value: 80, Unit: "cm",
+			InputType: "date", MeasurementDate: stringPtr("2023-01-01"),
+		}, parent.FamilyId)
+		if err != nil {
+			t.Fatalf("Failed to add first height: %v", err)
+		}
+		second, err = AddGrowthDataTx(tx, AddGrowthDataRequest{
+			PersonId: child.Id, MeasurementType: "height", Value: 76, Unit: "cm",
+			InputType: "date", MeasurementDate: stringPtr("2023-03-01"),
+		}, parent.FamilyId)
+		if err != nil {
+			t.Fatalf("Failed to add second height: %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	if !hasFlag(second.Flags, GrowthFlagDecrease) {
+		t.Fatalf("Expected AddGrowthDataTx to return the decrease flag, got %v", flagKinds(second.Flags))
+	}
+
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		stored := GetGrowthDataById(tx, second.Id)
+		if !hasFlag(stored.Flags, GrowthFlagDecrease) {
+			t.Errorf("Expected the flag to be persisted, got %v", flagKinds(stored.Flags))
+		}
+		if stored := GetGrowthDataById(tx, first.Id); len(stored.Flags) != 0 {
+			t.Errorf("Expected the first measurement to stay clean, got %v", flagKinds(stored.Flags))
+		}
+	})
+
+	t.Run("alerts go to opted-in parents only", func(t *testing.T) {
+		recorder := newMailRecorder(t)
+		vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+			for _, prefs := range []NotificationPreferences{
+				{UserId: otherParent.Id, ChatEnabled: true, GrowthAlertEmails: true},
+				{UserId: viewer.Id, ChatEnabled: true, GrowthAlertEmails: true},
+			} {
+				vbolt.Write(tx, NotificationPreferencesBkt, prefs.UserId, &prefs)
+			}
+			queueGrowthAlerts(tx, parent, child, second, second.Flags)
+			vbolt.TxCommit(tx)
+		})
+
+		if recorder.count() != 1 {
+			t.Fatalf("Expected exactly one alert email, got %d", recorder.count())
+		}
+		job := recorder.jobs[0]
+		if job.To != otherParent.Email || job.Kind != "growth_alert" {
+			t.Errorf("Expected the alert to go to the other parent, got %+v", job)
+		}
+		if !strings.Contains(job.Body, "Kid") {
+			t.Errorf("Expected the email to name the child, got %q", job.Body)
+		}
+	})
+
+	t.Run("correcting the value clears the flag", func(t *testing.T) {
+		vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+			updated, err := UpdateGrowthDataTx(tx, UpdateGrowthDataRequest{
+				Id: second.Id, MeasurementType: "height", Value: 82, Unit: "cm",
+				InputType: "date", MeasurementDate: stringPtr("2023-03-01"),
+			}, parent.FamilyId)
+			if err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+			if len(updated.Flags) != 0 {
+				t.Errorf("Expected no flags after the correction, got %v", flagKinds(updated.Flags))
+			}
+			vbolt.TxCommit(tx)
+		})
+	})
+
+	t.Run("deleting the baseline clears the follower", func(t *testing.T) {
+		vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+			// Put the drop back, then remove the measurement it was a drop from.
+			if _, err := UpdateGrowthDataTx(tx, UpdateGrowthDataRequest{
+				Id: second.Id, MeasurementType: "height", Value: 76, Unit: "cm",
+				InputType: "date", MeasurementDate: stringPtr("2023-03-01"),
+			}, parent.FamilyId); err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+			if err := DeleteGrowthDataTx(tx, first.Id, parent.FamilyId); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if stored := GetGrowthDataById(tx, second.Id); len(stored.Flags) != 0 {
+				t.Errorf("Expected the flag to clear with its baseline gone, got %v", flagKinds(stored.Flags))
+			}
+			vbolt.TxCommit(tx)
+		})
+	})
+}
+
+func TestGrowthAlertPreferences(t *testing.T) {
+	prefs := defaultNotificationPreferences(1)
+	if prefs.allowsEvent(PushEventGrowthAlert) {
+		t.Error("Growth alerts must be opt-in")
+	}
+	prefs.GrowthAlertsEnabled = true
+	if !prefs.allowsEvent(PushEventGrowthAlert) {
+		t.Error("Expected an opted-in account to receive growth alerts")
+	}
+
+	quiet := buildAPNsPayload(PushNotificationJob{Event: PushEventGrowthAlert, Content: "Kid: weight dropped"}, prefs)
+	if strings.Contains(quiet.Aps.Alert.Body, "Kid") || strings.Contains(quiet.Aps.Alert.Title, "Kid") {
+		t.Errorf("Expected the quiet alert to name nobody, got %+v", quiet.Aps.Alert)
+	}
+
+	prefs.ShowMessageText = true
+	loud := buildAPNsPayload(PushNotificationJob{Event: PushEventGrowthAlert, Content: "Kid: weight dropped"}, prefs)
+	if loud.Aps.Alert.Body != "Kid: weight dropped" {
+		t.Errorf("Expected the preview to carry the note, got %+v", loud.Aps.Alert)
+	}
+}
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// BMI is never entered, only derived: a height and a weight recorded on the same
+// day already say everything a BMI would, and storing a third row would mean
+// keeping it in step every time either of the other two is edited or deleted.
+// So the series is rebuilt from the measurements each time it is read.
+
+// BMIPoint is one body mass index derived from a height and a weight taken on
+// the same calendar day. HeightId and WeightId name the rows it came from so a
+// client can show which measurements produced it.
+type BMIPoint struct {
+	PersonId int       `json:"personId"`
+	Date     time.Time `json:"date"`
+	Value    float64   `json:"value"` // kg/m², rounded to one decimal
+	HeightId int       `json:"heightId"`
+	WeightId int       `json:"weightId"`
+}
+
+// deriveBMISeries pairs heights and weights that share a person and a date and
+// returns the BMI for each pair, oldest first. When a day has more than one
+// reading of a kind the most recently entered wins, on the basis that a second
+// entry on the same day is usually a correction of the first.
+func deriveBMISeries(growthData []GrowthData) []BMIPoint {
+	type dayKey struct {
+		PersonId int
+		Date     string
+	}
+	heights := make(map[dayKey]GrowthData)
+	weights := make(map[dayKey]GrowthData)
+
+	for _, gd := range growthData {
+		key := dayKey{gd.PersonId, gd.MeasurementDate.Format("2006-01-02")}
+		switch gd.MeasurementType {
+		case Height:
+			if existing, ok := heights[key]; !ok || gd.Id > existing.Id {
+				heights[key] = gd
+			}
+		case Weight:
+			if existing, ok := weights[key]; !ok || gd.Id > existing.Id {
+				weights[key] = gd
+			}
+		}
+	}
+
+	series := []BMIPoint{}
+	for key, height := range heights {
+		weight, ok := weights[key]
+		if !ok {
+			continue
+		}
+		meters := canonicalGrowthValue(Height, height.Value, height.Unit) / 100
+		kilograms := canonicalGrowthValue(Weight, weight.Value, weight.Unit)
+		if meters <= 0 || kilograms <= 0 {
+			continue
+		}
+		series = append(series, BMIPoint{
+			PersonId: key.PersonId,
+			Date:     height.MeasurementDate,
+			Value:    math.Round(kilograms/(meters*meters)*10) / 10,
+			HeightId: height.Id,
+			WeightId: weight.Id,
+		})
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		if !series[i].Date.Equal(series[j].Date) {
+			return series[i].Date.Before(series[j].Date)
+		}
+		return series[i].PersonId < series[j].PersonId
+	})
+	return series
+}
@@ -0,0 +1,187 @@
+// Tests for head circumference as a stored measurement and for the BMI series
+// derived from heights and weights that share a date.
+package backend
+
+import (
+	"family/cfg"
+	"os"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDeriveBMISeries(t *testing.T) {
+	day1 := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	growth := []GrowthData{
+		{Id: 1, PersonId: 1, MeasurementType: Height, Value: 100, Unit: "cm", MeasurementDate: day1},
+		// Same day, later time: still pairs with the height above.
+		{Id: 2, PersonId: 1, MeasurementType: Weight, Value: 16, Unit: "kg", MeasurementDate: day1.Add(2 * time.Hour)},
+		// Imperial units are converted before the division.
+		{Id: 3, PersonId: 1, MeasurementType: Height, Value: 40, Unit: "in", MeasurementDate: day2},
+		{Id: 4, PersonId: 1, MeasurementType: Weight, Value: 35, Unit: "lbs", MeasurementDate: day2},
+		// A weight with no height that day contributes nothing.
+		{Id: 5, PersonId: 1, MeasurementType: Weight, Value: 17, Unit: "kg", MeasurementDate: day1.AddDate(0, 1, 0)},
+		// Head circumference is not part of BMI.
+		{Id: 6, PersonId: 1, MeasurementType: HeadCircumference, Value: 50, Unit: "cm", MeasurementDate: day1},
+		// Another person's weight on the same day must not pair with person 1's height.
+		{Id: 7, PersonId: 2, MeasurementType: Weight, Value: 30, Unit: "kg", MeasurementDate: day2},
+	}
+
+	series := deriveBMISeries(growth)
+	if len(series) != 2 {
+		t.Fatalf("Expected 2 BMI points, got %d: %+v", len(series), series)
+	}
+
+	if series[0].Value != 16 || series[0].HeightId != 1 || series[0].WeightId != 2 {
+		t.Errorf("Expected BMI 16 from rows 1 and 2, got %+v", series[0])
+	}
+	// 35 lbs = 15.876 kg; 40 in = 1.016 m; 15.876 / 1.032 = 15.4
+	if series[1].Value != 15.4 || !series[1].Date.Equal(day2) {
+		t.Errorf("Expected BMI 15.4 on the second date, got %+v", series[1])
+	}
+
+	t.Run("latest entry on a day wins", func(t *testing.T) {
+		corrected := append([]GrowthData{}, growth[:2]...)
+		corrected = append(corrected, GrowthData{Id: 9, PersonId: 1, MeasurementType: Weight, Value: 20, Unit: "kg", MeasurementDate: day1})
+		series := deriveBMISeries(corrected)
+		if len(series) != 1 || series[0].WeightId != 9 || series[0].Value != 20 {
+			t.Errorf("Expected the corrected weight to be used, got %+v", series)
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		if series := deriveBMISeries(nil); series == nil || len(series) != 0 {
+			t.Errorf("Expected an empty, non-nil series, got %#v", series)
+		}
+	})
+}
+
+func TestValidateMeasurement(t *testing.T) {
+	tests := []struct {
+		name      string
+		typeName  string
+		value     float64
+		unit      string
+		wantError bool
+	}{
+		{"head circumference in cm", "head_circumference", 45.2, "cm", false},
+		{"head circumference in inches", "head_circumference", 17.8, "in", false},
+		{"head circumference in kg", "head_circumference", 45.2, "kg", true},
+		{"height in lbs", "height", 90, "lbs", true},
+		{"weight in kg", "weight", 12, "kg", false},
+		{"bmi is not a stored type", "bmi", 16, "", true},
+		{"zero value", "head_circumference", 0, "cm", true},
+		{"missing unit", "head_circumference", 45, "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateMeasurement(tc.typeName, tc.value, tc.unit)
+			if (err != nil) != tc.wantError {
+				t.Errorf("validateMeasurement(%q, %v, %q) error = %v, wantError %v", tc.typeName, tc.value, tc.unit, err, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestHeadCircumferenceRoundTrip(t *testing.T) {
+	testDBPath := "test_head_circumference.db"
+	db := vbolt.Open(testDBPath)
+	vbolt.InitBuckets(db, &cfg.Info)
+	defer os.Remove(testDBPath)
+	defer db.Close()
+
+	var user User
+	var baby Person
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		user = AddUserTx(tx, CreateAccountRequest{
+			Name:            "Parent",
+			Email:           "parent@example.com",
+			Password:        "password123",
+			ConfirmPassword: "password123",
+		}, hash)
+
+		var err error
+		baby, err = AddPersonTx(tx, AddPersonRequest{
+			Name:       "Baby",
+			PersonType: 1,
+			Gender:     0,
+			Birthdate:  "2024-01-01",
+		}, user.FamilyId)
+		if err != nil {
+			t.Fatalf("Failed to create person: %v", err)
+		}
+
+		gd, err := AddGrowthDataTx(tx, AddGrowthDataRequest{
+			PersonId:        baby.Id,
+			MeasurementType: "head_circumference",
+			Value:           16,
+			Unit:            "in",
+			InputType:       "date",
+			MeasurementDate: stringPtr("2024-04-01"),
+		}, user.FamilyId)
+		if err != nil {
+			t.Fatalf("Failed to add head circumference: %v", err)
+		}
+		if gd.MeasurementType != HeadCircumference || gd.Unit != "in" {
+			t.Errorf("Expected a head circumference stored as entered, got %+v", gd)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	var exported ExportDataStructure
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		var err error
+		exported, err = buildExportData(tx, user.FamilyId)
+		if err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+	})
+
+	if exported.TotalHeadCircumferences != 1 || len(exported.HeadCircumferences) != 1 {
+		t.Fatalf("Expected one exported head circumference, got %+v", exported.HeadCircumferences)
+	}
+	if got := exported.HeadCircumferences[0].Centimeters; got < 40.63 || got > 40.65 {
+		t.Errorf("Expected 16in exported as 40.64cm, got %v", got)
+	}
+	if len(exported.Heights) != 0 || len(exported.Weights) != 0 {
+		t.Error("Head circumference must not leak into heights or weights")
+	}
+
+	// Importing the export onto a second person writes the row back in
+	// centimetres, and importing it again is recognised as a duplicate.
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		twin, err := AddPersonTx(tx, AddPersonRequest{
+			Name:       "Twin",
+			PersonType: 1,
+			Gender:     1,
+			Birthdate:  "2024-01-01",
+		}, user.FamilyId)
+		if err != nil {
+			t.Fatalf("Failed to create person: %v", err)
+		}
+		mapping := map[int]int{baby.Id: twin.Id}
+
+		imported, skipped, errs := importHeadCircumferences(tx, exported.HeadCircumferences, mapping, user.FamilyId, nil)
+		if imported != 1 || skipped != 0 || len(errs) != 0 {
+			t.Fatalf("Expected one imported row, got imported=%d skipped=%d errs=%v", imported, skipped, errs)
+		}
+
+		rows := GetPersonGrowthDataTx(tx, twin.Id)
+		if len(rows) != 1 || rows[0].MeasurementType != HeadCircumference || rows[0].Unit != "cm" {
+			t.Errorf("Expected one head circumference in cm, got %+v", rows)
+		}
+
+		imported, skipped, _ = importHeadCircumferences(tx, exported.HeadCircumferences, mapping, user.FamilyId, nil)
+		if imported != 0 || skipped != 1 {
+			t.Errorf("Expected the second import to be skipped, got imported=%d skipped=%d", imported, skipped)
+		}
+		vbolt.TxCommit(tx)
+	})
+}
@@ -0,0 +1,196 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+// A checkup is one date and a handful of children measured together. Entering
+// it through AddGrowthData means one call per value with the date typed each
+// time, and a mistake halfway through leaves half a visit saved. AddCheckup
+// takes the whole visit at once and saves all of it or none of it: every row
+// is validated before anything is written, and if any row fails the
+// transaction is never committed.
+//
+// Row errors come back in the response rather than as the call's error, so the
+// client can put each message next to the row it belongs to. An error from the
+// call itself means the request as a whole was unusable.
+
+// maxCheckupRows bounds one request. A checkup is a few children; a request
+// with hundreds of rows is a bulk import and belongs in ImportData.
+const maxCheckupRows = 20
+
+// CheckupRow is one person's measurements from the visit. Any of the three
+// values may be left out, but not all of them.
+type CheckupRow struct {
+	PersonId          int      `json:"personId"`
+	Height            *float64 `json:"height,omitempty"`
+	Weight            *float64 `json:"weight,omitempty"`
+	HeadCircumference *float64 `json:"headCircumference,omitempty"`
+}
+
+type AddCheckupRequest struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	// LengthUnit applies to height and head circumference, WeightUnit to
+	// weight, for every row: the same scale and tape measure the whole visit.
+	LengthUnit string       `json:"lengthUnit"` // cm or in
+	WeightUnit string       `json:"weightUnit"` // kg or lbs
+	Rows       []CheckupRow `json:"rows"`
+	// Source and Notes apply to every measurement taken at the visit, the
+	// same as the date and units.
+	Source string `json:"source,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+}
+
+// CheckupRowResult reports on the row at the same position in the request.
+// GrowthData is filled only when the whole checkup was saved; Error only when
+// this row is why it was not.
+type CheckupRowResult struct {
+	PersonId   int          `json:"personId"`
+	GrowthData []GrowthData `json:"growthData"`
+	Error      string       `json:"error,omitempty"`
+}
+
+type AddCheckupResponse struct {
+	Success bool               `json:"success"`
+	Rows    []CheckupRowResult `json:"rows"`
+}
+
+// checkupMeasurements lists the values a row carries as add requests, in a
+// fixed order so the results read height, weight, head circumference.
+func checkupMeasurements(req AddCheckupRequest, row CheckupRow) []AddGrowthDataRequest {
+	date := req.Date
+	var measurements []AddGrowthDataRequest
+	add := func(typeName string, value *float64, unit string) {
+		if value == nil {
+			return
+		}
+		measurements = append(measurements, AddGrowthDataRequest{
+			PersonId:        row.PersonId,
+			MeasurementType: typeName,
+			Value:           *value,
+			Unit:            unit,
+			InputType:       "date",
+			MeasurementDate: &date,
+			Source:          req.Source,
+			Notes:           req.Notes,
+		})
+	}
+	add("height", row.Height, req.LengthUnit)
+	add("weight", row.Weight, req.WeightUnit)
+	add("head_circumference", row.HeadCircumference, req.LengthUnit)
+	return measurements
+}
+
+func validateAddCheckupRequest(req AddCheckupRequest) error {
+	if req.Date == "" {
+		return errors.New("Checkup date is required")
+	}
+	if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		return errors.New("Checkup date must be in YYYY-MM-DD format")
+	}
+	if len(req.Rows) == 0 {
+		return errors.New("At least one person is required")
+	}
+	if len(req.Rows) > maxCheckupRows {
+		return fmt.Errorf("A checkup can record at most %d people", maxCheckupRows)
+	}
+	return validateGrowthSource(req.Source)
+}
+
+// validateCheckupRowTx checks one row against everything AddGrowthDataTx
+// would, so that the write pass cannot fail on a row the check let through.
+// It returns the family the row's measurements will belong to.
+func validateCheckupRowTx(tx *vbolt.Tx, user User, req AddCheckupRequest, row CheckupRow) (int, error) {
+	if row.PersonId <= 0 {
+		return 0, errors.New("Person ID is required")
+	}
+	measurements := checkupMeasurements(req, row)
+	if len(measurements) == 0 {
+		return 0, errors.New("Enter at least one measurement")
+	}
+	for _, m := range measurements {
+		if err := validateMeasurement(m.MeasurementType, m.Value, m.Unit); err != nil {
+			return 0, err
+		}
+	}
+	return ActingFamilyForPerson(tx, user, row.PersonId, AccessContribute)
+}
+
+// AddCheckup records a visit's measurements for several people on one date.
+func AddCheckup(ctx *vbeam.Context, req AddCheckupRequest) (resp AddCheckupResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateAddCheckupRequest(req); err != nil {
+		return
+	}
+
+	resp.Rows = make([]CheckupRowResult, len(req.Rows))
+	familyIds := make([]int, len(req.Rows))
+	seen := make(map[int]bool, len(req.Rows))
+	failed := false
+
+	for i, row := range req.Rows {
+		resp.Rows[i].PersonId = row.PersonId
+		resp.Rows[i].GrowthData = []GrowthData{}
+
+		// Two rows for one person would be two readings of the same thing on
+		// the same day, which is almost certainly a mis-picked name.
+		if seen[row.PersonId] {
+			resp.Rows[i].Error = "This person appears more than once"
+			failed = true
+			continue
+		}
+		seen[row.PersonId] = true
+
+		familyId, rowErr := validateCheckupRowTx(ctx.Tx, user, req, row)
+		if rowErr != nil {
+			resp.Rows[i].Error = rowErr.Error()
+			failed = true
+			continue
+		}
+		familyIds[i] = familyId
+	}
+	if failed {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+
+	for i, row := range req.Rows {
+		for _, m := range checkupMeasurements(req, row) {
+			growthData, addErr := AddGrowthDataTx(ctx.Tx, m, familyIds[i])
+			if addErr != nil {
+				// Nothing is committed, so the rows written so far go with it.
+				resp.Rows[i].Error = addErr.Error()
+				for j := range resp.Rows {
+					resp.Rows[j].GrowthData = []GrowthData{}
+				}
+				return
+			}
+			resp.Rows[i].GrowthData = append(resp.Rows[i].GrowthData, growthData)
+		}
+	}
+
+	prefs := loadUserPreferences(ctx.Tx, user.Id)
+	for i, row := range req.Rows {
+		person := GetPersonById(ctx.Tx, row.PersonId)
+		for j, growthData := range resp.Rows[i].GrowthData {
+			queueGrowthAlerts(ctx.Tx, user, person, growthData, growthData.Flags)
+			resp.Rows[i].GrowthData[j] = localizeGrowthValue(prefs, withGrowthPercentile(ctx.Tx, growthData))
+		}
+	}
+
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Success = true
+	return
+}
@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"family/cfg"
+	"testing"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type checkupFixture struct {
+	db *vbolt.DB
+
+	parent   User
+	outsider User
+	older    Person
+	younger  Person
+	stranger Person
+}
+
+func setupCheckupFixture(t *testing.T) checkupFixture {
+	t.Helper()
+
+	db := vbolt.Open(t.TempDir() + "/checkup.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	t.Cleanup(func() { _ = db.Close() })
+	appDb = db
+	jwtKey = []byte("checkup-test-secret-key-at-least-32")
+
+	fx := checkupFixture{db: db}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		fx.parent = AddUserTx(tx, CreateAccountRequest{Name: "Parent", Email: "parent@example.com"}, hash)
+		fx.outsider = AddUserTx(tx, CreateAccountRequest{Name: "Outsider", Email: "outsider@example.com"}, hash)
+
+		var err error
+		if fx.older, err = AddPersonTx(tx, AddPersonRequest{Name: "Older", PersonType: 1, Gender: 0, Birthdate: "2020-02-01"}, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		if fx.younger, err = AddPersonTx(tx, AddPersonRequest{Name: "Younger", PersonType: 1, Gender: 1, Birthdate: "2023-08-15"}, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		if fx.stranger, err = AddPersonTx(tx, AddPersonRequest{Name: "Stranger", PersonType: 1, Gender: 0, Birthdate: "2021-01-01"}, fx.outsider.FamilyId); err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+	return fx
+}
+
+func (fx checkupFixture) addCheckup(t *testing.T, user User, req AddCheckupRequest) (AddCheckupResponse, error) {
+	t.Helper()
+
+	token, err := generateJwtTokenString(user)
+	if err != nil {
+		t.Fatalf("generateJwtTokenString() error = %v", err)
+	}
+	var resp AddCheckupResponse
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		resp, err = AddCheckup(&vbeam.Context{Tx: tx, Token: token}, req)
+	})
+	return resp, err
+}
+
+func (fx checkupFixture) growthCount(personId int) (count int) {
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		count = len(GetPersonGrowthDataTx(tx, personId))
+	})
+	return
+}
+
+func TestAddCheckupSavesEveryRow(t *testing.T) {
+	fx := setupCheckupFixture(t)
+
+	resp, err := fx.addCheckup(t, fx.parent, AddCheckupRequest{
+		Date:       "2024-02-01",
+		LengthUnit: "cm",
+		WeightUnit: "kg",
+		Rows: []CheckupRow{
+			{PersonId: fx.older.Id, Height: float64Ptr(102), Weight: float64Ptr(16.2)},
+			{PersonId: fx.younger.Id, Height: float64Ptr(61), Weight: float64Ptr(6.3), HeadCircumference: float64Ptr(41)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddCheckup() error = %v", err)
+	}
+	if !resp.Success {
+		t.Fatalf("AddCheckup() did not succeed: %+v", resp.Rows)
+	}
+	if len(resp.Rows) != 2 || len(resp.Rows[0].GrowthData) != 2 || len(resp.Rows[1].GrowthData) != 3 {
+		t.Fatalf("unexpected results: %+v", resp.Rows)
+	}
+
+	for _, row := range resp.Rows {
+		for _, gd := range row.GrowthData {
+			if gd.PersonId != row.PersonId || gd.FamilyId != fx.parent.FamilyId {
+				t.Errorf("measurement %d landed on person %d family %d", gd.Id, gd.PersonId, gd.FamilyId)
+			}
+			if got := gd.MeasurementDate.Format("2006-01-02"); got != "2024-02-01" {
+				t.Errorf("measurement %d dated %s, want the checkup date", gd.Id, got)
+			}
+		}
+	}
+	head := resp.Rows[1].GrowthData[2]
+	if head.MeasurementType != HeadCircumference || head.Unit != "cm" {
+		t.Errorf("head circumference stored as %+v", head)
+	}
+	if resp.Rows[1].GrowthData[0].Percentile == nil {
+		t.Error("the response should carry percentiles like AddGrowthData does")
+	}
+
+	if got := fx.growthCount(fx.older.Id); got != 2 {
+		t.Errorf("older has %d measurements stored, want 2", got)
+	}
+	if got := fx.growthCount(fx.younger.Id); got != 3 {
+		t.Errorf("younger has %d measurements stored, want 3", got)
+	}
+}
+
+func TestAddCheckupIsAllOrNothing(t *testing.T) {
+	fx := setupCheckupFixture(t)
+
+	tests := []struct {
+		name     string
+		rows     []CheckupRow
+		badRow   int
+		wantText string
+	}{
+		{
+			name: "someone else's child",
+			rows: []CheckupRow{
+				{PersonId: fx.older.Id, Height: float64Ptr(102)},
+				{PersonId: fx.stranger.Id, Height: float64Ptr(95)},
+			},
+			badRow:   1,
+			wantText: "Person not found or not in your family",
+		},
+		{
+			name: "a row with nothing in it",
+			rows: []CheckupRow{
+				{PersonId: fx.older.Id},
+				{PersonId: fx.younger.Id, Weight: float64Ptr(6.3)},
+			},
+			badRow:   0,
+			wantText: "Enter at least one measurement",
+		},
+		{
+			name: "a bad value",
+			rows: []CheckupRow{
+				{PersonId: fx.older.Id, Height: float64Ptr(102)},
+				{PersonId: fx.younger.Id, Weight: float64Ptr(-1)},
+			},
+			badRow:   1,
+			wantText: "Measurement value must be positive",
+		},
+		{
+			name: "the same child twice",
+			rows: []CheckupRow{
+				{PersonId: fx.older.Id, Height: float64Ptr(102)},
+				{PersonId: fx.older.Id, Weight: float64Ptr(16)},
+			},
+			badRow:   1,
+			wantText: "This person appears more than once",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := fx.addCheckup(t, fx.parent, AddCheckupRequest{
+				Date: "2024-02-01", LengthUnit: "cm", WeightUnit: "kg", Rows: tc.rows,
+			})
+			if err != nil {
+				t.Fatalf("a row error should be reported per row, got call error %v", err)
+			}
+			if resp.Success {
+				t.Fatal("the checkup should not have been saved")
+			}
+			for i, row := range resp.Rows {
+				if i == tc.badRow {
+					if row.Error != tc.wantText {
+						t.Errorf("row %d error = %q, want %q", i, row.Error, tc.wantText)
+					}
+				} else if row.Error != "" {
+					t.Errorf("row %d should not carry an error, got %q", i, row.Error)
+				}
+			}
+			if fx.growthCount(fx.older.Id) != 0 || fx.growthCount(fx.younger.Id) != 0 {
+				t.Error("a failed checkup must not leave any measurement behind")
+			}
+		})
+	}
+}
+
+func TestAddCheckupRejectsUnusableRequests(t *testing.T) {
+	fx := setupCheckupFixture(t)
+
+	tests := []struct {
+		name string
+		req  AddCheckupRequest
+	}{
+		{"no date", AddCheckupRequest{LengthUnit: "cm", WeightUnit: "kg", Rows: []CheckupRow{{PersonId: fx.older.Id, Height: float64Ptr(100)}}}},
+		{"bad date", AddCheckupRequest{Date: "02/01/2024", LengthUnit: "cm", WeightUnit: "kg", Rows: []CheckupRow{{PersonId: fx.older.Id, Height: float64Ptr(100)}}}},
+		{"no rows", AddCheckupRequest{Date: "2024-02-01", LengthUnit: "cm", WeightUnit: "kg"}},
+		{"too many rows", AddCheckupRequest{Date: "2024-02-01", LengthUnit: "cm", WeightUnit: "kg", Rows: make([]CheckupRow, maxCheckupRows+1)}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := fx.addCheckup(t, fx.parent, tc.req); err == nil {
+				t.Error("expected the request to be rejected")
+			}
+		})
+	}
+
+	t.Run("weight unit on a length", func(t *testing.T) {
+		resp, err := fx.addCheckup(t, fx.parent, AddCheckupRequest{
+			Date: "2024-02-01", LengthUnit: "kg", WeightUnit: "kg",
+			Rows: []CheckupRow{{PersonId: fx.older.Id, Height: float64Ptr(100)}},
+		})
+		if err != nil || resp.Success || resp.Rows[0].Error == "" {
+			t.Errorf("expected a row error for the unit, got resp=%+v err=%v", resp, err)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package backend
+
+// Growth percentiles.
+//
+// The charts on the person page have always drawn their own reference curves,
+// which was fine while the browser was the only thing that needed to know where
+// a child sat on them. The iOS app, the export bundle and anything that wants to
+// notice a child dropping off their curve all need the same answer, and the
+// only way they agree is if the server computes it once.
+//
+// The calculation is the standard LMS method: each reference gives, for a sex
+// and an age, a Box-Cox power L, a median M and a coefficient of variation S,
+// and a measurement X converts to a z-score with
+//
+//	z = ((X/M)^L - 1) / (L*S)     (or ln(X/M)/S when L is zero)
+//
+// which the normal distribution turns into a percentile. Two references are
+// used, following the CDC's own recommendation for US clinics: the WHO
+// standards below two years, the CDC 2000 charts from two to twenty. Past
+// twenty, or for someone with no recorded sex, there is no reference to place
+// them on and the fields are simply left empty.
+//
+// The tables ship inside the binary rather than in the database because they
+// are reference data, not family data: nobody edits them, every deployment
+// wants the same ones, and a fresh install should not need a seeding step.
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+//go:embed growthref/lms.csv
+var growthReferenceCSV []byte
+
+// Reference names as they appear in the embedded table and on the wire.
+const (
+	GrowthReferenceWHO = "who"
+	GrowthReferenceCDC = "cdc"
+)
+
+// whoReferenceMonths is where the WHO standards hand over to the CDC charts.
+// The WHO table is of recumbent length and the CDC one of standing height, so
+// the handover is also where a toddler's measurement changes meaning.
+const whoReferenceMonths = 24.0
+
+// cdcReferenceMonths is the top of the CDC charts. An adult has no percentile.
+const cdcReferenceMonths = 240.0
+
+// daysPerMonth converts an age in days to the fractional months the tables are
+// indexed by — the average Gregorian month, which is what both references use.
+const daysPerMonth = 30.4375
+
+// lmsPoint is one row of a reference table.
+type lmsPoint struct {
+	AgeMonths float64
+	L, M, S   float64
+}
+
+// lmsKey names one curve: a reference, a measurement and a sex.
+type lmsKey struct {
+	Reference string
+	Measure   MeasurementType
+	Gender    GenderType
+}
+
+// growthReferenceTables holds every curve, each sorted by age. It is parsed
+// once at startup; a malformed embedded table is a build mistake, so it panics
+// rather than quietly serving no percentiles.
+var growthReferenceTables = mustParseGrowthReference(growthReferenceCSV)
+
+func mustParseGrowthReference(data []byte) map[lmsKey][]lmsPoint {
+	tables, err := parseGrowthReference(data)
+	if err != nil {
+		panic("growth reference table: " + err.Error())
+	}
+	return tables
+}
+
+func parseGrowthReference(data []byte) (map[lmsKey][]lmsPoint, error) {
+	tables := make(map[lmsKey][]lmsPoint)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	sawHeader := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !sawHeader {
+			sawHeader = true
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) != 7 {
+			return nil, errInvalidReferenceRow(line)
+		}
+
+		var key lmsKey
+		key.Reference = fields[0]
+		switch fields[1] {
+		case "height":
+			key.Measure = Height
+		case "weight":
+			key.Measure = Weight
+		default:
+			return nil, errInvalidReferenceRow(line)
+		}
+		switch fields[2] {
+		case "male":
+			key.Gender = Male
+		case "female":
+			key.Gender = Female
+		default:
+			return nil, errInvalidReferenceRow(line)
+		}
+
+		var values [4]float64
+		for i := range values {
+			v, err := strconv.ParseFloat(fields[3+i], 64)
+			if err != nil {
+				return nil, errInvalidReferenceRow(line)
+			}
+			values[i] = v
+		}
+		tables[key] = append(tables[key], lmsPoint{AgeMonths: values[0], L: values[1], M: values[2], S: values[3]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for key := range tables {
+		points := tables[key]
+		sort.Slice(points, func(i, j int) bool { return points[i].AgeMonths < points[j].AgeMonths })
+	}
+	return tables, nil
+}
+
+func errInvalidReferenceRow(line string) error {
+	return fmt.Errorf("invalid row %q", line)
+}
+
+// lmsAt interpolates a curve linearly between its two nearest rows. Ages
+// outside the curve's range report false rather than extrapolating: a
+// percentile off the end of a chart is a number nobody published.
+func lmsAt(points []lmsPoint, ageMonths float64) (lmsPoint, bool) {
+	if len(points) == 0 || ageMonths < points[0].AgeMonths || ageMonths > points[len(points)-1].AgeMonths {
+		return lmsPoint{}, false
+	}
+	i := sort.Search(len(points), func(i int) bool { return points[i].AgeMonths >= ageMonths })
+	if points[i].AgeMonths == ageMonths || i == 0 {
+		return points[i], true
+	}
+	lo, hi := points[i-1], points[i]
+	t := (ageMonths - lo.AgeMonths) / (hi.AgeMonths - lo.AgeMonths)
+	return lmsPoint{
+		AgeMonths: ageMonths,
+		L:         lo.L + t*(hi.L-lo.L),
+		M:         lo.M + t*(hi.M-lo.M),
+		S:         lo.S + t*(hi.S-lo.S),
+	}, true
+}
+
+// lmsZScore is the LMS transform itself.
+func lmsZScore(value float64, p lmsPoint) float64 {
+	if math.Abs(p.L) < 1e-9 {
+		return math.Log(value/p.M) / p.S
+	}
+	return (math.Pow(value/p.M, p.L) - 1) / (p.L * p.S)
+}
+
+// lmsValue is the inverse: the measurement that sits at z on the curve. It is
+// what a chart needs to draw a percentile line.
+func lmsValue(z float64, p lmsPoint) float64 {
+	if math.Abs(p.L) < 1e-9 {
+		return p.M * math.Exp(p.S*z)
+	}
+	return p.M * math.Pow(1+p.L*p.S*z, 1/p.L)
+}
+
+// zScoreToPercentile is the standard normal CDF, as a percentage.
+func zScoreToPercentile(z float64) float64 {
+	return 50 * (1 + math.Erf(z/math.Sqrt2))
+}
+
+// growthReferenceFor picks the reference that covers an age, or "" past the
+// end of the charts.
+func growthReferenceFor(ageMonths float64) string {
+	switch {
+	case ageMonths < 0:
+		return ""
+	case ageMonths < whoReferenceMonths:
+		return GrowthReferenceWHO
+	case ageMonths <= cdcReferenceMonths:
+		return GrowthReferenceCDC
+	}
+	return ""
+}
+
+// ageInMonths is the fractional age the tables are indexed by.
+func ageInMonths(birthday, at time.Time) float64 {
+	return at.Sub(birthday).Hours() / 24 / daysPerMonth
+}
+
+// canonicalGrowthValue converts a stored measurement into the units the
+// references are written in: centimetres for lengths, kilograms for weight.
+func canonicalGrowthValue(measure MeasurementType, value float64, unit string) float64 {
+	switch {
+	case (measure == Height || measure == HeadCircumference) && unit == "in":
+		return value * 2.54
+	case measure == Weight && unit == "lbs":
+		return value * 0.45359237
+	}
+	return value
+}
+
+// GrowthPercentile is where one measurement sits on its reference curve.
+type GrowthPercentile struct {
+	Percentile float64 `json:"percentile"`
+	ZScore     float64 `json:"zScore"`
+	Reference  string  `json:"reference"`
+}
+
+// computeGrowthPercentile places a measurement for a person. It reports false
+// when there is nothing to place it against: an unrecorded sex, an expected
+// baby, a measurement dated before birth or after twenty.
+func computeGrowthPercentile(person Person, gd GrowthData) (GrowthPercentile, bool) {
+	if person.IsPregnancy || (person.Gender != Male && person.Gender != Female) {
+		return GrowthPercentile{}, false
+	}
+	if gd.Value <= 0 {
+		return GrowthPercentile{}, false
+	}
+
+	age := ageInMonths(person.Birthday, gd.MeasurementDate)
+	reference := growthReferenceFor(age)
+	if reference == "" {
+		return GrowthPercentile{}, false
+	}
+	point, ok := lmsAt(growthReferenceTables[lmsKey{reference, gd.MeasurementType, person.Gender}], age)
+	if !ok {
+		return GrowthPercentile{}, false
+	}
+
+	z := lmsZScore(canonicalGrowthValue(gd.MeasurementType, gd.Value, gd.Unit), point)
+	return GrowthPercentile{
+		Percentile: math.Round(zScoreToPercentile(z)*10) / 10,
+		ZScore:     math.Round(z*100) / 100,
+		Reference:  reference,
+	}, true
+}
+
+// annotateGrowthPercentiles fills in the computed fields on a person's
+// measurements in place and returns the slice for convenience. Nothing is
+// written back: the percentile depends on the birthday, which can be edited,
+// so it is derived on every read rather than stored beside the value.
+func annotateGrowthPercentiles(person Person, growthData []GrowthData) []GrowthData {
+	for i := range growthData {
+		if p, ok := computeGrowthPercentile(person, growthData[i]); ok {
+			growthData[i].Percentile = &p.Percentile
+			growthData[i].ZScore = &p.ZScore
+			growthData[i].Reference = p.Reference
+		} else {
+			growthData[i].Percentile = nil
+			growthData[i].ZScore = nil
+			growthData[i].Reference = ""
+		}
+	}
+	return growthData
+}
+
+// GetPersonGrowthWithPercentilesTx is GetPersonGrowthDataTx for callers that
+// hand the measurements to a client.
+func GetPersonGrowthWithPercentilesTx(tx *vbolt.Tx, person Person) []GrowthData {
+	return annotateGrowthPercentiles(person, GetPersonGrowthDataTx(tx, person.Id))
+}
+
+// withGrowthPercentile annotates a single measurement, looking its person up.
+func withGrowthPercentile(tx *vbolt.Tx, growthData GrowthData) GrowthData {
+	person := GetPersonById(tx, growthData.PersonId)
+	return annotateGrowthPercentiles(person, []GrowthData{growthData})[0]
+}
@@ -0,0 +1,254 @@
+// Tests for the server-side growth percentile engine: the embedded reference
+// tables parse, the LMS transform agrees with the published medians, units are
+// normalised before lookup, and people the charts do not cover get nothing.
+package backend
+
+import (
+	"family/cfg"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestGrowthReferenceTablesParse(t *testing.T) {
+	for _, ref := range []string{GrowthReferenceWHO, GrowthReferenceCDC} {
+		for _, measure := range []MeasurementType{Height, Weight} {
+			for _, gender := range []GenderType{Male, Female} {
+				points := growthReferenceTables[lmsKey{ref, measure, gender}]
+				if len(points) < 2 {
+					t.Fatalf("%s/%d/%d: expected a curve, got %d rows", ref, measure, gender, len(points))
+				}
+				for i := 1; i < len(points); i++ {
+					if points[i].AgeMonths <= points[i-1].AgeMonths {
+						t.Fatalf("%s/%d/%d: rows not sorted by age", ref, measure, gender)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := parseGrowthReference([]byte("header\nwho,height,male,0,1\n")); err == nil {
+		t.Error("Expected a short row to be rejected")
+	}
+	if _, err := parseGrowthReference([]byte("header\nwho,length,male,0,1,2,3\n")); err == nil {
+		t.Error("Expected an unknown measure to be rejected")
+	}
+}
+
+// The WHO rows reproduce the published WHO z-score tables, which print the
+// curve at -2, 0 and +2 SD to one decimal.
+func TestWHOReferenceMatchesPublishedPoints(t *testing.T) {
+	cases := []struct {
+		name      string
+		measure   MeasurementType
+		gender    GenderType
+		ageMonths float64
+		want      [3]float64 // -2 SD, median, +2 SD
+	}{
+		{"boys length at birth", Height, Male, 0, [3]float64{46.1, 49.9, 53.7}},
+		{"girls weight at 12 months", Weight, Female, 12, [3]float64{7.0, 8.9, 11.5}},
+		{"girls length at 24 months", Height, Female, 24, [3]float64{80.0, 86.4, 92.9}},
+		{"boys weight at 24 months", Weight, Male, 24, [3]float64{9.7, 12.2, 15.3}},
+	}
+	for _, tc := range cases {
+		p, ok := lmsAt(growthReferenceTables[lmsKey{GrowthReferenceWHO, tc.measure, tc.gender}], tc.ageMonths)
+		if !ok {
+			t.Fatalf("%s: no reference row", tc.name)
+		}
+		for i, z := range []float64{-2, 0, 2} {
+			if got := lmsValue(z, p); math.Abs(got-tc.want[i]) > 0.05 {
+				t.Errorf("%s at z=%v = %.2f, want %.1f", tc.name, z, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestLMSZScore(t *testing.T) {
+	p := lmsPoint{L: 0.3487, M: 3.3464, S: 0.14602}
+
+	if z := lmsZScore(p.M, p); math.Abs(z) > 1e-9 {
+		t.Errorf("Median should have z = 0, got %f", z)
+	}
+
+	// The inverse transform should land back where it started.
+	for _, z := range []float64{-2, -1, 0.5, 1.88} {
+		if got := lmsZScore(lmsValue(z, p), p); math.Abs(got-z) > 1e-9 {
+			t.Errorf("Round trip at z=%f returned %f", z, got)
+		}
+	}
+
+	// L = 0 is the log-normal special case.
+	logNormal := lmsPoint{L: 0, M: 10, S: 0.1}
+	if z := lmsZScore(10*math.Exp(0.1), logNormal); math.Abs(z-1) > 1e-9 {
+		t.Errorf("Expected z = 1 for the log-normal case, got %f", z)
+	}
+
+	if pct := zScoreToPercentile(0); math.Abs(pct-50) > 1e-9 {
+		t.Errorf("Expected z = 0 to be the 50th percentile, got %f", pct)
+	}
+	if pct := zScoreToPercentile(-2); math.Abs(pct-2.275) > 0.01 {
+		t.Errorf("Expected z = -2 to be about the 2.3rd percentile, got %f", pct)
+	}
+}
+
+func TestLMSInterpolation(t *testing.T) {
+	points := []lmsPoint{
+		{AgeMonths: 0, L: 1, M: 50, S: 0.04},
+		{AgeMonths: 1, L: 1, M: 54, S: 0.03},
+	}
+
+	mid, ok := lmsAt(points, 0.5)
+	if !ok {
+		t.Fatal("Expected an age inside the curve to resolve")
+	}
+	if math.Abs(mid.M-52) > 1e-9 || math.Abs(mid.S-0.035) > 1e-9 {
+		t.Errorf("Expected halfway values, got M=%f S=%f", mid.M, mid.S)
+	}
+
+	if exact, _ := lmsAt(points, 1); exact.M != 54 {
+		t.Errorf("Expected the exact row at an exact age, got M=%f", exact.M)
+	}
+	if _, ok := lmsAt(points, 1.5); ok {
+		t.Error("Expected an age past the curve not to extrapolate")
+	}
+}
+
+func TestComputeGrowthPercentile(t *testing.T) {
+	birthday := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	boy := Person{Id: 1, Gender: Male, Birthday: birthday}
+	girl := Person{Id: 2, Gender: Female, Birthday: birthday}
+
+	t.Run("WHO median at birth", func(t *testing.T) {
+		gd := GrowthData{MeasurementType: Weight, Value: 3.3464, Unit: "kg", MeasurementDate: birthday}
+		p, ok := computeGrowthPercentile(boy, gd)
+		if !ok {
+			t.Fatal("Expected a percentile for a newborn")
+		}
+		if p.Reference != GrowthReferenceWHO || p.Percentile != 50 || p.ZScore != 0 {
+			t.Errorf("Expected WHO 50th percentile, got %+v", p)
+		}
+	})
+
+	t.Run("imperial units are normalised", func(t *testing.T) {
+		metric := GrowthData{MeasurementType: Height, Value: 74.015, Unit: "cm", MeasurementDate: birthday.AddDate(1, 0, 0)}
+		imperial := metric
+		imperial.Value = metric.Value / 2.54
+		imperial.Unit = "in"
+
+		a, _ := computeGrowthPercentile(girl, metric)
+		b, _ := computeGrowthPercentile(girl, imperial)
+		if a != b {
+			t.Errorf("Expected the same placement in either unit, got %+v and %+v", a, b)
+		}
+
+		pounds := GrowthData{MeasurementType: Weight, Value: 8.9481 / 0.45359237, Unit: "lbs", MeasurementDate: birthday.AddDate(1, 0, 0)}
+		if p, _ := computeGrowthPercentile(girl, pounds); math.Abs(p.Percentile-50) > 1 {
+			t.Errorf("Expected a median weight in pounds to sit near the 50th percentile, got %+v", p)
+		}
+	})
+
+	t.Run("CDC from two years", func(t *testing.T) {
+		gd := GrowthData{MeasurementType: Height, Value: 115.52, Unit: "cm", MeasurementDate: birthday.AddDate(6, 0, 0)}
+		p, ok := computeGrowthPercentile(boy, gd)
+		if !ok || p.Reference != GrowthReferenceCDC {
+			t.Fatalf("Expected a CDC placement at six, got %+v ok=%v", p, ok)
+		}
+		if math.Abs(p.Percentile-50) > 2 {
+			t.Errorf("Expected the CDC median to sit near the 50th percentile, got %+v", p)
+		}
+	})
+
+	t.Run("taller is a higher percentile", func(t *testing.T) {
+		date := birthday.AddDate(0, 6, 0)
+		short, _ := computeGrowthPercentile(boy, GrowthData{MeasurementType: Height, Value: 64, Unit: "cm", MeasurementDate: date})
+		tall, _ := computeGrowthPercentile(boy, GrowthData{MeasurementType: Height, Value: 71, Unit: "cm", MeasurementDate: date})
+		if !(short.Percentile < 50 && tall.Percentile > 50 && short.ZScore < 0 && tall.ZScore > 0) {
+			t.Errorf("Expected short below and tall above the median, got %+v and %+v", short, tall)
+		}
+	})
+
+	uncovered := []struct {
+		name   string
+		person Person
+		date   time.Time
+	}{
+		{"unknown gender", Person{Gender: Unknown, Birthday: birthday}, birthday.AddDate(1, 0, 0)},
+		{"pregnancy", Person{Gender: Male, Birthday: birthday, IsPregnancy: true}, birthday.AddDate(1, 0, 0)},
+		{"before birth", boy, birthday.AddDate(0, -1, 0)},
+		{"adult", boy, birthday.AddDate(25, 0, 0)},
+	}
+	for _, tc := range uncovered {
+		t.Run(tc.name, func(t *testing.T) {
+			gd := GrowthData{MeasurementType: Height, Value: 80, Unit: "cm", MeasurementDate: tc.date}
+			if _, ok := computeGrowthPercentile(tc.person, gd); ok {
+				t.Error("Expected no percentile")
+			}
+			annotated := annotateGrowthPercentiles(tc.person, []GrowthData{gd})
+			if annotated[0].Percentile != nil || annotated[0].ZScore != nil || annotated[0].Reference != "" {
+				t.Errorf("Expected computed fields to stay empty, got %+v", annotated[0])
+			}
+		})
+	}
+}
+
+func TestGrowthPercentilesOnRead(t *testing.T) {
+	testDBPath := "test_growth_percentiles.db"
+	db := vbolt.Open(testDBPath)
+	vbolt.InitBuckets(db, &cfg.Info)
+	defer os.Remove(testDBPath)
+	defer db.Close()
+
+	var child Person
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		user := AddUserTx(tx, CreateAccountRequest{
+			Name:            "Parent",
+			Email:           "parent@example.com",
+			Password:        "password123",
+			ConfirmPassword: "password123",
+		}, hash)
+
+		var err error
+		child, err = AddPersonTx(tx, AddPersonRequest{
+			Name:       "Child",
+			PersonType: 1,
+			Gender:     1,
+			Birthdate:  "2022-01-01",
+		}, user.FamilyId)
+		if err != nil {
+			t.Fatalf("Failed to create person: %v", err)
+		}
+
+		_, err = AddGrowthDataTx(tx, AddGrowthDataRequest{
+			PersonId:        child.Id,
+			MeasurementType: "weight",
+			Value:           8.9481,
+			Unit:            "kg",
+			InputType:       "date",
+			MeasurementDate: stringPtr("2023-01-01"),
+		}, user.FamilyId)
+		if err != nil {
+			t.Fatalf("Failed to add growth data: %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		stored := GetPersonGrowthDataTx(tx, child.Id)
+		if len(stored) != 1 || stored[0].Percentile != nil {
+			t.Fatalf("Expected the stored row to carry no percentile, got %+v", stored)
+		}
+
+		growth := GetPersonGrowthWithPercentilesTx(tx, child)
+		if len(growth) != 1 || growth[0].Percentile == nil {
+			t.Fatalf("Expected an annotated measurement, got %+v", growth)
+		}
+		if math.Abs(*growth[0].Percentile-50) > 1 || growth[0].Reference != GrowthReferenceWHO {
+			t.Errorf("Expected a median one-year-old on the WHO curve, got %v (%s)", *growth[0].Percentile, growth[0].Reference)
+		}
+	})
+}
@@ -0,0 +1,381 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+// Growth projection answers "where is this child heading", two ways.
+//
+// The mid-parental target is the clinic's rule of thumb: a child's adult
+// height is, give or take, the average of the parents' heights shifted by half
+// the average difference between men and women. It only needs the parents, so
+// it is available from birth, but it knows nothing about the child.
+//
+// Percentile tracking knows only the child: it takes where they have sat on
+// the reference curve over the last year and follows that line forward to
+// twenty. Children do drift between percentiles — far more in the first two
+// years than later — so the further out it reaches the less it means, which is
+// why every series carries its method and band in words as well as numbers.
+//
+// Both are computed on read from the measurements as they stand, like
+// percentiles, so a new measurement is reflected the next time the chart asks.
+// Nothing is stored that could go stale.
+
+// midParentalOffsetCm is half the average adult height difference between men
+// and women, added for a boy and subtracted for a girl.
+const midParentalOffsetCm = 6.5
+
+// midParentalBandCm is the usual two-standard-deviation range around the
+// mid-parental target: about 95% of children finish inside it.
+const midParentalBandCm = 8.5
+
+// projectionBandZ is the width of the percentile-tracking band either side of
+// the tracked line, in z-scores: roughly the 16th to the 84th percentile.
+const projectionBandZ = 1.0
+
+// projectionWindow is how far back from the latest measurement the tracked
+// z-score is averaged over. One reading is noisy; a year of them is not.
+const projectionWindow = 365 * 24 * time.Hour
+
+// maxProjectedZ caps the tracked line. A child far off the chart is better
+// served by a clinician than by a curve extrapolated from the tail.
+const maxProjectedZ = 3.0
+
+// MidParentalHeight is the target adult height from the parents' heights.
+type MidParentalHeight struct {
+	Target       float64 `json:"target"`
+	Low          float64 `json:"low"`
+	High         float64 `json:"high"`
+	Unit         string  `json:"unit"`
+	FatherId     int     `json:"fatherId"`
+	MotherId     int     `json:"motherId"`
+	FatherHeight float64 `json:"fatherHeight"`
+	MotherHeight float64 `json:"motherHeight"`
+	Method       string  `json:"method"`
+}
+
+// ProjectionPoint is one age on a projected line, with its band.
+type ProjectionPoint struct {
+	AgeMonths float64   `json:"ageMonths"`
+	Date      time.Time `json:"date"`
+	Value     float64   `json:"value"`
+	Low       float64   `json:"low"`
+	High      float64   `json:"high"`
+}
+
+// ProjectionSeries is one measurement type's percentile-tracking projection,
+// starting at the latest measurement and ending at twenty.
+type ProjectionSeries struct {
+	MeasurementType MeasurementType   `json:"measurementType"`
+	Unit            string            `json:"unit"`
+	ZScore          float64           `json:"zScore"`
+	Percentile      float64           `json:"percentile"`
+	BasedOn         []int             `json:"basedOn"` // growth data ids averaged
+	Method          string            `json:"method"`
+	Points          []ProjectionPoint `json:"points"`
+}
+
+type GrowthProjection struct {
+	PersonId    int                `json:"personId"`
+	MidParental *MidParentalHeight `json:"midParental"`
+	Height      *ProjectionSeries  `json:"height"`
+	Weight      *ProjectionSeries  `json:"weight"`
+	// Notes says why a part is missing, so an empty chart overlay explains
+	// itself instead of looking broken.
+	Notes []string `json:"notes"`
+}
+
+type GetGrowthProjectionRequest struct {
+	PersonId int `json:"personId"`
+}
+
+type GetGrowthProjectionResponse struct {
+	Projection GrowthProjection `json:"projection"`
+}
+
+// isPlausibleMeasurement reports whether growth analysis let a value through.
+// A typo or unit mix-up must not steer a projection.
+func isPlausibleMeasurement(gd GrowthData) bool {
+	for _, flag := range gd.Flags {
+		if flag.Kind == GrowthFlagImplausible || flag.Kind == GrowthFlagUnitMixup {
+			return false
+		}
+	}
+	return true
+}
+
+// latestPlausibleHeightCm returns a person's most recent believable height in
+// centimetres.
+func latestPlausibleHeightCm(tx *vbolt.Tx, personId int) (float64, bool) {
+	var latest GrowthData
+	for _, gd := range GetPersonGrowthDataTx(tx, personId) {
+		if gd.MeasurementType != Height || !isPlausibleMeasurement(gd) {
+			continue
+		}
+		if latest.Id == 0 || gd.MeasurementDate.After(latest.MeasurementDate) {
+			latest = gd
+		}
+	}
+	if latest.Id == 0 {
+		return 0, false
+	}
+	return canonicalGrowthValue(Height, latest.Value, latest.Unit), true
+}
+
+// computeMidParentalHeight finds the child's parents and applies the
+// mid-parental formula. Parents recorded in the family tree are used when
+// there are any, wherever they live; otherwise the adults on the child's home
+// roster stand in for them. It needs exactly one father and one mother with a
+// height the viewer is allowed to see; anything else is explained in the
+// returned note rather than guessed at.
+func computeMidParentalHeight(tx *vbolt.Tx, user User, child Person) (*MidParentalHeight, string) {
+	if child.Gender != Male && child.Gender != Female {
+		return nil, "A mid-parental target needs the child's sex."
+	}
+
+	candidates, where := []Person{}, "on the family's roster"
+	if parentIds := GetParentIds(tx, child.Id); len(parentIds) > 0 {
+		where = "recorded as the child's parents"
+		for _, parentId := range parentIds {
+			candidates = append(candidates, GetPersonById(tx, parentId))
+		}
+	} else {
+		for _, p := range GetFamilyPeople(tx, child.FamilyId) {
+			if p.Type == Parent && p.Id != child.Id {
+				candidates = append(candidates, p)
+			}
+		}
+	}
+
+	var fathers, mothers []Person
+	for _, p := range candidates {
+		switch p.Gender {
+		case Male:
+			fathers = append(fathers, p)
+		case Female:
+			mothers = append(mothers, p)
+		}
+	}
+	if len(fathers) != 1 || len(mothers) != 1 {
+		return nil, "A mid-parental target needs one father and one mother " + where + "."
+	}
+	father, mother := fathers[0], mothers[0]
+
+	if !CanAccessPerson(tx, user, father, ScopeGrowth, AccessView) || !CanAccessPerson(tx, user, mother, ScopeGrowth, AccessView) {
+		return nil, "The parents' measurements are not shared with you."
+	}
+	fatherCm, ok := latestPlausibleHeightCm(tx, father.Id)
+	if !ok {
+		return nil, fmt.Sprintf("Record a height for %s to see a mid-parental target.", father.Name)
+	}
+	motherCm, ok := latestPlausibleHeightCm(tx, mother.Id)
+	if !ok {
+		return nil, fmt.Sprintf("Record a height for %s to see a mid-parental target.", mother.Name)
+	}
+
+	offset, sign := midParentalOffsetCm, "plus"
+	if child.Gender == Female {
+		offset, sign = -midParentalOffsetCm, "minus"
+	}
+	target := (fatherCm+motherCm)/2 + offset
+	return &MidParentalHeight{
+		Target:       target,
+		Low:          target - midParentalBandCm,
+		High:         target + midParentalBandCm,
+		Unit:         "cm",
+		FatherId:     father.Id,
+		MotherId:     mother.Id,
+		FatherHeight: fatherCm,
+		MotherHeight: motherCm,
+		Method: fmt.Sprintf("Mid-parental target: the average of the parents' heights %s %g cm. "+
+			"About 95%% of children finish within %g cm of it.", sign, midParentalOffsetCm, midParentalBandCm),
+	}, ""
+}
+
+// projectionAges lists the ages a projection is drawn at: the starting age,
+// then each age the references tabulate after it, monthly under two and
+// yearly after.
+func projectionAges(gender GenderType, measurementType MeasurementType, fromMonths float64) []float64 {
+	ages := []float64{fromMonths}
+	for _, reference := range []string{GrowthReferenceWHO, GrowthReferenceCDC} {
+		for _, point := range growthReferenceTables[lmsKey{reference, measurementType, gender}] {
+			if point.AgeMonths <= fromMonths || growthReferenceFor(point.AgeMonths) != reference {
+				continue
+			}
+			ages = append(ages, point.AgeMonths)
+		}
+	}
+	sort.Float64s(ages)
+	return ages
+}
+
+// projectGrowthSeries tracks the average z-score of the last year's
+// measurements of one type forward to the top of the reference charts.
+// growthData must already carry percentiles.
+func projectGrowthSeries(person Person, growthData []GrowthData, measurementType MeasurementType) (*ProjectionSeries, string) {
+	label := measurementLabel(measurementType)
+
+	var series []GrowthData
+	for _, gd := range growthData {
+		if gd.MeasurementType == measurementType && gd.ZScore != nil && isPlausibleMeasurement(gd) {
+			series = append(series, gd)
+		}
+	}
+	if len(series) == 0 {
+		return nil, fmt.Sprintf("No %s on the reference charts yet to project from.", label)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].MeasurementDate.Before(series[j].MeasurementDate)
+	})
+	latest := series[len(series)-1]
+
+	var sum float64
+	var basedOn []int
+	for _, gd := range series {
+		if latest.MeasurementDate.Sub(gd.MeasurementDate) > projectionWindow {
+			continue
+		}
+		sum += *gd.ZScore
+		basedOn = append(basedOn, gd.Id)
+	}
+	z := math.Max(-maxProjectedZ, math.Min(maxProjectedZ, sum/float64(len(basedOn))))
+
+	projection := &ProjectionSeries{
+		MeasurementType: measurementType,
+		Unit:            measurementUnits[measurementType][0],
+		ZScore:          math.Round(z*100) / 100,
+		Percentile:      math.Round(zScoreToPercentile(z)*10) / 10,
+		BasedOn:         basedOn,
+	}
+	projection.Method = fmt.Sprintf("Percentile tracking: assumes %s stays near the %s percentile, "+
+		"the average of %d measurement(s) over the year to %s, and follows the WHO and CDC reference curves to age 20. "+
+		"The band spans %g z-score either side, about the 16th to 84th percentile of that line; "+
+		"children often drift this far, especially before age 2.",
+		label, ordinal(int(math.Round(projection.Percentile))), len(basedOn),
+		latest.MeasurementDate.Format("Jan 2, 2006"), projectionBandZ)
+
+	for _, age := range projectionAges(person.Gender, measurementType, ageInMonths(person.Birthday, latest.MeasurementDate)) {
+		reference := growthReferenceFor(age)
+		point, ok := lmsAt(growthReferenceTables[lmsKey{reference, measurementType, person.Gender}], age)
+		if !ok {
+			continue
+		}
+		projection.Points = append(projection.Points, ProjectionPoint{
+			AgeMonths: math.Round(age*10) / 10,
+			Date:      person.Birthday.Add(time.Duration(age * daysPerMonth * 24 * float64(time.Hour))),
+			Value:     lmsValue(z, point),
+			Low:       lmsValue(z-projectionBandZ, point),
+			High:      lmsValue(z+projectionBandZ, point),
+		})
+	}
+	if len(projection.Points) == 0 {
+		return nil, fmt.Sprintf("The latest %s is past the end of the reference charts.", label)
+	}
+	return projection, ""
+}
+
+// projectionDisplay picks the units a projection is shown in. A reader who
+// has not chosen a system sees the units of the latest measurement of the kind.
+func projectionDisplay(prefs UserPreferences, growthData []GrowthData, measurementType MeasurementType) UserPreferences {
+	if prefs.UnitSystem != UnitSystemAsEntered {
+		return prefs
+	}
+	var latest GrowthData
+	for _, gd := range growthData {
+		if gd.MeasurementType == measurementType && (latest.Id == 0 || gd.MeasurementDate.After(latest.MeasurementDate)) {
+			latest = gd
+		}
+	}
+	prefs.UnitSystem = UnitSystemMetric
+	if latest.Unit == "in" || latest.Unit == "lbs" {
+		prefs.UnitSystem = UnitSystemImperial
+	}
+	return prefs
+}
+
+// localizeProjection converts a projection from centimetres and kilograms into
+// the reader's units.
+func localizeProjection(prefs UserPreferences, growthData []GrowthData, projection *GrowthProjection) {
+	if mp := projection.MidParental; mp != nil {
+		display := projectionDisplay(prefs, growthData, Height)
+		from := mp.Unit
+		for _, v := range []*float64{&mp.Target, &mp.Low, &mp.High, &mp.FatherHeight, &mp.MotherHeight} {
+			*v, mp.Unit = display.displayGrowthValue(Height, *v, from)
+		}
+	}
+	for _, series := range []*ProjectionSeries{projection.Height, projection.Weight} {
+		if series == nil {
+			continue
+		}
+		display := projectionDisplay(prefs, growthData, series.MeasurementType)
+		from := series.Unit
+		for i := range series.Points {
+			p := &series.Points[i]
+			for _, v := range []*float64{&p.Value, &p.Low, &p.High} {
+				*v, series.Unit = display.displayGrowthValue(series.MeasurementType, *v, from)
+			}
+		}
+	}
+}
+
+// BuildGrowthProjectionTx assembles the whole projection for one person as the
+// given user may see it. The caller has already checked growth access to the
+// person themselves.
+func BuildGrowthProjectionTx(tx *vbolt.Tx, user User, person Person) GrowthProjection {
+	projection := GrowthProjection{PersonId: person.Id, Notes: []string{}}
+	if person.IsPregnancy {
+		projection.Notes = append(projection.Notes, "Projections start once the baby is born.")
+		return projection
+	}
+	if person.InMemoriam {
+		projection.Notes = append(projection.Notes, "Projections are not made for someone who has passed away.")
+		return projection
+	}
+
+	var note string
+	if projection.MidParental, note = computeMidParentalHeight(tx, user, person); note != "" {
+		projection.Notes = append(projection.Notes, note)
+	}
+
+	growthData := GetPersonGrowthWithPercentilesTx(tx, person)
+	if projection.Height, note = projectGrowthSeries(person, growthData, Height); note != "" {
+		projection.Notes = append(projection.Notes, note)
+	}
+	if projection.Weight, note = projectGrowthSeries(person, growthData, Weight); note != "" {
+		projection.Notes = append(projection.Notes, note)
+	}
+
+	localizeProjection(loadUserPreferences(tx, user.Id), growthData, &projection)
+	return projection
+}
+
+// vbeam procedures
+
+func GetGrowthProjection(ctx *vbeam.Context, req GetGrowthProjectionRequest) (resp GetGrowthProjectionResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if req.PersonId <= 0 {
+		err = errors.New("Person ID is required")
+		return
+	}
+
+	person := GetPersonById(ctx.Tx, req.PersonId)
+	if !CanAccessPerson(ctx.Tx, user, person, ScopeGrowth, AccessView) {
+		err = errors.New("Person not found or not in your family")
+		return
+	}
+
+	resp.Projection = BuildGrowthProjectionTx(ctx.Tx, user, person)
+	return
+}
@@ -0,0 +1,255 @@
+package backend
+
+import (
+	"family/cfg"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestProjectGrowthSeriesTracksTheCurve(t *testing.T) {
+	birthday := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	boy := Person{Id: 1, Gender: Male, Birthday: birthday}
+
+	// Two heights exactly on the median, a year apart, plus an older
+	// outlier that falls outside the averaging window and a typo that growth
+	// analysis already flagged.
+	growth := annotateGrowthPercentiles(boy, []GrowthData{
+		{Id: 1, MeasurementType: Height, Value: 60, Unit: "cm", MeasurementDate: birthday.AddDate(0, 2, 0)},
+		{Id: 2, MeasurementType: Height, Value: 86.45, Unit: "cm", MeasurementDate: birthday.AddDate(2, 0, 0)},
+		{Id: 3, MeasurementType: Height, Value: 95.27, Unit: "cm", MeasurementDate: birthday.AddDate(3, 0, 0)},
+		{Id: 4, MeasurementType: Height, Value: 9.5, Unit: "cm", MeasurementDate: birthday.AddDate(2, 6, 0),
+			Flags: []GrowthFlag{{Kind: GrowthFlagImplausible}}},
+	})
+
+	series, note := projectGrowthSeries(boy, growth, Height)
+	if series == nil {
+		t.Fatalf("expected a projection, got note %q", note)
+	}
+	if len(series.BasedOn) != 2 || series.BasedOn[0] != 2 || series.BasedOn[1] != 3 {
+		t.Errorf("BasedOn = %v, want the two plausible heights from the last year", series.BasedOn)
+	}
+	if math.Abs(series.ZScore) > 0.05 {
+		t.Errorf("ZScore = %v, want about 0 for a child on the median", series.ZScore)
+	}
+	if !strings.Contains(series.Method, "Percentile tracking") || !strings.Contains(series.Method, "band") {
+		t.Errorf("Method should name the method and the band, got %q", series.Method)
+	}
+
+	first := series.Points[0]
+	if math.Abs(first.AgeMonths-ageInMonths(birthday, birthday.AddDate(3, 0, 0))) > 0.1 {
+		t.Errorf("the projection should start at the latest measurement, starts at %v months", first.AgeMonths)
+	}
+	last := series.Points[len(series.Points)-1]
+	if last.AgeMonths != cdcReferenceMonths {
+		t.Errorf("the projection should end at 20 years, ends at %v months", last.AgeMonths)
+	}
+	if math.Abs(last.Value-176.85) > 0.5 {
+		t.Errorf("adult height = %v, want the CDC median of about 176.85 cm", last.Value)
+	}
+	for i, p := range series.Points {
+		if !(p.Low < p.Value && p.Value < p.High) {
+			t.Errorf("point %d band %v..%v does not surround %v", i, p.Low, p.High, p.Value)
+		}
+		if i > 0 && p.AgeMonths <= series.Points[i-1].AgeMonths {
+			t.Errorf("point %d is not after the one before it", i)
+		}
+	}
+
+	t.Run("nothing to project from", func(t *testing.T) {
+		if series, note := projectGrowthSeries(boy, growth, Weight); series != nil || note == "" {
+			t.Errorf("expected a note and no weight projection, got %+v", series)
+		}
+	})
+}
+
+type projectionFixture struct {
+	db     *vbolt.DB
+	parent User
+	dad    Person
+	mom    Person
+	son    Person
+	girl   Person
+}
+
+func setupProjectionFixture(t *testing.T) projectionFixture {
+	t.Helper()
+
+	db := vbolt.Open(t.TempDir() + "/projection.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	t.Cleanup(func() { _ = db.Close() })
+	appDb = db
+	jwtKey = []byte("projection-test-secret-key-at-least-32")
+
+	fx := projectionFixture{db: db}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		fx.parent = AddUserTx(tx, CreateAccountRequest{Name: "Parent", Email: "parent@example.com"}, hash)
+		add := func(name string, personType int, gender int, birthdate string) Person {
+			person, err := AddPersonTx(tx, AddPersonRequest{Name: name, PersonType: personType, Gender: gender, Birthdate: birthdate}, fx.parent.FamilyId)
+			if err != nil {
+				t.Fatalf("AddPersonTx(%s) error = %v", name, err)
+			}
+			return person
+		}
+		fx.dad = add("Dad", 0, 0, "1985-01-01")
+		fx.mom = add("Mom", 0, 1, "1986-01-01")
+		fx.son = add("Son", 1, 0, "2020-01-01")
+		fx.girl = add("Girl", 1, 1, "2021-01-01")
+
+		measure := func(person Person, value float64, unit string, date string) {
+			if _, err := AddGrowthDataTx(tx, AddGrowthDataRequest{
+				PersonId: person.Id, MeasurementType: "height", Value: value, Unit: unit,
+				InputType: "date", MeasurementDate: stringPtr(date),
+			}, fx.parent.FamilyId); err != nil {
+				t.Fatalf("AddGrowthDataTx() error = %v", err)
+			}
+		}
+		// Dad's older height is superseded by his latest one.
+		measure(fx.dad, 170, "cm", "2010-01-01")
+		measure(fx.dad, 180, "cm", "2023-01-01")
+		measure(fx.mom, 65, "in", "2023-01-01") // 165.1 cm
+		measure(fx.son, 95.27, "cm", "2023-01-01")
+		vbolt.TxCommit(tx)
+	})
+	return fx
+}
+
+func (fx projectionFixture) project(t *testing.T, user User, personId int) (GrowthProjection, error) {
+	t.Helper()
+
+	token, err := generateJwtTokenString(user)
+	if err != nil {
+		t.Fatalf("generateJwtTokenString() error = %v", err)
+	}
+	var resp GetGrowthProjectionResponse
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		resp, err = GetGrowthProjection(&vbeam.Context{Tx: tx, Token: token}, GetGrowthProjectionRequest{PersonId: personId})
+	})
+	return resp.Projection, err
+}
+
+func TestMidParentalHeight(t *testing.T) {
+	fx := setupProjectionFixture(t)
+
+	son, err := fx.project(t, fx.parent, fx.son.Id)
+	if err != nil {
+		t.Fatalf("GetGrowthProjection() error = %v", err)
+	}
+	mp := son.MidParental
+	if mp == nil {
+		t.Fatalf("expected a mid-parental target, notes: %v", son.Notes)
+	}
+	// (180 + 165.1) / 2 + 6.5
+	if math.Abs(mp.Target-179.05) > 0.01 || mp.Unit != "cm" {
+		t.Errorf("target = %v %s, want 179.05 cm", mp.Target, mp.Unit)
+	}
+	if math.Abs(mp.High-mp.Low-2*midParentalBandCm) > 0.01 {
+		t.Errorf("band %v..%v is not ±%v cm", mp.Low, mp.High, midParentalBandCm)
+	}
+	if mp.FatherId != fx.dad.Id || mp.MotherId != fx.mom.Id {
+		t.Errorf("parents = %d, %d", mp.FatherId, mp.MotherId)
+	}
+	if son.Height == nil {
+		t.Errorf("expected a height projection, notes: %v", son.Notes)
+	}
+
+	girl, err := fx.project(t, fx.parent, fx.girl.Id)
+	if err != nil {
+		t.Fatalf("GetGrowthProjection() error = %v", err)
+	}
+	if girl.MidParental == nil || math.Abs(girl.MidParental.Target-166.05) > 0.01 {
+		t.Errorf("a girl's target should subtract the offset, got %+v", girl.MidParental)
+	}
+	if girl.Height != nil || len(girl.Notes) == 0 {
+		t.Error("a child with no measurements should get a note instead of a projection")
+	}
+}
+
+func TestGrowthProjectionFollowsReaderUnits(t *testing.T) {
+	fx := setupProjectionFixture(t)
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		prefs := UserPreferences{UserId: fx.parent.Id, UnitSystem: UnitSystemImperial, DateFormat: DateFormatLong}
+		vbolt.Write(tx, UserPreferencesBkt, prefs.UserId, &prefs)
+		vbolt.TxCommit(tx)
+	})
+
+	son, err := fx.project(t, fx.parent, fx.son.Id)
+	if err != nil {
+		t.Fatalf("GetGrowthProjection() error = %v", err)
+	}
+	if son.MidParental.Unit != "in" || math.Abs(son.MidParental.Target-70.49) > 0.01 {
+		t.Errorf("target = %v %s, want about 70.49 in", son.MidParental.Target, son.MidParental.Unit)
+	}
+	last := son.Height.Points[len(son.Height.Points)-1]
+	if son.Height.Unit != "in" || math.Abs(last.Value-69.63) > 0.2 {
+		t.Errorf("adult projection = %v %s, want about 69.6 in", last.Value, son.Height.Unit)
+	}
+}
+
+func TestGrowthProjectionNeedsTwoParents(t *testing.T) {
+	fx := setupProjectionFixture(t)
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		// A second father makes the pair ambiguous.
+		if _, err := AddPersonTx(tx, AddPersonRequest{Name: "Stepdad", PersonType: 0, Gender: 0, Birthdate: "1984-01-01"}, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	son, err := fx.project(t, fx.parent, fx.son.Id)
+	if err != nil {
+		t.Fatalf("GetGrowthProjection() error = %v", err)
+	}
+	if son.MidParental != nil {
+		t.Error("expected no target when the parents are ambiguous")
+	}
+	if len(son.Notes) == 0 || !strings.Contains(son.Notes[0], "one father and one mother") {
+		t.Errorf("notes = %v", son.Notes)
+	}
+}
+
+// Recorded parents settle what the roster cannot: with the tree saying who
+// the son's parents are, a second man on the roster no longer matters.
+func TestGrowthProjectionPrefersRecordedParents(t *testing.T) {
+	fx := setupProjectionFixture(t)
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if _, err := AddPersonTx(tx, AddPersonRequest{Name: "Stepdad", PersonType: 0, Gender: 0, Birthdate: "1984-01-01"}, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		for _, parent := range []Person{fx.dad, fx.mom} {
+			if _, err := AddPersonRelationTx(tx, RelationParent, parent.Id, fx.son.Id, fx.parent.FamilyId); err != nil {
+				t.Fatalf("AddPersonRelationTx() error = %v", err)
+			}
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	son, err := fx.project(t, fx.parent, fx.son.Id)
+	if err != nil {
+		t.Fatalf("GetGrowthProjection() error = %v", err)
+	}
+	if son.MidParental == nil || son.MidParental.FatherId != fx.dad.Id || son.MidParental.MotherId != fx.mom.Id {
+		t.Errorf("mid-parental = %+v, notes %v", son.MidParental, son.Notes)
+	}
+}
+
+func TestGrowthProjectionRequiresAccess(t *testing.T) {
+	fx := setupProjectionFixture(t)
+	var outsider User
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+		outsider = AddUserTx(tx, CreateAccountRequest{Name: "Outsider", Email: "outsider@example.com"}, hash)
+		vbolt.TxCommit(tx)
+	})
+
+	if _, err := fx.project(t, outsider, fx.son.Id); err == nil {
+		t.Error("an outsider was shown another family's projection")
+	}
+}
@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"errors"
+	"family/cfg"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// A measurement is only as good as where it came from: a pediatrician's
+// stadiometer and a pencil mark on the kitchen doorframe both go in as a
+// number and a date, and without a record of which is which nobody reading the
+// chart later can tell how much to trust a point. GrowthData therefore carries
+// a Source, free-text Notes, and any number of photos from ImagesBkt — a
+// picture of the clinic printout, the school nurse's slip.
+//
+// The photo link is a join row rather than a list on the measurement, for the
+// same reason MilestonePhoto is: a photo can be deleted on its own, and the
+// by-photo index is what lets deletePhotoRecordTx find and clear every
+// measurement that pointed at it.
+
+// Measurement sources. The empty string is every row recorded before sources
+// existed, and reads as "not recorded" rather than as any of the others.
+const (
+	GrowthSourceUnknown  = ""
+	GrowthSourceClinic   = "clinic"
+	GrowthSourceHome     = "home"
+	GrowthSourceSchool   = "school"
+	GrowthSourceImported = "imported"
+)
+
+var growthSources = map[string]bool{
+	GrowthSourceUnknown:  true,
+	GrowthSourceClinic:   true,
+	GrowthSourceHome:     true,
+	GrowthSourceSchool:   true,
+	GrowthSourceImported: true,
+}
+
+func validateGrowthSource(source string) error {
+	if !growthSources[source] {
+		return errors.New("Source must be 'clinic', 'home', 'school' or 'imported'")
+	}
+	return nil
+}
+
+// GrowthPhoto links a measurement to a photo documenting it.
+type GrowthPhoto struct {
+	Id           int       `json:"id"`
+	GrowthDataId int       `json:"growthDataId"`
+	PhotoId      int       `json:"photoId"`
+	FamilyId     int       `json:"familyId"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func PackGrowthPhoto(self *GrowthPhoto, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.GrowthDataId, buf)
+	vpack.Int(&self.PhotoId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var GrowthPhotoBkt = vbolt.Bucket(&cfg.Info, "growth_photos", vpack.FInt, PackGrowthPhoto)
+
+// GrowthPhotoByGrowthIndex: term = growth_data_id, target = growth_photo_id
+var GrowthPhotoByGrowthIndex = vbolt.Index(&cfg.Info, "growth_photo_by_growth", vpack.FInt, vpack.FInt)
+
+// GrowthPhotoByPhotoIndex: term = photo_id, target = growth_photo_id
+var GrowthPhotoByPhotoIndex = vbolt.Index(&cfg.Info, "growth_photo_by_photo", vpack.FInt, vpack.FInt)
+
+// GrowthPhotoByFamilyIndex: term = family_id, target = growth_photo_id
+var GrowthPhotoByFamilyIndex = vbolt.Index(&cfg.Info, "growth_photo_by_family", vpack.FInt, vpack.FInt)
+
+func GetGrowthPhotoIds(tx *vbolt.Tx, growthDataId int) []int {
+	growthPhotos := readByTerm(tx, GrowthPhotoByGrowthIndex, GrowthPhotoBkt, growthDataId)
+	photoIds := make([]int, 0, len(growthPhotos))
+	for _, growthPhoto := range growthPhotos {
+		photoIds = append(photoIds, growthPhoto.PhotoId)
+	}
+	return photoIds
+}
+
+// withGrowthPhotoIds fills in PhotoIds, which is stored as join rows and never
+// packed with the measurement. A household shown measurements by a link that
+// does not carry photos gets only the ids it could load.
+func withGrowthPhotoIds(tx *vbolt.Tx, user User, growthData []GrowthData) []GrowthData {
+	for i := range growthData {
+		growthData[i].PhotoIds = visiblePhotoIds(tx, user, GetGrowthPhotoIds(tx, growthData[i].Id))
+	}
+	return growthData
+}
+
+func deleteGrowthPhotoTx(tx *vbolt.Tx, growthPhoto GrowthPhoto) {
+	vbolt.Delete(tx, GrowthPhotoBkt, growthPhoto.Id)
+	vbolt.SetTargetSingleTerm(tx, GrowthPhotoByGrowthIndex, growthPhoto.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, GrowthPhotoByPhotoIndex, growthPhoto.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, GrowthPhotoByFamilyIndex, growthPhoto.Id, -1)
+}
+
+// validateGrowthPhotos checks every photo before any join is written, so a
+// bad id in the list cannot leave the measurement half-linked.
+func validateGrowthPhotos(tx *vbolt.Tx, photoIds []int, familyId int) error {
+	for _, photoId := range normalizePhotoIds(photoIds) {
+		if err := validatePhotoAccess(tx, photoId, familyId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setGrowthPhotosTx makes the measurement's photos exactly photoIds, adding
+// and removing joins as needed. The caller has already run
+// validateGrowthPhotos.
+func setGrowthPhotosTx(tx *vbolt.Tx, growthData GrowthData, photoIds []int) {
+	desired := make(map[int]bool)
+	for _, photoId := range normalizePhotoIds(photoIds) {
+		desired[photoId] = true
+	}
+
+	for _, growthPhoto := range readByTerm(tx, GrowthPhotoByGrowthIndex, GrowthPhotoBkt, growthData.Id) {
+		if desired[growthPhoto.PhotoId] {
+			delete(desired, growthPhoto.PhotoId)
+			continue
+		}
+		deleteGrowthPhotoTx(tx, growthPhoto)
+	}
+
+	for _, photoId := range normalizePhotoIds(photoIds) {
+		if !desired[photoId] {
+			continue
+		}
+		addGrowthPhotoTx(tx, growthData, photoId)
+	}
+}
+
+func addGrowthPhotoTx(tx *vbolt.Tx, growthData GrowthData, photoId int) {
+	growthPhoto := GrowthPhoto{
+		Id:           vbolt.NextIntId(tx, GrowthPhotoBkt),
+		GrowthDataId: growthData.Id,
+		PhotoId:      photoId,
+		FamilyId:     growthData.FamilyId,
+		CreatedAt:    time.Now(),
+	}
+	vbolt.Write(tx, GrowthPhotoBkt, growthPhoto.Id, &growthPhoto)
+	vbolt.SetTargetSingleTerm(tx, GrowthPhotoByGrowthIndex, growthPhoto.Id, growthData.Id)
+	vbolt.SetTargetSingleTerm(tx, GrowthPhotoByPhotoIndex, growthPhoto.Id, photoId)
+	vbolt.SetTargetSingleTerm(tx, GrowthPhotoByFamilyIndex, growthPhoto.Id, growthData.FamilyId)
+}
+
+// removeAllGrowthPhotos clears a measurement's joins when the measurement is
+// deleted, as removeAllMilestonePhotos does for a milestone.
+func removeAllGrowthPhotos(tx *vbolt.Tx, growthDataId int) {
+	for _, growthPhoto := range readByTerm(tx, GrowthPhotoByGrowthIndex, GrowthPhotoBkt, growthDataId) {
+		deleteGrowthPhotoTx(tx, growthPhoto)
+	}
+}
+
+// removePhotoFromGrowthData clears a photo's joins when the photo is deleted,
+// as removePhotoFromMilestones does. Called from deletePhotoRecordTx.
+func removePhotoFromGrowthData(tx *vbolt.Tx, photoId int) {
+	for _, growthPhoto := range readByTerm(tx, GrowthPhotoByPhotoIndex, GrowthPhotoBkt, photoId) {
+		deleteGrowthPhotoTx(tx, growthPhoto)
+	}
+}
@@ -0,0 +1,259 @@
+package backend
+
+import (
+	"family/cfg"
+	"testing"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type provenanceFixture struct {
+	db         *vbolt.DB
+	parent     User
+	outsider   User
+	child      Person
+	printout   Image
+	theirPhoto Image
+}
+
+func setupProvenanceFixture(t *testing.T) provenanceFixture {
+	t.Helper()
+
+	db := vbolt.Open(t.TempDir() + "/provenance.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	t.Cleanup(func() { _ = db.Close() })
+	appDb = db
+	jwtKey = []byte("provenance-test-secret-key-at-least-32")
+
+	fx := provenanceFixture{db: db}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		fx.parent = AddUserTx(tx, CreateAccountRequest{Name: "Parent", Email: "parent@example.com"}, hash)
+		fx.outsider = AddUserTx(tx, CreateAccountRequest{Name: "Outsider", Email: "outsider@example.com"}, hash)
+
+		var err error
+		fx.child, err = AddPersonTx(tx, AddPersonRequest{Name: "Kid", PersonType: 1, Gender: 0, Birthdate: "2020-01-01"}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		fx.printout = writeTestImage(tx, fx.parent.FamilyId, fx.parent.Id, "printout.jpg")
+		fx.theirPhoto = writeTestImage(tx, fx.outsider.FamilyId, fx.outsider.Id, "theirs.jpg")
+		vbolt.TxCommit(tx)
+	})
+	return fx
+}
+
+func (fx provenanceFixture) call(t *testing.T, fn func(ctx *vbeam.Context)) {
+	t.Helper()
+	token, err := generateJwtTokenString(fx.parent)
+	if err != nil {
+		t.Fatalf("generateJwtTokenString() error = %v", err)
+	}
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		fn(&vbeam.Context{Tx: tx, Token: token})
+	})
+}
+
+func clinicHeight(fx provenanceFixture, photoIds []int) AddGrowthDataRequest {
+	return AddGrowthDataRequest{
+		PersonId: fx.child.Id, MeasurementType: "height", Value: 100, Unit: "cm",
+		InputType: "date", MeasurementDate: stringPtr("2024-03-04"),
+		Source: GrowthSourceClinic, Notes: "  Measured barefoot  ", PhotoIds: photoIds,
+	}
+}
+
+func TestValidateGrowthSource(t *testing.T) {
+	for _, source := range []string{"", "clinic", "home", "school", "imported"} {
+		if err := validateGrowthSource(source); err != nil {
+			t.Errorf("validateGrowthSource(%q) = %v", source, err)
+		}
+	}
+	if err := validateGrowthSource("doorframe"); err == nil {
+		t.Error("an unknown source was accepted")
+	}
+
+	req := clinicHeight(provenanceFixture{}, nil)
+	req.PersonId = 1
+	req.Source = "doorframe"
+	if err := validateAddGrowthDataRequest(req); err == nil {
+		t.Error("AddGrowthData accepted an unknown source")
+	}
+}
+
+func TestMeasurementProvenanceIsStored(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	var added GrowthData
+	fx.call(t, func(ctx *vbeam.Context) {
+		resp, err := AddGrowthData(ctx, clinicHeight(fx, []int{fx.printout.Id, fx.printout.Id}))
+		if err != nil {
+			t.Fatalf("AddGrowthData() error = %v", err)
+		}
+		added = resp.GrowthData
+	})
+
+	if added.Source != GrowthSourceClinic || added.Notes != "Measured barefoot" {
+		t.Errorf("provenance = %q, %q", added.Source, added.Notes)
+	}
+	if len(added.PhotoIds) != 1 || added.PhotoIds[0] != fx.printout.Id {
+		t.Errorf("photoIds = %v, want the printout once", added.PhotoIds)
+	}
+
+	// An edit from a client that knows nothing of provenance leaves it alone.
+	fx.call(t, func(ctx *vbeam.Context) {
+		resp, err := UpdateGrowthData(ctx, UpdateGrowthDataRequest{
+			Id: added.Id, MeasurementType: "height", Value: 101, Unit: "cm",
+			InputType: "date", MeasurementDate: stringPtr("2024-03-04"),
+		})
+		if err != nil {
+			t.Fatalf("UpdateGrowthData() error = %v", err)
+		}
+		if resp.GrowthData.Source != GrowthSourceClinic || resp.GrowthData.Notes != "Measured barefoot" || len(resp.GrowthData.PhotoIds) != 1 {
+			t.Errorf("an edit without provenance changed it: %+v", resp.GrowthData)
+		}
+	})
+
+	// An empty list, as opposed to none, unlinks.
+	fx.call(t, func(ctx *vbeam.Context) {
+		resp, err := UpdateGrowthData(ctx, UpdateGrowthDataRequest{
+			Id: added.Id, MeasurementType: "height", Value: 101, Unit: "cm",
+			InputType: "date", MeasurementDate: stringPtr("2024-03-04"),
+			Source: stringPtr(GrowthSourceHome), PhotoIds: []int{},
+		})
+		if err != nil {
+			t.Fatalf("UpdateGrowthData() error = %v", err)
+		}
+		if resp.GrowthData.Source != GrowthSourceHome || len(resp.GrowthData.PhotoIds) != 0 {
+			t.Errorf("after the edit = %+v", resp.GrowthData)
+		}
+	})
+	if got := countRows(t, fx.db, GrowthPhotoBkt); got != 0 {
+		t.Errorf("growth photos remaining = %d", got)
+	}
+}
+
+func TestMeasurementRefusesAnotherFamilysPhoto(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		if _, err := AddGrowthData(ctx, clinicHeight(fx, []int{fx.printout.Id, fx.theirPhoto.Id})); err == nil {
+			t.Error("a measurement was linked to another family's photo")
+		}
+	})
+	if got := countRows(t, fx.db, GrowthDataBkt); got != 0 {
+		t.Errorf("growth rows = %d, want the refused add to leave nothing", got)
+	}
+	if got := countRows(t, fx.db, GrowthPhotoBkt); got != 0 {
+		t.Errorf("growth photos = %d, want the refused add to leave nothing", got)
+	}
+}
+
+func TestDeletingEitherSideClearsTheGrowthPhoto(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	var first, second GrowthData
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		if first, err = AddGrowthDataTx(tx, clinicHeight(fx, []int{fx.printout.Id}), fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddGrowthDataTx() error = %v", err)
+		}
+		weight := clinicHeight(fx, []int{fx.printout.Id})
+		weight.MeasurementType, weight.Value, weight.Unit = "weight", 16, "kg"
+		if second, err = AddGrowthDataTx(tx, weight, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddGrowthDataTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+	if got := countRows(t, fx.db, GrowthPhotoBkt); got != 2 {
+		t.Fatalf("growth photos = %d, want 2", got)
+	}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if err := DeleteGrowthDataTx(tx, first.Id, fx.parent.FamilyId); err != nil {
+			t.Fatalf("DeleteGrowthDataTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+	if got := countRows(t, fx.db, GrowthPhotoBkt); got != 1 {
+		t.Errorf("growth photos after deleting a measurement = %d, want 1", got)
+	}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		deletePhotoRecordTx(tx, fx.printout)
+		vbolt.TxCommit(tx)
+	})
+	if got := countRows(t, fx.db, GrowthPhotoBkt); got != 0 {
+		t.Errorf("growth photos after deleting the photo = %d, want 0", got)
+	}
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if GetGrowthDataById(tx, second.Id).Id == 0 {
+			t.Error("deleting the photo took the measurement with it")
+		}
+	})
+}
+
+func TestMeasurementProvenanceRoundTrips(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	var exported ExportDataStructure
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if _, err := AddGrowthDataTx(tx, clinicHeight(fx, []int{fx.printout.Id}), fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddGrowthDataTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		if exported, err = buildExportData(tx, fx.parent.FamilyId); err != nil {
+			t.Fatalf("buildExportData() error = %v", err)
+		}
+	})
+
+	height := exported.Heights[0]
+	if height.Source != GrowthSourceClinic || height.Notes != "Measured barefoot" || len(height.PhotoIds) != 1 {
+		t.Fatalf("exported height = %+v", height)
+	}
+
+	// Into the outsider's family, as a bundle would: a new person and a new
+	// copy of the photo.
+	var restoredPhoto Image
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		person, err := AddPersonTx(tx, AddPersonRequest{Name: "Kid", PersonType: 1, Gender: 0, Birthdate: "2020-01-01"}, fx.outsider.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		restoredPhoto = writeTestImage(tx, fx.outsider.FamilyId, fx.outsider.Id, "printout.jpg")
+
+		// A row from a file written before provenance existed.
+		legacy := height
+		legacy.Source, legacy.Notes, legacy.PhotoIds = "", "", nil
+		legacy.Date = legacy.Date.AddDate(0, 6, 0)
+
+		mapping := map[int]int{fx.child.Id: person.Id}
+		photoMapping := map[int]int{fx.printout.Id: restoredPhoto.Id}
+		imported, _, errs := importMeasurements(tx, []ImportHeight{height, legacy}, nil, mapping, fx.outsider.FamilyId, photoMapping)
+		if imported != 2 || len(errs) != 0 {
+			t.Fatalf("imported %d, errors %v", imported, errs)
+		}
+
+		rows := GetPersonGrowthDataTx(tx, person.Id)
+		sources := map[string]GrowthData{}
+		for _, gd := range rows {
+			sources[gd.Source] = gd
+		}
+		clinic, ok := sources[GrowthSourceClinic]
+		if !ok || clinic.Notes != "Measured barefoot" {
+			t.Errorf("the clinic row did not keep its provenance: %+v", rows)
+		}
+		if got := GetGrowthPhotoIds(tx, clinic.Id); len(got) != 1 || got[0] != restoredPhoto.Id {
+			t.Errorf("restored photo links = %v, want the new copy of the printout", got)
+		}
+		if _, ok := sources[GrowthSourceImported]; !ok {
+			t.Errorf("a row with no recorded source should come in as imported: %+v", rows)
+		}
+		vbolt.TxCommit(tx)
+	})
+}
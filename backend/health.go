@@ -0,0 +1,584 @@
+package backend
+
+// Health records: vaccinations, and the visits, illnesses, allergies and
+// medications a parent is asked about at every appointment.
+//
+// Vaccinations have a record of their own because the schedule reads them:
+// which vaccine and which dose is what decides what is due. The other four
+// share one shape — what it was, when it started and ended, who was involved,
+// and a detail that means the dosage of a medication, the reaction to an
+// allergen, or the diagnosis from a visit — and are one HealthRecord with a
+// Kind rather than four near-identical stores.
+//
+// Everything here is medical, and is read through ScopeHealth. No link carries
+// it unless the granting family turns it on for that link specifically;
+// sharing growth does not imply it.
+
+import (
+	"errors"
+	"family/cfg"
+	"sort"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+func RegisterHealthMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, GetPersonHealth)
+	vbeam.RegisterProc(app, GetVaccineSchedule)
+	vbeam.RegisterProc(app, AddVaccination)
+	vbeam.RegisterProc(app, UpdateVaccination)
+	vbeam.RegisterProc(app, DeleteVaccination)
+	vbeam.RegisterProc(app, AddHealthRecord)
+	vbeam.RegisterProc(app, UpdateHealthRecord)
+	vbeam.RegisterProc(app, DeleteHealthRecord)
+}
+
+// Health record kinds
+const (
+	HealthVisit      = "visit"
+	HealthIllness    = "illness"
+	HealthAllergy    = "allergy"
+	HealthMedication = "medication"
+)
+
+var healthRecordKinds = map[string]bool{
+	HealthVisit:      true,
+	HealthIllness:    true,
+	HealthAllergy:    true,
+	HealthMedication: true,
+}
+
+const maxHealthTextLength = 200
+const maxHealthNotesLength = 2000
+
+type Vaccination struct {
+	Id        int       `json:"id"`
+	PersonId  int       `json:"personId"`
+	FamilyId  int       `json:"familyId"`
+	Vaccine   string    `json:"vaccine"` // the schedule key when the schedule lists it, as entered otherwise
+	Dose      int       `json:"dose"`    // 1-based; 0 when not known
+	Date      time.Time `json:"date"`
+	Provider  string    `json:"provider"`
+	LotNumber string    `json:"lotNumber"`
+	Notes     string    `json:"notes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func PackVaccination(self *Vaccination, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.String(&self.Vaccine, buf)
+	vpack.Int(&self.Dose, buf)
+	vpack.Time(&self.Date, buf)
+	vpack.String(&self.Provider, buf)
+	vpack.String(&self.LotNumber, buf)
+	vpack.String(&self.Notes, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var VaccinationBkt = vbolt.Bucket(&cfg.Info, "vaccination", vpack.FInt, PackVaccination)
+
+// VaccinationByPersonIndex: term = person_id, target = vaccination_id
+var VaccinationByPersonIndex = vbolt.Index(&cfg.Info, "vaccination_by_person", vpack.FInt, vpack.FInt)
+
+// VaccinationByFamilyIndex: term = family_id, target = vaccination_id
+var VaccinationByFamilyIndex = vbolt.Index(&cfg.Info, "vaccination_by_family", vpack.FInt, vpack.FInt)
+
+// HealthRecord is a visit, illness, allergy or medication.
+type HealthRecord struct {
+	Id        int       `json:"id"`
+	PersonId  int       `json:"personId"`
+	FamilyId  int       `json:"familyId"`
+	Kind      string    `json:"kind"`
+	Title     string    `json:"title"`     // reason for the visit, the illness, the allergen, the medication
+	StartDate time.Time `json:"startDate"` // zero for an allergy nobody dated
+	EndDate   time.Time `json:"endDate"`   // zero while it is ongoing, and for a visit
+	Provider  string    `json:"provider"`  // the doctor or clinic; the prescriber of a medication
+	Detail    string    `json:"detail"`    // dosage, reaction or diagnosis
+	Notes     string    `json:"notes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func PackHealthRecord(self *HealthRecord, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.String(&self.Kind, buf)
+	vpack.String(&self.Title, buf)
+	vpack.Time(&self.StartDate, buf)
+	vpack.Time(&self.EndDate, buf)
+	vpack.String(&self.Provider, buf)
+	vpack.String(&self.Detail, buf)
+	vpack.String(&self.Notes, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var HealthRecordBkt = vbolt.Bucket(&cfg.Info, "health_record", vpack.FInt, PackHealthRecord)
+
+// HealthRecordByPersonIndex: term = person_id, target = health_record_id
+var HealthRecordByPersonIndex = vbolt.Index(&cfg.Info, "health_record_by_person", vpack.FInt, vpack.FInt)
+
+// HealthRecordByFamilyIndex: term = family_id, target = health_record_id
+var HealthRecordByFamilyIndex = vbolt.Index(&cfg.Info, "health_record_by_family", vpack.FInt, vpack.FInt)
+
+// Request/Response types
+
+// VaccinationFields is what a client sends for a vaccination, new or edited.
+type VaccinationFields struct {
+	Vaccine   string `json:"vaccine"`
+	Dose      int    `json:"dose,omitempty"`
+	Date      string `json:"date"` // YYYY-MM-DD
+	Provider  string `json:"provider,omitempty"`
+	LotNumber string `json:"lotNumber,omitempty"`
+	Notes     string `json:"notes,omitempty"`
+}
+
+type AddVaccinationRequest struct {
+	PersonId int `json:"personId"`
+	VaccinationFields
+}
+
+type UpdateVaccinationRequest struct {
+	Id int `json:"id"`
+	VaccinationFields
+}
+
+type VaccinationResponse struct {
+	Vaccination Vaccination `json:"vaccination"`
+}
+
+// HealthRecordFields is what a client sends for a health record, new or
+// edited.
+type HealthRecordFields struct {
+	Kind      string `json:"kind"`
+	Title     string `json:"title"`
+	StartDate string `json:"startDate,omitempty"` // YYYY-MM-DD
+	EndDate   string `json:"endDate,omitempty"`   // YYYY-MM-DD
+	Provider  string `json:"provider,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	Notes     string `json:"notes,omitempty"`
+}
+
+type AddHealthRecordRequest struct {
+	PersonId int `json:"personId"`
+	HealthRecordFields
+}
+
+type UpdateHealthRecordRequest struct {
+	Id int `json:"id"`
+	HealthRecordFields
+}
+
+type HealthRecordResponse struct {
+	HealthRecord HealthRecord `json:"healthRecord"`
+}
+
+type DeleteHealthItemRequest struct {
+	Id int `json:"id"`
+}
+
+type DeleteHealthItemResponse struct {
+	Success bool `json:"success"`
+}
+
+type GetPersonHealthRequest struct {
+	PersonId int `json:"personId"`
+}
+
+type GetPersonHealthResponse struct {
+	Vaccinations []Vaccination  `json:"vaccinations"` // newest first
+	Records      []HealthRecord `json:"records"`      // newest first
+	Due          []DueVaccine   `json:"due"`          // earliest first
+}
+
+// Database helper functions
+
+func GetVaccinationById(tx *vbolt.Tx, id int) (vaccination Vaccination) {
+	vbolt.Read(tx, VaccinationBkt, id, &vaccination)
+	return
+}
+
+func GetHealthRecordById(tx *vbolt.Tx, id int) (record HealthRecord) {
+	vbolt.Read(tx, HealthRecordBkt, id, &record)
+	return
+}
+
+// GetPersonVaccinations returns the person's vaccinations, newest first.
+func GetPersonVaccinations(tx *vbolt.Tx, personId int) []Vaccination {
+	vaccinations := readByTerm(tx, VaccinationByPersonIndex, VaccinationBkt, personId)
+	sort.SliceStable(vaccinations, func(i, j int) bool {
+		return vaccinations[i].Date.After(vaccinations[j].Date)
+	})
+	return vaccinations
+}
+
+// GetPersonHealthRecords returns the person's other health records, newest
+// first. An undated allergy sorts last.
+func GetPersonHealthRecords(tx *vbolt.Tx, personId int) []HealthRecord {
+	records := readByTerm(tx, HealthRecordByPersonIndex, HealthRecordBkt, personId)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].StartDate.After(records[j].StartDate)
+	})
+	return records
+}
+
+func getFamilyVaccinations(tx *vbolt.Tx, familyId int) []Vaccination {
+	return readByTerm(tx, VaccinationByFamilyIndex, VaccinationBkt, familyId)
+}
+
+func getFamilyHealthRecords(tx *vbolt.Tx, familyId int) []HealthRecord {
+	return readByTerm(tx, HealthRecordByFamilyIndex, HealthRecordBkt, familyId)
+}
+
+func writeVaccinationTx(tx *vbolt.Tx, vaccination *Vaccination) {
+	if vaccination.Id == 0 {
+		vaccination.Id = vbolt.NextIntId(tx, VaccinationBkt)
+		vaccination.CreatedAt = time.Now()
+	}
+	vbolt.Write(tx, VaccinationBkt, vaccination.Id, vaccination)
+	vbolt.SetTargetSingleTerm(tx, VaccinationByPersonIndex, vaccination.Id, vaccination.PersonId)
+	vbolt.SetTargetSingleTerm(tx, VaccinationByFamilyIndex, vaccination.Id, vaccination.FamilyId)
+}
+
+func deleteVaccinationTx(tx *vbolt.Tx, vaccination Vaccination) {
+	vbolt.SetTargetSingleTerm(tx, VaccinationByPersonIndex, vaccination.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, VaccinationByFamilyIndex, vaccination.Id, -1)
+	vbolt.Delete(tx, VaccinationBkt, vaccination.Id)
+}
+
+func writeHealthRecordTx(tx *vbolt.Tx, record *HealthRecord) {
+	if record.Id == 0 {
+		record.Id = vbolt.NextIntId(tx, HealthRecordBkt)
+		record.CreatedAt = time.Now()
+	}
+	vbolt.Write(tx, HealthRecordBkt, record.Id, record)
+	vbolt.SetTargetSingleTerm(tx, HealthRecordByPersonIndex, record.Id, record.PersonId)
+	vbolt.SetTargetSingleTerm(tx, HealthRecordByFamilyIndex, record.Id, record.FamilyId)
+}
+
+func deleteHealthRecordTx(tx *vbolt.Tx, record HealthRecord) {
+	vbolt.SetTargetSingleTerm(tx, HealthRecordByPersonIndex, record.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, HealthRecordByFamilyIndex, record.Id, -1)
+	vbolt.Delete(tx, HealthRecordBkt, record.Id)
+}
+
+// deleteFamilyHealthTx removes every health record the family owns.
+func deleteFamilyHealthTx(tx *vbolt.Tx, familyId int) {
+	for _, vaccination := range getFamilyVaccinations(tx, familyId) {
+		deleteVaccinationTx(tx, vaccination)
+	}
+	for _, record := range getFamilyHealthRecords(tx, familyId) {
+		deleteHealthRecordTx(tx, record)
+	}
+}
+
+// moveHealthRecordsTx hands the source's health records to the target of a
+// merge, returning how many moved. Nothing is dropped: two records of the same
+// shot are a question for whoever reads them, not something to guess at.
+func moveHealthRecordsTx(tx *vbolt.Tx, sourceId int, targetId int) (moved int) {
+	for _, vaccination := range GetPersonVaccinations(tx, sourceId) {
+		vaccination.PersonId = targetId
+		writeVaccinationTx(tx, &vaccination)
+		moved++
+	}
+	for _, record := range GetPersonHealthRecords(tx, sourceId) {
+		record.PersonId = targetId
+		writeHealthRecordTx(tx, &record)
+		moved++
+	}
+	return
+}
+
+// parseHealthDate reads an optional YYYY-MM-DD date. A health record is about
+// something that has happened, so a date in the future is refused.
+func parseHealthDate(value string, label string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid " + label + " format. Use YYYY-MM-DD")
+	}
+	if date.After(time.Now()) {
+		return time.Time{}, errors.New(strings.ToUpper(label[:1]) + label[1:] + " cannot be in the future")
+	}
+	return date, nil
+}
+
+func validateHealthText(provider, notes string, more ...string) error {
+	for _, value := range append(more, provider) {
+		if len(value) > maxHealthTextLength {
+			return errors.New("Text fields must be at most 200 characters")
+		}
+	}
+	if len(notes) > maxHealthNotesLength {
+		return errors.New("Notes must be at most 2000 characters")
+	}
+	return nil
+}
+
+// applyVaccinationFields validates fields against the person the vaccination
+// is for and copies them onto it.
+func applyVaccinationFields(vaccination *Vaccination, fields VaccinationFields, person Person) error {
+	fields.Vaccine = strings.TrimSpace(fields.Vaccine)
+	if fields.Vaccine == "" {
+		return errors.New("Vaccine is required")
+	}
+	if fields.Dose < 0 || fields.Dose > 10 {
+		return errors.New("Dose must be between 1 and 10, or left out")
+	}
+	if person.IsPregnancy {
+		return errors.New("Vaccinations are recorded once the baby is born")
+	}
+	if fields.Date == "" {
+		return errors.New("Vaccination date is required")
+	}
+	date, err := parseHealthDate(fields.Date, "vaccination date")
+	if err != nil {
+		return err
+	}
+	if date.Before(person.Birthday) {
+		return errors.New("Vaccination date cannot be before the birthdate")
+	}
+	if err := validateHealthText(fields.Provider, fields.Notes, fields.Vaccine, fields.LotNumber); err != nil {
+		return err
+	}
+
+	// A vaccine the schedule lists is stored under its key, so "Hep B" and
+	// "hepatitis b" count toward the same doses.
+	if key := scheduleVaccineKey(vaccineSchedule(), fields.Vaccine); key != "" {
+		fields.Vaccine = key
+	}
+	vaccination.Vaccine = fields.Vaccine
+	vaccination.Dose = fields.Dose
+	vaccination.Date = date
+	vaccination.Provider = strings.TrimSpace(fields.Provider)
+	vaccination.LotNumber = strings.TrimSpace(fields.LotNumber)
+	vaccination.Notes = strings.TrimSpace(fields.Notes)
+	return nil
+}
+
+// applyHealthRecordFields validates fields and copies them onto the record.
+// Every kind but an allergy needs a start date; an allergy is often known
+// without anyone remembering when it was found.
+func applyHealthRecordFields(record *HealthRecord, fields HealthRecordFields) error {
+	if !healthRecordKinds[fields.Kind] {
+		return errors.New("Kind must be visit, illness, allergy or medication")
+	}
+	fields.Title = strings.TrimSpace(fields.Title)
+	if fields.Title == "" {
+		return errors.New("Title is required")
+	}
+	if fields.StartDate == "" && fields.Kind != HealthAllergy {
+		return errors.New("Start date is required")
+	}
+	start, err := parseHealthDate(fields.StartDate, "start date")
+	if err != nil {
+		return err
+	}
+	end, err := parseHealthDate(fields.EndDate, "end date")
+	if err != nil {
+		return err
+	}
+	if !end.IsZero() && (fields.Kind == HealthVisit || fields.Kind == HealthAllergy) {
+		return errors.New("Only an illness or a medication has an end date")
+	}
+	if !end.IsZero() && end.Before(start) {
+		return errors.New("End date cannot be before the start date")
+	}
+	if err := validateHealthText(fields.Provider, fields.Notes, fields.Title, fields.Detail); err != nil {
+		return err
+	}
+
+	record.Kind = fields.Kind
+	record.Title = fields.Title
+	record.StartDate = start
+	record.EndDate = end
+	record.Provider = strings.TrimSpace(fields.Provider)
+	record.Detail = strings.TrimSpace(fields.Detail)
+	record.Notes = strings.TrimSpace(fields.Notes)
+	return nil
+}
+
+// getVaccinationForUser loads a vaccination the user may act on at need.
+func getVaccinationForUser(tx *vbolt.Tx, id int, user User, need AccessLevel) (Vaccination, error) {
+	vaccination := GetVaccinationById(tx, id)
+	if vaccination.Id == 0 || !CanAccessRecordOfPerson(tx, user, vaccination.FamilyId, vaccination.PersonId, ScopeHealth, need) {
+		return vaccination, errors.New("Vaccination not found")
+	}
+	return vaccination, nil
+}
+
+// getHealthRecordForUser loads a health record the user may act on at need.
+func getHealthRecordForUser(tx *vbolt.Tx, id int, user User, need AccessLevel) (HealthRecord, error) {
+	record := GetHealthRecordById(tx, id)
+	if record.Id == 0 || !CanAccessRecordOfPerson(tx, user, record.FamilyId, record.PersonId, ScopeHealth, need) {
+		return record, errors.New("Health record not found")
+	}
+	return record, nil
+}
+
+// vbeam procedures
+
+func GetPersonHealth(ctx *vbeam.Context, req GetPersonHealthRequest) (resp GetPersonHealthResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	person := GetPersonById(ctx.Tx, req.PersonId)
+	if !CanAccessPerson(ctx.Tx, user, person, ScopeHealth, AccessView) {
+		err = errors.New("Person not found or not in your family")
+		return
+	}
+
+	resp.Vaccinations = GetPersonVaccinations(ctx.Tx, person.Id)
+	resp.Records = GetPersonHealthRecords(ctx.Tx, person.Id)
+	resp.Due = computeDueVaccines(vaccineSchedule(), person, resp.Vaccinations, time.Now())
+	return
+}
+
+func AddVaccination(ctx *vbeam.Context, req AddVaccinationRequest) (resp VaccinationResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	// The person the record hangs off names the family that owns it.
+	familyId, err := ActingFamilyForPerson(ctx.Tx, user, req.PersonId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vaccination := Vaccination{PersonId: req.PersonId, FamilyId: familyId}
+	if err = applyVaccinationFields(&vaccination, req.VaccinationFields, GetPersonById(ctx.Tx, req.PersonId)); err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	writeVaccinationTx(ctx.Tx, &vaccination)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Vaccination = vaccination
+	return
+}
+
+func UpdateVaccination(ctx *vbeam.Context, req UpdateVaccinationRequest) (resp VaccinationResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	vaccination, err := getVaccinationForUser(ctx.Tx, req.Id, user, AccessContribute)
+	if err != nil {
+		return
+	}
+	if err = applyVaccinationFields(&vaccination, req.VaccinationFields, GetPersonById(ctx.Tx, vaccination.PersonId)); err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	writeVaccinationTx(ctx.Tx, &vaccination)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Vaccination = vaccination
+	return
+}
+
+func DeleteVaccination(ctx *vbeam.Context, req DeleteHealthItemRequest) (resp DeleteHealthItemResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	vaccination, err := getVaccinationForUser(ctx.Tx, req.Id, user, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	deleteVaccinationTx(ctx.Tx, vaccination)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Success = true
+	return
+}
+
+func AddHealthRecord(ctx *vbeam.Context, req AddHealthRecordRequest) (resp HealthRecordResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ActingFamilyForPerson(ctx.Tx, user, req.PersonId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	record := HealthRecord{PersonId: req.PersonId, FamilyId: familyId}
+	if err = applyHealthRecordFields(&record, req.HealthRecordFields); err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	writeHealthRecordTx(ctx.Tx, &record)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.HealthRecord = record
+	return
+}
+
+func UpdateHealthRecord(ctx *vbeam.Context, req UpdateHealthRecordRequest) (resp HealthRecordResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	record, err := getHealthRecordForUser(ctx.Tx, req.Id, user, AccessContribute)
+	if err != nil {
+		return
+	}
+	if err = applyHealthRecordFields(&record, req.HealthRecordFields); err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	writeHealthRecordTx(ctx.Tx, &record)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.HealthRecord = record
+	return
+}
+
+func DeleteHealthRecord(ctx *vbeam.Context, req DeleteHealthItemRequest) (resp DeleteHealthItemResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	record, err := getHealthRecordForUser(ctx.Tx, req.Id, user, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	deleteHealthRecordTx(ctx.Tx, record)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Success = true
+	return
+}
@@ -0,0 +1,172 @@
+package backend
+
+// Exporting and importing health records.
+//
+// Both lists are flat and hang off people, like the measurements: a record
+// whose person was not imported has nowhere to go and is left out. Importing a
+// file twice, or a file into a family that already has the records, skips the
+// ones already there rather than doubling every shot on the card.
+
+import (
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+// ExportVaccination carries PersonName for the same reason ExportMilestone
+// does: the id means nothing to someone reading the file.
+type ExportVaccination struct {
+	Id         int       `json:"id"`
+	PersonId   int       `json:"personId"`
+	PersonName string    `json:"personName"`
+	Vaccine    string    `json:"vaccine"`
+	Dose       int       `json:"dose,omitempty"`
+	Date       time.Time `json:"date"`
+	Provider   string    `json:"provider,omitempty"`
+	LotNumber  string    `json:"lotNumber,omitempty"`
+	Notes      string    `json:"notes,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type ExportHealthRecord struct {
+	Id         int       `json:"id"`
+	PersonId   int       `json:"personId"`
+	PersonName string    `json:"personName"`
+	Kind       string    `json:"kind"`
+	Title      string    `json:"title"`
+	StartDate  time.Time `json:"startDate"`
+	EndDate    time.Time `json:"endDate"`
+	Provider   string    `json:"provider,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	Notes      string    `json:"notes,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// HealthImportCounts says what an import did with each list.
+type HealthImportCounts struct {
+	Vaccinations  int `json:"vaccinations"`
+	HealthRecords int `json:"healthRecords"`
+	Skipped       int `json:"skipped"`
+}
+
+func buildHealthExport(tx *vbolt.Tx, familyId int, personNames map[int]string) ([]ExportVaccination, []ExportHealthRecord) {
+	vaccinations := []ExportVaccination{}
+	for _, vaccination := range getFamilyVaccinations(tx, familyId) {
+		vaccinations = append(vaccinations, ExportVaccination{
+			Id:         vaccination.Id,
+			PersonId:   vaccination.PersonId,
+			PersonName: personNames[vaccination.PersonId],
+			Vaccine:    vaccination.Vaccine,
+			Dose:       vaccination.Dose,
+			Date:       vaccination.Date,
+			Provider:   vaccination.Provider,
+			LotNumber:  vaccination.LotNumber,
+			Notes:      vaccination.Notes,
+			CreatedAt:  vaccination.CreatedAt,
+		})
+	}
+
+	records := []ExportHealthRecord{}
+	for _, record := range getFamilyHealthRecords(tx, familyId) {
+		records = append(records, ExportHealthRecord{
+			Id:         record.Id,
+			PersonId:   record.PersonId,
+			PersonName: personNames[record.PersonId],
+			Kind:       record.Kind,
+			Title:      record.Title,
+			StartDate:  record.StartDate,
+			EndDate:    record.EndDate,
+			Provider:   record.Provider,
+			Detail:     record.Detail,
+			Notes:      record.Notes,
+			CreatedAt:  record.CreatedAt,
+		})
+	}
+	return vaccinations, records
+}
+
+// importHealth writes the file's health records for the people that were
+// imported. A record that fails the checks a new one would is skipped with a
+// warning rather than stored half-valid.
+func importHealth(tx *vbolt.Tx, vaccinations []ExportVaccination, records []ExportHealthRecord, familyId int, personIdMapping map[int]int) (HealthImportCounts, []string) {
+	var counts HealthImportCounts
+	var warnings []string
+
+	for _, source := range vaccinations {
+		personId, ok := personIdMapping[source.PersonId]
+		if !ok {
+			continue
+		}
+		vaccination := Vaccination{PersonId: personId, FamilyId: familyId}
+		err := applyVaccinationFields(&vaccination, VaccinationFields{
+			Vaccine:   source.Vaccine,
+			Dose:      source.Dose,
+			Date:      source.Date.Format("2006-01-02"),
+			Provider:  source.Provider,
+			LotNumber: source.LotNumber,
+			Notes:     source.Notes,
+		}, GetPersonById(tx, personId))
+		if err != nil {
+			counts.Skipped++
+			warnings = append(warnings, "Skipped a "+source.Vaccine+" vaccination: "+err.Error())
+			continue
+		}
+		if hasVaccination(tx, vaccination) {
+			counts.Skipped++
+			continue
+		}
+		writeVaccinationTx(tx, &vaccination)
+		counts.Vaccinations++
+	}
+
+	for _, source := range records {
+		personId, ok := personIdMapping[source.PersonId]
+		if !ok {
+			continue
+		}
+		fields := HealthRecordFields{
+			Kind:     source.Kind,
+			Title:    source.Title,
+			Provider: source.Provider,
+			Detail:   source.Detail,
+			Notes:    source.Notes,
+		}
+		if !source.StartDate.IsZero() {
+			fields.StartDate = source.StartDate.Format("2006-01-02")
+		}
+		if !source.EndDate.IsZero() {
+			fields.EndDate = source.EndDate.Format("2006-01-02")
+		}
+		record := HealthRecord{PersonId: personId, FamilyId: familyId}
+		if err := applyHealthRecordFields(&record, fields); err != nil {
+			counts.Skipped++
+			warnings = append(warnings, "Skipped health record '"+source.Title+"': "+err.Error())
+			continue
+		}
+		if hasHealthRecord(tx, record) {
+			counts.Skipped++
+			continue
+		}
+		writeHealthRecordTx(tx, &record)
+		counts.HealthRecords++
+	}
+	return counts, warnings
+}
+
+func hasVaccination(tx *vbolt.Tx, vaccination Vaccination) bool {
+	for _, existing := range GetPersonVaccinations(tx, vaccination.PersonId) {
+		if existing.Vaccine == vaccination.Vaccine && existing.Dose == vaccination.Dose && existing.Date.Equal(vaccination.Date) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasHealthRecord(tx *vbolt.Tx, record HealthRecord) bool {
+	for _, existing := range GetPersonHealthRecords(tx, record.PersonId) {
+		if existing.Kind == record.Kind && existing.Title == record.Title && existing.StartDate.Equal(record.StartDate) {
+			return true
+		}
+	}
+	return false
+}
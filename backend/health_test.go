@@ -0,0 +1,166 @@
+package backend
+
+import (
+	"testing"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func addAliceVaccination(t *testing.T, fx familyLinkFixture) Vaccination {
+	t.Helper()
+	var vaccination Vaccination
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := AddVaccination(ctx, AddVaccinationRequest{
+			PersonId: fx.alice.Id,
+			VaccinationFields: VaccinationFields{
+				Vaccine: "MMR", Dose: 1, Date: "2019-04-10", Provider: "Dr. Lee", LotNumber: "X123",
+			},
+		})
+		if err != nil {
+			t.Fatalf("AddVaccination() error = %v", err)
+		}
+		vaccination = resp.Vaccination
+	})
+	return vaccination
+}
+
+func TestHealthNeedsItsOwnLinkScope(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("health-test-secret-key-at-least-32-bytes")
+
+	vaccination := addAliceVaccination(t, fx)
+	if vaccination.Vaccine != "mmr" {
+		t.Errorf("stored vaccine = %q, want the schedule key", vaccination.Vaccine)
+	}
+
+	// B sees alice's milestones and photos by default, and her growth once it
+	// is turned on, but never her health without the health scope itself.
+	setLinkScopes(t, fx, fx.linkAB, LinkScopes{People: true, Milestones: true, Photos: true, Growth: true})
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := GetPersonHealth(ctx, GetPersonHealthRequest{PersonId: fx.alice.Id}); err == nil {
+			t.Error("a link without the health scope read alice's health")
+		}
+	})
+
+	setLinkScopes(t, fx, fx.linkAB, LinkScopes{Health: true})
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		resp, err := GetPersonHealth(ctx, GetPersonHealthRequest{PersonId: fx.alice.Id})
+		if err != nil {
+			t.Fatalf("GetPersonHealth() error = %v", err)
+		}
+		if len(resp.Vaccinations) != 1 {
+			t.Errorf("vaccinations = %+v", resp.Vaccinations)
+		}
+		// Links are read-only.
+		if _, err := DeleteVaccination(ctx, DeleteHealthItemRequest{Id: vaccination.Id}); err == nil {
+			t.Error("a linked family deleted a vaccination")
+		}
+		if _, err := AddHealthRecord(ctx, AddHealthRecordRequest{
+			PersonId:           fx.alice.Id,
+			HealthRecordFields: HealthRecordFields{Kind: HealthAllergy, Title: "Peanuts"},
+		}); err == nil {
+			t.Error("a linked family added a health record")
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userC, func(ctx *vbeam.Context) {
+		if _, err := GetPersonHealth(ctx, GetPersonHealthRequest{PersonId: fx.alice.Id}); err == nil {
+			t.Error("a family with no link to A read alice's health")
+		}
+	})
+}
+
+func TestHealthRecordFields(t *testing.T) {
+	cases := []struct {
+		name    string
+		fields  HealthRecordFields
+		wantErr bool
+	}{
+		{"undated allergy", HealthRecordFields{Kind: HealthAllergy, Title: "Penicillin", Detail: "hives"}, false},
+		{"medication course", HealthRecordFields{Kind: HealthMedication, Title: "Amoxicillin", StartDate: "2024-02-01", EndDate: "2024-02-10", Detail: "250mg twice a day"}, false},
+		{"unknown kind", HealthRecordFields{Kind: "surgery", Title: "Tonsils", StartDate: "2024-02-01"}, true},
+		{"no title", HealthRecordFields{Kind: HealthIllness, Title: "  ", StartDate: "2024-02-01"}, true},
+		{"undated visit", HealthRecordFields{Kind: HealthVisit, Title: "Checkup"}, true},
+		{"visit with an end", HealthRecordFields{Kind: HealthVisit, Title: "Checkup", StartDate: "2024-02-01", EndDate: "2024-02-02"}, true},
+		{"ends before it starts", HealthRecordFields{Kind: HealthIllness, Title: "Flu", StartDate: "2024-02-10", EndDate: "2024-02-01"}, true},
+		{"in the future", HealthRecordFields{Kind: HealthIllness, Title: "Flu", StartDate: "2999-01-01"}, true},
+	}
+	for _, tc := range cases {
+		var record HealthRecord
+		if err := applyHealthRecordFields(&record, tc.fields); (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestVaccinationFields(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+
+	cases := []struct {
+		name    string
+		fields  VaccinationFields
+		wantErr bool
+	}{
+		{"off the schedule", VaccinationFields{Vaccine: "Yellow fever", Date: "2023-05-01"}, false},
+		{"no vaccine", VaccinationFields{Vaccine: " ", Date: "2023-05-01"}, true},
+		{"no date", VaccinationFields{Vaccine: "MMR"}, true},
+		{"before birth", VaccinationFields{Vaccine: "MMR", Date: "2017-01-01"}, true},
+		{"dose out of range", VaccinationFields{Vaccine: "MMR", Dose: 11, Date: "2023-05-01"}, true},
+	}
+	for _, tc := range cases {
+		var vaccination Vaccination
+		if err := applyVaccinationFields(&vaccination, tc.fields, fx.alice); (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestHealthExportRoundTrip(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("health-test-secret-key-at-least-32-bytes")
+
+	addAliceVaccination(t, fx)
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := AddHealthRecord(ctx, AddHealthRecordRequest{
+			PersonId:           fx.alice.Id,
+			HealthRecordFields: HealthRecordFields{Kind: HealthIllness, Title: "Chickenpox", StartDate: "2020-03-01", EndDate: "2020-03-12"},
+		}); err != nil {
+			t.Fatalf("AddHealthRecord() error = %v", err)
+		}
+	})
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		exportData, err := buildExportData(tx, fx.famA)
+		if err != nil {
+			t.Fatalf("buildExportData() error = %v", err)
+		}
+		if exportData.TotalVaccinations != 1 || exportData.TotalHealthRecords != 1 || exportData.Vaccinations[0].PersonName != "Alice" {
+			t.Fatalf("exported %+v and %+v", exportData.Vaccinations, exportData.HealthRecords)
+		}
+
+		// Into B, as a new person: everything comes across once, and a second
+		// import of the same file adds nothing.
+		copyOfAlice, err := AddPersonTx(tx, AddPersonRequest{Name: "Alice", PersonType: int(Child), Gender: 1, Birthdate: "2018-04-01"}, fx.famB)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		mapping := map[int]int{fx.alice.Id: copyOfAlice.Id}
+		counts, warnings := importHealth(tx, exportData.Vaccinations, exportData.HealthRecords, fx.famB, mapping)
+		if counts.Vaccinations != 1 || counts.HealthRecords != 1 || len(warnings) != 0 {
+			t.Errorf("first import = %+v, warnings %v", counts, warnings)
+		}
+		counts, _ = importHealth(tx, exportData.Vaccinations, exportData.HealthRecords, fx.famB, mapping)
+		if counts.Vaccinations != 0 || counts.HealthRecords != 0 || counts.Skipped != 2 {
+			t.Errorf("second import = %+v", counts)
+		}
+
+		records := GetPersonHealthRecords(tx, copyOfAlice.Id)
+		if len(records) != 1 || records[0].FamilyId != fx.famB || records[0].EndDate.IsZero() {
+			t.Errorf("imported records = %+v", records)
+		}
+	})
+}
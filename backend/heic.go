@@ -0,0 +1,321 @@
+// HEIC/HEIF support for photo uploads.
+//
+// Most phones save HEIC now, and asking people to convert every picture before
+// uploading it was the single most common complaint about the photo flow. The
+// decoder is libheif compiled to WASM, the same approach as the AVIF encoder
+// we already ship, so it needs no cgo and no system library on the server.
+//
+// Only decoding lives here. The original is stored byte-for-byte like any
+// other upload; the variants the browser actually sees are the usual JPEG,
+// WebP and AVIF ones, since most browsers cannot display HEIC at all.
+//
+// Orientation needs no code of ours. A HEIF file says how it is rotated in its
+// irot/imir properties, libheif applies them while decoding, and the EXIF
+// Orientation tag the camera also writes describes the same rotation — the
+// spec says readers follow the properties. imaging's AutoOrientation only
+// reads EXIF out of JPEGs, so a HEIC is never turned twice.
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+
+	"github.com/gen2brain/heic"
+)
+
+// heifBrands are the ftyp major brands a HEIC/HEIF image is written with. The
+// decoder registers itself for "heic" only, which leaves out multi-layer
+// files, image sequences and the generic "mif1" brand some Android phones use.
+var heifBrands = []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1"}
+
+// heifHeaderLimit is how much of a file heic.DecodeConfig looks at. It takes
+// the header in a single Read, so it is handed a reader that can give it all
+// at once.
+const heifHeaderLimit = 32 << 10
+
+func init() {
+	for _, brand := range heifBrands {
+		if brand == "heic" {
+			continue
+		}
+		image.RegisterFormat("heic", "????ftyp"+brand, heic.Decode, heic.DecodeConfig)
+	}
+}
+
+// heifBrand returns the file's major brand if it is one of heifBrands.
+func heifBrand(data []byte) (string, bool) {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return "", false
+	}
+	brand := string(data[8:12])
+	for _, known := range heifBrands {
+		if brand == known {
+			return brand, true
+		}
+	}
+	return "", false
+}
+
+func isHEIF(data []byte) bool {
+	_, ok := heifBrand(data)
+	return ok
+}
+
+// sniffHEIFMimeType recognises a HEIC upload the browser did not label.
+// Browsers that cannot display HEIC — Chrome and Firefox on most desktops —
+// send it as application/octet-stream or with no type at all, so the file's
+// own header is the only reliable answer. It returns "" for anything else.
+func sniffHEIFMimeType(file io.ReadSeeker) string {
+	file.Seek(0, io.SeekStart)
+	defer file.Seek(0, io.SeekStart)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return ""
+	}
+	brand, ok := heifBrand(header)
+	if !ok {
+		return ""
+	}
+	if brand == "mif1" || brand == "msf1" {
+		return "image/heif"
+	}
+	return "image/heic"
+}
+
+// heifDimensions reads a HEIC/HEIF file's displayed size — after rotation —
+// without decoding the picture.
+func heifDimensions(file io.ReadSeeker) (int, int, error) {
+	file.Seek(0, io.SeekStart)
+	defer file.Seek(0, io.SeekStart)
+	header, err := io.ReadAll(io.LimitReader(file, heifHeaderLimit))
+	if err != nil {
+		return 0, 0, err
+	}
+	config, err := heic.DecodeConfig(bytes.NewReader(header))
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// isoBox is one ISO base media file format box: its type and its payload.
+// HEIF is built on that format, and so are MP4 and QuickTime, which is why
+// video.go reads its clips with the same helpers.
+type isoBox struct {
+	Type    string
+	Payload []byte
+}
+
+var errISOBoxTruncated = errors.New("Box runs past the end of the file")
+
+// readISOBoxes splits data into its boxes. It does not descend into them.
+func readISOBoxes(data []byte) ([]isoBox, error) {
+	var boxes []isoBox
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errISOBoxTruncated
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			// The last box in the file, running to its end.
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errISOBoxTruncated
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, errISOBoxTruncated
+		}
+		boxes = append(boxes, isoBox{Type: boxType, Payload: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// isoReader reads the big-endian fields of a box payload. A read past the end
+// sets failed and returns zero, so a parser can check once at the end rather
+// than after every field.
+type isoReader struct {
+	data   []byte
+	failed bool
+}
+
+func (r *isoReader) uint(size int) uint64 {
+	if size == 0 {
+		return 0
+	}
+	if r.failed || len(r.data) < size {
+		r.failed = true
+		return 0
+	}
+	var value uint64
+	for _, b := range r.data[:size] {
+		value = value<<8 | uint64(b)
+	}
+	r.data = r.data[size:]
+	return value
+}
+
+func (r *isoReader) skip(size int) {
+	if r.failed || len(r.data) < size {
+		r.failed = true
+		return
+	}
+	r.data = r.data[size:]
+}
+
+func (r *isoReader) fourCC() string {
+	if r.failed || len(r.data) < 4 {
+		r.failed = true
+		return ""
+	}
+	value := string(r.data[:4])
+	r.data = r.data[4:]
+	return value
+}
+
+// heifExifItemId finds the Exif item in an iinf box.
+func heifExifItemId(iinf []byte) (uint64, bool) {
+	r := isoReader{data: iinf}
+	version := r.uint(1)
+	r.uint(3) // flags
+	if version == 0 {
+		r.uint(2)
+	} else {
+		r.uint(4)
+	}
+	if r.failed {
+		return 0, false
+	}
+	entries, err := readISOBoxes(r.data)
+	if err != nil {
+		return 0, false
+	}
+	for _, entry := range entries {
+		if entry.Type != "infe" {
+			continue
+		}
+		infe := isoReader{data: entry.Payload}
+		infeVersion := infe.uint(1)
+		infe.uint(3)
+		if infeVersion < 2 {
+			// Versions 0 and 1 predate item types and never carry EXIF.
+			continue
+		}
+		var itemId uint64
+		if infeVersion == 2 {
+			itemId = infe.uint(2)
+		} else {
+			itemId = infe.uint(4)
+		}
+		infe.uint(2) // item_protection_index
+		if infe.fourCC() == "Exif" && !infe.failed {
+			return itemId, true
+		}
+	}
+	return 0, false
+}
+
+// heifItemLocation finds where an item's bytes are in the file from an iloc
+// box. Only items stored in the file itself, in a single extent, are found —
+// which is how every camera writes EXIF.
+func heifItemLocation(iloc []byte, wantId uint64) (offset uint64, length uint64, ok bool) {
+	r := isoReader{data: iloc}
+	version := r.uint(1)
+	r.uint(3)
+	sizes := r.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xf)
+	sizes = r.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xf)
+	}
+	var itemCount uint64
+	if version < 2 {
+		itemCount = r.uint(2)
+	} else {
+		itemCount = r.uint(4)
+	}
+	for i := uint64(0); i < itemCount && !r.failed; i++ {
+		var itemId uint64
+		if version < 2 {
+			itemId = r.uint(2)
+		} else {
+			itemId = r.uint(4)
+		}
+		constructionMethod := uint64(0)
+		if version == 1 || version == 2 {
+			constructionMethod = r.uint(2) & 0xf
+		}
+		r.uint(2) // data_reference_index
+		baseOffset := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+		for e := uint64(0); e < extentCount && !r.failed; e++ {
+			r.uint(indexSize)
+			extentOffset := r.uint(offsetSize)
+			extentLength := r.uint(lengthSize)
+			if itemId == wantId && e == 0 {
+				offset, length = baseOffset+extentOffset, extentLength
+			}
+		}
+		if itemId == wantId {
+			return offset, length, !r.failed && constructionMethod == 0 && extentCount == 1
+		}
+	}
+	return 0, 0, false
+}
+
+// heifExif returns a HEIF file's EXIF block, starting at its TIFF header, in
+// the form exif.Decode reads. A HEIF file keeps EXIF as an item of its own,
+// listed in the meta box, rather than in a segment near the start the way a
+// JPEG does.
+func heifExif(data []byte) ([]byte, bool) {
+	boxes, err := readISOBoxes(data)
+	if err != nil {
+		return nil, false
+	}
+	for _, box := range boxes {
+		if box.Type != "meta" || len(box.Payload) < 4 {
+			continue
+		}
+		children, err := readISOBoxes(box.Payload[4:])
+		if err != nil {
+			return nil, false
+		}
+		var iinf, iloc []byte
+		for _, child := range children {
+			switch child.Type {
+			case "iinf":
+				iinf = child.Payload
+			case "iloc":
+				iloc = child.Payload
+			}
+		}
+		itemId, found := heifExifItemId(iinf)
+		if !found {
+			return nil, false
+		}
+		offset, length, found := heifItemLocation(iloc, itemId)
+		if !found || length < 4 || offset+length > uint64(len(data)) {
+			return nil, false
+		}
+		item := data[offset : offset+length]
+		// The item opens with the distance from its first byte after this
+		// field to the TIFF header, which skips the "Exif\0\0" a JPEG carries.
+		tiffStart := 4 + uint64(binary.BigEndian.Uint32(item))
+		if tiffStart >= uint64(len(item)) {
+			return nil, false
+		}
+		return item[tiffStart:], true
+	}
+	return nil, false
+}
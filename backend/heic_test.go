@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func testISOBox(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, boxType...), body...)
+}
+
+// testTIFFWithDateTime is the smallest EXIF block goexif reads a date out of:
+// a little-endian TIFF header and one IFD holding DateTime.
+func testTIFFWithDateTime(dateTime string) []byte {
+	tiff := []byte("II*\x00")
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0132) // DateTime
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)      // ASCII
+	tiff = binary.LittleEndian.AppendUint32(tiff, uint32(len(dateTime)+1))
+	tiff = binary.LittleEndian.AppendUint32(tiff, 26)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	return append(append(tiff, dateTime...), 0)
+}
+
+// buildTestHEIF lays a file out the way a phone does: ftyp, then a meta box
+// listing an Exif item, then the item's bytes in mdat.
+func buildTestHEIF(brand string, exifBlock []byte) []byte {
+	ftyp := testISOBox("ftyp", []byte(brand), make([]byte, 4), []byte("mif1"))
+	item := append(binary.BigEndian.AppendUint32(nil, 6), "Exif\x00\x00"...)
+	item = append(item, exifBlock...)
+
+	meta := func(itemOffset uint32) []byte {
+		infe := testISOBox("infe", []byte{2, 0, 0, 0}, []byte{0, 1}, []byte{0, 0}, []byte("Exif"), []byte{0})
+		iinf := testISOBox("iinf", []byte{0, 0, 0, 0}, []byte{0, 1}, infe)
+		iloc := []byte{0, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0, 1}
+		iloc = binary.BigEndian.AppendUint32(iloc, itemOffset)
+		iloc = binary.BigEndian.AppendUint32(iloc, uint32(len(item)))
+		return testISOBox("meta", []byte{0, 0, 0, 0}, iinf, testISOBox("iloc", iloc))
+	}
+	itemOffset := uint32(len(ftyp) + len(meta(0)) + 8)
+	return bytes.Join([][]byte{ftyp, meta(itemOffset), testISOBox("mdat", item)}, nil)
+}
+
+func TestHEIFDetection(t *testing.T) {
+	cases := []struct {
+		brand string
+		want  string
+	}{
+		{"heic", "image/heic"},
+		{"heix", "image/heic"},
+		{"mif1", "image/heif"},
+		{"avif", ""},
+		{"isom", ""},
+	}
+	for _, tc := range cases {
+		data := buildTestHEIF(tc.brand, nil)
+		if got := sniffHEIFMimeType(bytes.NewReader(data)); got != tc.want {
+			t.Errorf("brand %q sniffed as %q, want %q", tc.brand, got, tc.want)
+		}
+		if isHEIF(data) != (tc.want != "") {
+			t.Errorf("isHEIF(%q) = %v", tc.brand, isHEIF(data))
+		}
+	}
+	if isHEIF(createTestImage(4, 4)) {
+		t.Error("a PNG was taken for HEIF")
+	}
+
+	file, _, err := createMultipartFile("IMG_0001.HEIC", buildTestHEIF("heic", nil))
+	if err != nil {
+		t.Fatalf("createMultipartFile() error = %v", err)
+	}
+	defer (*file).Close()
+	if got := sniffHEIFMimeType(*file); got != "image/heic" {
+		t.Errorf("sniffed an uploaded HEIC as %q", got)
+	}
+	if got := zipExtToMime(".heic"); got != "image/heic" {
+		t.Errorf("zipExtToMime(.heic) = %q", got)
+	}
+}
+
+func TestHEIFExifDate(t *testing.T) {
+	data := buildTestHEIF("heic", testTIFFWithDateTime("2024:05:17 09:30:00"))
+	block, ok := heifExif(data)
+	if !ok || !bytes.HasPrefix(block, []byte("II*\x00")) {
+		t.Fatalf("heifExif() = %q, %v", block, ok)
+	}
+	date, err := extractExifDate(data)
+	if err != nil {
+		t.Fatalf("extractExifDate() error = %v", err)
+	}
+	if want := time.Date(2024, 5, 17, 9, 30, 0, 0, time.Local); !date.Equal(want) {
+		t.Errorf("date = %v, want %v", date, want)
+	}
+
+	// Without an Exif item, or cut short, there is simply no date.
+	if _, err := extractExifDate(buildTestHEIF("heic", nil)[:40]); err == nil {
+		t.Error("read a date out of a truncated HEIF file")
+	}
+	// The first "Exif" in the file is the infe item type; the item's own
+	// copy comes later, in mdat.
+	noExif := bytes.Replace(data, []byte("Exif\x00"), []byte("mime\x00"), 1)
+	if _, ok := heifExif(noExif); ok {
+		t.Error("found EXIF in a file whose only item is not Exif")
+	}
+}
+
+func TestProcessUndecodableHEIF(t *testing.T) {
+	// A HEIC that will not decode must fail rather than be stored as its own
+	// JPEG fallback.
+	if _, _, _, err := ProcessAndSaveMultipleSizes(buildTestHEIF("heic", nil), "image/heic"); err == nil {
+		t.Error("an undecodable HEIC was processed")
+	}
+}
@@ -164,6 +164,13 @@ func ProcessAndSaveMultipleSizes(imageData []byte, mimeType string) (map[string]
 		}
 	}
 
+	// A HEIC that would not decode has nothing a browser can show: storing the
+	// original as the JPEG fallback below would serve HEIC bytes labelled as
+	// JPEG. Failing lets the worker hide the photo instead.
+	if width == 0 && height == 0 && isHEIF(imageData) {
+		return nil, 0, 0, fmt.Errorf("failed to decode HEIC image")
+	}
+
 	// Fallback: if no images were processed successfully, try to get dimensions
 	if width == 0 && height == 0 {
 		img, err := imaging.Decode(bytes.NewReader(imageData), imaging.AutoOrientation(true))
@@ -26,6 +26,10 @@ type ImportPerson struct {
 	Birthday time.Time `json:"Birthday"`
 	Age      string    `json:"Age"`
 	ImageId  int       `json:"ImageId"`
+	// DateOfDeath is zero for the living. InMemoriam says the same thing for
+	// readers of the file, and is ignored on the way back in.
+	DateOfDeath time.Time `json:"DateOfDeath"`
+	InMemoriam  bool      `json:"InMemoriam"`
 }
 
 type ImportHeight struct {
@@ -36,6 +40,20 @@ type ImportHeight struct {
 	DateString string    `json:"DateString"`
 	Age        float64   `json:"Age"`
 	PersonName string    `json:"PersonName"`
+	// Percentile and ZScore are filled in on export only; an import ignores
+	// them and recomputes from the person's birthday.
+	Percentile *float64 `json:"Percentile,omitempty"`
+	ZScore     *float64 `json:"ZScore,omitempty"`
+	// Display and DisplayDate are the value and date as the exporting user
+	// reads them. Export only; an import reads the fields above.
+	Display     string `json:"Display,omitempty"`
+	DisplayDate string `json:"DisplayDate,omitempty"`
+	// Source, Notes and PhotoIds are the measurement's provenance. A file
+	// written before they existed has none, and its rows come in as
+	// "imported". PhotoIds name photos in the same bundle.
+	Source   string `json:"Source,omitempty"`
+	Notes    string `json:"Notes,omitempty"`
+	PhotoIds []int  `json:"PhotoIds,omitempty"`
 }
 
 type ImportWeight struct {
@@ -46,6 +64,37 @@ type ImportWeight struct {
 	DateString string    `json:"DateString"`
 	Age        float64   `json:"Age"`
 	PersonName string    `json:"PersonName"`
+	// Percentile and ZScore are filled in on export only; an import ignores
+	// them and recomputes from the person's birthday.
+	Percentile *float64 `json:"Percentile,omitempty"`
+	ZScore     *float64 `json:"ZScore,omitempty"`
+	// Export only, as on ImportHeight.
+	Display     string `json:"Display,omitempty"`
+	DisplayDate string `json:"DisplayDate,omitempty"`
+	// Provenance, as on ImportHeight.
+	Source   string `json:"Source,omitempty"`
+	Notes    string `json:"Notes,omitempty"`
+	PhotoIds []int  `json:"PhotoIds,omitempty"`
+}
+
+// ImportHeadCircumference is carried in centimetres rather than converted to
+// inches like heights are: it is only ever recorded at a clinic, and clinics
+// record it in centimetres.
+type ImportHeadCircumference struct {
+	Id          int       `json:"Id"`
+	PersonId    int       `json:"PersonId"`
+	Centimeters float64   `json:"Centimeters"`
+	Date        time.Time `json:"Date"`
+	DateString  string    `json:"DateString"`
+	Age         float64   `json:"Age"`
+	PersonName  string    `json:"PersonName"`
+	// Export only, as on ImportHeight.
+	Display     string `json:"Display,omitempty"`
+	DisplayDate string `json:"DisplayDate,omitempty"`
+	// Provenance, as on ImportHeight.
+	Source   string `json:"Source,omitempty"`
+	Notes    string `json:"Notes,omitempty"`
+	PhotoIds []int  `json:"PhotoIds,omitempty"`
 }
 
 type ImportDataStructure struct {
@@ -61,6 +110,21 @@ type ImportDataStructure struct {
 	TotalWeights    int               `json:"total_weights"`
 	TotalPeople     int               `json:"total_people"`
 	TotalMilestones int               `json:"total_milestones"`
+
+	// HeadCircumferences arrived after the format was in use, so a file
+	// written before them simply has none.
+	HeadCircumferences      []ImportHeadCircumference `json:"head_circumferences,omitempty"`
+	TotalHeadCircumferences int                       `json:"total_head_circumferences,omitempty"`
+
+	// MilestoneCategories likewise; a file without them has its milestones'
+	// categories matched, or created, one name at a time.
+	MilestoneCategories []ExportMilestoneCategory `json:"milestone_categories,omitempty"`
+
+	Vaccinations  []ExportVaccination  `json:"vaccinations,omitempty"`
+	HealthRecords []ExportHealthRecord `json:"health_records,omitempty"`
+	Teeth         []ExportToothRecord  `json:"teeth,omitempty"`
+	SchoolYears   []ExportSchoolYear   `json:"school_years,omitempty"`
+	Albums        []ExportAlbum        `json:"albums,omitempty"`
 }
 
 // Request/Response types
@@ -72,6 +136,9 @@ type ImportDataRequest struct {
 	MergeStrategy    string `json:"mergeStrategy,omitempty"`    // "create_all", "merge_people", or "skip_duplicates"
 	ImportMilestones bool   `json:"importMilestones,omitempty"` // Whether to import milestones
 	ImportActivities bool   `json:"importActivities,omitempty"` // Whether to import activities
+	ImportHealth     bool   `json:"importHealth,omitempty"`     // Whether to import vaccinations and health records
+	ImportDental     bool   `json:"importDental,omitempty"`     // Whether to import dental charts
+	ImportSchool     bool   `json:"importSchool,omitempty"`     // Whether to import school years
 	DryRun           bool   `json:"dryRun,omitempty"`           // Preview changes without committing
 	FamilyId         int    `json:"familyId,omitempty"`
 }
@@ -86,11 +153,22 @@ type ImportDataResponse struct {
 	SkippedMilestones    int `json:"skippedMilestones"`
 	ImportedTags         int `json:"importedTags"`
 	SkippedTags          int `json:"skippedTags"`
-	ImportedPhotos       int `json:"importedPhotos"`
-	SkippedPhotos        int `json:"skippedPhotos"`
+	// ImportedMilestoneCategories counts the categories the family did not
+	// already have under any of their names.
+	ImportedMilestoneCategories int `json:"importedMilestoneCategories"`
+	ImportedPhotos              int `json:"importedPhotos"`
+	SkippedPhotos               int `json:"skippedPhotos"`
+	// DuplicatePhotos counts photos the family already had, which a bundle
+	// import asked to skip duplicates maps onto the existing copy.
+	DuplicatePhotos int `json:"duplicatePhotos"`
 	// ImportedActivities counts one level of the activities tree at a time,
 	// because a single number would not say whether the results came back.
 	ImportedActivities ActivityImportCounts `json:"importedActivities"`
+	ImportedHealth     HealthImportCounts   `json:"importedHealth"`
+	ImportedTeeth      int                  `json:"importedTeeth"`
+	SkippedTeeth       int                  `json:"skippedTeeth"`
+	ImportedSchool     SchoolImportCounts   `json:"importedSchool"`
+	ImportedAlbums     AlbumImportCounts    `json:"importedAlbums"`
 	Errors             []string             `json:"errors,omitempty"`
 	Warnings           []string             `json:"warnings,omitempty"`
 	PersonIdMapping    map[int]int          `json:"personIdMapping,omitempty"`
@@ -185,16 +263,23 @@ func ImportData(ctx *vbeam.Context, req ImportDataRequest) (resp ImportDataRespo
 	tagNameToId, importedTags, skippedTags := importTags(ctx.Tx, importData.Tags, familyId)
 	resp.ImportedTags = importedTags
 	resp.SkippedTags = skippedTags
+	resp.ImportedMilestoneCategories = importMilestoneCategories(ctx.Tx, importData.MilestoneCategories, familyId)
 
 	// Only proceed with data import if we have people to import to
 	if len(personIdMapping) > 0 {
-		// Import measurements using the person ID mappings (filter by imported people)
+		// Import measurements using the person ID mappings (filter by imported
+		// people). Their photo links need the bundle, so they are dropped here.
 		filteredHeights, filteredWeights := filterMeasurements(importData.Heights, importData.Weights, personIdMapping)
-		importedMeasurements, skippedMeasurements, measurementErrors := importMeasurements(ctx.Tx, filteredHeights, filteredWeights, personIdMapping, familyId)
+		importedMeasurements, skippedMeasurements, measurementErrors := importMeasurements(ctx.Tx, filteredHeights, filteredWeights, personIdMapping, familyId, nil)
 		resp.ImportedMeasurements = importedMeasurements
 		resp.SkippedMeasurements = skippedMeasurements
 		resp.Errors = append(resp.Errors, measurementErrors...)
 
+		importedHC, skippedHC, hcErrors := importHeadCircumferences(ctx.Tx, importData.HeadCircumferences, personIdMapping, familyId, nil)
+		resp.ImportedMeasurements += importedHC
+		resp.SkippedMeasurements += skippedHC
+		resp.Errors = append(resp.Errors, hcErrors...)
+
 		// Import milestones if requested
 		if req.ImportMilestones && len(importData.Milestones) > 0 {
 			filteredMilestones := filterMilestones(importData.Milestones, personIdMapping)
@@ -211,6 +296,26 @@ func ImportData(ctx *vbeam.Context, req ImportDataRequest) (resp ImportDataRespo
 			resp.ImportedActivities = counts
 			resp.Warnings = append(resp.Warnings, activityWarnings...)
 		}
+
+		if req.ImportHealth {
+			counts, healthWarnings := importHealth(ctx.Tx, importData.Vaccinations, importData.HealthRecords, familyId, personIdMapping)
+			resp.ImportedHealth = counts
+			resp.Warnings = append(resp.Warnings, healthWarnings...)
+		}
+
+		if req.ImportDental {
+			var dentalWarnings []string
+			resp.ImportedTeeth, resp.SkippedTeeth, dentalWarnings = importDental(ctx.Tx, importData.Teeth, familyId, personIdMapping)
+			resp.Warnings = append(resp.Warnings, dentalWarnings...)
+		}
+
+		// Like activities, school years come back without their photos or
+		// documents on this path: there are no files to attach.
+		if req.ImportSchool {
+			counts, schoolWarnings := importSchoolYears(ctx.Tx, importData.SchoolYears, familyId, user.Id, personIdMapping, nil, nil)
+			resp.ImportedSchool = counts
+			resp.Warnings = append(resp.Warnings, schoolWarnings...)
+		}
 	}
 
 	// Calculate skipped people
@@ -254,6 +359,12 @@ func validateImportData(data ImportDataStructure) error {
 		}
 	}
 
+	for i, hc := range data.HeadCircumferences {
+		if err := validateImportHeadCircumference(hc, i, personIds); err != nil {
+			return err
+		}
+	}
+
 	// Validate milestones
 	for i, milestone := range data.Milestones {
 		if err := validateImportMilestone(milestone, i, personIds); err != nil {
@@ -299,6 +410,10 @@ func validateImportPerson(person ImportPerson, index int) error {
 		return errors.New("Person '" + person.Name + "' has invalid person type")
 	}
 
+	if !person.DateOfDeath.IsZero() && (person.DateOfDeath.Before(person.Birthday) || person.DateOfDeath.After(now)) {
+		return errors.New("Person '" + person.Name + "' has invalid date of death")
+	}
+
 	return nil
 }
 
@@ -334,10 +449,31 @@ func validateImportWeight(weight ImportWeight, index int, validPersonIds map[int
 	return nil
 }
 
+func validateImportHeadCircumference(hc ImportHeadCircumference, index int, validPersonIds map[int]bool) error {
+	if !validPersonIds[hc.PersonId] {
+		return errors.New("Head circumference at index " + formatIndex(index) + " references unknown person ID " + formatIndex(hc.PersonId))
+	}
+
+	if hc.Centimeters <= 0 || hc.Centimeters > 100 { // well past any adult head
+		return errors.New("Head circumference at index " + formatIndex(index) + " has invalid value")
+	}
+
+	if hc.Date.Year() < 1900 || hc.Date.After(time.Now()) {
+		return errors.New("Head circumference at index " + formatIndex(index) + " has invalid date")
+	}
+
+	return nil
+}
+
 func validateImportMilestone(milestone ExportMilestone, index int, validPersonIds map[int]bool) error {
 	if !validPersonIds[milestone.PersonId] {
 		return errors.New("Milestone at index " + formatIndex(index) + " references unknown person ID " + formatIndex(milestone.PersonId))
 	}
+	for _, personId := range milestone.PersonIds {
+		if !validPersonIds[personId] {
+			return errors.New("Milestone at index " + formatIndex(index) + " references unknown person ID " + formatIndex(personId))
+		}
+	}
 
 	if strings.TrimSpace(milestone.Description) == "" {
 		return errors.New("Milestone at index " + formatIndex(index) + " has empty description")
@@ -347,6 +483,10 @@ func validateImportMilestone(milestone ExportMilestone, index int, validPersonId
 		return errors.New("Milestone at index " + formatIndex(index) + " has invalid date")
 	}
 
+	if validateDatePrecision(milestone.DatePrecision) != nil {
+		return errors.New("Milestone at index " + formatIndex(index) + " has an unknown date precision")
+	}
+
 	return nil
 }
 
@@ -391,7 +531,8 @@ func importPeople(tx *vbolt.Tx, importPeople []ImportPerson, familyId int, merge
 		person.Type = PersonType(importPerson.Type)
 		person.Gender = GenderType(importPerson.Gender)
 		person.Birthday = importPerson.Birthday
-		person.Age = calculateAge(importPerson.Birthday)
+		person.DateOfDeath = importPerson.DateOfDeath
+		person.Age = personAge(person)
 
 		// Store in database
 		vbolt.Write(tx, PeopleBkt, person.Id, &person)
@@ -405,7 +546,10 @@ func importPeople(tx *vbolt.Tx, importPeople []ImportPerson, familyId int, merge
 	return personIdMapping, importedCount, mergedCount, errors, warnings
 }
 
-func importMeasurements(tx *vbolt.Tx, importHeights []ImportHeight, importWeights []ImportWeight, personIdMapping map[int]int, familyId int) (int, int, []string) {
+// importMeasurements writes heights and weights for the people that were
+// imported. photoIdMapping resolves the measurements' photo links; it is nil
+// when the import carries no photos, and the links are dropped.
+func importMeasurements(tx *vbolt.Tx, importHeights []ImportHeight, importWeights []ImportWeight, personIdMapping map[int]int, familyId int, photoIdMapping map[int]int) (int, int, []string) {
 	var errors []string
 	importedCount := 0
 	skippedCount := 0
@@ -438,10 +582,13 @@ func importMeasurements(tx *vbolt.Tx, importHeights []ImportHeight, importWeight
 		growthData.Value = height.Inches
 		growthData.Unit = "in"
 		growthData.MeasurementDate = height.Date
+		growthData.Source = importedGrowthSource(height.Source)
+		growthData.Notes = trimField(height.Notes, maxNotesLength)
 		growthData.CreatedAt = time.Now()
 
 		vbolt.Write(tx, GrowthDataBkt, growthData.Id, &growthData)
 		updateGrowthDataIndices(tx, growthData)
+		linkImportedGrowthPhotos(tx, growthData, height.PhotoIds, photoIdMapping)
 		importedCount++
 	}
 
@@ -473,16 +620,85 @@ func importMeasurements(tx *vbolt.Tx, importHeights []ImportHeight, importWeight
 		growthData.Value = weight.Pounds
 		growthData.Unit = "lbs"
 		growthData.MeasurementDate = weight.Date
+		growthData.Source = importedGrowthSource(weight.Source)
+		growthData.Notes = trimField(weight.Notes, maxNotesLength)
+		growthData.CreatedAt = time.Now()
+
+		vbolt.Write(tx, GrowthDataBkt, growthData.Id, &growthData)
+		updateGrowthDataIndices(tx, growthData)
+		linkImportedGrowthPhotos(tx, growthData, weight.PhotoIds, photoIdMapping)
+		importedCount++
+	}
+
+	return importedCount, skippedCount, errors
+}
+
+// importHeadCircumferences writes head circumferences for the people that were
+// imported. Rows for anyone outside personIdMapping were filtered out of the
+// import and are passed over silently, which is what filterMeasurements does
+// for heights and weights.
+func importHeadCircumferences(tx *vbolt.Tx, rows []ImportHeadCircumference, personIdMapping map[int]int, familyId int, photoIdMapping map[int]int) (int, int, []string) {
+	var errors []string
+	importedCount := 0
+	skippedCount := 0
+
+	for _, hc := range rows {
+		newPersonId, exists := personIdMapping[hc.PersonId]
+		if !exists {
+			continue
+		}
+
+		// Skip measurements with invalid dates (year 0001)
+		if hc.Date.Year() == 1 {
+			skippedCount++
+			continue
+		}
+
+		if isDuplicateMeasurement(tx, newPersonId, hc.Date, HeadCircumference, hc.Centimeters) {
+			skippedCount++
+			continue
+		}
+
+		var growthData GrowthData
+		growthData.Id = vbolt.NextIntId(tx, GrowthDataBkt)
+		growthData.PersonId = newPersonId
+		growthData.FamilyId = familyId
+		growthData.MeasurementType = HeadCircumference
+		growthData.Value = hc.Centimeters
+		growthData.Unit = "cm"
+		growthData.MeasurementDate = hc.Date
+		growthData.Source = importedGrowthSource(hc.Source)
+		growthData.Notes = trimField(hc.Notes, maxNotesLength)
 		growthData.CreatedAt = time.Now()
 
 		vbolt.Write(tx, GrowthDataBkt, growthData.Id, &growthData)
 		updateGrowthDataIndices(tx, growthData)
+		linkImportedGrowthPhotos(tx, growthData, hc.PhotoIds, photoIdMapping)
 		importedCount++
 	}
 
 	return importedCount, skippedCount, errors
 }
 
+// importedGrowthSource keeps the source a file recorded, so a bundle
+// round-trips, and marks a row from a file that recorded none as imported.
+func importedGrowthSource(source string) string {
+	if source == GrowthSourceUnknown || validateGrowthSource(source) != nil {
+		return GrowthSourceImported
+	}
+	return source
+}
+
+// linkImportedGrowthPhotos restores a measurement's photo links from the ids
+// in the file. A photo that did not come in with the import is skipped.
+func linkImportedGrowthPhotos(tx *vbolt.Tx, growthData GrowthData, oldPhotoIds []int, photoIdMapping map[int]int) {
+	for _, oldPhotoId := range normalizePhotoIds(oldPhotoIds) {
+		if newPhotoId, ok := photoIdMapping[oldPhotoId]; ok {
+			addGrowthPhotoTx(tx, growthData, newPhotoId)
+		}
+	}
+}
+
 func getUniqueFamilyIds(people []ImportPerson) []int {
 	familyIdMap := make(map[int]bool)
 	for _, person := range people {
@@ -718,8 +934,13 @@ func importMilestones(tx *vbolt.Tx, importMilestones []ExportMilestone, personId
 			continue
 		}
 
+		// A file written by hand, or by the AI import, may give any day
+		// within a coarse period; the stored date is always its start.
+		precision := normalizeDatePrecision(milestone.DatePrecision)
+		milestoneDate := anchorDate(milestone.MilestoneDate, precision)
+
 		// Check for duplicate milestone
-		if isDuplicateMilestone(tx, newPersonId, milestone.MilestoneDate, milestone.Description) {
+		if isDuplicateMilestone(tx, newPersonId, milestoneDate, milestone.Description) {
 			skippedCount++
 			continue
 		}
@@ -729,15 +950,25 @@ func importMilestones(tx *vbolt.Tx, importMilestones []ExportMilestone, personId
 		newMilestone.PersonId = newPersonId
 		newMilestone.FamilyId = familyId
 		newMilestone.Description = milestone.Description
-		newMilestone.Category = milestone.Category
-		newMilestone.MilestoneDate = milestone.MilestoneDate
+		newMilestone.Category = importMilestoneCategoryTx(tx, familyId, milestone.Category)
+		newMilestone.MilestoneDate = milestoneDate
+		newMilestone.DatePrecision = precision
 		newMilestone.CreatedAt = time.Now()
 
 		vbolt.Write(tx, MilestoneBkt, newMilestone.Id, &newMilestone)
 
+		// Everyone else the milestone is about comes along if they were
+		// imported too; one left behind is dropped rather than the milestone.
+		personIds := []int{newPersonId}
+		for _, personId := range milestone.PersonIds {
+			if mapped, ok := personIdMapping[personId]; ok {
+				personIds = append(personIds, mapped)
+			}
+		}
+		setMilestonePeopleTx(tx, &newMilestone, normalizeMilestonePersonIds(newPersonId, personIds))
+
 		// Update indices
-		vbolt.SetTargetSingleTerm(tx, MilestoneByPersonIndex, newMilestone.Id, newMilestone.PersonId)
-		vbolt.SetTargetSingleTerm(tx, MilestoneByFamilyIndex, newMilestone.Id, newMilestone.FamilyId)
+		updateMilestoneIndices(tx, newMilestone)
 
 		// Apply tags
 		for _, tagName := range milestone.TagNames {
@@ -792,3 +1023,43 @@ func importTags(tx *vbolt.Tx, exportedTags []ExportTag, familyId int) (map[strin
 
 	return tagNameToId, importedCount, skippedCount
 }
+
+// importMilestoneCategories brings in a file's categories ahead of its
+// milestones. One the family already has under its key or name is reused, and
+// whatever else the file called it is added to the family's as an alias, so
+// the milestones that follow find it. Returns how many were created.
+func importMilestoneCategories(tx *vbolt.Tx, exported []ExportMilestoneCategory, familyId int) int {
+	importedCount := 0
+	for _, exportCategory := range exported {
+		categories := ensureMilestoneCategoriesTx(tx, familyId)
+		names := append([]string{exportCategory.Key, exportCategory.Name}, exportCategory.Aliases...)
+
+		category, found := findMilestoneCategory(categories, exportCategory.Key)
+		if !found {
+			category, found = findMilestoneCategory(categories, exportCategory.Name)
+		}
+		if !found {
+			if validateMilestoneCategoryFields(exportCategory.Name, exportCategory.Icon) != nil || foldCategoryName(exportCategory.Key) == "" {
+				continue
+			}
+			category = MilestoneCategory{
+				FamilyId:  familyId,
+				Key:       exportCategory.Key,
+				Name:      strings.TrimSpace(exportCategory.Name),
+				Color:     exportCategory.Color,
+				Icon:      exportCategory.Icon,
+				SortOrder: nextMilestoneCategorySortOrder(categories),
+			}
+			importedCount++
+		}
+
+		for _, name := range names {
+			fold := foldCategoryName(name)
+			if fold != "" && !milestoneCategoryNameTaken(categories, name, 0) && !category.answersTo(fold) {
+				category.Aliases = append(category.Aliases, fold)
+			}
+		}
+		writeMilestoneCategoryTx(tx, &category)
+	}
+	return importedCount
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -39,6 +40,10 @@ func importBundleHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		requestedFamilyId = parsed
 	}
+	// skipDuplicates maps a photo the family already has, byte for byte, onto
+	// the one it has instead of importing a second copy. Restoring a bundle
+	// into the family it came from is the usual reason to want it.
+	skipDuplicates, _ := strconv.ParseBool(r.FormValue("skipDuplicates"))
 
 	file, _, err := r.FormFile("file")
 	if err != nil {
@@ -112,6 +117,7 @@ func importBundleHandler(w http.ResponseWriter, r *http.Request) {
 		tagNameToId, importedTags, skippedTags := importTags(tx, importData.Tags, familyId)
 		resp.ImportedTags = importedTags
 		resp.SkippedTags = skippedTags
+		resp.ImportedMilestoneCategories = importMilestoneCategories(tx, importData.MilestoneCategories, familyId)
 
 		// Build old tag ID → new tag ID mapping
 		tagIdMapping := make(map[int]int)
@@ -122,27 +128,14 @@ func importBundleHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		if len(personIdMapping) > 0 {
-			filteredHeights, filteredWeights := filterMeasurements(importData.Heights, importData.Weights, personIdMapping)
-			importedMeasurements, skippedMeasurements, measurementErrors := importMeasurements(tx, filteredHeights, filteredWeights, personIdMapping, familyId)
-			resp.ImportedMeasurements = importedMeasurements
-			resp.SkippedMeasurements = skippedMeasurements
-			resp.Errors = append(resp.Errors, measurementErrors...)
-
-			if len(importData.Milestones) > 0 {
-				filteredMilestones := filterMilestones(importData.Milestones, personIdMapping)
-				importedMilestones, skippedMilestones, milestoneErrors := importMilestones(tx, filteredMilestones, personIdMapping, familyId, tagNameToId)
-				resp.ImportedMilestones = importedMilestones
-				resp.SkippedMilestones = skippedMilestones
-				resp.Errors = append(resp.Errors, milestoneErrors...)
-			}
-		}
-
+		// Photos go in before the measurements and activities, so the photo
+		// joins on both have a mapping to resolve against.
 		var photoIdMapping map[int]int
 		if len(importData.Photos) > 0 {
-			imported, skipped, mapping := importPhotos(tx, familyId, user.Id, importData.Photos, personIdMapping, tagIdMapping, zipReader)
+			imported, skipped, duplicates, mapping := importPhotos(tx, familyId, user.Id, importData.Photos, personIdMapping, tagIdMapping, zipReader, skipDuplicates)
 			resp.ImportedPhotos = imported
 			resp.SkippedPhotos = skipped
+			resp.DuplicatePhotos = duplicates
 			photoIdMapping = mapping
 
 			// Restore profile photos
@@ -168,15 +161,55 @@ func importBundleHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// Activities go in after photos, so the photo joins have a mapping to
-		// resolve against. A bundle exported with photos and restored without
-		// them still restores the season; only the joins are missing.
+		if len(personIdMapping) > 0 {
+			filteredHeights, filteredWeights := filterMeasurements(importData.Heights, importData.Weights, personIdMapping)
+			importedMeasurements, skippedMeasurements, measurementErrors := importMeasurements(tx, filteredHeights, filteredWeights, personIdMapping, familyId, photoIdMapping)
+			resp.ImportedMeasurements = importedMeasurements
+			resp.SkippedMeasurements = skippedMeasurements
+			resp.Errors = append(resp.Errors, measurementErrors...)
+
+			importedHC, skippedHC, hcErrors := importHeadCircumferences(tx, importData.HeadCircumferences, personIdMapping, familyId, photoIdMapping)
+			resp.ImportedMeasurements += importedHC
+			resp.SkippedMeasurements += skippedHC
+			resp.Errors = append(resp.Errors, hcErrors...)
+
+			if len(importData.Milestones) > 0 {
+				filteredMilestones := filterMilestones(importData.Milestones, personIdMapping)
+				importedMilestones, skippedMilestones, milestoneErrors := importMilestones(tx, filteredMilestones, personIdMapping, familyId, tagNameToId)
+				resp.ImportedMilestones = importedMilestones
+				resp.SkippedMilestones = skippedMilestones
+				resp.Errors = append(resp.Errors, milestoneErrors...)
+			}
+
+			counts, healthWarnings := importHealth(tx, importData.Vaccinations, importData.HealthRecords, familyId, personIdMapping)
+			resp.ImportedHealth = counts
+			resp.Warnings = append(resp.Warnings, healthWarnings...)
+
+			var dentalWarnings []string
+			resp.ImportedTeeth, resp.SkippedTeeth, dentalWarnings = importDental(tx, importData.Teeth, familyId, personIdMapping)
+			resp.Warnings = append(resp.Warnings, dentalWarnings...)
+		}
+
+		// A bundle exported with photos and restored without them still
+		// restores the season; only the photo joins are missing.
 		if len(importData.Activities) > 0 {
 			counts, activityWarnings := importActivities(tx, importData.Activities, familyId, personIdMapping, photoIdMapping)
 			resp.ImportedActivities = counts
 			resp.Warnings = append(resp.Warnings, activityWarnings...)
 		}
 
+		if len(importData.SchoolYears) > 0 {
+			counts, schoolWarnings := importSchoolYears(tx, importData.SchoolYears, familyId, user.Id, personIdMapping, photoIdMapping, zipReader)
+			resp.ImportedSchool = counts
+			resp.Warnings = append(resp.Warnings, schoolWarnings...)
+		}
+
+		if len(importData.Albums) > 0 {
+			counts, albumWarnings := importAlbums(tx, importData.Albums, familyId, user.Id, personIdMapping, photoIdMapping)
+			resp.ImportedAlbums = counts
+			resp.Warnings = append(resp.Warnings, albumWarnings...)
+		}
+
 		resp.SkippedPeople = len(importData.People) - resp.ImportedPeople - resp.MergedPeople
 
 		vbolt.TxCommit(tx)
@@ -199,7 +232,8 @@ func importPhotos(
 	personIdMapping map[int]int,
 	tagIdMapping map[int]int,
 	zipReader *zip.Reader,
-) (imported, skipped int, photoIdMapping map[int]int) {
+	skipDuplicates bool,
+) (imported, skipped, duplicates int, photoIdMapping map[int]int) {
 	photoIdMapping = make(map[int]int)
 	// Build a lookup map for ZIP entries
 	zipFiles := make(map[string]*zip.File, len(zipReader.File))
@@ -214,6 +248,31 @@ func importPhotos(
 			continue
 		}
 
+		// Remap tag IDs
+		var newTagIds []int
+		for _, oldTagId := range photo.TagIds {
+			if newId, ok := tagIdMapping[oldTagId]; ok {
+				newTagIds = append(newTagIds, newId)
+			}
+		}
+
+		hash, err := hashZipEntry(zf)
+		if err != nil {
+			log.Printf("[IMPORT] Failed to read photo %s: %v", photo.ZipPath, err)
+			skipped++
+			continue
+		}
+		if skipDuplicates {
+			// The copy already here stands in for this one everywhere the
+			// bundle points at it, and picks up its tags and people.
+			if existingId := findPhotoByContentHash(tx, familyId, hash); existingId != 0 {
+				photoIdMapping[photo.Id] = existingId
+				mergeImportedPhotoLinks(tx, existingId, familyId, newTagIds, photo.PersonIds, personIdMapping)
+				duplicates++
+				continue
+			}
+		}
+
 		// Derive FilePath by stripping _original suffix
 		fileName := filepath.Base(photo.ZipPath)
 		ext := filepath.Ext(fileName)
@@ -231,14 +290,6 @@ func importPhotos(
 			continue
 		}
 
-		// Remap tag IDs
-		var newTagIds []int
-		for _, oldTagId := range photo.TagIds {
-			if newId, ok := tagIdMapping[oldTagId]; ok {
-				newTagIds = append(newTagIds, newId)
-			}
-		}
-
 		// Create Image record
 		var image Image
 		image.Id = vbolt.NextIntId(tx, ImagesBkt)
@@ -246,14 +297,26 @@ func importPhotos(
 		image.OwnerUserId = ownerUserId
 		image.FilePath = filePath
 		image.MimeType = mimeType
+		image.MediaKind = MediaKindPhoto
+		if photo.MediaKind == MediaKindVideo && isValidVideoType(mimeType) {
+			image.MediaKind = MediaKindVideo
+			image.DurationMs = photo.DurationMs
+		}
 		image.Title = photo.Title
 		image.Description = photo.Description
-		image.PhotoDate = photo.PhotoDate
+		image.PhotoDatePrecision = DatePrecisionDay
+		if validateDatePrecision(photo.PhotoDatePrecision) == nil {
+			image.PhotoDatePrecision = normalizeDatePrecision(photo.PhotoDatePrecision)
+		}
+		image.PhotoDate = anchorDate(photo.PhotoDate, image.PhotoDatePrecision)
 		image.Status = 0
 		image.CreatedAt = time.Now()
+		image.ContentHash = hash
+		applyExportedPhotoMetadata(&image, photo)
 
 		vbolt.Write(tx, ImagesBkt, image.Id, &image)
 		vbolt.SetTargetSingleTerm(tx, ImageByFamilyIndex, image.Id, familyId)
+		setImageContentHashTx(tx, image)
 		photoIdMapping[photo.Id] = image.Id
 
 		// Apply tags
@@ -273,6 +336,51 @@ func importPhotos(
 	return
 }
 
+// mergeImportedPhotoLinks adds the tags and people a skipped duplicate carried
+// in the bundle to the photo standing in for it, leaving those it has alone.
+func mergeImportedPhotoLinks(tx *vbolt.Tx, photoId int, familyId int, tagIds []int, oldPersonIds []int, personIdMapping map[int]int) {
+	existingTags := GetPhotoTagIds(tx, photoId)
+	for _, tagId := range tagIds {
+		if !slices.Contains(existingTags, tagId) {
+			addTagToPhoto(tx, photoId, tagId, familyId)
+			existingTags = append(existingTags, tagId)
+		}
+	}
+	tagged := make(map[int]bool)
+	for _, photoPerson := range GetPhotoPersonsByPhoto(tx, photoId) {
+		tagged[photoPerson.PersonId] = true
+	}
+	for _, oldPersonId := range oldPersonIds {
+		if newPersonId, ok := personIdMapping[oldPersonId]; ok && !tagged[newPersonId] {
+			AddPersonToPhoto(tx, photoId, newPersonId, familyId)
+			tagged[newPersonId] = true
+		}
+	}
+}
+
+// applyExportedPhotoMetadata restores the camera and location a bundle
+// carried. The place is named afresh rather than trusted from the file, so it
+// agrees with the table this server has. A hidden location stays hidden only
+// where the photo can be stripped; the bundle's copy already was.
+func applyExportedPhotoMetadata(image *Image, photo ExportPhoto) {
+	image.CameraMake = truncateCameraField(photo.CameraMake)
+	image.CameraModel = truncateCameraField(photo.CameraModel)
+	image.LensModel = truncateCameraField(photo.LensModel)
+	if validCoordinates(photo.Latitude, photo.Longitude) {
+		setPhotoLocation(image, photo.Latitude, photo.Longitude)
+	}
+	image.LocationHidden = photo.LocationHidden && canStripLocation(*image)
+}
+
+func hashZipEntry(zf *zip.File) (string, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	return contentHashOfReader(rc)
+}
+
 func writeZipEntryToDisk(zf *zip.File, diskPath string) error {
 	if err := os.MkdirAll(filepath.Dir(diskPath), 0755); err != nil {
 		return err
@@ -303,6 +411,16 @@ func zipExtToMime(ext string) string {
 		return "image/gif"
 	case ".webp":
 		return "image/webp"
+	case ".heic":
+		return "image/heic"
+	case ".heif":
+		return "image/heif"
+	case ".mp4":
+		return "video/mp4"
+	case ".m4v":
+		return "video/x-m4v"
+	case ".mov":
+		return "video/quicktime"
 	default:
 		return "application/octet-stream"
 	}
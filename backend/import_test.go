@@ -241,7 +241,7 @@ func TestImportMeasurements(t *testing.T) {
 
 	// Test importing measurements
 	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
-		importedCount, skippedCount, errors := importMeasurements(tx, importHeights, importWeights, personIdMapping, testUser.FamilyId)
+		importedCount, skippedCount, errors := importMeasurements(tx, importHeights, importWeights, personIdMapping, testUser.FamilyId, nil)
 
 		// Should import 2 valid heights + 2 weights = 4 measurements
 		// Should skip 1 height with invalid date (year 0001)
@@ -651,7 +651,7 @@ func TestFullImportWorkflow(t *testing.T) {
 
 		// Import measurements
 		filteredHeights, filteredWeights := filterMeasurements(importDataParsed.Heights, importDataParsed.Weights, personIdMapping)
-		importedMeasurements, skippedMeasurements, measurementErrors := importMeasurements(tx, filteredHeights, filteredWeights, personIdMapping, testUser.FamilyId)
+		importedMeasurements, skippedMeasurements, measurementErrors := importMeasurements(tx, filteredHeights, filteredWeights, personIdMapping, testUser.FamilyId, nil)
 		importResponse.ImportedMeasurements = importedMeasurements
 		importResponse.SkippedMeasurements = skippedMeasurements
 		importResponse.Errors = append(importResponse.Errors, measurementErrors...)
@@ -0,0 +1,368 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"family/cfg"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// "On this day" looks back at the milestones and photos that fall on today's
+// date in earlier years. It adds nothing to the records themselves: it is a
+// read over what the caller can already see on the timeline, so the same
+// family-link scopes decide what turns up — a person shared in for photos
+// contributes their photos and not their milestones.
+//
+// The daily digest sends the same list by email and, optionally, by push. Both
+// are off until the account asks for them in NotificationPreferences, and a
+// day on which nothing happened sends nothing.
+
+func RegisterMemoriesMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, GetOnThisDay)
+}
+
+// The digest runs hourly but sends at most once per account per day, from
+// memoriesDigestHour server time onwards. Checking hourly rather than sleeping
+// until the hour means a restart late in the morning still sends that day's
+// digest instead of waiting for tomorrow.
+const (
+	memoriesDigestInterval = time.Hour
+	memoriesDigestHour     = 8
+)
+
+// MemoriesDigestLog records the last day the digest was considered for an
+// account, whether or not it found anything to send. It is what stops an
+// hourly check, or a restart, from sending the same morning twice.
+type MemoriesDigestLog struct {
+	UserId int       `json:"userId"`
+	Day    string    `json:"day"` // YYYY-MM-DD, server time
+	SentAt time.Time `json:"sentAt"`
+}
+
+func PackMemoriesDigestLog(self *MemoriesDigestLog, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.UserId, buf)
+	vpack.String(&self.Day, buf)
+	vpack.Time(&self.SentAt, buf)
+}
+
+// MemoriesDigestLogBkt: user_id -> MemoriesDigestLog
+var MemoriesDigestLogBkt = vbolt.Bucket(&cfg.Info, "memories_digest_log", vpack.FInt, PackMemoriesDigestLog)
+
+// deleteMemoriesDigestLogTx drops the row for a deleted account.
+func deleteMemoriesDigestLogTx(tx *vbolt.Tx, userId int) {
+	vbolt.Delete(tx, MemoriesDigestLogBkt, userId)
+}
+
+// Request/Response types
+
+type GetOnThisDayRequest struct {
+	// Date is the day to look back from, YYYY-MM-DD. Empty means today.
+	Date string `json:"date,omitempty"`
+}
+
+// OnThisDayYear is everything from one earlier year.
+type OnThisDayYear struct {
+	Year       int         `json:"year"`
+	YearsAgo   int         `json:"yearsAgo"`
+	Milestones []Milestone `json:"milestones"`
+	Photos     []Image     `json:"photos"`
+}
+
+type GetOnThisDayResponse struct {
+	Date string `json:"date"`
+	// Years runs from the most recent year back.
+	Years []OnThisDayYear `json:"years"`
+}
+
+// isOnThisDay reports whether t fell on day's month and day in an earlier
+// year. Someone born or photographed on 29 February would otherwise only come
+// up one year in four, so in a common year their anniversary is the 28th.
+func isOnThisDay(t, day time.Time) bool {
+	if t.IsZero() || t.Year() >= day.Year() {
+		return false
+	}
+	if t.Month() == day.Month() && t.Day() == day.Day() {
+		return true
+	}
+	return t.Month() == time.February && t.Day() == 29 &&
+		day.Month() == time.February && day.Day() == 28 && !isLeapYear(day.Year())
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// collectOnThisDayTx gathers the user's memories for day, grouped by year.
+// Milestones come through the people the user may read milestones for, so a
+// milestone shared by two children is listed once; photos come through
+// GetVisibleImages, which already applies the photo scope, and go out with
+// any location the user may not see taken off. Only active photos count: one
+// still processing has nothing to show, and a hidden one was hidden on
+// purpose. Only dates known to the day count too: "July 2019" is stored as 1
+// July, and that is not a day anything happened on.
+func collectOnThisDayTx(tx *vbolt.Tx, user User, day time.Time) []OnThisDayYear {
+	byYear := make(map[int]*OnThisDayYear)
+	yearOf := func(year int) *OnThisDayYear {
+		entry, ok := byYear[year]
+		if !ok {
+			entry = &OnThisDayYear{
+				Year:       year,
+				YearsAgo:   day.Year() - year,
+				Milestones: []Milestone{},
+				Photos:     []Image{},
+			}
+			byYear[year] = entry
+		}
+		return entry
+	}
+
+	seen := make(map[int]bool)
+	var milestones []Milestone
+	for _, person := range GetVisiblePeople(tx, user) {
+		if !CanAccessPerson(tx, user, person, ScopeMilestones, AccessView) {
+			continue
+		}
+		for _, milestone := range GetPersonMilestonesTx(tx, person.Id) {
+			if seen[milestone.Id] || !isExactDate(milestone.DatePrecision) || !isOnThisDay(milestone.MilestoneDate, day) {
+				continue
+			}
+			seen[milestone.Id] = true
+			milestones = append(milestones, milestone)
+		}
+	}
+	milestones = withMilestonePersonIds(tx, user, milestones)
+	for _, milestone := range milestones {
+		milestone.PhotoIds = visiblePhotoIds(tx, user, GetMilestonePhotoIds(tx, milestone.Id))
+		milestone.TagIds = GetMilestoneTagIds(tx, milestone.Id)
+		entry := yearOf(milestone.MilestoneDate.Year())
+		entry.Milestones = append(entry.Milestones, milestone)
+	}
+
+	for _, image := range GetVisibleImages(tx, user) {
+		if image.Status != 0 || !isExactDate(image.PhotoDatePrecision) || !isOnThisDay(image.PhotoDate, day) {
+			continue
+		}
+		image.TagIds = GetPhotoTagIds(tx, image.Id)
+		redactPhotoLocation(tx, user, &image)
+		entry := yearOf(image.PhotoDate.Year())
+		entry.Photos = append(entry.Photos, image)
+	}
+
+	years := make([]OnThisDayYear, 0, len(byYear))
+	for _, entry := range byYear {
+		sort.Slice(entry.Milestones, func(i, j int) bool {
+			if !entry.Milestones[i].MilestoneDate.Equal(entry.Milestones[j].MilestoneDate) {
+				return entry.Milestones[i].MilestoneDate.Before(entry.Milestones[j].MilestoneDate)
+			}
+			return entry.Milestones[i].Id < entry.Milestones[j].Id
+		})
+		sort.Slice(entry.Photos, func(i, j int) bool {
+			if !entry.Photos[i].PhotoDate.Equal(entry.Photos[j].PhotoDate) {
+				return entry.Photos[i].PhotoDate.Before(entry.Photos[j].PhotoDate)
+			}
+			return entry.Photos[i].Id < entry.Photos[j].Id
+		})
+		years = append(years, *entry)
+	}
+	sort.Slice(years, func(i, j int) bool { return years[i].Year > years[j].Year })
+	return years
+}
+
+// vbeam procedures
+
+func GetOnThisDay(ctx *vbeam.Context, req GetOnThisDayRequest) (resp GetOnThisDayResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	day := time.Now()
+	if req.Date != "" {
+		day, err = time.Parse("2006-01-02", req.Date)
+		if err != nil {
+			err = errors.New("Invalid date format. Use YYYY-MM-DD")
+			return
+		}
+	}
+
+	resp.Date = day.Format("2006-01-02")
+	resp.Years = collectOnThisDayTx(ctx.Tx, user, day)
+	return
+}
+
+// ── daily digest ──────────────────────────────────────────────────────────────
+
+// RunMemoriesDigest checks for digests to send immediately and then hourly
+// until ctx is cancelled. Run it in its own goroutine.
+func RunMemoriesDigest(ctx context.Context, db *vbolt.DB) {
+	check := func() {
+		vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+			SendMemoriesDigestsTx(tx, time.Now())
+			vbolt.TxCommit(tx)
+		})
+	}
+
+	check()
+	ticker := time.NewTicker(memoriesDigestInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+// SendMemoriesDigestsTx sends today's digest to every account that opted in
+// and has not been considered yet today, and returns how many had something to
+// send. Before memoriesDigestHour it does nothing, so nobody's phone lights up
+// at midnight. As with growth alerts, a failure to queue is logged and
+// otherwise ignored: tomorrow brings another digest.
+func SendMemoriesDigestsTx(tx *vbolt.Tx, now time.Time) (sent int) {
+	if now.Hour() < memoriesDigestHour {
+		return
+	}
+	today := now.Format("2006-01-02")
+
+	var users []User
+	vbolt.IterateAll(tx, UsersBkt, func(id int, user User) bool {
+		users = append(users, user)
+		return true
+	})
+
+	for _, user := range users {
+		prefs := loadNotificationPreferences(tx, user.Id)
+		if !prefs.MemoriesEnabled && !prefs.MemoriesEmails {
+			continue
+		}
+		var last MemoriesDigestLog
+		vbolt.Read(tx, MemoriesDigestLogBkt, user.Id, &last)
+		if last.Day == today {
+			continue
+		}
+		last = MemoriesDigestLog{UserId: user.Id, Day: today, SentAt: now}
+		vbolt.Write(tx, MemoriesDigestLogBkt, user.Id, &last)
+
+		years := collectOnThisDayTx(tx, user, now)
+		if len(years) == 0 {
+			continue
+		}
+		sent++
+
+		if prefs.MemoriesEmails && user.Email != "" {
+			err := QueueMail(MailJob{
+				To:      user.Email,
+				Subject: "On this day in your family",
+				Body:    memoriesEmailBody(tx, user, loadUserPreferences(tx, user.Id), now, years),
+				Kind:    "memories_digest",
+			})
+			if err != nil {
+				LogWarn(LogCategoryAPI, "Failed to queue memories email", map[string]interface{}{
+					"userId": user.Id,
+					"error":  err.Error(),
+				})
+			}
+		}
+
+		if !prefs.MemoriesEnabled || !IsPushWorkerEnabled() {
+			continue
+		}
+		err := QueuePushNotification(PushNotificationJob{
+			Event:            PushEventMemories,
+			FamilyId:         user.FamilyId,
+			Content:          memoriesSummary(tx, years),
+			RecipientUserIds: []int{user.Id},
+		})
+		if err != nil {
+			LogWarn(LogCategoryAPI, "Failed to queue memories push", map[string]interface{}{
+				"userId": user.Id,
+				"error":  err.Error(),
+			})
+		}
+	}
+	return
+}
+
+// memoryLabel names one milestone the way the digest lists it: who it is
+// about, then what happened.
+func memoryLabel(tx *vbolt.Tx, milestone Milestone) string {
+	names := make([]string, 0, len(milestone.PersonIds))
+	for _, personId := range milestone.PersonIds {
+		if person := GetPersonById(tx, personId); person.Id != 0 {
+			names = append(names, person.Name)
+		}
+	}
+	if len(names) == 0 {
+		return milestone.Description
+	}
+	return strings.Join(names, " & ") + ": " + milestone.Description
+}
+
+func yearsAgoLabel(yearsAgo int) string {
+	if yearsAgo == 1 {
+		return "1 year ago"
+	}
+	return fmt.Sprintf("%d years ago", yearsAgo)
+}
+
+func photoCountLabel(count int) string {
+	if count == 1 {
+		return "1 photo"
+	}
+	return fmt.Sprintf("%d photos", count)
+}
+
+// memoriesSummary is the one line a push has room for: the most recent
+// milestone if there is one, or a count of photos if not.
+func memoriesSummary(tx *vbolt.Tx, years []OnThisDayYear) string {
+	total := 0
+	for _, year := range years {
+		total += len(year.Milestones) + len(year.Photos)
+	}
+	for _, year := range years {
+		if len(year.Milestones) == 0 {
+			continue
+		}
+		summary := fmt.Sprintf("%s: %s", yearsAgoLabel(year.YearsAgo), memoryLabel(tx, year.Milestones[0]))
+		if total > 1 {
+			summary += fmt.Sprintf(" (and %d more)", total-1)
+		}
+		return summary
+	}
+	return fmt.Sprintf("%s from this day in earlier years", photoCountLabel(total))
+}
+
+func memoriesEmailBody(tx *vbolt.Tx, recipient User, display UserPreferences, now time.Time, years []OnThisDayYear) string {
+	greeting := "Hello,"
+	if recipient.Name != "" {
+		greeting = "Hello " + recipient.Name + ","
+	}
+	var list strings.Builder
+	for _, year := range years {
+		fmt.Fprintf(&list, "%d, %s\n", year.Year, yearsAgoLabel(year.YearsAgo))
+		for _, milestone := range year.Milestones {
+			fmt.Fprintf(&list, "  - %s\n", memoryLabel(tx, milestone))
+		}
+		if len(year.Photos) > 0 {
+			fmt.Fprintf(&list, "  - %s\n", photoCountLabel(len(year.Photos)))
+		}
+		list.WriteString("\n")
+	}
+	return fmt.Sprintf(`%s
+
+Here is what happened on %s in earlier years:
+
+%sYou can turn these emails off in Settings.
+`, greeting, display.formatDate(now), list.String())
+}
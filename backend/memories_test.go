@@ -0,0 +1,206 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+// memoriesDay is the "today" the tests look back from.
+var memoriesDay = time.Date(2026, time.October, 16, 9, 0, 0, 0, time.UTC)
+
+type memoriesFixture struct {
+	familyLinkFixture
+	// Besides these two milestones, alice's photo is dated 2021 and the
+	// untagged one 2020.
+	firstSteps   Milestone // 2023, bob: only A sees it
+	applePicking Milestone // 2022, alice and bob
+}
+
+func setupMemoriesFixture(t *testing.T) memoriesFixture {
+	t.Helper()
+	base, cleanup := setupFamilyLinkFixture(t)
+	t.Cleanup(cleanup)
+	fx := memoriesFixture{familyLinkFixture: base}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		fx.firstSteps, err = AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.bob.Id, Description: "First steps", Category: "first",
+			InputType: "date", MilestoneDate: stringPtr("2023-10-16"),
+		}, fx.famA)
+		if err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		fx.applePicking, err = AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.alice.Id, PersonIds: []int{fx.bob.Id}, Description: "Apple picking",
+			Category: "other", InputType: "date", MilestoneDate: stringPtr("2022-10-16"),
+		}, fx.famA)
+		if err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		// The day before, which is not this day.
+		if _, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.alice.Id, Description: "Lost a tooth", Category: "first",
+			InputType: "date", MilestoneDate: stringPtr("2022-10-15"),
+		}, fx.famA); err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+
+		fx.alicePhoto.PhotoDate = time.Date(2021, time.October, 16, 15, 30, 0, 0, time.UTC)
+		vbolt.Write(tx, ImagesBkt, fx.alicePhoto.Id, &fx.alicePhoto)
+		fx.untaggedPhoto.PhotoDate = time.Date(2020, time.October, 16, 10, 0, 0, 0, time.UTC)
+		vbolt.Write(tx, ImagesBkt, fx.untaggedPhoto.Id, &fx.untaggedPhoto)
+
+		// Taken on this day, but hidden.
+		hidden := writeTestImage(tx, fx.famA, fx.userA.Id, "hidden.jpg")
+		hidden.PhotoDate = time.Date(2019, time.October, 16, 0, 0, 0, 0, time.UTC)
+		hidden.Status = 2
+		vbolt.Write(tx, ImagesBkt, hidden.Id, &hidden)
+		vbolt.TxCommit(tx)
+	})
+	return fx
+}
+
+func memoryYears(years []OnThisDayYear) (got []int) {
+	for _, year := range years {
+		got = append(got, year.Year)
+	}
+	return
+}
+
+func TestIsOnThisDay(t *testing.T) {
+	day := time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		date time.Time
+		want bool
+	}{
+		{time.Date(2020, time.October, 16, 23, 0, 0, 0, time.UTC), true},
+		{time.Date(2020, time.October, 17, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC), false}, // this year is not a memory yet
+		{time.Time{}, false},
+	}
+	for _, tc := range cases {
+		if got := isOnThisDay(tc.date, day); got != tc.want {
+			t.Errorf("isOnThisDay(%v) = %v, want %v", tc.date, got, tc.want)
+		}
+	}
+
+	leapDay := time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)
+	if !isOnThisDay(leapDay, time.Date(2025, time.February, 28, 0, 0, 0, 0, time.UTC)) {
+		t.Error("a 29 February memory did not come up on the 28th of a common year")
+	}
+	if isOnThisDay(leapDay, time.Date(2028, time.February, 28, 0, 0, 0, 0, time.UTC)) {
+		t.Error("a 29 February memory came up on the 28th of a leap year, which has its own 29th")
+	}
+}
+
+func TestOnThisDayForTheOwnFamily(t *testing.T) {
+	fx := setupMemoriesFixture(t)
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		years := collectOnThisDayTx(tx, fx.userA, memoriesDay)
+		if got := memoryYears(years); len(got) != 4 || got[0] != 2023 || got[3] != 2020 {
+			t.Fatalf("years = %v, want 2023, 2022, 2021, 2020 with the hidden photo left out", got)
+		}
+		if years[0].YearsAgo != 3 || len(years[0].Milestones) != 1 || years[0].Milestones[0].Id != fx.firstSteps.Id {
+			t.Errorf("2023 = %+v, want bob's first steps", years[0])
+		}
+		// A milestone about two people is one memory, not two.
+		shared := years[1].Milestones
+		if len(shared) != 1 || len(shared[0].PersonIds) != 2 {
+			t.Errorf("2022 milestones = %+v, want apple picking once, about both children", shared)
+		}
+		if len(years[3].Photos) != 1 || years[3].Photos[0].Id != fx.untaggedPhoto.Id {
+			t.Errorf("2020 = %+v, want the untagged photo", years[3])
+		}
+	})
+}
+
+func TestOnThisDayFollowsLinkScopes(t *testing.T) {
+	fx := setupMemoriesFixture(t)
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		years := collectOnThisDayTx(tx, fx.userB, memoriesDay)
+		if got := memoryYears(years); len(got) != 2 || got[0] != 2022 || got[1] != 2021 {
+			t.Fatalf("years = %v, want only what alice's link carries", got)
+		}
+		// Bob is on the milestone but not shared with B.
+		if ids := years[0].Milestones[0].PersonIds; len(ids) != 1 || ids[0] != fx.alice.Id {
+			t.Errorf("people on the shared milestone = %v, want alice alone", ids)
+		}
+		// Two hops away, C sees none of A's memories.
+		if years := collectOnThisDayTx(tx, fx.userC, memoriesDay); len(years) != 0 {
+			t.Errorf("C got %v, want nothing from A", memoryYears(years))
+		}
+	})
+
+	setLinkScopes(t, fx.familyLinkFixture, fx.linkAB, LinkScopes{People: true, Photos: true})
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		years := collectOnThisDayTx(tx, fx.userB, memoriesDay)
+		if got := memoryYears(years); len(got) != 1 || got[0] != 2021 || len(years[0].Milestones) != 0 {
+			t.Errorf("years = %v, want the photo without the milestone", got)
+		}
+	})
+}
+
+func TestMemoriesDigestIsOptInAndOncePerDay(t *testing.T) {
+	fx := setupMemoriesFixture(t)
+	recorder := newMailRecorder(t)
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if sent := SendMemoriesDigestsTx(tx, memoriesDay); sent != 0 {
+			t.Errorf("sent = %d before anybody opted in", sent)
+		}
+		for _, userId := range []int{fx.userA.Id, fx.userC.Id} {
+			prefs := NotificationPreferences{UserId: userId, ChatEnabled: true, MemoriesEmails: true}
+			vbolt.Write(tx, NotificationPreferencesBkt, prefs.UserId, &prefs)
+		}
+
+		early := time.Date(2026, time.October, 16, 6, 0, 0, 0, time.UTC)
+		if sent := SendMemoriesDigestsTx(tx, early); sent != 0 {
+			t.Errorf("sent = %d before the digest hour", sent)
+		}
+		// C opted in but has nothing on this day, so only A hears.
+		if sent := SendMemoriesDigestsTx(tx, memoriesDay); sent != 1 {
+			t.Errorf("sent = %d, want 1", sent)
+		}
+		if sent := SendMemoriesDigestsTx(tx, memoriesDay.Add(3*time.Hour)); sent != 0 {
+			t.Errorf("sent = %d on the second check of the day", sent)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	recorder.waitForAttempts(t, 1)
+	if recorder.count() != 1 {
+		t.Fatalf("emails = %d, want 1", recorder.count())
+	}
+	job := recorder.jobs[0]
+	if job.To != fx.userA.Email || job.Kind != "memories_digest" {
+		t.Errorf("email = %+v", job)
+	}
+	for _, want := range []string{"Bob: First steps", "Alice & Bob: Apple picking", "1 photo", "3 years ago"} {
+		if !strings.Contains(job.Body, want) {
+			t.Errorf("email body is missing %q:\n%s", want, job.Body)
+		}
+	}
+}
+
+func TestMemoriesPushFollowsThePreviewSetting(t *testing.T) {
+	job := PushNotificationJob{Event: PushEventMemories, Content: "3 years ago: Bob: First steps"}
+
+	quiet := buildAPNsPayload(job, NotificationPreferences{UserId: 1, MemoriesEnabled: true})
+	if strings.Contains(quiet.Aps.Alert.Body, "Bob") {
+		t.Errorf("quiet alert = %+v, want no names", quiet.Aps.Alert)
+	}
+	preview := buildAPNsPayload(job, NotificationPreferences{UserId: 1, MemoriesEnabled: true, ShowMessageText: true})
+	if preview.Aps.Alert.Body != job.Content {
+		t.Errorf("preview alert = %+v, want the summary", preview.Aps.Alert)
+	}
+
+	if (NotificationPreferences{ChatEnabled: true}).allowsEvent(PushEventMemories) {
+		t.Error("a memories push was allowed without opting in")
+	}
+}
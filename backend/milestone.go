@@ -4,6 +4,7 @@ import (
 	"errors"
 	"family/cfg"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -26,12 +27,14 @@ func RegisterMilestoneMethods(app *vbeam.Application) {
 type AddMilestoneRequest struct {
 	PersonId      int     `json:"personId"`
 	Description   string  `json:"description"`
-	Category      string  `json:"category"`                // "development", "behavior", "health", "achievement", "first", "other"
+	Category      string  `json:"category"`                // a category key, name or alias of the family
 	InputType     string  `json:"inputType"`               // "today", "date" or "age"
 	MilestoneDate *string `json:"milestoneDate,omitempty"` // YYYY-MM-DD format (if inputType is "date")
 	AgeYears      *int    `json:"ageYears,omitempty"`      // Age in years (if inputType is "age")
 	AgeMonths     *int    `json:"ageMonths,omitempty"`     // Additional months (if inputType is "age")
 	PhotoIds      []int   `json:"photoIds,omitempty"`      // Optional photo IDs to associate
+	PersonIds     []int   `json:"personIds,omitempty"`     // Others the milestone is also about
+	DatePrecision string  `json:"datePrecision,omitempty"` // "day" (default), "month", "season", "year" or "approximate"
 }
 
 type AddMilestoneResponse struct {
@@ -49,12 +52,14 @@ type GetPersonMilestonesResponse struct {
 type UpdateMilestoneRequest struct {
 	Id            int     `json:"id"`
 	Description   string  `json:"description"`
-	Category      string  `json:"category"`                // "development", "behavior", "health", "achievement", "first", "other"
+	Category      string  `json:"category"`                // a category key, name or alias of the family
 	InputType     string  `json:"inputType"`               // "today", "date" or "age"
 	MilestoneDate *string `json:"milestoneDate,omitempty"` // YYYY-MM-DD format (if inputType is "date")
 	AgeYears      *int    `json:"ageYears,omitempty"`      // Age in years (if inputType is "age")
 	AgeMonths     *int    `json:"ageMonths,omitempty"`     // Additional months (if inputType is "age")
 	PhotoIds      []int   `json:"photoIds,omitempty"`      // Optional photo IDs to associate
+	PersonIds     []int   `json:"personIds,omitempty"`     // Everyone the milestone is about; nil leaves them unchanged
+	DatePrecision *string `json:"datePrecision,omitempty"` // nil leaves the precision unchanged
 }
 
 type UpdateMilestoneResponse struct {
@@ -98,6 +103,12 @@ type Milestone struct {
 	CreatedAt     time.Time `json:"createdAt"`
 	PhotoIds      []int     `json:"photoIds,omitempty"`
 	TagIds        []int     `json:"tagIds,omitempty"`
+	// PersonIds is everyone the milestone is about, PersonId among them. It is
+	// stored as MilestonePerson rows and filled in on the way out.
+	PersonIds []int `json:"personIds,omitempty"`
+	// DatePrecision says how much of MilestoneDate to believe; see
+	// date_precision.go. Rows written before it existed read back as "day".
+	DatePrecision string `json:"datePrecision"`
 }
 
 // MilestonePhoto represents the relationship between milestones and photos
@@ -129,7 +140,7 @@ func PackMilestoneTag(self *MilestoneTag, buf *vpack.Buffer) {
 
 // Packing function for vbolt serialization
 func PackMilestone(self *Milestone, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
+	version := vpack.Version(2, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.Int(&self.PersonId, buf)
 	vpack.Int(&self.FamilyId, buf)
@@ -137,6 +148,11 @@ func PackMilestone(self *Milestone, buf *vpack.Buffer) {
 	vpack.String(&self.Category, buf)
 	vpack.Time(&self.MilestoneDate, buf)
 	vpack.Time(&self.CreatedAt, buf)
+	if version >= 2 {
+		vpack.String(&self.DatePrecision, buf)
+	} else {
+		self.DatePrecision = DatePrecisionDay
+	}
 }
 
 func PackMilestonePhoto(self *MilestonePhoto, buf *vpack.Buffer) {
@@ -153,10 +169,6 @@ var MilestoneBkt = vbolt.Bucket(&cfg.Info, "milestones", vpack.FInt, PackMilesto
 var MilestonePhotoBkt = vbolt.Bucket(&cfg.Info, "milestone_photos", vpack.FInt, PackMilestonePhoto)
 var MilestoneTagBkt = vbolt.Bucket(&cfg.Info, "milestone_tags", vpack.FInt, PackMilestoneTag)
 
-// MilestoneByPersonIndex: term = person_id, target = milestone_id
-// This allows efficient lookup of milestones by person
-var MilestoneByPersonIndex = vbolt.Index(&cfg.Info, "milestones_by_person", vpack.FInt, vpack.FInt)
-
 // MilestoneByFamilyIndex: term = family_id, target = milestone_id
 // This allows efficient lookup of milestones by family
 var MilestoneByFamilyIndex = vbolt.Index(&cfg.Info, "milestones_by_family", vpack.FInt, vpack.FInt)
@@ -189,13 +201,15 @@ func GetMilestoneById(tx *vbolt.Tx, milestoneId int) (milestone Milestone) {
 	return
 }
 
+// GetPersonMilestonesTx returns every milestone the person is on, including
+// those shared with others, oldest first.
 func GetPersonMilestonesTx(tx *vbolt.Tx, personId int) []Milestone {
 	milestones := []Milestone{}
-	var milestoneIds []int
-	vbolt.ReadTermTargets(tx, MilestoneByPersonIndex, personId, &milestoneIds, vbolt.Window{})
+	milestoneIds := getPersonMilestoneIds(tx, personId)
 	if len(milestoneIds) > 0 {
 		vbolt.ReadSlice(tx, MilestoneBkt, milestoneIds, &milestones)
 	}
+	sortMilestonesByDate(milestones)
 	return milestones
 }
 
@@ -253,7 +267,7 @@ func GetMilestoneForUser(tx *vbolt.Tx, milestoneId int, user User, need AccessLe
 	if milestone.Id == 0 {
 		return milestone, errors.New("Milestone not found")
 	}
-	if !CanAccessRecordOfPerson(tx, user, milestone.FamilyId, milestone.PersonId, ScopeMilestones, need) {
+	if !CanAccessMilestone(tx, user, milestone, need) {
 		return milestone, errors.New("Access denied: milestone belongs to another family")
 	}
 	return milestone, nil
@@ -269,7 +283,7 @@ func SearchMilestonesTx(tx *vbolt.Tx, query string, familyId int, limit int) []M
 // and the milestones of people shared into those families.
 func SearchVisibleMilestones(tx *vbolt.Tx, query string, user User, limit int) []Milestone {
 	return searchMilestonesTx(tx, query, limit, func(milestone Milestone) bool {
-		return CanAccessRecordOfPerson(tx, user, milestone.FamilyId, milestone.PersonId, ScopeMilestones, AccessView)
+		return CanAccessMilestone(tx, user, milestone, AccessView)
 	})
 }
 
@@ -343,15 +357,23 @@ func UpdateMilestoneSearchIndex(tx *vbolt.Tx, milestone Milestone) {
 	// Add category term
 	terms = append(terms, fmt.Sprintf("cat:%s", milestone.Category))
 
-	// Add year and month terms
+	// Add year and month terms for every month the date covers. "Summer 2019"
+	// is found under June, July and August; "2019" under the year alone.
 	terms = append(terms, fmt.Sprintf("y:%d", milestone.MilestoneDate.Year()))
-	terms = append(terms, fmt.Sprintf("m:%s", milestone.MilestoneDate.Format("2006.01")))
+	for _, month := range datePeriodMonths(milestone.MilestoneDate, milestone.DatePrecision) {
+		if month.Year() != milestone.MilestoneDate.Year() {
+			terms = append(terms, fmt.Sprintf("y:%d", month.Year()))
+		}
+		terms = append(terms, fmt.Sprintf("m:%s", month.Format("2006.01")))
+	}
 
-	// Add person term for filtering by person
-	terms = append(terms, fmt.Sprintf("p:%d", milestone.PersonId))
+	// Add a person term for each person on the milestone, for filtering by person
+	for _, personId := range GetMilestonePersonIds(tx, milestone.Id) {
+		terms = append(terms, fmt.Sprintf("p:%d", personId))
+	}
 
-	// Update the search index with all terms, using milestone date as priority for sorting
-	vbolt.SetTargetTermsUniform(tx, MilestoneSearchIndex, milestone.Id, terms, milestone.MilestoneDate)
+	// Update the search index with all terms, using where the date sorts as priority
+	vbolt.SetTargetTermsUniform(tx, MilestoneSearchIndex, milestone.Id, terms, dateSortKey(milestone.MilestoneDate, milestone.DatePrecision))
 }
 
 func normalizePhotoIds(photoIds []int) []int {
@@ -530,28 +552,37 @@ func AddMilestoneTx(tx *vbolt.Tx, req AddMilestoneRequest, familyId int) (Milest
 	var milestone Milestone
 	var err error
 
-	// Validate person belongs to family
-	person := GetPersonById(tx, req.PersonId)
-	if person.Id == 0 || !CanFamilyAccess(tx, familyId, person.FamilyId, AccessContribute) {
-		return milestone, errors.New("Person not found or not in your family")
+	// Validate every person belongs to family
+	personIds := normalizeMilestonePersonIds(req.PersonId, req.PersonIds)
+	if err = validateMilestonePeople(tx, personIds, familyId); err != nil {
+		return milestone, err
 	}
+	person := GetPersonById(tx, req.PersonId)
 
 	// Parse milestone date
-	milestone.MilestoneDate, err = parseMilestoneDate(req, person.Birthday)
+	if err = validateDatePrecision(req.DatePrecision); err != nil {
+		return milestone, err
+	}
+	milestone.DatePrecision = normalizeDatePrecision(req.DatePrecision)
+	milestone.MilestoneDate, err = parseMilestoneDate(req, person.Birthday, milestone.DatePrecision)
 	if err != nil {
 		return milestone, err
 	}
 
+	if milestone.Category, err = resolveMilestoneCategoryTx(tx, familyId, req.Category); err != nil {
+		return milestone, err
+	}
+
 	// Create milestone record
 	milestone.Id = vbolt.NextIntId(tx, MilestoneBkt)
 	milestone.PersonId = req.PersonId
 	milestone.FamilyId = familyId
 	milestone.Description = strings.TrimSpace(req.Description)
-	milestone.Category = req.Category
 	milestone.CreatedAt = time.Now()
 
 	vbolt.Write(tx, MilestoneBkt, milestone.Id, &milestone)
 
+	setMilestonePeopleTx(tx, &milestone, personIds)
 	updateMilestoneIndices(tx, milestone)
 
 	if req.PhotoIds != nil {
@@ -567,7 +598,6 @@ func AddMilestoneTx(tx *vbolt.Tx, req AddMilestoneRequest, familyId int) (Milest
 }
 
 func updateMilestoneIndices(tx *vbolt.Tx, milestone Milestone) {
-	vbolt.SetTargetSingleTerm(tx, MilestoneByPersonIndex, milestone.Id, milestone.PersonId)
 	vbolt.SetTargetSingleTerm(tx, MilestoneByFamilyIndex, milestone.Id, milestone.FamilyId)
 	UpdateMilestoneSearchIndex(tx, milestone)
 }
@@ -581,24 +611,53 @@ func UpdateMilestoneTx(tx *vbolt.Tx, req UpdateMilestoneRequest, familyId int) (
 		return milestone, err
 	}
 
+	// A new list of people is checked before anything is written, and
+	// decides whose birthday an age-based date is counted from.
+	var personIds []int
+	if req.PersonIds != nil {
+		personIds = normalizeMilestonePersonIds(0, req.PersonIds)
+		if err = validateMilestonePeople(tx, personIds, familyId); err != nil {
+			return milestone, err
+		}
+		if !slices.Contains(personIds, milestone.PersonId) {
+			milestone.PersonId = personIds[0]
+		}
+	}
+
 	// Get person for date calculation if needed
 	person := GetPersonById(tx, milestone.PersonId)
 	if person.Id == 0 {
 		return milestone, errors.New("Person not found")
 	}
 
-	// Parse milestone date
-	milestone.MilestoneDate, err = parseMilestoneDate(req, person.Birthday)
+	// Parse milestone date, at the precision it already had unless the
+	// request names a new one
+	if req.DatePrecision != nil {
+		if err = validateDatePrecision(*req.DatePrecision); err != nil {
+			return milestone, err
+		}
+		milestone.DatePrecision = normalizeDatePrecision(*req.DatePrecision)
+	}
+	milestone.MilestoneDate, err = parseMilestoneDate(req, person.Birthday, milestone.DatePrecision)
 	if err != nil {
 		return milestone, err
 	}
 
 	// Update the milestone fields
 	milestone.Description = strings.TrimSpace(req.Description)
-	milestone.Category = req.Category
+	// Only a change of category needs looking up; the key already stored is
+	// one the family has.
+	if req.Category != milestone.Category {
+		if milestone.Category, err = resolveMilestoneCategoryTx(tx, familyId, req.Category); err != nil {
+			return milestone, err
+		}
+	}
 
 	// Save updated record
 	vbolt.Write(tx, MilestoneBkt, milestone.Id, &milestone)
+	if personIds != nil {
+		setMilestonePeopleTx(tx, &milestone, personIds)
+	}
 
 	// Update search index (description, category, or date may have changed)
 	UpdateMilestoneSearchIndex(tx, milestone)
@@ -658,12 +717,14 @@ func DeleteMilestoneTx(tx *vbolt.Tx, milestoneId int, familyId int) error {
 	}
 
 	// Remove from indices
-	vbolt.SetTargetSingleTerm(tx, MilestoneByPersonIndex, milestone.Id, -1)
 	vbolt.SetTargetSingleTerm(tx, MilestoneByFamilyIndex, milestone.Id, -1)
 	vbolt.SetTargetTermsUniform(tx, MilestoneSearchIndex, milestone.Id, []string{}, time.Time{})
 
 	removeAllMilestonePhotos(tx, milestone.Id)
 	removeAllMilestoneTags(tx, milestone.Id)
+	removeAllMilestonePeople(tx, milestone.Id)
+	removeMilestoneChecklistAchievements(tx, milestone.Id)
+	deleteTargetCommentsTx(tx, CommentTarget{Kind: CommentTargetMilestone, Id: milestone.Id})
 
 	// Delete the record
 	vbolt.Delete(tx, MilestoneBkt, milestone.Id)
@@ -688,7 +749,18 @@ func (req UpdateMilestoneRequest) GetMilestoneDate() *string { return req.Milest
 func (req UpdateMilestoneRequest) GetAgeYears() *int         { return req.AgeYears }
 func (req UpdateMilestoneRequest) GetAgeMonths() *int        { return req.AgeMonths }
 
-func parseMilestoneDate(req MilestoneDateRequest, personBirthday time.Time) (time.Time, error) {
+// parseMilestoneDate works out the date a request names and snaps it to the
+// start of the period the precision covers, so "age 2, approximately" and
+// "July 2019" store what they mean rather than a day nobody chose.
+func parseMilestoneDate(req MilestoneDateRequest, personBirthday time.Time, precision string) (time.Time, error) {
+	date, err := parseMilestoneInputDate(req, personBirthday, precision)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return anchorDate(date, precision), nil
+}
+
+func parseMilestoneInputDate(req MilestoneDateRequest, personBirthday time.Time, precision string) (time.Time, error) {
 	if req.GetInputType() == "today" {
 		// Use current date for "today" input type
 		return time.Now(), nil
@@ -697,7 +769,7 @@ func parseMilestoneDate(req MilestoneDateRequest, personBirthday time.Time) (tim
 		if milestoneDate == nil || *milestoneDate == "" {
 			return time.Time{}, errors.New("Milestone date is required when input type is 'date'")
 		}
-		return time.Parse("2006-01-02", *milestoneDate)
+		return parseDateValue(*milestoneDate, precision)
 	} else if req.GetInputType() == "age" {
 		ageYears := req.GetAgeYears()
 		if ageYears == nil || *ageYears < 0 {
@@ -726,21 +798,13 @@ func validateAddMilestoneRequest(req AddMilestoneRequest) error {
 	if strings.TrimSpace(req.Description) == "" {
 		return errors.New("Description is required")
 	}
-	validCategories := []string{"development", "behavior", "health", "achievement", "first", "other"}
-	isValidCategory := false
-	for _, category := range validCategories {
-		if req.Category == category {
-			isValidCategory = true
-			break
-		}
-	}
-	if !isValidCategory {
-		return errors.New("Category must be one of: development, behavior, health, achievement, first, other")
+	if err := validateMilestoneCategoryName(req.Category); err != nil {
+		return err
 	}
 	if req.InputType != "today" && req.InputType != "date" && req.InputType != "age" {
 		return errors.New("Input type must be 'today', 'date' or 'age'")
 	}
-	return nil
+	return validateDatePrecision(req.DatePrecision)
 }
 
 func validateUpdateMilestoneRequest(req UpdateMilestoneRequest) error {
@@ -750,20 +814,15 @@ func validateUpdateMilestoneRequest(req UpdateMilestoneRequest) error {
 	if strings.TrimSpace(req.Description) == "" {
 		return errors.New("Description is required")
 	}
-	validCategories := []string{"development", "behavior", "health", "achievement", "first", "other"}
-	isValidCategory := false
-	for _, category := range validCategories {
-		if req.Category == category {
-			isValidCategory = true
-			break
-		}
-	}
-	if !isValidCategory {
-		return errors.New("Category must be one of: development, behavior, health, achievement, first, other")
+	if err := validateMilestoneCategoryName(req.Category); err != nil {
+		return err
 	}
 	if req.InputType != "today" && req.InputType != "date" && req.InputType != "age" {
 		return errors.New("Input type must be 'today', 'date' or 'age'")
 	}
+	if req.DatePrecision != nil {
+		return validateDatePrecision(*req.DatePrecision)
+	}
 	return nil
 }
 
@@ -794,7 +853,7 @@ func AddMilestone(ctx *vbeam.Context, req AddMilestoneRequest) (resp AddMileston
 		return
 	}
 
-	resp.Milestone = milestone
+	resp.Milestone = withMilestonePersonIds(ctx.Tx, user, []Milestone{milestone})[0]
 	resp.Milestone.PhotoIds = GetMilestonePhotoIds(ctx.Tx, milestone.Id)
 	resp.Milestone.TagIds = GetMilestoneTagIds(ctx.Tx, milestone.Id)
 
@@ -824,7 +883,7 @@ func GetPersonMilestones(ctx *vbeam.Context, req GetPersonMilestonesRequest) (re
 		milestones[i].PhotoIds = GetMilestonePhotoIds(ctx.Tx, milestones[i].Id)
 		milestones[i].TagIds = GetMilestoneTagIds(ctx.Tx, milestones[i].Id)
 	}
-	resp.Milestones = milestones
+	resp.Milestones = withMilestonePersonIds(ctx.Tx, user, milestones)
 	return
 }
 
@@ -848,7 +907,7 @@ func GetMilestone(ctx *vbeam.Context, req GetMilestoneRequest) (resp GetMileston
 		return
 	}
 
-	resp.Milestone = milestone
+	resp.Milestone = withMilestonePersonIds(ctx.Tx, user, []Milestone{milestone})[0]
 	resp.Milestone.PhotoIds = GetMilestonePhotoIds(ctx.Tx, milestone.Id)
 	resp.Milestone.TagIds = GetMilestoneTagIds(ctx.Tx, milestone.Id)
 	return
@@ -880,7 +939,7 @@ func UpdateMilestone(ctx *vbeam.Context, req UpdateMilestoneRequest) (resp Updat
 		return
 	}
 
-	resp.Milestone = milestone
+	resp.Milestone = withMilestonePersonIds(ctx.Tx, user, []Milestone{milestone})[0]
 	resp.Milestone.PhotoIds = GetMilestonePhotoIds(ctx.Tx, milestone.Id)
 	resp.Milestone.TagIds = GetMilestoneTagIds(ctx.Tx, milestone.Id)
 
@@ -1015,7 +1074,7 @@ func SearchMilestones(ctx *vbeam.Context, req SearchMilestonesRequest) (resp Sea
 	// Search milestones
 	milestones := SearchVisibleMilestones(ctx.Tx, query, user, limit)
 
-	resp.Milestones = milestones
+	resp.Milestones = withMilestonePersonIds(ctx.Tx, user, milestones)
 	resp.Query = query
 	return
 }
@@ -0,0 +1,569 @@
+package backend
+
+import (
+	"errors"
+	"family/cfg"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// Milestone categories used to be whatever string arrived in the request. The
+// procs checked it against six values, but import did not, so real data
+// collected "first", "First", "firsts" and "Firsts" as four categories with
+// one meaning. A category is now a record of its own, per family like a Tag,
+// with a name, a color and an icon, and a place in the family's order.
+//
+// Milestone.Category still holds a string: the category's Key. The key is
+// fixed when the category is made — the six built-in ones keep the values
+// clients already know — and renaming changes only the Name, so a rename never
+// has to touch the milestones filed under it. Merging does: every milestone of
+// the source moves to the target, and the source's key and name become aliases
+// of the target, so a request or an import still naming the old category lands
+// in the new one.
+//
+// Names are compared folded: lower case, punctuation and spaces run together
+// into a hyphen, and a plain plural "s" dropped. That is what makes "Firsts"
+// and "first" the same category, both when a milestone is filed and when the
+// migration gathers up the free text already stored.
+
+func RegisterMilestoneCategoryMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, ListMilestoneCategories)
+	vbeam.RegisterProc(app, CreateMilestoneCategory)
+	vbeam.RegisterProc(app, UpdateMilestoneCategory)
+	vbeam.RegisterProc(app, ReorderMilestoneCategories)
+	vbeam.RegisterProc(app, MergeMilestoneCategories)
+}
+
+type MilestoneCategory struct {
+	Id        int    `json:"id"`
+	FamilyId  int    `json:"familyId"`
+	Key       string `json:"key"` // what Milestone.Category holds; never changes
+	Name      string `json:"name"`
+	Color     string `json:"color"` // hex string, e.g. "#4A90D9"
+	Icon      string `json:"icon"`  // a single emoji
+	SortOrder int    `json:"sortOrder"`
+	// Aliases are other folded names that resolve to this category: the keys
+	// and names of categories merged into it.
+	Aliases   []string  `json:"aliases,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func PackMilestoneCategory(self *MilestoneCategory, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.String(&self.Key, buf)
+	vpack.String(&self.Name, buf)
+	vpack.String(&self.Color, buf)
+	vpack.String(&self.Icon, buf)
+	vpack.Int(&self.SortOrder, buf)
+	// Folded names cannot contain a newline, so the list packs as one string.
+	aliases := strings.Join(self.Aliases, "\n")
+	vpack.String(&aliases, buf)
+	if !buf.Writing {
+		self.Aliases = nil
+		if aliases != "" {
+			self.Aliases = strings.Split(aliases, "\n")
+		}
+	}
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var MilestoneCategoryBkt = vbolt.Bucket(&cfg.Info, "milestone_category", vpack.FInt, PackMilestoneCategory)
+
+// MilestoneCategoryByFamilyIndex: term = family_id, target = category_id
+var MilestoneCategoryByFamilyIndex = vbolt.Index(&cfg.Info, "milestone_category_by_family", vpack.FInt, vpack.FInt)
+
+// defaultMilestoneCategories is what every family starts with: the six values
+// the milestone form has always offered, under the keys already stored.
+var defaultMilestoneCategories = []MilestoneCategory{
+	{Key: "development", Name: "Development", Icon: "🌱"},
+	{Key: "behavior", Name: "Behavior", Icon: "😊"},
+	{Key: "health", Name: "Health", Icon: "🏥"},
+	{Key: "achievement", Name: "Achievement", Icon: "🏆"},
+	{Key: "first", Name: "First Time", Icon: "⭐"},
+	{Key: "other", Name: "Other", Icon: "📝"},
+}
+
+// fallbackMilestoneCategory is where an import files a milestone with no
+// category at all.
+const fallbackMilestoneCategory = "other"
+
+const maxMilestoneCategoryName = 40
+
+// foldCategoryName reduces a category name to the form names are compared in.
+func foldCategoryName(name string) string {
+	var b strings.Builder
+	pendingHyphen := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingHyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingHyphen = false
+			b.WriteRune(r)
+		} else {
+			pendingHyphen = true
+		}
+	}
+	folded := b.String()
+	if len(folded) > 3 && strings.HasSuffix(folded, "s") && !strings.HasSuffix(folded, "ss") {
+		folded = folded[:len(folded)-1]
+	}
+	return folded
+}
+
+// folds lists every folded name this category answers to.
+func (category MilestoneCategory) folds() []string {
+	return append([]string{foldCategoryName(category.Key), foldCategoryName(category.Name)}, category.Aliases...)
+}
+
+func (category MilestoneCategory) answersTo(folded string) bool {
+	for _, fold := range category.folds() {
+		if fold == folded {
+			return true
+		}
+	}
+	return false
+}
+
+func getMilestoneCategoryById(tx *vbolt.Tx, id int) (category MilestoneCategory) {
+	vbolt.Read(tx, MilestoneCategoryBkt, id, &category)
+	return
+}
+
+// getFamilyMilestoneCategories returns a family's categories in its order.
+func getFamilyMilestoneCategories(tx *vbolt.Tx, familyId int) []MilestoneCategory {
+	categories := readByTerm(tx, MilestoneCategoryByFamilyIndex, MilestoneCategoryBkt, familyId)
+	sort.SliceStable(categories, func(i, j int) bool {
+		if categories[i].SortOrder != categories[j].SortOrder {
+			return categories[i].SortOrder < categories[j].SortOrder
+		}
+		return categories[i].Id < categories[j].Id
+	})
+	return categories
+}
+
+func writeMilestoneCategoryTx(tx *vbolt.Tx, category *MilestoneCategory) {
+	if category.Id == 0 {
+		category.Id = vbolt.NextIntId(tx, MilestoneCategoryBkt)
+		category.CreatedAt = time.Now()
+	}
+	vbolt.Write(tx, MilestoneCategoryBkt, category.Id, category)
+	vbolt.SetTargetSingleTerm(tx, MilestoneCategoryByFamilyIndex, category.Id, category.FamilyId)
+}
+
+func deleteMilestoneCategoryTx(tx *vbolt.Tx, category MilestoneCategory) {
+	vbolt.SetTargetSingleTerm(tx, MilestoneCategoryByFamilyIndex, category.Id, -1)
+	vbolt.Delete(tx, MilestoneCategoryBkt, category.Id)
+}
+
+// deleteFamilyMilestoneCategoriesTx removes a family's categories along with
+// the family.
+func deleteFamilyMilestoneCategoriesTx(tx *vbolt.Tx, familyId int) {
+	for _, category := range readByTerm(tx, MilestoneCategoryByFamilyIndex, MilestoneCategoryBkt, familyId) {
+		deleteMilestoneCategoryTx(tx, category)
+	}
+}
+
+// ensureMilestoneCategoriesTx gives a family the default categories if it has
+// none yet, and returns its categories. Families are created in several places
+// and this is the one place that needs to know, so a family is seeded the
+// first time anything asks rather than when it is made. Needs a write
+// transaction the first time.
+func ensureMilestoneCategoriesTx(tx *vbolt.Tx, familyId int) []MilestoneCategory {
+	if categories := getFamilyMilestoneCategories(tx, familyId); len(categories) > 0 {
+		return categories
+	}
+	for i, category := range defaultMilestoneCategories {
+		category.FamilyId = familyId
+		category.SortOrder = i
+		writeMilestoneCategoryTx(tx, &category)
+	}
+	return getFamilyMilestoneCategories(tx, familyId)
+}
+
+// findMilestoneCategory looks a name, key or alias up among the categories.
+func findMilestoneCategory(categories []MilestoneCategory, name string) (MilestoneCategory, bool) {
+	folded := foldCategoryName(name)
+	if folded == "" {
+		return MilestoneCategory{}, false
+	}
+	// An exact key wins over a folded match, so two categories that fold alike
+	// — possible only for rows written before folding — stay distinguishable.
+	for _, category := range categories {
+		if category.Key == name {
+			return category, true
+		}
+	}
+	for _, category := range categories {
+		if category.answersTo(folded) {
+			return category, true
+		}
+	}
+	return MilestoneCategory{}, false
+}
+
+// resolveMilestoneCategoryTx turns what a request or file calls a category into
+// the key a milestone stores.
+func resolveMilestoneCategoryTx(tx *vbolt.Tx, familyId int, name string) (string, error) {
+	category, found := findMilestoneCategory(ensureMilestoneCategoriesTx(tx, familyId), name)
+	if !found {
+		return "", errors.New("Unknown milestone category")
+	}
+	return category.Key, nil
+}
+
+// importMilestoneCategoryTx is resolveMilestoneCategoryTx for a file, where an
+// unknown category is created rather than refused: the file is the family's
+// own record of what it called things.
+func importMilestoneCategoryTx(tx *vbolt.Tx, familyId int, name string) string {
+	if strings.TrimSpace(name) == "" {
+		name = fallbackMilestoneCategory
+	}
+	categories := ensureMilestoneCategoriesTx(tx, familyId)
+	if category, found := findMilestoneCategory(categories, name); found {
+		return category.Key
+	}
+	category := MilestoneCategory{
+		FamilyId:  familyId,
+		Key:       foldCategoryName(name),
+		Name:      trimField(name, maxMilestoneCategoryName),
+		SortOrder: nextMilestoneCategorySortOrder(categories),
+	}
+	writeMilestoneCategoryTx(tx, &category)
+	return category.Key
+}
+
+func nextMilestoneCategorySortOrder(categories []MilestoneCategory) int {
+	next := 0
+	for _, category := range categories {
+		if category.SortOrder >= next {
+			next = category.SortOrder + 1
+		}
+	}
+	return next
+}
+
+// milestoneCategoryNameTaken reports whether another category in the list
+// already answers to this name.
+func milestoneCategoryNameTaken(categories []MilestoneCategory, name string, excludeId int) bool {
+	folded := foldCategoryName(name)
+	for _, category := range categories {
+		if category.Id != excludeId && category.answersTo(folded) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMilestoneCategoryName is the check a milestone request can make on
+// its own; whether the family has such a category is for the transaction.
+func validateMilestoneCategoryName(name string) error {
+	if foldCategoryName(name) == "" {
+		return errors.New("Category is required")
+	}
+	if len(name) > maxMilestoneCategoryName {
+		return errors.New("Category must be 40 characters or fewer")
+	}
+	return nil
+}
+
+func validateMilestoneCategoryFields(name string, icon string) error {
+	name = strings.TrimSpace(name)
+	if foldCategoryName(name) == "" {
+		return errors.New("Category name is required")
+	}
+	if len(name) > maxMilestoneCategoryName {
+		return errors.New("Category name must be 40 characters or fewer")
+	}
+	// An emoji with modifiers runs to a few code points; anything longer is
+	// not an icon.
+	if len(icon) > 32 {
+		return errors.New("Category icon must be a single emoji")
+	}
+	return nil
+}
+
+// mergeMilestoneCategoriesTx moves every milestone filed under source to
+// target and deletes source, keeping its names as aliases of target. Returns
+// how many milestones moved.
+func mergeMilestoneCategoriesTx(tx *vbolt.Tx, source MilestoneCategory, target MilestoneCategory) (moved int) {
+	for _, milestone := range getFamilyMilestones(tx, source.FamilyId) {
+		if milestone.Category != source.Key {
+			continue
+		}
+		milestone.Category = target.Key
+		vbolt.Write(tx, MilestoneBkt, milestone.Id, &milestone)
+		UpdateMilestoneSearchIndex(tx, milestone)
+		moved++
+	}
+
+	for _, fold := range source.folds() {
+		if fold != "" && !target.answersTo(fold) {
+			target.Aliases = append(target.Aliases, fold)
+		}
+	}
+	writeMilestoneCategoryTx(tx, &target)
+	deleteMilestoneCategoryTx(tx, source)
+	return
+}
+
+// BackfillMilestoneCategories gives every family its categories and folds the
+// free text already stored on milestones into them. A value that folds to an
+// existing category is rewritten to its key; one that matches nothing becomes
+// a new category, named as it was first written. Safe to re-run: a second pass
+// finds every milestone already holding a key.
+func BackfillMilestoneCategories(tx *vbolt.Tx) (rewritten int, created int) {
+	vbolt.IterateAll(tx, FamiliesBkt, func(familyId int, family Family) bool {
+		ensureMilestoneCategoriesTx(tx, familyId)
+		return true
+	})
+
+	var milestones []Milestone
+	vbolt.IterateAll(tx, MilestoneBkt, func(id int, milestone Milestone) bool {
+		milestones = append(milestones, milestone)
+		return true
+	})
+	for _, milestone := range milestones {
+		if milestone.FamilyId == 0 {
+			continue
+		}
+		before := len(getFamilyMilestoneCategories(tx, milestone.FamilyId))
+		key := importMilestoneCategoryTx(tx, milestone.FamilyId, milestone.Category)
+		if len(getFamilyMilestoneCategories(tx, milestone.FamilyId)) > before {
+			created++
+		}
+		if key != milestone.Category {
+			milestone.Category = key
+			vbolt.Write(tx, MilestoneBkt, milestone.Id, &milestone)
+			UpdateMilestoneSearchIndex(tx, milestone)
+			rewritten++
+		}
+	}
+	return
+}
+
+// Request/Response types
+
+type ListMilestoneCategoriesRequest struct{}
+
+type ListMilestoneCategoriesResponse struct {
+	// Categories are those of every family the user can see milestones in,
+	// each family's in its own order. A milestone's category is looked up by
+	// its FamilyId and Category together.
+	Categories []MilestoneCategory `json:"categories"`
+}
+
+type CreateMilestoneCategoryRequest struct {
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	Icon     string `json:"icon"`
+	FamilyId int    `json:"familyId,omitempty"`
+}
+
+type UpdateMilestoneCategoryRequest struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	Icon  string `json:"icon"`
+}
+
+type MilestoneCategoryResponse struct {
+	Category MilestoneCategory `json:"category"`
+}
+
+type ReorderMilestoneCategoriesRequest struct {
+	FamilyId int `json:"familyId,omitempty"`
+	// CategoryIds is every one of the family's categories, in the new order.
+	CategoryIds []int `json:"categoryIds"`
+}
+
+type MergeMilestoneCategoriesRequest struct {
+	SourceId int `json:"sourceId"` // merged away
+	TargetId int `json:"targetId"` // kept
+}
+
+type MergeMilestoneCategoriesResponse struct {
+	Category        MilestoneCategory `json:"category"`
+	MovedMilestones int               `json:"movedMilestones"`
+}
+
+// vbeam procedures
+
+func ListMilestoneCategories(ctx *vbeam.Context, req ListMilestoneCategoriesRequest) (resp ListMilestoneCategoriesResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	// The user's own families are seeded if they have nothing yet; families
+	// shared in are read as they are; their members seed their own.
+	own := familiesVisibleTo(ctx.Tx, user)
+	for _, familyId := range own {
+		if len(getFamilyMilestoneCategories(ctx.Tx, familyId)) == 0 {
+			vbeam.UseWriteTx(ctx)
+			ensureMilestoneCategoriesTx(ctx.Tx, familyId)
+		}
+	}
+
+	resp.Categories = []MilestoneCategory{}
+	for _, familyId := range append(own, sharedInFamilies(ctx.Tx, user, ScopeMilestones)...) {
+		resp.Categories = append(resp.Categories, getFamilyMilestoneCategories(ctx.Tx, familyId)...)
+	}
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+func CreateMilestoneCategory(ctx *vbeam.Context, req CreateMilestoneCategoryRequest) (resp MilestoneCategoryResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateMilestoneCategoryFields(req.Name, req.Icon); err != nil {
+		return
+	}
+
+	familyId, err := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	categories := ensureMilestoneCategoriesTx(ctx.Tx, familyId)
+	if milestoneCategoryNameTaken(categories, req.Name, 0) {
+		err = errors.New("A category with this name already exists")
+		return
+	}
+
+	category := MilestoneCategory{
+		FamilyId:  familyId,
+		Key:       foldCategoryName(req.Name),
+		Name:      strings.TrimSpace(req.Name),
+		Color:     req.Color,
+		Icon:      strings.TrimSpace(req.Icon),
+		SortOrder: nextMilestoneCategorySortOrder(categories),
+	}
+	writeMilestoneCategoryTx(ctx.Tx, &category)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Category = category
+	return
+}
+
+// UpdateMilestoneCategory renames or restyles a category. The key stays, so
+// none of the milestones filed under it change.
+func UpdateMilestoneCategory(ctx *vbeam.Context, req UpdateMilestoneCategoryRequest) (resp MilestoneCategoryResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateMilestoneCategoryFields(req.Name, req.Icon); err != nil {
+		return
+	}
+
+	category := getMilestoneCategoryById(ctx.Tx, req.Id)
+	if category.Id == 0 || !CanAccessFamily(ctx.Tx, user, category.FamilyId, AccessContribute) {
+		err = errors.New("Category not found")
+		return
+	}
+	if milestoneCategoryNameTaken(getFamilyMilestoneCategories(ctx.Tx, category.FamilyId), req.Name, category.Id) {
+		err = errors.New("A category with this name already exists")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	category.Name = strings.TrimSpace(req.Name)
+	category.Color = req.Color
+	category.Icon = strings.TrimSpace(req.Icon)
+	writeMilestoneCategoryTx(ctx.Tx, &category)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Category = category
+	return
+}
+
+func ReorderMilestoneCategories(ctx *vbeam.Context, req ReorderMilestoneCategoriesRequest) (resp ListMilestoneCategoriesResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	// The order must name every category once; a partial list would leave
+	// the rest with positions that no longer mean anything.
+	categories := getFamilyMilestoneCategories(ctx.Tx, familyId)
+	byId := make(map[int]MilestoneCategory, len(categories))
+	for _, category := range categories {
+		byId[category.Id] = category
+	}
+	if len(req.CategoryIds) != len(categories) {
+		err = errors.New("The new order must list every category once")
+		return
+	}
+	for _, id := range req.CategoryIds {
+		if _, ok := byId[id]; !ok {
+			err = errors.New("The new order must list every category once")
+			return
+		}
+		delete(byId, id)
+	}
+
+	vbeam.UseWriteTx(ctx)
+	for position, id := range req.CategoryIds {
+		category := getMilestoneCategoryById(ctx.Tx, id)
+		category.SortOrder = position
+		writeMilestoneCategoryTx(ctx.Tx, &category)
+	}
+	resp.Categories = getFamilyMilestoneCategories(ctx.Tx, familyId)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+// MergeMilestoneCategories folds one category into another. It is also how a
+// category is deleted: its milestones have to be filed somewhere, and the
+// caller says where.
+func MergeMilestoneCategories(ctx *vbeam.Context, req MergeMilestoneCategoriesRequest) (resp MergeMilestoneCategoriesResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if req.SourceId == req.TargetId {
+		err = errors.New("A category cannot be merged into itself")
+		return
+	}
+	source := getMilestoneCategoryById(ctx.Tx, req.SourceId)
+	target := getMilestoneCategoryById(ctx.Tx, req.TargetId)
+	if source.Id == 0 || target.Id == 0 || source.FamilyId != target.FamilyId {
+		err = errors.New("Category not found")
+		return
+	}
+	if !CanAccessFamily(ctx.Tx, user, source.FamilyId, AccessAdmin) {
+		err = errors.New("Access denied")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	resp.MovedMilestones = mergeMilestoneCategoriesTx(ctx.Tx, source, target)
+	resp.Category = getMilestoneCategoryById(ctx.Tx, target.Id)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
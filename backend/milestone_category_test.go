@@ -0,0 +1,320 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func TestFoldCategoryName(t *testing.T) {
+	cases := map[string]string{
+		"first":          "first",
+		"First":          "first",
+		"firsts":         "first",
+		" Firsts ":       "first",
+		"Health":         "health",
+		"Fine Motor":     "fine-motor",
+		"fine-motor!!":   "fine-motor",
+		"Class":          "class",
+		"bus":            "bus",
+		"":               "",
+		"!!":             "",
+		"Sleep & Naps":   "sleep-nap",
+		"First Time":     "first-time",
+		"Achievements  ": "achievement",
+	}
+	for in, want := range cases {
+		if got := foldCategoryName(in); got != want {
+			t.Errorf("foldCategoryName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func writeRawMilestone(tx *vbolt.Tx, familyId int, personId int, category string) Milestone {
+	milestone := Milestone{
+		Id:            vbolt.NextIntId(tx, MilestoneBkt),
+		PersonId:      personId,
+		FamilyId:      familyId,
+		Description:   "Something " + category,
+		Category:      category,
+		MilestoneDate: calendarDay(2021, time.May, 1),
+		CreatedAt:     time.Now(),
+	}
+	vbolt.Write(tx, MilestoneBkt, milestone.Id, &milestone)
+	updateMilestoneIndices(tx, milestone)
+	return milestone
+}
+
+func TestBackfillFoldsFreeTextCategories(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	var ids []int
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		for _, category := range []string{"first", "First", "firsts", "Firsts", "Swimming", "swimming", ""} {
+			ids = append(ids, writeRawMilestone(tx, fx.parent.FamilyId, fx.child.Id, category).Id)
+		}
+		BackfillMilestoneCategories(tx)
+
+		// A second run finds nothing left to do.
+		if rewritten, created := BackfillMilestoneCategories(tx); rewritten != 0 || created != 0 {
+			t.Errorf("second run rewrote %d and created %d", rewritten, created)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		want := []string{"first", "first", "first", "first", "swimming", "swimming", "other"}
+		for i, id := range ids {
+			var milestone Milestone
+			vbolt.Read(tx, MilestoneBkt, id, &milestone)
+			if milestone.Category != want[i] {
+				t.Errorf("milestone %d category = %q, want %q", i, milestone.Category, want[i])
+			}
+		}
+
+		categories := getFamilyMilestoneCategories(tx, fx.parent.FamilyId)
+		if len(categories) != len(defaultMilestoneCategories)+1 {
+			t.Fatalf("family has %d categories", len(categories))
+		}
+		swimming := categories[len(categories)-1]
+		if swimming.Key != "swimming" || swimming.Name != "Swimming" {
+			t.Errorf("new category = %+v", swimming)
+		}
+
+		// The outsider's family has no milestones but still gets the defaults.
+		if got := len(getFamilyMilestoneCategories(tx, fx.outsider.FamilyId)); got != len(defaultMilestoneCategories) {
+			t.Errorf("outsider family has %d categories", got)
+		}
+
+		var found []int
+		vbolt.ReadTermTargets(tx, MilestoneSearchIndex, "cat:swimming", &found, vbolt.Window{})
+		if len(found) != 2 {
+			t.Errorf("search found %d swimming milestones", len(found))
+		}
+	})
+}
+
+func TestMilestoneCategoryRenameKeepsKey(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	var first MilestoneCategory
+	fx.call(t, func(ctx *vbeam.Context) {
+		resp, err := ListMilestoneCategories(ctx, ListMilestoneCategoriesRequest{})
+		if err != nil {
+			t.Fatalf("ListMilestoneCategories() error = %v", err)
+		}
+		if len(resp.Categories) != len(defaultMilestoneCategories) {
+			t.Fatalf("listed %d categories", len(resp.Categories))
+		}
+		first, _ = findMilestoneCategory(resp.Categories, "first")
+	})
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		resp, err := UpdateMilestoneCategory(ctx, UpdateMilestoneCategoryRequest{Id: first.Id, Name: "Firsts!", Color: "#FFAA00", Icon: "🎈"})
+		if err != nil {
+			t.Fatalf("UpdateMilestoneCategory() error = %v", err)
+		}
+		if resp.Category.Key != "first" || resp.Category.Name != "Firsts!" || resp.Category.Icon != "🎈" {
+			t.Errorf("renamed = %+v", resp.Category)
+		}
+	})
+	fx.call(t, func(ctx *vbeam.Context) {
+		if _, err := UpdateMilestoneCategory(ctx, UpdateMilestoneCategoryRequest{Id: first.Id, Name: "health"}); err == nil {
+			t.Error("a rename onto another category's name was accepted")
+		}
+	})
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		// The old key and the new name both file a milestone under it.
+		for _, name := range []string{"first", "FIRSTS"} {
+			milestone, err := AddMilestoneTx(tx, AddMilestoneRequest{
+				PersonId: fx.child.Id, Description: "Said " + name, Category: name, InputType: "today",
+			}, fx.parent.FamilyId)
+			if err != nil {
+				t.Fatalf("AddMilestoneTx(%q) error = %v", name, err)
+			}
+			if milestone.Category != "first" {
+				t.Errorf("AddMilestoneTx(%q) filed under %q", name, milestone.Category)
+			}
+		}
+
+		if _, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.child.Id, Description: "Something", Category: "Astronomy", InputType: "today",
+		}, fx.parent.FamilyId); err == nil {
+			t.Error("an unknown category was accepted")
+		}
+	})
+}
+
+func TestMergeMilestoneCategoriesRepointsMilestones(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	var milestoneId int
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		milestone, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.child.Id, Description: "Rolled over", Category: "achievement", InputType: "today",
+		}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		milestoneId = milestone.Id
+		vbolt.TxCommit(tx)
+	})
+
+	var source, target MilestoneCategory
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		categories := getFamilyMilestoneCategories(tx, fx.parent.FamilyId)
+		source, _ = findMilestoneCategory(categories, "achievement")
+		target, _ = findMilestoneCategory(categories, "development")
+	})
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		resp, err := MergeMilestoneCategories(ctx, MergeMilestoneCategoriesRequest{SourceId: source.Id, TargetId: target.Id})
+		if err != nil {
+			t.Fatalf("MergeMilestoneCategories() error = %v", err)
+		}
+		if resp.MovedMilestones != 1 {
+			t.Errorf("moved %d milestones", resp.MovedMilestones)
+		}
+	})
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var milestone Milestone
+		vbolt.Read(tx, MilestoneBkt, milestoneId, &milestone)
+		if milestone.Category != "development" {
+			t.Errorf("milestone category = %q", milestone.Category)
+		}
+		if len(getFamilyMilestoneCategories(tx, fx.parent.FamilyId)) != len(defaultMilestoneCategories)-1 {
+			t.Error("the source category was not deleted")
+		}
+
+		// Old clients still sending the merged key land in the target.
+		added, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.child.Id, Description: "Won a race", Category: "achievement", InputType: "today",
+		}, fx.parent.FamilyId)
+		if err != nil || added.Category != "development" {
+			t.Errorf("merged key filed under %q, %v", added.Category, err)
+		}
+	})
+
+	// Merging needs an admin of the family.
+	var health, other MilestoneCategory
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		categories := getFamilyMilestoneCategories(tx, fx.parent.FamilyId)
+		health, _ = findMilestoneCategory(categories, "health")
+		other, _ = findMilestoneCategory(categories, "other")
+	})
+	callAsUser(t, fx.db, fx.outsider, func(ctx *vbeam.Context) {
+		if _, err := MergeMilestoneCategories(ctx, MergeMilestoneCategoriesRequest{SourceId: health.Id, TargetId: other.Id}); err == nil {
+			t.Error("an outsider merged the family's categories")
+		}
+	})
+}
+
+func TestReorderMilestoneCategories(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	var ids []int
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		for _, category := range ensureMilestoneCategoriesTx(tx, fx.parent.FamilyId) {
+			ids = append([]int{category.Id}, ids...)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		if _, err := ReorderMilestoneCategories(ctx, ReorderMilestoneCategoriesRequest{CategoryIds: ids[1:]}); err == nil {
+			t.Error("a partial order was accepted")
+		}
+		resp, err := ReorderMilestoneCategories(ctx, ReorderMilestoneCategoriesRequest{CategoryIds: ids})
+		if err != nil {
+			t.Fatalf("ReorderMilestoneCategories() error = %v", err)
+		}
+		for i, category := range resp.Categories {
+			if category.Id != ids[i] {
+				t.Fatalf("order = %+v, want ids %v", resp.Categories, ids)
+			}
+		}
+	})
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		resp, err := CreateMilestoneCategory(ctx, CreateMilestoneCategoryRequest{Name: "Fine Motor", Icon: "✋"})
+		if err != nil {
+			t.Fatalf("CreateMilestoneCategory() error = %v", err)
+		}
+		if resp.Category.Key != "fine-motor" || resp.Category.SortOrder != len(ids) {
+			t.Errorf("created = %+v", resp.Category)
+		}
+	})
+	fx.call(t, func(ctx *vbeam.Context) {
+		if _, err := CreateMilestoneCategory(ctx, CreateMilestoneCategoryRequest{Name: "fine motors"}); err == nil {
+			t.Error("a second category folding to the same name was accepted")
+		}
+	})
+}
+
+func TestMilestoneCategoriesRoundTripThroughExport(t *testing.T) {
+	fx := setupProvenanceFixture(t)
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		categories := ensureMilestoneCategoriesTx(tx, fx.parent.FamilyId)
+		swimming := MilestoneCategory{FamilyId: fx.parent.FamilyId, Key: "swimming", Name: "Swimming", Icon: "🏊", SortOrder: len(categories)}
+		writeMilestoneCategoryTx(tx, &swimming)
+		pool := MilestoneCategory{FamilyId: fx.parent.FamilyId, Key: "pool", Name: "Pool"}
+		writeMilestoneCategoryTx(tx, &pool)
+		mergeMilestoneCategoriesTx(tx, pool, swimming)
+
+		if _, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.child.Id, Description: "First lap", Category: "swimming", InputType: "today",
+		}, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	var exported ExportDataStructure
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		if exported, err = buildExportData(tx, fx.parent.FamilyId); err != nil {
+			t.Fatalf("buildExportData() error = %v", err)
+		}
+	})
+	if len(exported.MilestoneCategories) != len(defaultMilestoneCategories)+1 {
+		t.Fatalf("exported %d categories", len(exported.MilestoneCategories))
+	}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		person, err := AddPersonTx(tx, AddPersonRequest{Name: "Kid", PersonType: 1, Gender: 0, Birthdate: "2020-01-01"}, fx.outsider.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		if imported := importMilestoneCategories(tx, exported.MilestoneCategories, fx.outsider.FamilyId); imported != 1 {
+			t.Errorf("imported %d categories, want only the one the family lacked", imported)
+		}
+
+		milestones := exported.Milestones
+		// An older file, or one written by hand, names its category freely.
+		milestones = append(milestones, ExportMilestone{
+			PersonId: fx.child.Id, Description: "Jumped in", Category: "Pool", MilestoneDate: calendarDay(2022, time.June, 3),
+		}, ExportMilestone{
+			PersonId: fx.child.Id, Description: "Ate sushi", Category: "Foods", MilestoneDate: calendarDay(2022, time.June, 4),
+		})
+		imported, _, errs := importMilestones(tx, milestones, map[int]int{fx.child.Id: person.Id}, fx.outsider.FamilyId, nil)
+		if imported != 3 || len(errs) != 0 {
+			t.Fatalf("imported %d, errors %v", imported, errs)
+		}
+
+		got := map[string]string{}
+		for _, milestone := range GetPersonMilestonesTx(tx, person.Id) {
+			got[milestone.Description] = milestone.Category
+		}
+		want := map[string]string{"First lap": "swimming", "Jumped in": "swimming", "Ate sushi": "food"}
+		for description, category := range want {
+			if got[description] != category {
+				t.Errorf("%q filed under %q, want %q", description, got[description], category)
+			}
+		}
+	})
+}
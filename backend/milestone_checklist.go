@@ -0,0 +1,911 @@
+package backend
+
+// Developmental milestone checklists.
+//
+// A Milestone is whatever a parent thought worth writing down, which is the
+// right record for "first trip to the beach" and the wrong one for "is she
+// doing what most 12-month-olds do?". That second question has a standard
+// answer — the CDC's list of what most children do by each age — and a parent
+// wants it as a checklist: the items for their child's age, ticked off as they
+// happen, and the ones still open.
+//
+// The built-in items ship inside the binary, like the growth references, and
+// are identified by a stable key. A family can hide built-in items it does not
+// want and add its own; a family item's key is "family-" and its id. Ticking an
+// item off creates an ordinary Milestone — dated, searchable, on the timeline —
+// and a ChecklistAchievement joining the item's key to it. The milestone is the
+// record; the achievement only says which checklist item it answers, so
+// deleting the milestone reopens the item and editing its date moves the
+// achievement with it.
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"family/cfg"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+func RegisterChecklistMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, GetPersonChecklist)
+	vbeam.RegisterProc(app, MarkChecklistItem)
+	vbeam.RegisterProc(app, UnmarkChecklistItem)
+	vbeam.RegisterProc(app, GetChecklistReport)
+	vbeam.RegisterProc(app, GetChecklistItems)
+	vbeam.RegisterProc(app, AddChecklistItem)
+	vbeam.RegisterProc(app, UpdateChecklistItem)
+	vbeam.RegisterProc(app, DeleteChecklistItem)
+	vbeam.RegisterProc(app, SetChecklistItemHidden)
+}
+
+//go:embed checklistref/cdc.csv
+var checklistReferenceCSV []byte
+
+// Checklist domains, as the CDC groups its milestones.
+const (
+	ChecklistSocial    = "social"
+	ChecklistLanguage  = "language"
+	ChecklistCognitive = "cognitive"
+	ChecklistMovement  = "movement"
+)
+
+var checklistDomains = map[string]bool{
+	ChecklistSocial:    true,
+	ChecklistLanguage:  true,
+	ChecklistCognitive: true,
+	ChecklistMovement:  true,
+}
+
+// checklistDomainRank orders the domains the way the CDC lists them within an
+// age, which is the order the list is shown in.
+var checklistDomainRank = map[string]int{
+	ChecklistSocial:    0,
+	ChecklistLanguage:  1,
+	ChecklistCognitive: 2,
+	ChecklistMovement:  3,
+}
+
+// maxChecklistReportMonths is how old a child can be and still appear in the
+// open-items report. The built-in list stops at five years; a year past that
+// an item still open is history rather than something to watch for, and a
+// ten-year-old reported as not yet hopping on one foot helps nobody.
+const maxChecklistReportMonths = 72
+
+// familyChecklistKeyPrefix marks a family's own item in a key.
+const familyChecklistKeyPrefix = "family-"
+
+// checklistMilestoneCategory is the category a ticked-off item's milestone is
+// filed under.
+const checklistMilestoneCategory = "development"
+
+// ChecklistItem is one item on the list, built-in or the family's own, as the
+// client sees it.
+type ChecklistItem struct {
+	Key       string `json:"key"`
+	AgeMonths int    `json:"ageMonths"`
+	Domain    string `json:"domain"`
+	Text      string `json:"text"`
+	// Custom items are the family's own and carry their FamilyChecklistItem
+	// id. Hidden is only ever set on a built-in item.
+	Custom bool `json:"custom"`
+	Id     int  `json:"id,omitempty"`
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// builtinChecklist is the embedded list, in file order: by age, then domain.
+// A malformed table is a build mistake, so it panics rather than quietly
+// serving an empty checklist.
+var builtinChecklist = mustParseChecklist(checklistReferenceCSV)
+
+func mustParseChecklist(data []byte) []ChecklistItem {
+	items, err := parseChecklist(data)
+	if err != nil {
+		panic("milestone checklist: " + err.Error())
+	}
+	return items
+}
+
+func parseChecklist(data []byte) ([]ChecklistItem, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 4
+
+	var items []ChecklistItem
+	seen := make(map[string]bool)
+	sawHeader := false
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !sawHeader {
+			sawHeader = true
+			continue
+		}
+
+		age, err := strconv.Atoi(record[1])
+		if err != nil || age <= 0 {
+			return nil, fmt.Errorf("bad age in row %q", record[0])
+		}
+		if !checklistDomains[record[2]] {
+			return nil, fmt.Errorf("unknown domain in row %q", record[0])
+		}
+		if seen[record[0]] || strings.HasPrefix(record[0], familyChecklistKeyPrefix) {
+			return nil, fmt.Errorf("duplicate or reserved key %q", record[0])
+		}
+		seen[record[0]] = true
+		items = append(items, ChecklistItem{Key: record[0], AgeMonths: age, Domain: record[2], Text: record[3]})
+	}
+	if len(items) == 0 {
+		return nil, errors.New("no items")
+	}
+	return items, nil
+}
+
+func builtinChecklistItem(key string) (ChecklistItem, bool) {
+	for _, item := range builtinChecklist {
+		if item.Key == key {
+			return item, true
+		}
+	}
+	return ChecklistItem{}, false
+}
+
+func familyChecklistKey(id int) string {
+	return familyChecklistKeyPrefix + strconv.Itoa(id)
+}
+
+// checklistAgeLabel is how a section heading reads.
+func checklistAgeLabel(months int) string {
+	if months >= 24 && months%12 == 0 {
+		return fmt.Sprintf("By %d years", months/12)
+	}
+	return fmt.Sprintf("By %d months", months)
+}
+
+// Database types
+
+// FamilyChecklistItem is an item a family added to its own checklist.
+type FamilyChecklistItem struct {
+	Id        int       `json:"id"`
+	FamilyId  int       `json:"familyId"`
+	AgeMonths int       `json:"ageMonths"`
+	Domain    string    `json:"domain"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ChecklistHiddenItem records a built-in item a family has taken off its list.
+type ChecklistHiddenItem struct {
+	Id        int
+	FamilyId  int
+	ItemKey   string
+	CreatedAt time.Time
+}
+
+// ChecklistAchievement joins a checklist item to the milestone that ticked it
+// off for one person.
+type ChecklistAchievement struct {
+	Id          int       `json:"id"`
+	PersonId    int       `json:"personId"`
+	FamilyId    int       `json:"familyId"`
+	ItemKey     string    `json:"itemKey"`
+	MilestoneId int       `json:"milestoneId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func PackFamilyChecklistItem(self *FamilyChecklistItem, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Int(&self.AgeMonths, buf)
+	vpack.String(&self.Domain, buf)
+	vpack.String(&self.Text, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+func PackChecklistHiddenItem(self *ChecklistHiddenItem, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.String(&self.ItemKey, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+func PackChecklistAchievement(self *ChecklistAchievement, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.String(&self.ItemKey, buf)
+	vpack.Int(&self.MilestoneId, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var FamilyChecklistItemBkt = vbolt.Bucket(&cfg.Info, "checklist_items", vpack.FInt, PackFamilyChecklistItem)
+var ChecklistHiddenItemBkt = vbolt.Bucket(&cfg.Info, "checklist_hidden_items", vpack.FInt, PackChecklistHiddenItem)
+var ChecklistAchievementBkt = vbolt.Bucket(&cfg.Info, "checklist_achievements", vpack.FInt, PackChecklistAchievement)
+
+// ChecklistItemByFamilyIndex: term = family_id, target = checklist_item_id
+var ChecklistItemByFamilyIndex = vbolt.Index(&cfg.Info, "checklist_item_by_family", vpack.FInt, vpack.FInt)
+
+// ChecklistHiddenByFamilyIndex: term = family_id, target = checklist_hidden_item_id
+var ChecklistHiddenByFamilyIndex = vbolt.Index(&cfg.Info, "checklist_hidden_by_family", vpack.FInt, vpack.FInt)
+
+// ChecklistAchievementByPersonIndex: term = person_id, target = achievement_id
+var ChecklistAchievementByPersonIndex = vbolt.Index(&cfg.Info, "checklist_achievement_by_person", vpack.FInt, vpack.FInt)
+
+// ChecklistAchievementByMilestoneIndex: term = milestone_id, target = achievement_id.
+// Deleting a milestone uses it to reopen the item the milestone ticked off.
+var ChecklistAchievementByMilestoneIndex = vbolt.Index(&cfg.Info, "checklist_achievement_by_milestone", vpack.FInt, vpack.FInt)
+
+// ChecklistAchievementByFamilyIndex: term = family_id, target = achievement_id
+var ChecklistAchievementByFamilyIndex = vbolt.Index(&cfg.Info, "checklist_achievement_by_family", vpack.FInt, vpack.FInt)
+
+// Request/Response types
+
+type ChecklistEntry struct {
+	ChecklistItem
+	Achieved     bool      `json:"achieved"`
+	MilestoneId  int       `json:"milestoneId,omitempty"`
+	AchievedDate time.Time `json:"achievedDate,omitempty"`
+}
+
+// ChecklistSection is one age's items. Due is whether the child has reached
+// that age; the first section that is not yet due is included so a parent can
+// see what is coming.
+type ChecklistSection struct {
+	AgeMonths int              `json:"ageMonths"`
+	Label     string           `json:"label"`
+	Due       bool             `json:"due"`
+	Items     []ChecklistEntry `json:"items"`
+}
+
+type GetPersonChecklistRequest struct {
+	PersonId int `json:"personId"`
+}
+
+type PersonChecklist struct {
+	PersonId  int                `json:"personId"`
+	AgeMonths float64            `json:"ageMonths"`
+	Sections  []ChecklistSection `json:"sections"`
+	// Open lists the items in due sections that are not yet ticked off.
+	Open []ChecklistEntry `json:"open"`
+}
+
+type GetPersonChecklistResponse struct {
+	Checklist PersonChecklist `json:"checklist"`
+}
+
+type MarkChecklistItemRequest struct {
+	PersonId      int     `json:"personId"`
+	ItemKey       string  `json:"itemKey"`
+	InputType     string  `json:"inputType"`               // "today", "date" or "age"
+	MilestoneDate *string `json:"milestoneDate,omitempty"` // YYYY-MM-DD format (if inputType is "date")
+	AgeYears      *int    `json:"ageYears,omitempty"`      // Age in years (if inputType is "age")
+	AgeMonths     *int    `json:"ageMonths,omitempty"`     // Additional months (if inputType is "age")
+	DatePrecision string  `json:"datePrecision,omitempty"` // As on AddMilestoneRequest
+}
+
+type MarkChecklistItemResponse struct {
+	Entry     ChecklistEntry `json:"entry"`
+	Milestone Milestone      `json:"milestone"`
+}
+
+type UnmarkChecklistItemRequest struct {
+	PersonId int    `json:"personId"`
+	ItemKey  string `json:"itemKey"`
+}
+
+type UnmarkChecklistItemResponse struct {
+	Success bool `json:"success"`
+}
+
+type GetChecklistReportRequest struct {
+	FamilyId int `json:"familyId,omitempty"`
+}
+
+type ChecklistReportRow struct {
+	Person    Person           `json:"person"`
+	AgeMonths float64          `json:"ageMonths"`
+	Open      []ChecklistEntry `json:"open"`
+}
+
+type GetChecklistReportResponse struct {
+	Rows []ChecklistReportRow `json:"rows"`
+}
+
+type GetChecklistItemsRequest struct {
+	FamilyId int `json:"familyId,omitempty"`
+}
+
+type GetChecklistItemsResponse struct {
+	Items []ChecklistItem `json:"items"`
+}
+
+type AddChecklistItemRequest struct {
+	FamilyId  int    `json:"familyId,omitempty"`
+	AgeMonths int    `json:"ageMonths"`
+	Domain    string `json:"domain"`
+	Text      string `json:"text"`
+}
+
+type UpdateChecklistItemRequest struct {
+	Id        int    `json:"id"`
+	AgeMonths int    `json:"ageMonths"`
+	Domain    string `json:"domain"`
+	Text      string `json:"text"`
+}
+
+type ChecklistItemResponse struct {
+	Item ChecklistItem `json:"item"`
+}
+
+type DeleteChecklistItemRequest struct {
+	Id int `json:"id"`
+}
+
+type DeleteChecklistItemResponse struct {
+	Success bool `json:"success"`
+}
+
+type SetChecklistItemHiddenRequest struct {
+	FamilyId int    `json:"familyId,omitempty"`
+	ItemKey  string `json:"itemKey"`
+	Hidden   bool   `json:"hidden"`
+}
+
+type SetChecklistItemHiddenResponse struct {
+	Items []ChecklistItem `json:"items"`
+}
+
+// Database helper functions
+
+func GetFamilyChecklistItemById(tx *vbolt.Tx, id int) (item FamilyChecklistItem) {
+	vbolt.Read(tx, FamilyChecklistItemBkt, id, &item)
+	return
+}
+
+func getFamilyChecklistItems(tx *vbolt.Tx, familyId int) []FamilyChecklistItem {
+	return readByTerm(tx, ChecklistItemByFamilyIndex, FamilyChecklistItemBkt, familyId)
+}
+
+func getChecklistHiddenItems(tx *vbolt.Tx, familyId int) []ChecklistHiddenItem {
+	return readByTerm(tx, ChecklistHiddenByFamilyIndex, ChecklistHiddenItemBkt, familyId)
+}
+
+func getPersonChecklistAchievements(tx *vbolt.Tx, personId int) []ChecklistAchievement {
+	return readByTerm(tx, ChecklistAchievementByPersonIndex, ChecklistAchievementBkt, personId)
+}
+
+func (item FamilyChecklistItem) asChecklistItem() ChecklistItem {
+	return ChecklistItem{
+		Key:       familyChecklistKey(item.Id),
+		AgeMonths: item.AgeMonths,
+		Domain:    item.Domain,
+		Text:      item.Text,
+		Custom:    true,
+		Id:        item.Id,
+	}
+}
+
+// familyChecklistTx is the whole list as a family has edited it: the built-in
+// items, marked hidden where the family hid them, and the family's own, sorted
+// by age and then domain. Within a domain the built-in items keep the table's
+// order and the family's own follow them.
+func familyChecklistTx(tx *vbolt.Tx, familyId int) []ChecklistItem {
+	hidden := make(map[string]bool)
+	for _, row := range getChecklistHiddenItems(tx, familyId) {
+		hidden[row.ItemKey] = true
+	}
+
+	items := make([]ChecklistItem, 0, len(builtinChecklist))
+	for _, item := range builtinChecklist {
+		item.Hidden = hidden[item.Key]
+		items = append(items, item)
+	}
+	for _, custom := range getFamilyChecklistItems(tx, familyId) {
+		items = append(items, custom.asChecklistItem())
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].AgeMonths != items[j].AgeMonths {
+			return items[i].AgeMonths < items[j].AgeMonths
+		}
+		return checklistDomainRank[items[i].Domain] < checklistDomainRank[items[j].Domain]
+	})
+	return items
+}
+
+// findChecklistItemTx resolves a key against a family's list. A hidden item
+// still resolves, so it can be unmarked after it was hidden.
+func findChecklistItemTx(tx *vbolt.Tx, familyId int, key string) (ChecklistItem, bool) {
+	if strings.HasPrefix(key, familyChecklistKeyPrefix) {
+		id, err := strconv.Atoi(strings.TrimPrefix(key, familyChecklistKeyPrefix))
+		if err != nil {
+			return ChecklistItem{}, false
+		}
+		item := GetFamilyChecklistItemById(tx, id)
+		if item.Id == 0 || item.FamilyId != familyId {
+			return ChecklistItem{}, false
+		}
+		return item.asChecklistItem(), true
+	}
+	return builtinChecklistItem(key)
+}
+
+func deleteChecklistAchievementTx(tx *vbolt.Tx, achievement ChecklistAchievement) {
+	vbolt.Delete(tx, ChecklistAchievementBkt, achievement.Id)
+	vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByPersonIndex, achievement.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByMilestoneIndex, achievement.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByFamilyIndex, achievement.Id, -1)
+}
+
+// removeMilestoneChecklistAchievements reopens whatever item a milestone ticked
+// off. Called from DeleteMilestoneTx.
+func removeMilestoneChecklistAchievements(tx *vbolt.Tx, milestoneId int) {
+	for _, achievement := range readByTerm(tx, ChecklistAchievementByMilestoneIndex, ChecklistAchievementBkt, milestoneId) {
+		deleteChecklistAchievementTx(tx, achievement)
+	}
+}
+
+// mergeChecklistAchievementsTx moves a person's achievements to the person
+// they are being merged into. Where both had ticked the same item off, the
+// target's stands and the source's join goes; its milestone is moved with the
+// rest and stays on the record.
+func mergeChecklistAchievementsTx(tx *vbolt.Tx, sourcePersonId, targetPersonId int) {
+	have := make(map[string]bool)
+	for _, achievement := range getPersonChecklistAchievements(tx, targetPersonId) {
+		have[achievement.ItemKey] = true
+	}
+	for _, achievement := range getPersonChecklistAchievements(tx, sourcePersonId) {
+		if have[achievement.ItemKey] {
+			deleteChecklistAchievementTx(tx, achievement)
+			continue
+		}
+		have[achievement.ItemKey] = true
+		achievement.PersonId = targetPersonId
+		vbolt.Write(tx, ChecklistAchievementBkt, achievement.Id, &achievement)
+		vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByPersonIndex, achievement.Id, targetPersonId)
+	}
+}
+
+// deleteFamilyChecklistTx removes a family's own items, hidden rows and
+// achievements. The achievements' milestones are the family's and go with the
+// rest of its milestones.
+func deleteFamilyChecklistTx(tx *vbolt.Tx, familyId int) {
+	for _, item := range getFamilyChecklistItems(tx, familyId) {
+		vbolt.Delete(tx, FamilyChecklistItemBkt, item.Id)
+		vbolt.SetTargetSingleTerm(tx, ChecklistItemByFamilyIndex, item.Id, -1)
+	}
+	for _, row := range getChecklistHiddenItems(tx, familyId) {
+		vbolt.Delete(tx, ChecklistHiddenItemBkt, row.Id)
+		vbolt.SetTargetSingleTerm(tx, ChecklistHiddenByFamilyIndex, row.Id, -1)
+	}
+	for _, achievement := range readByTerm(tx, ChecklistAchievementByFamilyIndex, ChecklistAchievementBkt, familyId) {
+		deleteChecklistAchievementTx(tx, achievement)
+	}
+}
+
+// BuildPersonChecklistTx lays a person's checklist out by age as of now.
+func BuildPersonChecklistTx(tx *vbolt.Tx, person Person, now time.Time) (checklist PersonChecklist, err error) {
+	if person.IsPregnancy {
+		err = errors.New("The checklist starts at birth")
+		return
+	}
+	// Developmental milestones are about what a child should be doing by now,
+	// which means nothing for someone who has died.
+	if person.InMemoriam {
+		err = errors.New("The checklist is not kept for someone who has passed away")
+		return
+	}
+
+	checklist.PersonId = person.Id
+	checklist.AgeMonths = ageInMonths(person.Birthday, now)
+	checklist.Sections = []ChecklistSection{}
+	checklist.Open = []ChecklistEntry{}
+
+	achieved := make(map[string]ChecklistAchievement)
+	for _, achievement := range getPersonChecklistAchievements(tx, person.Id) {
+		achieved[achievement.ItemKey] = achievement
+	}
+
+	var section *ChecklistSection
+	for _, item := range familyChecklistTx(tx, person.FamilyId) {
+		if item.Hidden {
+			continue
+		}
+		if section == nil || section.AgeMonths != item.AgeMonths {
+			// Past the first section not yet due, there is nothing to show.
+			if section != nil && !section.Due {
+				break
+			}
+			checklist.Sections = append(checklist.Sections, ChecklistSection{
+				AgeMonths: item.AgeMonths,
+				Label:     checklistAgeLabel(item.AgeMonths),
+				Due:       float64(item.AgeMonths) <= checklist.AgeMonths,
+				Items:     []ChecklistEntry{},
+			})
+			section = &checklist.Sections[len(checklist.Sections)-1]
+		}
+
+		entry := ChecklistEntry{ChecklistItem: item}
+		if achievement, ok := achieved[item.Key]; ok {
+			entry.Achieved = true
+			entry.MilestoneId = achievement.MilestoneId
+			entry.AchievedDate = GetMilestoneById(tx, achievement.MilestoneId).MilestoneDate
+		}
+		section.Items = append(section.Items, entry)
+		if section.Due && !entry.Achieved {
+			checklist.Open = append(checklist.Open, entry)
+		}
+	}
+	return
+}
+
+// MarkChecklistItemTx ticks an item off by creating its milestone and the
+// achievement joining the two.
+func MarkChecklistItemTx(tx *vbolt.Tx, req MarkChecklistItemRequest, familyId int) (resp MarkChecklistItemResponse, err error) {
+	person := GetPersonById(tx, req.PersonId)
+	if person.Id == 0 || !CanFamilyAccess(tx, familyId, person.FamilyId, AccessContribute) {
+		err = errors.New("Person not found or not in your family")
+		return
+	}
+	if person.IsPregnancy {
+		err = errors.New("The checklist starts at birth")
+		return
+	}
+
+	item, ok := findChecklistItemTx(tx, person.FamilyId, req.ItemKey)
+	if !ok {
+		err = errors.New("Checklist item not found")
+		return
+	}
+	for _, achievement := range getPersonChecklistAchievements(tx, person.Id) {
+		if achievement.ItemKey == item.Key {
+			err = errors.New("This item is already checked off")
+			return
+		}
+	}
+
+	resp.Milestone, err = AddMilestoneTx(tx, AddMilestoneRequest{
+		PersonId:      person.Id,
+		Description:   item.Text,
+		Category:      checklistMilestoneCategory,
+		InputType:     req.InputType,
+		MilestoneDate: req.MilestoneDate,
+		AgeYears:      req.AgeYears,
+		AgeMonths:     req.AgeMonths,
+		DatePrecision: req.DatePrecision,
+	}, familyId)
+	if err != nil {
+		return
+	}
+
+	achievement := ChecklistAchievement{
+		Id:          vbolt.NextIntId(tx, ChecklistAchievementBkt),
+		PersonId:    person.Id,
+		FamilyId:    resp.Milestone.FamilyId,
+		ItemKey:     item.Key,
+		MilestoneId: resp.Milestone.Id,
+		CreatedAt:   time.Now(),
+	}
+	vbolt.Write(tx, ChecklistAchievementBkt, achievement.Id, &achievement)
+	vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByPersonIndex, achievement.Id, achievement.PersonId)
+	vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByMilestoneIndex, achievement.Id, achievement.MilestoneId)
+	vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByFamilyIndex, achievement.Id, achievement.FamilyId)
+
+	resp.Entry = ChecklistEntry{
+		ChecklistItem: item,
+		Achieved:      true,
+		MilestoneId:   resp.Milestone.Id,
+		AchievedDate:  resp.Milestone.MilestoneDate,
+	}
+	return
+}
+
+func validateChecklistItemFields(ageMonths int, domain string, text string) error {
+	if ageMonths <= 0 || ageMonths > maxChecklistReportMonths {
+		return fmt.Errorf("Age must be between 1 and %d months", maxChecklistReportMonths)
+	}
+	if !checklistDomains[domain] {
+		return errors.New("Domain must be 'social', 'language', 'cognitive' or 'movement'")
+	}
+	if strings.TrimSpace(text) == "" {
+		return errors.New("Item text is required")
+	}
+	return nil
+}
+
+// vbeam procedures
+
+func GetPersonChecklist(ctx *vbeam.Context, req GetPersonChecklistRequest) (resp GetPersonChecklistResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	person := GetPersonById(ctx.Tx, req.PersonId)
+	if !CanAccessPerson(ctx.Tx, user, person, ScopeMilestones, AccessView) {
+		err = errors.New("Person not found or not in your family")
+		return
+	}
+
+	resp.Checklist, err = BuildPersonChecklistTx(ctx.Tx, person, time.Now())
+	return
+}
+
+func MarkChecklistItem(ctx *vbeam.Context, req MarkChecklistItemRequest) (resp MarkChecklistItemResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if req.PersonId <= 0 || req.ItemKey == "" {
+		err = errors.New("Person and checklist item are required")
+		return
+	}
+	if req.InputType == "" {
+		req.InputType = "today"
+	}
+
+	familyId, err := ActingFamilyForPerson(ctx.Tx, user, req.PersonId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	resp, err = MarkChecklistItemTx(ctx.Tx, req, familyId)
+	if err != nil {
+		return
+	}
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+// UnmarkChecklistItem reopens an item. The milestone was created by ticking the
+// item off, so it goes too — unless it has since been shared with others, in
+// which case only this person comes off it. Deleting the milestone from the
+// timeline reopens the item the same way.
+func UnmarkChecklistItem(ctx *vbeam.Context, req UnmarkChecklistItemRequest) (resp UnmarkChecklistItemResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ActingFamilyForPerson(ctx.Tx, user, req.PersonId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	for _, achievement := range getPersonChecklistAchievements(ctx.Tx, req.PersonId) {
+		if achievement.ItemKey != req.ItemKey {
+			continue
+		}
+		vbeam.UseWriteTx(ctx)
+		deleteChecklistAchievementTx(ctx.Tx, achievement)
+		milestone := GetMilestoneById(ctx.Tx, achievement.MilestoneId)
+		if milestone.Id != 0 && !removePersonFromMilestoneTx(ctx.Tx, milestone, req.PersonId) {
+			if err = DeleteMilestoneTx(ctx.Tx, milestone.Id, familyId); err != nil {
+				return
+			}
+		}
+		vbolt.TxCommit(ctx.Tx)
+		resp.Success = true
+		return
+	}
+
+	err = errors.New("This item is not checked off")
+	return
+}
+
+// GetChecklistReport lists the open, age-appropriate items for every child on
+// a family's roster the caller can see milestones for.
+func GetChecklistReport(ctx *vbeam.Context, req GetChecklistReportRequest) (resp GetChecklistReportResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessView)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	resp.Rows = []ChecklistReportRow{}
+	for _, person := range GetFamilyPeople(ctx.Tx, familyId) {
+		if person.Type != Child || person.IsPregnancy || person.InMemoriam {
+			continue
+		}
+		if ageInMonths(person.Birthday, now) > maxChecklistReportMonths {
+			continue
+		}
+		if !CanAccessPerson(ctx.Tx, user, person, ScopeMilestones, AccessView) {
+			continue
+		}
+		checklist, buildErr := BuildPersonChecklistTx(ctx.Tx, person, now)
+		if buildErr != nil || len(checklist.Open) == 0 {
+			continue
+		}
+		resp.Rows = append(resp.Rows, ChecklistReportRow{
+			Person:    person,
+			AgeMonths: checklist.AgeMonths,
+			Open:      checklist.Open,
+		})
+	}
+	return
+}
+
+func GetChecklistItems(ctx *vbeam.Context, req GetChecklistItemsRequest) (resp GetChecklistItemsResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessView)
+	if err != nil {
+		return
+	}
+
+	resp.Items = familyChecklistTx(ctx.Tx, familyId)
+	return
+}
+
+func AddChecklistItem(ctx *vbeam.Context, req AddChecklistItemRequest) (resp ChecklistItemResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateChecklistItemFields(req.AgeMonths, req.Domain, req.Text); err != nil {
+		return
+	}
+
+	familyId, err := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	item := FamilyChecklistItem{
+		Id:        vbolt.NextIntId(ctx.Tx, FamilyChecklistItemBkt),
+		FamilyId:  familyId,
+		AgeMonths: req.AgeMonths,
+		Domain:    req.Domain,
+		Text:      trimField(req.Text, maxNameLength),
+		CreatedAt: time.Now(),
+	}
+	vbolt.Write(ctx.Tx, FamilyChecklistItemBkt, item.Id, &item)
+	vbolt.SetTargetSingleTerm(ctx.Tx, ChecklistItemByFamilyIndex, item.Id, familyId)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Item = item.asChecklistItem()
+	return
+}
+
+// UpdateChecklistItem edits a family's own item. Achievements are stored by
+// key, so a child who had ticked the item off keeps it ticked; the milestone
+// keeps the wording it was created with.
+func UpdateChecklistItem(ctx *vbeam.Context, req UpdateChecklistItemRequest) (resp ChecklistItemResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateChecklistItemFields(req.AgeMonths, req.Domain, req.Text); err != nil {
+		return
+	}
+
+	item := GetFamilyChecklistItemById(ctx.Tx, req.Id)
+	if item.Id == 0 || !CanAccessFamily(ctx.Tx, user, item.FamilyId, AccessContribute) {
+		err = errors.New("Checklist item not found")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	item.AgeMonths = req.AgeMonths
+	item.Domain = req.Domain
+	item.Text = trimField(req.Text, maxNameLength)
+	vbolt.Write(ctx.Tx, FamilyChecklistItemBkt, item.Id, &item)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Item = item.asChecklistItem()
+	return
+}
+
+// DeleteChecklistItem removes a family's own item and the achievements
+// answering it. The milestones stay: they record something that happened,
+// whichever list it was on.
+func DeleteChecklistItem(ctx *vbeam.Context, req DeleteChecklistItemRequest) (resp DeleteChecklistItemResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	item := GetFamilyChecklistItemById(ctx.Tx, req.Id)
+	if item.Id == 0 || !CanAccessFamily(ctx.Tx, user, item.FamilyId, AccessAdmin) {
+		err = errors.New("Checklist item not found")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	key := familyChecklistKey(item.Id)
+	for _, achievement := range readByTerm(ctx.Tx, ChecklistAchievementByFamilyIndex, ChecklistAchievementBkt, item.FamilyId) {
+		if achievement.ItemKey == key {
+			deleteChecklistAchievementTx(ctx.Tx, achievement)
+		}
+	}
+	vbolt.Delete(ctx.Tx, FamilyChecklistItemBkt, item.Id)
+	vbolt.SetTargetSingleTerm(ctx.Tx, ChecklistItemByFamilyIndex, item.Id, -1)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Success = true
+	return
+}
+
+// SetChecklistItemHidden takes a built-in item off a family's list or puts it
+// back. A family's own items are deleted rather than hidden.
+func SetChecklistItemHidden(ctx *vbeam.Context, req SetChecklistItemHiddenRequest) (resp SetChecklistItemHiddenResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if _, ok := builtinChecklistItem(req.ItemKey); !ok {
+		err = errors.New("Only built-in checklist items can be hidden")
+		return
+	}
+
+	familyId, err := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	var existing ChecklistHiddenItem
+	for _, row := range getChecklistHiddenItems(ctx.Tx, familyId) {
+		if row.ItemKey == req.ItemKey {
+			existing = row
+		}
+	}
+	switch {
+	case req.Hidden && existing.Id == 0:
+		row := ChecklistHiddenItem{
+			Id:        vbolt.NextIntId(ctx.Tx, ChecklistHiddenItemBkt),
+			FamilyId:  familyId,
+			ItemKey:   req.ItemKey,
+			CreatedAt: time.Now(),
+		}
+		vbolt.Write(ctx.Tx, ChecklistHiddenItemBkt, row.Id, &row)
+		vbolt.SetTargetSingleTerm(ctx.Tx, ChecklistHiddenByFamilyIndex, row.Id, familyId)
+	case !req.Hidden && existing.Id != 0:
+		vbolt.Delete(ctx.Tx, ChecklistHiddenItemBkt, existing.Id)
+		vbolt.SetTargetSingleTerm(ctx.Tx, ChecklistHiddenByFamilyIndex, existing.Id, -1)
+	}
+	// The list is read before the commit closes the transaction.
+	resp.Items = familyChecklistTx(ctx.Tx, familyId)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
@@ -0,0 +1,363 @@
+package backend
+
+import (
+	"family/cfg"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type checklistFixture struct {
+	db       *vbolt.DB
+	parent   User
+	outsider User
+	baby     Person
+	teen     Person
+}
+
+func setupChecklistFixture(t *testing.T) checklistFixture {
+	t.Helper()
+
+	db := vbolt.Open(t.TempDir() + "/checklist.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	t.Cleanup(func() { _ = db.Close() })
+	appDb = db
+	jwtKey = []byte("checklist-test-secret-key-at-least-32")
+
+	fx := checklistFixture{db: db}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+
+	// The baby is thirteen months old whenever the test runs, so the report,
+	// which reads the clock, sees the same sections as the fixed-date tests.
+	babyBirthday := time.Now().AddDate(-1, -1, 0).Format("2006-01-02")
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		fx.parent = AddUserTx(tx, CreateAccountRequest{Name: "Parent", Email: "parent@example.com"}, hash)
+		fx.outsider = AddUserTx(tx, CreateAccountRequest{Name: "Outsider", Email: "outsider@example.com"}, hash)
+
+		var err error
+		fx.baby, err = AddPersonTx(tx, AddPersonRequest{Name: "Baby", PersonType: 1, Gender: 1, Birthdate: babyBirthday}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		fx.teen, err = AddPersonTx(tx, AddPersonRequest{Name: "Teen", PersonType: 1, Gender: 0, Birthdate: "2010-01-01"}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+	return fx
+}
+
+func (fx checklistFixture) call(t *testing.T, user User, fn func(ctx *vbeam.Context)) {
+	t.Helper()
+	token, err := generateJwtTokenString(user)
+	if err != nil {
+		t.Fatalf("generateJwtTokenString() error = %v", err)
+	}
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		fn(&vbeam.Context{Tx: tx, Token: token})
+	})
+}
+
+// seedFamilyChecklist gives a family one of each checklist row, for the
+// account deletion sweep.
+func seedFamilyChecklist(t *testing.T, tx *vbolt.Tx, familyId int, personId int) {
+	t.Helper()
+
+	item := FamilyChecklistItem{
+		Id: vbolt.NextIntId(tx, FamilyChecklistItemBkt), FamilyId: familyId,
+		AgeMonths: 18, Domain: ChecklistSocial, Text: "Waves at the neighbours", CreatedAt: time.Now(),
+	}
+	vbolt.Write(tx, FamilyChecklistItemBkt, item.Id, &item)
+	vbolt.SetTargetSingleTerm(tx, ChecklistItemByFamilyIndex, item.Id, familyId)
+
+	hidden := ChecklistHiddenItem{
+		Id: vbolt.NextIntId(tx, ChecklistHiddenItemBkt), FamilyId: familyId,
+		ItemKey: builtinChecklist[0].Key, CreatedAt: time.Now(),
+	}
+	vbolt.Write(tx, ChecklistHiddenItemBkt, hidden.Id, &hidden)
+	vbolt.SetTargetSingleTerm(tx, ChecklistHiddenByFamilyIndex, hidden.Id, familyId)
+
+	if _, err := MarkChecklistItemTx(tx, MarkChecklistItemRequest{
+		PersonId: personId, ItemKey: familyChecklistKey(item.Id), InputType: "today",
+	}, familyId); err != nil {
+		t.Fatalf("MarkChecklistItemTx() error = %v", err)
+	}
+}
+
+func TestBuiltinChecklistParses(t *testing.T) {
+	if len(builtinChecklist) < 100 {
+		t.Fatalf("builtin checklist has %d items", len(builtinChecklist))
+	}
+	seen := map[string]bool{}
+	for i, item := range builtinChecklist {
+		if seen[item.Key] {
+			t.Errorf("duplicate key %q", item.Key)
+		}
+		seen[item.Key] = true
+		if i > 0 && item.AgeMonths < builtinChecklist[i-1].AgeMonths {
+			t.Errorf("%q is out of age order", item.Key)
+		}
+	}
+
+	if _, err := parseChecklist([]byte("key,age_months,domain,text\nx,12,juggling,Juggles\n")); err == nil {
+		t.Error("an unknown domain was accepted")
+	}
+	if _, err := parseChecklist([]byte("key,age_months,domain,text\nx,12,social,A\nx,15,social,B\n")); err == nil {
+		t.Error("a duplicate key was accepted")
+	}
+	if _, err := parseChecklist([]byte("key,age_months,domain,text\nfamily-1,12,social,A\n")); err == nil {
+		t.Error("a key in the family namespace was accepted")
+	}
+}
+
+func TestChecklistSectionsFollowAge(t *testing.T) {
+	fx := setupChecklistFixture(t)
+	now := fx.baby.Birthday.AddDate(0, 13, 0)
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		checklist, err := BuildPersonChecklistTx(tx, fx.baby, now)
+		if err != nil {
+			t.Fatalf("BuildPersonChecklistTx() error = %v", err)
+		}
+
+		var ages []int
+		for _, section := range checklist.Sections {
+			ages = append(ages, section.AgeMonths)
+			if want := section.AgeMonths <= 12; section.Due != want {
+				t.Errorf("section %d due = %v", section.AgeMonths, section.Due)
+			}
+		}
+		if len(ages) != 6 || ages[len(ages)-1] != 15 {
+			t.Errorf("sections = %v, want 2 through 12 months and the upcoming 15", ages)
+		}
+		for _, entry := range checklist.Open {
+			if entry.AgeMonths > 12 {
+				t.Errorf("open item %q is not yet due", entry.Key)
+			}
+		}
+		if checklist.Sections[1].Label != "By 4 months" {
+			t.Errorf("label = %q", checklist.Sections[1].Label)
+		}
+	})
+}
+
+func TestMarkingAnItemCreatesAMilestone(t *testing.T) {
+	fx := setupChecklistFixture(t)
+	item := builtinChecklist[0]
+
+	var marked MarkChecklistItemResponse
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		var err error
+		marked, err = MarkChecklistItem(ctx, MarkChecklistItemRequest{PersonId: fx.baby.Id, ItemKey: item.Key})
+		if err != nil {
+			t.Fatalf("MarkChecklistItem() error = %v", err)
+		}
+	})
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		if _, err := MarkChecklistItem(ctx, MarkChecklistItemRequest{PersonId: fx.baby.Id, ItemKey: item.Key}); err == nil {
+			t.Error("an item was checked off twice")
+		}
+	})
+	if marked.Milestone.Description != item.Text || marked.Milestone.Category != checklistMilestoneCategory {
+		t.Errorf("milestone = %+v", marked.Milestone)
+	}
+
+	fx.call(t, fx.outsider, func(ctx *vbeam.Context) {
+		if _, err := MarkChecklistItem(ctx, MarkChecklistItemRequest{PersonId: fx.baby.Id, ItemKey: builtinChecklist[1].Key}); err == nil {
+			t.Error("an outsider checked off an item for someone else's child")
+		}
+		if _, err := GetPersonChecklist(ctx, GetPersonChecklistRequest{PersonId: fx.baby.Id}); err == nil {
+			t.Error("an outsider read someone else's child's checklist")
+		}
+	})
+
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		resp, err := GetPersonChecklist(ctx, GetPersonChecklistRequest{PersonId: fx.baby.Id})
+		if err != nil {
+			t.Fatalf("GetPersonChecklist() error = %v", err)
+		}
+		first := resp.Checklist.Sections[0].Items[0]
+		if !first.Achieved || first.MilestoneId != marked.Milestone.Id {
+			t.Errorf("first item = %+v", first)
+		}
+	})
+
+	// Deleting the milestone from the timeline reopens the item.
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if err := DeleteMilestoneTx(tx, marked.Milestone.Id, fx.parent.FamilyId); err != nil {
+			t.Fatalf("DeleteMilestoneTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+	if got := countRows(t, fx.db, ChecklistAchievementBkt); got != 0 {
+		t.Errorf("achievements after deleting the milestone = %d", got)
+	}
+
+	// Unmarking takes the milestone with it.
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		if _, err := MarkChecklistItem(ctx, MarkChecklistItemRequest{PersonId: fx.baby.Id, ItemKey: item.Key}); err != nil {
+			t.Fatalf("MarkChecklistItem() error = %v", err)
+		}
+	})
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		if _, err := UnmarkChecklistItem(ctx, UnmarkChecklistItemRequest{PersonId: fx.baby.Id, ItemKey: item.Key}); err != nil {
+			t.Fatalf("UnmarkChecklistItem() error = %v", err)
+		}
+	})
+	if got := countRows(t, fx.db, MilestoneBkt); got != 0 {
+		t.Errorf("milestones after unmarking = %d", got)
+	}
+}
+
+func TestFamilyChecklistItems(t *testing.T) {
+	fx := setupChecklistFixture(t)
+	hiddenKey := builtinChecklist[0].Key
+
+	var custom ChecklistItem
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		if _, err := AddChecklistItem(ctx, AddChecklistItemRequest{AgeMonths: 6, Domain: "juggling", Text: "Juggles"}); err == nil {
+			t.Error("an unknown domain was accepted")
+		}
+		resp, err := AddChecklistItem(ctx, AddChecklistItemRequest{AgeMonths: 6, Domain: ChecklistSocial, Text: "Laughs at the dog"})
+		if err != nil {
+			t.Fatalf("AddChecklistItem() error = %v", err)
+		}
+		custom = resp.Item
+	})
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		if _, err := SetChecklistItemHidden(ctx, SetChecklistItemHiddenRequest{ItemKey: custom.Key, Hidden: true}); err == nil {
+			t.Error("a family's own item was hidden rather than deleted")
+		}
+		if _, err := SetChecklistItemHidden(ctx, SetChecklistItemHiddenRequest{ItemKey: hiddenKey, Hidden: true}); err != nil {
+			t.Fatalf("SetChecklistItemHidden() error = %v", err)
+		}
+	})
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		if _, err := MarkChecklistItem(ctx, MarkChecklistItemRequest{PersonId: fx.baby.Id, ItemKey: custom.Key}); err != nil {
+			t.Fatalf("MarkChecklistItem(custom) error = %v", err)
+		}
+	})
+
+	fx.call(t, fx.outsider, func(ctx *vbeam.Context) {
+		if _, err := MarkChecklistItem(ctx, MarkChecklistItemRequest{PersonId: fx.baby.Id, ItemKey: custom.Key}); err == nil {
+			t.Error("an outsider used another family's item")
+		}
+		if _, err := UpdateChecklistItem(ctx, UpdateChecklistItemRequest{Id: custom.Id, AgeMonths: 6, Domain: ChecklistSocial, Text: "Mine now"}); err == nil {
+			t.Error("an outsider edited another family's item")
+		}
+	})
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		checklist, err := BuildPersonChecklistTx(tx, fx.baby, fx.baby.Birthday.AddDate(0, 13, 0))
+		if err != nil {
+			t.Fatalf("BuildPersonChecklistTx() error = %v", err)
+		}
+		foundCustom := false
+		for _, section := range checklist.Sections {
+			for _, entry := range section.Items {
+				if entry.Key == hiddenKey {
+					t.Error("a hidden item is still on the checklist")
+				}
+				if entry.Key == custom.Key {
+					foundCustom = entry.Achieved && section.AgeMonths == 6
+				}
+			}
+		}
+		if !foundCustom {
+			t.Error("the family's own item is missing from the 6 month section or not checked off")
+		}
+	})
+
+	// Deleting the item drops its achievement but keeps the milestone.
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		if _, err := DeleteChecklistItem(ctx, DeleteChecklistItemRequest{Id: custom.Id}); err != nil {
+			t.Fatalf("DeleteChecklistItem() error = %v", err)
+		}
+	})
+	if got := countRows(t, fx.db, ChecklistAchievementBkt); got != 0 {
+		t.Errorf("achievements after deleting the item = %d", got)
+	}
+	if got := countRows(t, fx.db, MilestoneBkt); got != 1 {
+		t.Errorf("milestones after deleting the item = %d, want the milestone kept", got)
+	}
+}
+
+func TestChecklistReport(t *testing.T) {
+	fx := setupChecklistFixture(t)
+
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		resp, err := GetChecklistReport(ctx, GetChecklistReportRequest{})
+		if err != nil {
+			t.Fatalf("GetChecklistReport() error = %v", err)
+		}
+		if len(resp.Rows) != 1 || resp.Rows[0].Person.Id != fx.baby.Id {
+			t.Fatalf("report rows = %+v, want only the baby", resp.Rows)
+		}
+		if len(resp.Rows[0].Open) == 0 {
+			t.Error("the baby has no open items")
+		}
+	})
+}
+
+func TestChecklistLeavesOutThoseWhoDied(t *testing.T) {
+	fx := setupChecklistFixture(t)
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		baby := GetPersonById(tx, fx.baby.Id)
+		baby.DateOfDeath = time.Now().AddDate(0, 0, -7)
+		vbolt.Write(tx, PeopleBkt, baby.Id, &baby)
+		vbolt.TxCommit(tx)
+	})
+
+	fx.call(t, fx.parent, func(ctx *vbeam.Context) {
+		if _, err := GetPersonChecklist(ctx, GetPersonChecklistRequest{PersonId: fx.baby.Id}); err == nil {
+			t.Error("a checklist was built for a child who has died")
+		}
+		resp, err := GetChecklistReport(ctx, GetChecklistReportRequest{})
+		if err != nil {
+			t.Fatalf("GetChecklistReport() error = %v", err)
+		}
+		if len(resp.Rows) != 0 {
+			t.Errorf("report rows = %+v, want none", resp.Rows)
+		}
+	})
+}
+
+func TestMergeMovesChecklistAchievements(t *testing.T) {
+	fx := setupChecklistFixture(t)
+	shared, only := builtinChecklist[0].Key, builtinChecklist[1].Key
+
+	var twin Person
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		twin, err = AddPersonTx(tx, AddPersonRequest{Name: "Baby again", PersonType: 1, Gender: 1, Birthdate: fx.baby.Birthday.Format("2006-01-02")}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		for _, mark := range []struct {
+			personId int
+			key      string
+		}{{fx.baby.Id, shared}, {twin.Id, shared}, {twin.Id, only}} {
+			if _, err := MarkChecklistItemTx(tx, MarkChecklistItemRequest{PersonId: mark.personId, ItemKey: mark.key, InputType: "today"}, fx.parent.FamilyId); err != nil {
+				t.Fatalf("MarkChecklistItemTx() error = %v", err)
+			}
+		}
+		mergeChecklistAchievementsTx(tx, twin.Id, fx.baby.Id)
+		vbolt.TxCommit(tx)
+	})
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		achievements := getPersonChecklistAchievements(tx, fx.baby.Id)
+		if len(achievements) != 2 {
+			t.Errorf("achievements after the merge = %+v, want one per item", achievements)
+		}
+		if got := getPersonChecklistAchievements(tx, twin.Id); len(got) != 0 {
+			t.Errorf("the source kept %d achievements", len(got))
+		}
+	})
+}
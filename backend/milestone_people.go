@@ -0,0 +1,256 @@
+package backend
+
+import (
+	"errors"
+	"family/cfg"
+	"slices"
+	"sort"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// A milestone used to hang off exactly one person, so "first family trip to
+// the beach" went in once per child and the copies drifted apart as each was
+// edited on its own. The people a milestone is about are now join rows, the
+// same shape as PhotoPerson, and a milestone shows up for every one of them.
+//
+// Milestone.PersonId stays, as the person the milestone was entered for. It is
+// always one of the joined people, it is the birthday an "age" date is worked
+// out from, and it is what a client that predates shared milestones keeps
+// showing. Removing that person from the milestone hands the role to whoever
+// is left.
+//
+// Every person on a milestone belongs to the milestone's own family: the
+// family that owns the record is the one that can write it, and a person
+// shared in by a link is not theirs to write about.
+
+// MilestonePerson links a milestone to one of the people it is about.
+type MilestonePerson struct {
+	Id          int       `json:"id"`
+	MilestoneId int       `json:"milestoneId"`
+	PersonId    int       `json:"personId"`
+	FamilyId    int       `json:"familyId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func PackMilestonePerson(self *MilestonePerson, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.MilestoneId, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var MilestonePersonBkt = vbolt.Bucket(&cfg.Info, "milestone_people", vpack.FInt, PackMilestonePerson)
+
+// MilestonePersonByMilestoneIndex: term = milestone_id, target = milestone_person_id
+var MilestonePersonByMilestoneIndex = vbolt.Index(&cfg.Info, "milestone_person_by_milestone", vpack.FInt, vpack.FInt)
+
+// MilestonePersonByPersonIndex: term = person_id, target = milestone_person_id.
+// This is what GetPersonMilestonesTx reads.
+var MilestonePersonByPersonIndex = vbolt.Index(&cfg.Info, "milestone_person_by_person", vpack.FInt, vpack.FInt)
+
+// MilestonePersonByFamilyIndex: term = family_id, target = milestone_person_id
+var MilestonePersonByFamilyIndex = vbolt.Index(&cfg.Info, "milestone_person_by_family", vpack.FInt, vpack.FInt)
+
+// GetMilestonePersonIds returns everyone on the milestone, in the order they
+// were added, which puts the person it was entered for first.
+func GetMilestonePersonIds(tx *vbolt.Tx, milestoneId int) []int {
+	rows := readByTerm(tx, MilestonePersonByMilestoneIndex, MilestonePersonBkt, milestoneId)
+	personIds := make([]int, 0, len(rows))
+	for _, row := range rows {
+		personIds = append(personIds, row.PersonId)
+	}
+	return personIds
+}
+
+// getPersonMilestoneIds returns the ids of every milestone the person is on,
+// ascending.
+func getPersonMilestoneIds(tx *vbolt.Tx, personId int) []int {
+	rows := readByTerm(tx, MilestonePersonByPersonIndex, MilestonePersonBkt, personId)
+	milestoneIds := make([]int, 0, len(rows))
+	for _, row := range rows {
+		milestoneIds = append(milestoneIds, row.MilestoneId)
+	}
+	sort.Ints(milestoneIds)
+	return milestoneIds
+}
+
+// withMilestonePersonIds fills in PersonIds, which is stored as join rows and
+// never packed with the milestone. A reader who reached the milestone through
+// a link sees only the people that link shares with them; the rest of the
+// household stays out of the list.
+func withMilestonePersonIds(tx *vbolt.Tx, user User, milestones []Milestone) []Milestone {
+	for i := range milestones {
+		personIds := GetMilestonePersonIds(tx, milestones[i].Id)
+		visible := make([]int, 0, len(personIds))
+		for _, personId := range personIds {
+			if CanAccessPerson(tx, user, GetPersonById(tx, personId), ScopeMilestones, AccessView) {
+				visible = append(visible, personId)
+			}
+		}
+		milestones[i].PersonIds = visible
+	}
+	return milestones
+}
+
+// normalizeMilestonePersonIds puts the primary person first and drops
+// duplicates and non-ids.
+func normalizeMilestonePersonIds(primaryId int, personIds []int) []int {
+	normalized := make([]int, 0, len(personIds)+1)
+	for _, personId := range append([]int{primaryId}, personIds...) {
+		if personId > 0 && !slices.Contains(normalized, personId) {
+			normalized = append(normalized, personId)
+		}
+	}
+	return normalized
+}
+
+// validateMilestonePeople checks every person before anything is written, so a
+// bad id in the list cannot leave the milestone half-linked.
+func validateMilestonePeople(tx *vbolt.Tx, personIds []int, familyId int) error {
+	if len(personIds) == 0 {
+		return errors.New("A milestone needs at least one person")
+	}
+	for _, personId := range personIds {
+		person := GetPersonById(tx, personId)
+		if person.Id == 0 || !CanFamilyAccess(tx, familyId, person.FamilyId, AccessContribute) {
+			return errors.New("Person not found or not in your family")
+		}
+	}
+	return nil
+}
+
+func addMilestonePersonTx(tx *vbolt.Tx, milestone Milestone, personId int) {
+	row := MilestonePerson{
+		Id:          vbolt.NextIntId(tx, MilestonePersonBkt),
+		MilestoneId: milestone.Id,
+		PersonId:    personId,
+		FamilyId:    milestone.FamilyId,
+		CreatedAt:   time.Now(),
+	}
+	vbolt.Write(tx, MilestonePersonBkt, row.Id, &row)
+	vbolt.SetTargetSingleTerm(tx, MilestonePersonByMilestoneIndex, row.Id, milestone.Id)
+	vbolt.SetTargetSingleTerm(tx, MilestonePersonByPersonIndex, row.Id, personId)
+	vbolt.SetTargetSingleTerm(tx, MilestonePersonByFamilyIndex, row.Id, milestone.FamilyId)
+}
+
+// deleteMilestonePersonTx takes one person off a milestone. A checklist item
+// that person ticked off with this milestone is theirs no longer, so it
+// reopens.
+func deleteMilestonePersonTx(tx *vbolt.Tx, row MilestonePerson) {
+	vbolt.Delete(tx, MilestonePersonBkt, row.Id)
+	vbolt.SetTargetSingleTerm(tx, MilestonePersonByMilestoneIndex, row.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, MilestonePersonByPersonIndex, row.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, MilestonePersonByFamilyIndex, row.Id, -1)
+
+	for _, achievement := range readByTerm(tx, ChecklistAchievementByMilestoneIndex, ChecklistAchievementBkt, row.MilestoneId) {
+		if achievement.PersonId == row.PersonId {
+			deleteChecklistAchievementTx(tx, achievement)
+		}
+	}
+}
+
+// setMilestonePeopleTx makes the milestone's people exactly personIds, adding
+// and removing joins as needed, and moves PersonId if its person was removed.
+// The caller has already run validateMilestonePeople, and writes the milestone
+// and its search terms afterwards.
+func setMilestonePeopleTx(tx *vbolt.Tx, milestone *Milestone, personIds []int) {
+	desired := make(map[int]bool, len(personIds))
+	for _, personId := range personIds {
+		desired[personId] = true
+	}
+
+	for _, row := range readByTerm(tx, MilestonePersonByMilestoneIndex, MilestonePersonBkt, milestone.Id) {
+		if desired[row.PersonId] {
+			delete(desired, row.PersonId)
+			continue
+		}
+		deleteMilestonePersonTx(tx, row)
+	}
+
+	for _, personId := range personIds {
+		if desired[personId] {
+			addMilestonePersonTx(tx, *milestone, personId)
+		}
+	}
+
+	if !slices.Contains(personIds, milestone.PersonId) {
+		milestone.PersonId = personIds[0]
+	}
+}
+
+// removeAllMilestonePeople clears a milestone's joins when the milestone is
+// deleted, as removeAllMilestonePhotos does for its photos.
+func removeAllMilestonePeople(tx *vbolt.Tx, milestoneId int) {
+	for _, row := range readByTerm(tx, MilestonePersonByMilestoneIndex, MilestonePersonBkt, milestoneId) {
+		deleteMilestonePersonTx(tx, row)
+	}
+}
+
+// removePersonFromMilestoneTx takes one person off a milestone that others are
+// still on, keeping the milestone. Returns false, and changes nothing, when
+// the person is the only one left, since a milestone about nobody is the
+// caller's to delete.
+func removePersonFromMilestoneTx(tx *vbolt.Tx, milestone Milestone, personId int) bool {
+	remaining := make([]int, 0)
+	for _, id := range GetMilestonePersonIds(tx, milestone.Id) {
+		if id != personId {
+			remaining = append(remaining, id)
+		}
+	}
+	if len(remaining) == 0 {
+		return false
+	}
+	setMilestonePeopleTx(tx, &milestone, remaining)
+	vbolt.Write(tx, MilestoneBkt, milestone.Id, &milestone)
+	UpdateMilestoneSearchIndex(tx, milestone)
+	return true
+}
+
+// moveMilestonesToPersonTx is the milestone half of MergePeople. Every
+// milestone the source is on moves to the target; where both were on the same
+// milestone, the source's join simply goes. Returns how many milestones the
+// source was on.
+func moveMilestonesToPersonTx(tx *vbolt.Tx, sourcePersonId, targetPersonId int) (moved int) {
+	for _, row := range readByTerm(tx, MilestonePersonByPersonIndex, MilestonePersonBkt, sourcePersonId) {
+		milestone := GetMilestoneById(tx, row.MilestoneId)
+		if slices.Contains(GetMilestonePersonIds(tx, row.MilestoneId), targetPersonId) {
+			vbolt.Delete(tx, MilestonePersonBkt, row.Id)
+			vbolt.SetTargetSingleTerm(tx, MilestonePersonByMilestoneIndex, row.Id, -1)
+			vbolt.SetTargetSingleTerm(tx, MilestonePersonByPersonIndex, row.Id, -1)
+			vbolt.SetTargetSingleTerm(tx, MilestonePersonByFamilyIndex, row.Id, -1)
+		} else {
+			row.PersonId = targetPersonId
+			vbolt.Write(tx, MilestonePersonBkt, row.Id, &row)
+			vbolt.SetTargetSingleTerm(tx, MilestonePersonByPersonIndex, row.Id, targetPersonId)
+		}
+
+		if milestone.PersonId == sourcePersonId {
+			milestone.PersonId = targetPersonId
+			vbolt.Write(tx, MilestoneBkt, milestone.Id, &milestone)
+		}
+		UpdateMilestoneSearchIndex(tx, milestone)
+		moved++
+	}
+	return
+}
+
+// BackfillMilestonePeople writes the join row for every milestone recorded
+// when a milestone had exactly one person, and refreshes its search terms.
+// Safe to re-run: a milestone that already has its person joined is skipped.
+func BackfillMilestonePeople(tx *vbolt.Tx) (created int) {
+	vbolt.IterateAll(tx, MilestoneBkt, func(milestoneId int, milestone Milestone) bool {
+		if milestone.PersonId == 0 || slices.Contains(GetMilestonePersonIds(tx, milestone.Id), milestone.PersonId) {
+			return true
+		}
+		addMilestonePersonTx(tx, milestone, milestone.PersonId)
+		UpdateMilestoneSearchIndex(tx, milestone)
+		created++
+		return true
+	})
+	return
+}
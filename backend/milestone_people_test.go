@@ -0,0 +1,281 @@
+package backend
+
+import (
+	"family/cfg"
+	"fmt"
+	"slices"
+	"testing"
+
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type milestonePeopleFixture struct {
+	db       *vbolt.DB
+	parent   User
+	outsider User
+	older    Person
+	younger  Person
+	theirs   Person
+}
+
+func setupMilestonePeopleFixture(t *testing.T) milestonePeopleFixture {
+	t.Helper()
+
+	db := vbolt.Open(t.TempDir() + "/milestone_people.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	t.Cleanup(func() { _ = db.Close() })
+
+	fx := milestonePeopleFixture{db: db}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		fx.parent = AddUserTx(tx, CreateAccountRequest{Name: "Parent", Email: "parent@example.com"}, hash)
+		fx.outsider = AddUserTx(tx, CreateAccountRequest{Name: "Outsider", Email: "outsider@example.com"}, hash)
+
+		var err error
+		fx.older, err = AddPersonTx(tx, AddPersonRequest{Name: "Older", PersonType: 1, Gender: 0, Birthdate: "2018-03-01"}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		fx.younger, err = AddPersonTx(tx, AddPersonRequest{Name: "Younger", PersonType: 1, Gender: 1, Birthdate: "2021-07-01"}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		fx.theirs, err = AddPersonTx(tx, AddPersonRequest{Name: "Theirs", PersonType: 1, Gender: 1, Birthdate: "2020-01-01"}, fx.outsider.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+	return fx
+}
+
+func (fx milestonePeopleFixture) beachTrip(t *testing.T) Milestone {
+	t.Helper()
+	var milestone Milestone
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		milestone, err = AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.older.Id, PersonIds: []int{fx.younger.Id, fx.older.Id},
+			Description: "First family trip to the beach", Category: "first",
+			InputType: "date", MilestoneDate: stringPtr("2023-07-14"),
+		}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+	return milestone
+}
+
+func TestSharedMilestoneShowsForEveryone(t *testing.T) {
+	fx := setupMilestonePeopleFixture(t)
+	trip := fx.beachTrip(t)
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if got := GetMilestonePersonIds(tx, trip.Id); !slices.Equal(got, []int{fx.older.Id, fx.younger.Id}) {
+			t.Errorf("people = %v, want the primary first and no duplicate", got)
+		}
+		for _, person := range []Person{fx.older, fx.younger} {
+			milestones := GetPersonMilestonesTx(tx, person.Id)
+			if len(milestones) != 1 || milestones[0].Id != trip.Id {
+				t.Errorf("%s's milestones = %+v", person.Name, milestones)
+			}
+		}
+		if got := SearchMilestonesTx(tx, "beach", fx.parent.FamilyId, 10); len(got) != 1 {
+			t.Errorf("search returned %d milestones, want the one shared milestone", len(got))
+		}
+		var ids []int
+		vbolt.ReadTermTargets(tx, MilestoneSearchIndex, fmt.Sprintf("p:%d", fx.younger.Id), &ids, vbolt.Window{})
+		if len(ids) != 1 {
+			t.Errorf("person search term for the second person found %v", ids)
+		}
+	})
+}
+
+func TestSharedMilestoneRefusesAnotherFamilysPerson(t *testing.T) {
+	fx := setupMilestonePeopleFixture(t)
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if _, err := AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.older.Id, PersonIds: []int{fx.theirs.Id},
+			Description: "Playdate", Category: "other", InputType: "today",
+		}, fx.parent.FamilyId); err == nil {
+			t.Error("a milestone was shared with another family's child")
+		}
+	})
+	if got := countRows(t, fx.db, MilestoneBkt); got != 0 {
+		t.Errorf("milestones = %d, want the refused add to leave nothing", got)
+	}
+}
+
+func TestUpdatingMilestonePeople(t *testing.T) {
+	fx := setupMilestonePeopleFixture(t)
+	trip := fx.beachTrip(t)
+
+	update := UpdateMilestoneRequest{
+		Id: trip.Id, Description: trip.Description, Category: trip.Category,
+		InputType: "date", MilestoneDate: stringPtr("2023-07-14"),
+	}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		// No list leaves the people alone.
+		if _, err := UpdateMilestoneTx(tx, update, fx.parent.FamilyId); err != nil {
+			t.Fatalf("UpdateMilestoneTx() error = %v", err)
+		}
+		if got := GetMilestonePersonIds(tx, trip.Id); len(got) != 2 {
+			t.Errorf("an update without people changed them: %v", got)
+		}
+
+		// An empty list is not a milestone about nobody.
+		update.PersonIds = []int{}
+		if _, err := UpdateMilestoneTx(tx, update, fx.parent.FamilyId); err == nil {
+			t.Error("a milestone was left with no people")
+		}
+
+		// Dropping the primary hands the role on, and an age is then counted
+		// from the new primary's birthday.
+		update.PersonIds = []int{fx.younger.Id}
+		update.InputType, update.AgeYears, update.AgeMonths = "age", intPtr(1), intPtr(0)
+		updated, err := UpdateMilestoneTx(tx, update, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("UpdateMilestoneTx() error = %v", err)
+		}
+		if updated.PersonId != fx.younger.Id {
+			t.Errorf("primary = %d, want the remaining person", updated.PersonId)
+		}
+		if got := updated.MilestoneDate.Format("2006-01-02"); got != "2022-07-01" {
+			t.Errorf("date = %s, want a year after the younger child's birthday", got)
+		}
+		if got := GetPersonMilestonesTx(tx, fx.older.Id); len(got) != 0 {
+			t.Errorf("the removed person still has %d milestones", len(got))
+		}
+		vbolt.TxCommit(tx)
+	})
+}
+
+func TestSharedMilestoneThroughALink(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+
+	// alice is shared with B; bob is not.
+	var both Milestone
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		both, err = AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.bob.Id, PersonIds: []int{fx.alice.Id},
+			Description: "Siblings at the zoo", Category: "other", InputType: "today",
+		}, fx.famA)
+		if err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		milestone, err := GetMilestoneForUser(tx, both.Id, fx.userB, AccessView)
+		if err != nil {
+			t.Fatalf("a milestone about a shared child was denied: %v", err)
+		}
+		shown := withMilestonePersonIds(tx, fx.userB, []Milestone{milestone})[0]
+		if !slices.Equal(shown.PersonIds, []int{fx.alice.Id}) {
+			t.Errorf("people shown to the linked family = %v, want only the shared child", shown.PersonIds)
+		}
+		if _, err := GetMilestoneForUser(tx, both.Id, fx.userB, AccessContribute); err == nil {
+			t.Error("a link allowed a write")
+		}
+		if _, err := GetMilestoneForUser(tx, both.Id, fx.userC, AccessView); err == nil {
+			t.Error("a second-hop family reached the milestone")
+		}
+	})
+}
+
+func TestMergeKeepsASharedMilestoneOnce(t *testing.T) {
+	fx := setupMilestonePeopleFixture(t)
+	trip := fx.beachTrip(t)
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if moved := moveMilestonesToPersonTx(tx, fx.older.Id, fx.younger.Id); moved != 1 {
+			t.Errorf("moved = %d, want 1", moved)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if got := GetMilestonePersonIds(tx, trip.Id); !slices.Equal(got, []int{fx.younger.Id}) {
+			t.Errorf("people after the merge = %v", got)
+		}
+		if got := GetMilestoneById(tx, trip.Id).PersonId; got != fx.younger.Id {
+			t.Errorf("primary after the merge = %d", got)
+		}
+	})
+}
+
+func TestBackfillMilestonePeople(t *testing.T) {
+	fx := setupMilestonePeopleFixture(t)
+
+	// A milestone as it was written before milestones had people rows.
+	legacy := Milestone{PersonId: fx.older.Id, FamilyId: fx.parent.FamilyId, Description: "Rode a bike", Category: "achievement"}
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		legacy.Id = vbolt.NextIntId(tx, MilestoneBkt)
+		vbolt.Write(tx, MilestoneBkt, legacy.Id, &legacy)
+		vbolt.SetTargetSingleTerm(tx, MilestoneByFamilyIndex, legacy.Id, legacy.FamilyId)
+		vbolt.TxCommit(tx)
+	})
+
+	var first, second int
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		first = BackfillMilestonePeople(tx)
+		second = BackfillMilestonePeople(tx)
+		vbolt.TxCommit(tx)
+	})
+	if first != 1 || second != 0 {
+		t.Errorf("backfill created %d then %d, want 1 then 0", first, second)
+	}
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if got := GetPersonMilestonesTx(tx, fx.older.Id); len(got) != 1 {
+			t.Errorf("the legacy milestone is not on its person after the backfill: %+v", got)
+		}
+	})
+}
+
+func TestSharedMilestoneRoundTripsAndDeletes(t *testing.T) {
+	fx := setupMilestonePeopleFixture(t)
+	fx.beachTrip(t)
+
+	var exported ExportDataStructure
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		if exported, err = buildExportData(tx, fx.parent.FamilyId); err != nil {
+			t.Fatalf("buildExportData() error = %v", err)
+		}
+	})
+	milestone := exported.Milestones[0]
+	if !slices.Equal(milestone.PersonIds, []int{fx.older.Id, fx.younger.Id}) ||
+		!slices.Equal(milestone.PersonNames, []string{"Older", "Younger"}) {
+		t.Fatalf("exported milestone = %+v", milestone)
+	}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		olderCopy, _ := AddPersonTx(tx, AddPersonRequest{Name: "Older", PersonType: 1, Gender: 0, Birthdate: "2018-03-01"}, fx.outsider.FamilyId)
+		youngerCopy, _ := AddPersonTx(tx, AddPersonRequest{Name: "Younger", PersonType: 1, Gender: 1, Birthdate: "2021-07-01"}, fx.outsider.FamilyId)
+		mapping := map[int]int{fx.older.Id: olderCopy.Id, fx.younger.Id: youngerCopy.Id}
+
+		imported, _, errs := importMilestones(tx, exported.Milestones, mapping, fx.outsider.FamilyId, map[string]int{})
+		if imported != 1 || len(errs) != 0 {
+			t.Fatalf("imported %d, errors %v", imported, errs)
+		}
+		if got := GetPersonMilestonesTx(tx, youngerCopy.Id); len(got) != 1 {
+			t.Errorf("the imported milestone is not on the second person: %+v", got)
+		}
+
+		deleteFamilyContentTx(tx, fx.parent.FamilyId)
+		deleteFamilyContentTx(tx, fx.outsider.FamilyId)
+		vbolt.TxCommit(tx)
+	})
+	if got := countRows(t, fx.db, MilestonePersonBkt); got != 0 {
+		t.Errorf("milestone people remaining = %d", got)
+	}
+}
@@ -377,7 +377,7 @@ func TestParseMilestoneDate(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result, err := parseMilestoneDate(test.request, birthday)
+			result, err := parseMilestoneDate(test.request, birthday, DatePrecisionDay)
 
 			if test.shouldError {
 				if err == nil {
@@ -498,11 +498,11 @@ func TestMilestoneValidation(t *testing.T) {
 			shouldError: true,
 		},
 		{
-			name: "invalid category",
+			name: "missing category",
 			request: AddMilestoneRequest{
 				PersonId:    1,
 				Description: "Test milestone",
-				Category:    "invalid",
+				Category:    "  ",
 				InputType:   "today",
 			},
 			shouldError: true,
@@ -34,14 +34,32 @@ type NotificationPreferences struct {
 	// iOS renders on the lock screen. Off by default.
 	ShowMessageText bool      `json:"showMessageText"`
 	UpdatedAt       time.Time `json:"updatedAt"`
+	// GrowthAlertsEnabled and GrowthAlertEmails deliver growth analysis flags
+	// by push and by email. Both are off until asked for: a note that a
+	// child's weight dropped is not something to spring on anyone by default.
+	GrowthAlertsEnabled bool `json:"growthAlertsEnabled"`
+	GrowthAlertEmails   bool `json:"growthAlertEmails"`
+	// MemoriesEnabled and MemoriesEmails deliver the daily "on this day"
+	// digest. Also off until asked for: an old photo can be a welcome surprise
+	// or a hard one, and only the reader knows which.
+	MemoriesEnabled bool `json:"memoriesEnabled"`
+	MemoriesEmails  bool `json:"memoriesEmails"`
 }
 
 func PackNotificationPreferences(self *NotificationPreferences, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
+	version := vpack.Version(3, buf)
 	vpack.Int(&self.UserId, buf)
 	vpack.Bool(&self.ChatEnabled, buf)
 	vpack.Bool(&self.ShowMessageText, buf)
 	vpack.Time(&self.UpdatedAt, buf)
+	if version >= 2 {
+		vpack.Bool(&self.GrowthAlertsEnabled, buf)
+		vpack.Bool(&self.GrowthAlertEmails, buf)
+	}
+	if version >= 3 {
+		vpack.Bool(&self.MemoriesEnabled, buf)
+		vpack.Bool(&self.MemoriesEmails, buf)
+	}
 }
 
 var NotificationPreferencesBkt = vbolt.Bucket(&cfg.Info, "notification_preferences", vpack.FInt, PackNotificationPreferences)
@@ -80,6 +98,10 @@ func (prefs NotificationPreferences) allowsEvent(event string) bool {
 	switch event {
 	case PushEventChatMessage:
 		return prefs.ChatEnabled
+	case PushEventGrowthAlert:
+		return prefs.GrowthAlertsEnabled
+	case PushEventMemories:
+		return prefs.MemoriesEnabled
 	case PushEventTest:
 		// A test push answers "can this device receive anything at all", which
 		// is a question about the delivery path rather than about content. It
@@ -93,13 +115,24 @@ func (prefs NotificationPreferences) allowsEvent(event string) bool {
 // Request/Response types
 
 type NotificationPreferencesResponse struct {
-	ChatEnabled     bool `json:"chatEnabled"`
-	ShowMessageText bool `json:"showMessageText"`
+	ChatEnabled         bool `json:"chatEnabled"`
+	ShowMessageText     bool `json:"showMessageText"`
+	GrowthAlertsEnabled bool `json:"growthAlertsEnabled"`
+	GrowthAlertEmails   bool `json:"growthAlertEmails"`
+	MemoriesEnabled     bool `json:"memoriesEnabled"`
+	MemoriesEmails      bool `json:"memoriesEmails"`
 }
 
 type UpdateNotificationPreferencesRequest struct {
 	ChatEnabled     bool `json:"chatEnabled"`
 	ShowMessageText bool `json:"showMessageText"`
+	// The growth alert settings came later than the form that saves the
+	// others, so they are optional: a client that does not send them leaves
+	// them as they were rather than switching them off.
+	GrowthAlertsEnabled *bool `json:"growthAlertsEnabled,omitempty"`
+	GrowthAlertEmails   *bool `json:"growthAlertEmails,omitempty"`
+	MemoriesEnabled     *bool `json:"memoriesEnabled,omitempty"`
+	MemoriesEmails      *bool `json:"memoriesEmails,omitempty"`
 }
 
 type UpdateNotificationPreferencesResponse struct {
@@ -108,8 +141,12 @@ type UpdateNotificationPreferencesResponse struct {
 
 func notificationPreferencesResponse(prefs NotificationPreferences) NotificationPreferencesResponse {
 	return NotificationPreferencesResponse{
-		ChatEnabled:     prefs.ChatEnabled,
-		ShowMessageText: prefs.ShowMessageText,
+		ChatEnabled:         prefs.ChatEnabled,
+		ShowMessageText:     prefs.ShowMessageText,
+		GrowthAlertsEnabled: prefs.GrowthAlertsEnabled,
+		GrowthAlertEmails:   prefs.GrowthAlertEmails,
+		MemoriesEnabled:     prefs.MemoriesEnabled,
+		MemoriesEmails:      prefs.MemoriesEmails,
 	}
 }
 
@@ -133,11 +170,28 @@ func UpdateNotificationPreferences(ctx *vbeam.Context, req UpdateNotificationPre
 		return
 	}
 
+	existing := loadNotificationPreferences(ctx.Tx, user.Id)
 	prefs := NotificationPreferences{
-		UserId:          user.Id,
-		ChatEnabled:     req.ChatEnabled,
-		ShowMessageText: req.ShowMessageText,
-		UpdatedAt:       time.Now(),
+		UserId:              user.Id,
+		ChatEnabled:         req.ChatEnabled,
+		ShowMessageText:     req.ShowMessageText,
+		GrowthAlertsEnabled: existing.GrowthAlertsEnabled,
+		GrowthAlertEmails:   existing.GrowthAlertEmails,
+		MemoriesEnabled:     existing.MemoriesEnabled,
+		MemoriesEmails:      existing.MemoriesEmails,
+		UpdatedAt:           time.Now(),
+	}
+	if req.GrowthAlertsEnabled != nil {
+		prefs.GrowthAlertsEnabled = *req.GrowthAlertsEnabled
+	}
+	if req.GrowthAlertEmails != nil {
+		prefs.GrowthAlertEmails = *req.GrowthAlertEmails
+	}
+	if req.MemoriesEnabled != nil {
+		prefs.MemoriesEnabled = *req.MemoriesEnabled
+	}
+	if req.MemoriesEmails != nil {
+		prefs.MemoriesEmails = *req.MemoriesEmails
 	}
 	if prefs.UserId == 0 {
 		// Zero is the "never saved" marker in the bucket, so a user id of zero
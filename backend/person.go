@@ -42,10 +42,11 @@ const (
 // Request/Response types
 type AddPersonRequest struct {
 	Name        string `json:"name"`
-	PersonType  int    `json:"personType"`  // 0 = Parent, 1 = Child
-	Gender      int    `json:"gender"`      // 0 = Male, 1 = Female, 2 = Unknown
-	Birthdate   string `json:"birthdate"`   // YYYY-MM-DD format
-	IsPregnancy bool   `json:"isPregnancy"` // true when birthdate is an expected due date
+	PersonType  int    `json:"personType"`            // 0 = Parent, 1 = Child
+	Gender      int    `json:"gender"`                // 0 = Male, 1 = Female, 2 = Unknown
+	Birthdate   string `json:"birthdate"`             // YYYY-MM-DD format
+	IsPregnancy bool   `json:"isPregnancy"`           // true when birthdate is an expected due date
+	DateOfDeath string `json:"dateOfDeath,omitempty"` // YYYY-MM-DD, for someone who has died
 	FamilyId    int    `json:"familyId,omitempty"`
 }
 
@@ -56,6 +57,11 @@ type UpdatePersonRequest struct {
 	Gender      int    `json:"gender"`      // 0 = Male, 1 = Female, 2 = Unknown
 	Birthdate   string `json:"birthdate"`   // YYYY-MM-DD format
 	IsPregnancy bool   `json:"isPregnancy"` // true when birthdate is an expected due date
+
+	// DateOfDeath is YYYY-MM-DD, or "" to clear one recorded by mistake. nil
+	// leaves it unchanged, so an edit form that never shows the field cannot
+	// take someone out of "in memoriam".
+	DateOfDeath *string `json:"dateOfDeath,omitempty"`
 }
 
 type GetPersonRequest struct {
@@ -85,6 +91,15 @@ type MergePeopleResponse struct {
 	MergedGrowthCount int    `json:"mergedGrowthCount"`
 	MergedMilestones  int    `json:"mergedMilestones"`
 	MergedPhotos      int    `json:"mergedPhotos"`
+	MergedPrenatal    int    `json:"mergedPrenatal"`
+	MergedHealth      int    `json:"mergedHealth"` // vaccinations and health records
+	MergedTeeth       int    `json:"mergedTeeth"`  // tooth records moved; one the target had is kept instead
+	MergedSchoolYears int    `json:"mergedSchoolYears"`
+	MergedAlbums      int    `json:"mergedAlbums"`
+	// MergeId names the journal entry UnmergePeople takes, until
+	// UndoableUntil.
+	MergeId       int       `json:"mergeId"`
+	UndoableUntil time.Time `json:"undoableUntil"`
 }
 
 type ListPeopleResponse struct {
@@ -94,8 +109,10 @@ type ListPeopleResponse struct {
 type GetPersonResponse struct {
 	Person     Person       `json:"person,omitempty"`
 	GrowthData []GrowthData `json:"growthData"`
-	Milestones []Milestone  `json:"milestones"`
-	Photos     []Image      `json:"photos"`
+	// BMI is derived from GrowthData on read and shares its scope.
+	BMI        []BMIPoint  `json:"bmi"`
+	Milestones []Milestone `json:"milestones"`
+	Photos     []Image     `json:"photos"`
 }
 
 type ComparePeopleRequest struct {
@@ -105,8 +122,10 @@ type ComparePeopleRequest struct {
 type PersonComparisonData struct {
 	Person     Person       `json:"person"`
 	GrowthData []GrowthData `json:"growthData"`
-	Milestones []Milestone  `json:"milestones"`
-	Photos     []Image      `json:"photos"`
+	// BMI is derived from GrowthData on read and shares its scope.
+	BMI        []BMIPoint  `json:"bmi"`
+	Milestones []Milestone `json:"milestones"`
+	Photos     []Image     `json:"photos"`
 }
 
 type ComparePeopleResponse struct {
@@ -122,6 +141,9 @@ type FamilyTimelineItem struct {
 	GrowthData []GrowthData `json:"growthData"`
 	Milestones []Milestone  `json:"milestones"`
 	Photos     []Image      `json:"photos"`
+	// SchoolYears are the years alone, newest first; grades and report cards
+	// are GetSchoolHistory's.
+	SchoolYears []SchoolYear `json:"schoolYears"`
 }
 
 type GetFamilyTimelineResponse struct {
@@ -143,6 +165,8 @@ type Person struct {
 	ProfileCropScale float64    `json:"profileCropScale"` // Zoom level 1.0+ (default 1.0 = no zoom)
 	FaceDescriptor   []float32  `json:"-"`                // 128-dim face embedding, nil if not extracted
 	IsPregnancy      bool       `json:"isPregnancy"`      // Birthday stores due date until the baby is born
+	DateOfDeath      time.Time  `json:"dateOfDeath"`      // zero for the living
+	InMemoriam       bool       `json:"inMemoriam"`       // derived from DateOfDeath, never stored
 }
 
 // packFloat32Slice serializes a []float32 as a byte slice using little-endian IEEE 754
@@ -167,7 +191,7 @@ func packFloat32Slice(data *[]float32, buf *vpack.Buffer) {
 
 // Packing function for vbolt serialization
 func PackPerson(self *Person, buf *vpack.Buffer) {
-	version := vpack.Version(5, buf)
+	version := vpack.Version(6, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.Int(&self.FamilyId, buf)
 	vpack.String(&self.Name, buf)
@@ -188,6 +212,12 @@ func PackPerson(self *Person, buf *vpack.Buffer) {
 	if version >= 5 {
 		vpack.Bool(&self.IsPregnancy, buf)
 	}
+	if version >= 6 {
+		vpack.Time(&self.DateOfDeath, buf)
+	}
+	// Set on the way in and out alike, so a person read from the database and
+	// one just written both carry it without every caller remembering to.
+	self.InMemoriam = !self.DateOfDeath.IsZero()
 }
 
 // Buckets for vbolt database storage
@@ -216,7 +246,7 @@ func GetFamilyPeople(tx *vbolt.Tx, familyId int) (people []Person) {
 			continue
 		}
 		person.Type = row.Role
-		person.Age = calculatePersonAge(person.Birthday, person.IsPregnancy)
+		person.Age = personAge(person)
 		people = append(people, person)
 	}
 	return
@@ -237,7 +267,7 @@ func GetFamilyOwnPeople(tx *vbolt.Tx, familyId int) (people []Person) {
 	vbolt.ReadTermTargets(tx, PersonIndex, familyId, &personIds, vbolt.Window{})
 	vbolt.ReadSlice(tx, PeopleBkt, personIds, &people)
 	for i := range people {
-		people[i].Age = calculatePersonAge(people[i].Birthday, people[i].IsPregnancy)
+		people[i].Age = personAge(people[i])
 	}
 	return
 }
@@ -267,6 +297,11 @@ func AddPersonTx(tx *vbolt.Tx, req AddPersonRequest, familyId int) (Person, erro
 		return Person{}, errors.New("Invalid birthdate format. Use YYYY-MM-DD")
 	}
 
+	dateOfDeath, err := parseDateOfDeath(req.DateOfDeath, parsedTime, req.IsPregnancy)
+	if err != nil {
+		return Person{}, err
+	}
+
 	// Create person
 	var person Person
 	person.Id = vbolt.NextIntId(tx, PeopleBkt)
@@ -276,7 +311,8 @@ func AddPersonTx(tx *vbolt.Tx, req AddPersonRequest, familyId int) (Person, erro
 	person.Gender = GenderType(req.Gender)
 	person.Birthday = parsedTime
 	person.IsPregnancy = req.IsPregnancy
-	person.Age = calculatePersonAge(parsedTime, person.IsPregnancy)
+	person.DateOfDeath = dateOfDeath
+	person.Age = personAge(person)
 	person.ProfilePhotoId = 0
 
 	vbolt.Write(tx, PeopleBkt, person.Id, &person)
@@ -362,6 +398,36 @@ func calculatePersonAge(birthdate time.Time, isPregnancy bool) string {
 	return calculatePersonAgeAt(birthdate, time.Now(), isPregnancy)
 }
 
+// personAge is the age shown for a person. For someone who has died it is
+// their age when they died: a grandmother who died at 81 stays 81 rather than
+// going on getting older in every list she appears in.
+func personAge(person Person) string {
+	if !person.DateOfDeath.IsZero() {
+		return calculatePersonAgeAt(person.Birthday, person.DateOfDeath, person.IsPregnancy)
+	}
+	return calculatePersonAge(person.Birthday, person.IsPregnancy)
+}
+
+// parseDateOfDeath reads a date of death against the birthdate that came with
+// it; empty means the person is living. A pregnancy may have one too, which
+// is how a loss is remembered, and its age is then the weeks it reached.
+func parseDateOfDeath(value string, birthday time.Time, isPregnancy bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	dateOfDeath, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid date of death format. Use YYYY-MM-DD")
+	}
+	if dateOfDeath.After(time.Now()) {
+		return time.Time{}, errors.New("Date of death cannot be in the future")
+	}
+	if !isPregnancy && dateOfDeath.Before(birthday) {
+		return time.Time{}, errors.New("Date of death cannot be before the birthdate")
+	}
+	return dateOfDeath, nil
+}
+
 func calculateGestationalAgeAt(dueDate, referenceDate time.Time) string {
 	dueDateUTC := time.Date(dueDate.Year(), dueDate.Month(), dueDate.Day(), 0, 0, 0, 0, time.UTC)
 	referenceDateUTC := time.Date(referenceDate.Year(), referenceDate.Month(), referenceDate.Day(), 0, 0, 0, 0, time.UTC)
@@ -406,6 +472,7 @@ func AddPerson(ctx *vbeam.Context, req AddPersonRequest) (resp GetPersonResponse
 
 	resp.Person = person
 	resp.GrowthData = []GrowthData{}
+	resp.BMI = []BMIPoint{}
 	resp.Milestones = []Milestone{}
 	resp.Photos = []Image{}
 	return
@@ -441,22 +508,40 @@ func UpdatePerson(ctx *vbeam.Context, req UpdatePersonRequest) (resp GetPersonRe
 		err = errors.New("Invalid birthdate format. Use YYYY-MM-DD")
 		return
 	}
+	// A date that is kept is still checked against the new birthdate.
+	deathValue := ""
+	if !person.DateOfDeath.IsZero() {
+		deathValue = person.DateOfDeath.Format("2006-01-02")
+	}
+	if req.DateOfDeath != nil {
+		deathValue = *req.DateOfDeath
+	}
+	dateOfDeath, err := parseDateOfDeath(deathValue, parsedTime, req.IsPregnancy)
+	if err != nil {
+		return
+	}
 
 	person.Name = req.Name
 	person.Type = PersonType(req.PersonType)
 	person.Gender = GenderType(req.Gender)
 	person.Birthday = parsedTime
 	person.IsPregnancy = req.IsPregnancy
-	person.Age = calculatePersonAge(parsedTime, person.IsPregnancy)
+	person.DateOfDeath = dateOfDeath
+	person.Age = personAge(person)
 
 	vbolt.Write(ctx.Tx, PeopleBkt, person.Id, &person)
 	// The edit form is scoped to the person's own household, so it sets the role
 	// on the home roster only. Roles on extended rosters are untouched.
 	SetPersonFamilyRoleTx(ctx.Tx, person.Id, person.FamilyId, person.Type)
 
+	// Every plausibility check and percentile is relative to age, so a
+	// corrected birthday can raise or clear any flag in the record.
+	refreshAllGrowthFlagsTx(ctx.Tx, person.Id)
+
 	resp.Person = person
-	resp.GrowthData = GetPersonGrowthDataTx(ctx.Tx, req.Id)
-	resp.Milestones = GetPersonMilestonesTx(ctx.Tx, req.Id)
+	resp.GrowthData = withGrowthPhotoIds(ctx.Tx, user, localizeGrowthData(loadUserPreferences(ctx.Tx, user.Id), GetPersonGrowthWithPercentilesTx(ctx.Tx, person)))
+	resp.BMI = deriveBMISeries(resp.GrowthData)
+	resp.Milestones = withMilestonePersonIds(ctx.Tx, user, GetPersonMilestonesTx(ctx.Tx, req.Id))
 	for i := range resp.Milestones {
 		resp.Milestones[i].PhotoIds = GetMilestonePhotoIds(ctx.Tx, resp.Milestones[i].Id)
 	}
@@ -498,18 +583,19 @@ func GetPerson(ctx *vbeam.Context, req GetPersonRequest) (resp GetPersonResponse
 	}
 
 	// Calculate age
-	resp.Person.Age = calculatePersonAge(resp.Person.Birthday, resp.Person.IsPregnancy)
+	resp.Person.Age = personAge(resp.Person)
 
 	// Each section is gated on its own scope. Someone in the person's family
 	// clears all of them; someone seeing a shared person gets only the kinds of
 	// record their link carries, which is what makes "milestones yes,
 	// measurements no" expressible.
 	if CanAccessPerson(ctx.Tx, user, resp.Person, ScopeGrowth, AccessView) {
-		resp.GrowthData = GetPersonGrowthDataTx(ctx.Tx, req.Id)
+		resp.GrowthData = withGrowthPhotoIds(ctx.Tx, user, localizeGrowthData(loadUserPreferences(ctx.Tx, user.Id), GetPersonGrowthWithPercentilesTx(ctx.Tx, resp.Person)))
+		resp.BMI = deriveBMISeries(resp.GrowthData)
 	}
 
 	if CanAccessPerson(ctx.Tx, user, resp.Person, ScopeMilestones, AccessView) {
-		resp.Milestones = GetPersonMilestonesTx(ctx.Tx, req.Id)
+		resp.Milestones = withMilestonePersonIds(ctx.Tx, user, GetPersonMilestonesTx(ctx.Tx, req.Id))
 		for i := range resp.Milestones {
 			resp.Milestones[i].PhotoIds = GetMilestonePhotoIds(ctx.Tx, resp.Milestones[i].Id)
 			resp.Milestones[i].TagIds = GetMilestoneTagIds(ctx.Tx, resp.Milestones[i].Id)
@@ -521,6 +607,7 @@ func GetPerson(ctx *vbeam.Context, req GetPersonRequest) (resp GetPersonResponse
 		for i := range resp.Photos {
 			resp.Photos[i].TagIds = GetPhotoTagIds(ctx.Tx, resp.Photos[i].Id)
 		}
+		redactPhotoLocations(ctx.Tx, user, resp.Photos)
 	}
 
 	return
@@ -546,6 +633,7 @@ func ComparePeople(ctx *vbeam.Context, req ComparePeopleRequest) (resp ComparePe
 
 	// Fetch data for each person
 	resp.People = make([]PersonComparisonData, 0, len(req.PersonIds))
+	prefs := loadUserPreferences(ctx.Tx, user.Id)
 
 	for _, personId := range req.PersonIds {
 		// Get person data
@@ -559,16 +647,17 @@ func ComparePeople(ctx *vbeam.Context, req ComparePeopleRequest) (resp ComparePe
 		}
 
 		// Calculate age
-		person.Age = calculateAge(person.Birthday)
+		person.Age = personAge(person)
 
 		// Build comparison data, each section gated on its own scope so a
 		// shared person contributes only what their link carries.
 		comparisonData := PersonComparisonData{Person: person}
 		if CanAccessPerson(ctx.Tx, user, person, ScopeGrowth, AccessView) {
-			comparisonData.GrowthData = GetPersonGrowthDataTx(ctx.Tx, personId)
+			comparisonData.GrowthData = localizeGrowthData(prefs, GetPersonGrowthWithPercentilesTx(ctx.Tx, person))
+			comparisonData.BMI = deriveBMISeries(comparisonData.GrowthData)
 		}
 		if CanAccessPerson(ctx.Tx, user, person, ScopeMilestones, AccessView) {
-			milestones := GetPersonMilestonesTx(ctx.Tx, personId)
+			milestones := withMilestonePersonIds(ctx.Tx, user, GetPersonMilestonesTx(ctx.Tx, personId))
 			for i := range milestones {
 				milestones[i].PhotoIds = GetMilestonePhotoIds(ctx.Tx, milestones[i].Id)
 			}
@@ -576,6 +665,7 @@ func ComparePeople(ctx *vbeam.Context, req ComparePeopleRequest) (resp ComparePe
 		}
 		if CanAccessPerson(ctx.Tx, user, person, ScopePhotos, AccessView) {
 			comparisonData.Photos = GetPersonImages(ctx.Tx, personId)
+			redactPhotoLocations(ctx.Tx, user, comparisonData.Photos)
 		}
 
 		resp.People = append(resp.People, comparisonData)
@@ -672,7 +762,7 @@ func SetProfilePhoto(ctx *vbeam.Context, req SetProfilePhotoRequest) (resp SetPr
 	go TriggerPersonFaceUpdate(req.PersonId)
 
 	// Calculate age for response
-	person.Age = calculateAge(person.Birthday)
+	person.Age = personAge(person)
 	resp.Person = person
 	return
 }
@@ -724,6 +814,9 @@ func MergePeople(ctx *vbeam.Context, req MergePeopleRequest) (resp MergePeopleRe
 		return
 	}
 
+	// Everything the source has is read before it moves, for the journal
+	snapshot := takeMergeSnapshot(ctx.Tx, sourcePerson, targetPerson.Id)
+
 	// Merge growth data
 	growthData := GetPersonGrowthDataTx(ctx.Tx, req.SourcePersonId)
 	for _, gd := range growthData {
@@ -733,14 +826,37 @@ func MergePeople(ctx *vbeam.Context, req MergePeopleRequest) (resp MergePeopleRe
 	}
 	resp.MergedGrowthCount = len(growthData)
 
-	// Merge milestones
-	milestones := GetPersonMilestonesTx(ctx.Tx, req.SourcePersonId)
-	for _, milestone := range milestones {
-		milestone.PersonId = req.TargetPersonId
-		vbolt.Write(ctx.Tx, MilestoneBkt, milestone.Id, &milestone)
-		vbolt.SetTargetSingleTerm(ctx.Tx, MilestoneByPersonIndex, milestone.Id, req.TargetPersonId)
+	// Two series interleave into one, so every flag is relative to a new
+	// neighbour now.
+	refreshAllGrowthFlagsTx(ctx.Tx, req.TargetPersonId)
+
+	// Merge milestones. One the two were both on is kept once, with the
+	// target on it.
+	resp.MergedMilestones = moveMilestonesToPersonTx(ctx.Tx, req.SourcePersonId, req.TargetPersonId)
+
+	// Merge prenatal measurements, for a pregnancy recorded twice
+	prenatal := GetPersonPrenatalMeasurementsTx(ctx.Tx, req.SourcePersonId)
+	for _, measurement := range prenatal {
+		measurement.PersonId = req.TargetPersonId
+		vbolt.Write(ctx.Tx, PrenatalMeasurementBkt, measurement.Id, &measurement)
+		vbolt.SetTargetSingleTerm(ctx.Tx, PrenatalByPersonIndex, measurement.Id, req.TargetPersonId)
 	}
-	resp.MergedMilestones = len(milestones)
+	resp.MergedPrenatal = len(prenatal)
+
+	// Merge vaccinations and health records
+	resp.MergedHealth = moveHealthRecordsTx(ctx.Tx, req.SourcePersonId, req.TargetPersonId)
+
+	// Merge the dental chart
+	resp.MergedTeeth = moveToothRecordsTx(ctx.Tx, req.SourcePersonId, req.TargetPersonId)
+
+	// School years move whole, with their grades and report cards
+	resp.MergedSchoolYears = moveSchoolYearsTx(ctx.Tx, req.SourcePersonId, req.TargetPersonId)
+
+	// Albums about the source become albums about the target
+	resp.MergedAlbums = moveAlbumsTx(ctx.Tx, req.SourcePersonId, req.TargetPersonId)
+
+	// Checklist achievements follow the milestones they point at
+	mergeChecklistAchievementsTx(ctx.Tx, req.SourcePersonId, req.TargetPersonId)
 
 	// Merge photo associations
 	photoPersons := GetPhotoPersonsByPerson(ctx.Tx, req.SourcePersonId)
@@ -780,16 +896,22 @@ func MergePeople(ctx *vbeam.Context, req MergePeopleRequest) (resp MergePeopleRe
 		EnsurePersonFamilyTx(ctx.Tx, req.TargetPersonId, row.FamilyId, row.Role)
 	}
 
+	// The family tree follows the surviving record too
+	movePersonRelationsTx(ctx.Tx, req.SourcePersonId, req.TargetPersonId)
+
 	// Delete source person
 	deletePersonRostersTx(ctx.Tx, req.SourcePersonId)
 	vbolt.Delete(ctx.Tx, PeopleBkt, req.SourcePersonId)
 	vbolt.SetTargetSingleTerm(ctx.Tx, PersonIndex, req.SourcePersonId, -1)
 
+	merge := snapshot.recordTx(ctx.Tx, sourcePerson.FamilyId, user.Id)
 	vbolt.TxCommit(ctx.Tx)
 
 	// Prepare response
 	resp.Success = true
-	targetPerson.Age = calculateAge(targetPerson.Birthday)
+	resp.MergeId = merge.Id
+	resp.UndoableUntil = merge.UndoableUntil()
+	targetPerson.Age = personAge(targetPerson)
 	resp.TargetPerson = targetPerson
 
 	// Log the merge operation
@@ -801,6 +923,7 @@ func MergePeople(ctx *vbeam.Context, req MergePeopleRequest) (resp MergePeopleRe
 		"mergedGrowth":     resp.MergedGrowthCount,
 		"mergedMilestones": resp.MergedMilestones,
 		"mergedPhotos":     resp.MergedPhotos,
+		"mergeId":          merge.Id,
 	})
 
 	return
@@ -818,6 +941,7 @@ func GetFamilyTimeline(ctx *vbeam.Context, req GetFamilyTimelineRequest) (resp G
 
 	// Build timeline data for each person
 	resp.People = make([]FamilyTimelineItem, 0, len(people))
+	prefs := loadUserPreferences(ctx.Tx, user.Id)
 
 	for _, person := range people {
 		// A person on the roster is not automatically a person whose records
@@ -825,8 +949,10 @@ func GetFamilyTimeline(ctx *vbeam.Context, req GetFamilyTimelineRequest) (resp G
 		// kinds of record that link carries.
 		timelineItem := FamilyTimelineItem{Person: person}
 
+		// A milestone shared by several people is listed under each of them,
+		// and its PersonIds is what lets a client draw it once.
 		if CanAccessPerson(ctx.Tx, user, person, ScopeMilestones, AccessView) {
-			timelineMilestones := GetPersonMilestonesTx(ctx.Tx, person.Id)
+			timelineMilestones := withMilestonePersonIds(ctx.Tx, user, GetPersonMilestonesTx(ctx.Tx, person.Id))
 			for i := range timelineMilestones {
 				timelineMilestones[i].PhotoIds = GetMilestonePhotoIds(ctx.Tx, timelineMilestones[i].Id)
 				timelineMilestones[i].TagIds = GetMilestoneTagIds(ctx.Tx, timelineMilestones[i].Id)
@@ -836,14 +962,22 @@ func GetFamilyTimeline(ctx *vbeam.Context, req GetFamilyTimelineRequest) (resp G
 
 		if CanAccessPerson(ctx.Tx, user, person, ScopePhotos, AccessView) {
 			timelinePhotos := GetPersonImages(ctx.Tx, person.Id)
+			sortImagesByDate(timelinePhotos)
 			for i := range timelinePhotos {
 				timelinePhotos[i].TagIds = GetPhotoTagIds(ctx.Tx, timelinePhotos[i].Id)
 			}
+			redactPhotoLocations(ctx.Tx, user, timelinePhotos)
 			timelineItem.Photos = timelinePhotos
 		}
 
 		if CanAccessPerson(ctx.Tx, user, person, ScopeGrowth, AccessView) {
-			timelineItem.GrowthData = GetPersonGrowthDataTx(ctx.Tx, person.Id)
+			timelineItem.GrowthData = localizeGrowthData(prefs, GetPersonGrowthWithPercentilesTx(ctx.Tx, person))
+		}
+
+		if CanAccessPerson(ctx.Tx, user, person, ScopeSchool, AccessView) {
+			schoolYears := GetPersonSchoolYears(ctx.Tx, person.Id)
+			sortSchoolYearsNewestFirst(schoolYears)
+			timelineItem.SchoolYears = schoolYears
 		}
 
 		resp.People = append(resp.People, timelineItem)
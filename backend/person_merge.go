@@ -0,0 +1,626 @@
+package backend
+
+import (
+	"errors"
+	"family/cfg"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// A merge moves everything hanging off one person onto another and deletes
+// the first. Done to the wrong pair it used to mean repairing the database by
+// hand, so every merge now leaves a journal: the deleted person as they were,
+// and one item per record the merge touched. UnmergePeople replays the journal
+// backwards — the person comes back under their old id, and exactly the
+// records listed return to them, however many the target has gained since.
+//
+// The journal is taken by looking, not by threading a recorder through every
+// mover: the source's records are read before the merge and read again after,
+// and a record that is still there was moved while one that is gone was
+// dropped as a duplicate of the target's. The movers stay as they were.
+
+func RegisterPersonMergeMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, ListPersonMerges)
+	vbeam.RegisterProc(app, UnmergePeople)
+}
+
+// defaultMergeUndoWindow is how long a merge can be undone. MERGE_UNDO_DAYS
+// overrides it; past the window the records have usually been edited enough
+// that putting them back would be a second surprise rather than an undo.
+const defaultMergeUndoWindow = 30 * 24 * time.Hour
+
+func mergeUndoWindow() time.Duration {
+	if days, err := strconv.Atoi(os.Getenv("MERGE_UNDO_DAYS")); err == nil && days >= 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return defaultMergeUndoWindow
+}
+
+type PersonMerge struct {
+	Id             int       `json:"id"`
+	FamilyId       int       `json:"familyId"`
+	UserId         int       `json:"userId"` // who merged
+	TargetPersonId int       `json:"targetPersonId"`
+	Source         Person    `json:"source"` // the deleted person, as they were
+	MergedAt       time.Time `json:"mergedAt"`
+	UndoneAt       time.Time `json:"undoneAt,omitempty"`
+}
+
+func PackPersonMerge(self *PersonMerge, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Int(&self.UserId, buf)
+	vpack.Int(&self.TargetPersonId, buf)
+	PackPerson(&self.Source, buf)
+	vpack.Time(&self.MergedAt, buf)
+	vpack.Time(&self.UndoneAt, buf)
+}
+
+// PersonMergeItem is one record a merge touched. RecordId is the record's own
+// id; Ref and Detail carry what a dropped record needs to be made again.
+type PersonMergeItem struct {
+	Id       int    `json:"id"`
+	MergeId  int    `json:"mergeId"`
+	Kind     string `json:"kind"`
+	RecordId int    `json:"recordId"`
+	Ref      int    `json:"ref,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func PackPersonMergeItem(self *PersonMergeItem, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.MergeId, buf)
+	vpack.String(&self.Kind, buf)
+	vpack.Int(&self.RecordId, buf)
+	vpack.Int(&self.Ref, buf)
+	vpack.String(&self.Detail, buf)
+}
+
+// Merge item kinds
+const (
+	mergeItemGrowth            = "growth"             // growth record moved
+	mergeItemPrenatal          = "prenatal"           // prenatal measurement moved
+	mergeItemMilestonePerson   = "milestone_person"   // milestone join row moved
+	mergeItemMilestoneShared   = "milestone_shared"   // RecordId: milestone both were on; the source's join was dropped
+	mergeItemMilestonePrimary  = "milestone_primary"  // RecordId: milestone whose PersonId was the source
+	mergeItemAchievement       = "achievement"        // checklist achievement moved
+	mergeItemAchievementShared = "achievement_shared" // RecordId: milestone, Detail: item key; dropped as a duplicate
+	mergeItemPhotoPerson       = "photo_person"       // photo tag moved
+	mergeItemPhotoShared       = "photo_shared"       // RecordId: photo both were tagged in, Ref: the tag's family
+	mergeItemRoster            = "roster"             // RecordId: family, Ref: role, Detail: relationship; the source's roster row
+	mergeItemRosterAdded       = "roster_added"       // roster row the merge gave the target
+	mergeItemRelation          = "relation"           // RecordId: edge, Ref: the other person, Detail: what the source was to them
+	mergeItemVaccination       = "vaccination"        // vaccination moved
+	mergeItemHealthRecord      = "health_record"      // health record moved
+	mergeItemTooth             = "tooth"              // tooth record moved
+	mergeItemToothShared       = "tooth_shared"       // Detail: the source's record of a tooth the target had; dropped
+	mergeItemSchoolYear        = "school_year"        // school year moved, with everything under it
+	mergeItemAlbum             = "album"              // album about the source, now about the target
+)
+
+var PersonMergeBkt = vbolt.Bucket(&cfg.Info, "person_merge", vpack.FInt, PackPersonMerge)
+
+// PersonMergeByFamilyIndex: term = family_id, target = merge_id
+var PersonMergeByFamilyIndex = vbolt.Index(&cfg.Info, "person_merge_by_family", vpack.FInt, vpack.FInt)
+
+var PersonMergeItemBkt = vbolt.Bucket(&cfg.Info, "person_merge_item", vpack.FInt, PackPersonMergeItem)
+
+// PersonMergeItemByMergeIndex: term = merge_id, target = item_id
+var PersonMergeItemByMergeIndex = vbolt.Index(&cfg.Info, "person_merge_item_by_merge", vpack.FInt, vpack.FInt)
+
+func getPersonMergeById(tx *vbolt.Tx, id int) (merge PersonMerge) {
+	vbolt.Read(tx, PersonMergeBkt, id, &merge)
+	return
+}
+
+func getPersonMergeItems(tx *vbolt.Tx, mergeId int) []PersonMergeItem {
+	return readByTerm(tx, PersonMergeItemByMergeIndex, PersonMergeItemBkt, mergeId)
+}
+
+// UndoableUntil is when the merge stops being undoable; zero once it has been
+// undone.
+func (merge PersonMerge) UndoableUntil() time.Time {
+	if !merge.UndoneAt.IsZero() {
+		return time.Time{}
+	}
+	return merge.MergedAt.Add(mergeUndoWindow())
+}
+
+// mergeSnapshot is the source person's side of a merge, read before anything
+// moves.
+type mergeSnapshot struct {
+	source         Person
+	targetId       int
+	growthIds      []int
+	prenatalIds    []int
+	vaccinationIds []int
+	healthIds      []int
+	teeth          []ToothRecord
+	schoolYearIds  []int
+	albumIds       []int
+	milestoneJoins []MilestonePerson
+	primaryIds     []int
+	achievements   []ChecklistAchievement
+	photoPeople    []PhotoPerson
+	rosters        []PersonFamily
+	targetFamilies map[int]bool
+	relations      []PersonRelation
+}
+
+func takeMergeSnapshot(tx *vbolt.Tx, source Person, targetId int) mergeSnapshot {
+	snap := mergeSnapshot{
+		source:         source,
+		targetId:       targetId,
+		milestoneJoins: readByTerm(tx, MilestonePersonByPersonIndex, MilestonePersonBkt, source.Id),
+		achievements:   getPersonChecklistAchievements(tx, source.Id),
+		photoPeople:    GetPhotoPersonsByPerson(tx, source.Id),
+		rosters:        GetPersonFamilies(tx, source.Id),
+		targetFamilies: map[int]bool{},
+		relations:      GetPersonRelations(tx, source.Id),
+		teeth:          GetPersonToothRecords(tx, source.Id),
+	}
+	for _, gd := range GetPersonGrowthDataTx(tx, source.Id) {
+		snap.growthIds = append(snap.growthIds, gd.Id)
+	}
+	for _, measurement := range GetPersonPrenatalMeasurementsTx(tx, source.Id) {
+		snap.prenatalIds = append(snap.prenatalIds, measurement.Id)
+	}
+	for _, vaccination := range GetPersonVaccinations(tx, source.Id) {
+		snap.vaccinationIds = append(snap.vaccinationIds, vaccination.Id)
+	}
+	for _, record := range GetPersonHealthRecords(tx, source.Id) {
+		snap.healthIds = append(snap.healthIds, record.Id)
+	}
+	for _, year := range GetPersonSchoolYears(tx, source.Id) {
+		snap.schoolYearIds = append(snap.schoolYearIds, year.Id)
+	}
+	for _, album := range GetPersonAlbums(tx, source.Id) {
+		snap.albumIds = append(snap.albumIds, album.Id)
+	}
+	for _, row := range snap.milestoneJoins {
+		if GetMilestoneById(tx, row.MilestoneId).PersonId == source.Id {
+			snap.primaryIds = append(snap.primaryIds, row.MilestoneId)
+		}
+	}
+	for _, row := range GetPersonFamilies(tx, targetId) {
+		snap.targetFamilies[row.FamilyId] = true
+	}
+	return snap
+}
+
+// recordTx compares the snapshot with what the merge left and writes the
+// journal.
+func (snap mergeSnapshot) recordTx(tx *vbolt.Tx, familyId int, userId int) PersonMerge {
+	merge := PersonMerge{
+		Id:             vbolt.NextIntId(tx, PersonMergeBkt),
+		FamilyId:       familyId,
+		UserId:         userId,
+		TargetPersonId: snap.targetId,
+		Source:         snap.source,
+		MergedAt:       time.Now(),
+	}
+	vbolt.Write(tx, PersonMergeBkt, merge.Id, &merge)
+	vbolt.SetTargetSingleTerm(tx, PersonMergeByFamilyIndex, merge.Id, familyId)
+
+	add := func(kind string, recordId int, ref int, detail string) {
+		item := PersonMergeItem{
+			Id:       vbolt.NextIntId(tx, PersonMergeItemBkt),
+			MergeId:  merge.Id,
+			Kind:     kind,
+			RecordId: recordId,
+			Ref:      ref,
+			Detail:   detail,
+		}
+		vbolt.Write(tx, PersonMergeItemBkt, item.Id, &item)
+		vbolt.SetTargetSingleTerm(tx, PersonMergeItemByMergeIndex, item.Id, merge.Id)
+	}
+
+	for _, id := range snap.growthIds {
+		add(mergeItemGrowth, id, 0, "")
+	}
+	for _, id := range snap.prenatalIds {
+		add(mergeItemPrenatal, id, 0, "")
+	}
+	for _, id := range snap.vaccinationIds {
+		add(mergeItemVaccination, id, 0, "")
+	}
+	for _, id := range snap.healthIds {
+		add(mergeItemHealthRecord, id, 0, "")
+	}
+	for _, id := range snap.schoolYearIds {
+		add(mergeItemSchoolYear, id, 0, "")
+	}
+	for _, id := range snap.albumIds {
+		add(mergeItemAlbum, id, 0, "")
+	}
+	for _, record := range snap.teeth {
+		var after ToothRecord
+		if vbolt.Read(tx, ToothRecordBkt, record.Id, &after) {
+			add(mergeItemTooth, record.Id, 0, "")
+		} else {
+			add(mergeItemToothShared, record.Id, 0, encodeMergedTooth(record))
+		}
+	}
+	for _, row := range snap.milestoneJoins {
+		var after MilestonePerson
+		if vbolt.Read(tx, MilestonePersonBkt, row.Id, &after) {
+			add(mergeItemMilestonePerson, row.Id, 0, "")
+		} else {
+			add(mergeItemMilestoneShared, row.MilestoneId, 0, "")
+		}
+	}
+	for _, id := range snap.primaryIds {
+		add(mergeItemMilestonePrimary, id, 0, "")
+	}
+	for _, achievement := range snap.achievements {
+		var after ChecklistAchievement
+		if vbolt.Read(tx, ChecklistAchievementBkt, achievement.Id, &after) {
+			add(mergeItemAchievement, achievement.Id, 0, "")
+		} else {
+			add(mergeItemAchievementShared, achievement.MilestoneId, 0, achievement.ItemKey)
+		}
+	}
+	for _, photoPerson := range snap.photoPeople {
+		var after PhotoPerson
+		if vbolt.Read(tx, PhotoPersonBkt, photoPerson.Id, &after) {
+			add(mergeItemPhotoPerson, photoPerson.Id, 0, "")
+		} else {
+			add(mergeItemPhotoShared, photoPerson.PhotoId, photoPerson.FamilyId, "")
+		}
+	}
+	for _, row := range snap.rosters {
+		add(mergeItemRoster, row.FamilyId, int(row.Role), row.Relationship)
+	}
+	for _, row := range GetPersonFamilies(tx, snap.targetId) {
+		if !snap.targetFamilies[row.FamilyId] {
+			add(mergeItemRosterAdded, row.Id, 0, "")
+		}
+	}
+	for _, relation := range snap.relations {
+		switch {
+		case relation.Type == RelationPartner:
+			other := relation.FromPersonId
+			if other == snap.source.Id {
+				other = relation.ToPersonId
+			}
+			add(mergeItemRelation, relation.Id, other, "partner")
+		case relation.FromPersonId == snap.source.Id:
+			add(mergeItemRelation, relation.Id, relation.ToPersonId, "parent")
+		default:
+			add(mergeItemRelation, relation.Id, relation.FromPersonId, "child")
+		}
+	}
+	return merge
+}
+
+// encodeMergedTooth packs a dropped tooth record into an item's Detail as
+// "tooth|erupted|lost|notes", the notes last since they may hold anything.
+func encodeMergedTooth(record ToothRecord) string {
+	date := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	}
+	return strings.Join([]string{record.Tooth, date(record.EruptedDate), date(record.LostDate), record.Notes}, "|")
+}
+
+func decodeMergedTooth(detail string) (record ToothRecord, ok bool) {
+	parts := strings.SplitN(detail, "|", 4)
+	if len(parts) != 4 {
+		return record, false
+	}
+	record.Tooth = parts[0]
+	record.EruptedDate, _ = time.Parse("2006-01-02", parts[1])
+	record.LostDate, _ = time.Parse("2006-01-02", parts[2])
+	record.Notes = parts[3]
+	return record, true
+}
+
+// unmergePeopleTx brings the source person back and returns to them the
+// records the journal lists. A record is only taken back while it still
+// belongs to the target: one deleted or re-tagged since stays where it is.
+func unmergePeopleTx(tx *vbolt.Tx, merge PersonMerge, now time.Time) error {
+	if !merge.UndoneAt.IsZero() {
+		return errors.New("This merge has already been undone")
+	}
+	if now.After(merge.UndoableUntil()) {
+		return errors.New("This merge is too old to undo")
+	}
+	sourceId, targetId := merge.Source.Id, merge.TargetPersonId
+	if GetPersonById(tx, sourceId).Id != 0 {
+		return errors.New("This merge has already been undone")
+	}
+	if GetPersonById(tx, targetId).Id == 0 {
+		return errors.New("The person this was merged into no longer exists")
+	}
+
+	source := merge.Source
+	vbolt.Write(tx, PeopleBkt, source.Id, &source)
+	vbolt.SetTargetSingleTerm(tx, PersonIndex, source.Id, source.FamilyId)
+
+	touchedMilestones := map[int]bool{}
+	var relations []PersonMergeItem
+	for _, item := range getPersonMergeItems(tx, merge.Id) {
+		switch item.Kind {
+		case mergeItemGrowth:
+			gd := GetGrowthDataById(tx, item.RecordId)
+			if gd.PersonId == targetId {
+				gd.PersonId = sourceId
+				vbolt.Write(tx, GrowthDataBkt, gd.Id, &gd)
+				vbolt.SetTargetSingleTerm(tx, GrowthDataByPersonIndex, gd.Id, sourceId)
+			}
+		case mergeItemPrenatal:
+			var measurement PrenatalMeasurement
+			if vbolt.Read(tx, PrenatalMeasurementBkt, item.RecordId, &measurement) && measurement.PersonId == targetId {
+				measurement.PersonId = sourceId
+				vbolt.Write(tx, PrenatalMeasurementBkt, measurement.Id, &measurement)
+				vbolt.SetTargetSingleTerm(tx, PrenatalByPersonIndex, measurement.Id, sourceId)
+			}
+		case mergeItemVaccination:
+			vaccination := GetVaccinationById(tx, item.RecordId)
+			if vaccination.PersonId == targetId {
+				vaccination.PersonId = sourceId
+				writeVaccinationTx(tx, &vaccination)
+			}
+		case mergeItemHealthRecord:
+			record := GetHealthRecordById(tx, item.RecordId)
+			if record.PersonId == targetId {
+				record.PersonId = sourceId
+				writeHealthRecordTx(tx, &record)
+			}
+		case mergeItemTooth:
+			var record ToothRecord
+			if vbolt.Read(tx, ToothRecordBkt, item.RecordId, &record) && record.PersonId == targetId {
+				record.PersonId = sourceId
+				writeToothRecordTx(tx, &record)
+			}
+		case mergeItemToothShared:
+			// The dates the merge filled in on the target's record stay
+			// there; the source gets its own record back beside it.
+			record, ok := decodeMergedTooth(item.Detail)
+			if _, found := findPersonToothRecord(tx, sourceId, record.Tooth); ok && !found {
+				record.PersonId = sourceId
+				record.FamilyId = source.FamilyId
+				writeToothRecordTx(tx, &record)
+			}
+		case mergeItemSchoolYear:
+			year := GetSchoolYearById(tx, item.RecordId)
+			if year.PersonId == targetId {
+				year.PersonId = sourceId
+				writeSchoolYearTx(tx, &year)
+			}
+		case mergeItemAlbum:
+			album := GetAlbumById(tx, item.RecordId)
+			if album.PersonId == targetId {
+				album.PersonId = sourceId
+				writeAlbumTx(tx, &album)
+			}
+		case mergeItemMilestonePerson:
+			var row MilestonePerson
+			if vbolt.Read(tx, MilestonePersonBkt, item.RecordId, &row) && row.PersonId == targetId {
+				row.PersonId = sourceId
+				vbolt.Write(tx, MilestonePersonBkt, row.Id, &row)
+				vbolt.SetTargetSingleTerm(tx, MilestonePersonByPersonIndex, row.Id, sourceId)
+				touchedMilestones[row.MilestoneId] = true
+			}
+		case mergeItemMilestoneShared:
+			milestone := GetMilestoneById(tx, item.RecordId)
+			if milestone.Id != 0 && !slices.Contains(GetMilestonePersonIds(tx, milestone.Id), sourceId) {
+				addMilestonePersonTx(tx, milestone, sourceId)
+				touchedMilestones[milestone.Id] = true
+			}
+		case mergeItemMilestonePrimary:
+			milestone := GetMilestoneById(tx, item.RecordId)
+			if milestone.PersonId == targetId {
+				milestone.PersonId = sourceId
+				vbolt.Write(tx, MilestoneBkt, milestone.Id, &milestone)
+				touchedMilestones[milestone.Id] = true
+			}
+		case mergeItemAchievement:
+			var achievement ChecklistAchievement
+			if vbolt.Read(tx, ChecklistAchievementBkt, item.RecordId, &achievement) && achievement.PersonId == targetId {
+				achievement.PersonId = sourceId
+				vbolt.Write(tx, ChecklistAchievementBkt, achievement.Id, &achievement)
+				vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByPersonIndex, achievement.Id, sourceId)
+			}
+		case mergeItemAchievementShared:
+			milestone := GetMilestoneById(tx, item.RecordId)
+			if milestone.Id == 0 {
+				continue
+			}
+			achievement := ChecklistAchievement{
+				Id:          vbolt.NextIntId(tx, ChecklistAchievementBkt),
+				PersonId:    sourceId,
+				FamilyId:    milestone.FamilyId,
+				ItemKey:     item.Detail,
+				MilestoneId: milestone.Id,
+				CreatedAt:   time.Now(),
+			}
+			vbolt.Write(tx, ChecklistAchievementBkt, achievement.Id, &achievement)
+			vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByPersonIndex, achievement.Id, achievement.PersonId)
+			vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByMilestoneIndex, achievement.Id, achievement.MilestoneId)
+			vbolt.SetTargetSingleTerm(tx, ChecklistAchievementByFamilyIndex, achievement.Id, achievement.FamilyId)
+		case mergeItemPhotoPerson:
+			var photoPerson PhotoPerson
+			if vbolt.Read(tx, PhotoPersonBkt, item.RecordId, &photoPerson) && photoPerson.PersonId == targetId {
+				photoPerson.PersonId = sourceId
+				vbolt.Write(tx, PhotoPersonBkt, photoPerson.Id, &photoPerson)
+				vbolt.SetTargetSingleTerm(tx, PhotoPersonByPersonIndex, photoPerson.Id, sourceId)
+			}
+		case mergeItemPhotoShared:
+			var image Image
+			if vbolt.Read(tx, ImagesBkt, item.RecordId, &image) {
+				AddPersonToPhoto(tx, image.Id, sourceId, item.Ref)
+			}
+		case mergeItemRoster:
+			if _, onRoster := FindPersonFamily(tx, sourceId, item.RecordId); !onRoster {
+				addPersonFamilyTx(tx, sourceId, item.RecordId, PersonType(item.Ref), item.Detail, time.Now())
+			}
+		case mergeItemRosterAdded:
+			var row PersonFamily
+			if vbolt.Read(tx, PersonFamilyBkt, item.RecordId, &row) && row.PersonId == targetId {
+				deletePersonFamilyTx(tx, row)
+			}
+		case mergeItemRelation:
+			relations = append(relations, item)
+		}
+	}
+
+	// Edges last: the moved copies come off the target before the originals
+	// go back, so the duplicate check does not count one against the other.
+	for _, item := range relations {
+		if relation := getPersonRelationById(tx, item.RecordId); relation.Id != 0 {
+			deletePersonRelationTx(tx, relation)
+		}
+	}
+	for _, item := range relations {
+		if GetPersonById(tx, item.Ref).Id == 0 {
+			continue
+		}
+		switch item.Detail {
+		case "parent":
+			AddPersonRelationTx(tx, RelationParent, sourceId, item.Ref, source.FamilyId)
+		case "child":
+			AddPersonRelationTx(tx, RelationParent, item.Ref, sourceId, source.FamilyId)
+		case "partner":
+			AddPersonRelationTx(tx, RelationPartner, sourceId, item.Ref, source.FamilyId)
+		}
+	}
+
+	for milestoneId := range touchedMilestones {
+		UpdateMilestoneSearchIndex(tx, GetMilestoneById(tx, milestoneId))
+	}
+	refreshAllGrowthFlagsTx(tx, sourceId)
+	refreshAllGrowthFlagsTx(tx, targetId)
+
+	merge.UndoneAt = now
+	vbolt.Write(tx, PersonMergeBkt, merge.Id, &merge)
+	return nil
+}
+
+// deleteFamilyPersonMergesTx removes a family's merge journal, which holds
+// copies of people the family deleted.
+func deleteFamilyPersonMergesTx(tx *vbolt.Tx, familyId int) {
+	for _, merge := range readByTerm(tx, PersonMergeByFamilyIndex, PersonMergeBkt, familyId) {
+		for _, item := range getPersonMergeItems(tx, merge.Id) {
+			vbolt.SetTargetSingleTerm(tx, PersonMergeItemByMergeIndex, item.Id, -1)
+			vbolt.Delete(tx, PersonMergeItemBkt, item.Id)
+		}
+		vbolt.SetTargetSingleTerm(tx, PersonMergeByFamilyIndex, merge.Id, -1)
+		vbolt.Delete(tx, PersonMergeBkt, merge.Id)
+	}
+}
+
+// Request/Response types
+
+type ListPersonMergesRequest struct {
+	FamilyId int `json:"familyId,omitempty"`
+}
+
+type PersonMergeSummary struct {
+	Id               int       `json:"id"`
+	SourcePersonId   int       `json:"sourcePersonId"`
+	SourcePersonName string    `json:"sourcePersonName"`
+	TargetPersonId   int       `json:"targetPersonId"`
+	TargetPersonName string    `json:"targetPersonName"`
+	MergedAt         time.Time `json:"mergedAt"`
+	UndoneAt         time.Time `json:"undoneAt,omitempty"`
+	UndoableUntil    time.Time `json:"undoableUntil,omitempty"`
+	Undoable         bool      `json:"undoable"`
+	ItemCount        int       `json:"itemCount"`
+}
+
+type ListPersonMergesResponse struct {
+	Merges []PersonMergeSummary `json:"merges"` // newest first
+}
+
+type UnmergePeopleRequest struct {
+	MergeId int `json:"mergeId"`
+}
+
+type UnmergePeopleResponse struct {
+	Success      bool   `json:"success"`
+	SourcePerson Person `json:"sourcePerson"`
+	TargetPerson Person `json:"targetPerson"`
+}
+
+// vbeam procedures
+
+func ListPersonMerges(ctx *vbeam.Context, req ListPersonMergesRequest) (resp ListPersonMergesResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ResolveActingFamily(ctx.Tx, user, req.FamilyId, AccessAdmin)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	resp.Merges = []PersonMergeSummary{}
+	for _, merge := range readByTerm(ctx.Tx, PersonMergeByFamilyIndex, PersonMergeBkt, familyId) {
+		until := merge.UndoableUntil()
+		resp.Merges = append(resp.Merges, PersonMergeSummary{
+			Id:               merge.Id,
+			SourcePersonId:   merge.Source.Id,
+			SourcePersonName: merge.Source.Name,
+			TargetPersonId:   merge.TargetPersonId,
+			TargetPersonName: GetPersonById(ctx.Tx, merge.TargetPersonId).Name,
+			MergedAt:         merge.MergedAt,
+			UndoneAt:         merge.UndoneAt,
+			UndoableUntil:    until,
+			Undoable:         !until.IsZero() && now.Before(until),
+			ItemCount:        len(getPersonMergeItems(ctx.Tx, merge.Id)),
+		})
+	}
+	slices.SortFunc(resp.Merges, func(a, b PersonMergeSummary) int { return b.MergedAt.Compare(a.MergedAt) })
+	return
+}
+
+// UnmergePeople undoes a merge within the window. Like the merge itself it
+// takes admin rights in the family.
+func UnmergePeople(ctx *vbeam.Context, req UnmergePeopleRequest) (resp UnmergePeopleResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	merge := getPersonMergeById(ctx.Tx, req.MergeId)
+	if merge.Id == 0 || !CanAccessFamily(ctx.Tx, user, merge.FamilyId, AccessAdmin) {
+		err = errors.New("Merge not found")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	if err = unmergePeopleTx(ctx.Tx, merge, time.Now()); err != nil {
+		return
+	}
+	resp.SourcePerson = GetPersonById(ctx.Tx, merge.Source.Id)
+	resp.TargetPerson = GetPersonById(ctx.Tx, merge.TargetPersonId)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Success = true
+	resp.SourcePerson.Age = personAge(resp.SourcePerson)
+	resp.TargetPerson.Age = personAge(resp.TargetPerson)
+
+	LogInfo("DATA", "People unmerged", map[string]any{
+		"userId":         user.Id,
+		"mergeId":        merge.Id,
+		"sourcePersonId": merge.Source.Id,
+		"targetPersonId": merge.TargetPersonId,
+	})
+	return
+}
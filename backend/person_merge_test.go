@@ -0,0 +1,194 @@
+package backend
+
+import (
+	"slices"
+	"testing"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+// mergeFixture is the provenance fixture's child entered twice: the duplicate
+// has a measurement, a milestone of its own, one shared with the original,
+// two photos — one the original is also tagged in — and a parent in the tree.
+type mergeFixture struct {
+	provenanceFixture
+	duplicate       Person
+	mom             Person
+	growth          GrowthData
+	ownMilestone    Milestone
+	sharedMilestone Milestone
+	ownPhoto        Image
+}
+
+func setupMergeFixture(t *testing.T) mergeFixture {
+	t.Helper()
+	fx := mergeFixture{provenanceFixture: setupProvenanceFixture(t)}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		familyId := fx.parent.FamilyId
+		if fx.duplicate, err = AddPersonTx(tx, AddPersonRequest{Name: "Kiddo", PersonType: 1, Gender: 0, Birthdate: "2020-01-01"}, familyId); err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		if fx.mom, err = AddPersonTx(tx, AddPersonRequest{Name: "Mom", PersonType: 0, Gender: 1, Birthdate: "1990-01-01"}, familyId); err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		if _, err = AddPersonRelationTx(tx, RelationParent, fx.mom.Id, fx.duplicate.Id, familyId); err != nil {
+			t.Fatalf("AddPersonRelationTx() error = %v", err)
+		}
+
+		if fx.growth, err = AddGrowthDataTx(tx, AddGrowthDataRequest{
+			PersonId: fx.duplicate.Id, MeasurementType: "height", Value: 80, Unit: "cm",
+			InputType: "date", MeasurementDate: stringPtr("2021-06-01"),
+		}, familyId); err != nil {
+			t.Fatalf("AddGrowthDataTx() error = %v", err)
+		}
+		if fx.ownMilestone, err = AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.duplicate.Id, Description: "Walked", Category: "development", InputType: "today",
+		}, familyId); err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+		if fx.sharedMilestone, err = AddMilestoneTx(tx, AddMilestoneRequest{
+			PersonId: fx.child.Id, PersonIds: []int{fx.duplicate.Id}, Description: "Beach day", Category: "other", InputType: "today",
+		}, familyId); err != nil {
+			t.Fatalf("AddMilestoneTx() error = %v", err)
+		}
+
+		fx.ownPhoto = writeTestImage(tx, familyId, fx.parent.Id, "own.jpg")
+		AddPersonToPhoto(tx, fx.ownPhoto.Id, fx.duplicate.Id, familyId)
+		AddPersonToPhoto(tx, fx.printout.Id, fx.duplicate.Id, familyId)
+		AddPersonToPhoto(tx, fx.printout.Id, fx.child.Id, familyId)
+		vbolt.TxCommit(tx)
+	})
+	return fx
+}
+
+func photoPersonIds(tx *vbolt.Tx, photoId int) (ids []int) {
+	for _, photoPerson := range GetPhotoPersonsByPhoto(tx, photoId) {
+		ids = append(ids, photoPerson.PersonId)
+	}
+	return
+}
+
+func (fx mergeFixture) merge(t *testing.T) MergePeopleResponse {
+	t.Helper()
+	var resp MergePeopleResponse
+	fx.call(t, func(ctx *vbeam.Context) {
+		var err error
+		if resp, err = MergePeople(ctx, MergePeopleRequest{SourcePersonId: fx.duplicate.Id, TargetPersonId: fx.child.Id}); err != nil {
+			t.Fatalf("MergePeople() error = %v", err)
+		}
+	})
+	return resp
+}
+
+func TestUnmergeRestoresExactlyWhatMoved(t *testing.T) {
+	fx := setupMergeFixture(t)
+	merged := fx.merge(t)
+	if merged.MergeId == 0 || merged.UndoableUntil.IsZero() {
+		t.Fatalf("merge response = %+v", merged)
+	}
+
+	// Something recorded against the merged person afterwards stays with them.
+	var later GrowthData
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		if later, err = AddGrowthDataTx(tx, AddGrowthDataRequest{
+			PersonId: fx.child.Id, MeasurementType: "height", Value: 95, Unit: "cm",
+			InputType: "date", MeasurementDate: stringPtr("2022-06-01"),
+		}, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddGrowthDataTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		resp, err := UnmergePeople(ctx, UnmergePeopleRequest{MergeId: merged.MergeId})
+		if err != nil {
+			t.Fatalf("UnmergePeople() error = %v", err)
+		}
+		if resp.SourcePerson.Id != fx.duplicate.Id || resp.SourcePerson.Name != "Kiddo" {
+			t.Errorf("restored %+v", resp.SourcePerson)
+		}
+	})
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if GetGrowthDataById(tx, fx.growth.Id).PersonId != fx.duplicate.Id {
+			t.Error("the duplicate's measurement was not returned")
+		}
+		if GetGrowthDataById(tx, later.Id).PersonId != fx.child.Id {
+			t.Error("a measurement recorded after the merge was taken back")
+		}
+
+		own := GetMilestoneById(tx, fx.ownMilestone.Id)
+		if own.PersonId != fx.duplicate.Id || !slices.Equal(GetMilestonePersonIds(tx, own.Id), []int{fx.duplicate.Id}) {
+			t.Errorf("own milestone person = %d, people %v", own.PersonId, GetMilestonePersonIds(tx, own.Id))
+		}
+		shared := GetMilestonePersonIds(tx, fx.sharedMilestone.Id)
+		if !slices.Contains(shared, fx.child.Id) || !slices.Contains(shared, fx.duplicate.Id) {
+			t.Errorf("shared milestone people = %v", shared)
+		}
+
+		if ids := photoPersonIds(tx, fx.ownPhoto.Id); !slices.Equal(ids, []int{fx.duplicate.Id}) {
+			t.Errorf("own photo tagged %v", ids)
+		}
+		if ids := photoPersonIds(tx, fx.printout.Id); len(ids) != 2 {
+			t.Errorf("shared photo tagged %v", ids)
+		}
+
+		if _, onRoster := FindPersonFamily(tx, fx.duplicate.Id, fx.parent.FamilyId); !onRoster {
+			t.Error("the restored person is not on their home roster")
+		}
+		if parents := GetParentIds(tx, fx.duplicate.Id); !slices.Equal(parents, []int{fx.mom.Id}) {
+			t.Errorf("restored parents = %v", parents)
+		}
+		if parents := GetParentIds(tx, fx.child.Id); len(parents) != 0 {
+			t.Errorf("the merged person kept the duplicate's parents: %v", parents)
+		}
+	})
+
+	// Once undone, it cannot be undone again, and the list says so.
+	fx.call(t, func(ctx *vbeam.Context) {
+		if _, err := UnmergePeople(ctx, UnmergePeopleRequest{MergeId: merged.MergeId}); err == nil {
+			t.Error("a merge was undone twice")
+		}
+		list, err := ListPersonMerges(ctx, ListPersonMergesRequest{})
+		if err != nil {
+			t.Fatalf("ListPersonMerges() error = %v", err)
+		}
+		if len(list.Merges) != 1 || list.Merges[0].Undoable || list.Merges[0].UndoneAt.IsZero() {
+			t.Errorf("merges = %+v", list.Merges)
+		}
+	})
+}
+
+func TestUnmergeRespectsTheWindow(t *testing.T) {
+	fx := setupMergeFixture(t)
+	t.Setenv("MERGE_UNDO_DAYS", "0")
+	merged := fx.merge(t)
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		if _, err := UnmergePeople(ctx, UnmergePeopleRequest{MergeId: merged.MergeId}); err == nil {
+			t.Error("a merge was undone after its window closed")
+		}
+	})
+}
+
+func TestUnmergeNeedsFamilyAdmin(t *testing.T) {
+	fx := setupMergeFixture(t)
+	merged := fx.merge(t)
+
+	callAsUser(t, fx.db, fx.outsider, func(ctx *vbeam.Context) {
+		if _, err := UnmergePeople(ctx, UnmergePeopleRequest{MergeId: merged.MergeId}); err == nil {
+			t.Error("an outsider undid another family's merge")
+		}
+		list, err := ListPersonMerges(ctx, ListPersonMergesRequest{})
+		if err != nil {
+			t.Fatalf("ListPersonMerges() error = %v", err)
+		}
+		if len(list.Merges) != 0 {
+			t.Errorf("an outsider sees %d merges", len(list.Merges))
+		}
+	})
+}
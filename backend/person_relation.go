@@ -0,0 +1,207 @@
+package backend
+
+import (
+	"errors"
+	"family/cfg"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// PersonRelation is one edge of the family tree: a parent and their child, or
+// two partners.
+//
+// The roster says who appears in a family; it does not say how they are
+// related, and PersonFamily.Relationship is a label someone typed relative to
+// one roster. Edges are between Person records, so they cross households the
+// way families do: a grandmother homed in her own family is the parent of a
+// father homed in his.
+//
+// Only parent and partner are stored. Siblings are people who share a parent,
+// and grandparents, aunts and cousins are walks over parent edges; storing any
+// of them would be a second answer that could disagree with the first.
+//
+// FamilyId is the family that recorded the edge, always the home family of one
+// of its two people, so deleting that person takes the edge with them.
+type PersonRelation struct {
+	Id           int          `json:"id"`
+	Type         RelationType `json:"type"`
+	FromPersonId int          `json:"fromPersonId"` // the parent, or the lower id of two partners
+	ToPersonId   int          `json:"toPersonId"`   // the child, or the higher id of two partners
+	FamilyId     int          `json:"familyId"`
+	CreatedAt    time.Time    `json:"createdAt"`
+}
+
+type RelationType int
+
+const (
+	RelationParent RelationType = iota // FromPerson is a parent of ToPerson
+	RelationPartner
+)
+
+func PackPersonRelation(self *PersonRelation, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.IntEnum(&self.Type, buf)
+	vpack.Int(&self.FromPersonId, buf)
+	vpack.Int(&self.ToPersonId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var PersonRelationBkt = vbolt.Bucket(&cfg.Info, "person_relation", vpack.FInt, PackPersonRelation)
+
+// PersonRelationByFromIndex: term = from_person_id, target = relation_id
+var PersonRelationByFromIndex = vbolt.Index(&cfg.Info, "person_relation_by_from", vpack.FInt, vpack.FInt)
+
+// PersonRelationByToIndex: term = to_person_id, target = relation_id
+var PersonRelationByToIndex = vbolt.Index(&cfg.Info, "person_relation_by_to", vpack.FInt, vpack.FInt)
+
+var ErrRelationCycle = errors.New("That would make someone their own ancestor")
+var ErrRelationExists = errors.New("These people are already related that way")
+
+// GetPersonRelations returns every edge with the person on either end.
+func GetPersonRelations(tx *vbolt.Tx, personId int) []PersonRelation {
+	relations := readByTerm(tx, PersonRelationByFromIndex, PersonRelationBkt, personId)
+	return append(relations, readByTerm(tx, PersonRelationByToIndex, PersonRelationBkt, personId)...)
+}
+
+func getPersonRelationById(tx *vbolt.Tx, id int) (relation PersonRelation) {
+	vbolt.Read(tx, PersonRelationBkt, id, &relation)
+	return
+}
+
+// GetParentIds returns the ids of the person's recorded parents.
+func GetParentIds(tx *vbolt.Tx, personId int) (ids []int) {
+	for _, relation := range readByTerm(tx, PersonRelationByToIndex, PersonRelationBkt, personId) {
+		if relation.Type == RelationParent {
+			ids = append(ids, relation.FromPersonId)
+		}
+	}
+	return
+}
+
+// normalizeRelation puts a partner edge's two people in id order, so the same
+// pair is always stored the same way round.
+func normalizeRelation(relation PersonRelation) PersonRelation {
+	if relation.Type == RelationPartner && relation.FromPersonId > relation.ToPersonId {
+		relation.FromPersonId, relation.ToPersonId = relation.ToPersonId, relation.FromPersonId
+	}
+	return relation
+}
+
+// isAncestorTx reports whether ancestorId is reached by walking up parent
+// edges from personId, the person themselves included.
+func isAncestorTx(tx *vbolt.Tx, ancestorId int, personId int) bool {
+	seen := map[int]bool{}
+	queue := []int{personId}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == ancestorId {
+			return true
+		}
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		queue = append(queue, GetParentIds(tx, current)...)
+	}
+	return false
+}
+
+// validatePersonRelationTx checks a new edge against the ones already stored.
+// Parent edges must stay acyclic: nobody may end up above themselves in the
+// tree, however many generations the loop would span.
+func validatePersonRelationTx(tx *vbolt.Tx, relation PersonRelation) error {
+	if relation.Type != RelationParent && relation.Type != RelationPartner {
+		return errors.New("Relationship must be parent or partner")
+	}
+	if relation.FromPersonId == relation.ToPersonId {
+		return errors.New("A person cannot be related to themselves")
+	}
+	for _, existing := range GetPersonRelations(tx, relation.FromPersonId) {
+		if existing.Id == relation.Id {
+			continue
+		}
+		if existing.Type != relation.Type {
+			continue
+		}
+		// A partner edge has no direction, so either order is the same
+		// pair. A parent edge pointing the other way is a different edge;
+		// the cycle check below rejects it.
+		samePair := existing.FromPersonId == relation.FromPersonId && existing.ToPersonId == relation.ToPersonId
+		if relation.Type == RelationPartner {
+			samePair = samePair || (existing.FromPersonId == relation.ToPersonId && existing.ToPersonId == relation.FromPersonId)
+		}
+		if samePair {
+			return ErrRelationExists
+		}
+	}
+	if relation.Type == RelationParent && isAncestorTx(tx, relation.ToPersonId, relation.FromPersonId) {
+		return ErrRelationCycle
+	}
+	return nil
+}
+
+func writePersonRelationTx(tx *vbolt.Tx, relation *PersonRelation) {
+	if relation.Id == 0 {
+		relation.Id = vbolt.NextIntId(tx, PersonRelationBkt)
+		relation.CreatedAt = time.Now()
+	}
+	vbolt.Write(tx, PersonRelationBkt, relation.Id, relation)
+	vbolt.SetTargetSingleTerm(tx, PersonRelationByFromIndex, relation.Id, relation.FromPersonId)
+	vbolt.SetTargetSingleTerm(tx, PersonRelationByToIndex, relation.Id, relation.ToPersonId)
+}
+
+// AddPersonRelationTx validates and stores an edge recorded by familyId.
+func AddPersonRelationTx(tx *vbolt.Tx, relationType RelationType, fromPersonId int, toPersonId int, familyId int) (PersonRelation, error) {
+	relation := normalizeRelation(PersonRelation{
+		Type:         relationType,
+		FromPersonId: fromPersonId,
+		ToPersonId:   toPersonId,
+		FamilyId:     familyId,
+	})
+	if err := validatePersonRelationTx(tx, relation); err != nil {
+		return relation, err
+	}
+	writePersonRelationTx(tx, &relation)
+	return relation, nil
+}
+
+func deletePersonRelationTx(tx *vbolt.Tx, relation PersonRelation) {
+	vbolt.SetTargetSingleTerm(tx, PersonRelationByFromIndex, relation.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, PersonRelationByToIndex, relation.Id, -1)
+	vbolt.Delete(tx, PersonRelationBkt, relation.Id)
+}
+
+// deletePersonRelationsTx removes every edge touching a person, for use when
+// the person record itself goes away.
+func deletePersonRelationsTx(tx *vbolt.Tx, personId int) {
+	for _, relation := range GetPersonRelations(tx, personId) {
+		deletePersonRelationTx(tx, relation)
+	}
+}
+
+// movePersonRelationsTx hands the source's edges to the target of a merge. An
+// edge the target already has, or one that would now loop back on itself, is
+// dropped rather than kept twice or left as a cycle.
+func movePersonRelationsTx(tx *vbolt.Tx, sourceId int, targetId int) {
+	for _, relation := range GetPersonRelations(tx, sourceId) {
+		moved := relation
+		if moved.FromPersonId == sourceId {
+			moved.FromPersonId = targetId
+		}
+		if moved.ToPersonId == sourceId {
+			moved.ToPersonId = targetId
+		}
+		moved = normalizeRelation(moved)
+		// The old edge goes first so it is not counted against the new one.
+		deletePersonRelationTx(tx, relation)
+		if validatePersonRelationTx(tx, moved) != nil {
+			continue
+		}
+		writePersonRelationTx(tx, &moved)
+	}
+}
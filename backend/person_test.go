@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"go.hasen.dev/vbeam"
 	"go.hasen.dev/vbolt"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -184,6 +185,120 @@ func TestCalculatePersonAgePregnancyPastDue(t *testing.T) {
 	}
 }
 
+func TestPersonAgeStopsAtDeath(t *testing.T) {
+	grandma := Person{
+		Birthday:    time.Date(1940, 3, 1, 0, 0, 0, 0, time.UTC),
+		DateOfDeath: time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if age := personAge(grandma); age != "80 years" {
+		t.Errorf("age at death = %q, want 80 years", age)
+	}
+
+	// A pregnancy that was lost keeps the weeks it reached.
+	loss := Person{
+		Birthday:    time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+		DateOfDeath: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+		IsPregnancy: true,
+	}
+	if age := personAge(loss); age != "27 weeks" {
+		t.Errorf("weeks reached = %q, want 27 weeks", age)
+	}
+
+	living := Person{Birthday: time.Now().AddDate(-20, 0, 0)}
+	if age := personAge(living); age != "20 years" {
+		t.Errorf("living age = %q, want 20 years", age)
+	}
+}
+
+func TestParseDateOfDeath(t *testing.T) {
+	birthday := time.Date(1940, 3, 1, 0, 0, 0, 0, time.UTC)
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+
+	cases := []struct {
+		name        string
+		value       string
+		isPregnancy bool
+		wantErr     bool
+	}{
+		{"living", "", false, false},
+		{"a date", "2021-02-01", false, false},
+		{"not a date", "February 2021", false, true},
+		{"in the future", tomorrow, false, true},
+		{"before birth", "1939-12-31", false, true},
+		{"before a due date", "1939-12-31", true, false},
+	}
+	for _, tc := range cases {
+		if _, err := parseDateOfDeath(tc.value, birthday, tc.isPregnancy); (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestInMemoriamIsReadBack(t *testing.T) {
+	db := vbolt.Open(t.TempDir() + "/memorial.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	defer db.Close()
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		grandpa, err := AddPersonTx(tx, AddPersonRequest{Name: "Grandpa", PersonType: 0, Gender: 0, Birthdate: "1938-07-04", DateOfDeath: "2019-11-20"}, 1)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		if !grandpa.InMemoriam || grandpa.Age != "81 years" {
+			t.Errorf("added grandpa = %+v", grandpa)
+		}
+
+		stored := GetPersonById(tx, grandpa.Id)
+		if !stored.InMemoriam || !stored.DateOfDeath.Equal(grandpa.DateOfDeath) {
+			t.Errorf("stored grandpa = %+v", stored)
+		}
+		people := GetFamilyPeople(tx, 1)
+		if len(people) != 1 || people[0].Age != "81 years" {
+			t.Errorf("roster = %+v", people)
+		}
+	})
+}
+
+// An edit that leaves the date of death out keeps it; only an explicit empty
+// value clears it.
+func TestUpdatePersonKeepsDateOfDeath(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("person-test-secret-key-at-least-32-bytes")
+
+	var grandpa Person
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		grandpa, err = AddPersonTx(tx, AddPersonRequest{Name: "Grandpa", PersonType: int(Parent), Gender: 0, Birthdate: "1938-07-04", DateOfDeath: "2019-11-20"}, fx.famA)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	update := func(dateOfDeath *string) Person {
+		t.Helper()
+		var resp GetPersonResponse
+		callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+			var err error
+			resp, err = UpdatePerson(ctx, UpdatePersonRequest{
+				Id: grandpa.Id, Name: "Grandpa Joe", PersonType: int(Parent), Gender: 0, Birthdate: "1938-07-04", DateOfDeath: dateOfDeath,
+			})
+			if err != nil {
+				t.Fatalf("UpdatePerson() error = %v", err)
+			}
+		})
+		return resp.Person
+	}
+
+	if renamed := update(nil); !renamed.InMemoriam || !renamed.DateOfDeath.Equal(grandpa.DateOfDeath) {
+		t.Errorf("an update without the field changed the date of death: %+v", renamed)
+	}
+	if cleared := update(stringPtr("")); cleared.InMemoriam || !cleared.DateOfDeath.IsZero() {
+		t.Errorf("an empty date of death was not cleared: %+v", cleared)
+	}
+}
+
 func TestCalculateAgeCurrentTime(t *testing.T) {
 	now := time.Now()
 
@@ -480,13 +595,7 @@ func TestMergePeople(t *testing.T) {
 		mergedGrowthCount = len(growthData)
 
 		// Merge milestones
-		milestones := GetPersonMilestonesTx(tx, sourcePerson.Id)
-		for _, milestone := range milestones {
-			milestone.PersonId = targetPerson.Id
-			vbolt.Write(tx, MilestoneBkt, milestone.Id, &milestone)
-			vbolt.SetTargetSingleTerm(tx, MilestoneByPersonIndex, milestone.Id, targetPerson.Id)
-		}
-		mergedMilestones = len(milestones)
+		mergedMilestones = moveMilestonesToPersonTx(tx, sourcePerson.Id, targetPerson.Id)
 
 		// Delete source person
 		vbolt.Delete(tx, PeopleBkt, sourcePerson.Id)
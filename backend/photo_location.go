@@ -0,0 +1,871 @@
+// Where a photo was taken, what took it, and keeping the first private.
+//
+// A phone writes the place into the EXIF next to the date, along with the
+// camera and lens. The upload handler reads all of it at the moment it reads
+// the date, and names the place offline: the nearest city in an embedded
+// table, or failing that only its country. Nothing is sent anywhere to look a
+// place up, because the coordinates of a child's photos are exactly what a
+// family would not want leaving the server.
+//
+// The table is coarse on purpose. A photo is named after the nearest city
+// listed within placeCityRadiusKm — a village an hour out of Lyon is "Lyon" —
+// and given only the country of the nearest city within placeCountryRadiusKm.
+// There are no borders in it, so a photo near one can take the neighbouring
+// country's name; the coordinates themselves are kept exactly, and a map shows
+// them where they are.
+//
+// Hiding a photo's location does not forget it: the family still sees it, and
+// turning the switch off brings it back. What changes is every copy that
+// leaves the server. The original is served and exported with the GPS entries
+// blanked out of its EXIF and its XMP packet emptied, and the location fields
+// are left out of the export and out of every answer given to someone who
+// sees the photo through a share link rather than the family.
+package backend
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func RegisterPhotoLocationMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, ListPhotoPlaces)
+	vbeam.RegisterProc(app, ListPlacePhotos)
+	vbeam.RegisterProc(app, SetPhotoLocationPrivacy)
+	vbeam.RegisterProc(app, BackfillPhotoMetadata)
+}
+
+var ErrPlaceOrArea = errors.New("Choose a place or an area of the map")
+var ErrInvalidMapArea = errors.New("That area of the map is not valid")
+var ErrLocationPrivacyVideo = errors.New("Only a photo's location can be hidden")
+var ErrLocationPrivacyFormat = errors.New("Location can only be hidden on JPEG and HEIC photos")
+var errCannotStripLocation = errors.New("cannot strip location from this file")
+
+const (
+	// placeCityRadiusKm is how far from a listed city a photo may be and
+	// still be named after it.
+	placeCityRadiusKm = 80
+	// placeCountryRadiusKm is how far it may be and still take that city's
+	// country. Further out — at sea, on the ice — a photo keeps its
+	// coordinates and no name.
+	placeCountryRadiusKm = 800
+	// maxCameraFieldLength bounds the make, model and lens strings, which
+	// come from the file and are sometimes padded or garbage.
+	maxCameraFieldLength = 64
+)
+
+// ── reverse geocoding ─────────────────────────────────────────────────────────
+
+//go:embed georef/places.csv
+var placesCSV []byte
+
+// geoPlace is one row of the table.
+type geoPlace struct {
+	City        string
+	CountryCode string
+	Country     string
+	Latitude    float64
+	Longitude   float64
+}
+
+// builtinPlaces is the embedded table. A malformed table is a build mistake,
+// so it panics rather than quietly naming nothing.
+var builtinPlaces = mustParsePlaces(placesCSV)
+
+func mustParsePlaces(data []byte) []geoPlace {
+	places, err := parsePlaces(data)
+	if err != nil {
+		panic("places: " + err.Error())
+	}
+	return places
+}
+
+func parsePlaces(data []byte) ([]geoPlace, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 5
+
+	var places []geoPlace
+	sawHeader := false
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !sawHeader {
+			sawHeader = true
+			continue
+		}
+
+		city := strings.TrimSpace(record[0])
+		country := strings.TrimSpace(record[2])
+		if city == "" || country == "" || len(strings.TrimSpace(record[1])) != 2 {
+			return nil, fmt.Errorf("a row has no city, country or country code: %v", record)
+		}
+		lat, err := strconv.ParseFloat(record[3], 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return nil, fmt.Errorf("bad latitude for %q", city)
+		}
+		lon, err := strconv.ParseFloat(record[4], 64)
+		if err != nil || lon < -180 || lon > 180 {
+			return nil, fmt.Errorf("bad longitude for %q", city)
+		}
+		places = append(places, geoPlace{
+			City:        city,
+			CountryCode: strings.TrimSpace(record[1]),
+			Country:     country,
+			Latitude:    lat,
+			Longitude:   lon,
+		})
+	}
+	if len(places) == 0 {
+		return nil, errors.New("no places")
+	}
+	return places, nil
+}
+
+// distanceKm is the great-circle distance between two points.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// reverseGeocode names a point after the nearest listed city. A few hundred
+// rows scanned once per upload is not worth an index.
+func reverseGeocode(lat, lon float64) (place string, country string) {
+	nearest := -1
+	best := math.Inf(1)
+	for i, candidate := range builtinPlaces {
+		if d := distanceKm(lat, lon, candidate.Latitude, candidate.Longitude); d < best {
+			nearest, best = i, d
+		}
+	}
+	if nearest < 0 || best > placeCountryRadiusKm {
+		return "", ""
+	}
+	if best <= placeCityRadiusKm {
+		place = builtinPlaces[nearest].City
+	}
+	return place, builtinPlaces[nearest].Country
+}
+
+// ── reading EXIF ──────────────────────────────────────────────────────────────
+
+// photoMetadata is what a photo's EXIF says beyond its date. An empty field is
+// one the file did not carry.
+type photoMetadata struct {
+	Latitude    float64
+	Longitude   float64
+	HasLocation bool
+	CameraMake  string
+	CameraModel string
+	LensModel   string
+}
+
+// extractPhotoMetadata reads the location and camera from a JPEG or HEIC. A
+// file with no EXIF, or EXIF without these, gives an empty result rather than
+// an error: most PNGs and screenshots have none and that is not a failure.
+func extractPhotoMetadata(fileData []byte) (meta photoMetadata) {
+	x, err := decodeExif(fileData)
+	if err != nil {
+		return
+	}
+	if lat, lon, err := x.LatLong(); err == nil && validCoordinates(lat, lon) {
+		meta.Latitude, meta.Longitude, meta.HasLocation = lat, lon, true
+	}
+	meta.CameraMake = exifString(x, exif.Make)
+	meta.CameraModel = exifString(x, exif.Model)
+	meta.LensModel = exifString(x, exif.LensModel)
+	return
+}
+
+// validCoordinates refuses what is not on the globe, and exactly 0,0, which is
+// what a camera with no fix writes far more often than anyone photographs the
+// Gulf of Guinea.
+func validCoordinates(lat, lon float64) bool {
+	if math.IsNaN(lat) || math.IsNaN(lon) || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return false
+	}
+	return lat != 0 || lon != 0
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return truncateCameraField(strings.TrimRight(value, "\x00"))
+}
+
+func truncateCameraField(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > maxCameraFieldLength {
+		value = strings.ToValidUTF8(value[:maxCameraFieldLength], "")
+	}
+	return value
+}
+
+// applyPhotoMetadata stores what the EXIF said on the photo and names its
+// place. Fields the EXIF left empty leave the photo's alone.
+func applyPhotoMetadata(image *Image, meta photoMetadata) {
+	if meta.HasLocation {
+		setPhotoLocation(image, meta.Latitude, meta.Longitude)
+	}
+	if meta.CameraMake != "" {
+		image.CameraMake = meta.CameraMake
+	}
+	if meta.CameraModel != "" {
+		image.CameraModel = meta.CameraModel
+	}
+	if meta.LensModel != "" {
+		image.LensModel = meta.LensModel
+	}
+}
+
+func setPhotoLocation(image *Image, lat, lon float64) {
+	image.Latitude = lat
+	image.Longitude = lon
+	image.HasLocation = true
+	image.Place, image.Country = reverseGeocode(lat, lon)
+}
+
+// ── who sees the location ─────────────────────────────────────────────────────
+
+// locationVisibleTo reports whether user may see where image was taken. A
+// hidden location is still the family's own; only those who came in through a
+// share link lose it.
+func locationVisibleTo(tx *vbolt.Tx, user User, image Image) bool {
+	if !image.HasLocation {
+		return false
+	}
+	return !image.LocationHidden || CanAccessFamily(tx, user, image.FamilyId, AccessView)
+}
+
+// redactPhotoLocation clears the location from a copy of the photo about to be
+// sent to user, when they may not see it.
+func redactPhotoLocation(tx *vbolt.Tx, user User, image *Image) {
+	if image.HasLocation && !locationVisibleTo(tx, user, *image) {
+		clearPhotoLocation(image)
+	}
+}
+
+// redactPhotoLocations does the same for every photo in a list, for the
+// responses that hand a person's or a family's photos out in bulk.
+func redactPhotoLocations(tx *vbolt.Tx, user User, images []Image) {
+	for i := range images {
+		redactPhotoLocation(tx, user, &images[i])
+	}
+}
+
+func clearPhotoLocation(image *Image) {
+	image.Latitude = 0
+	image.Longitude = 0
+	image.HasLocation = false
+	image.Place = ""
+	image.Country = ""
+}
+
+// canStripLocation reports whether the photo's original is a format
+// stripLocation can clean. Hiding is refused for anything else, since the
+// original would otherwise go out as it is.
+func canStripLocation(image Image) bool {
+	if image.MediaKind == MediaKindVideo {
+		return false
+	}
+	switch image.MimeType {
+	case "image/jpeg", "image/jpg", "image/heic", "image/heif":
+		return true
+	}
+	return false
+}
+
+// ── stripping the location from a file ───────────────────────────────────────
+
+// stripLocation returns a copy of a JPEG or HEIC with its GPS entries blanked
+// and any XMP packet emptied. Everything is overwritten in place rather than
+// removed, so no offset in the file moves and nothing has to be rewritten
+// around it: the picture, the date and the camera are untouched. A file it
+// cannot parse is an error, never a copy returned as it came.
+func stripLocation(data []byte) ([]byte, error) {
+	stripped := bytes.Clone(data)
+	switch {
+	case isHEIF(stripped):
+		// heifExif returns a window onto the copy, so clearing it clears
+		// the copy.
+		if block, ok := heifExif(stripped); ok {
+			if err := clearTIFFGPS(block); err != nil {
+				return nil, err
+			}
+		}
+	case len(stripped) > 3 && stripped[0] == 0xFF && stripped[1] == 0xD8:
+		if err := clearJPEGGPS(stripped); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errCannotStripLocation
+	}
+	blankXMP(stripped)
+	return stripped, nil
+}
+
+// clearJPEGGPS walks a JPEG's header segments to the EXIF one. The walk stops
+// at the start of the image data, after which there are no more segments.
+func clearJPEGGPS(data []byte) error {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return errCannotStripLocation
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++ // fill byte
+			continue
+		}
+		if marker == 0xD9 || marker == 0xDA {
+			return nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2 // markers with no length
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return errCannotStripLocation
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			if err := clearTIFFGPS(segment[6:]); err != nil {
+				return err
+			}
+		}
+		pos = end
+	}
+	return nil
+}
+
+// tiffTypeSizes is the size in bytes of one value of each TIFF field type.
+var tiffTypeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+const tiffGPSPointerTag = 0x8825
+
+// clearTIFFGPS empties the GPS directory of a TIFF block, the form EXIF takes:
+// every value it points at and every entry is zeroed, and its count set to
+// none, so a reader finds the directory where it always was and nothing in it.
+func clearTIFFGPS(block []byte) error {
+	if len(block) < 8 {
+		return errCannotStripLocation
+	}
+	var order binary.ByteOrder
+	switch string(block[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errCannotStripLocation
+	}
+	if order.Uint16(block[2:]) != 42 {
+		return errCannotStripLocation
+	}
+
+	ifd0 := int(order.Uint32(block[4:]))
+	entries, ok := tiffEntries(block, order, ifd0)
+	if !ok {
+		return errCannotStripLocation
+	}
+	gpsOffset := -1
+	for _, entry := range entries {
+		if order.Uint16(block[entry:]) == tiffGPSPointerTag {
+			gpsOffset = int(order.Uint32(block[entry+8:]))
+		}
+	}
+	if gpsOffset < 0 {
+		return nil
+	}
+
+	gpsEntries, ok := tiffEntries(block, order, gpsOffset)
+	if !ok {
+		return errCannotStripLocation
+	}
+	for _, entry := range gpsEntries {
+		size, known := tiffTypeSizes[order.Uint16(block[entry+2:])]
+		if !known {
+			return errCannotStripLocation
+		}
+		total := uint64(size) * uint64(order.Uint32(block[entry+4:]))
+		if total > 4 {
+			start := uint64(order.Uint32(block[entry+8:]))
+			if start+total > uint64(len(block)) {
+				return errCannotStripLocation
+			}
+			clear(block[start : start+total])
+		}
+	}
+	// The directory's entries and the link to the next directory after
+	// them; GPS is never followed by one.
+	clear(block[gpsOffset+2 : gpsOffset+2+12*len(gpsEntries)+4])
+	order.PutUint16(block[gpsOffset:], 0)
+	return nil
+}
+
+// tiffEntries returns where each 12-byte entry of the directory at offset
+// starts, having checked the directory and the link after it fit the block.
+func tiffEntries(block []byte, order binary.ByteOrder, offset int) ([]int, bool) {
+	if offset < 8 || offset+2 > len(block) {
+		return nil, false
+	}
+	count := int(order.Uint16(block[offset:]))
+	if offset+2+12*count+4 > len(block) {
+		return nil, false
+	}
+	entries := make([]int, count)
+	for i := range entries {
+		entries[i] = offset + 2 + 12*i
+	}
+	return entries, true
+}
+
+// blankXMP overwrites every XMP packet's contents with spaces, which XMP
+// readers take for an empty packet. XMP can carry the coordinates a second
+// time, and an editor's history besides; neither is worth parsing the RDF to
+// keep.
+func blankXMP(data []byte) {
+	for _, tags := range [][2]string{
+		{"<x:xmpmeta", "</x:xmpmeta>"},
+		{"<x:xapmeta", "</x:xapmeta>"},
+	} {
+		pos := 0
+		for {
+			start := bytes.Index(data[pos:], []byte(tags[0]))
+			if start < 0 {
+				break
+			}
+			start += pos
+			end := bytes.Index(data[start:], []byte(tags[1]))
+			if end < 0 {
+				break
+			}
+			end += start + len(tags[1])
+			for i := start; i < end; i++ {
+				data[i] = ' '
+			}
+			pos = end
+		}
+	}
+}
+
+// readStrippedOriginal reads an original from disk without its location.
+func readStrippedOriginal(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return stripLocation(data)
+}
+
+// serveStrippedOriginal answers for the original of a photo whose location is
+// hidden. A file that cannot be stripped is not served at all; the other
+// sizes were re-encoded from it and never carried any EXIF.
+func serveStrippedOriginal(w http.ResponseWriter, r *http.Request, image Image, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	data, err := readStrippedOriginal(path)
+	if err != nil {
+		log.Printf("Not serving original of photo %d with hidden location: %v", image.Id, err)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+// ── procs ─────────────────────────────────────────────────────────────────────
+
+// PhotoPlace is one city and how many of the photos were taken near it.
+type PhotoPlace struct {
+	Place string `json:"place"`
+	Count int    `json:"count"`
+	// Latitude and Longitude are the middle of the photos, for a pin.
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	CoverPhotoId int     `json:"coverPhotoId"` // the most recent
+}
+
+// PhotoCountry is one country, its cities, and how many photos in all. Photos
+// too far from any listed city count towards the country and no city in it.
+type PhotoCountry struct {
+	Country string       `json:"country"`
+	Count   int          `json:"count"`
+	Places  []PhotoPlace `json:"places"`
+}
+
+type ListPhotoPlacesResponse struct {
+	Countries []PhotoCountry `json:"countries"`
+	// Unnamed counts photos with a location too far from anywhere listed to
+	// name, and Unlocated those with no location at all.
+	Unnamed   int `json:"unnamed"`
+	Unlocated int `json:"unlocated"`
+}
+
+// visibleLocatedPhotos is every photo the user can see, with the locations
+// they may not see taken off.
+func visibleLocatedPhotos(tx *vbolt.Tx, user User) []Image {
+	images := GetVisibleImages(tx, user)
+	result := make([]Image, 0, len(images))
+	for _, image := range images {
+		if image.Status == 2 {
+			continue
+		}
+		redactPhotoLocation(tx, user, &image)
+		result = append(result, image)
+	}
+	return result
+}
+
+// ListPhotoPlaces groups the photos the user can see by country and city, the
+// most photographed first.
+func ListPhotoPlaces(ctx *vbeam.Context, req Empty) (resp ListPhotoPlacesResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	type placeTally struct {
+		place          PhotoPlace
+		latSum, lonSum float64
+		coverPhotoDate time.Time
+	}
+	countries := make(map[string]*PhotoCountry)
+	places := make(map[string]map[string]*placeTally)
+	for _, image := range visibleLocatedPhotos(ctx.Tx, user) {
+		if !image.HasLocation {
+			resp.Unlocated++
+			continue
+		}
+		if image.Country == "" {
+			resp.Unnamed++
+			continue
+		}
+		country := countries[image.Country]
+		if country == nil {
+			country = &PhotoCountry{Country: image.Country}
+			countries[image.Country] = country
+			places[image.Country] = make(map[string]*placeTally)
+		}
+		country.Count++
+		if image.Place == "" {
+			continue
+		}
+		tally := places[image.Country][image.Place]
+		if tally == nil {
+			tally = &placeTally{place: PhotoPlace{Place: image.Place}}
+			places[image.Country][image.Place] = tally
+		}
+		tally.place.Count++
+		tally.latSum += image.Latitude
+		tally.lonSum += image.Longitude
+		if tally.place.CoverPhotoId == 0 || image.PhotoDate.After(tally.coverPhotoDate) {
+			tally.place.CoverPhotoId = image.Id
+			tally.coverPhotoDate = image.PhotoDate
+		}
+	}
+
+	resp.Countries = make([]PhotoCountry, 0, len(countries))
+	for name, country := range countries {
+		country.Places = make([]PhotoPlace, 0, len(places[name]))
+		for _, tally := range places[name] {
+			tally.place.Latitude = tally.latSum / float64(tally.place.Count)
+			tally.place.Longitude = tally.lonSum / float64(tally.place.Count)
+			country.Places = append(country.Places, tally.place)
+		}
+		sort.Slice(country.Places, func(i, j int) bool {
+			a, b := country.Places[i], country.Places[j]
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return a.Place < b.Place
+		})
+		resp.Countries = append(resp.Countries, *country)
+	}
+	sort.Slice(resp.Countries, func(i, j int) bool {
+		a, b := resp.Countries[i], resp.Countries[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Country < b.Country
+	})
+	return
+}
+
+// MapArea is a box on the map. An area whose west edge is east of its east
+// edge crosses the 180th meridian.
+type MapArea struct {
+	MinLatitude  float64 `json:"minLatitude"`
+	MaxLatitude  float64 `json:"maxLatitude"`
+	MinLongitude float64 `json:"minLongitude"`
+	MaxLongitude float64 `json:"maxLongitude"`
+}
+
+func (area MapArea) contains(lat, lon float64) bool {
+	if lat < area.MinLatitude || lat > area.MaxLatitude {
+		return false
+	}
+	if area.MinLongitude <= area.MaxLongitude {
+		return lon >= area.MinLongitude && lon <= area.MaxLongitude
+	}
+	return lon >= area.MinLongitude || lon <= area.MaxLongitude
+}
+
+type ListPlacePhotosRequest struct {
+	// Country alone is every photo in it; with Place, those near that city.
+	Country string `json:"country,omitempty"`
+	Place   string `json:"place,omitempty"`
+	// Area is the other way to ask: whatever was taken inside it.
+	Area *MapArea `json:"area,omitempty"`
+}
+
+type ListPlacePhotosResponse struct {
+	Photos []PhotoWithPeople `json:"photos"`
+}
+
+func validateListPlacePhotosRequest(req ListPlacePhotosRequest) error {
+	byName := req.Country != "" || req.Place != ""
+	if byName == (req.Area != nil) {
+		return ErrPlaceOrArea
+	}
+	if req.Place != "" && req.Country == "" {
+		return ErrPlaceOrArea
+	}
+	if area := req.Area; area != nil {
+		for _, lat := range []float64{area.MinLatitude, area.MaxLatitude} {
+			if math.IsNaN(lat) || lat < -90 || lat > 90 {
+				return ErrInvalidMapArea
+			}
+		}
+		for _, lon := range []float64{area.MinLongitude, area.MaxLongitude} {
+			if math.IsNaN(lon) || lon < -180 || lon > 180 {
+				return ErrInvalidMapArea
+			}
+		}
+		if area.MinLatitude > area.MaxLatitude {
+			return ErrInvalidMapArea
+		}
+	}
+	return nil
+}
+
+// ListPlacePhotos lists the photos taken in a place, or in an area of the map,
+// newest first.
+func ListPlacePhotos(ctx *vbeam.Context, req ListPlacePhotosRequest) (resp ListPlacePhotosResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateListPlacePhotosRequest(req); err != nil {
+		return
+	}
+
+	var matched []Image
+	for _, image := range visibleLocatedPhotos(ctx.Tx, user) {
+		if !image.HasLocation {
+			continue
+		}
+		if req.Area != nil {
+			if !req.Area.contains(image.Latitude, image.Longitude) {
+				continue
+			}
+		} else if image.Country != req.Country || (req.Place != "" && image.Place != req.Place) {
+			continue
+		}
+		matched = append(matched, image)
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].PhotoDate.After(matched[j].PhotoDate)
+	})
+
+	resp.Photos = make([]PhotoWithPeople, 0, len(matched))
+	for _, image := range matched {
+		people := GetPhotoPeople(ctx.Tx, image.Id)
+		for i := range people {
+			people[i].Age = personAge(people[i])
+		}
+		image.TagIds = GetPhotoTagIds(ctx.Tx, image.Id)
+		resp.Photos = append(resp.Photos, PhotoWithPeople{Image: image, People: people})
+	}
+	return
+}
+
+type SetPhotoLocationPrivacyRequest struct {
+	PhotoId int  `json:"photoId"`
+	Hidden  bool `json:"hidden"`
+}
+
+type SetPhotoLocationPrivacyResponse struct {
+	Image Image `json:"image"`
+}
+
+// SetPhotoLocationPrivacy turns a photo's location privacy on or off. It asks
+// for the access editing the photo does.
+func SetPhotoLocationPrivacy(ctx *vbeam.Context, req SetPhotoLocationPrivacyRequest) (resp SetPhotoLocationPrivacyResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+
+	photo := GetImageById(ctx.Tx, req.PhotoId)
+	if photo.Id == 0 || !CanAccessFamily(ctx.Tx, user, photo.FamilyId, AccessContribute) {
+		err = ErrPhotoNotFound
+		return
+	}
+	if req.Hidden {
+		if photo.MediaKind == MediaKindVideo {
+			err = ErrLocationPrivacyVideo
+			return
+		}
+		if !canStripLocation(photo) {
+			err = ErrLocationPrivacyFormat
+			return
+		}
+	}
+
+	photo.LocationHidden = req.Hidden
+	vbolt.Write(ctx.Tx, ImagesBkt, photo.Id, &photo)
+
+	resp.Image = photo
+	resp.Image.TagIds = GetPhotoTagIds(ctx.Tx, photo.Id)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+// storedMetadata is the part of a photo the backfill may change, in a
+// form that compares.
+type storedMetadata struct {
+	meta    photoMetadata
+	place   string
+	country string
+}
+
+func storedPhotoMetadata(image Image) storedMetadata {
+	return storedMetadata{
+		meta: photoMetadata{
+			Latitude:    image.Latitude,
+			Longitude:   image.Longitude,
+			HasLocation: image.HasLocation,
+			CameraMake:  image.CameraMake,
+			CameraModel: image.CameraModel,
+			LensModel:   image.LensModel,
+		},
+		place:   image.Place,
+		country: image.Country,
+	}
+}
+
+type BackfillPhotoMetadataResponse struct {
+	Read      int      `json:"read"`
+	Located   int      `json:"located"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+	TotalTime string   `json:"totalTime"`
+}
+
+// BackfillPhotoMetadata reads the location and camera from the originals of
+// photos uploaded before they were kept, and names the places of photos
+// whose location is known but unnamed, as after the table has grown.
+func BackfillPhotoMetadata(ctx *vbeam.Context, req Empty) (resp BackfillPhotoMetadataResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	// Check if user is admin (ID == 1)
+	if user.Id != 1 {
+		err = errors.New("Unauthorized: Admin access required")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	startTime := time.Now()
+
+	var photosToRead []Image
+	vbolt.IterateAll(ctx.Tx, ImagesBkt, func(key int, image Image) bool {
+		if image.MediaKind == MediaKindVideo || image.Status == 1 {
+			return true
+		}
+		if !image.HasLocation || image.Place == "" || image.CameraModel == "" {
+			photosToRead = append(photosToRead, image)
+		}
+		return true
+	})
+
+	for _, photo := range photosToRead {
+		before := storedPhotoMetadata(photo)
+		if photo.HasLocation {
+			setPhotoLocation(&photo, photo.Latitude, photo.Longitude)
+		}
+		if !photo.HasLocation || photo.CameraModel == "" {
+			data, readErr := os.ReadFile(getOriginalPhotoPath(photo))
+			if readErr != nil {
+				resp.Failed++
+				resp.Errors = append(resp.Errors, fmt.Sprintf("Photo %d: %v", photo.Id, readErr))
+				continue
+			}
+			resp.Read++
+			meta := extractPhotoMetadata(data)
+			if photo.HasLocation {
+				meta.HasLocation = false // the stored location may have been imported
+			}
+			applyPhotoMetadata(&photo, meta)
+		}
+		if storedPhotoMetadata(photo) == before {
+			continue
+		}
+		if photo.HasLocation && !before.meta.HasLocation {
+			resp.Located++
+		}
+		vbolt.Write(ctx.Tx, ImagesBkt, photo.Id, &photo)
+	}
+
+	vbolt.TxCommit(ctx.Tx)
+	resp.TotalTime = time.Since(startTime).String()
+	return
+}
@@ -0,0 +1,415 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"family/cfg"
+	"image"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description exif:GPSLatitude="48,51.5N"/></rdf:RDF></x:xmpmeta>`
+
+// exifJPEG returns a small JPEG carrying the EXIF a phone writes: the camera's
+// make and, unless both are zero, a GPS position, in a big-endian TIFF block
+// with the values that do not fit an entry stored after each directory. An
+// XMP packet repeats the position the way editors do.
+func exifJPEG(t *testing.T, cameraMake string, lat, lon float64) []byte {
+	t.Helper()
+	order := binary.BigEndian
+	var tiff bytes.Buffer
+	u16 := func(v uint16) { binary.Write(&tiff, order, v) }
+	u32 := func(v uint32) { binary.Write(&tiff, order, v) }
+	entry := func(tag, kind uint16, count, value uint32) { u16(tag); u16(kind); u32(count); u32(value) }
+
+	makeValue := append([]byte(cameraMake), 0)
+	if len(makeValue)%2 == 1 {
+		makeValue = append(makeValue, 0)
+	}
+	hasGPS := lat != 0 || lon != 0
+	ifd0Entries := 1
+	if hasGPS {
+		ifd0Entries = 2
+	}
+	makeOffset := 8 + 2 + 12*ifd0Entries + 4
+	gpsOffset := makeOffset + len(makeValue)
+
+	tiff.WriteString("MM")
+	u16(42)
+	u32(8)
+	u16(uint16(ifd0Entries))
+	entry(0x010F, 2, uint32(len(cameraMake)+1), uint32(makeOffset))
+	if hasGPS {
+		entry(0x8825, 4, 1, uint32(gpsOffset))
+	}
+	u32(0)
+	tiff.Write(makeValue)
+
+	if hasGPS {
+		latRef, lonRef := "N", "E"
+		if lat < 0 {
+			latRef, lat = "S", -lat
+		}
+		if lon < 0 {
+			lonRef, lon = "W", -lon
+		}
+		ref := func(s string) uint32 { return uint32(s[0]) << 24 }
+		dataOffset := uint32(gpsOffset + 2 + 12*4 + 4)
+		u16(4)
+		entry(0x0001, 2, 2, ref(latRef))
+		entry(0x0002, 5, 3, dataOffset)
+		entry(0x0003, 2, 2, ref(lonRef))
+		entry(0x0004, 5, 3, dataOffset+24)
+		u32(0)
+		for _, degrees := range []float64{lat, lon} {
+			whole := math.Floor(degrees)
+			minutes := math.Floor((degrees - whole) * 60)
+			seconds := math.Round(((degrees-whole)*60 - minutes) * 60 * 1000)
+			u32(uint32(whole))
+			u32(1)
+			u32(uint32(minutes))
+			u32(1)
+			u32(uint32(seconds))
+			u32(1000)
+		}
+	}
+
+	segment := func(out *bytes.Buffer, marker byte, payload []byte) {
+		out.Write([]byte{0xFF, marker})
+		binary.Write(out, binary.BigEndian, uint16(len(payload)+2))
+		out.Write(payload)
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, createPatternImage(32, 24), nil); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2])
+	segment(&out, 0xE1, append([]byte("Exif\x00\x00"), tiff.Bytes()...))
+	segment(&out, 0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"+testXMP))
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestExtractPhotoMetadata(t *testing.T) {
+	meta := extractPhotoMetadata(exifJPEG(t, "Canon", 48.8584, -122.2945))
+	if !meta.HasLocation || math.Abs(meta.Latitude-48.8584) > 1e-4 || math.Abs(meta.Longitude+122.2945) > 1e-4 {
+		t.Errorf("location = %v, %v (%v)", meta.Latitude, meta.Longitude, meta.HasLocation)
+	}
+	if meta.CameraMake != "Canon" {
+		t.Errorf("camera make = %q", meta.CameraMake)
+	}
+
+	if meta := extractPhotoMetadata(exifJPEG(t, "Canon", 0, 0)); meta.HasLocation || meta.CameraMake != "Canon" {
+		t.Errorf("no GPS: %+v", meta)
+	}
+	if meta := extractPhotoMetadata(createTestImage(10, 10)); meta != (photoMetadata{}) {
+		t.Errorf("no EXIF at all: %+v", meta)
+	}
+
+	var photo Image
+	applyPhotoMetadata(&photo, extractPhotoMetadata(exifJPEG(t, "Apple", -33.87, 151.2)))
+	if !photo.HasLocation || photo.Place != "Sydney" || photo.Country != "Australia" || photo.CameraMake != "Apple" {
+		t.Errorf("applied metadata = %+v", photo)
+	}
+}
+
+func TestReverseGeocode(t *testing.T) {
+	tests := []struct {
+		name           string
+		lat, lon       float64
+		place, country string
+	}{
+		{"city centre", 48.87, 2.30, "Paris", "France"},
+		{"a suburb", 37.87, -122.27, "San Francisco", "United States"},
+		{"across the antimeridian", -16.8, -179.97, "", "Fiji"},
+		{"too far from a city to name", 45.78, 3.08, "", "France"},
+		{"mid-Pacific", 0, -140, "", ""},
+	}
+	for _, tt := range tests {
+		place, country := reverseGeocode(tt.lat, tt.lon)
+		if place != tt.place || country != tt.country {
+			t.Errorf("%s: reverseGeocode(%v, %v) = %q, %q; want %q, %q", tt.name, tt.lat, tt.lon, place, country, tt.place, tt.country)
+		}
+	}
+
+	if _, err := parsePlaces([]byte("city,country_code,country,lat,lon\nNowhere,XX,Nowhere,91,0\n")); err == nil {
+		t.Error("a latitude off the globe was accepted")
+	}
+}
+
+func TestStripLocation(t *testing.T) {
+	original := exifJPEG(t, "Canon", 48.8584, 2.2945)
+	kept := bytes.Clone(original)
+
+	stripped, err := stripLocation(original)
+	if err != nil {
+		t.Fatalf("stripLocation() error = %v", err)
+	}
+	if !bytes.Equal(original, kept) {
+		t.Error("stripping changed the original")
+	}
+	if len(stripped) != len(original) {
+		t.Errorf("stripped length %d, original %d", len(stripped), len(original))
+	}
+	if meta := extractPhotoMetadata(stripped); meta.HasLocation || meta.CameraMake != "Canon" {
+		t.Errorf("stripped metadata = %+v", meta)
+	}
+	if bytes.Contains(stripped, []byte("GPSLatitude")) {
+		t.Error("the XMP copy of the location survived")
+	}
+	if _, _, err := image.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped photo no longer decodes: %v", err)
+	}
+
+	// Nothing to strip is not an error; something unreadable is.
+	if _, err := stripLocation(exifJPEG(t, "Canon", 0, 0)); err != nil {
+		t.Errorf("a JPEG with no GPS: error = %v", err)
+	}
+	if _, err := stripLocation(createTestImage(10, 10)); err == nil {
+		t.Error("a PNG was stripped")
+	}
+	if _, err := stripLocation(original[:30]); err == nil {
+		t.Error("a truncated EXIF block was stripped")
+	}
+}
+
+func TestPhotoPlacesAndLocationPrivacy(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("location-test-secret-key-at-least-32-bytes")
+
+	var clip, screenshot Image
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		alicePhoto := GetImageById(tx, fx.alicePhoto.Id)
+		setPhotoLocation(&alicePhoto, 48.86, 2.35)
+		alicePhoto.PhotoDate = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		vbolt.Write(tx, ImagesBkt, alicePhoto.Id, &alicePhoto)
+
+		untagged := GetImageById(tx, fx.untaggedPhoto.Id)
+		setPhotoLocation(&untagged, 48.80, 2.13) // Versailles
+		untagged.PhotoDate = time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+		vbolt.Write(tx, ImagesBkt, untagged.Id, &untagged)
+
+		clip = writeTestImage(tx, fx.famA, fx.userA.Id, "waves.mp4")
+		clip.MediaKind = MediaKindVideo
+		setPhotoLocation(&clip, 35.68, 139.69)
+		vbolt.Write(tx, ImagesBkt, clip.Id, &clip)
+
+		screenshot = writeTestImage(tx, fx.famA, fx.userA.Id, "screenshot.png")
+		screenshot.MimeType = "image/png"
+		vbolt.Write(tx, ImagesBkt, screenshot.Id, &screenshot)
+		vbolt.TxCommit(tx)
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := ListPhotoPlaces(ctx, Empty{})
+		if err != nil {
+			t.Fatalf("ListPhotoPlaces() error = %v", err)
+		}
+		if len(resp.Countries) != 2 || resp.Countries[0].Country != "France" || resp.Countries[0].Count != 2 {
+			t.Fatalf("countries = %+v", resp.Countries)
+		}
+		paris := resp.Countries[0].Places
+		if len(paris) != 1 || paris[0].Place != "Paris" || paris[0].Count != 2 || paris[0].CoverPhotoId != fx.untaggedPhoto.Id {
+			t.Errorf("places in France = %+v", paris)
+		}
+		if resp.Unlocated != 1 {
+			t.Errorf("unlocated = %d, want the screenshot", resp.Unlocated)
+		}
+
+		byPlace, err := ListPlacePhotos(ctx, ListPlacePhotosRequest{Country: "France", Place: "Paris"})
+		if err != nil || len(byPlace.Photos) != 2 || byPlace.Photos[0].Image.Id != fx.untaggedPhoto.Id {
+			t.Errorf("photos in Paris = %+v, %v", byPlace.Photos, err)
+		}
+		// An area around Japan that runs across the 180th meridian.
+		byArea, err := ListPlacePhotos(ctx, ListPlacePhotosRequest{Area: &MapArea{MinLatitude: 30, MaxLatitude: 40, MinLongitude: 130, MaxLongitude: -170}})
+		if err != nil || len(byArea.Photos) != 1 || byArea.Photos[0].Image.Id != clip.Id {
+			t.Errorf("photos in the area = %+v, %v", byArea.Photos, err)
+		}
+		if _, err := ListPlacePhotos(ctx, ListPlacePhotosRequest{}); err != ErrPlaceOrArea {
+			t.Errorf("neither place nor area: error = %v", err)
+		}
+		if _, err := ListPlacePhotos(ctx, ListPlacePhotosRequest{Area: &MapArea{MinLatitude: 10, MaxLatitude: -10}}); err != ErrInvalidMapArea {
+			t.Errorf("an upside-down area: error = %v", err)
+		}
+
+		if _, err := SetPhotoLocationPrivacy(ctx, SetPhotoLocationPrivacyRequest{PhotoId: clip.Id, Hidden: true}); err != ErrLocationPrivacyVideo {
+			t.Errorf("hiding a clip's location: error = %v", err)
+		}
+		if _, err := SetPhotoLocationPrivacy(ctx, SetPhotoLocationPrivacyRequest{PhotoId: screenshot.Id, Hidden: true}); err != ErrLocationPrivacyFormat {
+			t.Errorf("hiding a PNG's location: error = %v", err)
+		}
+	})
+
+	// Grandparents see alice's photo and where it was taken, until it is hidden.
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		resp, err := GetPhoto(ctx, GetPhotoRequest{Id: fx.alicePhoto.Id})
+		if err != nil || resp.Image.Place != "Paris" {
+			t.Errorf("linked family before hiding: %+v, %v", resp.Image, err)
+		}
+		if _, err := SetPhotoLocationPrivacy(ctx, SetPhotoLocationPrivacyRequest{PhotoId: fx.alicePhoto.Id, Hidden: true}); err != ErrPhotoNotFound {
+			t.Errorf("a linked family hiding the location: error = %v", err)
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := SetPhotoLocationPrivacy(ctx, SetPhotoLocationPrivacyRequest{PhotoId: fx.alicePhoto.Id, Hidden: true})
+		if err != nil || !resp.Image.LocationHidden || resp.Image.Place != "Paris" {
+			t.Errorf("SetPhotoLocationPrivacy() = %+v, %v", resp.Image, err)
+		}
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		places, _ := ListPhotoPlaces(ctx, Empty{})
+		if places.Countries[0].Count != 2 {
+			t.Errorf("the family lost its own hidden location: %+v", places.Countries)
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		resp, err := GetPhoto(ctx, GetPhotoRequest{Id: fx.alicePhoto.Id})
+		if err != nil || resp.Image.HasLocation || resp.Image.Place != "" || resp.Image.Latitude != 0 {
+			t.Errorf("linked family after hiding: %+v, %v", resp.Image, err)
+		}
+		list, _ := ListFamilyPhotos(ctx, ListFamilyPhotosRequest{})
+		for _, photo := range list.Photos {
+			if photo.Image.HasLocation {
+				t.Errorf("photo %d listed with its location", photo.Image.Id)
+			}
+		}
+		places, _ := ListPhotoPlaces(ctx, Empty{})
+		if len(places.Countries) != 0 {
+			t.Errorf("linked family places = %+v", places.Countries)
+		}
+		byPlace, _ := ListPlacePhotos(ctx, ListPlacePhotosRequest{Country: "France"})
+		if len(byPlace.Photos) != 0 {
+			t.Errorf("linked family found %d photos in France", len(byPlace.Photos))
+		}
+
+		// Every other way a shared person's photos go out is redacted too.
+		checkRedacted := func(source string, images []Image) {
+			t.Helper()
+			for _, image := range images {
+				if image.Id == fx.alicePhoto.Id && (image.HasLocation || image.Place != "" || image.Country != "" || image.Latitude != 0) {
+					t.Errorf("%s sent the hidden location: %+v", source, image)
+				}
+			}
+		}
+		person, err := GetPerson(ctx, GetPersonRequest{Id: fx.alice.Id})
+		if err != nil || len(person.Photos) == 0 {
+			t.Fatalf("GetPerson() = %d photos, %v", len(person.Photos), err)
+		}
+		checkRedacted("GetPerson", person.Photos)
+		compared, err := ComparePeople(ctx, ComparePeopleRequest{PersonIds: []int{fx.alice.Id}})
+		if err != nil {
+			t.Fatalf("ComparePeople() error = %v", err)
+		}
+		checkRedacted("ComparePeople", compared.People[0].Photos)
+		timeline, err := GetFamilyTimeline(ctx, GetFamilyTimelineRequest{})
+		if err != nil {
+			t.Fatalf("GetFamilyTimeline() error = %v", err)
+		}
+		for _, item := range timeline.People {
+			checkRedacted("GetFamilyTimeline", item.Photos)
+		}
+		memories, err := GetOnThisDay(ctx, GetOnThisDayRequest{Date: "2025-06-01"})
+		if err != nil || len(memories.Years) == 0 {
+			t.Fatalf("GetOnThisDay() = %+v, %v", memories.Years, err)
+		}
+		for _, year := range memories.Years {
+			checkRedacted("GetOnThisDay", year.Photos)
+		}
+	})
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		for _, ep := range buildPhotoExportMetadata(tx, fx.famA) {
+			if ep.Id == fx.alicePhoto.Id && (ep.Latitude != 0 || ep.Longitude != 0 || !ep.LocationHidden) {
+				t.Errorf("exported hidden photo = %+v", ep)
+			}
+			if ep.Id == fx.untaggedPhoto.Id && ep.Latitude != 48.80 {
+				t.Errorf("exported photo lost its location: %+v", ep)
+			}
+		}
+	})
+}
+
+func TestServeOriginalWithHiddenLocation(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	appDb = fx.db
+
+	original := exifJPEG(t, "Canon", 51.5, -0.12)
+	filePath := "photos/test-located-" + strconv.FormatInt(time.Now().UnixNano(), 36) + ".jpg"
+	originalPath := filepath.Join(cfg.StaticDir, originalMediaPath(filePath))
+	if err := os.MkdirAll(filepath.Dir(originalPath), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(originalPath, original, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var photo Image
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		photo = writeTestImage(tx, fx.famA, fx.userA.Id, "london.jpg")
+		photo.FilePath = filePath
+		applyPhotoMetadata(&photo, extractPhotoMetadata(original))
+		vbolt.Write(tx, ImagesBkt, photo.Id, &photo)
+		vbolt.TxCommit(tx)
+	})
+	t.Cleanup(func() { deletePhotoFiles(photo) })
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/photo/"+strconv.Itoa(photo.Id)+"/original", nil)
+		req = req.WithContext(context.WithValue(req.Context(), UserContextKey, fx.userA))
+		recorder := httptest.NewRecorder()
+		servePhotoHandler(recorder, req)
+		return recorder
+	}
+
+	resp := serve()
+	if resp.Code != http.StatusOK || !bytes.Equal(resp.Body.Bytes(), original) {
+		t.Fatalf("original before hiding: status %d, %d bytes", resp.Code, resp.Body.Len())
+	}
+	etag := resp.Header().Get("ETag")
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		photo.LocationHidden = true
+		vbolt.Write(tx, ImagesBkt, photo.Id, &photo)
+		vbolt.TxCommit(tx)
+	})
+
+	resp = serve()
+	if resp.Code != http.StatusOK {
+		t.Fatalf("original after hiding: status %d", resp.Code)
+	}
+	if meta := extractPhotoMetadata(resp.Body.Bytes()); meta.HasLocation || meta.CameraMake != "Canon" {
+		t.Errorf("served original metadata = %+v", meta)
+	}
+	if got := resp.Header().Get("Content-Type"); got != "image/jpeg" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if resp.Header().Get("ETag") == etag || !strings.Contains(resp.Header().Get("ETag"), "nolocation") {
+		t.Errorf("ETag %q did not change from %q", resp.Header().Get("ETag"), etag)
+	}
+
+	// A file that cannot be stripped is not served at all.
+	if err := os.WriteFile(originalPath, original[:30], 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if resp := serve(); resp.Code != http.StatusNotFound {
+		t.Errorf("unstrippable original: status %d, want 404", resp.Code)
+	}
+}
@@ -23,6 +23,11 @@ type PhotoProcessingJob struct {
 	MimeType       string
 	OriginalWidth  int
 	OriginalHeight int
+	// MediaKind and Duration are set for a video clip. A clip's job carries
+	// no FileData: the upload has already written it to disk, and the job
+	// reads it from there.
+	MediaKind string
+	Duration  time.Duration
 }
 
 // PhotoWorker manages background photo processing
@@ -150,7 +155,15 @@ func (pw *PhotoWorker) processPhotoJob(job PhotoProcessingJob) {
 
 	// Process the image and create multiple sizes/formats
 	log.Printf("[PHOTO_PROCESSING] Processing image formats and sizes for photo %d", job.ImageId)
-	processedImages, processedWidth, processedHeight, err := ProcessAndSaveMultipleSizes(job.FileData, job.MimeType)
+	var processedImages map[string][]byte
+	var processedWidth, processedHeight int
+	if job.MediaKind == MediaKindVideo {
+		// A clip's variants are its poster frame's, and a missing poster
+		// does not fail the clip.
+		processedImages, processedWidth, processedHeight = processVideoPoster(job)
+	} else {
+		processedImages, processedWidth, processedHeight, err = ProcessAndSaveMultipleSizes(job.FileData, job.MimeType)
+	}
 	if err != nil {
 		log.Printf("[PHOTO_PROCESSING] FAILED to process photo ID %d: %v", job.ImageId, err)
 		pw.updatePhotoStatus(job.ImageId, 2) // 2 = failed/hidden
@@ -168,9 +181,13 @@ func (pw *PhotoWorker) processPhotoJob(job PhotoProcessingJob) {
 	}
 	log.Printf("[PHOTO_PROCESSING] Successfully saved all variants for photo %d", job.ImageId)
 
+	// Hashing never fails the photo: one that cannot be hashed is only one
+	// the duplicate finder will not match.
+	hashes := hashProcessedPhoto(job, processedImages)
+
 	// Update photo dimensions and mark as completed
 	log.Printf("[PHOTO_PROCESSING] Marking photo %d as completed", job.ImageId)
-	err = pw.updatePhotoComplete(job.ImageId, processedWidth, processedHeight)
+	err = pw.updatePhotoComplete(job.ImageId, processedWidth, processedHeight, hashes)
 	if err != nil {
 		if errors.Is(err, errPhotoRecordGone) {
 			// The photo was deleted while we were decoding it. The variants
@@ -304,8 +321,9 @@ func (pw *PhotoWorker) updatePhotoStatus(imageId int, status int) error {
 	return updateError
 }
 
-// updatePhotoComplete marks a photo as completed and updates dimensions
-func (pw *PhotoWorker) updatePhotoComplete(imageId int, width, height int) error {
+// updatePhotoComplete marks a photo as completed and updates dimensions and
+// hashes
+func (pw *PhotoWorker) updatePhotoComplete(imageId int, width, height int, hashes photoHashes) error {
 	if pw.db == nil {
 		log.Printf("ERROR: Photo worker has no database reference")
 		return fmt.Errorf("photo worker database not initialized")
@@ -327,8 +345,10 @@ func (pw *PhotoWorker) updatePhotoComplete(imageId int, width, height int) error
 		if height > 0 {
 			image.Height = height
 		}
+		applyPhotoHashes(&image, hashes)
 
 		vbolt.Write(tx, ImagesBkt, image.Id, &image)
+		setImageContentHashTx(tx, image)
 
 		// MUST commit the transaction to persist changes
 		if updateError == nil {
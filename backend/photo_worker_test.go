@@ -390,7 +390,7 @@ func TestUpdatePhotoComplete(t *testing.T) {
 	})
 
 	t.Run("Mark as complete with dimensions", func(t *testing.T) {
-		err := worker.updatePhotoComplete(testImage.Id, 800, 600)
+		err := worker.updatePhotoComplete(testImage.Id, 800, 600, photoHashes{})
 		if err != nil {
 			t.Errorf("Expected no error, got %v", err)
 		}
@@ -420,7 +420,7 @@ func TestUpdatePhotoComplete(t *testing.T) {
 			vbolt.TxCommit(tx)
 		})
 
-		err := worker.updatePhotoComplete(testImage.Id, 0, 0)
+		err := worker.updatePhotoComplete(testImage.Id, 0, 0, photoHashes{})
 		if err != nil {
 			t.Errorf("Expected no error, got %v", err)
 		}
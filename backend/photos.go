@@ -31,6 +31,7 @@ import (
 
 func RegisterPhotoMethods(app *vbeam.Application) {
 	app.HandleFunc("/api/upload-photo", AuthMiddleware(uploadPhotoHandler))
+	app.HandleFunc("/api/upload-video", AuthMiddleware(uploadVideoHandler))
 	app.HandleFunc("/api/photo/", AuthMiddleware(servePhotoHandler))
 	vbeam.RegisterProc(app, GetPhoto)
 	vbeam.RegisterProc(app, UpdatePhoto)
@@ -51,10 +52,17 @@ type AddPhotoRequest struct {
 	PhotoDate   string `json:"photoDate,omitempty"`
 	AgeYears    *int   `json:"ageYears,omitempty"`
 	AgeMonths   *int   `json:"ageMonths,omitempty"`
+	// PhotoDatePrecision is "day" (the default), "month", "season", "year" or
+	// "approximate". An "auto" date comes from the camera and is always a day.
+	PhotoDatePrecision string `json:"photoDatePrecision,omitempty"`
 }
 
 type AddPhotoResponse struct {
 	Image Image `json:"image"`
+	// DuplicateOf is an earlier photo in the family with exactly the same
+	// bytes. The upload is kept regardless; this is what lets the client say
+	// "this looks like photo #123" and offer to merge the two.
+	DuplicateOf int `json:"duplicateOf,omitempty"`
 }
 
 type GetPhotoRequest struct {
@@ -74,6 +82,8 @@ type UpdatePhotoRequest struct {
 	PhotoDate   string `json:"photoDate,omitempty"`
 	AgeYears    *int   `json:"ageYears,omitempty"`
 	AgeMonths   *int   `json:"ageMonths,omitempty"`
+	// PhotoDatePrecision nil leaves the precision as it was.
+	PhotoDatePrecision *string `json:"photoDatePrecision,omitempty"`
 }
 
 type UpdatePhotoResponse struct {
@@ -147,6 +157,33 @@ type Image struct {
 	Status           int       `json:"status"`         // 0 = active, 1 = processing, 2 = hidden
 	AnalysisStatus   int       `json:"analysisStatus"` // 0 = pending, 1 = analyzing, 2 = done, 3 = failed
 	TagIds           []int     `json:"tagIds,omitempty"`
+	// PhotoDatePrecision says how much of PhotoDate to believe; see
+	// date_precision.go. Rows written before it existed read back as "day".
+	PhotoDatePrecision string `json:"photoDatePrecision"`
+	// MediaKind is "photo" or "video"; see video.go. Rows written before
+	// videos existed read back as photos.
+	MediaKind string `json:"mediaKind"`
+	// DurationMs is a video's running time. Photos leave it zero.
+	DurationMs int `json:"durationMs,omitempty"`
+	// ContentHash and PerceptualHash find the same picture uploaded twice;
+	// see duplicates.go. Either is empty until something has computed it.
+	ContentHash    string `json:"contentHash,omitempty"`
+	PerceptualHash string `json:"perceptualHash,omitempty"`
+	// Where and with what the photo was taken, read from its EXIF at upload;
+	// see photo_location.go. HasLocation says the coordinates are real, since
+	// zero is a place too. Place and Country are the nearest city and its
+	// country, and either may be empty when nothing listed is near enough.
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+	HasLocation bool    `json:"hasLocation"`
+	Place       string  `json:"place,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	CameraMake  string  `json:"cameraMake,omitempty"`
+	CameraModel string  `json:"cameraModel,omitempty"`
+	LensModel   string  `json:"lensModel,omitempty"`
+	// LocationHidden keeps the location off every copy of the photo that
+	// leaves the server, and away from anyone outside the family.
+	LocationHidden bool `json:"locationHidden"`
 }
 
 // PhotoPerson represents the many-to-many relationship between photos and people
@@ -161,7 +198,7 @@ type PhotoPerson struct {
 
 // Packing function for vbolt serialization
 func PackImage(self *Image, buf *vpack.Buffer) {
-	version := vpack.Version(3, buf)
+	version := vpack.Version(7, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.Int(&self.FamilyId, buf)
 	vpack.Int(&self.OwnerUserId, buf)
@@ -179,6 +216,32 @@ func PackImage(self *Image, buf *vpack.Buffer) {
 	if version >= 3 {
 		vpack.Int(&self.AnalysisStatus, buf)
 	}
+	if version >= 4 {
+		vpack.String(&self.PhotoDatePrecision, buf)
+	} else {
+		self.PhotoDatePrecision = DatePrecisionDay
+	}
+	if version >= 5 {
+		vpack.String(&self.MediaKind, buf)
+		vpack.Int(&self.DurationMs, buf)
+	} else {
+		self.MediaKind = MediaKindPhoto
+	}
+	if version >= 6 {
+		vpack.String(&self.ContentHash, buf)
+		vpack.String(&self.PerceptualHash, buf)
+	}
+	if version >= 7 {
+		vpack.Float64(&self.Latitude, buf)
+		vpack.Float64(&self.Longitude, buf)
+		vpack.Bool(&self.HasLocation, buf)
+		vpack.String(&self.Place, buf)
+		vpack.String(&self.Country, buf)
+		vpack.String(&self.CameraMake, buf)
+		vpack.String(&self.CameraModel, buf)
+		vpack.String(&self.LensModel, buf)
+		vpack.Bool(&self.LocationHidden, buf)
+	}
 }
 
 // Packing function for PhotoPerson
@@ -469,6 +532,8 @@ func isValidImageType(mimeType string) bool {
 		"image/jpg",
 		"image/png",
 		"image/gif",
+		"image/heic",
+		"image/heif",
 	}
 	for _, validType := range validTypes {
 		if mimeType == validType {
@@ -480,6 +545,10 @@ func isValidImageType(mimeType string) bool {
 
 // Get image dimensions
 func getImageDimensions(file multipart.File) (int, int, error) {
+	if sniffHEIFMimeType(file) != "" {
+		return heifDimensions(file)
+	}
+
 	// Reset file position
 	file.Seek(0, 0)
 
@@ -494,14 +563,31 @@ func getImageDimensions(file multipart.File) (int, int, error) {
 	return config.Width, config.Height, nil
 }
 
-// Extract date from EXIF metadata
-func extractExifDate(fileData []byte) (time.Time, error) {
+// decodeExif reads the EXIF block of a JPEG or HEIC file. Everything taken
+// from a photo's EXIF — the date here, the place and camera in
+// photo_location.go — goes through it, so both formats are read the same way.
+func decodeExif(fileData []byte) (*exif.Exif, error) {
 	reader := bytes.NewReader(fileData)
+	if isHEIF(fileData) {
+		block, ok := heifExif(fileData)
+		if !ok {
+			return nil, errors.New("failed to decode EXIF: no EXIF item in HEIF file")
+		}
+		reader = bytes.NewReader(block)
+	}
 
-	// Decode EXIF data
 	x, err := exif.Decode(reader)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("failed to decode EXIF: %w", err)
+		return nil, fmt.Errorf("failed to decode EXIF: %w", err)
+	}
+	return x, nil
+}
+
+// Extract date from EXIF metadata
+func extractExifDate(fileData []byte) (time.Time, error) {
+	x, err := decodeExif(fileData)
+	if err != nil {
+		return time.Time{}, err
 	}
 
 	// Try to get DateTime tag (when photo was taken)
@@ -559,6 +645,101 @@ func calculatePhotoDate(inputType string, photoDate string, ageYears *int, ageMo
 	}
 }
 
+// calculatePhotoDateAt is calculatePhotoDate at a precision: it accepts the
+// short forms a coarse precision allows and snaps the result to the start of
+// the period. It returns the precision the date ended up with, which for an
+// "auto" date read from the camera is always a day.
+func calculatePhotoDateAt(inputType string, photoDate string, precision string, ageYears *int, ageMonths *int, person Person, fileData []byte) (time.Time, string, error) {
+	if err := validateDatePrecision(precision); err != nil {
+		return time.Time{}, "", err
+	}
+	precision = normalizeDatePrecision(precision)
+	if inputType == "auto" {
+		precision = DatePrecisionDay
+	}
+
+	var date time.Time
+	var err error
+	if inputType == "date" && photoDate != "" {
+		date, err = parseDateValue(photoDate, precision)
+	} else {
+		date, err = calculatePhotoDate(inputType, photoDate, ageYears, ageMonths, person, fileData)
+	}
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return anchorDate(date, precision), precision, nil
+}
+
+// mediaUploadForm is the part of an upload form photos and videos share: who
+// is in it, which family it belongs to, and how to work out when it was taken.
+type mediaUploadForm struct {
+	PersonIds []int
+	// FamilyId names the family the upload belongs to. Zero means the caller's
+	// primary family.
+	FamilyId           int
+	Title              string
+	Description        string
+	InputType          string
+	PhotoDate          string
+	PhotoDatePrecision string
+	AgeYears           *int
+	AgeMonths          *int
+}
+
+func readMediaUploadForm(r *http.Request) (form mediaUploadForm, appErr *AppError) {
+	// Parse form data for person IDs (can be multiple)
+	if personIdsStr := r.FormValue("personIds"); personIdsStr != "" {
+		// Parse JSON array of person IDs
+		if err := json.Unmarshal([]byte(personIdsStr), &form.PersonIds); err != nil {
+			return form, NewAppError(ErrCodeValidation, "The people tagged on this photo could not be read.", err.Error())
+		}
+	}
+
+	if familyIdStr := r.FormValue("familyId"); familyIdStr != "" {
+		parsed, err := strconv.Atoi(familyIdStr)
+		if err != nil {
+			return form, NewAppError(ErrCodeValidation, "That family could not be identified.", err.Error())
+		}
+		form.FamilyId = parsed
+	}
+
+	form.Title = strings.TrimSpace(r.FormValue("title"))
+	form.Description = strings.TrimSpace(r.FormValue("description"))
+	form.InputType = r.FormValue("inputType")
+	form.PhotoDate = r.FormValue("photoDate")
+	form.PhotoDatePrecision = r.FormValue("photoDatePrecision")
+
+	if ageYearsStr := r.FormValue("ageYears"); ageYearsStr != "" {
+		if years, err := strconv.Atoi(ageYearsStr); err == nil {
+			form.AgeYears = &years
+		}
+	}
+	if ageMonthsStr := r.FormValue("ageMonths"); ageMonthsStr != "" {
+		if months, err := strconv.Atoi(ageMonthsStr); err == nil {
+			form.AgeMonths = &months
+		}
+	}
+	return form, nil
+}
+
+// taggedPeopleForUpload checks that every person an upload is tagged with can
+// be tagged by familyId.
+func taggedPeopleForUpload(tx *vbolt.Tx, familyId int, personIds []int) ([]Person, *AppError) {
+	people := make([]Person, 0, len(personIds))
+	for _, personId := range personIds {
+		person := GetPersonById(tx, personId)
+		if person.Id == 0 || !CanFamilyAccess(tx, familyId, person.FamilyId, AccessContribute) {
+			// Deliberately the same answer for "no such person" and "not your
+			// person": telling them apart would let a caller probe for ids
+			// outside their family.
+			return nil, NewAppError(ErrCodeValidation, "One of the tagged people is not in your family.")
+		}
+		people = append(people, person)
+	}
+	return people, nil
+}
+
 // Upload photo handler
 func uploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
@@ -592,47 +773,13 @@ func uploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
 		"userId": user.Id,
 	})
 
-	// Parse form data for person IDs (can be multiple)
-	personIdsStr := r.FormValue("personIds")
-	var personIds []int
-
-	if personIdsStr != "" {
-		// Parse JSON array of person IDs
-		if err := json.Unmarshal([]byte(personIdsStr), &personIds); err != nil {
-			RespondValidationError(w, r, "The people tagged on this photo could not be read.", err.Error())
-			return
-		}
-	}
-
-	// familyId names the family the photo belongs to. Absent or blank means
-	// the caller's primary family.
-	var requestedFamilyId int
-	if familyIdStr := r.FormValue("familyId"); familyIdStr != "" {
-		parsed, convErr := strconv.Atoi(familyIdStr)
-		if convErr != nil {
-			RespondValidationError(w, r, "That family could not be identified.", convErr.Error())
-			return
-		}
-		requestedFamilyId = parsed
-	}
-
-	title := strings.TrimSpace(r.FormValue("title"))
-
-	description := strings.TrimSpace(r.FormValue("description"))
-	inputType := r.FormValue("inputType")
-	photoDate := r.FormValue("photoDate")
-
-	var ageYears, ageMonths *int
-	if ageYearsStr := r.FormValue("ageYears"); ageYearsStr != "" {
-		if years, err := strconv.Atoi(ageYearsStr); err == nil {
-			ageYears = &years
-		}
-	}
-	if ageMonthsStr := r.FormValue("ageMonths"); ageMonthsStr != "" {
-		if months, err := strconv.Atoi(ageMonthsStr); err == nil {
-			ageMonths = &months
-		}
+	form, formErr := readMediaUploadForm(r)
+	if formErr != nil {
+		RespondWithError(w, r, formErr, statusForErrorCode(formErr.Code))
+		return
 	}
+	personIds := form.PersonIds
+	title := form.Title
 
 	// Get the uploaded file
 	file, fileHeader, err := r.FormFile("photo")
@@ -651,7 +798,10 @@ func uploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate file type
 	mimeType := fileHeader.Header.Get("Content-Type")
 	if !isValidImageType(mimeType) {
-		RespondInvalidFileTypeError(w, r, "JPEG, PNG, GIF")
+		mimeType = sniffHEIFMimeType(file)
+	}
+	if !isValidImageType(mimeType) {
+		RespondInvalidFileTypeError(w, r, "JPEG, PNG, GIF, HEIC")
 		return
 	}
 
@@ -670,30 +820,20 @@ func uploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
 	// somebody else's child and a user whose disk is full deserve different
 	// answers, so each path records one.
 	var image Image
+	var duplicateOf int
 	var validPersons []Person
 	var uploadErr *AppError
 
 	vbolt.WithWriteTx(appDb, func(tx *vbolt.Tx) {
-		familyId, err := ResolveActingFamily(tx, user, requestedFamilyId, AccessContribute)
+		familyId, err := ResolveActingFamily(tx, user, form.FamilyId, AccessContribute)
 		if err != nil {
 			uploadErr = NewAppError(ErrCodeForbidden, "You cannot add photos to that family.", err.Error())
 			return
 		}
 
 		// Validate all person IDs exist and belong to that family
-		if len(personIds) > 0 {
-			validPersons = make([]Person, 0, len(personIds))
-			for _, personId := range personIds {
-				person := GetPersonById(tx, personId)
-				if person.Id == 0 || !CanFamilyAccess(tx, familyId, person.FamilyId, AccessContribute) {
-					// Deliberately the same answer for "no such person" and
-					// "not your person": telling them apart would let a caller
-					// probe for ids outside their family.
-					uploadErr = NewAppError(ErrCodeValidation, "One of the tagged people is not in your family.")
-					return
-				}
-				validPersons = append(validPersons, person)
-			}
+		if validPersons, uploadErr = taggedPeopleForUpload(tx, familyId, personIds); uploadErr != nil {
+			return
 		}
 
 		// Generate unique filename
@@ -725,7 +865,7 @@ func uploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
 		if len(validPersons) > 0 {
 			referencePerson = validPersons[0]
 		}
-		calculatedPhotoDate, err := calculatePhotoDate(inputType, photoDate, ageYears, ageMonths, referencePerson, fileData)
+		calculatedPhotoDate, calculatedPrecision, err := calculatePhotoDateAt(form.InputType, form.PhotoDate, form.PhotoDatePrecision, form.AgeYears, form.AgeMonths, referencePerson, fileData)
 		if err != nil {
 			uploadErr = NewAppError(ErrCodeValidation, "That photo date could not be worked out. Check the date or age you entered.", err.Error())
 			return
@@ -747,27 +887,37 @@ func uploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
 			origFile.Close()
 		}
 
+		// An exact copy is reported rather than refused: the client offers the
+		// merge, and a bulk upload should not fail halfway over one repeat.
+		hash := contentHash(fileData)
+		duplicateOf = findPhotoByContentHash(tx, familyId, hash)
+
 		// Create image record
 		image = Image{
-			Id:               vbolt.NextIntId(tx, ImagesBkt),
-			FamilyId:         familyId,
-			OwnerUserId:      user.Id,
-			OriginalFilename: fileHeader.Filename,
-			MimeType:         mimeType,
-			FileSize:         int(fileHeader.Size), // Original file size for now
-			Width:            width,
-			Height:           height,
-			FilePath:         fmt.Sprintf("photos/%s", uniqueFilename),
-			Title:            title,
-			Description:      description,
-			PhotoDate:        calculatedPhotoDate,
-			CreatedAt:        time.Now(),
-			Status:           1, // Processing
+			Id:                 vbolt.NextIntId(tx, ImagesBkt),
+			FamilyId:           familyId,
+			OwnerUserId:        user.Id,
+			OriginalFilename:   fileHeader.Filename,
+			MimeType:           mimeType,
+			FileSize:           int(fileHeader.Size), // Original file size for now
+			Width:              width,
+			Height:             height,
+			FilePath:           fmt.Sprintf("photos/%s", uniqueFilename),
+			Title:              title,
+			Description:        form.Description,
+			PhotoDate:          calculatedPhotoDate,
+			CreatedAt:          time.Now(),
+			Status:             1, // Processing
+			PhotoDatePrecision: calculatedPrecision,
+			MediaKind:          MediaKindPhoto,
+			ContentHash:        hash,
 		}
+		applyPhotoMetadata(&image, extractPhotoMetadata(fileData))
 
 		// Save image to database
 		vbolt.Write(tx, ImagesBkt, image.Id, &image)
 		vbolt.SetTargetSingleTerm(tx, ImageByFamilyIndex, image.Id, familyId)
+		setImageContentHashTx(tx, image)
 
 		// Create PhotoPerson relationships for each tagged person
 		for _, person := range validPersons {
@@ -838,7 +988,8 @@ func uploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
 	// Return success response (TagIds will be empty for new uploads)
 	image.TagIds = []int{}
 	response := AddPhotoResponse{
-		Image: image,
+		Image:       image,
+		DuplicateOf: duplicateOf,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -930,6 +1081,7 @@ func servePhotoHandler(w http.ResponseWriter, r *http.Request) {
 	validSizes := map[string]bool{
 		"small": true, "thumb": true, "medium": true,
 		"large": true, "xlarge": true, "xxlarge": true, "original": true,
+		"video": true,
 	}
 
 	if sizeVariant != "" && !validSizes[sizeVariant] {
@@ -937,6 +1089,16 @@ func servePhotoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A clip plays from its "video" variant, which is its original file; every
+	// other size of a clip is its poster frame.
+	if sizeVariant == "video" {
+		if image.MediaKind != MediaKindVideo {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		sizeVariant = "original"
+	}
+
 	// Default to large if no size specified
 	if sizeVariant == "" {
 		sizeVariant = "large"
@@ -998,6 +1160,11 @@ func servePhotoHandler(w http.ResponseWriter, r *http.Request) {
 			if _, err := os.Stat(fallbackPath); err == nil {
 				fullPath = fallbackPath
 				contentType = "image/jpeg"
+			} else if image.MediaKind == MediaKindVideo {
+				// No poster frame was made. The original is the clip itself,
+				// which an <img> cannot show.
+				serveVideoPlaceholder(w, r)
+				return
 			} else {
 				// Ultimate fallback to original file
 				originalPath := baseFilename + "_original" + filepath.Ext(basePath)
@@ -1015,6 +1182,10 @@ func servePhotoHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// An original whose location is hidden goes out without it; the other
+	// sizes were re-encoded and never carried EXIF. See photo_location.go.
+	withoutLocation := image.LocationHidden && fullPath == baseFilename+"_original"+filepath.Ext(basePath)
+
 	// Set content type based on determined optimal format
 	w.Header().Set("Content-Type", contentType)
 
@@ -1029,12 +1200,24 @@ func servePhotoHandler(w http.ResponseWriter, r *http.Request) {
 	// minutes of free reuse, then revalidate against the ETag, which already
 	// covers the id, variant, creation time, and processing status.
 	w.Header().Set("Cache-Control", photoCacheControl)
-	w.Header().Set("ETag", fmt.Sprintf("\"%d-%s-%d-%d\"", image.Id, sizeVariant, image.CreatedAt.Unix(), image.Status))
+	etag := fmt.Sprintf("%d-%s-%d-%d", image.Id, sizeVariant, image.CreatedAt.Unix(), image.Status)
+	if withoutLocation {
+		// The bytes differ from the ones served before the switch was
+		// turned on, and a revalidating browser must not keep those.
+		etag += "-nolocation"
+	}
+	w.Header().Set("ETag", "\""+etag+"\"")
 
 	// Add Vary header for content negotiation
 	w.Header().Set("Vary", "Accept")
 
-	// Serve the file
+	// Serve the file. ServeFile answers Range requests itself, which is what
+	// lets a <video> element start playing and seek without downloading the
+	// whole clip first; the ETag above keeps If-Range honest.
+	if withoutLocation {
+		serveStrippedOriginal(w, r, image, fullPath)
+		return
+	}
 	http.ServeFile(w, r, fullPath)
 }
 
@@ -1132,11 +1315,12 @@ func GetPhoto(ctx *vbeam.Context, req GetPhotoRequest) (resp GetPhotoResponse, e
 
 	// Calculate age for all people
 	for i := range people {
-		people[i].Age = calculateAge(people[i].Birthday)
+		people[i].Age = personAge(people[i])
 	}
 
 	resp.Image = photo
 	resp.Image.TagIds = GetPhotoTagIds(ctx.Tx, photo.Id)
+	redactPhotoLocation(ctx.Tx, user, &resp.Image)
 	resp.People = people
 	return
 }
@@ -1172,7 +1356,11 @@ func UpdatePhoto(ctx *vbeam.Context, req UpdatePhotoRequest) (resp UpdatePhotoRe
 	}
 
 	// Calculate new photo date
-	calculatedPhotoDate, err := calculatePhotoDate(req.InputType, req.PhotoDate, req.AgeYears, req.AgeMonths, referencePerson, nil)
+	precision := photo.PhotoDatePrecision
+	if req.PhotoDatePrecision != nil {
+		precision = *req.PhotoDatePrecision
+	}
+	calculatedPhotoDate, calculatedPrecision, err := calculatePhotoDateAt(req.InputType, req.PhotoDate, precision, req.AgeYears, req.AgeMonths, referencePerson, nil)
 	if err != nil {
 		return
 	}
@@ -1181,6 +1369,7 @@ func UpdatePhoto(ctx *vbeam.Context, req UpdatePhotoRequest) (resp UpdatePhotoRe
 	photo.Title = strings.TrimSpace(req.Title)
 	photo.Description = strings.TrimSpace(req.Description)
 	photo.PhotoDate = calculatedPhotoDate
+	photo.PhotoDatePrecision = calculatedPrecision
 
 	// Generate title if empty
 	if photo.Title == "" {
@@ -1242,11 +1431,16 @@ func deletePhotoRecordTx(tx *vbolt.Tx, photo Image) {
 	}
 
 	removePhotoFromMilestones(tx, photo.Id)
+	removePhotoFromGrowthData(tx, photo.Id)
 	removePhotoFromActivities(tx, photo.Id)
+	removePhotoFromSchoolYears(tx, photo.Id)
+	removePhotoFromAlbums(tx, photo.Id)
 	removeAllPhotoTags(tx, photo.Id)
+	deleteTargetCommentsTx(tx, CommentTarget{Kind: CommentTargetPhoto, Id: photo.Id})
 
 	vbolt.Delete(tx, ImagesBkt, photo.Id)
 	vbolt.SetTargetSingleTerm(tx, ImageByFamilyIndex, photo.Id, -1)
+	clearImageContentHashTx(tx, photo.Id)
 }
 
 // Helper function to delete all photo file variants
@@ -1317,6 +1511,10 @@ func validateUpdatePhotoRequest(req UpdatePhotoRequest) error {
 		return errors.New("Age years is required when input type is 'age'")
 	}
 
+	if req.PhotoDatePrecision != nil {
+		return validateDatePrecision(*req.PhotoDatePrecision)
+	}
+
 	return nil
 }
 
@@ -1410,10 +1608,11 @@ func ListFamilyPhotos(ctx *vbeam.Context, req ListFamilyPhotosRequest) (resp Lis
 
 		// Calculate age for all people
 		for i := range people {
-			people[i].Age = calculateAge(people[i].Birthday)
+			people[i].Age = personAge(people[i])
 		}
 
 		image.TagIds = GetPhotoTagIds(ctx.Tx, image.Id)
+		redactPhotoLocation(ctx.Tx, user, &image)
 
 		// Add to response (photos without people are still included)
 		resp.Photos = append(resp.Photos, PhotoWithPeople{
@@ -1477,7 +1676,7 @@ func AddPeopleToPhoto(ctx *vbeam.Context, req AddPeopleToPhotoRequest) (resp Add
 
 		// Add the association
 		AddPersonToPhoto(ctx.Tx, req.PhotoId, personId, photo.FamilyId)
-		person.Age = calculateAge(person.Birthday)
+		person.Age = personAge(person)
 		addedPeople = append(addedPeople, person)
 	}
 
@@ -283,6 +283,8 @@ func TestIsValidImageType(t *testing.T) {
 		{"image/jpg", true},
 		{"image/png", true},
 		{"image/gif", true},
+		{"image/heic", true},
+		{"image/heif", true},
 		{"image/webp", false},
 		{"image/bmp", false},
 		{"text/plain", false},
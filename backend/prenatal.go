@@ -0,0 +1,527 @@
+package backend
+
+import (
+	"errors"
+	"family/cfg"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// Prenatal tracking.
+//
+// A pregnancy is a Person with IsPregnancy set and the due date in Birthday.
+// Until now the only thing that could hang off one was a milestone. The
+// measurements a pregnancy produces are not growth data: they are read off an
+// ultrasound report or a midwife's tape by gestational week, not by date of
+// birth, and two of the three are not measurements of the baby at all. So they
+// have their own bucket, keyed by week, and the growth code — percentiles,
+// flags, BMI — never sees them.
+//
+// RecordBirth is the hinge between the two. In one transaction it turns the
+// due date into the birthdate, writes birth weight and length as the first
+// growth points, and records the birth as a milestone. The prenatal rows stay
+// where they are, as the history of the pregnancy that became this child.
+
+func RegisterPrenatalMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, AddPrenatalMeasurement)
+	vbeam.RegisterProc(app, ListPrenatalMeasurements)
+	vbeam.RegisterProc(app, DeletePrenatalMeasurement)
+	vbeam.RegisterProc(app, RecordBirth)
+}
+
+type PrenatalMeasurementType int
+
+const (
+	// FetalWeightEstimate is the ultrasound's estimated fetal weight.
+	FetalWeightEstimate PrenatalMeasurementType = iota
+	// FundalHeight is the bump measured from pubic bone to the top of the uterus.
+	FundalHeight
+	// MaternalWeight is the mother's weight at the appointment.
+	MaternalWeight
+)
+
+var prenatalTypeNames = map[string]PrenatalMeasurementType{
+	"fetal_weight":    FetalWeightEstimate,
+	"fundal_height":   FundalHeight,
+	"maternal_weight": MaternalWeight,
+}
+
+// prenatalUnits lists the units each prenatal measurement may be recorded in.
+// An ultrasound report gives fetal weight in grams; some also print pounds.
+var prenatalUnits = map[PrenatalMeasurementType][]string{
+	FetalWeightEstimate: {"g", "lbs"},
+	FundalHeight:        {"cm", "in"},
+	MaternalWeight:      {"kg", "lbs"},
+}
+
+// The range of gestational weeks a measurement can be recorded at. A dating
+// scan can come as early as week 4; a pregnancy past 42 weeks is induced.
+const (
+	minGestationalWeek = 4
+	maxGestationalWeek = 42
+	termWeeks          = 40
+)
+
+type PrenatalMeasurement struct {
+	Id              int                     `json:"id"`
+	PersonId        int                     `json:"personId"`
+	FamilyId        int                     `json:"familyId"`
+	MeasurementType PrenatalMeasurementType `json:"measurementType"`
+	GestationalWeek int                     `json:"gestationalWeek"`
+	Value           float64                 `json:"value"`
+	Unit            string                  `json:"unit"`
+	// MeasurementDate is the appointment date, which defaults to the start of
+	// the gestational week counted back from the due date.
+	MeasurementDate time.Time `json:"measurementDate"`
+	Notes           string    `json:"notes"`
+	CreatedAt       time.Time `json:"createdAt"`
+
+	// Display fields follow the reader's preferences, as on GrowthData.
+	DisplayValue float64 `json:"displayValue,omitempty"`
+	DisplayUnit  string  `json:"displayUnit,omitempty"`
+	DisplayDate  string  `json:"displayDate,omitempty"`
+}
+
+func PackPrenatalMeasurement(self *PrenatalMeasurement, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.IntEnum(&self.MeasurementType, buf)
+	vpack.Int(&self.GestationalWeek, buf)
+	vpack.Float64(&self.Value, buf)
+	vpack.String(&self.Unit, buf)
+	vpack.Time(&self.MeasurementDate, buf)
+	vpack.String(&self.Notes, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+var PrenatalMeasurementBkt = vbolt.Bucket(&cfg.Info, "prenatal_measurements", vpack.FInt, PackPrenatalMeasurement)
+
+// PrenatalByPersonIndex: term = person_id, target = prenatal_measurement_id
+var PrenatalByPersonIndex = vbolt.Index(&cfg.Info, "prenatal_by_person", vpack.FInt, vpack.FInt)
+
+// PrenatalByFamilyIndex: term = family_id, target = prenatal_measurement_id
+var PrenatalByFamilyIndex = vbolt.Index(&cfg.Info, "prenatal_by_family", vpack.FInt, vpack.FInt)
+
+// Request/Response types
+
+type AddPrenatalMeasurementRequest struct {
+	PersonId        int     `json:"personId"`
+	MeasurementType string  `json:"measurementType"` // "fetal_weight", "fundal_height" or "maternal_weight"
+	GestationalWeek int     `json:"gestationalWeek"`
+	Value           float64 `json:"value"`
+	Unit            string  `json:"unit"`                      // g, lbs, cm, in, kg
+	MeasurementDate *string `json:"measurementDate,omitempty"` // YYYY-MM-DD; defaults from the week
+	Notes           string  `json:"notes"`
+}
+
+type AddPrenatalMeasurementResponse struct {
+	Measurement PrenatalMeasurement `json:"measurement"`
+}
+
+type ListPrenatalMeasurementsRequest struct {
+	PersonId int `json:"personId"`
+}
+
+type ListPrenatalMeasurementsResponse struct {
+	Measurements []PrenatalMeasurement `json:"measurements"`
+}
+
+type DeletePrenatalMeasurementRequest struct {
+	Id int `json:"id"`
+}
+
+type DeletePrenatalMeasurementResponse struct {
+	Success bool `json:"success"`
+}
+
+type RecordBirthRequest struct {
+	PersonId  int    `json:"personId"`
+	Birthdate string `json:"birthdate"` // YYYY-MM-DD
+	// Name and Gender are optional: a pregnancy is often recorded as "Baby"
+	// with an unknown sex, and both are settled at the birth.
+	Name        *string  `json:"name,omitempty"`
+	Gender      *int     `json:"gender,omitempty"`
+	BirthWeight *float64 `json:"birthWeight,omitempty"`
+	WeightUnit  string   `json:"weightUnit"` // kg or lbs
+	BirthLength *float64 `json:"birthLength,omitempty"`
+	LengthUnit  string   `json:"lengthUnit"` // cm or in
+}
+
+type RecordBirthResponse struct {
+	Person     Person       `json:"person"`
+	GrowthData []GrowthData `json:"growthData"`
+	Milestone  Milestone    `json:"milestone"`
+}
+
+// Database helper functions
+
+func GetPrenatalMeasurementById(tx *vbolt.Tx, id int) (measurement PrenatalMeasurement) {
+	vbolt.Read(tx, PrenatalMeasurementBkt, id, &measurement)
+	return
+}
+
+func GetPersonPrenatalMeasurementsTx(tx *vbolt.Tx, personId int) []PrenatalMeasurement {
+	measurements := []PrenatalMeasurement{}
+	var ids []int
+	vbolt.ReadTermTargets(tx, PrenatalByPersonIndex, personId, &ids, vbolt.Window{})
+	if len(ids) > 0 {
+		vbolt.ReadSlice(tx, PrenatalMeasurementBkt, ids, &measurements)
+	}
+	return measurements
+}
+
+func getFamilyPrenatalMeasurements(tx *vbolt.Tx, familyId int) (measurements []PrenatalMeasurement) {
+	var ids []int
+	vbolt.ReadTermTargets(tx, PrenatalByFamilyIndex, familyId, &ids, vbolt.Window{})
+	if len(ids) > 0 {
+		vbolt.ReadSlice(tx, PrenatalMeasurementBkt, ids, &measurements)
+	}
+	return
+}
+
+func updatePrenatalIndices(tx *vbolt.Tx, measurement PrenatalMeasurement) {
+	vbolt.SetTargetSingleTerm(tx, PrenatalByPersonIndex, measurement.Id, measurement.PersonId)
+	vbolt.SetTargetSingleTerm(tx, PrenatalByFamilyIndex, measurement.Id, measurement.FamilyId)
+}
+
+func deletePrenatalMeasurementTx(tx *vbolt.Tx, measurement PrenatalMeasurement) {
+	vbolt.SetTargetSingleTerm(tx, PrenatalByPersonIndex, measurement.Id, -1)
+	vbolt.SetTargetSingleTerm(tx, PrenatalByFamilyIndex, measurement.Id, -1)
+	vbolt.Delete(tx, PrenatalMeasurementBkt, measurement.Id)
+}
+
+// gestationalWeekStart is the first day of a gestational week, counted back
+// from the due date, which is the first day of week 40.
+func gestationalWeekStart(dueDate time.Time, week int) time.Time {
+	return dueDate.AddDate(0, 0, -(termWeeks-week)*7)
+}
+
+// gestationalWeekAt is the completed gestational week on a date, the same
+// count calculateGestationalAgeAt puts into words.
+func gestationalWeekAt(dueDate time.Time, at time.Time) int {
+	days := dueDate.Sub(at).Hours() / 24
+	return termWeeks - int(math.Ceil(days/7))
+}
+
+func validateAddPrenatalMeasurementRequest(req AddPrenatalMeasurementRequest) error {
+	if req.PersonId <= 0 {
+		return errors.New("Person ID is required")
+	}
+	measurementType, ok := prenatalTypeNames[req.MeasurementType]
+	if !ok {
+		return errors.New("Measurement type must be 'fetal_weight', 'fundal_height' or 'maternal_weight'")
+	}
+	if req.GestationalWeek < minGestationalWeek || req.GestationalWeek > maxGestationalWeek {
+		return fmt.Errorf("Gestational week must be between %d and %d", minGestationalWeek, maxGestationalWeek)
+	}
+	if req.Value <= 0 {
+		return errors.New("Measurement value must be positive")
+	}
+	for _, unit := range prenatalUnits[measurementType] {
+		if req.Unit == unit {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unit must be one of: %s", strings.Join(prenatalUnits[measurementType], ", "))
+}
+
+func AddPrenatalMeasurementTx(tx *vbolt.Tx, req AddPrenatalMeasurementRequest, familyId int) (PrenatalMeasurement, error) {
+	var measurement PrenatalMeasurement
+
+	person := GetPersonById(tx, req.PersonId)
+	if person.Id == 0 || !CanFamilyAccess(tx, familyId, person.FamilyId, AccessContribute) {
+		return measurement, errors.New("Person not found or not in your family")
+	}
+	// Once the baby is born the record is a child, and what was a prenatal
+	// measurement is now a growth measurement.
+	if !person.IsPregnancy {
+		return measurement, errors.New("Prenatal measurements can only be added to a pregnancy")
+	}
+
+	measurement.MeasurementDate = gestationalWeekStart(person.Birthday, req.GestationalWeek)
+	if req.MeasurementDate != nil && *req.MeasurementDate != "" {
+		parsed, err := time.Parse("2006-01-02", *req.MeasurementDate)
+		if err != nil {
+			return measurement, errors.New("Invalid measurement date format. Use YYYY-MM-DD")
+		}
+		measurement.MeasurementDate = parsed
+	}
+
+	measurement.Id = vbolt.NextIntId(tx, PrenatalMeasurementBkt)
+	measurement.PersonId = person.Id
+	measurement.FamilyId = familyId
+	measurement.MeasurementType = prenatalTypeNames[req.MeasurementType]
+	measurement.GestationalWeek = req.GestationalWeek
+	measurement.Value = req.Value
+	measurement.Unit = req.Unit
+	measurement.Notes = strings.TrimSpace(req.Notes)
+	measurement.CreatedAt = time.Now()
+
+	vbolt.Write(tx, PrenatalMeasurementBkt, measurement.Id, &measurement)
+	updatePrenatalIndices(tx, measurement)
+	return measurement, nil
+}
+
+// displayPrenatalValue converts a prenatal value into the reader's unit
+// system. Fetal weight goes to grams rather than kilograms, since that is what
+// every ultrasound report prints.
+func (prefs UserPreferences) displayPrenatalValue(measurementType PrenatalMeasurementType, value float64, unit string) (float64, string) {
+	switch measurementType {
+	case FundalHeight:
+		return prefs.displayGrowthValue(Height, value, unit)
+	case MaternalWeight:
+		return prefs.displayGrowthValue(Weight, value, unit)
+	}
+	switch {
+	case prefs.UnitSystem == UnitSystemMetric && unit == "lbs":
+		return math.Round(value / 2.20462 * 1000), "g"
+	case prefs.UnitSystem == UnitSystemImperial && unit == "g":
+		return math.Round(value/1000*2.20462*100) / 100, "lbs"
+	}
+	return value, unit
+}
+
+func localizePrenatalMeasurements(prefs UserPreferences, measurements []PrenatalMeasurement) []PrenatalMeasurement {
+	for i := range measurements {
+		m := &measurements[i]
+		m.DisplayValue, m.DisplayUnit = prefs.displayPrenatalValue(m.MeasurementType, m.Value, m.Unit)
+		m.DisplayDate = prefs.formatDate(m.MeasurementDate)
+	}
+	return measurements
+}
+
+func validateRecordBirthRequest(req RecordBirthRequest) error {
+	if req.PersonId <= 0 {
+		return errors.New("Person ID is required")
+	}
+	if req.Birthdate == "" {
+		return errors.New("Birthdate is required")
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		return errors.New("Name cannot be empty")
+	}
+	if req.Gender != nil && (*req.Gender < int(Male) || *req.Gender > int(Unknown)) {
+		return errors.New("Invalid gender")
+	}
+	if req.BirthWeight != nil {
+		if err := validateMeasurement("weight", *req.BirthWeight, req.WeightUnit); err != nil {
+			return err
+		}
+	}
+	if req.BirthLength != nil {
+		if err := validateMeasurement("height", *req.BirthLength, req.LengthUnit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// birthMilestoneDescription words the birth milestone, with the gestational
+// age and the birth measurements when they are known.
+func birthMilestoneDescription(name string, gestationalWeek int, req RecordBirthRequest) string {
+	description := fmt.Sprintf("%s was born at %d weeks", name, gestationalWeek)
+	var details []string
+	if req.BirthWeight != nil {
+		details = append(details, fmt.Sprintf("%g %s", *req.BirthWeight, req.WeightUnit))
+	}
+	if req.BirthLength != nil {
+		details = append(details, fmt.Sprintf("%g %s long", *req.BirthLength, req.LengthUnit))
+	}
+	if len(details) > 0 {
+		description += ", " + strings.Join(details, ", ")
+	}
+	return description
+}
+
+// RecordBirthTx turns a pregnancy into a child. Everything it writes is in the
+// caller's transaction, so a failure part way leaves the pregnancy untouched.
+func RecordBirthTx(tx *vbolt.Tx, req RecordBirthRequest, familyId int, now time.Time) (resp RecordBirthResponse, err error) {
+	person := GetPersonById(tx, req.PersonId)
+	if person.Id == 0 || !CanFamilyAccess(tx, familyId, person.FamilyId, AccessContribute) {
+		err = errors.New("Person not found or not in your family")
+		return
+	}
+	if !person.IsPregnancy {
+		err = errors.New("This person has already been born")
+		return
+	}
+
+	birthdate, parseErr := time.Parse("2006-01-02", req.Birthdate)
+	if parseErr != nil {
+		err = errors.New("Invalid birthdate format. Use YYYY-MM-DD")
+		return
+	}
+	if birthdate.After(now) {
+		err = errors.New("Birthdate cannot be in the future")
+		return
+	}
+	gestationalWeek := gestationalWeekAt(person.Birthday, birthdate)
+	if gestationalWeek < 20 {
+		err = errors.New("Birthdate is too long before the due date")
+		return
+	}
+	if gestationalWeek > maxGestationalWeek+2 {
+		err = errors.New("Birthdate is too long after the due date")
+		return
+	}
+
+	if req.Name != nil {
+		person.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Gender != nil {
+		person.Gender = GenderType(*req.Gender)
+	}
+	person.Birthday = birthdate
+	person.IsPregnancy = false
+	person.Age = personAge(person)
+	vbolt.Write(tx, PeopleBkt, person.Id, &person)
+
+	resp.Person = person
+	resp.GrowthData = []GrowthData{}
+	birthday := req.Birthdate
+	for _, m := range []struct {
+		typeName string
+		value    *float64
+		unit     string
+	}{
+		{"weight", req.BirthWeight, req.WeightUnit},
+		{"height", req.BirthLength, req.LengthUnit},
+	} {
+		if m.value == nil {
+			continue
+		}
+		var gd GrowthData
+		gd, err = AddGrowthDataTx(tx, AddGrowthDataRequest{
+			PersonId:        person.Id,
+			MeasurementType: m.typeName,
+			Value:           *m.value,
+			Unit:            m.unit,
+			InputType:       "date",
+			MeasurementDate: &birthday,
+		}, person.FamilyId)
+		if err != nil {
+			return
+		}
+		resp.GrowthData = append(resp.GrowthData, gd)
+	}
+
+	resp.Milestone, err = AddMilestoneTx(tx, AddMilestoneRequest{
+		PersonId:      person.Id,
+		Description:   birthMilestoneDescription(person.Name, gestationalWeek, req),
+		Category:      "first",
+		InputType:     "date",
+		MilestoneDate: &birthday,
+	}, person.FamilyId)
+	return
+}
+
+// vbeam procedures
+
+func AddPrenatalMeasurement(ctx *vbeam.Context, req AddPrenatalMeasurementRequest) (resp AddPrenatalMeasurementResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateAddPrenatalMeasurementRequest(req); err != nil {
+		return
+	}
+
+	familyId, err := ActingFamilyForPerson(ctx.Tx, user, req.PersonId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	measurement, err := AddPrenatalMeasurementTx(ctx.Tx, req, familyId)
+	if err != nil {
+		return
+	}
+	// The preferences are read before the commit closes the transaction.
+	resp.Measurement = localizePrenatalMeasurements(loadUserPreferences(ctx.Tx, user.Id), []PrenatalMeasurement{measurement})[0]
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+func ListPrenatalMeasurements(ctx *vbeam.Context, req ListPrenatalMeasurementsRequest) (resp ListPrenatalMeasurementsResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	// Prenatal measurements are medical data, shared across a link only
+	// when the link carries measurements.
+	person := GetPersonById(ctx.Tx, req.PersonId)
+	if !CanAccessPerson(ctx.Tx, user, person, ScopeGrowth, AccessView) {
+		err = errors.New("Person not found or not in your family")
+		return
+	}
+
+	measurements := GetPersonPrenatalMeasurementsTx(ctx.Tx, person.Id)
+	resp.Measurements = localizePrenatalMeasurements(loadUserPreferences(ctx.Tx, user.Id), measurements)
+	return
+}
+
+func DeletePrenatalMeasurement(ctx *vbeam.Context, req DeletePrenatalMeasurementRequest) (resp DeletePrenatalMeasurementResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	measurement := GetPrenatalMeasurementById(ctx.Tx, req.Id)
+	if measurement.Id == 0 || !CanAccessRecordOfPerson(ctx.Tx, user, measurement.FamilyId, measurement.PersonId, ScopeGrowth, AccessContribute) {
+		err = errors.New("Prenatal measurement not found")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	deletePrenatalMeasurementTx(ctx.Tx, measurement)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Success = true
+	return
+}
+
+func RecordBirth(ctx *vbeam.Context, req RecordBirthRequest) (resp RecordBirthResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateRecordBirthRequest(req); err != nil {
+		return
+	}
+
+	familyId, err := ActingFamilyForPerson(ctx.Tx, user, req.PersonId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	resp, err = RecordBirthTx(ctx.Tx, req, familyId, time.Now())
+	if err != nil {
+		return
+	}
+	// The preferences are read before the commit closes the transaction.
+	resp.GrowthData = localizeGrowthData(loadUserPreferences(ctx.Tx, user.Id), annotateGrowthPercentiles(resp.Person, resp.GrowthData))
+	vbolt.TxCommit(ctx.Tx)
+
+	LogInfo("DATA", "Birth recorded", map[string]any{
+		"userId":   user.Id,
+		"personId": resp.Person.Id,
+		"familyId": resp.Person.FamilyId,
+	})
+	return
+}
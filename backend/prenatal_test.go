@@ -0,0 +1,249 @@
+package backend
+
+import (
+	"family/cfg"
+	"strings"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type prenatalFixture struct {
+	db      *vbolt.DB
+	parent  User
+	bump    Person
+	toddler Person
+}
+
+func setupPrenatalFixture(t *testing.T) prenatalFixture {
+	t.Helper()
+
+	db := vbolt.Open(t.TempDir() + "/prenatal.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	t.Cleanup(func() { _ = db.Close() })
+
+	fx := prenatalFixture{db: db}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		fx.parent = AddUserTx(tx, CreateAccountRequest{Name: "Parent", Email: "parent@example.com"}, hash)
+
+		var err error
+		fx.bump, err = AddPersonTx(tx, AddPersonRequest{
+			Name: "Baby", PersonType: 1, Gender: int(Unknown), Birthdate: "2024-06-01", IsPregnancy: true,
+		}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		fx.toddler, err = AddPersonTx(tx, AddPersonRequest{
+			Name: "Toddler", PersonType: 1, Gender: 0, Birthdate: "2022-01-01",
+		}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+	return fx
+}
+
+func TestValidateAddPrenatalMeasurementRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     AddPrenatalMeasurementRequest
+		wantErr bool
+	}{
+		{"fetal weight in grams", AddPrenatalMeasurementRequest{PersonId: 1, MeasurementType: "fetal_weight", GestationalWeek: 32, Value: 1700, Unit: "g"}, false},
+		{"fundal height", AddPrenatalMeasurementRequest{PersonId: 1, MeasurementType: "fundal_height", GestationalWeek: 28, Value: 28, Unit: "cm"}, false},
+		{"maternal weight in pounds", AddPrenatalMeasurementRequest{PersonId: 1, MeasurementType: "maternal_weight", GestationalWeek: 12, Value: 150, Unit: "lbs"}, false},
+		{"fetal weight in kilograms", AddPrenatalMeasurementRequest{PersonId: 1, MeasurementType: "fetal_weight", GestationalWeek: 32, Value: 1.7, Unit: "kg"}, true},
+		{"week too early", AddPrenatalMeasurementRequest{PersonId: 1, MeasurementType: "fundal_height", GestationalWeek: 2, Value: 2, Unit: "cm"}, true},
+		{"week too late", AddPrenatalMeasurementRequest{PersonId: 1, MeasurementType: "fundal_height", GestationalWeek: 45, Value: 40, Unit: "cm"}, true},
+		{"unknown type", AddPrenatalMeasurementRequest{PersonId: 1, MeasurementType: "heartbeat", GestationalWeek: 20, Value: 140, Unit: "bpm"}, true},
+		{"no person", AddPrenatalMeasurementRequest{MeasurementType: "fundal_height", GestationalWeek: 28, Value: 28, Unit: "cm"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateAddPrenatalMeasurementRequest(tc.req); (err != nil) != tc.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestGestationalWeeks(t *testing.T) {
+	due := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if got := gestationalWeekStart(due, 40); !got.Equal(due) {
+		t.Errorf("week 40 starts %v, want the due date", got)
+	}
+	start := gestationalWeekStart(due, 32)
+	if want := due.AddDate(0, 0, -56); !start.Equal(want) {
+		t.Errorf("week 32 starts %v, want %v", start, want)
+	}
+	if got := gestationalWeekAt(due, start); got != 32 {
+		t.Errorf("gestationalWeekAt(start of 32) = %d", got)
+	}
+	// Agrees with the wording on the person card.
+	if got := calculateGestationalAgeAt(due, start); got != "32 weeks" {
+		t.Errorf("calculateGestationalAgeAt = %q, want 32 weeks", got)
+	}
+}
+
+func TestAddPrenatalMeasurement(t *testing.T) {
+	fx := setupPrenatalFixture(t)
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		m, err := AddPrenatalMeasurementTx(tx, AddPrenatalMeasurementRequest{
+			PersonId: fx.bump.Id, MeasurementType: "fetal_weight", GestationalWeek: 32, Value: 1700, Unit: "g",
+		}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPrenatalMeasurementTx() error = %v", err)
+		}
+		if got := m.MeasurementDate.Format("2006-01-02"); got != "2024-04-06" {
+			t.Errorf("measurement dated %s, want the start of week 32", got)
+		}
+
+		_, err = AddPrenatalMeasurementTx(tx, AddPrenatalMeasurementRequest{
+			PersonId: fx.bump.Id, MeasurementType: "fundal_height", GestationalWeek: 30, Value: 30, Unit: "cm",
+			MeasurementDate: stringPtr("2024-03-25"),
+		}, fx.parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPrenatalMeasurementTx() error = %v", err)
+		}
+
+		if _, err := AddPrenatalMeasurementTx(tx, AddPrenatalMeasurementRequest{
+			PersonId: fx.toddler.Id, MeasurementType: "fundal_height", GestationalWeek: 30, Value: 30, Unit: "cm",
+		}, fx.parent.FamilyId); err == nil {
+			t.Error("a prenatal measurement was added to someone already born")
+		}
+
+		if got := GetPersonPrenatalMeasurementsTx(tx, fx.bump.Id); len(got) != 2 {
+			t.Errorf("stored %d measurements, want 2", len(got))
+		}
+		if got := GetPersonGrowthDataTx(tx, fx.bump.Id); len(got) != 0 {
+			t.Error("prenatal measurements must not appear as growth data")
+		}
+		vbolt.TxCommit(tx)
+	})
+}
+
+func TestDisplayPrenatalValue(t *testing.T) {
+	metric := UserPreferences{UnitSystem: UnitSystemMetric}
+	imperial := UserPreferences{UnitSystem: UnitSystemImperial}
+
+	if v, u := imperial.displayPrenatalValue(FetalWeightEstimate, 1700, "g"); v != 3.75 || u != "lbs" {
+		t.Errorf("1700 g for an imperial reader = %v %s", v, u)
+	}
+	if v, u := metric.displayPrenatalValue(FetalWeightEstimate, 3.75, "lbs"); v != 1701 || u != "g" {
+		t.Errorf("3.75 lbs for a metric reader = %v %s", v, u)
+	}
+	if v, u := imperial.displayPrenatalValue(FundalHeight, 30, "cm"); v != 11.81 || u != "in" {
+		t.Errorf("30 cm fundal height for an imperial reader = %v %s", v, u)
+	}
+}
+
+func TestRecordBirth(t *testing.T) {
+	fx := setupPrenatalFixture(t)
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if _, err := AddPrenatalMeasurementTx(tx, AddPrenatalMeasurementRequest{
+			PersonId: fx.bump.Id, MeasurementType: "fetal_weight", GestationalWeek: 36, Value: 2700, Unit: "g",
+		}, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddPrenatalMeasurementTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	t.Run("rejects a future date and leaves the pregnancy alone", func(t *testing.T) {
+		vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+			if _, err := RecordBirthTx(tx, RecordBirthRequest{PersonId: fx.bump.Id, Birthdate: "2024-06-20"}, fx.parent.FamilyId, now); err == nil {
+				t.Error("a birth in the future was accepted")
+			}
+			if !GetPersonById(tx, fx.bump.Id).IsPregnancy {
+				t.Error("the failed call changed the person")
+			}
+		})
+	})
+
+	var resp RecordBirthResponse
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		var err error
+		resp, err = RecordBirthTx(tx, RecordBirthRequest{
+			PersonId:    fx.bump.Id,
+			Birthdate:   "2024-05-18",
+			Name:        stringPtr("Rosie"),
+			Gender:      intPtr(int(Female)),
+			BirthWeight: float64Ptr(3.2),
+			WeightUnit:  "kg",
+			BirthLength: float64Ptr(50),
+			LengthUnit:  "cm",
+		}, fx.parent.FamilyId, now)
+		if err != nil {
+			t.Fatalf("RecordBirthTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	if resp.Person.IsPregnancy || resp.Person.Name != "Rosie" || resp.Person.Gender != Female {
+		t.Errorf("person after birth = %+v", resp.Person)
+	}
+	if got := resp.Person.Birthday.Format("2006-01-02"); got != "2024-05-18" {
+		t.Errorf("birthday = %s, want the birthdate rather than the due date", got)
+	}
+	if !strings.Contains(resp.Milestone.Description, "Rosie was born at 38 weeks") ||
+		!strings.Contains(resp.Milestone.Description, "3.2 kg") {
+		t.Errorf("milestone = %q", resp.Milestone.Description)
+	}
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		stored := GetPersonById(tx, fx.bump.Id)
+		if stored.IsPregnancy || stored.Name != "Rosie" {
+			t.Errorf("stored person = %+v", stored)
+		}
+
+		growth := GetPersonGrowthDataTx(tx, fx.bump.Id)
+		if len(growth) != 2 {
+			t.Fatalf("growth points = %d, want birth weight and length", len(growth))
+		}
+		for _, gd := range growth {
+			if !gd.MeasurementDate.Equal(stored.Birthday) {
+				t.Errorf("birth measurement dated %v", gd.MeasurementDate)
+			}
+		}
+
+		milestones := GetPersonMilestonesTx(tx, fx.bump.Id)
+		if len(milestones) != 1 || !milestones[0].MilestoneDate.Equal(stored.Birthday) {
+			t.Errorf("milestones = %+v", milestones)
+		}
+
+		if got := GetPersonPrenatalMeasurementsTx(tx, fx.bump.Id); len(got) != 1 {
+			t.Error("the pregnancy's history should survive the birth")
+		}
+	})
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if _, err := RecordBirthTx(tx, RecordBirthRequest{PersonId: fx.bump.Id, Birthdate: "2024-05-18"}, fx.parent.FamilyId, now); err == nil {
+			t.Error("a birth was recorded twice")
+		}
+	})
+}
+
+func TestFamilyDeletionSweepsPrenatalMeasurements(t *testing.T) {
+	fx := setupPrenatalFixture(t)
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		if _, err := AddPrenatalMeasurementTx(tx, AddPrenatalMeasurementRequest{
+			PersonId: fx.bump.Id, MeasurementType: "maternal_weight", GestationalWeek: 20, Value: 70, Unit: "kg",
+		}, fx.parent.FamilyId); err != nil {
+			t.Fatalf("AddPrenatalMeasurementTx() error = %v", err)
+		}
+		deleteFamilyContentTx(tx, fx.parent.FamilyId)
+		vbolt.TxCommit(tx)
+	})
+
+	if got := countRows(t, fx.db, PrenatalMeasurementBkt); got != 0 {
+		t.Errorf("prenatal measurements remaining = %d", got)
+	}
+}
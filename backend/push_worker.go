@@ -23,6 +23,11 @@ import (
 const (
 	PushEventChatMessage = "chat_message"
 	PushEventTest        = "test"
+	// PushEventGrowthAlert carries a growth analysis flag: a measurement that
+	// looks mistyped or that crossed percentile lines.
+	PushEventGrowthAlert = "growth_alert"
+	// PushEventMemories is the daily "on this day" digest.
+	PushEventMemories = "memories"
 )
 
 // pushPayloadVersion is the schema version of the payload's `data` object. The
@@ -80,6 +85,20 @@ var pushEventSpecs = map[string]pushEventSpec{
 		QuietTitle:  "Family Portal",
 		QuietBody:   "New message",
 	},
+	PushEventGrowthAlert: {
+		Category:    "growth_alert",
+		Destination: "/family-timeline",
+		Title:       "Growth check",
+		QuietTitle:  "Family Portal",
+		QuietBody:   "A measurement needs a look",
+	},
+	PushEventMemories: {
+		Category:    "memories",
+		Destination: "/family-timeline",
+		Title:       "On this day",
+		QuietTitle:  "Family Portal",
+		QuietBody:   "You have memories from this day",
+	},
 	PushEventTest: {
 		Category:    "test",
 		Destination: "/settings",
@@ -535,6 +554,18 @@ func buildAPNsPayload(job PushNotificationJob, prefs NotificationPreferences) AP
 		} else {
 			payload.Aps.Alert = APNsAlert{Title: spec.QuietTitle, Body: spec.QuietBody}
 		}
+	case PushEventGrowthAlert, PushEventMemories:
+		// The child's name and the direction of a percentile move are health
+		// information, and a memory names the people in it, so both follow
+		// the same preview setting as a chat message does.
+		if prefs.ShowMessageText {
+			payload.Aps.Alert = APNsAlert{
+				Title: spec.Title,
+				Body:  truncateAlertBody(job.Content),
+			}
+		} else {
+			payload.Aps.Alert = APNsAlert{Title: spec.QuietTitle, Body: spec.QuietBody}
+		}
 	default:
 		// Unreachable: QueuePushNotification refuses an event with no spec.
 		// Falling back to the quiet wording keeps a mistake from putting
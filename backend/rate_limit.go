@@ -92,6 +92,7 @@ var exactPathRules = map[string]RateLimitRule{
 	"/api/change-password": rateRuleLogin,
 	"/api/delete-account":  rateRuleLogin,
 	"/api/upload-photo":    rateRuleUpload,
+	"/api/upload-video":    rateRuleUpload,
 	"/api/import-bundle":   rateRuleImport,
 	"/ws/chat":             rateRuleWebSocket,
 	SnapshotPath:           rateRuleSnapshot,
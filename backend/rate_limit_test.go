@@ -45,6 +45,7 @@ func TestRuleForPath(t *testing.T) {
 		{path: "/rpc/ImportData", wantRule: rateRuleImport.Name, wantFound: true},
 		{path: "/api/import-bundle", wantRule: rateRuleImport.Name, wantFound: true},
 		{path: "/api/upload-photo", wantRule: rateRuleUpload.Name, wantFound: true},
+		{path: "/api/upload-video", wantRule: rateRuleUpload.Name, wantFound: true},
 		{path: "/ws/chat", wantRule: rateRuleWebSocket.Name, wantFound: true},
 		{path: "/api/photo/42/full", wantRule: rateRulePhotoRead.Name, wantFound: true},
 		{path: "/rpc/ListPeople", wantRule: rateRuleDefault.Name, wantFound: true},
@@ -40,6 +40,10 @@ const (
 	// at a genuinely bad 200 KB/s of mobile data is a little over four minutes.
 	uploadReadTimeout = 10 * time.Minute
 
+	// videoUploadReadTimeout covers a video clip: four times a photo's size
+	// limit, from the same phone on the same bad connection.
+	videoUploadReadTimeout = 20 * time.Minute
+
 	// importReadTimeout covers a full-family archive, ten times the size. The
 	// assumption behind it is different: an archive is uploaded from a computer
 	// that already has the file on disk, so the floor is broadband rather than
@@ -48,7 +52,7 @@ const (
 	importReadTimeout = 30 * time.Minute
 
 	// downloadWriteTimeout covers responses that stream a file back: photo
-	// variants, family exports, and the database snapshot the nightly backup
+	// variants and video clips, family exports, and the database snapshot the nightly backup
 	// pulls.
 	downloadWriteTimeout = 30 * time.Minute
 )
@@ -63,6 +67,8 @@ func requestDeadlines(r *http.Request) (read, write time.Duration) {
 	switch r.URL.Path {
 	case "/api/upload-photo":
 		return uploadReadTimeout, defaultWriteTimeout
+	case "/api/upload-video":
+		return videoUploadReadTimeout, defaultWriteTimeout
 	case "/api/import-bundle":
 		return importReadTimeout, defaultWriteTimeout
 	case "/api/export-bundle", SnapshotPath:
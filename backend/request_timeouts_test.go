@@ -17,6 +17,7 @@ func TestRequestDeadlinesByRoute(t *testing.T) {
 		{"RPC call", "/rpc/GetDashboard", defaultReadTimeout, defaultWriteTimeout},
 		{"login", "/api/login", defaultReadTimeout, defaultWriteTimeout},
 		{"photo upload", "/api/upload-photo", uploadReadTimeout, defaultWriteTimeout},
+		{"video upload", "/api/upload-video", videoUploadReadTimeout, defaultWriteTimeout},
 		{"family import", "/api/import-bundle", importReadTimeout, defaultWriteTimeout},
 		{"family export", "/api/export-bundle", defaultReadTimeout, downloadWriteTimeout},
 		{"database snapshot", SnapshotPath, defaultReadTimeout, downloadWriteTimeout},
@@ -74,6 +75,7 @@ func TestUploadBudgetsCoverTheirSizeLimits(t *testing.T) {
 		uplink int64
 	}{
 		{"photo upload", maxPhotoRequestBytes, uploadReadTimeout, mobileUplinkBytesPerSecond},
+		{"video upload", maxVideoRequestBytes, videoUploadReadTimeout, mobileUplinkBytesPerSecond},
 		{"family import", maxImportRequestBytes, importReadTimeout, broadbandUplinkBytesPerSecond},
 	}
 
@@ -0,0 +1,384 @@
+// School: one record per person per school year, with the report cards that
+// came home that year.
+//
+// The shape follows activity.go. SchoolYear is the parent, and everything a
+// year collects — the free-form grades, the report-card photos, the scanned
+// PDFs — hangs off it by SchoolYearId, with the family's id on every row so
+// account deletion can sweep each bucket directly.
+//
+// A school year belongs to one person. Siblings in the same class are two
+// years with the same school and teacher; there is no shared classroom record,
+// because nothing read from here would be improved by one and it would need a
+// roster of its own.
+//
+// Grades are free text on purpose. A kindergarten report says "Meets
+// expectations", a fifth-grade one says "B+", and a secondary transcript says
+// "87" — none of them convert into the others, and the report card itself is
+// the authority the family is copying from.
+//
+// Everything here is read through ScopeSchool.
+package backend
+
+import (
+	"family/cfg"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+type SchoolYear struct {
+	Id             int       `json:"id"`
+	PersonId       int       `json:"personId"`
+	FamilyId       int       `json:"familyId"`
+	Label          string    `json:"label"`     // "2025-26"
+	StartDate      time.Time `json:"startDate"` // zero when not known
+	EndDate        time.Time `json:"endDate"`   // zero while the year is under way
+	School         string    `json:"school"`
+	Grade          string    `json:"grade"` // the year group: "3rd grade", "Year 4", "Kindergarten"
+	Teacher        string    `json:"teacher"`
+	TeacherContact string    `json:"teacherContact"` // free text: an email, a phone number, a room
+	Notes          string    `json:"notes"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// SchoolGrade is one line of a report card: a subject, the term it was given
+// for, and the mark as printed.
+type SchoolGrade struct {
+	Id           int       `json:"id"`
+	SchoolYearId int       `json:"schoolYearId"`
+	FamilyId     int       `json:"familyId"`
+	Subject      string    `json:"subject"` // "Reading"
+	Term         string    `json:"term"`    // "Q1", "Spring", "Final"; empty for a whole-year mark
+	Mark         string    `json:"mark"`    // "A-", "4", "Meets expectations"
+	Comment      string    `json:"comment"`
+	SortOrder    int       `json:"sortOrder"` // display order within a year
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// SchoolYearPhoto joins a photo from the library to a school year — a
+// photographed report card, or the first-day-of-school picture.
+type SchoolYearPhoto struct {
+	Id           int       `json:"id"`
+	SchoolYearId int       `json:"schoolYearId"`
+	PhotoId      int       `json:"photoId"`
+	FamilyId     int       `json:"familyId"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// SchoolDocument is an uploaded PDF, usually a report card the school sent
+// electronically. Unlike a photo it is not part of the library: it belongs to
+// the one school year and goes with it.
+//
+// FilePath is relative to cfg.StaticDir and always under the family's own
+// directory (see schoolFamilyDir), so destroying a family can remove its
+// documents with one directory rather than a list of files.
+type SchoolDocument struct {
+	Id               int       `json:"id"`
+	SchoolYearId     int       `json:"schoolYearId"`
+	FamilyId         int       `json:"familyId"`
+	OwnerUserId      int       `json:"ownerUserId"`
+	Title            string    `json:"title"`
+	OriginalFilename string    `json:"originalFilename"`
+	FilePath         string    `json:"-"`
+	FileSize         int       `json:"fileSize"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// ── packing ───────────────────────────────────────────────────────────────────
+
+func PackSchoolYear(self *SchoolYear, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.String(&self.Label, buf)
+	vpack.Time(&self.StartDate, buf)
+	vpack.Time(&self.EndDate, buf)
+	vpack.String(&self.School, buf)
+	vpack.String(&self.Grade, buf)
+	vpack.String(&self.Teacher, buf)
+	vpack.String(&self.TeacherContact, buf)
+	vpack.String(&self.Notes, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+func PackSchoolGrade(self *SchoolGrade, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.SchoolYearId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.String(&self.Subject, buf)
+	vpack.String(&self.Term, buf)
+	vpack.String(&self.Mark, buf)
+	vpack.String(&self.Comment, buf)
+	vpack.Int(&self.SortOrder, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+func PackSchoolYearPhoto(self *SchoolYearPhoto, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.SchoolYearId, buf)
+	vpack.Int(&self.PhotoId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+func PackSchoolDocument(self *SchoolDocument, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.SchoolYearId, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.Int(&self.OwnerUserId, buf)
+	vpack.String(&self.Title, buf)
+	vpack.String(&self.OriginalFilename, buf)
+	vpack.String(&self.FilePath, buf)
+	vpack.Int(&self.FileSize, buf)
+	vpack.Time(&self.CreatedAt, buf)
+}
+
+// ── buckets ───────────────────────────────────────────────────────────────────
+
+var SchoolYearBkt = vbolt.Bucket(&cfg.Info, "school_years", vpack.FInt, PackSchoolYear)
+var SchoolGradeBkt = vbolt.Bucket(&cfg.Info, "school_grades", vpack.FInt, PackSchoolGrade)
+var SchoolYearPhotoBkt = vbolt.Bucket(&cfg.Info, "school_year_photos", vpack.FInt, PackSchoolYearPhoto)
+var SchoolDocumentBkt = vbolt.Bucket(&cfg.Info, "school_documents", vpack.FInt, PackSchoolDocument)
+
+// ── indexes ───────────────────────────────────────────────────────────────────
+
+// SchoolYearByPersonIndex: term = person_id, target = school_year_id — the
+// history view.
+var SchoolYearByPersonIndex = vbolt.Index(&cfg.Info, "school_year_by_person", vpack.FInt, vpack.FInt)
+
+// SchoolYearByFamilyIndex: term = family_id, target = school_year_id
+var SchoolYearByFamilyIndex = vbolt.Index(&cfg.Info, "school_year_by_family", vpack.FInt, vpack.FInt)
+
+// SchoolGradeByYearIndex: term = school_year_id, target = school_grade_id
+var SchoolGradeByYearIndex = vbolt.Index(&cfg.Info, "school_grade_by_year", vpack.FInt, vpack.FInt)
+
+// SchoolGradeByFamilyIndex: term = family_id, target = school_grade_id
+var SchoolGradeByFamilyIndex = vbolt.Index(&cfg.Info, "school_grade_by_family", vpack.FInt, vpack.FInt)
+
+// SchoolYearPhotoBy*: as with the activity photo joins, the by-photo index is
+// what lets deleting a photo clear its joins.
+var SchoolYearPhotoByYearIndex = vbolt.Index(&cfg.Info, "school_year_photo_by_year", vpack.FInt, vpack.FInt)
+var SchoolYearPhotoByPhotoIndex = vbolt.Index(&cfg.Info, "school_year_photo_by_photo", vpack.FInt, vpack.FInt)
+var SchoolYearPhotoByFamilyIndex = vbolt.Index(&cfg.Info, "school_year_photo_by_family", vpack.FInt, vpack.FInt)
+
+// SchoolDocumentByYearIndex: term = school_year_id, target = school_document_id
+var SchoolDocumentByYearIndex = vbolt.Index(&cfg.Info, "school_document_by_year", vpack.FInt, vpack.FInt)
+
+// SchoolDocumentByFamilyIndex: term = family_id, target = school_document_id
+var SchoolDocumentByFamilyIndex = vbolt.Index(&cfg.Info, "school_document_by_family", vpack.FInt, vpack.FInt)
+
+// ── reads ─────────────────────────────────────────────────────────────────────
+
+func GetSchoolYearById(tx *vbolt.Tx, id int) (year SchoolYear) {
+	vbolt.Read(tx, SchoolYearBkt, id, &year)
+	return
+}
+
+func GetSchoolDocumentById(tx *vbolt.Tx, id int) (document SchoolDocument) {
+	vbolt.Read(tx, SchoolDocumentBkt, id, &document)
+	return
+}
+
+func GetPersonSchoolYears(tx *vbolt.Tx, personId int) []SchoolYear {
+	return readByTerm(tx, SchoolYearByPersonIndex, SchoolYearBkt, personId)
+}
+
+func GetFamilySchoolYears(tx *vbolt.Tx, familyId int) []SchoolYear {
+	return readByTerm(tx, SchoolYearByFamilyIndex, SchoolYearBkt, familyId)
+}
+
+func GetSchoolYearGrades(tx *vbolt.Tx, schoolYearId int) []SchoolGrade {
+	return readByTerm(tx, SchoolGradeByYearIndex, SchoolGradeBkt, schoolYearId)
+}
+
+func GetFamilySchoolGrades(tx *vbolt.Tx, familyId int) []SchoolGrade {
+	return readByTerm(tx, SchoolGradeByFamilyIndex, SchoolGradeBkt, familyId)
+}
+
+func GetSchoolYearPhotoJoins(tx *vbolt.Tx, schoolYearId int) []SchoolYearPhoto {
+	return readByTerm(tx, SchoolYearPhotoByYearIndex, SchoolYearPhotoBkt, schoolYearId)
+}
+
+func GetFamilySchoolYearPhotos(tx *vbolt.Tx, familyId int) []SchoolYearPhoto {
+	return readByTerm(tx, SchoolYearPhotoByFamilyIndex, SchoolYearPhotoBkt, familyId)
+}
+
+func GetSchoolYearPhotoIds(tx *vbolt.Tx, schoolYearId int) []int {
+	joins := GetSchoolYearPhotoJoins(tx, schoolYearId)
+	photoIds := make([]int, 0, len(joins))
+	for _, join := range joins {
+		photoIds = append(photoIds, join.PhotoId)
+	}
+	return photoIds
+}
+
+func GetSchoolYearDocuments(tx *vbolt.Tx, schoolYearId int) []SchoolDocument {
+	return readByTerm(tx, SchoolDocumentByYearIndex, SchoolDocumentBkt, schoolYearId)
+}
+
+func GetFamilySchoolDocuments(tx *vbolt.Tx, familyId int) []SchoolDocument {
+	return readByTerm(tx, SchoolDocumentByFamilyIndex, SchoolDocumentBkt, familyId)
+}
+
+// ── writes ────────────────────────────────────────────────────────────────────
+//
+// The same pairing as activity.go: each write helper owns its record's index
+// entries and the matching row delete clears exactly that set.
+
+func writeSchoolYearTx(tx *vbolt.Tx, year *SchoolYear) {
+	vbolt.Write(tx, SchoolYearBkt, year.Id, year)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearByPersonIndex, year.Id, year.PersonId)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearByFamilyIndex, year.Id, year.FamilyId)
+}
+
+func writeSchoolGradeTx(tx *vbolt.Tx, grade *SchoolGrade) {
+	vbolt.Write(tx, SchoolGradeBkt, grade.Id, grade)
+	vbolt.SetTargetSingleTerm(tx, SchoolGradeByYearIndex, grade.Id, grade.SchoolYearId)
+	vbolt.SetTargetSingleTerm(tx, SchoolGradeByFamilyIndex, grade.Id, grade.FamilyId)
+}
+
+func writeSchoolYearPhotoTx(tx *vbolt.Tx, join *SchoolYearPhoto) {
+	vbolt.Write(tx, SchoolYearPhotoBkt, join.Id, join)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearPhotoByYearIndex, join.Id, join.SchoolYearId)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearPhotoByPhotoIndex, join.Id, join.PhotoId)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearPhotoByFamilyIndex, join.Id, join.FamilyId)
+}
+
+func writeSchoolDocumentTx(tx *vbolt.Tx, document *SchoolDocument) {
+	vbolt.Write(tx, SchoolDocumentBkt, document.Id, document)
+	vbolt.SetTargetSingleTerm(tx, SchoolDocumentByYearIndex, document.Id, document.SchoolYearId)
+	vbolt.SetTargetSingleTerm(tx, SchoolDocumentByFamilyIndex, document.Id, document.FamilyId)
+}
+
+// ── row deletion ──────────────────────────────────────────────────────────────
+
+func deleteSchoolYearRowTx(tx *vbolt.Tx, id int) {
+	vbolt.Delete(tx, SchoolYearBkt, id)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearByPersonIndex, id, -1)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearByFamilyIndex, id, -1)
+}
+
+func deleteSchoolGradeRowTx(tx *vbolt.Tx, id int) {
+	vbolt.Delete(tx, SchoolGradeBkt, id)
+	vbolt.SetTargetSingleTerm(tx, SchoolGradeByYearIndex, id, -1)
+	vbolt.SetTargetSingleTerm(tx, SchoolGradeByFamilyIndex, id, -1)
+}
+
+func deleteSchoolYearPhotoRowTx(tx *vbolt.Tx, id int) {
+	vbolt.Delete(tx, SchoolYearPhotoBkt, id)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearPhotoByYearIndex, id, -1)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearPhotoByPhotoIndex, id, -1)
+	vbolt.SetTargetSingleTerm(tx, SchoolYearPhotoByFamilyIndex, id, -1)
+}
+
+func deleteSchoolDocumentRowTx(tx *vbolt.Tx, id int) {
+	vbolt.Delete(tx, SchoolDocumentBkt, id)
+	vbolt.SetTargetSingleTerm(tx, SchoolDocumentByYearIndex, id, -1)
+	vbolt.SetTargetSingleTerm(tx, SchoolDocumentByFamilyIndex, id, -1)
+}
+
+// ── cascades ──────────────────────────────────────────────────────────────────
+
+// deleteSchoolYearTx deletes a year with its grades, photo joins and
+// documents, returning the documents whose files the caller removes once the
+// transaction has committed.
+func deleteSchoolYearTx(tx *vbolt.Tx, schoolYearId int) (documents []SchoolDocument) {
+	for _, grade := range GetSchoolYearGrades(tx, schoolYearId) {
+		deleteSchoolGradeRowTx(tx, grade.Id)
+	}
+	for _, join := range GetSchoolYearPhotoJoins(tx, schoolYearId) {
+		deleteSchoolYearPhotoRowTx(tx, join.Id)
+	}
+	documents = GetSchoolYearDocuments(tx, schoolYearId)
+	for _, document := range documents {
+		deleteSchoolDocumentRowTx(tx, document.Id)
+	}
+	deleteSchoolYearRowTx(tx, schoolYearId)
+	return
+}
+
+// removePhotoFromSchoolYears clears a deleted photo's joins. The photo goes;
+// the school year it illustrated does not.
+func removePhotoFromSchoolYears(tx *vbolt.Tx, photoId int) {
+	var joinIds []int
+	vbolt.ReadTermTargets(tx, SchoolYearPhotoByPhotoIndex, photoId, &joinIds, vbolt.Window{})
+	for _, joinId := range joinIds {
+		deleteSchoolYearPhotoRowTx(tx, joinId)
+	}
+}
+
+// deleteFamilySchoolTx empties all four buckets for one family, sweeping the
+// by-family indexes for the same reason deleteFamilyActivitiesTx does. The
+// documents' files are left to the caller, which removes schoolFamilyDir after
+// the commit.
+func deleteFamilySchoolTx(tx *vbolt.Tx, familyId int) {
+	for _, grade := range GetFamilySchoolGrades(tx, familyId) {
+		deleteSchoolGradeRowTx(tx, grade.Id)
+	}
+	for _, join := range GetFamilySchoolYearPhotos(tx, familyId) {
+		deleteSchoolYearPhotoRowTx(tx, join.Id)
+	}
+	for _, document := range GetFamilySchoolDocuments(tx, familyId) {
+		deleteSchoolDocumentRowTx(tx, document.Id)
+	}
+	for _, year := range GetFamilySchoolYears(tx, familyId) {
+		deleteSchoolYearRowTx(tx, year.Id)
+	}
+}
+
+// moveSchoolYearsTx hands the source's school years to the target of a merge,
+// returning how many moved. Nothing is deduplicated: two records of the same
+// year are as likely to be two report cards as one entered twice, and the
+// family can delete the extra once it can see both side by side. Grades,
+// photos and documents hang off the year and move with it.
+func moveSchoolYearsTx(tx *vbolt.Tx, sourceId int, targetId int) (moved int) {
+	for _, year := range GetPersonSchoolYears(tx, sourceId) {
+		year.PersonId = targetId
+		writeSchoolYearTx(tx, &year)
+		moved++
+	}
+	return
+}
+
+// ── files ─────────────────────────────────────────────────────────────────────
+
+// schoolFamilyDir is where a family's school documents live on disk, relative
+// to cfg.StaticDir.
+func schoolFamilyDir(familyId int) string {
+	return filepath.Join("school", strconv.Itoa(familyId))
+}
+
+// removeSchoolDocumentFiles deletes the files behind documents whose rows are
+// already gone. A file that is already missing is not an error.
+func removeSchoolDocumentFiles(documents []SchoolDocument) (lastError error) {
+	for _, document := range documents {
+		if document.FilePath == "" {
+			continue
+		}
+		err := os.Remove(filepath.Join(cfg.StaticDir, document.FilePath))
+		if err != nil && !os.IsNotExist(err) {
+			lastError = err
+		}
+	}
+	return
+}
+
+// ── access ────────────────────────────────────────────────────────────────────
+
+// canAccessSchoolYear allows the owning family, or a user the person is shared
+// with by a link carrying ScopeSchool. Grades, photo joins and documents have
+// no person of their own and resolve through their year.
+func canAccessSchoolYear(tx *vbolt.Tx, user User, year SchoolYear, need AccessLevel) bool {
+	if year.Id == 0 || year.FamilyId == 0 {
+		return false
+	}
+	return CanAccessRecordOfPerson(tx, user, year.FamilyId, year.PersonId, ScopeSchool, need)
+}
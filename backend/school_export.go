@@ -0,0 +1,249 @@
+// Exporting and importing a family's school years.
+//
+// A year nests its grades, photo ids and documents the way an exported
+// activity nests its seasons: the year's new id is in scope when its children
+// are written, so there is no id-remapping pass. A document's file travels in
+// the bundle at ZipPath when the export includes files; a data-only file still
+// lists the document, and importing it brings back everything but the PDF.
+package backend
+
+import (
+	"archive/zip"
+	"family/cfg"
+	"log"
+	"path/filepath"
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+// ExportSchoolYear carries PersonName for the same reason ExportMilestone
+// does.
+type ExportSchoolYear struct {
+	Id             int                    `json:"id"`
+	PersonId       int                    `json:"personId"`
+	PersonName     string                 `json:"personName"`
+	Label          string                 `json:"label"`
+	StartDate      time.Time              `json:"startDate"`
+	EndDate        time.Time              `json:"endDate"`
+	School         string                 `json:"school,omitempty"`
+	Grade          string                 `json:"grade,omitempty"`
+	Teacher        string                 `json:"teacher,omitempty"`
+	TeacherContact string                 `json:"teacherContact,omitempty"`
+	Notes          string                 `json:"notes,omitempty"`
+	CreatedAt      time.Time              `json:"createdAt"`
+	Grades         []ExportSchoolGrade    `json:"grades,omitempty"`
+	PhotoIds       []int                  `json:"photoIds,omitempty"`
+	Documents      []ExportSchoolDocument `json:"documents,omitempty"`
+}
+
+type ExportSchoolGrade struct {
+	Subject string `json:"subject"`
+	Term    string `json:"term,omitempty"`
+	Mark    string `json:"mark,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type ExportSchoolDocument struct {
+	Id               int       `json:"id"`
+	Title            string    `json:"title"`
+	OriginalFilename string    `json:"originalFilename,omitempty"`
+	ZipPath          string    `json:"zipPath"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// SchoolImportCounts says what an import did at each level.
+type SchoolImportCounts struct {
+	Years     int `json:"years"`
+	Grades    int `json:"grades"`
+	Photos    int `json:"photos"`
+	Documents int `json:"documents"`
+	// Reused counts years the family already had — same person, label and
+	// school — which are left as they are rather than filled in twice.
+	Reused  int `json:"reused"`
+	Skipped int `json:"skipped"`
+}
+
+func buildSchoolExport(tx *vbolt.Tx, familyId int, personNames map[int]string) []ExportSchoolYear {
+	years := GetFamilySchoolYears(tx, familyId)
+	sortSchoolYearsNewestFirst(years)
+	exported := make([]ExportSchoolYear, 0, len(years))
+	for _, year := range years {
+		grades := GetSchoolYearGrades(tx, year.Id)
+		sortSchoolGrades(grades)
+		exportGrades := make([]ExportSchoolGrade, 0, len(grades))
+		for _, grade := range grades {
+			exportGrades = append(exportGrades, ExportSchoolGrade{
+				Subject: grade.Subject,
+				Term:    grade.Term,
+				Mark:    grade.Mark,
+				Comment: grade.Comment,
+			})
+		}
+		var documents []ExportSchoolDocument
+		for _, document := range GetSchoolYearDocuments(tx, year.Id) {
+			documents = append(documents, ExportSchoolDocument{
+				Id:               document.Id,
+				Title:            document.Title,
+				OriginalFilename: document.OriginalFilename,
+				ZipPath:          filepath.ToSlash(document.FilePath),
+				CreatedAt:        document.CreatedAt,
+			})
+		}
+		exported = append(exported, ExportSchoolYear{
+			Id:             year.Id,
+			PersonId:       year.PersonId,
+			PersonName:     personNames[year.PersonId],
+			Label:          year.Label,
+			StartDate:      year.StartDate,
+			EndDate:        year.EndDate,
+			School:         year.School,
+			Grade:          year.Grade,
+			Teacher:        year.Teacher,
+			TeacherContact: year.TeacherContact,
+			Notes:          year.Notes,
+			CreatedAt:      year.CreatedAt,
+			Grades:         exportGrades,
+			PhotoIds:       GetSchoolYearPhotoIds(tx, year.Id),
+			Documents:      documents,
+		})
+	}
+	return exported
+}
+
+// exportedSchoolDocumentPaths lists the files a with-photos bundle carries for
+// the school years.
+func exportedSchoolDocumentPaths(years []ExportSchoolYear) []string {
+	var paths []string
+	for _, year := range years {
+		for _, document := range year.Documents {
+			paths = append(paths, document.ZipPath)
+		}
+	}
+	return paths
+}
+
+// findSchoolYear returns the person's year with this label at this school, if
+// the family already has it.
+func findSchoolYear(tx *vbolt.Tx, personId int, familyId int, label string, school string) (SchoolYear, bool) {
+	for _, year := range GetPersonSchoolYears(tx, personId) {
+		if year.FamilyId == familyId && year.Label == label && year.School == school {
+			return year, true
+		}
+	}
+	return SchoolYear{}, false
+}
+
+// importSchoolYears writes the file's school years for the people that were
+// imported. photoIdMapping and zipReader are nil on the JSON-only path, which
+// then brings back the years and grades but no photo joins or documents.
+func importSchoolYears(
+	tx *vbolt.Tx,
+	years []ExportSchoolYear,
+	familyId int,
+	ownerUserId int,
+	personIdMapping map[int]int,
+	photoIdMapping map[int]int,
+	zipReader *zip.Reader,
+) (counts SchoolImportCounts, warnings []string) {
+	zipFiles := map[string]*zip.File{}
+	if zipReader != nil {
+		for _, zf := range zipReader.File {
+			zipFiles[zf.Name] = zf
+		}
+	}
+
+	for _, source := range years {
+		personId, ok := personIdMapping[source.PersonId]
+		if !ok {
+			continue
+		}
+		fields := SchoolYearFields{
+			Label:          source.Label,
+			School:         source.School,
+			Grade:          source.Grade,
+			Teacher:        source.Teacher,
+			TeacherContact: source.TeacherContact,
+			Notes:          source.Notes,
+		}
+		if !source.StartDate.IsZero() {
+			start := source.StartDate.Format("2006-01-02")
+			fields.StartDate = &start
+		}
+		if !source.EndDate.IsZero() {
+			end := source.EndDate.Format("2006-01-02")
+			fields.EndDate = &end
+		}
+		year := SchoolYear{PersonId: personId, FamilyId: familyId}
+		if err := applySchoolYearFields(&year, fields, GetPersonById(tx, personId)); err != nil {
+			counts.Skipped++
+			warnings = append(warnings, "Skipped school year "+source.Label+": "+err.Error())
+			continue
+		}
+		if _, found := findSchoolYear(tx, personId, familyId, year.Label, year.School); found {
+			counts.Reused++
+			continue
+		}
+
+		year.Id = vbolt.NextIntId(tx, SchoolYearBkt)
+		year.CreatedAt = source.CreatedAt
+		if year.CreatedAt.IsZero() {
+			year.CreatedAt = time.Now()
+		}
+		writeSchoolYearTx(tx, &year)
+		counts.Years++
+
+		inputs := make([]SchoolGradeInput, 0, len(source.Grades))
+		for _, grade := range source.Grades {
+			inputs = append(inputs, SchoolGradeInput{Subject: grade.Subject, Term: grade.Term, Mark: grade.Mark, Comment: grade.Comment})
+		}
+		if grades, err := setSchoolGradesTx(tx, year, inputs); err != nil {
+			warnings = append(warnings, "Skipped the grades for school year "+year.Label+": "+err.Error())
+		} else {
+			counts.Grades += len(grades)
+		}
+
+		var photoIds []int
+		attachPhotos(photoIdMapping, source.PhotoIds, func(photoId int) {
+			photoIds = append(photoIds, photoId)
+		})
+		if len(photoIds) > 0 {
+			if err := setSchoolYearPhotosTx(tx, year, photoIds); err != nil {
+				warnings = append(warnings, "Skipped the photos for school year "+year.Label+": "+err.Error())
+			} else {
+				counts.Photos += len(photoIds)
+			}
+		}
+
+		for _, document := range source.Documents {
+			zf, exists := zipFiles[document.ZipPath]
+			if !exists {
+				continue
+			}
+			uniqueFilename, err := generateUniqueFilename(".pdf")
+			if err != nil {
+				continue
+			}
+			relativePath := filepath.Join(schoolFamilyDir(familyId), uniqueFilename)
+			diskPath := filepath.Join(cfg.StaticDir, relativePath)
+			if err := writeZipEntryToDisk(zf, diskPath); err != nil {
+				log.Printf("[IMPORT] Failed to write school document %s: %v", diskPath, err)
+				continue
+			}
+			imported := SchoolDocument{
+				Id:               vbolt.NextIntId(tx, SchoolDocumentBkt),
+				SchoolYearId:     year.Id,
+				FamilyId:         familyId,
+				OwnerUserId:      ownerUserId,
+				Title:            trimField(document.Title, maxNameLength),
+				OriginalFilename: document.OriginalFilename,
+				FilePath:         relativePath,
+				FileSize:         int(zf.UncompressedSize64),
+				CreatedAt:        document.CreatedAt,
+			}
+			writeSchoolDocumentTx(tx, &imported)
+			counts.Documents++
+		}
+	}
+	return
+}
@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"family/cfg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+func TestSchoolExportRoundTrip(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	for _, familyId := range []int{fx.famA, fx.famB} {
+		dir := filepath.Join(cfg.StaticDir, schoolFamilyDir(familyId))
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	}
+
+	pdf := []byte("%PDF-1.4 report card")
+	documentPath := filepath.Join(schoolFamilyDir(fx.famA), "report-card.pdf")
+	if err := os.MkdirAll(filepath.Join(cfg.StaticDir, schoolFamilyDir(fx.famA)), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.StaticDir, documentPath), pdf, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		photo := writeTestImage(tx, fx.famA, fx.userA.Id, "first-day.jpg")
+		year := SchoolYear{
+			Id: vbolt.NextIntId(tx, SchoolYearBkt), PersonId: fx.alice.Id, FamilyId: fx.famA,
+			Label: "2024-25", StartDate: time.Date(2024, 9, 3, 0, 0, 0, 0, time.UTC),
+			School: "Maple Elementary", Grade: "1st grade", Teacher: "Ms. Ortiz",
+		}
+		writeSchoolYearTx(tx, &year)
+		if _, err := setSchoolGradesTx(tx, year, []SchoolGradeInput{{Subject: "Reading", Term: "Q1", Mark: "3"}, {Subject: "Math", Term: "Q1", Mark: "4"}}); err != nil {
+			t.Fatalf("setSchoolGradesTx() error = %v", err)
+		}
+		if err := setSchoolYearPhotosTx(tx, year, []int{photo.Id}); err != nil {
+			t.Fatalf("setSchoolYearPhotosTx() error = %v", err)
+		}
+		document := SchoolDocument{
+			Id: vbolt.NextIntId(tx, SchoolDocumentBkt), SchoolYearId: year.Id, FamilyId: fx.famA,
+			Title: "Q1 report card", FilePath: documentPath, FileSize: len(pdf),
+		}
+		writeSchoolDocumentTx(tx, &document)
+
+		exportData, err := buildExportData(tx, fx.famA)
+		if err != nil {
+			t.Fatalf("buildExportData() error = %v", err)
+		}
+		if exportData.TotalSchoolYears != 1 {
+			t.Fatalf("exported %d school years, want 1", exportData.TotalSchoolYears)
+		}
+		exported := exportData.SchoolYears[0]
+		if exported.PersonName != "Alice" || len(exported.Grades) != 2 || exported.Grades[0].Subject != "Reading" ||
+			len(exported.PhotoIds) != 1 || len(exported.Documents) != 1 || exported.Documents[0].ZipPath != filepath.ToSlash(documentPath) {
+			t.Fatalf("exported school year %+v", exported)
+		}
+
+		// The bundle a with-photos export writes: the document at its ZipPath.
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		entry, _ := zw.Create(exported.Documents[0].ZipPath)
+		entry.Write(pdf)
+		zw.Close()
+		zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("zip.NewReader() error = %v", err)
+		}
+
+		copyOfAlice, err := AddPersonTx(tx, AddPersonRequest{Name: "Alice", PersonType: int(Child), Gender: 1, Birthdate: "2018-04-01"}, fx.famB)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		copyOfPhoto := writeTestImage(tx, fx.famB, fx.userB.Id, "first-day.jpg")
+		people := map[int]int{fx.alice.Id: copyOfAlice.Id}
+		photos := map[int]int{photo.Id: copyOfPhoto.Id}
+
+		counts, warnings := importSchoolYears(tx, exportData.SchoolYears, fx.famB, fx.userB.Id, people, photos, zipReader)
+		if counts.Years != 1 || counts.Grades != 2 || counts.Photos != 1 || counts.Documents != 1 || len(warnings) != 0 {
+			t.Fatalf("first import = %+v, warnings %v", counts, warnings)
+		}
+		years := GetPersonSchoolYears(tx, copyOfAlice.Id)
+		if len(years) != 1 || years[0].FamilyId != fx.famB || years[0].Teacher != "Ms. Ortiz" {
+			t.Fatalf("imported years %+v", years)
+		}
+		if ids := GetSchoolYearPhotoIds(tx, years[0].Id); len(ids) != 1 || ids[0] != copyOfPhoto.Id {
+			t.Errorf("imported photo ids %v", ids)
+		}
+		documents := GetSchoolYearDocuments(tx, years[0].Id)
+		if len(documents) != 1 || filepath.Dir(documents[0].FilePath) != schoolFamilyDir(fx.famB) {
+			t.Fatalf("imported documents %+v", documents)
+		}
+		if written, err := os.ReadFile(filepath.Join(cfg.StaticDir, documents[0].FilePath)); err != nil || !bytes.Equal(written, pdf) {
+			t.Errorf("imported document on disk = %q, %v", written, err)
+		}
+
+		// A second import finds the year already there. The JSON-only path
+		// has no files, so it could not have brought the document anyway.
+		if counts, _ := importSchoolYears(tx, exportData.SchoolYears, fx.famB, fx.userB.Id, people, nil, nil); counts.Years != 0 || counts.Reused != 1 {
+			t.Errorf("second import = %+v", counts)
+		}
+	})
+}
@@ -0,0 +1,613 @@
+// Procs and handlers for school years: the history view, CRUD on a year, its
+// grades and report-card photos, and the PDF upload that sits beside them.
+//
+// Writes follow health.go: the person a year hangs off names the family that
+// owns it, and every mutation asks for AccessContribute, which no link can
+// grant.
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"family/cfg"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func RegisterSchoolMethods(app *vbeam.Application) {
+	app.HandleFunc("/api/upload-school-document", AuthMiddleware(uploadSchoolDocumentHandler))
+	app.HandleFunc("/api/school-document/", AuthMiddleware(serveSchoolDocumentHandler))
+	vbeam.RegisterProc(app, GetSchoolHistory)
+	vbeam.RegisterProc(app, CreateSchoolYear)
+	vbeam.RegisterProc(app, UpdateSchoolYear)
+	vbeam.RegisterProc(app, DeleteSchoolYear)
+	vbeam.RegisterProc(app, SetSchoolGrades)
+	vbeam.RegisterProc(app, SetSchoolYearPhotos)
+	vbeam.RegisterProc(app, DeleteSchoolDocument)
+}
+
+var (
+	ErrSchoolYearNotFound     = errors.New("School year not found")
+	ErrSchoolDocumentNotFound = errors.New("Document not found")
+	ErrSchoolLabelRequired    = errors.New("A school year label is required, such as 2025-26")
+	ErrSubjectRequired        = errors.New("Every grade needs a subject")
+)
+
+// maxGradesPerYear is a sanity bound on one request, well past any report card.
+const maxGradesPerYear = 200
+
+// maxSchoolDocumentSize is larger than a scanned report card needs and smaller
+// than anything that is not one.
+const maxSchoolDocumentSize = 20 << 20 // 20MB
+
+// ── request/response types ────────────────────────────────────────────────────
+
+type GetSchoolHistoryRequest struct {
+	PersonId int `json:"personId"`
+}
+
+// SchoolYearView is a year with everything it collected. PhotoIds lists only
+// the photos the caller can fetch.
+type SchoolYearView struct {
+	SchoolYear
+	Grades    []SchoolGrade    `json:"grades"`
+	PhotoIds  []int            `json:"photoIds"`
+	Documents []SchoolDocument `json:"documents"`
+}
+
+type GetSchoolHistoryResponse struct {
+	PersonId int              `json:"personId"`
+	Years    []SchoolYearView `json:"years"` // newest first
+}
+
+// SchoolYearFields is the shared half of create and update.
+type SchoolYearFields struct {
+	Label          string  `json:"label"`
+	StartDate      *string `json:"startDate,omitempty"` // YYYY-MM-DD
+	EndDate        *string `json:"endDate,omitempty"`   // YYYY-MM-DD
+	School         string  `json:"school"`
+	Grade          string  `json:"grade"`
+	Teacher        string  `json:"teacher"`
+	TeacherContact string  `json:"teacherContact"`
+	Notes          string  `json:"notes"`
+}
+
+type CreateSchoolYearRequest struct {
+	PersonId int `json:"personId"`
+	SchoolYearFields
+}
+
+type UpdateSchoolYearRequest struct {
+	Id int `json:"id"`
+	SchoolYearFields
+}
+
+type SchoolYearResponse struct {
+	Year SchoolYearView `json:"year"`
+}
+
+type SchoolIdRequest struct {
+	Id int `json:"id"`
+}
+
+type SchoolGradeInput struct {
+	Subject string `json:"subject"`
+	Term    string `json:"term"`
+	Mark    string `json:"mark"`
+	Comment string `json:"comment"`
+}
+
+type SetSchoolGradesRequest struct {
+	SchoolYearId int                `json:"schoolYearId"`
+	Grades       []SchoolGradeInput `json:"grades"`
+}
+
+type SetSchoolGradesResponse struct {
+	SchoolYearId int           `json:"schoolYearId"`
+	Grades       []SchoolGrade `json:"grades"`
+}
+
+type SetSchoolYearPhotosRequest struct {
+	SchoolYearId int   `json:"schoolYearId"`
+	PhotoIds     []int `json:"photoIds"`
+}
+
+type SetSchoolYearPhotosResponse struct {
+	SchoolYearId int   `json:"schoolYearId"`
+	PhotoIds     []int `json:"photoIds"`
+}
+
+// ── helpers ───────────────────────────────────────────────────────────────────
+
+func getSchoolYearForUser(tx *vbolt.Tx, id int, user User, need AccessLevel) (SchoolYear, error) {
+	year := GetSchoolYearById(tx, id)
+	if !canAccessSchoolYear(tx, user, year, need) {
+		return SchoolYear{}, ErrSchoolYearNotFound
+	}
+	return year, nil
+}
+
+func schoolYearView(tx *vbolt.Tx, user User, year SchoolYear) SchoolYearView {
+	grades := GetSchoolYearGrades(tx, year.Id)
+	sortSchoolGrades(grades)
+	return SchoolYearView{
+		SchoolYear: year,
+		Grades:     grades,
+		PhotoIds:   visiblePhotoIds(tx, user, GetSchoolYearPhotoIds(tx, year.Id)),
+		Documents:  GetSchoolYearDocuments(tx, year.Id),
+	}
+}
+
+// sortSchoolGrades puts a year's grades back in the order they were entered.
+func sortSchoolGrades(grades []SchoolGrade) {
+	sort.SliceStable(grades, func(i, j int) bool { return grades[i].SortOrder < grades[j].SortOrder })
+}
+
+// sortSchoolYearsNewestFirst orders by start date, then by label — "2025-26"
+// sorts after "2024-25" as text — so a year entered without dates still lands
+// in roughly the right place.
+func sortSchoolYearsNewestFirst(years []SchoolYear) {
+	sort.SliceStable(years, func(i, j int) bool {
+		a, b := years[i], years[j]
+		if !a.StartDate.Equal(b.StartDate) {
+			return a.StartDate.After(b.StartDate)
+		}
+		if a.Label != b.Label {
+			return a.Label > b.Label
+		}
+		return a.Id > b.Id
+	})
+}
+
+// applySchoolYearFields validates a year's fields and copies them onto it.
+func applySchoolYearFields(year *SchoolYear, fields SchoolYearFields, person Person) error {
+	if person.IsPregnancy {
+		return errors.New("School years are recorded once the baby is born")
+	}
+	label := trimField(fields.Label, maxLabelLength)
+	if label == "" {
+		return ErrSchoolLabelRequired
+	}
+	start, err := parseActivityDate(fields.StartDate)
+	if err != nil {
+		return err
+	}
+	end, err := parseActivityDate(fields.EndDate)
+	if err != nil {
+		return err
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return errors.New("A school year cannot end before it starts")
+	}
+
+	year.Label = label
+	year.StartDate = start
+	year.EndDate = end
+	year.School = trimField(fields.School, maxNameLength)
+	year.Grade = trimField(fields.Grade, maxLabelLength)
+	year.Teacher = trimField(fields.Teacher, maxNameLength)
+	year.TeacherContact = trimField(fields.TeacherContact, maxNameLength)
+	year.Notes = trimField(fields.Notes, maxNotesLength)
+	return nil
+}
+
+// setSchoolGradesTx replaces the year's grades with the list given, in that
+// order — the same whole-set semantics as SetEntryRoster.
+func setSchoolGradesTx(tx *vbolt.Tx, year SchoolYear, inputs []SchoolGradeInput) ([]SchoolGrade, error) {
+	if len(inputs) > maxGradesPerYear {
+		return nil, errors.New("That is more grades than one school year can hold")
+	}
+	grades := make([]SchoolGrade, 0, len(inputs))
+	for i, input := range inputs {
+		grade := SchoolGrade{
+			SchoolYearId: year.Id,
+			FamilyId:     year.FamilyId,
+			Subject:      trimField(input.Subject, maxLabelLength),
+			Term:         trimField(input.Term, maxLabelLength),
+			Mark:         trimField(input.Mark, maxLabelLength),
+			Comment:      trimField(input.Comment, maxNotesLength),
+			SortOrder:    i,
+		}
+		if grade.Subject == "" {
+			return nil, ErrSubjectRequired
+		}
+		grades = append(grades, grade)
+	}
+
+	for _, grade := range GetSchoolYearGrades(tx, year.Id) {
+		deleteSchoolGradeRowTx(tx, grade.Id)
+	}
+	now := time.Now()
+	for i := range grades {
+		grades[i].Id = vbolt.NextIntId(tx, SchoolGradeBkt)
+		grades[i].CreatedAt = now
+		writeSchoolGradeTx(tx, &grades[i])
+	}
+	return grades, nil
+}
+
+// setSchoolYearPhotosTx replaces the year's photos, checked the way activity
+// photos are.
+func setSchoolYearPhotosTx(tx *vbolt.Tx, year SchoolYear, photoIds []int) error {
+	ordered, err := resolveActivityPhotoIds(tx, photoIds, year.FamilyId)
+	if err != nil {
+		return err
+	}
+	for _, join := range GetSchoolYearPhotoJoins(tx, year.Id) {
+		deleteSchoolYearPhotoRowTx(tx, join.Id)
+	}
+	now := time.Now()
+	for _, photoId := range ordered {
+		join := SchoolYearPhoto{
+			Id:           vbolt.NextIntId(tx, SchoolYearPhotoBkt),
+			SchoolYearId: year.Id,
+			PhotoId:      photoId,
+			FamilyId:     year.FamilyId,
+			CreatedAt:    now,
+		}
+		writeSchoolYearPhotoTx(tx, &join)
+	}
+	return nil
+}
+
+// ── procs ─────────────────────────────────────────────────────────────────────
+
+// GetSchoolHistory is the person-level view: every school year, newest first,
+// each with its grades, photos and documents.
+func GetSchoolHistory(ctx *vbeam.Context, req GetSchoolHistoryRequest) (resp GetSchoolHistoryResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	person := GetPersonById(ctx.Tx, req.PersonId)
+	if !CanAccessPerson(ctx.Tx, user, person, ScopeSchool, AccessView) {
+		err = errors.New("Person not found or not in your family")
+		return
+	}
+
+	years := GetPersonSchoolYears(ctx.Tx, person.Id)
+	sortSchoolYearsNewestFirst(years)
+	resp.PersonId = person.Id
+	resp.Years = make([]SchoolYearView, 0, len(years))
+	for _, year := range years {
+		if canAccessSchoolYear(ctx.Tx, user, year, AccessView) {
+			resp.Years = append(resp.Years, schoolYearView(ctx.Tx, user, year))
+		}
+	}
+	return
+}
+
+func CreateSchoolYear(ctx *vbeam.Context, req CreateSchoolYearRequest) (resp SchoolYearResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	familyId, err := ActingFamilyForPerson(ctx.Tx, user, req.PersonId, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	year := SchoolYear{PersonId: req.PersonId, FamilyId: familyId}
+	if err = applySchoolYearFields(&year, req.SchoolYearFields, GetPersonById(ctx.Tx, req.PersonId)); err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	year.Id = vbolt.NextIntId(ctx.Tx, SchoolYearBkt)
+	year.CreatedAt = time.Now()
+	writeSchoolYearTx(ctx.Tx, &year)
+	resp.Year = schoolYearView(ctx.Tx, user, year)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+func UpdateSchoolYear(ctx *vbeam.Context, req UpdateSchoolYearRequest) (resp SchoolYearResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	year, err := getSchoolYearForUser(ctx.Tx, req.Id, user, AccessContribute)
+	if err != nil {
+		return
+	}
+	if err = applySchoolYearFields(&year, req.SchoolYearFields, GetPersonById(ctx.Tx, year.PersonId)); err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	writeSchoolYearTx(ctx.Tx, &year)
+	resp.Year = schoolYearView(ctx.Tx, user, year)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+// DeleteSchoolYear deletes the year and everything under it. The photos stay
+// in the library; the uploaded documents, which belong to the year alone, are
+// removed from disk once the deletion has committed.
+func DeleteSchoolYear(ctx *vbeam.Context, req SchoolIdRequest) (resp DeleteResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	year, err := getSchoolYearForUser(ctx.Tx, req.Id, user, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	documents := deleteSchoolYearTx(ctx.Tx, year.Id)
+	vbolt.TxCommit(ctx.Tx)
+
+	if fileErr := removeSchoolDocumentFiles(documents); fileErr != nil {
+		LogErrorSimple(LogCategoryAPI, "Failed to delete school document files", map[string]interface{}{
+			"schoolYearId": year.Id,
+			"error":        fileErr.Error(),
+		})
+	}
+
+	resp.Success = true
+	return
+}
+
+func SetSchoolGrades(ctx *vbeam.Context, req SetSchoolGradesRequest) (resp SetSchoolGradesResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	year, err := getSchoolYearForUser(ctx.Tx, req.SchoolYearId, user, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	grades, err := setSchoolGradesTx(ctx.Tx, year, req.Grades)
+	if err != nil {
+		return
+	}
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.SchoolYearId = year.Id
+	resp.Grades = grades
+	return
+}
+
+func SetSchoolYearPhotos(ctx *vbeam.Context, req SetSchoolYearPhotosRequest) (resp SetSchoolYearPhotosResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	year, err := getSchoolYearForUser(ctx.Tx, req.SchoolYearId, user, AccessContribute)
+	if err != nil {
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	if err = setSchoolYearPhotosTx(ctx.Tx, year, req.PhotoIds); err != nil {
+		return
+	}
+	resp.SchoolYearId = year.Id
+	resp.PhotoIds = GetSchoolYearPhotoIds(ctx.Tx, year.Id)
+	vbolt.TxCommit(ctx.Tx)
+	return
+}
+
+func DeleteSchoolDocument(ctx *vbeam.Context, req SchoolIdRequest) (resp DeleteResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	document := GetSchoolDocumentById(ctx.Tx, req.Id)
+	if document.Id == 0 {
+		err = ErrSchoolDocumentNotFound
+		return
+	}
+	if _, yearErr := getSchoolYearForUser(ctx.Tx, document.SchoolYearId, user, AccessContribute); yearErr != nil {
+		err = ErrSchoolDocumentNotFound
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	deleteSchoolDocumentRowTx(ctx.Tx, document.Id)
+	vbolt.TxCommit(ctx.Tx)
+
+	if fileErr := removeSchoolDocumentFiles([]SchoolDocument{document}); fileErr != nil {
+		LogErrorSimple(LogCategoryAPI, "Failed to delete school document file", map[string]interface{}{
+			"documentId": document.Id,
+			"error":      fileErr.Error(),
+		})
+	}
+
+	resp.Success = true
+	return
+}
+
+// ── documents ─────────────────────────────────────────────────────────────────
+
+// isPDF checks the file's own header rather than the Content-Type the browser
+// sent, which is whatever the operating system guessed from the extension.
+func isPDF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("%PDF-"))
+}
+
+// uploadSchoolDocumentHandler takes a multipart form with schoolYearId, an
+// optional title, and the PDF as "document".
+func uploadSchoolDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.ContentLength > maxSchoolDocumentSize+(1<<20) {
+		RespondFileTooLargeError(w, r, "20MB")
+		return
+	}
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		RespondValidationError(w, r, "That upload could not be read. Please try again.", err.Error())
+		return
+	}
+
+	user, ok := GetUserFromContext(r)
+	if !ok {
+		RespondAuthError(w, r, "Authentication required")
+		return
+	}
+
+	schoolYearId, err := strconv.Atoi(r.FormValue("schoolYearId"))
+	if err != nil {
+		RespondValidationError(w, r, "That school year could not be identified.", err.Error())
+		return
+	}
+
+	file, fileHeader, err := r.FormFile("document")
+	if err != nil {
+		RespondValidationError(w, r, "Choose a PDF to upload.", err.Error())
+		return
+	}
+	defer file.Close()
+	if fileHeader.Size > maxSchoolDocumentSize {
+		RespondFileTooLargeError(w, r, "20MB")
+		return
+	}
+	fileData, err := io.ReadAll(io.LimitReader(file, maxSchoolDocumentSize+1))
+	if err != nil {
+		RespondValidationError(w, r, "That upload could not be read. Please try again.", err.Error())
+		return
+	}
+	if len(fileData) > maxSchoolDocumentSize {
+		RespondFileTooLargeError(w, r, "20MB")
+		return
+	}
+	if !isPDF(fileData) {
+		RespondInvalidFileTypeError(w, r, "PDF")
+		return
+	}
+
+	title := trimField(r.FormValue("title"), maxNameLength)
+	if title == "" {
+		title = trimField(strings.TrimSuffix(fileHeader.Filename, filepath.Ext(fileHeader.Filename)), maxNameLength)
+	}
+
+	var document SchoolDocument
+	var uploadErr *AppError
+	vbolt.WithWriteTx(appDb, func(tx *vbolt.Tx) {
+		year, err := getSchoolYearForUser(tx, schoolYearId, user, AccessContribute)
+		if err != nil {
+			uploadErr = NewAppError(ErrCodeNotFound, "That school year could not be found.", err.Error())
+			return
+		}
+
+		uniqueFilename, err := generateUniqueFilename(".pdf")
+		if err != nil {
+			uploadErr = NewAppError(ErrCodeInternal, unexpectedErrorMessage, err.Error())
+			return
+		}
+		relativePath := filepath.Join(schoolFamilyDir(year.FamilyId), uniqueFilename)
+		diskPath := filepath.Join(cfg.StaticDir, relativePath)
+		if err := os.MkdirAll(filepath.Dir(diskPath), 0755); err != nil {
+			uploadErr = NewAppError(ErrCodeInternal, unexpectedErrorMessage, err.Error())
+			return
+		}
+		if err := os.WriteFile(diskPath, fileData, 0644); err != nil {
+			uploadErr = NewAppError(ErrCodeInternal, unexpectedErrorMessage, err.Error())
+			return
+		}
+
+		document = SchoolDocument{
+			Id:               vbolt.NextIntId(tx, SchoolDocumentBkt),
+			SchoolYearId:     year.Id,
+			FamilyId:         year.FamilyId,
+			OwnerUserId:      user.Id,
+			Title:            title,
+			OriginalFilename: fileHeader.Filename,
+			FilePath:         relativePath,
+			FileSize:         len(fileData),
+			CreatedAt:        time.Now(),
+		}
+		writeSchoolDocumentTx(tx, &document)
+		vbolt.TxCommit(tx)
+	})
+
+	if uploadErr != nil {
+		RespondWithError(w, r, uploadErr, statusForErrorCode(uploadErr.Code))
+		return
+	}
+	if document.Id == 0 {
+		RespondUnexpectedError(w, r, errors.New("school document upload transaction produced no document"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(document)
+}
+
+// serveSchoolDocumentHandler answers GET /api/school-document/{id}. Like a
+// photo, a document the caller may not see is "not found" rather than
+// forbidden, so ids outside the caller's reach cannot be probed.
+func serveSchoolDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	documentId, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/school-document/"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	user, ok := GetUserFromContext(r)
+	if !ok {
+		RespondNotFoundError(w, r, "Not found")
+		return
+	}
+
+	var document SchoolDocument
+	var canAccess bool
+	vbolt.WithReadTx(appDb, func(tx *vbolt.Tx) {
+		document = GetSchoolDocumentById(tx, documentId)
+		canAccess = document.Id != 0 && canAccessSchoolYear(tx, user, GetSchoolYearById(tx, document.SchoolYearId), AccessView)
+	})
+	if !canAccess {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	fullPath := filepath.Clean(filepath.Join(cfg.StaticDir, document.FilePath))
+	if !strings.HasPrefix(fullPath, filepath.Clean(cfg.StaticDir)) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if _, err := os.Stat(fullPath); err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", document.Title+".pdf"))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeFile(w, r, fullPath)
+}
@@ -0,0 +1,238 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+)
+
+func TestApplySchoolYearFields(t *testing.T) {
+	person := Person{Birthday: time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)}
+	cases := []struct {
+		name    string
+		fields  SchoolYearFields
+		wantErr bool
+	}{
+		{"label only", SchoolYearFields{Label: "2024-25"}, false},
+		{"full year", SchoolYearFields{Label: "2024-25", StartDate: stringPtr("2024-09-03"), EndDate: stringPtr("2025-06-20"), School: "Maple Elementary", Grade: "1st grade", Teacher: "Ms. Ortiz"}, false},
+		{"no label", SchoolYearFields{Label: "  ", School: "Maple Elementary"}, true},
+		{"ends before it starts", SchoolYearFields{Label: "2024-25", StartDate: stringPtr("2025-06-20"), EndDate: stringPtr("2024-09-03")}, true},
+		{"bad date", SchoolYearFields{Label: "2024-25", StartDate: stringPtr("Sept 3")}, true},
+	}
+	for _, tc := range cases {
+		var year SchoolYear
+		if err := applySchoolYearFields(&year, tc.fields, person); (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+
+	person.IsPregnancy = true
+	var year SchoolYear
+	if err := applySchoolYearFields(&year, SchoolYearFields{Label: "2024-25"}, person); err == nil {
+		t.Error("recorded a school year for a pregnancy")
+	}
+}
+
+func TestSchoolHistory(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("school-test-secret-key-at-least-32-bytes")
+
+	var reportCard Image
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		reportCard = writeTestImage(tx, fx.famA, fx.userA.Id, "report-card.jpg")
+		tagPersonInPhoto(tx, reportCard.Id, fx.alice.Id, fx.famA)
+		vbolt.TxCommit(tx)
+	})
+
+	var kindergarten, firstGrade SchoolYear
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := CreateSchoolYear(ctx, CreateSchoolYearRequest{PersonId: fx.alice.Id, SchoolYearFields: SchoolYearFields{
+			Label: "2023-24", StartDate: stringPtr("2023-09-05"), School: "Maple Elementary", Grade: "Kindergarten",
+		}})
+		if err != nil {
+			t.Fatalf("CreateSchoolYear() error = %v", err)
+		}
+		kindergarten = resp.Year.SchoolYear
+		if kindergarten.FamilyId != fx.famA {
+			t.Errorf("the year belongs to family %d, want %d", kindergarten.FamilyId, fx.famA)
+		}
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		resp, err := CreateSchoolYear(ctx, CreateSchoolYearRequest{PersonId: fx.alice.Id, SchoolYearFields: SchoolYearFields{
+			Label: "2024-25", StartDate: stringPtr("2024-09-03"), School: "Maple Elementary", Grade: "1st grade", Teacher: "Ms. Ortiz",
+		}})
+		if err != nil {
+			t.Fatalf("CreateSchoolYear() error = %v", err)
+		}
+		firstGrade = resp.Year.SchoolYear
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		grades, err := SetSchoolGrades(ctx, SetSchoolGradesRequest{SchoolYearId: firstGrade.Id, Grades: []SchoolGradeInput{
+			{Subject: "Reading", Term: "Q1", Mark: "Meets expectations"},
+			{Subject: "Math", Term: "Q1", Mark: "Exceeds expectations", Comment: "Loves fractions"},
+		}})
+		if err != nil || len(grades.Grades) != 2 {
+			t.Fatalf("SetSchoolGrades() = %+v, %v", grades, err)
+		}
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		// Setting grades replaces the list rather than adding to it.
+		if _, err := SetSchoolGrades(ctx, SetSchoolGradesRequest{SchoolYearId: firstGrade.Id, Grades: []SchoolGradeInput{
+			{Subject: "Math", Term: "Q1", Mark: "Exceeds expectations"},
+			{Subject: "Reading", Term: "Q1", Mark: "Meets expectations"},
+			{Subject: "Art", Mark: "A"},
+		}}); err != nil {
+			t.Fatalf("SetSchoolGrades() error = %v", err)
+		}
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := SetSchoolGrades(ctx, SetSchoolGradesRequest{SchoolYearId: firstGrade.Id, Grades: []SchoolGradeInput{{Mark: "A"}}}); err != ErrSubjectRequired {
+			t.Errorf("a grade without a subject: error = %v", err)
+		}
+		if _, err := SetSchoolYearPhotos(ctx, SetSchoolYearPhotosRequest{SchoolYearId: firstGrade.Id, PhotoIds: []int{reportCard.Id}}); err != nil {
+			t.Fatalf("SetSchoolYearPhotos() error = %v", err)
+		}
+	})
+
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		history, err := GetSchoolHistory(ctx, GetSchoolHistoryRequest{PersonId: fx.alice.Id})
+		if err != nil {
+			t.Fatalf("GetSchoolHistory() error = %v", err)
+		}
+		if len(history.Years) != 2 || history.Years[0].Id != firstGrade.Id || history.Years[1].Id != kindergarten.Id {
+			t.Fatalf("history is not newest first: %+v", history.Years)
+		}
+		year := history.Years[0]
+		if len(year.Grades) != 3 || year.Grades[0].Subject != "Math" || year.Grades[2].Subject != "Art" {
+			t.Errorf("grades = %+v", year.Grades)
+		}
+		if len(year.PhotoIds) != 1 || year.PhotoIds[0] != reportCard.Id {
+			t.Errorf("photo ids = %v", year.PhotoIds)
+		}
+	})
+
+	// School is its own scope, off by default, and read-only through a link.
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		if _, err := GetSchoolHistory(ctx, GetSchoolHistoryRequest{PersonId: fx.alice.Id}); err == nil {
+			t.Error("a default link read alice's school history")
+		}
+	})
+	setLinkScopes(t, fx, fx.linkAB, LinkScopes{School: true})
+	callAsUser(t, fx.db, fx.userB, func(ctx *vbeam.Context) {
+		history, err := GetSchoolHistory(ctx, GetSchoolHistoryRequest{PersonId: fx.alice.Id})
+		if err != nil {
+			t.Fatalf("GetSchoolHistory() through a school link error = %v", err)
+		}
+		// The link does not carry photos, so the report-card photo is left out.
+		if len(history.Years) != 2 || len(history.Years[0].PhotoIds) != 0 {
+			t.Errorf("history through a school-only link = %+v", history.Years)
+		}
+		if _, err := UpdateSchoolYear(ctx, UpdateSchoolYearRequest{Id: firstGrade.Id, SchoolYearFields: SchoolYearFields{Label: "changed"}}); err == nil {
+			t.Error("a linked family edited a school year")
+		}
+		if _, err := CreateSchoolYear(ctx, CreateSchoolYearRequest{PersonId: fx.alice.Id, SchoolYearFields: SchoolYearFields{Label: "2025-26"}}); err == nil {
+			t.Error("a linked family added a school year")
+		}
+	})
+
+	// Deleting the photo clears its join; deleting the year takes its grades.
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		deletePhotoRecordTx(tx, reportCard)
+		if ids := GetSchoolYearPhotoIds(tx, firstGrade.Id); len(ids) != 0 {
+			t.Errorf("a deleted photo is still on the year: %v", ids)
+		}
+		vbolt.TxCommit(tx)
+	})
+	callAsUser(t, fx.db, fx.userA, func(ctx *vbeam.Context) {
+		if _, err := DeleteSchoolYear(ctx, SchoolIdRequest{Id: firstGrade.Id}); err != nil {
+			t.Fatalf("DeleteSchoolYear() error = %v", err)
+		}
+	})
+	if got := countRows(t, fx.db, SchoolGradeBkt); got != 0 {
+		t.Errorf("%d grades outlived their year", got)
+	}
+	if got := countRows(t, fx.db, SchoolYearBkt); got != 1 {
+		t.Errorf("%d school years remain, want 1", got)
+	}
+}
+
+func TestFamilyTimelineSchoolYears(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	jwtKey = []byte("school-test-secret-key-at-least-32-bytes")
+
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		year := SchoolYear{Id: vbolt.NextIntId(tx, SchoolYearBkt), PersonId: fx.alice.Id, FamilyId: fx.famA, Label: "2024-25"}
+		writeSchoolYearTx(tx, &year)
+		vbolt.TxCommit(tx)
+	})
+
+	aliceYears := func(user User) []SchoolYear {
+		t.Helper()
+		var years []SchoolYear
+		callAsUser(t, fx.db, user, func(ctx *vbeam.Context) {
+			timeline, err := GetFamilyTimeline(ctx, GetFamilyTimelineRequest{})
+			if err != nil {
+				t.Fatalf("GetFamilyTimeline() error = %v", err)
+			}
+			for _, item := range timeline.People {
+				if item.Person.Id == fx.alice.Id {
+					years = item.SchoolYears
+				}
+			}
+		})
+		return years
+	}
+
+	if years := aliceYears(fx.userA); len(years) != 1 || years[0].Label != "2024-25" {
+		t.Errorf("alice's own family sees %+v", years)
+	}
+	if years := aliceYears(fx.userB); len(years) != 0 {
+		t.Errorf("a default link sees %+v", years)
+	}
+	setLinkScopes(t, fx, fx.linkAB, LinkScopes{School: true})
+	if years := aliceYears(fx.userB); len(years) != 1 {
+		t.Errorf("a school link sees %+v", years)
+	}
+}
+
+func TestMergeAndUnmergeSchoolYears(t *testing.T) {
+	fx := setupMergeFixture(t)
+	var year SchoolYear
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		year = SchoolYear{Id: vbolt.NextIntId(tx, SchoolYearBkt), PersonId: fx.duplicate.Id, FamilyId: fx.parent.FamilyId, Label: "2025-26"}
+		writeSchoolYearTx(tx, &year)
+		if _, err := setSchoolGradesTx(tx, year, []SchoolGradeInput{{Subject: "Reading", Mark: "B"}}); err != nil {
+			t.Fatalf("setSchoolGradesTx() error = %v", err)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	merged := fx.merge(t)
+	if merged.MergedSchoolYears != 1 {
+		t.Errorf("MergedSchoolYears = %d, want 1", merged.MergedSchoolYears)
+	}
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		years := GetPersonSchoolYears(tx, fx.child.Id)
+		if len(years) != 1 || years[0].Id != year.Id || len(GetSchoolYearGrades(tx, year.Id)) != 1 {
+			t.Errorf("the target's school years after the merge: %+v", years)
+		}
+	})
+
+	fx.call(t, func(ctx *vbeam.Context) {
+		if _, err := UnmergePeople(ctx, UnmergePeopleRequest{MergeId: merged.MergeId}); err != nil {
+			t.Fatalf("UnmergePeople() error = %v", err)
+		}
+	})
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if years := GetPersonSchoolYears(tx, fx.duplicate.Id); len(years) != 1 || years[0].Id != year.Id {
+			t.Errorf("the school year did not come back: %+v", years)
+		}
+		if years := GetPersonSchoolYears(tx, fx.child.Id); len(years) != 0 {
+			t.Errorf("the target kept %+v", years)
+		}
+	})
+}
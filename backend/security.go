@@ -10,6 +10,7 @@ import (
 const (
 	maxJSONRequestBytes   int64 = 1 << 20   // 1 MiB
 	maxPhotoRequestBytes  int64 = 52 << 20  // 50 MiB file plus multipart metadata
+	maxVideoRequestBytes  int64 = 202 << 20 // 200 MiB clip plus multipart metadata
 	maxImportRequestBytes int64 = 512 << 20 // Full-family archives can contain photos.
 )
 
@@ -28,6 +29,8 @@ func requestBodyLimit(r *http.Request) int64 {
 	switch r.URL.Path {
 	case "/api/upload-photo":
 		return maxPhotoRequestBytes
+	case "/api/upload-video":
+		return maxVideoRequestBytes
 	case "/api/import-bundle":
 		return maxImportRequestBytes
 	}
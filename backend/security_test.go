@@ -20,6 +20,7 @@ func TestRequestSizeLimitWrapper(t *testing.T) {
 	}{
 		{name: "JSON RPC", path: "/rpc/example", contentType: "application/json; charset=utf-8", limit: maxJSONRequestBytes},
 		{name: "photo upload", path: "/api/upload-photo", contentType: "multipart/form-data; boundary=test", limit: maxPhotoRequestBytes},
+		{name: "video upload", path: "/api/upload-video", contentType: "multipart/form-data; boundary=test", limit: maxVideoRequestBytes},
 		{name: "family import", path: "/api/import-bundle", contentType: "multipart/form-data; boundary=test", limit: maxImportRequestBytes},
 	}
 
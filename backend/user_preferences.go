@@ -0,0 +1,262 @@
+package backend
+
+import (
+	"errors"
+	"family/cfg"
+	"fmt"
+	"math"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// A measurement is stored in the unit it was entered in, and that pair is the
+// record: converting on the way in would turn a clinic's 40.6 cm into 15.98 in
+// and back into 40.59 cm. What changes from reader to reader is only how it is
+// shown. Two linked families can look at the same child, one in inches and one
+// in centimetres, so the choice belongs to the user rather than to a family.
+//
+// The server does the conversion so every place a value leaves it — a growth
+// read, an export, an email, the context handed to AI import — agrees, instead
+// of each client carrying its own copy of the arithmetic and the rounding.
+
+func RegisterUserPreferenceMethods(app *vbeam.Application) {
+	vbeam.RegisterProc(app, GetUserPreferences)
+	vbeam.RegisterProc(app, UpdateUserPreferences)
+}
+
+// Unit systems. The empty value shows each measurement in the unit it was
+// entered in, which is what every reader saw before the setting existed.
+const (
+	UnitSystemAsEntered = ""
+	UnitSystemMetric    = "metric"
+	UnitSystemImperial  = "imperial"
+)
+
+// Date formats, named for what they look like rather than for a locale, since
+// a locale would also be claiming a language the app does not have.
+const (
+	DateFormatLong = "long" // Jan 2, 2006
+	DateFormatISO  = "iso"  // 2006-01-02
+	DateFormatUS   = "us"   // 01/02/2006
+	DateFormatEU   = "eu"   // 02/01/2006
+)
+
+var dateFormatLayouts = map[string]string{
+	DateFormatLong: "Jan 2, 2006",
+	DateFormatISO:  "2006-01-02",
+	DateFormatUS:   "01/02/2006",
+	DateFormatEU:   "02/01/2006",
+}
+
+// dateFormatPatterns spells each layout the way a person would, for the AI
+// import context.
+var dateFormatPatterns = map[string]string{
+	DateFormatLong: "Mon D, YYYY",
+	DateFormatISO:  "YYYY-MM-DD",
+	DateFormatUS:   "MM/DD/YYYY",
+	DateFormatEU:   "DD/MM/YYYY",
+}
+
+// UserPreferences is one account's display settings.
+type UserPreferences struct {
+	UserId     int    `json:"userId"`
+	UnitSystem string `json:"unitSystem"`
+	DateFormat string `json:"dateFormat"`
+	// WeekStart is the first day of a calendar week, as a time.Weekday:
+	// 0 for Sunday, 1 for Monday.
+	WeekStart int       `json:"weekStart"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func PackUserPreferences(self *UserPreferences, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.UserId, buf)
+	vpack.String(&self.UnitSystem, buf)
+	vpack.String(&self.DateFormat, buf)
+	vpack.Int(&self.WeekStart, buf)
+	vpack.Time(&self.UpdatedAt, buf)
+}
+
+var UserPreferencesBkt = vbolt.Bucket(&cfg.Info, "user_preferences", vpack.FInt, PackUserPreferences)
+
+// defaultUserPreferences is what an account reads as before it has saved
+// anything: values as entered, long dates, weeks from Sunday.
+func defaultUserPreferences(userId int) UserPreferences {
+	return UserPreferences{
+		UserId:     userId,
+		UnitSystem: UnitSystemAsEntered,
+		DateFormat: DateFormatLong,
+		WeekStart:  int(time.Sunday),
+	}
+}
+
+// loadUserPreferences reads an account's settings, falling back to the
+// defaults when it has never saved any. As with notification preferences, a
+// zero UserId is the "nothing written yet" marker.
+func loadUserPreferences(tx *vbolt.Tx, userId int) UserPreferences {
+	var prefs UserPreferences
+	vbolt.Read(tx, UserPreferencesBkt, userId, &prefs)
+	if prefs.UserId == 0 {
+		return defaultUserPreferences(userId)
+	}
+	return prefs
+}
+
+// deleteUserPreferencesTx drops the row for a deleted account.
+func deleteUserPreferencesTx(tx *vbolt.Tx, userId int) {
+	vbolt.Delete(tx, UserPreferencesBkt, userId)
+}
+
+// formatDate renders a date the way this reader asked for.
+func (prefs UserPreferences) formatDate(t time.Time) string {
+	layout, ok := dateFormatLayouts[prefs.DateFormat]
+	if !ok {
+		layout = dateFormatLayouts[DateFormatLong]
+	}
+	return t.Format(layout)
+}
+
+// displayGrowthValue converts a stored value into this reader's unit system,
+// rounded to two decimals so 16 in does not come back as 40.640000000000001.
+// Values already in the wanted unit are passed through untouched.
+func (prefs UserPreferences) displayGrowthValue(measurementType MeasurementType, value float64, unit string) (float64, string) {
+	var want string
+	switch prefs.UnitSystem {
+	case UnitSystemMetric:
+		want = "cm"
+		if measurementType == Weight {
+			want = "kg"
+		}
+	case UnitSystemImperial:
+		want = "in"
+		if measurementType == Weight {
+			want = "lbs"
+		}
+	default:
+		return value, unit
+	}
+	if unit == want {
+		return value, unit
+	}
+
+	converted := canonicalGrowthValue(measurementType, value, unit)
+	switch want {
+	case "in":
+		converted = converted / 2.54
+	case "lbs":
+		converted = converted * 2.20462
+	}
+	return math.Round(converted*100) / 100, want
+}
+
+// formatGrowthValue is displayGrowthValue as text, for emails.
+func (prefs UserPreferences) formatGrowthValue(measurementType MeasurementType, value float64, unit string) string {
+	value, unit = prefs.displayGrowthValue(measurementType, value, unit)
+	return fmt.Sprintf("%g %s", value, unit)
+}
+
+// localizeGrowthData fills in the display fields on measurements about to be
+// returned to this reader. Value and Unit are left exactly as stored.
+func localizeGrowthData(prefs UserPreferences, growthData []GrowthData) []GrowthData {
+	for i := range growthData {
+		growthData[i] = localizeGrowthValue(prefs, growthData[i])
+	}
+	return growthData
+}
+
+func localizeGrowthValue(prefs UserPreferences, gd GrowthData) GrowthData {
+	gd.DisplayValue, gd.DisplayUnit = prefs.displayGrowthValue(gd.MeasurementType, gd.Value, gd.Unit)
+	gd.DisplayDate = prefs.formatDate(gd.MeasurementDate)
+	return gd
+}
+
+// Request/Response types
+
+type UserPreferencesResponse struct {
+	UnitSystem string `json:"unitSystem"`
+	DateFormat string `json:"dateFormat"`
+	WeekStart  int    `json:"weekStart"`
+}
+
+type UpdateUserPreferencesRequest struct {
+	UnitSystem string `json:"unitSystem"` // "", "metric" or "imperial"
+	DateFormat string `json:"dateFormat"` // "long", "iso", "us" or "eu"; empty means "long"
+	WeekStart  int    `json:"weekStart"`  // 0 (Sunday) or 1 (Monday)
+}
+
+type UpdateUserPreferencesResponse struct {
+	Preferences UserPreferencesResponse `json:"preferences"`
+}
+
+func userPreferencesResponse(prefs UserPreferences) UserPreferencesResponse {
+	return UserPreferencesResponse{
+		UnitSystem: prefs.UnitSystem,
+		DateFormat: prefs.DateFormat,
+		WeekStart:  prefs.WeekStart,
+	}
+}
+
+func validateUpdateUserPreferencesRequest(req UpdateUserPreferencesRequest) error {
+	switch req.UnitSystem {
+	case UnitSystemAsEntered, UnitSystemMetric, UnitSystemImperial:
+	default:
+		return errors.New("Unit system must be 'metric' or 'imperial'")
+	}
+	if _, ok := dateFormatLayouts[req.DateFormat]; req.DateFormat != "" && !ok {
+		return errors.New("Date format must be 'long', 'iso', 'us' or 'eu'")
+	}
+	if req.WeekStart != int(time.Sunday) && req.WeekStart != int(time.Monday) {
+		return errors.New("Weeks can start on Sunday or Monday")
+	}
+	return nil
+}
+
+// vbeam procedures
+
+func GetUserPreferences(ctx *vbeam.Context, req Empty) (resp UserPreferencesResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	resp = userPreferencesResponse(loadUserPreferences(ctx.Tx, user.Id))
+	return
+}
+
+func UpdateUserPreferences(ctx *vbeam.Context, req UpdateUserPreferencesRequest) (resp UpdateUserPreferencesResponse, err error) {
+	user, authErr := GetAuthUser(ctx)
+	if authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+
+	if err = validateUpdateUserPreferencesRequest(req); err != nil {
+		return
+	}
+
+	prefs := UserPreferences{
+		UserId:     user.Id,
+		UnitSystem: req.UnitSystem,
+		DateFormat: req.DateFormat,
+		WeekStart:  req.WeekStart,
+		UpdatedAt:  time.Now(),
+	}
+	if prefs.DateFormat == "" {
+		prefs.DateFormat = DateFormatLong
+	}
+	if prefs.UserId == 0 {
+		err = errors.New("invalid account")
+		return
+	}
+
+	vbeam.UseWriteTx(ctx)
+	vbolt.Write(ctx.Tx, UserPreferencesBkt, prefs.UserId, &prefs)
+	vbolt.TxCommit(ctx.Tx)
+
+	resp.Preferences = userPreferencesResponse(prefs)
+	return
+}
@@ -0,0 +1,242 @@
+package backend
+
+import (
+	"family/cfg"
+	"strings"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbeam"
+	"go.hasen.dev/vbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDisplayGrowthValue(t *testing.T) {
+	metric := UserPreferences{UnitSystem: UnitSystemMetric}
+	imperial := UserPreferences{UnitSystem: UnitSystemImperial}
+	asEntered := UserPreferences{UnitSystem: UnitSystemAsEntered}
+
+	tests := []struct {
+		name      string
+		prefs     UserPreferences
+		typ       MeasurementType
+		value     float64
+		unit      string
+		wantValue float64
+		wantUnit  string
+	}{
+		{"inches to centimetres", metric, Height, 16, "in", 40.64, "cm"},
+		{"pounds to kilograms", metric, Weight, 22, "lbs", 9.98, "kg"},
+		{"centimetres to inches", imperial, Height, 100, "cm", 39.37, "in"},
+		{"kilograms to pounds", imperial, Weight, 10, "kg", 22.05, "lbs"},
+		{"head circumference to inches", imperial, HeadCircumference, 45, "cm", 17.72, "in"},
+		{"already in the wanted unit", metric, Height, 100.123, "cm", 100.123, "cm"},
+		{"as entered is untouched", asEntered, Weight, 22, "lbs", 22, "lbs"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			value, unit := tc.prefs.displayGrowthValue(tc.typ, tc.value, tc.unit)
+			if value != tc.wantValue || unit != tc.wantUnit {
+				t.Errorf("displayGrowthValue(%v %s) = %v %s, want %v %s", tc.value, tc.unit, value, unit, tc.wantValue, tc.wantUnit)
+			}
+		})
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	for format, want := range map[string]string{
+		DateFormatLong: "Mar 4, 2024",
+		DateFormatISO:  "2024-03-04",
+		DateFormatUS:   "03/04/2024",
+		DateFormatEU:   "04/03/2024",
+		"":             "Mar 4, 2024",
+	} {
+		if got := (UserPreferences{DateFormat: format}).formatDate(day); got != want {
+			t.Errorf("formatDate with %q = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestValidateUpdateUserPreferencesRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     UpdateUserPreferencesRequest
+		wantErr bool
+	}{
+		{"metric, iso, monday", UpdateUserPreferencesRequest{UnitSystem: UnitSystemMetric, DateFormat: DateFormatISO, WeekStart: 1}, false},
+		{"defaults", UpdateUserPreferencesRequest{}, false},
+		{"unknown unit system", UpdateUserPreferencesRequest{UnitSystem: "furlongs"}, true},
+		{"unknown date format", UpdateUserPreferencesRequest{DateFormat: "dd.mm.yy"}, true},
+		{"week starting wednesday", UpdateUserPreferencesRequest{WeekStart: 3}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateUpdateUserPreferencesRequest(tc.req); (err != nil) != tc.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// Two readers of the same measurement each see it their own way, and neither
+// view changes what is stored.
+func TestGrowthReadsFollowTheReader(t *testing.T) {
+	db := vbolt.Open(t.TempDir() + "/user_prefs.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	t.Cleanup(func() { _ = db.Close() })
+	appDb = db
+	jwtKey = []byte("user-prefs-test-secret-key-at-least-32")
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	var parent, grandparent User
+	var gd GrowthData
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		parent = AddUserTx(tx, CreateAccountRequest{Name: "Parent", Email: "parent@example.com"}, hash)
+		grandparent = AddUserTx(tx, CreateAccountRequest{Name: "Grandparent", Email: "grandparent@example.com"}, hash)
+		EnsureMembershipTx(tx, grandparent.Id, parent.FamilyId, AccessView)
+
+		child, err := AddPersonTx(tx, AddPersonRequest{Name: "Kid", PersonType: 1, Gender: 0, Birthdate: "2020-01-01"}, parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddPersonTx() error = %v", err)
+		}
+		gd, err = AddGrowthDataTx(tx, AddGrowthDataRequest{
+			PersonId: child.Id, MeasurementType: "height", Value: 40, Unit: "in",
+			InputType: "date", MeasurementDate: stringPtr("2024-03-04"),
+		}, parent.FamilyId)
+		if err != nil {
+			t.Fatalf("AddGrowthDataTx() error = %v", err)
+		}
+
+		prefs := UserPreferences{UserId: grandparent.Id, UnitSystem: UnitSystemMetric, DateFormat: DateFormatEU}
+		vbolt.Write(tx, UserPreferencesBkt, prefs.UserId, &prefs)
+		vbolt.TxCommit(tx)
+	})
+
+	read := func(user User) GrowthData {
+		token, err := generateJwtTokenString(user)
+		if err != nil {
+			t.Fatalf("generateJwtTokenString() error = %v", err)
+		}
+		var resp GetGrowthDataResponse
+		vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+			resp, err = GetGrowthData(&vbeam.Context{Tx: tx, Token: token}, GetGrowthDataRequest{Id: gd.Id})
+		})
+		if err != nil {
+			t.Fatalf("GetGrowthData() error = %v", err)
+		}
+		return resp.GrowthData
+	}
+
+	mine := read(parent)
+	if mine.DisplayValue != 40 || mine.DisplayUnit != "in" || mine.DisplayDate != "Mar 4, 2024" {
+		t.Errorf("the parent's defaults should show the value as entered, got %v %s on %s", mine.DisplayValue, mine.DisplayUnit, mine.DisplayDate)
+	}
+
+	theirs := read(grandparent)
+	if theirs.DisplayValue != 101.6 || theirs.DisplayUnit != "cm" || theirs.DisplayDate != "04/03/2024" {
+		t.Errorf("the grandparent should read metric, day first, got %v %s on %s", theirs.DisplayValue, theirs.DisplayUnit, theirs.DisplayDate)
+	}
+	if theirs.Value != 40 || theirs.Unit != "in" {
+		t.Errorf("the stored value must come back as stored, got %v %s", theirs.Value, theirs.Unit)
+	}
+}
+
+func TestUpdateUserPreferencesRoundTrip(t *testing.T) {
+	db := vbolt.Open(t.TempDir() + "/user_prefs.db")
+	vbolt.InitBuckets(db, &cfg.Info)
+	t.Cleanup(func() { _ = db.Close() })
+	appDb = db
+	jwtKey = []byte("user-prefs-test-secret-key-at-least-32")
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	var user User
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		user = AddUserTx(tx, CreateAccountRequest{Name: "Reader", Email: "reader@example.com"}, hash)
+		vbolt.TxCommit(tx)
+	})
+	token, _ := generateJwtTokenString(user)
+
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		resp, err := GetUserPreferences(&vbeam.Context{Tx: tx, Token: token}, Empty{})
+		if err != nil {
+			t.Fatalf("GetUserPreferences() error = %v", err)
+		}
+		if resp.UnitSystem != UnitSystemAsEntered || resp.DateFormat != DateFormatLong || resp.WeekStart != 0 {
+			t.Errorf("defaults = %+v", resp)
+		}
+	})
+
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		_, err := UpdateUserPreferences(&vbeam.Context{Tx: tx, Token: token}, UpdateUserPreferencesRequest{
+			UnitSystem: UnitSystemImperial, DateFormat: DateFormatUS, WeekStart: 1,
+		})
+		if err != nil {
+			t.Fatalf("UpdateUserPreferences() error = %v", err)
+		}
+	})
+
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		got := loadUserPreferences(tx, user.Id)
+		if got.UnitSystem != UnitSystemImperial || got.DateFormat != DateFormatUS || got.WeekStart != 1 {
+			t.Errorf("stored %+v", got)
+		}
+	})
+}
+
+func TestLocalizeExportData(t *testing.T) {
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	exportData := ExportDataStructure{
+		Heights:            []ImportHeight{{Inches: 40, Date: day, DateString: "2024-03-04"}},
+		Weights:            []ImportWeight{{Pounds: 22, Date: day}},
+		HeadCircumferences: []ImportHeadCircumference{{Centimeters: 45, Date: day}},
+		Milestones:         []ExportMilestone{{MilestoneDate: day}},
+	}
+	localizeExportData(UserPreferences{UnitSystem: UnitSystemMetric, DateFormat: DateFormatISO}, &exportData)
+
+	if got := exportData.Heights[0].Display; got != "101.6 cm" {
+		t.Errorf("height display = %q", got)
+	}
+	if exportData.Heights[0].Inches != 40 {
+		t.Error("the field import reads must keep its fixed unit")
+	}
+	if got := exportData.Weights[0].Display; got != "9.98 kg" {
+		t.Errorf("weight display = %q", got)
+	}
+	if got := exportData.HeadCircumferences[0].Display; got != "45 cm" {
+		t.Errorf("head circumference display = %q", got)
+	}
+	if got := exportData.Milestones[0].DisplayDate; got != "2024-03-04" {
+		t.Errorf("milestone display date = %q", got)
+	}
+	if exportData.UnitSystem != UnitSystemMetric || exportData.DateFormat != DateFormatISO {
+		t.Error("the export should say which preferences it was rendered with")
+	}
+}
+
+func TestPersonContextCarriesReaderPreferences(t *testing.T) {
+	person := Person{Id: 3, Name: "Kid", Birthday: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	plain := formatPersonContext(person, defaultUserPreferences(1))
+	if strings.Contains(plain, "imperial") || strings.Contains(plain, "metric") || strings.Contains(plain, "writes dates") {
+		t.Errorf("default preferences should add nothing to the context:\n%s", plain)
+	}
+
+	eu := formatPersonContext(person, UserPreferences{UnitSystem: UnitSystemMetric, DateFormat: DateFormatEU})
+	if !strings.Contains(eu, "centimeters or kilograms") {
+		t.Errorf("expected a metric hint:\n%s", eu)
+	}
+	if !strings.Contains(eu, "DD/MM/YYYY") || !strings.Contains(eu, "04/03/2024") {
+		t.Errorf("expected a day-first date hint:\n%s", eu)
+	}
+}
+
+func TestGrowthAlertEmailUsesRecipientUnits(t *testing.T) {
+	gd := GrowthData{MeasurementType: Weight, Value: 10, Unit: "kg", MeasurementDate: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)}
+	body := growthAlertEmailBody(User{Name: "Grandma"}, UserPreferences{UnitSystem: UnitSystemImperial, DateFormat: DateFormatUS},
+		User{Name: "Parent"}, Person{Name: "Kid"}, gd, []string{"note"})
+	if !strings.Contains(body, "22.05 lbs") || !strings.Contains(body, "03/04/2024") {
+		t.Errorf("expected the email in pounds and US dates:\n%s", body)
+	}
+}
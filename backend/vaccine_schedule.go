@@ -0,0 +1,283 @@
+package backend
+
+// Vaccine schedule and the doses a person has due.
+//
+// The schedule ships inside the binary, like the checklist and the growth
+// references, and says which dose of which vaccine is recommended at what age.
+// Countries and clinics schedule differently, so VACCINE_SCHEDULE_PATH can name
+// a file of the same shape to use instead. It is read once, on first use; a
+// file that does not parse is reported at startup by CheckProductionConfig,
+// and falls back to the built-in schedule rather than leaving nobody anything
+// due.
+//
+// Nothing about the schedule is stored. A vaccination records the vaccine and
+// dose that was given, and what is due is worked out on read, so changing the
+// schedule changes every family's answer at once and never leaves a stale
+// "due" row behind.
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.hasen.dev/vbeam"
+)
+
+//go:embed healthref/vaccines.csv
+var vaccineScheduleCSV []byte
+
+// ScheduledDose is one row of the schedule.
+type ScheduledDose struct {
+	Vaccine   string `json:"vaccine"` // stable key, e.g. "dtap"
+	Name      string `json:"name"`
+	Dose      int    `json:"dose"` // 1-based
+	AgeMonths int    `json:"ageMonths"`
+}
+
+// Due statuses, earliest first.
+const (
+	VaccineUpcoming = "upcoming" // due within vaccineUpcomingMonths
+	VaccineDue      = "due"
+	VaccineOverdue  = "overdue" // more than vaccineOverdueMonths past its age
+)
+
+// vaccineUpcomingMonths is how far ahead a dose is mentioned, long enough to
+// book the appointment. vaccineOverdueMonths is the slack before a dose is
+// called overdue: a schedule age is a recommendation, and a dose a few weeks
+// late is still on time by most clinics' reckoning.
+const (
+	vaccineUpcomingMonths = 2
+	vaccineOverdueMonths  = 3
+)
+
+// DueVaccine is a scheduled dose the person has not had.
+type DueVaccine struct {
+	ScheduledDose
+	DueDate time.Time `json:"dueDate"`
+	Status  string    `json:"status"`
+}
+
+// builtinVaccineSchedule is the embedded schedule. A malformed table is a
+// build mistake, so it panics rather than quietly serving nothing due.
+var builtinVaccineSchedule = mustParseVaccineSchedule(vaccineScheduleCSV)
+
+func mustParseVaccineSchedule(data []byte) []ScheduledDose {
+	schedule, err := parseVaccineSchedule(data)
+	if err != nil {
+		panic("vaccine schedule: " + err.Error())
+	}
+	return schedule
+}
+
+func parseVaccineSchedule(data []byte) ([]ScheduledDose, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 4
+
+	var schedule []ScheduledDose
+	seen := make(map[string]bool)
+	sawHeader := false
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !sawHeader {
+			sawHeader = true
+			continue
+		}
+
+		key := strings.TrimSpace(record[0])
+		if key == "" || strings.TrimSpace(record[1]) == "" {
+			return nil, errors.New("a row has no vaccine or name")
+		}
+		dose, err := strconv.Atoi(record[2])
+		if err != nil || dose <= 0 {
+			return nil, fmt.Errorf("bad dose in row for %q", key)
+		}
+		age, err := strconv.Atoi(record[3])
+		if err != nil || age < 0 {
+			return nil, fmt.Errorf("bad age in row for %q dose %d", key, dose)
+		}
+		doseKey := key + "#" + record[2]
+		if seen[doseKey] {
+			return nil, fmt.Errorf("duplicate row for %q dose %d", key, dose)
+		}
+		seen[doseKey] = true
+		schedule = append(schedule, ScheduledDose{Vaccine: key, Name: strings.TrimSpace(record[1]), Dose: dose, AgeMonths: age})
+	}
+	if len(schedule) == 0 {
+		return nil, errors.New("no doses")
+	}
+	sort.SliceStable(schedule, func(i, j int) bool {
+		return schedule[i].AgeMonths < schedule[j].AgeMonths
+	})
+	return schedule, nil
+}
+
+// loadVaccineSchedule reads the schedule named by VACCINE_SCHEDULE_PATH, or
+// returns the built-in one when it is unset.
+func loadVaccineSchedule() ([]ScheduledDose, error) {
+	path := os.Getenv("VACCINE_SCHEDULE_PATH")
+	if path == "" {
+		return builtinVaccineSchedule, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseVaccineSchedule(data)
+}
+
+var (
+	vaccineScheduleOnce   sync.Once
+	activeVaccineSchedule []ScheduledDose
+)
+
+// vaccineSchedule is the schedule in force.
+func vaccineSchedule() []ScheduledDose {
+	vaccineScheduleOnce.Do(func() {
+		schedule, err := loadVaccineSchedule()
+		if err != nil {
+			LogWarn(LogCategorySystem, "Vaccine schedule unusable, using the built-in one", map[string]interface{}{
+				"error": err.Error(),
+			})
+			schedule = builtinVaccineSchedule
+		}
+		activeVaccineSchedule = schedule
+	})
+	return activeVaccineSchedule
+}
+
+// foldVaccineName reduces a vaccine's name to letters and digits, lowercased,
+// so "DTaP", "dtap" and "D-TaP" are one vaccine.
+func foldVaccineName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// scheduleVaccineKey returns the schedule key a vaccine name refers to, by key
+// or by name, or "" for a vaccine the schedule does not list — a travel
+// vaccine, say, which is recorded all the same.
+func scheduleVaccineKey(schedule []ScheduledDose, vaccine string) string {
+	folded := foldVaccineName(vaccine)
+	if folded == "" {
+		return ""
+	}
+	for _, dose := range schedule {
+		if foldVaccineName(dose.Vaccine) == folded || foldVaccineName(dose.Name) == folded {
+			return dose.Vaccine
+		}
+	}
+	return ""
+}
+
+// computeDueVaccines lists the scheduled doses the person has not had and that
+// are upcoming, due or overdue as of now, earliest first.
+//
+// A vaccination recorded with its dose number covers that dose. One recorded
+// without a number — a record copied off a card that did not say — covers the
+// lowest dose not otherwise accounted for, so three unnumbered DTaP shots are
+// the first three whatever order they were entered in.
+//
+// There is nothing due for an expected baby, or for someone who has died.
+func computeDueVaccines(schedule []ScheduledDose, person Person, vaccinations []Vaccination, now time.Time) []DueVaccine {
+	due := []DueVaccine{}
+	if person.IsPregnancy || person.InMemoriam {
+		return due
+	}
+
+	numbered := make(map[string]map[int]bool)
+	unnumbered := make(map[string]int)
+	for _, vaccination := range vaccinations {
+		key := scheduleVaccineKey(schedule, vaccination.Vaccine)
+		if key == "" {
+			continue
+		}
+		if vaccination.Dose > 0 {
+			if numbered[key] == nil {
+				numbered[key] = make(map[int]bool)
+			}
+			numbered[key][vaccination.Dose] = true
+		} else {
+			unnumbered[key]++
+		}
+	}
+
+	// Doses in dose order per vaccine, so unnumbered shots fill the earliest.
+	byDose := append([]ScheduledDose(nil), schedule...)
+	sort.SliceStable(byDose, func(i, j int) bool {
+		if byDose[i].Vaccine != byDose[j].Vaccine {
+			return byDose[i].Vaccine < byDose[j].Vaccine
+		}
+		return byDose[i].Dose < byDose[j].Dose
+	})
+
+	for _, dose := range byDose {
+		if numbered[dose.Vaccine][dose.Dose] {
+			continue
+		}
+		if unnumbered[dose.Vaccine] > 0 {
+			unnumbered[dose.Vaccine]--
+			continue
+		}
+
+		dueDate := person.Birthday.AddDate(0, dose.AgeMonths, 0)
+		var status string
+		switch {
+		case now.Before(dueDate.AddDate(0, -vaccineUpcomingMonths, 0)):
+			continue
+		case now.Before(dueDate):
+			status = VaccineUpcoming
+		case now.Before(dueDate.AddDate(0, vaccineOverdueMonths, 0)):
+			status = VaccineDue
+		default:
+			status = VaccineOverdue
+		}
+		due = append(due, DueVaccine{ScheduledDose: dose, DueDate: dueDate, Status: status})
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		if !due[i].DueDate.Equal(due[j].DueDate) {
+			return due[i].DueDate.Before(due[j].DueDate)
+		}
+		return due[i].Vaccine < due[j].Vaccine
+	})
+	return due
+}
+
+// Request/Response types
+
+type GetVaccineScheduleResponse struct {
+	Doses []ScheduledDose `json:"doses"` // by age
+}
+
+// vbeam procedures
+
+// GetVaccineSchedule returns the schedule in force, for picking a vaccine and
+// dose when recording one.
+func GetVaccineSchedule(ctx *vbeam.Context, req Empty) (resp GetVaccineScheduleResponse, err error) {
+	if _, authErr := GetAuthUser(ctx); authErr != nil {
+		err = ErrAuthFailure
+		return
+	}
+	resp.Doses = vaccineSchedule()
+	return
+}
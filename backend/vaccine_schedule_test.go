@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBuiltinVaccineScheduleParses(t *testing.T) {
+	if len(builtinVaccineSchedule) == 0 {
+		t.Fatal("the built-in schedule is empty")
+	}
+	for i := 1; i < len(builtinVaccineSchedule); i++ {
+		if builtinVaccineSchedule[i].AgeMonths < builtinVaccineSchedule[i-1].AgeMonths {
+			t.Fatalf("the schedule is not in age order at %+v", builtinVaccineSchedule[i])
+		}
+	}
+	if key := scheduleVaccineKey(builtinVaccineSchedule, "Hepatitis-B"); key != "hepb" {
+		t.Errorf("Hepatitis-B resolved to %q, want hepb", key)
+	}
+	if key := scheduleVaccineKey(builtinVaccineSchedule, "Yellow fever"); key != "" {
+		t.Errorf("a vaccine off the schedule resolved to %q", key)
+	}
+}
+
+func TestParseVaccineScheduleRejectsBadRows(t *testing.T) {
+	cases := map[string]string{
+		"no rows":        "vaccine,name,dose,age_months\n",
+		"zero dose":      "vaccine,name,dose,age_months\nmmr,MMR,0,12\n",
+		"negative age":   "vaccine,name,dose,age_months\nmmr,MMR,1,-1\n",
+		"duplicate dose": "vaccine,name,dose,age_months\nmmr,MMR,1,12\nmmr,MMR,1,15\n",
+		"no name":        "vaccine,name,dose,age_months\nmmr,,1,12\n",
+	}
+	for name, data := range cases {
+		if _, err := parseVaccineSchedule([]byte(data)); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestLoadVaccineScheduleFromPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule.csv")
+	if err := os.WriteFile(path, []byte("vaccine,name,dose,age_months\nbcg,BCG,1,0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VACCINE_SCHEDULE_PATH", path)
+
+	schedule, err := loadVaccineSchedule()
+	if err != nil {
+		t.Fatalf("loadVaccineSchedule() error = %v", err)
+	}
+	if len(schedule) != 1 || schedule[0].Vaccine != "bcg" {
+		t.Errorf("schedule = %+v", schedule)
+	}
+	if issues := checkVaccineSchedule(); len(issues) != 0 {
+		t.Errorf("issues = %v", issues)
+	}
+
+	t.Setenv("VACCINE_SCHEDULE_PATH", filepath.Join(t.TempDir(), "missing.csv"))
+	if issues := checkVaccineSchedule(); len(issues) != 1 {
+		t.Errorf("a missing schedule file raised %d issues, want 1", len(issues))
+	}
+}
+
+func TestComputeDueVaccines(t *testing.T) {
+	schedule := []ScheduledDose{
+		{Vaccine: "hepb", Name: "Hepatitis B", Dose: 1, AgeMonths: 0},
+		{Vaccine: "hepb", Name: "Hepatitis B", Dose: 2, AgeMonths: 1},
+		{Vaccine: "dtap", Name: "DTaP", Dose: 1, AgeMonths: 2},
+		{Vaccine: "dtap", Name: "DTaP", Dose: 2, AgeMonths: 4},
+		{Vaccine: "dtap", Name: "DTaP", Dose: 3, AgeMonths: 6},
+		{Vaccine: "mmr", Name: "MMR", Dose: 1, AgeMonths: 12},
+	}
+	baby := Person{Birthday: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	now := time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC) // eight months old
+
+	// Both hepatitis B doses are in, one without its number; DTaP dose 2 was
+	// given, dose 1 was not recorded.
+	vaccinations := []Vaccination{
+		{Vaccine: "hepb", Dose: 2},
+		{Vaccine: "Hepatitis B"},
+		{Vaccine: "dtap", Dose: 2},
+	}
+
+	got := map[string]string{}
+	for _, due := range computeDueVaccines(schedule, baby, vaccinations, now) {
+		got[due.Vaccine+"-"+strconv.Itoa(due.Dose)] = due.Status
+	}
+	want := map[string]string{
+		"dtap-1": VaccineOverdue, // due at 2 months
+		"dtap-3": VaccineDue,     // due at 6 months
+		"mmr-1":  "",             // 12 months is more than two away
+		"hepb-1": "",             // covered by the unnumbered shot
+		"hepb-2": "",             // recorded
+		"dtap-2": "",             // recorded
+	}
+	for key, status := range want {
+		if got[key] != status {
+			t.Errorf("%s = %q, want %q", key, got[key], status)
+		}
+	}
+
+	tenMonths := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+	for _, due := range computeDueVaccines(schedule, baby, vaccinations, tenMonths) {
+		if due.Vaccine == "mmr" && due.Status != VaccineUpcoming {
+			t.Errorf("MMR at ten months is %q, want upcoming", due.Status)
+		}
+	}
+
+	baby.DateOfDeath = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	baby.InMemoriam = true
+	if due := computeDueVaccines(schedule, baby, nil, now); len(due) != 0 {
+		t.Errorf("%d doses due for a child who has died", len(due))
+	}
+}
@@ -0,0 +1,565 @@
+// Short video clips, kept alongside photos.
+//
+// A clip is an Image with MediaKind "video". Making it a kind of photo rather
+// than a record of its own is what gives it everything photos already have —
+// people tags, tags, comments, milestone and activity joins, the family
+// timeline, deletion cleanup and the export bundle — without a second copy of
+// each. The differences are kept to three places: the upload, the processing
+// job, and serving.
+//
+// Only MP4 and QuickTime are accepted. That is what phones record, both are
+// the ISO box format heic.go already reads, and it means duration and size come
+// from the file's own header in pure Go. WebM and the rest would each need a
+// parser of their own for a case nobody has asked for.
+//
+// The still a grid shows for a clip is its poster frame, and pulling a frame
+// out of H.264 or HEVC needs a real decoder. That is ffmpeg, found on PATH at
+// the moment a clip is processed. It is an optional dependency in the sense of
+// docs/degraded-dependencies.md: without it a clip has no poster, serves a
+// placeholder in the grid, and plays exactly as well as it otherwise would.
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"family/cfg"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+// An Image's MediaKind. Rows written before videos existed read back as photos.
+const (
+	MediaKindPhoto = "photo"
+	MediaKindVideo = "video"
+)
+
+const (
+	// maxVideoFileSize is the largest clip an upload takes: about a minute of
+	// 4K or three of 1080p from a current phone.
+	maxVideoFileSize = 200 << 20
+
+	// maxVideoDuration keeps "short" honest. A recital is a few minutes; a
+	// whole school concert belongs somewhere built for long video.
+	maxVideoDuration = 5 * time.Minute
+
+	// maxMovieBoxSize bounds the header readVideoInfo loads into memory. A
+	// five-minute clip's sample tables are a few megabytes at most.
+	maxMovieBoxSize = 32 << 20
+
+	// posterFrameTimeout bounds one ffmpeg run. Seeking to a keyframe and
+	// decoding one frame takes well under a second; this only matters when
+	// something is wrong with the file or the machine.
+	posterFrameTimeout = 60 * time.Second
+)
+
+var (
+	ErrNoVideoTrack        = errors.New("That file has no video in it")
+	ErrPosterUnavailable   = errors.New("ffmpeg is not installed, so clips get no poster frame")
+	errMovieBoxMissing     = errors.New("No movie header found")
+	errMovieBoxTooLarge    = errors.New("Movie header is too large")
+	errMovieTimescaleEmpty = errors.New("Movie header has no timescale")
+)
+
+func isValidVideoType(mimeType string) bool {
+	switch mimeType {
+	case "video/mp4", "video/quicktime", "video/x-m4v":
+		return true
+	}
+	return false
+}
+
+// videoExtension is the file extension a clip is stored under. It comes from
+// the type rather than the uploaded name, which may have none.
+func videoExtension(mimeType string) string {
+	if mimeType == "video/quicktime" {
+		return ".mov"
+	}
+	return ".mp4"
+}
+
+// sniffVideoMimeType recognises an MP4 or QuickTime upload from its first box,
+// for the browsers that send clips as application/octet-stream. HEIF files
+// share the box format, so their brands are turned away here.
+func sniffVideoMimeType(file io.ReadSeeker) string {
+	file.Seek(0, io.SeekStart)
+	defer file.Seek(0, io.SeekStart)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return ""
+	}
+	if string(header[4:8]) != "ftyp" || isHEIF(header) {
+		return ""
+	}
+	switch brand := string(header[8:12]); {
+	case brand == "qt  ":
+		return "video/quicktime"
+	case brand == "M4V " || brand == "M4VH" || brand == "M4VP":
+		return "video/x-m4v"
+	case strings.HasPrefix(brand, "iso"), strings.HasPrefix(brand, "mp4"),
+		strings.HasPrefix(brand, "3gp"), brand == "avc1", brand == "MSNV", brand == "dash":
+		return "video/mp4"
+	}
+	return ""
+}
+
+// videoInfo is what a clip's movie header says about it.
+type videoInfo struct {
+	Duration time.Duration
+	// Width and Height are as displayed: a portrait phone clip is stored
+	// landscape with a rotation, and these are already turned.
+	Width  int
+	Height int
+	// CreationTime is when the camera says it started recording, or zero.
+	// It is the video counterpart of a photo's EXIF date.
+	CreationTime time.Time
+}
+
+// readVideoInfo finds the moov box and reads the clip's duration, size and
+// recording time out of it. The box can come before or after the media data —
+// cameras usually write it last — so the file is walked box by box with seeks,
+// and only the header itself is ever read into memory.
+func readVideoInfo(file io.ReadSeeker) (videoInfo, error) {
+	file.Seek(0, io.SeekStart)
+	defer file.Seek(0, io.SeekStart)
+	for {
+		var header [16]byte
+		if _, err := io.ReadFull(file, header[:8]); err != nil {
+			if err == io.EOF {
+				return videoInfo{}, errMovieBoxMissing
+			}
+			return videoInfo{}, err
+		}
+		size := uint64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := uint64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(file, header[8:16]); err != nil {
+				return videoInfo{}, err
+			}
+			size = binary.BigEndian.Uint64(header[8:16])
+			headerSize = 16
+		}
+		if size == 0 && boxType != "moov" {
+			// Runs to the end of the file, so there is nothing after it.
+			return videoInfo{}, errMovieBoxMissing
+		}
+		if size != 0 && size < headerSize {
+			return videoInfo{}, errISOBoxTruncated
+		}
+
+		if boxType == "moov" {
+			limit := int64(size - headerSize)
+			if size == 0 {
+				limit = maxMovieBoxSize + 1
+			}
+			if limit > maxMovieBoxSize {
+				return videoInfo{}, errMovieBoxTooLarge
+			}
+			moov, err := io.ReadAll(io.LimitReader(file, limit))
+			if err != nil {
+				return videoInfo{}, err
+			}
+			if size != 0 && int64(len(moov)) < limit {
+				return videoInfo{}, errISOBoxTruncated
+			}
+			return parseMovieBox(moov)
+		}
+		if _, err := file.Seek(int64(size-headerSize), io.SeekCurrent); err != nil {
+			return videoInfo{}, err
+		}
+	}
+}
+
+// movieEpoch is where QuickTime and MP4 timestamps count from.
+var movieEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func parseMovieBox(moov []byte) (info videoInfo, err error) {
+	boxes, err := readISOBoxes(moov)
+	if err != nil {
+		return info, err
+	}
+	foundVideo := false
+	for _, box := range boxes {
+		switch box.Type {
+		case "mvhd":
+			r := isoReader{data: box.Payload}
+			version := r.uint(1)
+			r.uint(3)
+			var created, timescale, duration uint64
+			if version == 1 {
+				created = r.uint(8)
+				r.skip(8)
+				timescale = r.uint(4)
+				duration = r.uint(8)
+			} else {
+				created = r.uint(4)
+				r.skip(4)
+				timescale = r.uint(4)
+				duration = r.uint(4)
+			}
+			if r.failed {
+				return info, errISOBoxTruncated
+			}
+			if timescale == 0 {
+				return info, errMovieTimescaleEmpty
+			}
+			info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+			// Cameras with no clock write zero, which lands in 1904; anything
+			// before 2000 is treated the same way.
+			if recorded := movieEpoch.Add(time.Duration(created) * time.Second); recorded.Year() >= 2000 {
+				info.CreationTime = recorded
+			}
+		case "trak":
+			children, err := readISOBoxes(box.Payload)
+			if err != nil {
+				return info, err
+			}
+			for _, child := range children {
+				if child.Type != "tkhd" || foundVideo {
+					continue
+				}
+				width, height, ok := trackDimensions(child.Payload)
+				if ok && width > 0 && height > 0 {
+					info.Width, info.Height = width, height
+					foundVideo = true
+				}
+			}
+		}
+	}
+	if !foundVideo {
+		return info, ErrNoVideoTrack
+	}
+	return info, nil
+}
+
+// trackDimensions reads a tkhd box's display size. Audio tracks report zero.
+// A phone held upright records landscape and sets the track matrix to a
+// quarter turn, which is recognised by its first coefficient being zero.
+func trackDimensions(tkhd []byte) (width int, height int, ok bool) {
+	r := isoReader{data: tkhd}
+	version := r.uint(1)
+	r.uint(3)
+	// Times, track id and duration, then reserved, layer, alternate group,
+	// volume and reserved again.
+	if version == 1 {
+		r.skip(8 + 8 + 4 + 4 + 8)
+	} else {
+		r.skip(4 + 4 + 4 + 4 + 4)
+	}
+	r.skip(8 + 2 + 2 + 2 + 2)
+	var matrix [9]int32
+	for i := range matrix {
+		matrix[i] = int32(r.uint(4))
+	}
+	width = int(r.uint(4) >> 16)
+	height = int(r.uint(4) >> 16)
+	if r.failed {
+		return 0, 0, false
+	}
+	if matrix[0] == 0 && matrix[1] != 0 {
+		width, height = height, width
+	}
+	return width, height, true
+}
+
+// posterFrameOffset is where in a clip the poster is taken from. The very
+// first frame is often black or mid-fade, so a second in, or halfway through
+// a clip shorter than two seconds.
+func posterFrameOffset(duration time.Duration) time.Duration {
+	if duration < 2*time.Second {
+		return duration / 2
+	}
+	return time.Second
+}
+
+// extractPosterFrame asks ffmpeg for one JPEG frame of the clip at videoPath.
+// ffmpeg applies the clip's rotation itself, so the frame comes out the way
+// up the clip plays.
+func extractPosterFrame(videoPath string, at time.Duration) ([]byte, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, ErrPosterUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), posterFrameTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-nostdin", "-v", "error",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", videoPath,
+		"-frames:v", "1", "-f", "image2pipe", "-c:v", "mjpeg", "-q:v", "2",
+		"pipe:1",
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("ffmpeg produced no frame")
+	}
+	return stdout.Bytes(), nil
+}
+
+// processVideoPoster makes the still variants for a clip from its poster
+// frame, for the photo worker to save in place of a photo's. It never fails
+// the clip: with no poster the variants are simply absent, and serving falls
+// back to a placeholder. The size returned is always the clip's own.
+func processVideoPoster(job PhotoProcessingJob) (map[string][]byte, int, int) {
+	originalPath := filepath.Join(cfg.StaticDir, originalMediaPath(job.FilePath))
+	poster, err := extractPosterFrame(originalPath, posterFrameOffset(job.Duration))
+	if err == nil {
+		var variants map[string][]byte
+		variants, _, _, err = ProcessAndSaveMultipleSizes(poster, "image/jpeg")
+		if err == nil {
+			return variants, job.OriginalWidth, job.OriginalHeight
+		}
+	}
+	if errors.Is(err, ErrPosterUnavailable) {
+		log.Printf("[PHOTO_PROCESSING] No poster frame for video %d: %v", job.ImageId, err)
+	} else {
+		LogErrorSimple(LogCategoryWorker, "Failed to make a video poster frame", map[string]interface{}{
+			"photoId": job.ImageId,
+			"error":   err.Error(),
+		})
+	}
+	return map[string][]byte{}, job.OriginalWidth, job.OriginalHeight
+}
+
+// originalMediaPath is where an upload's original file sits, relative to the
+// static directory, for a record whose FilePath is filePath.
+func originalMediaPath(filePath string) string {
+	ext := filepath.Ext(filePath)
+	return strings.TrimSuffix(filePath, ext) + "_original" + ext
+}
+
+func generateDefaultVideoTitle(originalFilename string, recordedAt time.Time) string {
+	if !recordedAt.IsZero() {
+		return fmt.Sprintf("Video from %s", recordedAt.Format("Jan 2, 2006"))
+	}
+	return strings.TrimSuffix(originalFilename, filepath.Ext(originalFilename))
+}
+
+// uploadVideoHandler takes a clip. It reads the same form as a photo upload,
+// with the file under "video", and answers the same way. The clip is copied
+// to disk rather than read into memory, and the processing job carries its
+// path rather than its bytes — a queue of two-hundred-megabyte jobs would be
+// the server's whole memory.
+func uploadVideoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.ContentLength > maxVideoRequestBytes {
+		RespondFileTooLargeError(w, r, "200MB")
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		RespondValidationError(w, r, "That upload could not be read. Please try again.", err.Error())
+		return
+	}
+
+	user, ok := GetUserFromContext(r)
+	if !ok {
+		RespondAuthError(w, r, "Authentication required")
+		return
+	}
+
+	form, formErr := readMediaUploadForm(r)
+	if formErr != nil {
+		RespondWithError(w, r, formErr, statusForErrorCode(formErr.Code))
+		return
+	}
+
+	file, fileHeader, err := r.FormFile("video")
+	if err != nil {
+		RespondValidationError(w, r, "Choose a video to upload.", err.Error())
+		return
+	}
+	defer file.Close()
+
+	if fileHeader.Size > maxVideoFileSize {
+		RespondFileTooLargeError(w, r, "200MB")
+		return
+	}
+
+	mimeType := fileHeader.Header.Get("Content-Type")
+	if !isValidVideoType(mimeType) {
+		mimeType = sniffVideoMimeType(file)
+	}
+	if !isValidVideoType(mimeType) {
+		RespondInvalidFileTypeError(w, r, "MP4, MOV")
+		return
+	}
+
+	info, err := readVideoInfo(file)
+	if err != nil {
+		RespondValidationError(w, r, "That file could not be read as a video. Try an MP4 or MOV.", err.Error())
+		return
+	}
+	if info.Duration > maxVideoDuration {
+		RespondValidationError(w, r, "Videos can be up to 5 minutes long.")
+		return
+	}
+
+	// A clip's recording time plays the part a photo's EXIF date does when
+	// the date is left to the file.
+	if form.InputType == "auto" && !info.CreationTime.IsZero() {
+		form.InputType = "date"
+		form.PhotoDate = info.CreationTime.Local().Format("2006-01-02")
+	}
+
+	var video Image
+	var uploadErr *AppError
+	vbolt.WithWriteTx(appDb, func(tx *vbolt.Tx) {
+		familyId, err := ResolveActingFamily(tx, user, form.FamilyId, AccessContribute)
+		if err != nil {
+			uploadErr = NewAppError(ErrCodeForbidden, "You cannot add videos to that family.", err.Error())
+			return
+		}
+		people, appErr := taggedPeopleForUpload(tx, familyId, form.PersonIds)
+		if appErr != nil {
+			uploadErr = appErr
+			return
+		}
+
+		var referencePerson Person
+		if len(people) > 0 {
+			referencePerson = people[0]
+		}
+		recordedAt, precision, err := calculatePhotoDateAt(form.InputType, form.PhotoDate, form.PhotoDatePrecision, form.AgeYears, form.AgeMonths, referencePerson, nil)
+		if err != nil {
+			uploadErr = NewAppError(ErrCodeValidation, "That video date could not be worked out. Check the date or age you entered.", err.Error())
+			return
+		}
+		title := form.Title
+		if title == "" {
+			title = generateDefaultVideoTitle(fileHeader.Filename, recordedAt)
+		}
+
+		uniqueFilename, err := generateUniqueFilename(videoExtension(mimeType))
+		if err != nil {
+			uploadErr = NewAppError(ErrCodeInternal, unexpectedErrorMessage, err.Error())
+			return
+		}
+		filePath := "photos/" + uniqueFilename
+		originalPath := filepath.Join(cfg.StaticDir, originalMediaPath(filePath))
+		if err := writeUploadedFile(file, originalPath); err != nil {
+			uploadErr = NewAppError(ErrCodeInternal, unexpectedErrorMessage, err.Error())
+			return
+		}
+
+		video = Image{
+			Id:                 vbolt.NextIntId(tx, ImagesBkt),
+			FamilyId:           familyId,
+			OwnerUserId:        user.Id,
+			OriginalFilename:   fileHeader.Filename,
+			MimeType:           mimeType,
+			FileSize:           int(fileHeader.Size),
+			Width:              info.Width,
+			Height:             info.Height,
+			FilePath:           filePath,
+			Title:              title,
+			Description:        form.Description,
+			PhotoDate:          recordedAt,
+			CreatedAt:          time.Now(),
+			Status:             1, // Processing
+			PhotoDatePrecision: precision,
+			MediaKind:          MediaKindVideo,
+			DurationMs:         int(info.Duration / time.Millisecond),
+		}
+		vbolt.Write(tx, ImagesBkt, video.Id, &video)
+		vbolt.SetTargetSingleTerm(tx, ImageByFamilyIndex, video.Id, familyId)
+		for _, person := range people {
+			AddPersonToPhoto(tx, video.Id, person.Id, familyId)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	if uploadErr != nil {
+		RespondWithError(w, r, uploadErr, statusForErrorCode(uploadErr.Code))
+		return
+	}
+	if video.Id == 0 {
+		RespondUnexpectedError(w, r, errors.New("video upload transaction produced no record"))
+		return
+	}
+
+	job := PhotoProcessingJob{
+		ImageId:        video.Id,
+		FamilyId:       video.FamilyId,
+		FilePath:       video.FilePath,
+		MimeType:       mimeType,
+		OriginalWidth:  video.Width,
+		OriginalHeight: video.Height,
+		MediaKind:      MediaKindVideo,
+		Duration:       info.Duration,
+	}
+	if err := QueuePhotoProcessing(job); err != nil {
+		log.Printf("Failed to queue video %d for processing: %v", video.Id, err)
+		markPhotoFailed(video.Id)
+		RespondUnavailableError(w, r,
+			"The video could not be processed right now. Please try again in a few minutes.",
+			err.Error())
+		return
+	}
+
+	LogInfoWithRequest(r, LogCategoryPhoto, "Video upload completed", map[string]interface{}{
+		"userId":     user.Id,
+		"photoId":    video.Id,
+		"fileSize":   fileHeader.Size,
+		"mimeType":   mimeType,
+		"durationMs": video.DurationMs,
+	})
+
+	video.TagIds = []int{}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AddPhotoResponse{Image: video})
+}
+
+// writeUploadedFile copies an upload to path, creating its directory.
+func writeUploadedFile(file io.ReadSeeker, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	return out.Close()
+}
+
+// serveVideoPlaceholder stands in for a clip's poster when it has none.
+func serveVideoPlaceholder(w http.ResponseWriter, r *http.Request) {
+	svgContent := `<svg width="400" height="300" xmlns="http://www.w3.org/2000/svg">
+		<rect width="100%" height="100%" fill="#2b2b2b"/>
+		<circle cx="200" cy="150" r="44" fill="#ffffff" fill-opacity="0.85"/>
+		<polygon points="186,126 186,174 226,150" fill="#2b2b2b"/>
+	</svg>`
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Write([]byte(svgContent))
+}
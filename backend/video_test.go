@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"family/cfg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+func testTrackHeader(width, height int, rotated bool) []byte {
+	tkhd := make([]byte, 4+20+8+8)
+	matrix := [9]int32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}
+	if rotated {
+		matrix[0], matrix[1], matrix[3], matrix[4] = 0, 0x10000, -0x10000, 0
+	}
+	for _, value := range matrix {
+		tkhd = binary.BigEndian.AppendUint32(tkhd, uint32(value))
+	}
+	tkhd = binary.BigEndian.AppendUint32(tkhd, uint32(width)<<16)
+	tkhd = binary.BigEndian.AppendUint32(tkhd, uint32(height)<<16)
+	return testISOBox("trak", testISOBox("tkhd", tkhd))
+}
+
+// buildTestVideo lays a clip out the way a camera does: the media data first
+// and the movie header after it, with an audio track ahead of the video one.
+func buildTestVideo(brand string, recorded time.Time, duration time.Duration, videoTrack []byte) []byte {
+	const timescale = 600
+	mvhd := []byte{0, 0, 0, 0}
+	mvhd = binary.BigEndian.AppendUint32(mvhd, uint32(recorded.Sub(movieEpoch)/time.Second))
+	mvhd = binary.BigEndian.AppendUint32(mvhd, 0)
+	mvhd = binary.BigEndian.AppendUint32(mvhd, timescale)
+	mvhd = binary.BigEndian.AppendUint32(mvhd, uint32(duration.Seconds()*timescale))
+	mvhd = append(mvhd, make([]byte, 80)...)
+
+	return bytes.Join([][]byte{
+		testISOBox("ftyp", []byte(brand), make([]byte, 4), []byte("isom")),
+		testISOBox("mdat", bytes.Repeat([]byte{0xAB}, 4096)),
+		testISOBox("moov", testISOBox("mvhd", mvhd), testTrackHeader(0, 0, false), videoTrack),
+	}, nil)
+}
+
+func TestReadVideoInfo(t *testing.T) {
+	recorded := time.Date(2024, 5, 17, 9, 30, 0, 0, time.UTC)
+	clip := buildTestVideo("qt  ", recorded, 12500*time.Millisecond, testTrackHeader(1920, 1080, true))
+
+	info, err := readVideoInfo(bytes.NewReader(clip))
+	if err != nil {
+		t.Fatalf("readVideoInfo() error = %v", err)
+	}
+	if info.Duration != 12500*time.Millisecond {
+		t.Errorf("Duration = %v, want 12.5s", info.Duration)
+	}
+	// Recorded upright: stored landscape, displayed portrait.
+	if info.Width != 1080 || info.Height != 1920 {
+		t.Errorf("size = %dx%d, want 1080x1920", info.Width, info.Height)
+	}
+	if !info.CreationTime.Equal(recorded) {
+		t.Errorf("CreationTime = %v, want %v", info.CreationTime, recorded)
+	}
+
+	// A camera with no clock writes zero, which is no date at all.
+	info, err = readVideoInfo(bytes.NewReader(buildTestVideo("isom", movieEpoch, time.Second, testTrackHeader(640, 480, false))))
+	if err != nil || !info.CreationTime.IsZero() || info.Width != 640 {
+		t.Errorf("clockless clip = %+v, %v", info, err)
+	}
+
+	if _, err := readVideoInfo(bytes.NewReader(buildTestVideo("isom", recorded, time.Second, nil))); err != ErrNoVideoTrack {
+		t.Errorf("audio-only clip: error = %v, want ErrNoVideoTrack", err)
+	}
+	if _, err := readVideoInfo(bytes.NewReader(clip[:len(clip)-20])); err == nil {
+		t.Error("read a truncated movie header")
+	}
+	if _, err := readVideoInfo(bytes.NewReader(createTestImage(4, 4))); err == nil {
+		t.Error("read a PNG as a video")
+	}
+}
+
+func TestSniffVideoMimeType(t *testing.T) {
+	cases := map[string]string{
+		"qt  ": "video/quicktime",
+		"isom": "video/mp4",
+		"mp42": "video/mp4",
+		"M4V ": "video/x-m4v",
+		"heic": "",
+		"avif": "",
+	}
+	for brand, want := range cases {
+		clip := buildTestVideo(brand, time.Now(), time.Second, testTrackHeader(640, 480, false))
+		if got := sniffVideoMimeType(bytes.NewReader(clip)); got != want {
+			t.Errorf("brand %q sniffed as %q, want %q", brand, got, want)
+		}
+	}
+	if got := sniffVideoMimeType(bytes.NewReader(createTestImage(4, 4))); got != "" {
+		t.Errorf("a PNG sniffed as %q", got)
+	}
+}
+
+func TestPosterFrameOffset(t *testing.T) {
+	if got := posterFrameOffset(30 * time.Second); got != time.Second {
+		t.Errorf("posterFrameOffset(30s) = %v", got)
+	}
+	if got := posterFrameOffset(time.Second); got != 500*time.Millisecond {
+		t.Errorf("posterFrameOffset(1s) = %v", got)
+	}
+}
+
+func TestServeVideo(t *testing.T) {
+	fx, cleanup := setupFamilyLinkFixture(t)
+	defer cleanup()
+	appDb = fx.db
+
+	clip := buildTestVideo("isom", time.Now(), 3*time.Second, testTrackHeader(640, 480, false))
+	filePath := "photos/test-clip-" + strconv.FormatInt(time.Now().UnixNano(), 36) + ".mp4"
+	originalPath := filepath.Join(cfg.StaticDir, originalMediaPath(filePath))
+	if err := os.MkdirAll(filepath.Dir(originalPath), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(originalPath, clip, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var video, photo Image
+	vbolt.WithWriteTx(fx.db, func(tx *vbolt.Tx) {
+		video = Image{
+			Id: vbolt.NextIntId(tx, ImagesBkt), FamilyId: fx.famA, OwnerUserId: fx.userA.Id,
+			MimeType: "video/mp4", FilePath: filePath, CreatedAt: time.Now(),
+			MediaKind: MediaKindVideo, DurationMs: 3000,
+		}
+		vbolt.Write(tx, ImagesBkt, video.Id, &video)
+		vbolt.SetTargetSingleTerm(tx, ImageByFamilyIndex, video.Id, fx.famA)
+		photo = writeTestImage(tx, fx.famA, fx.userA.Id, "still.jpg")
+		vbolt.TxCommit(tx)
+	})
+	t.Cleanup(func() { deletePhotoFiles(video) })
+
+	vbolt.WithReadTx(fx.db, func(tx *vbolt.Tx) {
+		if stored := GetImageById(tx, video.Id); stored.MediaKind != MediaKindVideo || stored.DurationMs != 3000 {
+			t.Errorf("stored video = %+v", stored)
+		}
+		exported := buildPhotoExportMetadata(tx, fx.famA)
+		for _, ep := range exported {
+			if ep.Id == video.Id && (ep.MediaKind != MediaKindVideo || ep.DurationMs != 3000) {
+				t.Errorf("exported video = %+v", ep)
+			}
+			if ep.Id == photo.Id && ep.MediaKind != "" {
+				t.Errorf("exported photo has media kind %q", ep.MediaKind)
+			}
+		}
+	})
+
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req = req.WithContext(context.WithValue(req.Context(), UserContextKey, fx.userA))
+		recorder := httptest.NewRecorder()
+		servePhotoHandler(recorder, req)
+		return recorder
+	}
+
+	// A player asks for the clip a piece at a time.
+	resp := serve("/api/photo/"+strconv.Itoa(video.Id)+"/video", http.Header{"Range": {"bytes=0-99"}})
+	if resp.Code != http.StatusPartialContent {
+		t.Fatalf("ranged request status = %d, want 206", resp.Code)
+	}
+	if got := resp.Header().Get("Content-Type"); got != "video/mp4" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if !bytes.Equal(resp.Body.Bytes(), clip[:100]) {
+		t.Errorf("ranged body is %d bytes, not the first 100 of the clip", resp.Body.Len())
+	}
+
+	// With no poster frame the grid gets a placeholder, never the clip.
+	resp = serve("/api/photo/"+strconv.Itoa(video.Id)+"/thumb", nil)
+	if resp.Code != http.StatusOK || !strings.HasPrefix(resp.Header().Get("Content-Type"), "image/svg") {
+		t.Errorf("poster without a frame: status %d, type %q", resp.Code, resp.Header().Get("Content-Type"))
+	}
+
+	if resp := serve("/api/photo/"+strconv.Itoa(photo.Id)+"/video", nil); resp.Code != http.StatusNotFound {
+		t.Errorf("a photo's video variant: status %d, want 404", resp.Code)
+	}
+}
@@ -67,6 +67,12 @@ const (
 	WSMsgTypeUserOffline   = "user_offline"
 	WSMsgTypeHeartbeat     = "heartbeat"
 	WSMsgTypeError         = "error"
+
+	// Comment threads on milestones and photos. These go to the users who can
+	// see the record, not to a family room.
+	WSMsgTypeComment         = "comment"
+	WSMsgTypeDeleteComment   = "delete_comment"
+	WSMsgTypeReactionChanged = "reaction_changed"
 )
 
 // WebSocket message structure
@@ -87,6 +93,24 @@ type WSDeleteMessagePayload struct {
 	UserId    int `json:"userId"`
 }
 
+// Message payload for a comment added or edited. The client tells the two apart
+// by whether it already holds the comment's id.
+type WSCommentPayload struct {
+	Comment Comment `json:"comment"`
+}
+
+// Message payload for a deleted comment
+type WSDeleteCommentPayload struct {
+	Target    CommentTarget `json:"target"`
+	CommentId int           `json:"commentId"`
+}
+
+// Message payload for a record's reactions, sent whole after any change
+type WSReactionsPayload struct {
+	Target    CommentTarget     `json:"target"`
+	Reactions []ReactionSummary `json:"reactions"`
+}
+
 // Message payload for typing indicator
 type WSTypingPayload struct {
 	UserId   int    `json:"userId"`
@@ -138,9 +162,12 @@ type ChatHub struct {
 	closing atomic.Bool
 }
 
-// BroadcastMessage contains a message and target family
+// BroadcastMessage contains a message and its recipients: every client in
+// FamilyId, or, when UserIds is set, those users' clients in whichever family
+// they are connected under.
 type BroadcastMessage struct {
 	FamilyId int
+	UserIds  []int
 	Message  WSMessage
 }
 
@@ -278,7 +305,11 @@ func (h *ChatHub) run() {
 			h.unregisterClient(client)
 
 		case message := <-h.broadcast:
-			h.broadcastToFamily(message.FamilyId, message.Message)
+			if message.UserIds != nil {
+				h.broadcastToUsers(message.UserIds, message.Message)
+			} else {
+				h.broadcastToFamily(message.FamilyId, message.Message)
+			}
 		}
 	}
 }
@@ -432,6 +463,54 @@ func (h *ChatHub) broadcastToFamily(familyId int, message WSMessage) {
 
 }
 
+// broadcastToUsers sends a message to every connection held by these users.
+//
+// Connections are filed by the user's primary family, and a user may be
+// connected from several devices, so this walks every family's clients rather
+// than looking one up. A blocked client is left for the family broadcast and
+// the heartbeat to clean up; skipping it here just drops one live update.
+func (h *ChatHub) broadcastToUsers(userIds []int, message WSMessage) {
+	wanted := make(map[int]bool, len(userIds))
+	for _, userId := range userIds {
+		wanted[userId] = true
+	}
+
+	h.mu.RLock()
+	var clientList []*Client
+	for _, clients := range h.families {
+		for client := range clients {
+			if wanted[client.userId] {
+				clientList = append(clientList, client)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(clientList) == 0 {
+		return
+	}
+
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		LogErrorSimple(LogCategoryAPI, "Failed to marshal WebSocket message", map[string]interface{}{
+			"messageType": message.Type,
+			"error":       err.Error(),
+		})
+		return
+	}
+
+	for _, client := range clientList {
+		select {
+		case client.send <- messageBytes:
+		default:
+			LogWarn(LogCategoryAPI, "Client send channel blocked, dropping message", map[string]interface{}{
+				"userId":      client.userId,
+				"messageType": message.Type,
+			})
+		}
+	}
+}
+
 // cleanupFailedClients removes clients that failed to receive messages
 func (h *ChatHub) cleanupFailedClients(familyId int, failedClients []*Client) {
 	h.mu.Lock()
@@ -523,6 +602,52 @@ func (h *ChatHub) BroadcastDeleteMessage(familyId int, messageId int, userId int
 	}
 }
 
+// broadcastToAudience queues a message for a list of users. An empty audience
+// sends nothing, rather than falling back to a family.
+func (h *ChatHub) broadcastToAudience(userIds []int, wsMessage WSMessage) {
+	if h == nil || len(userIds) == 0 {
+		return
+	}
+
+	select {
+	case h.broadcast <- BroadcastMessage{UserIds: userIds, Message: wsMessage}:
+	default:
+		LogWarn(LogCategoryAPI, "WebSocket broadcast channel full", map[string]interface{}{
+			"recipients":  len(userIds),
+			"messageType": wsMessage.Type,
+		})
+	}
+}
+
+// BroadcastComment sends a new or edited comment to the users who can see its
+// record
+func (h *ChatHub) BroadcastComment(userIds []int, comment Comment) {
+	h.broadcastToAudience(userIds, WSMessage{
+		Type:      WSMsgTypeComment,
+		Payload:   WSCommentPayload{Comment: comment},
+		Timestamp: time.Now(),
+	})
+}
+
+// BroadcastDeleteComment tells the users who can see a record that one of its
+// comments is gone
+func (h *ChatHub) BroadcastDeleteComment(userIds []int, target CommentTarget, commentId int) {
+	h.broadcastToAudience(userIds, WSMessage{
+		Type:      WSMsgTypeDeleteComment,
+		Payload:   WSDeleteCommentPayload{Target: target, CommentId: commentId},
+		Timestamp: time.Now(),
+	})
+}
+
+// BroadcastReactions sends a record's reactions after one of them changed
+func (h *ChatHub) BroadcastReactions(userIds []int, target CommentTarget, reactions []ReactionSummary) {
+	h.broadcastToAudience(userIds, WSMessage{
+		Type:      WSMsgTypeReactionChanged,
+		Payload:   WSReactionsPayload{Target: target, Reactions: reactions},
+		Timestamp: time.Now(),
+	})
+}
+
 // heartbeatChecker periodically checks for stale connections
 func (h *ChatHub) heartbeatChecker() {
 	ticker := time.NewTicker(30 * time.Second)
@@ -3,7 +3,12 @@ import { JSX } from "preact";
 import * as vlens from "vlens";
 import * as server from "../../server";
 import "./chart.styles";
-import { ageInMonths, computePercentileLabel, isValidBirthday } from "../../lib/growthPercentiles";
+import {
+  ageInMonths,
+  computePercentileLabel,
+  isValidBirthday,
+  percentileTypeFor,
+} from "../../lib/growthPercentiles";
 
 export interface GrowthChartProps {
   growthData: server.GrowthData[];
@@ -194,9 +199,9 @@ export const GrowthChart = ({
   if (selected.key && birthdayMs !== null) {
     const allData = [...heightData, ...weightData];
     const d = allData.find(x => x.id === selected.key!.id);
-    if (d) {
+    const type = d ? percentileTypeFor(d.measurementType) : null;
+    if (d && type) {
       const ageMonths = ageInMonths(birthday!, d.measurementDate);
-      const type = d.measurementType === server.Height ? "height" : "weight";
       selectedPercentileLabel = computePercentileLabel(d.value, d.unit, ageMonths, gender, type);
     }
   }
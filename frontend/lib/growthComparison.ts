@@ -125,24 +125,29 @@ function formatDurationMonths(absMonths: number): string {
   return `${yrs} yr ${mos} mo`;
 }
 
+/** The noun for a measurement type and the words for more and less of it. */
+function comparisonWords(measurementType: server.MeasurementType): [string, string, string] {
+  switch (measurementType) {
+    case server.Height:
+      return ["height", "taller", "shorter"];
+    case server.HeadCircumference:
+      return ["head size", "bigger", "smaller"];
+    default:
+      return ["weight", "heavier", "lighter"];
+  }
+}
+
 /** Describes how a comparison point's value differs from the target's, e.g. "you were 3 in taller at this age". */
 export function describeValueComparison(
   point: ComparisonPoint,
   measurementType: server.MeasurementType
 ): string {
-  const noun = measurementType === server.Height ? "height" : "weight";
+  const [noun, more, less] = comparisonWords(measurementType);
   if (roundTo1Decimal(Math.abs(point.valueDiff)) === 0) {
     return `you were about the same ${noun} at this age`;
   }
   const magnitude = formatValueDiff(point.valueDiff, point.unit);
-  const comparative =
-    measurementType === server.Height
-      ? point.valueDiff > 0
-        ? "taller"
-        : "shorter"
-      : point.valueDiff > 0
-        ? "heavier"
-        : "lighter";
+  const comparative = point.valueDiff > 0 ? more : less;
   return `you were ${magnitude} ${comparative} at this age`;
 }
 
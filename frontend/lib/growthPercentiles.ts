@@ -1,3 +1,5 @@
+import * as server from "../server";
+
 // WHO 2006 Child Growth Standards (0-24 months) and CDC NCHS 2000 Growth Charts (2-20 years)
 // Percentiles: 3rd, 15th, 50th, 85th, 97th
 // Height in cm, Weight in kg
@@ -323,6 +325,21 @@ function ordinalSuffix(n: number): string {
   return "th";
 }
 
+/**
+ * The table a measurement type is ranked against. There is no head
+ * circumference table here, so those rows get no label rather than a weight one.
+ */
+export function percentileTypeFor(type: server.MeasurementType): "height" | "weight" | null {
+  switch (type) {
+    case server.Height:
+      return "height";
+    case server.Weight:
+      return "weight";
+    default:
+      return null;
+  }
+}
+
 /**
  * Returns a human-readable percentile label like "~75th %ile", "<3rd %ile", or "~99.2th %ile".
  * Returns null if the age is out of range (>240 months / >20 years) or birthday is not provided.
@@ -32,5 +32,12 @@ export function getCategoryLabel(category: string): string {
  * Get the display label for a measurement type
  */
 export function getMeasurementTypeLabel(type: server.MeasurementType): string {
-  return type === server.Height ? "Height" : "Weight";
+  switch (type) {
+    case server.Height:
+      return "Height";
+    case server.HeadCircumference:
+      return "Head circumference";
+    default:
+      return "Weight";
+  }
 }
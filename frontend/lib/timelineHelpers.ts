@@ -1,4 +1,5 @@
 import * as server from "../server";
+import { getMeasurementTypeLabel } from "./milestoneHelpers";
 
 /**
  * Extract numeric age in years from an age string
@@ -54,7 +55,7 @@ export async function handleDeleteGrowthData(
   value: number,
   unit: string
 ): Promise<void> {
-  const typeLabel = type === server.Height ? "Height" : "Weight";
+  const typeLabel = getMeasurementTypeLabel(type);
   const confirmed = confirm(
     `Are you sure you want to delete this ${typeLabel.toLowerCase()} measurement of ${value} ${unit}?`
   );
@@ -6,7 +6,7 @@ import { splitPeopleByType } from "../../lib/routeHelpers";
 
 type GrowthFormData = {
   selectedPersonId: string;
-  measurementType: string; // 'height' | 'weight'
+  measurementType: string; // 'height' | 'weight' | 'head_circumference'
   value: string;
   unit: string; // cm, in, kg, lbs
   heightInputMode: string; // 'decimal' | 'feet-inches'
@@ -20,15 +20,28 @@ type GrowthFormData = {
   loading: boolean;
 };
 
+// The request's name for each stored type, so an edit keeps the type it opened with.
+const measurementTypeKey = (type: server.MeasurementType | undefined): string => {
+  switch (type) {
+    case server.Weight:
+      return "weight";
+    case server.HeadCircumference:
+      return "head_circumference";
+    default:
+      return "height";
+  }
+};
+
+const measurementTypeLabels: Record<string, string> = {
+  height: "Height",
+  weight: "Weight",
+  head_circumference: "Head circumference",
+};
+
 const useGrowthForm = vlens.declareHook(
   (mode: "add" | "edit", personId?: string, growthData?: server.GrowthData): GrowthFormData => ({
     selectedPersonId: personId || growthData?.personId?.toString() || "",
-    measurementType:
-      mode === "edit" && growthData?.measurementType === server.Height
-        ? "height"
-        : mode === "edit"
-          ? "weight"
-          : "height",
+    measurementType: mode === "edit" ? measurementTypeKey(growthData?.measurementType) : "height",
     value: growthData?.value?.toString() || "",
     unit: growthData?.unit || "in",
     heightInputMode: "decimal",
@@ -167,7 +180,7 @@ async function onSubmitGrowth(
 
 function onMeasurementTypeChange(form: GrowthFormData, newType: string) {
   form.measurementType = newType;
-  form.unit = newType === "height" ? "in" : "lbs";
+  form.unit = newType === "height" ? "in" : newType === "weight" ? "lbs" : "cm";
   form.heightInputMode = "decimal";
   form.value = "";
   form.feet = "";
@@ -213,6 +226,11 @@ export const GrowthForm = ({
         { value: "in", label: "inches" },
         { value: "cm", label: "cm" },
       ];
+    } else if (form.measurementType === "head_circumference") {
+      return [
+        { value: "cm", label: "cm" },
+        { value: "in", label: "inches" },
+      ];
     } else {
       return [{ value: "lbs", label: "lbs" }];
     }
@@ -229,7 +247,7 @@ export const GrowthForm = ({
           <h1>{mode === "add" ? "Measure Now" : "Edit Growth Measurement"}</h1>
           <p>
             {mode === "add"
-              ? "Track height or weight progress for your family"
+              ? "Track height, weight or head circumference for your family"
               : "Update this growth measurement record"}
           </p>
         </div>
@@ -302,6 +320,17 @@ export const GrowthForm = ({
                 />
                 <span>Weight</span>
               </label>
+              <label className="radio-option">
+                <input
+                  type="radio"
+                  name="measurementType"
+                  value="head_circumference"
+                  checked={form.measurementType === "head_circumference"}
+                  onChange={() => onMeasurementTypeChange(form, "head_circumference")}
+                  disabled={form.loading}
+                />
+                <span>Head circumference</span>
+              </label>
             </div>
           </fieldset>
 
@@ -345,7 +374,7 @@ export const GrowthForm = ({
             <div className="form-row">
               <div className="form-group flex-2">
                 <label htmlFor="value">
-                  {form.measurementType === "height" ? "Height" : "Weight"}
+                  {measurementTypeLabels[form.measurementType]}
                 </label>
                 <input
                   id="value"
@@ -353,7 +382,13 @@ export const GrowthForm = ({
                   inputmode="decimal"
                   pattern="[0-9]*\.?[0-9]*"
                   {...vlens.attrsBindInput(vlens.ref(form, "value"))}
-                  placeholder={form.measurementType === "height" ? "67.50" : "45.25"}
+                  placeholder={
+                    form.measurementType === "height"
+                      ? "67.50"
+                      : form.measurementType === "weight"
+                        ? "45.25"
+                        : "42.0"
+                  }
                   required
                   disabled={form.loading}
                 />
@@ -531,7 +566,7 @@ export const GrowthForm = ({
               {mode === "add" && <strong>{selectedPerson.name}</strong>}
               {mode === "add" && " - "}
               {mode === "edit" && "Updated "}
-              {form.measurementType}:{" "}
+              {measurementTypeLabels[form.measurementType].toLowerCase()}:{" "}
               {form.measurementType === "height" &&
               form.unit === "in" &&
               form.heightInputMode === "feet-inches" ? (
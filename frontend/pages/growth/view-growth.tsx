@@ -7,11 +7,13 @@ import { getIdFromRoute, splitPeopleByType } from "../../lib/routeHelpers";
 import { formatDate } from "../../lib/dateUtils";
 import { ErrorPage } from "../../components/ErrorPage";
 import { handleDeleteGrowthData } from "../../lib/timelineHelpers";
+import { getMeasurementTypeLabel } from "../../lib/milestoneHelpers";
 import {
   ageInMonths,
   computePercentileLabel,
   formatAgeAtMeasurement,
   isValidBirthday,
+  percentileTypeFor,
 } from "../../lib/growthPercentiles";
 import {
   computeFamilyComparisons,
@@ -81,21 +83,30 @@ interface ViewGrowthPageProps {
   familyMembers: server.FamilyTimelineItem[];
 }
 
-const getMeasurementTypeLabel = (type: server.MeasurementType) =>
-  type === server.Height ? "Height" : "Weight";
+const getMeasurementTypeIcon = (type: server.MeasurementType) => {
+  switch (type) {
+    case server.Height:
+      return "📏";
+    case server.HeadCircumference:
+      return "📐";
+    default:
+      return "⚖️";
+  }
+};
 
 const ViewGrowthPage = ({ growthData, person, familyMembers }: ViewGrowthPageProps) => {
   const hasBirthday = isValidBirthday(person.birthday);
   const ageMonths = hasBirthday ? ageInMonths(person.birthday, growthData.measurementDate) : null;
   const ageLabel = ageMonths !== null ? formatAgeAtMeasurement(ageMonths) : null;
+  const percentileType = percentileTypeFor(growthData.measurementType);
   const percentileLabel =
-    ageMonths !== null && ageMonths <= 240
+    ageMonths !== null && ageMonths <= 240 && percentileType
       ? computePercentileLabel(
           growthData.value,
           growthData.unit,
           ageMonths,
           person.gender,
-          growthData.measurementType === server.Height ? "height" : "weight"
+          percentileType
         )
       : null;
 
@@ -120,7 +131,7 @@ const ViewGrowthPage = ({ growthData, person, familyMembers }: ViewGrowthPagePro
 
       <div className="growth-detail-card">
         <div className="growth-detail-icon">
-          {growthData.measurementType === server.Height ? "📏" : "⚖️"}
+          {getMeasurementTypeIcon(growthData.measurementType)}
         </div>
         <div className="growth-detail-main">
           <div className="growth-detail-type">
@@ -1,11 +1,13 @@
 import * as preact from "preact";
 import * as server from "../../../server";
 import { GrowthChart } from "../../../components/chart/chart";
+import { getMeasurementTypeLabel } from "../../../lib/milestoneHelpers";
 import {
   ageInMonths,
   computePercentileLabel,
   formatAgeAtMeasurement,
   isValidBirthday,
+  percentileTypeFor,
 } from "../../../lib/growthPercentiles";
 
 interface GrowthTabProps {
@@ -31,7 +33,7 @@ const handleDeleteGrowthData = async (
   value: number,
   unit: string
 ) => {
-  const typeLabel = type === server.Height ? "Height" : "Weight";
+  const typeLabel = getMeasurementTypeLabel(type);
   const confirmed = confirm(
     `Are you sure you want to delete this ${typeLabel.toLowerCase()} measurement of ${value} ${unit}?`
   );
@@ -53,10 +55,6 @@ const handleDeleteGrowthData = async (
 };
 
 export const GrowthTab = ({ person, growthData }: GrowthTabProps) => {
-  const getMeasurementTypeLabel = (type: server.MeasurementType) => {
-    return type === server.Height ? "Height" : "Weight";
-  };
-
   // Sort growth data by measurement date (newest first) for table
   const sortedGrowthData = (growthData || [])
     .slice()
@@ -74,7 +72,8 @@ export const GrowthTab = ({ person, growthData }: GrowthTabProps) => {
     if (!hasBirthday) return null;
     const months = ageInMonths(person.birthday, record.measurementDate);
     if (months > 240) return null;
-    const type = record.measurementType === server.Height ? "height" : "weight";
+    const type = percentileTypeFor(record.measurementType);
+    if (!type) return null;
     return computePercentileLabel(record.value, record.unit, months, person.gender, type);
   };
 
@@ -19,6 +19,7 @@ import {
   ageInMonths,
   computePercentileLabel,
   isValidBirthday,
+  percentileTypeFor,
 } from "../../../lib/growthPercentiles";
 import "./timeline-styles";
 
@@ -341,15 +342,17 @@ export const UnifiedTimeline = ({
 
               case "measurement": {
                 const measurement = item.data as server.GrowthData;
-                const pctLabel = isValidBirthday(person.birthday)
-                  ? computePercentileLabel(
-                      measurement.value,
-                      measurement.unit,
-                      ageInMonths(person.birthday, measurement.measurementDate),
-                      person.gender,
-                      measurement.measurementType === server.Height ? "height" : "weight"
-                    )
-                  : null;
+                const pctType = percentileTypeFor(measurement.measurementType);
+                const pctLabel =
+                  isValidBirthday(person.birthday) && pctType
+                    ? computePercentileLabel(
+                        measurement.value,
+                        measurement.unit,
+                        ageInMonths(person.birthday, measurement.measurementDate),
+                        person.gender,
+                        pctType
+                      )
+                    : null;
                 return (
                   <div key={`measurement-${item.id}`} className="timeline-item measurement-item">
                     <div className="timeline-item-icon">📏</div>
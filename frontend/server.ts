@@ -23,6 +23,7 @@ export const Unknown: GenderType = 2;
 export type MeasurementType = number;
 export const Height: MeasurementType = 0;
 export const Weight: MeasurementType = 1;
+export const HeadCircumference: MeasurementType = 2;
 
 // Errors
 export const ErrCannotRemoveHomeRoster = "Cannot remove a person from their home family";
@@ -39,6 +39,7 @@ func StartLocalServer() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 	go backend.RunTokenCleanup(ctx, app.DB)
+	go backend.RunMemoriesDigest(ctx, app.DB)
 	if err := family.RunHTTPServer(ctx, appServer); err != nil {
 		// The dev server's exit status is what `make local` reports, so a
 		// listener that could not start should not look like a clean stop.
@@ -56,5 +56,6 @@ func run() error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 	go backend.RunTokenCleanup(ctx, app.DB)
+	go backend.RunMemoriesDigest(ctx, app.DB)
 	return family.RunHTTPServer(ctx, appServer)
 }